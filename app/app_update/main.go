@@ -0,0 +1,191 @@
+// app_update installs a signer binary pushed from the host over USB. The
+// gadget stages the upload on the data partition (see package appupdate);
+// before app_verify runs on the next boot this helper checks it again,
+// writes it to /app/tezsign, records its hash for the booted slot on the
+// boot partition and empties the staging area. A staging that fails the
+// checks is dropped and the installed binary keeps running.
+//
+// The hash it records is what app_verify checks, so the helper trusts
+// nothing the gadget or the host wrote: the staged binary must carry a
+// signature by the release key of the image (appupdate.KeyFile on the app
+// partition), checked here again. The signature names the release and build
+// date of the binary; they replace the app partition's version and date
+// markers, so a later push cannot bring back an older signer.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/appupdate"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/logging"
+	"golang.org/x/sys/unix"
+)
+
+type updateConfig struct {
+	Staging string // appupdate.Dir under the signer's DATA_STORE
+	App     string // mount point of the app partition, with the release key
+	Device  string // boot partition
+	Mount   string // private mount point for the boot partition
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() *updateConfig {
+	return &updateConfig{
+		Staging: envOr("APP_UPDATE_DIR", filepath.Join("/data/tezsign", appupdate.Dir)),
+		App:     envOr("APP_UPDATE_APP", "/app"),
+		Device:  envOr("APP_UPDATE_DEVICE", "/dev/disk/by-label/boot"),
+		Mount:   envOr("APP_UPDATE_MOUNT", "/run/tezsign-app-update"),
+	}
+}
+
+// writeFile replaces name with the contents of r through a temporary file,
+// so a power cut leaves the old or the new file.
+func writeFile(name string, r io.Reader, perm os.FileMode) error {
+	tmp := name + ".new"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	d, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// installBinary copies the staged binary onto the app partition, remounted
+// read-write for as long as it takes, followed by its version and date.
+func (c *updateConfig) installBinary(staged *appupdate.StagedBinary) error {
+	src, err := os.Open(staged.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := unix.Mount("", c.App, "", unix.MS_REMOUNT|unix.MS_NOATIME, ""); err != nil {
+		return fmt.Errorf("remount %s read-write: %w", c.App, err)
+	}
+	err = writeFile(filepath.Join(c.App, apphash.Binary), src, 0o755)
+	for _, marker := range []struct{ name, value string }{
+		{appupdate.VersionFile, staged.Version},
+		{appupdate.DateFile, staged.Date},
+	} {
+		if err != nil || marker.value == "" {
+			continue
+		}
+		err = writeFile(filepath.Join(c.App, marker.name), strings.NewReader(marker.value+"\n"), 0o444)
+	}
+	if rerr := unix.Mount("", c.App, "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOATIME, ""); rerr != nil && err == nil {
+		err = fmt.Errorf("remount %s read-only: %w", c.App, rerr)
+	}
+	return err
+}
+
+// installHash writes the booted slot's hash file on the boot partition.
+func (c *updateConfig) installHash(slot, sum string) error {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return err
+	}
+	var mountErr error
+	mounted := false
+	for _, fstype := range []string{"vfat", "ext4"} {
+		if mountErr = unix.Mount(c.Device, c.Mount, fstype, unix.MS_NOATIME|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); mountErr == nil {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return fmt.Errorf("mount %s: %w", c.Device, mountErr)
+	}
+	err := writeFile(filepath.Join(c.Mount, apphash.Path(slot)), strings.NewReader(string(apphash.Marshal(sum))), 0o644)
+	if uerr := unix.Unmount(c.Mount, 0); uerr != nil && err == nil {
+		err = fmt.Errorf("unmount %s: %w", c.Mount, uerr)
+	}
+	return err
+}
+
+func (c *updateConfig) apply(l *slog.Logger) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	machine, err := appupdate.Machine(exe)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(c.Staging, appupdate.Binary)); errors.Is(err, os.ErrNotExist) {
+		l.Debug("no app update staged", "dir", c.Staging)
+		return nil
+	}
+	trust, err := appupdate.LoadTrust(c.App)
+	if err != nil {
+		l.Error("cannot check the staged app update; dropping it", "err", err)
+		return appupdate.Clear(c.Staging)
+	}
+	staged, err := appupdate.Staged(c.Staging, trust, machine)
+	if errors.Is(err, os.ErrNotExist) {
+		l.Debug("no app update staged", "dir", c.Staging)
+		return nil
+	}
+	if err != nil {
+		l.Error("staged app update failed its checks; dropping it", "err", err)
+		return appupdate.Clear(c.Staging)
+	}
+
+	// plain images have no slot on the command line and one hash file
+	slot := ""
+	if cmdline, err := os.ReadFile("/proc/cmdline"); err == nil {
+		slot = bootslot.FromCmdline(string(cmdline))
+	}
+	// the binary goes first: until the hash follows, app_verify stops the
+	// signer, and the next boot installs the staging again
+	if err := c.installBinary(staged); err != nil {
+		return fmt.Errorf("install %s: %w", apphash.Binary, err)
+	}
+	if err := c.installHash(slot, staged.Sum); err != nil {
+		return fmt.Errorf("write %s: %w", apphash.Path(slot), err)
+	}
+	if err := appupdate.Clear(c.Staging); err != nil {
+		return err
+	}
+	l.Warn("app update installed", "binary", filepath.Join(c.App, apphash.Binary), "version", staged.Version, "date", staged.Date, "sha256", staged.Sum)
+	return nil
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	if err := loadConfig().apply(l); err != nil {
+		l.Error("app update failed", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+// app_verify checks the signer binary before tezsign.service starts. It
+// mounts the boot partition read-only, reads the hash the image recorded
+// for /app/tezsign (the booted slot's on A/B images) and exits non-zero on
+// a mismatch, a missing hash file or an unreadable binary. tezsign.service
+// requires it, so the signer does not start.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/logging"
+	"golang.org/x/sys/unix"
+)
+
+type verifyConfig struct {
+	Device string // boot partition
+	Mount  string // private mount point for the boot partition
+	Binary string // the signer on the mounted app partition
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() *verifyConfig {
+	return &verifyConfig{
+		Device: envOr("APP_VERIFY_DEVICE", "/dev/disk/by-label/boot"),
+		Mount:  envOr("APP_VERIFY_MOUNT", "/run/tezsign-app-verify"),
+		Binary: envOr("APP_VERIFY_BINARY", filepath.Join("/app", apphash.Binary)),
+	}
+}
+
+// expected reads the hash file from the boot partition, mounted read-only
+// for as long as it takes.
+func (c *verifyConfig) expected(slot string) ([]byte, error) {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return nil, err
+	}
+	var mountErr error
+	mounted := false
+	for _, fstype := range []string{"vfat", "ext4"} {
+		if mountErr = unix.Mount(c.Device, c.Mount, fstype, unix.MS_RDONLY|unix.MS_NOATIME|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); mountErr == nil {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return nil, fmt.Errorf("mount %s: %w", c.Device, mountErr)
+	}
+	data, err := os.ReadFile(filepath.Join(c.Mount, apphash.Path(slot)))
+	if uerr := unix.Unmount(c.Mount, 0); uerr != nil && err == nil {
+		err = fmt.Errorf("unmount %s: %w", c.Mount, uerr)
+	}
+	return data, err
+}
+
+func (c *verifyConfig) verify(l *slog.Logger) error {
+	// plain images have no slot on the command line and one hash file
+	slot := ""
+	if cmdline, err := os.ReadFile("/proc/cmdline"); err == nil {
+		slot = bootslot.FromCmdline(string(cmdline))
+	}
+	want, err := c.expected(slot)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", apphash.Path(slot), err)
+	}
+	f, err := os.Open(c.Binary)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := apphash.Check(want, f); err != nil {
+		return err
+	}
+	l.Info("app binary verified", "binary", c.Binary, "hash", apphash.Path(slot))
+	return nil
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	if err := loadConfig().verify(l); err != nil {
+		l.Error("app binary check failed; tezsign will not start", "err", err)
+		os.Exit(1)
+	}
+}
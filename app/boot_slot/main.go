@@ -0,0 +1,193 @@
+// boot_slot drives A/B updates on slotted images. "arm" runs before the
+// signer: when the booted slot is on trial it points the boot loader back at
+// the committed slot, so a hang, reboot or power cut before "commit" falls
+// back on the next boot. "commit" waits until the signer has stayed up and
+// makes the trial slot the committed one; if the signer does not come up it
+// reboots into the committed slot.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/logging"
+	"golang.org/x/sys/unix"
+)
+
+type slotConfig struct {
+	Device        string // boot partition
+	Mount         string // private mount point for the boot partition
+	HealthUnit    string // unit that must be up before a trial slot is committed
+	HealthTimeout time.Duration
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() (*slotConfig, error) {
+	seconds, err := strconv.Atoi(envOr("BOOT_SLOT_HEALTH_SECONDS", "90"))
+	if err != nil || seconds < 10 {
+		return nil, fmt.Errorf("BOOT_SLOT_HEALTH_SECONDS must be a number >= 10")
+	}
+	return &slotConfig{
+		Device:        envOr("BOOT_SLOT_DEVICE", "/dev/disk/by-label/boot"),
+		Mount:         envOr("BOOT_SLOT_MOUNT", "/run/tezsign-boot"),
+		HealthUnit:    envOr("BOOT_SLOT_HEALTH_UNIT", "tezsign.service"),
+		HealthTimeout: time.Duration(seconds) * time.Second,
+	}, nil
+}
+
+func bootedSlot() (string, error) {
+	cmdline, err := os.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
+	slot := bootslot.FromCmdline(string(cmdline))
+	if slot == "" {
+		return "", fmt.Errorf("%w: no %s= on the kernel command line", bootslot.ErrInvalidSlot, bootslot.CmdlineKey)
+	}
+	return slot, nil
+}
+
+// withBoot mounts the boot partition read-write for the duration of fn.
+func (c *slotConfig) withBoot(fn func(dir string) error) error {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return err
+	}
+	var mountErr error
+	mounted := false
+	for _, fstype := range []string{"vfat", "ext4"} {
+		if mountErr = unix.Mount(c.Device, c.Mount, fstype, unix.MS_NOATIME|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); mountErr == nil {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return fmt.Errorf("mount %s: %w", c.Device, mountErr)
+	}
+
+	err := fn(c.Mount)
+	unix.Sync()
+	if uerr := unix.Unmount(c.Mount, 0); uerr != nil && err == nil {
+		err = fmt.Errorf("unmount %s: %w", c.Mount, uerr)
+	}
+	return err
+}
+
+func (c *slotConfig) arm(l *slog.Logger) error {
+	slot, err := bootedSlot()
+	if err != nil {
+		return err
+	}
+	return c.withBoot(func(dir string) error {
+		state, err := bootslot.ReadState(dir)
+		if err != nil {
+			return err
+		}
+		switch {
+		case state.Trial == slot:
+			// Until commit, any reboot lands on the committed slot.
+			if err := bootslot.SetSelector(dir, state.Active); err != nil {
+				return err
+			}
+			l.Info("trial boot armed", "slot", slot, "fallback", state.Active)
+		case state.Trial != "":
+			// The trial slot was selected but we came up on the other one:
+			// it never reached commit and the boot loader fell back.
+			l.Warn("trial slot failed; staying on committed slot", "trial", state.Trial, "slot", slot)
+			return bootslot.WriteState(dir, bootslot.State{Active: state.Active})
+		case state.Active != slot:
+			l.Warn("booted slot is not the committed one", "slot", slot, "active", state.Active)
+		}
+		return nil
+	})
+}
+
+// healthy reports whether unit is active and has not been restarted.
+func (c *slotConfig) healthy() (bool, error) {
+	out, err := exec.Command("systemctl", "show", "--property=ActiveState,NRestarts", c.HealthUnit).Output()
+	if err != nil {
+		return false, err
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			props[k] = v
+		}
+	}
+	return props["ActiveState"] == "active" && props["NRestarts"] == "0", nil
+}
+
+func (c *slotConfig) commit(l *slog.Logger) error {
+	slot, err := bootedSlot()
+	if err != nil {
+		return err
+	}
+
+	var state bootslot.State
+	if err := c.withBoot(func(dir string) error {
+		state, err = bootslot.ReadState(dir)
+		return err
+	}); err != nil {
+		return err
+	}
+	if state.Trial != slot {
+		return nil
+	}
+
+	time.Sleep(c.HealthTimeout)
+	ok, err := c.healthy()
+	if err != nil || !ok {
+		l.Error("trial slot unhealthy; rebooting into committed slot", "slot", slot, "fallback", state.Active, "unit", c.HealthUnit, "err", err)
+		return exec.Command("systemctl", "reboot").Run()
+	}
+
+	return c.withBoot(func(dir string) error {
+		if err := bootslot.SetSelector(dir, slot); err != nil {
+			return err
+		}
+		if err := bootslot.WriteState(dir, bootslot.State{Active: slot}); err != nil {
+			return err
+		}
+		l.Info("trial slot committed", "slot", slot, "previous", state.Active)
+		return nil
+	})
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		l.Error("boot slot config", "err", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) != 2 {
+		err = errors.New("usage: boot_slot arm|commit")
+	} else {
+		switch os.Args[1] {
+		case "arm":
+			err = cfg.arm(l)
+		case "commit":
+			err = cfg.commit(l)
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+	}
+	if err != nil {
+		l.Error("boot slot", "err", err)
+		os.Exit(1)
+	}
+}
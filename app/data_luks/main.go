@@ -0,0 +1,267 @@
+// data_luks opens the LUKS2 data partition before /data is mounted. The image
+// ships the data partition as the empty ext4 that wic made; on the first boot
+// it is formatted as LUKS2 and gets a fresh filesystem. The key is never
+// stored: it is derived from the board's serial number on every boot. The
+// serial is not a secret (anything on the board can read it, and it may be
+// printed on the board) and can have as little as 32 bits of entropy, so
+// the Argon2id keyslot only makes guessing it from a lone card slow.
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/secure"
+	"golang.org/x/sys/unix"
+)
+
+// keyContext separates this key from anything else derived from the serial.
+const keyContext = "tezsign data partition v1"
+
+type luksConfig struct {
+	Device string // the LUKS2 data partition, by its LUKS label
+	Plain  string // the data partition as the image ships it
+	Label  string // LUKS2 label given at format time
+	Mapper string // /dev/mapper name that fstab mounts
+	Serial string // file holding the board serial number
+	Cipher string
+	Wait   time.Duration
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() (*luksConfig, error) {
+	seconds, err := strconv.Atoi(envOr("DATA_LUKS_WAIT_SECONDS", "30"))
+	if err != nil || seconds < 1 {
+		return nil, fmt.Errorf("DATA_LUKS_WAIT_SECONDS must be a number >= 1")
+	}
+	label := envOr("DATA_LUKS_LABEL", "data-luks")
+	return &luksConfig{
+		Device: filepath.Join("/dev/disk/by-label", label),
+		Plain:  envOr("DATA_LUKS_PLAIN", "/dev/disk/by-label/data"),
+		Label:  label,
+		Mapper: envOr("DATA_LUKS_MAPPER", "data-luks"),
+		Serial: envOr("DATA_LUKS_SERIAL", "/sys/firmware/devicetree/base/serial-number"),
+		Cipher: envOr("DATA_LUKS_CIPHER", "aes-xts-plain64"),
+		Wait:   time.Duration(seconds) * time.Second,
+	}, nil
+}
+
+func (c *luksConfig) mapperDevice() string {
+	return filepath.Join("/dev/mapper", c.Mapper)
+}
+
+// key is HMAC-SHA256(serial, keyContext). Boards without a serial number in
+// the device tree cannot use an encrypted data partition.
+func (c *luksConfig) key() ([]byte, error) {
+	serial, err := os.ReadFile(c.Serial)
+	if err != nil {
+		return nil, fmt.Errorf("board serial number: %w", err)
+	}
+	serial = bytes.TrimRight(serial, "\x00\n ")
+	if len(serial) == 0 {
+		return nil, fmt.Errorf("board serial number in %s is empty", c.Serial)
+	}
+	mac := hmac.New(sha256.New, serial)
+	mac.Write([]byte(keyContext))
+	return mac.Sum(nil), nil
+}
+
+// waitForPartition returns the LUKS2 partition, or the plain one on the
+// first boot, as soon as udev has created either link.
+func (c *luksConfig) waitForPartition() (dev string, luks bool, err error) {
+	deadline := time.Now().Add(c.Wait)
+	for {
+		if dev, err := filepath.EvalSymlinks(c.Device); err == nil {
+			return dev, true, nil
+		}
+		if dev, err := filepath.EvalSymlinks(c.Plain); err == nil {
+			return dev, false, nil
+		}
+		if time.Now().After(deadline) {
+			return "", false, fmt.Errorf("neither %s nor %s appeared within %s", c.Device, c.Plain, c.Wait)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func (c *luksConfig) open(l *slog.Logger) error {
+	if _, err := os.Stat(c.mapperDevice()); err == nil {
+		return nil
+	}
+
+	dev, luks, err := c.waitForPartition()
+	if err != nil {
+		return err
+	}
+	key, err := c.key()
+	if err != nil {
+		return err
+	}
+	defer secure.MemoryWipe(key)
+
+	if luks {
+		if err := runWithKey(key, "cryptsetup", "open", "--type", "luks2", "--key-file", "-", dev, c.Mapper); err != nil {
+			return err
+		}
+		c.upgradeKeyslot(dev, key, l)
+		return nil
+	}
+	return c.provision(dev, key, l)
+}
+
+// upgradeKeyslot moves a keyslot formatted with PBKDF2 by older images to
+// Argon2id. A failure leaves the old keyslot in place, and the next boot
+// tries again.
+func (c *luksConfig) upgradeKeyslot(dev string, key []byte, l *slog.Logger) {
+	out, err := exec.Command("cryptsetup", "luksDump", "--dump-json-metadata", dev).Output()
+	if err != nil {
+		l.Warn("read data partition header", "device", dev, "err", err)
+		return
+	}
+	var header struct {
+		Keyslots map[string]struct {
+			KDF struct {
+				Type string `json:"type"`
+			} `json:"kdf"`
+		} `json:"keyslots"`
+	}
+	if err := json.Unmarshal(out, &header); err != nil {
+		l.Warn("read data partition header", "device", dev, "err", err)
+		return
+	}
+	for _, slot := range header.Keyslots {
+		if slot.KDF.Type != "pbkdf2" {
+			continue
+		}
+		l.Info("converting data partition keyslot to argon2id", "device", dev)
+		if err := runWithKey(key, "cryptsetup", "luksConvertKey", "--batch-mode", "--pbkdf", "argon2id", "--key-file", "-", dev); err != nil {
+			l.Warn("convert data partition keyslot", "device", dev, "err", err)
+		}
+		return
+	}
+}
+
+// provision turns the image's empty data partition into LUKS2. A partition
+// that already holds files is left alone: it is a plaintext store and
+// formatting it would destroy the keys.
+func (c *luksConfig) provision(dev string, key []byte, l *slog.Logger) error {
+	empty, err := isEmptyExt4(dev)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("%s holds a plaintext data partition; back it up and reflash to encrypt it", dev)
+	}
+
+	l.Info("formatting data partition as LUKS2", "device", dev)
+	// The serial is low entropy, so the keyslot gets cryptsetup's default
+	// Argon2id cost (it benchmarks the board and caps memory by its RAM).
+	err = runWithKey(key, "cryptsetup", "luksFormat",
+		"--type", "luks2",
+		"--batch-mode",
+		"--cipher", c.Cipher,
+		"--key-size", "512",
+		"--pbkdf", "argon2id",
+		"--label", c.Label,
+		"--key-file", "-",
+		dev,
+	)
+	if err == nil {
+		err = runWithKey(key, "cryptsetup", "open", "--type", "luks2", "--key-file", "-", dev, c.Mapper)
+	}
+	if err == nil {
+		err = mkfsData(c.mapperDevice())
+	}
+	if err != nil {
+		// put the plain filesystem back so the next boot provisions from scratch
+		_ = run("cryptsetup", "close", c.Mapper)
+		_ = mkfsData(dev)
+		return err
+	}
+	l.Info("data partition encrypted", "device", dev, "mapper", c.mapperDevice())
+	return nil
+}
+
+// mkfsData uses the options wic uses for the plain data partition.
+func mkfsData(dev string) error {
+	return run("mke2fs", "-F", "-t", "ext4", "-q", "-L", "data", "-I", "1024", "-J", "size=8", "-m", "0",
+		"-O", "has_journal,extents,sparse_super,metadata_csum,inline_data,fast_commit", dev)
+}
+
+func isEmptyExt4(dev string) (bool, error) {
+	dir, err := os.MkdirTemp("/run", "data-luks-probe-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(dir)
+
+	if err := unix.Mount(dev, dir, "ext4", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return false, fmt.Errorf("mount %s: %w", dev, err)
+	}
+	defer unix.Unmount(dir, 0)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.Name() != "lost+found" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func run(name string, args ...string) error {
+	return runWithKey(nil, name, args...)
+}
+
+// runWithKey runs a tool with key on stdin, so the key never shows up in argv
+// or on disk.
+func runWithKey(key []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if key != nil {
+		cmd.Stdin = bytes.NewReader(key)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return fmt.Errorf("%s %s: %w", name, args[0], err)
+		}
+		return fmt.Errorf("%s %s: %w: %s", name, args[0], err, msg)
+	}
+	return nil
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		l.Error("data luks config", "err", err)
+		os.Exit(1)
+	}
+	if err := cfg.open(l); err != nil {
+		l.Error("data luks", "err", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,118 @@
+// data_vault is the privileged half of the encrypted keystore volume: it opens,
+// formats and mounts a LUKS2 container on behalf of the unprivileged gadget,
+// which supplies the volume key derived from the master passphrase.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/secure"
+)
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() (*vaultConfig, error) {
+	sizeMiB, err := strconv.Atoi(envOr("VAULT_SIZE_MIB", "64"))
+	if err != nil || sizeMiB < 32 {
+		return nil, fmt.Errorf("VAULT_SIZE_MIB must be a number >= 32")
+	}
+
+	owner, err := user.Lookup(envOr("VAULT_USER", "tezsign"))
+	if err != nil {
+		return nil, err
+	}
+	uid, _ := strconv.Atoi(owner.Uid)
+	gid, _ := strconv.Atoi(owner.Gid)
+
+	return &vaultConfig{
+		File:    envOr("VAULT_FILE", "/data/tezsign/vault.img"),
+		Mount:   envOr("VAULT_MOUNT", "/data/tezsign/keystore"),
+		Mapper:  envOr("VAULT_MAPPER", "tezsign-data"),
+		Cipher:  envOr("VAULT_CIPHER", "aes-xts-plain64"),
+		SizeMiB: sizeMiB,
+		UID:     uid,
+		GID:     gid,
+	}, nil
+}
+
+func serve(cfg *vaultConfig, l *slog.Logger) error {
+	ln, err := common.ListenUnix(common.VaultSock, 0o660)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	// only the gadget's group may talk to us
+	if err := os.Chown(common.VaultSock, 0, cfg.GID); err != nil {
+		return err
+	}
+
+	l.Info("data vault helper online", "file", cfg.File, "mount", cfg.Mount)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			l.Error("vault socket accept", "err", err)
+			continue
+		}
+		handleConn(conn, cfg, l)
+	}
+}
+
+// handleConn serves a single request; requests are handled one at a time.
+func handleConn(conn *os.File, cfg *vaultConfig, l *slog.Logger) {
+	defer conn.Close()
+
+	op, key, err := common.ReadVaultFrame(conn)
+	if err != nil {
+		l.Warn("vault request read", "err", err)
+		return
+	}
+	defer secure.MemoryWipe(key)
+
+	var reply string
+	switch op {
+	case common.VaultOpStatus:
+		reply, err = cfg.state()
+	case common.VaultOpOpen:
+		err = cfg.open(key)
+		reply = common.VaultStateOpen
+	case common.VaultOpFormat:
+		err = cfg.format(key)
+		reply = common.VaultStateOpen
+	default:
+		err = errors.New("unknown vault op")
+	}
+
+	if err != nil {
+		l.Warn("vault request failed", "op", string(op), "err", err)
+		_ = common.WriteVaultFrame(conn, common.VaultReplyErr, []byte(err.Error()))
+		return
+	}
+	_ = common.WriteVaultFrame(conn, common.VaultReplyOK, []byte(reply))
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		l.Error("data vault config", "err", err)
+		os.Exit(1)
+	}
+	if err := serve(cfg, l); err != nil {
+		l.Error("data vault helper", "err", err)
+		os.Exit(1)
+	}
+}
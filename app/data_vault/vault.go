@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"golang.org/x/sys/unix"
+)
+
+type vaultConfig struct {
+	File    string // LUKS2 container on the data partition
+	Mount   string // where the opened volume is mounted (the gadget's keystore dir)
+	Mapper  string // /dev/mapper name
+	Cipher  string
+	SizeMiB int
+	UID     int
+	GID     int
+}
+
+var errVaultExists = errors.New("vault already exists")
+
+func (c *vaultConfig) device() string {
+	return filepath.Join("/dev/mapper", c.Mapper)
+}
+
+func (c *vaultConfig) state() (string, error) {
+	if _, err := os.Stat(c.File); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return common.VaultStateAbsent, nil
+		}
+		return "", err
+	}
+	mounted, err := isMountPoint(c.Mount)
+	if err != nil {
+		return "", err
+	}
+	if mounted {
+		return common.VaultStateOpen, nil
+	}
+	return common.VaultStateClosed, nil
+}
+
+func (c *vaultConfig) open(key []byte) error {
+	if mounted, err := isMountPoint(c.Mount); err != nil || mounted {
+		return err
+	}
+	if _, err := os.Stat(c.File); err != nil {
+		return err
+	}
+	if _, err := os.Stat(c.device()); errors.Is(err, os.ErrNotExist) {
+		// cryptsetup attaches a loop device for the container file itself
+		if err := runWithKey(key, "cryptsetup", "open", "--type", "luks2", "--key-file", "-", c.File, c.Mapper); err != nil {
+			return err
+		}
+	}
+	return c.mount()
+}
+
+func (c *vaultConfig) format(key []byte) error {
+	if _, err := os.Stat(c.File); err == nil {
+		return errVaultExists
+	}
+
+	if err := c.allocate(); err != nil {
+		if !errors.Is(err, errVaultExists) {
+			_ = os.Remove(c.File)
+		}
+		return err
+	}
+	err := runWithKey(key, "cryptsetup", "luksFormat",
+		"--type", "luks2",
+		"--batch-mode",
+		"--cipher", c.Cipher,
+		"--key-size", "512",
+		"--pbkdf", "argon2id",
+		"--pbkdf-memory", "65536",
+		"--iter-time", "1000",
+		"--label", "tezsign-data",
+		"--key-file", "-",
+		c.File,
+	)
+	if err == nil {
+		err = runWithKey(key, "cryptsetup", "open", "--type", "luks2", "--key-file", "-", c.File, c.Mapper)
+	}
+	if err == nil {
+		err = run("mke2fs", "-t", "ext4", "-q", "-m", "0", "-L", "tezsign-keys", c.device())
+	}
+	if err == nil {
+		err = c.mount()
+	}
+	if err != nil {
+		// leave nothing half made behind, so a retry starts clean
+		_ = unix.Unmount(c.Mount, 0)
+		_ = run("cryptsetup", "close", c.Mapper)
+		_ = os.Remove(c.File)
+		return err
+	}
+	return nil
+}
+
+func (c *vaultConfig) allocate() error {
+	if err := os.MkdirAll(filepath.Dir(c.File), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.File, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return errVaultExists
+	}
+	if err != nil {
+		return err
+	}
+	// reserve the blocks now so the volume cannot run out of space later
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, int64(c.SizeMiB)<<20); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("allocate %s: %w", c.File, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *vaultConfig) mount() error {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_NOATIME | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	if err := unix.Mount(c.device(), c.Mount, "ext4", flags, "errors=remount-ro,commit=5"); err != nil {
+		return fmt.Errorf("mount %s: %w", c.Mount, err)
+	}
+	// the volume root belongs to the gadget user
+	if err := os.Chown(c.Mount, c.UID, c.GID); err != nil {
+		return err
+	}
+	return os.Chmod(c.Mount, 0o700)
+}
+
+func isMountPoint(path string) (bool, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	clean := filepath.Clean(path)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// id parent major:minor root mountpoint ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) > 4 && fields[4] == clean {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
+
+func run(name string, args ...string) error {
+	return runWithKey(nil, name, args...)
+}
+
+// runWithKey runs a tool with key on stdin, so the key never shows up in argv
+// or on disk.
+func runWithKey(key []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if key != nil {
+		cmd.Stdin = bytes.NewReader(key)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return fmt.Errorf("%s %s: %w", name, args[0], err)
+		}
+		return fmt.Errorf("%s %s: %w: %s", name, args[0], err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/signerpb"
+	"golang.org/x/sys/unix"
+)
+
+var errClockBeforeBuild = errors.New("time is before the image build date")
+
+// clockSynced is set once a host has pushed its clock since boot.
+var clockSynced atomic.Bool
+
+// settime sets the system clock; tests swap it out. The service needs
+// CAP_SYS_TIME for it.
+var settime = func(t time.Time) error {
+	ts := unix.NsecToTimespec(t.UnixNano())
+	return unix.ClockSettime(unix.CLOCK_REALTIME, &ts)
+}
+
+// setClock moves the clock forward to t. It never goes back: key validity
+// windows are judged by this clock, and a host should not be able to reopen
+// one that has closed. Times before the image was built are a broken host
+// clock, not a reason to sign.
+func setClock(t time.Time) (time.Time, error) {
+	if built, err := time.Parse(time.RFC3339, readTrim(common.ImageBuildDateFile)); err == nil && t.Before(built) {
+		return time.Now(), errClockBeforeBuild
+	}
+	if now := time.Now(); !t.After(now) {
+		clockSynced.Store(true)
+		return now, nil
+	}
+	if err := settime(t); err != nil {
+		return time.Now(), err
+	}
+	clockSynced.Store(true)
+	return time.Now(), nil
+}
+
+func timeResponse(now time.Time) *signerpb.TimeResponse {
+	return &signerpb.TimeResponse{UnixMs: now.UnixMilli(), Synced: clockSynced.Load()}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetClockOnlyMovesForward(t *testing.T) {
+	prevSet, prevDate := settime, common.ImageBuildDateFile
+	t.Cleanup(func() {
+		settime, common.ImageBuildDateFile = prevSet, prevDate
+		clockSynced.Store(false)
+	})
+
+	var set []time.Time
+	settime = func(t time.Time) error {
+		set = append(set, t)
+		return nil
+	}
+	common.ImageBuildDateFile = filepath.Join(t.TempDir(), ".image-date")
+	if err := os.WriteFile(common.ImageBuildDateFile, []byte("2024-01-02T03:04:05Z\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := setClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, errClockBeforeBuild) {
+		t.Fatalf("expected errClockBeforeBuild, got %v", err)
+	}
+	if clockSynced.Load() {
+		t.Fatalf("rejected time marked the clock synced")
+	}
+
+	if _, err := setClock(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("earlier time: %v", err)
+	}
+	if len(set) != 0 {
+		t.Fatalf("clock moved back to %v", set)
+	}
+	if !clockSynced.Load() {
+		t.Fatalf("clock already ahead of the host should count as synced")
+	}
+
+	ahead := time.Now().Add(time.Hour)
+	if _, err := setClock(ahead); err != nil {
+		t.Fatalf("later time: %v", err)
+	}
+	if len(set) != 1 || !set[0].Equal(ahead) {
+		t.Fatalf("clock not moved forward: %v", set)
+	}
+}
+
+func TestSetTimeIsManagementOnly(t *testing.T) {
+	reached := false
+	h := handleSignAndStatus(func(context.Context, []byte) ([]byte, error) {
+		reached = true
+		return nil, nil
+	})
+	for _, req := range []*signerpb.Request{
+		{Payload: &signerpb.Request_SetTime{SetTime: &signerpb.SetTimeRequest{UnixMs: time.Now().Add(time.Hour).UnixMilli()}}},
+		{Payload: &signerpb.Request_GetTime{GetTime: &signerpb.GetTimeRequest{}}},
+	} {
+		reached = false
+		payload, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+		_, isSet := req.Payload.(*signerpb.Request_SetTime)
+		if reached == isSet {
+			t.Fatalf("%T reached the handler on IF0: %v", req.Payload, reached)
+		}
+	}
+}
@@ -5,9 +5,14 @@ var (
 
 	EnabledSock = "/tmp/tezsign.enabled"
 	ReadySock   = "/tmp/tezsign.ready"
+	VaultSock   = "/tmp/tezsign.vault"
 
 	AppMountPoint = "/app"
 
+	HwRngDevice = "/dev/hwrng"
+
 	ImageVersionFile   = AppMountPoint + "/.image-version"
 	ImageBuildDateFile = AppMountPoint + "/.image-date"
+	ImageFlavourFile   = AppMountPoint + "/.image-flavour"
+	DeviceIDFile       = AppMountPoint + "/tezsign_id"
 )
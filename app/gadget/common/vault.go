@@ -0,0 +1,57 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Data vault protocol between the gadget and the privileged data_vault
+// helper: one request and one reply per connection, both framed as
+// [op/status u8][len u16][body].
+const (
+	VaultOpStatus byte = 'S'
+	VaultOpOpen   byte = 'O'
+	VaultOpFormat byte = 'F' // create, format and open a fresh vault
+
+	VaultReplyOK  byte = 0
+	VaultReplyErr byte = 1
+
+	// status reply bodies
+	VaultStateAbsent = "absent"
+	VaultStateClosed = "closed"
+	VaultStateOpen   = "open"
+
+	vaultMaxFrame = 4 * 1024
+)
+
+var ErrVaultFrameTooLarge = errors.New("vault frame too large")
+
+func WriteVaultFrame(w io.Writer, kind byte, body []byte) error {
+	if len(body) > vaultMaxFrame {
+		return ErrVaultFrameTooLarge
+	}
+	buf := make([]byte, 3+len(body))
+	buf[0] = kind
+	binary.BigEndian.PutUint16(buf[1:3], uint16(len(body)))
+	copy(buf[3:], body)
+	_, err := w.Write(buf)
+	clear(buf)
+	return err
+}
+
+func ReadVaultFrame(r io.Reader) (kind byte, body []byte, err error) {
+	var hdr [3]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[1:3]))
+	if n > vaultMaxFrame {
+		return 0, nil, ErrVaultFrameTooLarge
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
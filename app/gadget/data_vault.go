@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/secure"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// The data vault is a LUKS2 volume mounted over the keystore directory by the
+// privileged data_vault helper. Its key comes from the master passphrase, so
+// the keystore stays sealed until the first request that carries it (usually
+// the host's unlock) and the card alone reveals nothing but ciphertext.
+const vaultKeyLabel = "tezsign/data-vault/v1"
+
+var errVaultAbsent = errors.New("data vault not created yet")
+
+var vaultOpenLimiter = newAttemptLimiter(securedAttemptLimit, securedAttemptWindow)
+
+type dataVault struct {
+	mu     sync.Mutex
+	fs     *keychain.FileStore
+	l      *slog.Logger
+	isOpen bool
+}
+
+// newDataVault returns nil when the keystore is not vault backed: vault
+// support off, or a plaintext keystore from before it was turned on.
+func newDataVault(enabled bool, fs *keychain.FileStore, l *slog.Logger) (*dataVault, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	v := &dataVault{fs: fs, l: l}
+	state, err := v.status()
+	if err != nil {
+		return nil, fmt.Errorf("data vault helper: %w", err)
+	}
+	switch state {
+	case common.VaultStateOpen:
+		// the gadget restarted; the helper kept the volume mounted
+		if err := fs.Reopen(); err != nil {
+			return nil, err
+		}
+		v.isOpen = true
+	case common.VaultStateAbsent:
+		if master, _, _ := fs.InitInfo(); master {
+			l.Warn("plaintext keystore present; data vault stays disabled for it")
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+func vaultKey(pass []byte) []byte {
+	mac := hmac.New(sha256.New, pass)
+	mac.Write([]byte(vaultKeyLabel))
+	return mac.Sum(nil)
+}
+
+func vaultRequest(op byte, body []byte) (string, error) {
+	conn, err := common.DialUnix(common.VaultSock)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := common.WriteVaultFrame(conn, op, body); err != nil {
+		return "", err
+	}
+	status, reply, err := common.ReadVaultFrame(conn)
+	if err != nil {
+		return "", err
+	}
+	if status != common.VaultReplyOK {
+		return "", errors.New(string(reply))
+	}
+	return string(reply), nil
+}
+
+func (v *dataVault) status() (string, error) {
+	return vaultRequest(common.VaultOpStatus, nil)
+}
+
+func (v *dataVault) opened() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.isOpen
+}
+
+// open opens the vault with pass, creating it first when create is set and
+// there is none yet. It returns errVaultAbsent for a device without a vault.
+func (v *dataVault) open(pass []byte, create bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.isOpen {
+		return nil
+	}
+	state, err := v.status()
+	if err != nil {
+		return err
+	}
+
+	op := common.VaultOpOpen
+	switch state {
+	case common.VaultStateAbsent:
+		if !create {
+			return errVaultAbsent
+		}
+		op = common.VaultOpFormat
+	case common.VaultStateClosed:
+		if ok, wait := vaultOpenLimiter.Allow(); !ok {
+			return fmt.Errorf("data vault throttled: retry in ~%s", wait.Round(time.Second))
+		}
+	}
+
+	if state != common.VaultStateOpen {
+		key := vaultKey(pass)
+		_, err := vaultRequest(op, key)
+		secure.MemoryWipe(key)
+		if err != nil {
+			return err
+		}
+	}
+	if err := v.fs.Reopen(); err != nil {
+		return err
+	}
+	v.isOpen = true
+	v.l.Info("data vault open")
+	return nil
+}
+
+// handleDataVault keeps keystore requests away from the store until the vault
+// is open, opening it with the first master passphrase that arrives.
+func handleDataVault(v *dataVault, base broker.Handler) broker.Handler {
+	if v == nil {
+		return base
+	}
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		if v.opened() {
+			return base(ctx, payload)
+		}
+
+		var req signerpb.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
+		}
+		defer wipeReq(&req)
+		defer secure.MemoryWipe(payload)
+
+		switch req.Payload.(type) {
+		case *signerpb.Request_Version, *signerpb.Request_Logs, *signerpb.Request_SetLogLevel, *signerpb.Request_GetEntropy,
+			*signerpb.Request_SetTime, *signerpb.Request_GetTime:
+			return base(ctx, payload)
+		case *signerpb.Request_DeviceInfo:
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_DeviceInfo{DeviceInfo: deviceInfo(nil, nil)},
+			})
+		case *signerpb.Request_InitInfo:
+			state, err := v.status()
+			if err != nil {
+				return marshalErr(rpcDataVaultFailed, "data vault: "+err.Error()), nil
+			}
+			if state == common.VaultStateAbsent {
+				return base(ctx, payload)
+			}
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_InitInfo{
+					InitInfo: &signerpb.InitInfoResponse{MasterPresent: true, DataLocked: true},
+				},
+			})
+		}
+
+		pass := masterPassphrase(&req)
+		if len(pass) == 0 {
+			// nothing to open with; an uninitialized device behaves as usual
+			if state, err := v.status(); err == nil && state == common.VaultStateAbsent {
+				return base(ctx, payload)
+			}
+			if sr, ok := req.Payload.(*signerpb.Request_Sign); ok {
+				auditRefusedSign(v.l, sr.Sign, reasonDataLocked)
+			}
+			return marshalErr(rpcDataLocked, "data vault locked: unlock a key with the master passphrase first"), nil
+		}
+
+		_, isInit := req.Payload.(*signerpb.Request_InitMaster)
+		switch err := v.open(pass, isInit); {
+		case errors.Is(err, errVaultAbsent):
+			return base(ctx, payload)
+		case err != nil:
+			v.l.Warn("data vault open failed", slog.Any("err", err))
+			return marshalErr(rpcDataVaultFailed, "data vault: "+err.Error()), nil
+		}
+		return base(ctx, payload)
+	}
+}
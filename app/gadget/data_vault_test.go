@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeVaultHelper answers the data_vault protocol from memory.
+func fakeVaultHelper(t *testing.T, state string, pass []byte) {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "vault.sock")
+	prev := common.VaultSock
+	common.VaultSock = sock
+
+	ln, err := common.ListenUnix(sock, 0o600)
+	if err != nil {
+		t.Fatalf("ListenUnix: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+		common.VaultSock = prev
+	})
+
+	var mu sync.Mutex
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			op, body, err := common.ReadVaultFrame(conn)
+			if err == nil {
+				mu.Lock()
+				switch {
+				case op == common.VaultOpStatus:
+					_ = common.WriteVaultFrame(conn, common.VaultReplyOK, []byte(state))
+				case op == common.VaultOpOpen && bytes.Equal(body, vaultKey(pass)):
+					state = common.VaultStateOpen
+					_ = common.WriteVaultFrame(conn, common.VaultReplyOK, []byte(state))
+				default:
+					_ = common.WriteVaultFrame(conn, common.VaultReplyErr, []byte("no key available"))
+				}
+				mu.Unlock()
+			}
+			_ = conn.Close()
+		}
+	}()
+}
+
+func decodeResponse(t *testing.T, b []byte) *signerpb.Response {
+	t.Helper()
+
+	var resp signerpb.Response
+	if err := proto.Unmarshal(b, &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	return &resp
+}
+
+func TestDataVaultGateOpensOnMasterPassphrase(t *testing.T) {
+	pass := []byte("vault-pass")
+	fakeVaultHelper(t, common.VaultStateClosed, pass)
+
+	fs, err := keychain.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	v, err := newDataVault(true, fs, l)
+	if err != nil || v == nil {
+		t.Fatalf("newDataVault: %v (vault=%v)", err, v)
+	}
+
+	calls := 0
+	h := handleDataVault(v, func(context.Context, []byte) ([]byte, error) {
+		calls++
+		return marshalOK(true), nil
+	})
+	call := func(req *signerpb.Request) *signerpb.Response {
+		payload, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		out, err := h(context.Background(), payload)
+		if err != nil {
+			t.Fatalf("handler: %v", err)
+		}
+		return decodeResponse(t, out)
+	}
+	unlock := func(p string) *signerpb.Request {
+		return &signerpb.Request{Payload: &signerpb.Request_Unlock{
+			Unlock: &signerpb.UnlockRequest{KeyIds: []string{"k"}, Passphrase: []byte(p)},
+		}}
+	}
+
+	info := call(&signerpb.Request{Payload: &signerpb.Request_InitInfo{InitInfo: &signerpb.InitInfoRequest{}}})
+	if !info.GetInitInfo().GetDataLocked() || !info.GetInitInfo().GetMasterPresent() {
+		t.Fatalf("expected locked init info, got %v", info)
+	}
+	status := call(&signerpb.Request{Payload: &signerpb.Request_Status{Status: &signerpb.StatusRequest{}}})
+	if status.GetError().GetCode() != rpcDataLocked {
+		t.Fatalf("expected data locked error, got %v", status)
+	}
+	if resp := call(unlock("wrong")); resp.GetError().GetCode() != rpcDataVaultFailed || v.opened() {
+		t.Fatalf("expected wrong passphrase to keep the vault closed, got %v", resp)
+	}
+	if calls != 0 {
+		t.Fatalf("store reached %d time(s) while the vault was closed", calls)
+	}
+
+	if resp := call(unlock(string(pass))); !resp.GetOk().GetOk() {
+		t.Fatalf("expected unlock to pass through once open, got %v", resp)
+	}
+	if !v.opened() || calls != 1 {
+		t.Fatalf("vault open=%v, store calls=%d", v.opened(), calls)
+	}
+	call(&signerpb.Request{Payload: &signerpb.Request_Status{Status: &signerpb.StatusRequest{}}})
+	if calls != 2 {
+		t.Fatalf("expected status to reach the store after open, calls=%d", calls)
+	}
+}
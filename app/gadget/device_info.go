@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signerpb"
+)
+
+// gadgetFeatures are the optional capabilities of this build, for hosts
+// that need to know before they try.
+var gadgetFeatures = []string{
+	"app_update",
+	"data_vault",
+	"deterministic_paths",
+	"device_info",
+	"entropy",
+	"get_public_key",
+	"kdf_upgrade",
+	"log_level",
+	"log_ring",
+	"key_passphrases",
+	"key_tags",
+	"key_policy",
+	"key_validity",
+	"pop_regenerate",
+	"self_test",
+	"strict_validation",
+	"threshold_shares",
+	"time_sync",
+	"watermark_snapshots",
+}
+
+func readTrim(filePath string) string {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// deviceInfo answers DeviceInfoRequest. With no store (the data vault is
+// still closed) the store stats only say so.
+func deviceInfo(fs *keychain.FileStore, kr *keychain.KeyRing) *signerpb.DeviceInfoResponse {
+	info := &signerpb.DeviceInfoResponse{
+		Serial:       orUnknown(readTrim(common.DeviceIDFile)),
+		ImageFlavour: orUnknown(readTrim(common.ImageFlavourFile)),
+		Version:      orUnknown(readTrim(common.ImageVersionFile)),
+		BuildDate:    orUnknown(readTrim(common.ImageBuildDateFile)),
+		Store:        &signerpb.StoreStats{DataLocked: fs == nil},
+		Features:     gadgetFeatures,
+
+		SelfTestFailure: selfTestFailure,
+	}
+	if fs == nil {
+		return info
+	}
+
+	info.Store.MasterPresent, info.Store.Deterministic, _ = fs.InitInfo()
+	if suite, err := fs.CipherSuite(); err == nil {
+		info.Store.Cipher = string(suite)
+	}
+	if st, err := kr.KDFStatus(); err == nil {
+		info.Store.KdfWeak = st.Weak
+	}
+	for _, ks := range kr.Status() {
+		info.Store.Keys++
+		if ks.GetLockState() == signerpb.LockState_UNLOCKED {
+			info.Store.UnlockedKeys++
+		}
+	}
+	return info
+}
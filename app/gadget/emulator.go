@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/logging"
+)
+
+// The emulator serves the gadget's handler and keychain stack on TCP or
+// Unix sockets instead of FunctionFS endpoints, so the host CLI and its HTTP
+// signer run without a board. `go build -tags emulator ./app/gadget` builds
+// it as tezsign-emulator (see emulator_main.go).
+
+const (
+	emulatorName = "tezsign-emulator"
+	// beside the host's HTTP signer on 20090
+	emulatorSignAddress = "127.0.0.1:20091"
+	emulatorMgmtAddress = "127.0.0.1:20092"
+	emulatorLogFile     = "emulator.log"
+	emulatorStoreDir    = "tezsign-emulator"
+)
+
+var errEmulatedStreamClosed = errors.New("emulated stream closed")
+
+type emulatorConfig struct {
+	// store stands in for the data partition: keystore, broker key and
+	// pushed updates
+	store       string
+	transport   string
+	signAddress string
+	mgmtAddress string
+}
+
+func parseEmulatorFlags(args []string, output io.Writer) (emulatorConfig, error) {
+	var cfg emulatorConfig
+	defaultStore := strings.TrimSpace(os.Getenv("DATA_STORE"))
+	if defaultStore == "" {
+		defaultStore = emulatorStoreDir
+	}
+
+	flags := flag.NewFlagSet(emulatorName, flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.StringVar(&cfg.store, "store", defaultStore, "directory holding the emulated gadget's keystore and broker key (env DATA_STORE)")
+	flags.StringVar(&cfg.transport, "transport", "tcp", "tcp or unix")
+	flags.StringVar(&cfg.signAddress, "sign-address", "", "listen address of the sign channel (default "+emulatorSignAddress+", or sign.sock in the store for unix)")
+	flags.StringVar(&cfg.mgmtAddress, "mgmt-address", "", "listen address of the management channel (default "+emulatorMgmtAddress+", or mgmt.sock in the store for unix)")
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+	if flags.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	switch cfg.transport {
+	case "tcp":
+		cfg.signAddress = cmp.Or(cfg.signAddress, emulatorSignAddress)
+		cfg.mgmtAddress = cmp.Or(cfg.mgmtAddress, emulatorMgmtAddress)
+	case "unix":
+		cfg.signAddress = cmp.Or(cfg.signAddress, filepath.Join(cfg.store, "sign.sock"))
+		cfg.mgmtAddress = cmp.Or(cfg.mgmtAddress, filepath.Join(cfg.store, "mgmt.sock"))
+	default:
+		return cfg, fmt.Errorf("unknown transport %q (want tcp or unix)", cfg.transport)
+	}
+	if cfg.signAddress == cfg.mgmtAddress {
+		return cfg, errors.New("the sign and management channels need different addresses")
+	}
+	return cfg, nil
+}
+
+// emulator is a gadget whose channels are socket listeners. Every connection
+// gets a broker of its own, so reconnecting hosts are served like a replug.
+type emulator struct {
+	svc        *gadgetServices
+	sign, mgmt net.Listener
+	l          *slog.Logger
+}
+
+func newEmulator(cfg emulatorConfig, l *slog.Logger) (*emulator, error) {
+	svc, err := newGadgetServices(filepath.Join(cfg.store, "keystore"), false, l)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := listenEmulated(cfg.transport, cfg.signAddress)
+	if err != nil {
+		return nil, err
+	}
+	mgmt, err := listenEmulated(cfg.transport, cfg.mgmtAddress)
+	if err != nil {
+		_ = sign.Close()
+		return nil, err
+	}
+	return &emulator{svc: svc, sign: sign, mgmt: mgmt, l: l}, nil
+}
+
+func listenEmulated(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if err := os.MkdirAll(filepath.Dir(address), 0o700); err != nil {
+			return nil, fmt.Errorf("socket dir %q: %w", address, err)
+		}
+		_ = os.Remove(address) // left behind by an emulator that was killed
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s %q: %w", network, address, err)
+	}
+	return ln, nil
+}
+
+// serve answers both channels until ctx ends, then closes the listeners and
+// waits for open sessions to drain.
+func (e *emulator) serve(ctx context.Context) {
+	e.l.Info("Emulated signer gadget online; awaiting requests.",
+		slog.String("sign", e.sign.Addr().String()), slog.String("mgmt", e.mgmt.Addr().String()))
+
+	var wg sync.WaitGroup
+	wg.Go(func() { e.serveChannel(ctx, e.sign, "sign", e.svc.signBroker) })
+	wg.Go(func() { e.serveChannel(ctx, e.mgmt, "mgmt", e.svc.mgmtBroker) })
+
+	<-ctx.Done()
+	_ = e.sign.Close()
+	_ = e.mgmt.Close()
+	wg.Wait()
+}
+
+func (e *emulator) serveChannel(ctx context.Context, ln net.Listener, channel string, newBroker func(broker.ReadContexter, broker.WriteContexter) *broker.Broker) {
+	l := e.l.With(slog.String("chan", channel))
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.Error("accept", slog.Any("err", err))
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		sessions.Go(func() {
+			defer conn.Close()
+			st := &emulatedStream{conn: conn}
+			b := newBroker(st, st)
+			l.Info("host connected", slog.String("remote", conn.RemoteAddr().String()))
+			select {
+			case <-b.Done():
+			case <-ctx.Done():
+			}
+			b.Stop()
+			l.Info("host disconnected", slog.String("remote", conn.RemoteAddr().String()))
+		})
+	}
+}
+
+// emulatedStream maps context cancellation onto the connection's deadlines,
+// as the host's common.NewConnStream does; the gadget does not link the
+// host package and the libusb beneath it.
+type emulatedStream struct {
+	conn net.Conn
+}
+
+func (s *emulatedStream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	d, _ := ctx.Deadline()
+	_ = s.conn.SetReadDeadline(d)
+	stop := context.AfterFunc(ctx, func() { _ = s.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	n, err := s.conn.Read(p)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return n, ctx.Err()
+	case errors.Is(err, io.EOF):
+		// a closed socket does not come back like a rebound endpoint; the
+		// broker must stop rather than retry
+		return n, errEmulatedStreamClosed
+	}
+	return n, err
+}
+
+func (s *emulatedStream) WriteContext(ctx context.Context, p []byte) (int, error) {
+	d, _ := ctx.Deadline()
+	_ = s.conn.SetWriteDeadline(d)
+	stop := context.AfterFunc(ctx, func() { _ = s.conn.SetWriteDeadline(time.Now()) })
+	defer stop()
+	n, err := s.conn.Write(p)
+	if err != nil && ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	return n, err
+}
+
+// emulatorMain is tezsign-emulator's main and returns its exit code.
+func emulatorMain(args []string) int {
+	cfg, err := parseEmulatorFlags(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	logCfg := logging.NewConfigFromEnv()
+	if logCfg.RingLines == 0 {
+		logCfg.RingLines = logRingLines
+	}
+	if logCfg.File == "" && !logCfg.UsesJournal() {
+		logCfg.File = filepath.Join(cfg.store, emulatorLogFile)
+	}
+	if err := logging.EnsureDir(logCfg.File); err != nil {
+		fmt.Fprintf(os.Stderr, "log dir: %v\n", err)
+		return 1
+	}
+	l, _ := logging.New(logCfg)
+
+	// the emulator runs on the host's own clock; set_time must not move it
+	settime = func(time.Time) error { return nil }
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	e, err := newEmulator(cfg, l)
+	if err != nil {
+		l.Error("RUN ERROR", slog.Any("err", err))
+		return 1
+	}
+	e.serve(ctx)
+	return 0
+}
@@ -0,0 +1,9 @@
+//go:build emulator
+
+package main
+
+import "os"
+
+func main() {
+	os.Exit(emulatorMain(os.Args[1:]))
+}
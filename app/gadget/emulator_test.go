@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// dialEmulated connects a host-side broker to one of the emulator's channels.
+func dialEmulated(t *testing.T, ln net.Listener) *broker.Broker {
+	t.Helper()
+	conn, err := net.Dial(ln.Addr().Network(), ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	hostKey, err := broker.LoadOrCreateStaticKey(filepath.Join(t.TempDir(), "host.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &emulatedStream{conn: conn}
+	b := broker.New(st, st,
+		broker.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		broker.WithHandshake(), broker.WithCompression(), broker.WithFragmentation(), broker.WithChecksums(),
+		broker.WithFlowControl(), broker.WithDeadlines(), broker.WithSequencing(), broker.WithErrorFrames(), broker.WithStreaming(),
+		broker.WithNoise(broker.NoiseConfig{Static: hostKey, Initiator: true}),
+		broker.WithHandler(func(context.Context, []byte) ([]byte, error) { return nil, nil }),
+	)
+	t.Cleanup(b.Stop)
+	return b
+}
+
+func emulatedRequest(t *testing.T, b *broker.Broker, req *signerpb.Request) *signerpb.Response {
+	t.Helper()
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, _, err := b.Request(ctx, payload)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	var resp signerpb.Response
+	if err := proto.Unmarshal(out, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func TestEmulatorServesBothChannels(t *testing.T) {
+	for _, transport := range []string{"tcp", "unix"} {
+		t.Run(transport, func(t *testing.T) {
+			store := t.TempDir()
+			cfg := emulatorConfig{store: store, transport: transport}
+			if transport == "tcp" {
+				cfg.signAddress, cfg.mgmtAddress = "127.0.0.1:0", "127.0.0.1:0"
+			} else {
+				cfg.signAddress, cfg.mgmtAddress = filepath.Join(store, "sign.sock"), filepath.Join(store, "mgmt.sock")
+			}
+			e, err := newEmulator(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan struct{})
+			go func() {
+				e.serve(ctx)
+				close(served)
+			}()
+
+			mgmt := dialEmulated(t, e.mgmt)
+			if resp := emulatedRequest(t, mgmt, &signerpb.Request{Payload: &signerpb.Request_Version{Version: &signerpb.VersionRequest{}}}); resp.GetVersion() == nil {
+				t.Fatalf("version over mgmt: %v", resp)
+			}
+			if _, ok := mgmt.PeerStatic(); !ok {
+				t.Fatal("expected the emulated channel to be encrypted")
+			}
+			// the sign channel refuses management requests
+			sign := dialEmulated(t, e.sign)
+			if resp := emulatedRequest(t, sign, &signerpb.Request{Payload: &signerpb.Request_Status{Status: &signerpb.StatusRequest{}}}); resp.GetError() != nil {
+				t.Fatalf("status over sign: %v", resp)
+			}
+			if resp := emulatedRequest(t, sign, &signerpb.Request{Payload: &signerpb.Request_InitInfo{InitInfo: &signerpb.InitInfoRequest{}}}); resp.GetError() == nil {
+				t.Fatalf("sign channel served a management request: %v", resp)
+			}
+
+			// a host that reconnects gets a fresh session
+			mgmt.Stop()
+			again := dialEmulated(t, e.mgmt)
+			if resp := emulatedRequest(t, again, &signerpb.Request{Payload: &signerpb.Request_Version{Version: &signerpb.VersionRequest{}}}); resp.GetVersion() == nil {
+				t.Fatalf("version after reconnect: %v", resp)
+			}
+
+			cancel()
+			select {
+			case <-served:
+			case <-time.After(5 * time.Second):
+				t.Fatal("emulator outlived its context")
+			}
+		})
+	}
+}
+
+func TestParseEmulatorFlags(t *testing.T) {
+	t.Setenv("DATA_STORE", "")
+	cfg, err := parseEmulatorFlags(nil, io.Discard)
+	if err != nil || cfg.transport != "tcp" || cfg.signAddress != emulatorSignAddress || cfg.mgmtAddress != emulatorMgmtAddress || cfg.store != emulatorStoreDir {
+		t.Fatalf("defaults = %+v, %v", cfg, err)
+	}
+	cfg, err = parseEmulatorFlags([]string{"--transport", "unix", "--store", "/tmp/emu"}, io.Discard)
+	if err != nil || cfg.signAddress != "/tmp/emu/sign.sock" || cfg.mgmtAddress != "/tmp/emu/mgmt.sock" {
+		t.Fatalf("unix = %+v, %v", cfg, err)
+	}
+	for _, args := range [][]string{
+		{"--transport", "usb"},
+		{"--sign-address", "127.0.0.1:1", "--mgmt-address", "127.0.0.1:1"},
+		{"extra"},
+	} {
+		if _, err := parseEmulatorFlags(args, io.Discard); err == nil {
+			t.Fatalf("%q: expected an error", args)
+		}
+	}
+}
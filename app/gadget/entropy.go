@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/secure"
+)
+
+// maxEntropyRequest bounds one GetEntropy answer; hosts wanting more ask
+// again.
+const maxEntropyRequest = 4096
+
+var errEntropyLength = fmt.Errorf("entropy length must be 1..%d", maxEntropyRequest)
+
+// readEntropy returns n bytes of the hardware RNG XORed with as many from the
+// kernel CSPRNG, so the result is no weaker than the better of the two. When
+// the board has no /dev/hwrng the kernel bytes are returned alone and
+// hardware is false.
+func readEntropy(n int) (out []byte, hardware bool, err error) {
+	if n <= 0 || n > maxEntropyRequest {
+		return nil, false, errEntropyLength
+	}
+
+	out = make([]byte, n)
+	if _, err := rand.Read(out); err != nil {
+		return nil, false, err
+	}
+
+	hw, err := readHwRng(n)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return out, false, nil
+	case err != nil:
+		secure.MemoryWipe(out)
+		return nil, false, fmt.Errorf("hwrng: %w", err)
+	}
+	defer secure.MemoryWipe(hw)
+
+	for i := range out {
+		out[i] ^= hw[i]
+	}
+	return out, true, nil
+}
+
+func readHwRng(n int) ([]byte, error) {
+	f, err := os.Open(common.HwRngDevice)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		secure.MemoryWipe(buf)
+		return nil, err
+	}
+	return buf, nil
+}
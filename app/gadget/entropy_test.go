@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+)
+
+func TestReadEntropyMixesHardwareRNG(t *testing.T) {
+	prev := common.HwRngDevice
+	t.Cleanup(func() { common.HwRngDevice = prev })
+
+	common.HwRngDevice = filepath.Join(t.TempDir(), "missing")
+	out, hardware, err := readEntropy(32)
+	if err != nil || hardware || len(out) != 32 {
+		t.Fatalf("without hwrng: %d bytes, hardware=%v, %v", len(out), hardware, err)
+	}
+
+	// a stuck-at-zero source must not show through the kernel bytes
+	zeros := filepath.Join(t.TempDir(), "hwrng")
+	if err := os.WriteFile(zeros, make([]byte, maxEntropyRequest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	common.HwRngDevice = zeros
+	out, hardware, err = readEntropy(maxEntropyRequest)
+	if err != nil || !hardware || len(out) != maxEntropyRequest {
+		t.Fatalf("with hwrng: %d bytes, hardware=%v, %v", len(out), hardware, err)
+	}
+	if bytes.Equal(out, make([]byte, maxEntropyRequest)) {
+		t.Fatalf("output is the hardware bytes unmixed")
+	}
+
+	// a source that runs dry is an error, not short output
+	if _, _, err := readEntropy(maxEntropyRequest); err != nil {
+		t.Fatalf("reread: %v", err)
+	}
+	if err := os.WriteFile(zeros, make([]byte, 8), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readEntropy(16); err == nil {
+		t.Fatalf("expected an error from a short hwrng read")
+	}
+
+	for _, n := range []int{0, -1, maxEntropyRequest + 1} {
+		if _, _, err := readEntropy(n); !errors.Is(err, errEntropyLength) {
+			t.Fatalf("readEntropy(%d): expected errEntropyLength, got %v", n, err)
+		}
+	}
+}
@@ -1,12 +1,44 @@
 package main
 
 const (
+	rpcDeadlineExceeded uint32 = 3
+
 	rpcUnlockThrottled uint32 = 12
 
-	rpcKeyNotFound    uint32 = 31
-	rpcKeyLocked      uint32 = 32
-	rpcStaleWatermark uint32 = 33
-	rpcBadPayload     uint32 = 34
+	rpcKeyNotFound     uint32 = 31
+	rpcKeyLocked       uint32 = 32
+	rpcStaleWatermark  uint32 = 33
+	rpcBadPayload      uint32 = 34
+	rpcOutsideValidity uint32 = 35
+	rpcPolicyRefused   uint32 = 36
+	rpcRateLimited     uint32 = 37
+	rpcSelfCheckFailed uint32 = 38
+	rpcSelfTestFailed  uint32 = 39
+
+	rpcRestrictionThrottled uint32 = 112
+	rpcRestrictionBadPass   uint32 = 113
+
+	rpcWatermarksThrottled uint32 = 122
+	rpcWatermarksBadPass   uint32 = 123
+
+	rpcKDFThrottled uint32 = 132
+	rpcKDFBadPass   uint32 = 133
+
+	rpcDataLocked      uint32 = 140
+	rpcDataVaultFailed uint32 = 141
+
+	rpcStoreCorrupted uint32 = 150
+
+	rpcEntropyBadLength   uint32 = 160
+	rpcEntropyUnavailable uint32 = 161
+
+	rpcClockRejected uint32 = 170
+	rpcClockFailed   uint32 = 171
+
+	rpcUpdateThrottled uint32 = 182
+	rpcUpdateBadPass   uint32 = 183
+	rpcUpdateRejected  uint32 = 184
+	rpcUpdateFailed    uint32 = 185
 
 	rpcDeleteThrottled uint32 = 92
 	rpcDeleteBadPass   uint32 = 93
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/appupdate"
 	"github.com/tez-capital/tezsign/broker"
 	"github.com/tez-capital/tezsign/keychain"
 	"github.com/tez-capital/tezsign/logging"
@@ -22,6 +23,11 @@ import (
 
 const (
 	waitEndpointsTime = 30 * time.Second
+	// in-flight requests get this long to finish when the gadget shuts down
+	brokerDrainTimeout = 2 * time.Second
+
+	// records kept in memory for the logs RPC, whatever the file does
+	logRingLines = 2000
 
 	securedAttemptWindow = 30 * time.Second
 	securedAttemptLimit  = 5
@@ -29,34 +35,6 @@ const (
 
 var securedRPCLimiter = newAttemptLimiter(securedAttemptLimit, securedAttemptWindow)
 
-func main() {
-	logCfg := logging.NewConfigFromEnv()
-	if logCfg.File == "" {
-		dataStore := strings.TrimSpace(os.Getenv("DATA_STORE"))
-		if dataStore != "" {
-			if err := os.MkdirAll(dataStore, 0o700); err != nil {
-				panic(fmt.Errorf("could not create DATA_STORE=%q: %w", dataStore, err))
-			}
-			logCfg.File = filepath.Join(dataStore, "gadget.log")
-		} else {
-			logCfg.File = logging.DefaultFileInExecDir("gadget.log")
-		}
-	}
-
-	if err := logging.EnsureDir(logCfg.File); err != nil {
-		panic("Could not create dir for path of configuration file!")
-	}
-
-	l, _ := logging.New(logCfg)
-
-	l.Debug("logging to file", "path", logging.CurrentFile())
-
-	if err := run(l); err != nil {
-		l.Error("RUN ERROR", slog.Any("err", err))
-		os.Exit(1)
-	}
-}
-
 func handleSignAndStatus(base func(context.Context, []byte) ([]byte, error)) broker.Handler {
 	return func(ctx context.Context, payload []byte) ([]byte, error) {
 		var req signerpb.Request
@@ -64,8 +42,10 @@ func handleSignAndStatus(base func(context.Context, []byte) ([]byte, error)) bro
 			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
 		}
 		switch req.Payload.(type) {
-		case *signerpb.Request_Sign, *signerpb.Request_Status, *signerpb.Request_Version:
-			// allowed on IF0
+		case *signerpb.Request_Sign, *signerpb.Request_Status, *signerpb.Request_GetPublicKey, *signerpb.Request_Version, *signerpb.Request_DeviceInfo,
+			*signerpb.Request_GetTime:
+			// allowed on IF0; SetTime moves the clock validity windows are
+			// judged by, so it is management only
 		default:
 			return marshalErr(98, "wrong interface: use management (IF1) for this request"), nil
 		}
@@ -74,6 +54,22 @@ func handleSignAndStatus(base func(context.Context, []byte) ([]byte, error)) bro
 	}
 }
 
+// signPriority puts sign responses on the broker's high lane. Sign requests
+// are small; anything bigger is not worth decoding to find out.
+func signPriority(payload []byte) broker.Priority {
+	if len(payload) > 4*broker.KB {
+		return broker.PriorityNormal
+	}
+	var req signerpb.Request
+	if err := proto.Unmarshal(payload, &req); err != nil {
+		return broker.PriorityNormal
+	}
+	if _, isSign := req.Payload.(*signerpb.Request_Sign); isSign {
+		return broker.PriorityHigh
+	}
+	return broker.PriorityNormal
+}
+
 func handleMgmtOnly(base func(context.Context, []byte) ([]byte, error)) broker.Handler {
 	return func(ctx context.Context, payload []byte) ([]byte, error) {
 		var req signerpb.Request
@@ -88,6 +84,21 @@ func handleMgmtOnly(base func(context.Context, []byte) ([]byte, error)) broker.H
 	}
 }
 
+// Refusal reasons of sign requests that never reach the keyring, in addition
+// to keychain's SignReason* codes.
+const (
+	reasonSelfTestFailed = "self_test_failed"
+	reasonDataLocked     = "data_locked"
+)
+
+// auditRefusedSign logs the keychain.SignEvent of a sign request refused by
+// a handler in front of the keyring.
+func auditRefusedSign(l *slog.Logger, req *signerpb.SignRequest, reason string) {
+	ev := keychain.NewSignEvent(req.GetTz4(), req.GetMessage())
+	ev.Decision, ev.Reason = keychain.DecisionRefused, reason
+	ev.Log(l)
+}
+
 func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog.Logger) broker.Handler {
 	return func(ctx context.Context, payload []byte) ([]byte, error) {
 		var req signerpb.Request
@@ -97,6 +108,11 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 		defer wipeReq(&req)
 		defer secure.MemoryWipe(payload)
 
+		// the host stopped waiting while the request sat in the queue
+		if ctx.Err() != nil {
+			return marshalErr(rpcDeadlineExceeded, "request deadline passed"), nil
+		}
+
 		switch p := req.Payload.(type) {
 		case *signerpb.Request_Unlock:
 			pass := p.Unlock.GetPassphrase()
@@ -120,19 +136,26 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				return marshalErr(rpcUnlockThrottled, msg), nil
 			}
 
+			keyPasses := p.Unlock.GetKeyPassphrases()
+
 			results := make([]*signerpb.PerKeyResult, 0, len(ids))
-			for _, id := range ids {
-				res := &signerpb.PerKeyResult{KeyId: id}
-				if err := kr.Unlock(id, pass); err != nil {
-					res.Ok = false
-					res.Error = err.Error()
-					l.Error("unlock", "key", id, "err", err)
-				} else {
-					res.Ok = true
-					l.Debug("UNLOCKED " + id)
+			kr.WithCachedKEK(pass, func() {
+				for _, id := range ids {
+					res := &signerpb.PerKeyResult{KeyId: id}
+					if ctx.Err() != nil {
+						// each key is an Argon2 derivation; skip the rest
+						res.Error = "request deadline passed"
+					} else if err := kr.Unlock(id, pass, keyPasses[id]); err != nil {
+						res.Ok = false
+						res.Error = err.Error()
+						l.Error("unlock", "key", id, "err", err)
+					} else {
+						res.Ok = true
+						l.Debug("UNLOCKED " + id)
+					}
+					results = append(results, res)
 				}
-				results = append(results, res)
-			}
+			})
 
 			l.Debug("UNLOCK batch", "count", len(ids))
 
@@ -175,10 +198,23 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 
 			return proto.Marshal(&signerpb.Response{
 				Payload: &signerpb.Response_Status{
-					Status: &signerpb.StatusResponse{Keys: st},
+					Status: &signerpb.StatusResponse{Keys: st, SelfTestFailure: selfTestFailure},
 				},
 			})
 
+		case *signerpb.Request_GetPublicKey:
+			pk, err := kr.PublicKey(p.GetPublicKey.GetKey())
+			if err != nil {
+				if errors.Is(err, keychain.ErrKeyNotFound) {
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				}
+				return marshalErr(30, "get public key: "+err.Error()), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_GetPublicKey{GetPublicKey: pk},
+			})
+
 		case *signerpb.Request_Sign:
 			tz4 := p.Sign.GetTz4()
 			sig, err := kr.SignAndUpdate(tz4, p.Sign.GetMessage())
@@ -191,15 +227,22 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				case errors.Is(err, keychain.ErrStaleWatermark):
 					return marshalErr(rpcStaleWatermark, keychain.ErrStaleWatermark.Error()), nil
 				case errors.Is(err, keychain.ErrBadPayload):
-					return marshalErr(rpcBadPayload, keychain.ErrBadPayload.Error()), nil
+					return marshalErr(rpcBadPayload, err.Error()), nil
+				case errors.Is(err, keychain.ErrOutsideValidity):
+					return marshalErr(rpcOutsideValidity, keychain.ErrOutsideValidity.Error()), nil
+				case errors.Is(err, keychain.ErrPolicyRefused):
+					return marshalErr(rpcPolicyRefused, err.Error()), nil
+				case errors.Is(err, keychain.ErrRateLimited):
+					return marshalErr(rpcRateLimited, keychain.ErrRateLimited.Error()), nil
+				case errors.Is(err, keychain.ErrSignatureSelfCheck):
+					l.Error("signature self-check failed; not returned", "tz4", tz4, "err", err)
+					return marshalErr(rpcSelfCheckFailed, keychain.ErrSignatureSelfCheck.Error()), nil
 
 				default:
 					return marshalErr(30, "sign: "+err.Error()), nil
 				}
 			}
 
-			l.Debug("SIGNED", "tz4", tz4)
-
 			result, err := proto.Marshal(&signerpb.Response{
 				Payload: &signerpb.Response_Sign{
 					Sign: &signerpb.SignResponse{Signature: sig},
@@ -211,32 +254,50 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 		case *signerpb.Request_NewKeys:
 			pass := p.NewKeys.GetPassphrase()
 			defer secure.MemoryWipe(pass)
+			keyPass := p.NewKeys.GetKeyPassphrase()
+			defer secure.MemoryWipe(keyPass)
 			ids := p.NewKeys.GetKeyIds()
 			if len(ids) == 0 {
 				ids = []string{""}
 			}
+			path := p.NewKeys.GetDerivationPath()
+			if path != "" && len(ids) > 1 {
+				return marshalErr(40, "new_keys: derivation_path requires a single key"), nil
+			}
 
 			results := make([]*signerpb.NewKeyPerKeyResult, 0, len(ids))
-			for _, alias := range ids {
-				id, blPubkey, tz4, err := kr.CreateKey(alias, pass)
-				r := &signerpb.NewKeyPerKeyResult{
-					KeyId:    id,
-					BlPubkey: blPubkey,
-					Tz4:      tz4,
-				}
-				if err != nil {
-					if id == "" {
-						r.KeyId = alias
+			kr.WithCachedKEK(pass, func() {
+				for _, alias := range ids {
+					var (
+						id, blPubkey, tz4 string
+						err               error
+					)
+					if ctx.Err() != nil {
+						err = errors.New("request deadline passed")
+					} else if path != "" {
+						id, blPubkey, tz4, err = kr.CreateKeyAtPath(alias, path, pass, keyPass)
+					} else {
+						id, blPubkey, tz4, err = kr.CreateKey(alias, pass, keyPass)
 					}
-					r.Ok = false
-					r.Error = err.Error()
-					l.Error("NEW_KEY", "alias", alias, "err", err)
-				} else {
-					r.Ok = true
-					l.Debug("NEW_KEY", "key", id, "tz4", tz4)
+					r := &signerpb.NewKeyPerKeyResult{
+						KeyId:    id,
+						BlPubkey: blPubkey,
+						Tz4:      tz4,
+					}
+					if err != nil {
+						if id == "" {
+							r.KeyId = alias
+						}
+						r.Ok = false
+						r.Error = err.Error()
+						l.Error("NEW_KEY", "alias", alias, "err", err)
+					} else {
+						r.Ok = true
+						l.Debug("NEW_KEY", "key", id, "tz4", tz4)
+					}
+					results = append(results, r)
 				}
-				results = append(results, r)
-			}
+			})
 
 			l.Debug("NEW_KEY batch", "count", len(ids))
 
@@ -248,6 +309,37 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				},
 			})
 
+		case *signerpb.Request_ImportKeyShare:
+			pass := p.ImportKeyShare.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+			keyPass := p.ImportKeyShare.GetKeyPassphrase()
+			defer secure.MemoryWipe(keyPass)
+			alias := p.ImportKeyShare.GetKeyId()
+
+			share := keychain.ShareInfoFromProto(p.ImportKeyShare.GetShare())
+			id, blPubkey, tz4, err := kr.ImportShare(alias, p.ImportKeyShare.GetSecretKey(), share, pass, keyPass)
+			r := &signerpb.NewKeyPerKeyResult{
+				KeyId:    id,
+				BlPubkey: blPubkey,
+				Tz4:      tz4,
+				Ok:       err == nil,
+			}
+			if err != nil {
+				r.KeyId = alias
+				r.Error = err.Error()
+				l.Error("IMPORT_SHARE", "alias", alias, "err", err)
+			} else {
+				l.Debug("IMPORT_SHARE", "key", id, "tz4", tz4, "index", share.Index)
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_NewKey{
+					NewKey: &signerpb.NewKeysResponse{
+						Results: []*signerpb.NewKeyPerKeyResult{r},
+					},
+				},
+			})
+
 		case *signerpb.Request_DeleteKeys:
 			pass := p.DeleteKeys.GetPassphrase()
 			defer secure.MemoryWipe(pass)
@@ -298,12 +390,20 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 			})
 
 		case *signerpb.Request_Logs:
+			lim := int(p.Logs.GetLimit())
+			if ring := logging.CurrentRing(); ring != nil && !p.Logs.GetFromFile() {
+				return proto.Marshal(&signerpb.Response{
+					Payload: &signerpb.Response_Logs{
+						Logs: &signerpb.LogsResponse{Lines: ring.Last(lim), Source: "memory"},
+					},
+				})
+			}
+
 			path := logging.CurrentFile()
 			if path == "" {
 				return marshalErr(50, "logs: file logging not enabled"), nil
 			}
 
-			lim := int(p.Logs.GetLimit())
 			lines, err := logging.TailLastLines(path, lim)
 			if err != nil {
 				return marshalErr(51, fmt.Sprintf("logs: %v", err)), nil
@@ -311,37 +411,82 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 
 			return proto.Marshal(&signerpb.Response{
 				Payload: &signerpb.Response_Logs{
-					Logs: &signerpb.LogsResponse{Lines: lines},
+					Logs: &signerpb.LogsResponse{Lines: lines, Source: "file"},
 				},
 			})
 
-		case *signerpb.Request_Version:
-			readTrim := func(filePath string) string {
-				b, err := os.ReadFile(filePath)
-				if err != nil {
-					return ""
+		case *signerpb.Request_SetLogLevel:
+			levels := logging.CurrentLevels()
+			if levels == nil {
+				return marshalErr(119, "set_log_level: logging not configured"), nil
+			}
+			if spec := strings.TrimSpace(p.SetLogLevel.GetSpec()); spec != "" {
+				if err := levels.Apply(spec); err != nil {
+					return marshalErr(119, "set_log_level: "+err.Error()), nil
 				}
-				return strings.TrimSpace(string(b))
+				l.Warn("log levels changed", "levels", levels.String())
 			}
 
-			version := readTrim(common.ImageVersionFile)
-			if version == "" {
-				version = "unknown"
-			}
-			buildDate := readTrim(common.ImageBuildDateFile)
-			if buildDate == "" {
-				buildDate = "unknown"
-			}
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_SetLogLevel{
+					SetLogLevel: &signerpb.SetLogLevelResponse{Levels: levels.String()},
+				},
+			})
 
+		case *signerpb.Request_Version:
 			return proto.Marshal(&signerpb.Response{
 				Payload: &signerpb.Response_Version{
 					Version: &signerpb.VersionResponse{
-						Version:   version,
-						BuildDate: buildDate,
+						Version:   orUnknown(readTrim(common.ImageVersionFile)),
+						BuildDate: orUnknown(readTrim(common.ImageBuildDateFile)),
 					},
 				},
 			})
 
+		case *signerpb.Request_DeviceInfo:
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_DeviceInfo{DeviceInfo: deviceInfo(fs, kr)},
+			})
+
+		case *signerpb.Request_SetTime:
+			now, err := setClock(time.UnixMilli(p.SetTime.GetUnixMs()))
+			switch {
+			case errors.Is(err, errClockBeforeBuild):
+				return marshalErr(rpcClockRejected, err.Error()), nil
+			case err != nil:
+				l.Error("set clock failed", slog.Any("err", err))
+				return marshalErr(rpcClockFailed, "set time: "+err.Error()), nil
+			}
+			l.Info("clock synced from host", slog.Time("now", now))
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_Time{Time: timeResponse(now)},
+			})
+
+		case *signerpb.Request_GetTime:
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_Time{Time: timeResponse(time.Now())},
+			})
+
+		case *signerpb.Request_GetEntropy:
+			data, hardware, err := readEntropy(int(p.GetEntropy.GetLength()))
+			switch {
+			case errors.Is(err, errEntropyLength):
+				return marshalErr(rpcEntropyBadLength, err.Error()), nil
+			case err != nil:
+				l.Error("entropy read failed", slog.Any("err", err))
+				return marshalErr(rpcEntropyUnavailable, "entropy: "+err.Error()), nil
+			}
+			if !hardware {
+				l.Warn("no hardware RNG; entropy is from the kernel CSPRNG only")
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_GetEntropy{
+					GetEntropy: &signerpb.GetEntropyResponse{Data: data, Hardware: hardware},
+				},
+			})
+
 		case *signerpb.Request_InitMaster:
 			det := p.InitMaster.GetDeterministic()
 			pass := p.InitMaster.GetPassphrase()
@@ -351,8 +496,13 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 				return marshalErr(60, "init_master: passphrase required"), nil
 			}
 
+			suite, err := keychain.ParseCipherSuite(p.InitMaster.GetCipher())
+			if err != nil {
+				return marshalErr(60, "init_master: "+err.Error()), nil
+			}
+
 			// master.json
-			if err := fs.InitMaster(); err != nil {
+			if err := fs.InitMasterWithCipher(suite); err != nil {
 				return marshalErr(61, "init_master: "+err.Error()), nil
 			}
 
@@ -386,13 +536,318 @@ func handleRequestsFactory(fs *keychain.FileStore, kr *keychain.KeyRing, l *slog
 
 			return marshalOK(true), nil
 
+		case *signerpb.Request_SetTags:
+			keyID := p.SetTags.GetKeyId()
+			tags, err := kr.SetTags(keyID, p.SetTags.GetSet(), p.SetTags.GetRemove())
+			if err != nil {
+				if errors.Is(err, keychain.ErrKeyNotFound) {
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				}
+				return marshalErr(100, fmt.Sprintf("set_tags for key=%s error: %v", keyID, err)), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_SetTags{
+					SetTags: &signerpb.SetTagsResponse{Tags: tags},
+				},
+			})
+
+		case *signerpb.Request_SetValidity:
+			pass := p.SetValidity.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+			// a new window can open what the old one closed, so the link
+			// alone does not get to change it
+			if denied := guardSecuredRPC("set_validity", pass, kr, l, restrictionRPCCodes); denied != nil {
+				return denied, nil
+			}
+			keyID := p.SetValidity.GetKeyId()
+			if err := kr.SetValidity(keyID, keychain.ValidityFromProto(p.SetValidity.GetValidity())); err != nil {
+				switch {
+				case errors.Is(err, keychain.ErrKeyNotFound):
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				case errors.Is(err, keychain.ErrKeyLocked):
+					return marshalErr(rpcKeyLocked, keychain.ErrKeyLocked.Error()), nil
+				}
+				return marshalErr(110, fmt.Sprintf("set_validity for key=%s error: %v", keyID, err)), nil
+			}
+
+			return marshalOK(true), nil
+
+		case *signerpb.Request_SetPolicy:
+			pass := p.SetPolicy.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+			// the same goes for the kinds, chain and rate the key may sign
+			if denied := guardSecuredRPC("set_policy", pass, kr, l, restrictionRPCCodes); denied != nil {
+				return denied, nil
+			}
+			keyID := p.SetPolicy.GetKeyId()
+			policy, validity := keychain.PolicyFromProto(p.SetPolicy.GetPolicy())
+			if err := kr.SetPolicy(keyID, policy, validity); err != nil {
+				switch {
+				case errors.Is(err, keychain.ErrKeyNotFound):
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				case errors.Is(err, keychain.ErrKeyLocked):
+					return marshalErr(rpcKeyLocked, keychain.ErrKeyLocked.Error()), nil
+				}
+				return marshalErr(115, fmt.Sprintf("set_policy for key=%s error: %v", keyID, err)), nil
+			}
+
+			return marshalPolicy(kr, keyID)
+
+		case *signerpb.Request_GetPolicy:
+			return marshalPolicy(kr, p.GetPolicy.GetKeyId())
+
+		case *signerpb.Request_RegeneratePop:
+			keyID := p.RegeneratePop.GetKeyId()
+			pk, err := kr.RegeneratePoP(keyID)
+			if err != nil {
+				switch {
+				case errors.Is(err, keychain.ErrKeyNotFound):
+					return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+				case errors.Is(err, keychain.ErrKeyLocked):
+					return marshalErr(rpcKeyLocked, keychain.ErrKeyLocked.Error()), nil
+				case errors.Is(err, keychain.ErrSignatureSelfCheck):
+					l.Error("pop self-check failed", "key", keyID, "err", err)
+					return marshalErr(rpcSelfCheckFailed, err.Error()), nil
+				}
+				return marshalErr(118, fmt.Sprintf("regenerate_pop for key=%s error: %v", keyID, err)), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_GetPublicKey{GetPublicKey: pk},
+			})
+
+		case *signerpb.Request_GetWatermarks:
+			keys, err := kr.Watermarks(p.GetWatermarks.GetKeyIds())
+			if err != nil {
+				if errors.Is(err, keychain.ErrKeyNotFound) {
+					return marshalErr(rpcKeyNotFound, err.Error()), nil
+				}
+				return marshalErr(117, "get_watermarks: "+err.Error()), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_GetWatermarks{
+					GetWatermarks: &signerpb.GetWatermarksResponse{Keys: keys},
+				},
+			})
+
+		case *signerpb.Request_ExportWatermarks:
+			pass := p.ExportWatermarks.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+			if denied := guardSecuredRPC("export_watermarks", pass, kr, l, watermarksRPCCodes); denied != nil {
+				return denied, nil
+			}
+
+			deviceID, _ := os.ReadFile(common.DeviceIDFile)
+			snapshot, skipped, err := kr.ExportWatermarks(pass, string(deviceID))
+			if err != nil {
+				return marshalErr(121, "export_watermarks: "+err.Error()), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_ExportWatermarks{
+					ExportWatermarks: &signerpb.ExportWatermarksResponse{
+						Snapshot: snapshot,
+						Skipped:  skipped,
+					},
+				},
+			})
+
+		case *signerpb.Request_ImportWatermarks:
+			pass := p.ImportWatermarks.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+			if denied := guardSecuredRPC("import_watermarks", pass, kr, l, watermarksRPCCodes); denied != nil {
+				return denied, nil
+			}
+
+			imported, err := kr.ImportWatermarks(pass, p.ImportWatermarks.GetSnapshot())
+			if err != nil {
+				return marshalErr(121, "import_watermarks: "+err.Error()), nil
+			}
+
+			results := make([]*signerpb.ImportWatermarksPerKeyResult, 0, len(imported))
+			for _, r := range imported {
+				res := &signerpb.ImportWatermarksPerKeyResult{
+					KeyId:  r.KeyID,
+					Tz4:    r.TZ4,
+					Raised: r.Raised,
+					Ok:     r.Err == nil,
+				}
+				if r.Err != nil {
+					res.Error = r.Err.Error()
+					l.Error("IMPORT_WATERMARKS", "key", r.KeyID, "tz4", r.TZ4, "err", r.Err)
+				} else {
+					l.Debug("IMPORT_WATERMARKS", "key", r.KeyID, "raised", r.Raised)
+				}
+				results = append(results, res)
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_ImportWatermarks{
+					ImportWatermarks: &signerpb.ImportWatermarksResponse{Results: results},
+				},
+			})
+
+		case *signerpb.Request_KdfStatus:
+			st, err := kr.KDFStatus()
+			if err != nil {
+				return marshalErr(131, "kdf_status: "+err.Error()), nil
+			}
+
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_KdfStatus{
+					KdfStatus: &signerpb.KDFStatusResponse{
+						Time:                 st.Current.Time,
+						MemoryKib:            st.Current.MemoryKiB,
+						Threads:              uint32(st.Current.Threads),
+						RecommendedTime:      st.Recommended.Time,
+						RecommendedMemoryKib: st.Recommended.MemoryKiB,
+						RecommendedThreads:   uint32(st.Recommended.Threads),
+						Weak:                 st.Weak,
+						Pending:              st.Pending,
+					},
+				},
+			})
+
+		case *signerpb.Request_UpgradeKdf:
+			pass := p.UpgradeKdf.GetPassphrase()
+			defer secure.MemoryWipe(pass)
+
+			var (
+				denied   []byte
+				upgraded bool
+				err      error
+			)
+			// the guard and the upgrade share one derivation of the current KEK
+			kr.WithCachedKEK(pass, func() {
+				if denied = guardSecuredRPC("upgrade_kdf", pass, kr, l, kdfRPCCodes); denied != nil {
+					return
+				}
+				upgraded, err = kr.UpgradeKDF(pass)
+			})
+			if denied != nil {
+				return denied, nil
+			}
+			if err != nil {
+				l.Error("UPGRADE_KDF", "err", err)
+				return marshalErr(131, "upgrade_kdf: "+err.Error()), nil
+			}
+
+			return marshalOK(upgraded), nil
+
 		default:
 			return marshalErr(1000, "unknown request"), nil
 		}
 	}
 }
 
-func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRing, l *slog.Logger) error {
+type securedRPCCodes struct {
+	noPass, throttled, badPass uint32
+}
+
+var (
+	watermarksRPCCodes  = securedRPCCodes{noPass: 120, throttled: rpcWatermarksThrottled, badPass: rpcWatermarksBadPass}
+	kdfRPCCodes         = securedRPCCodes{noPass: 130, throttled: rpcKDFThrottled, badPass: rpcKDFBadPass}
+	restrictionRPCCodes = securedRPCCodes{noPass: 111, throttled: rpcRestrictionThrottled, badPass: rpcRestrictionBadPass}
+)
+
+// guardSecuredRPC applies the secured-RPC throttle and master passphrase
+// check; it returns the error response to send, or nil to proceed.
+func guardSecuredRPC(op string, pass []byte, kr *keychain.KeyRing, l *slog.Logger, codes securedRPCCodes) []byte {
+	if len(pass) == 0 {
+		return marshalErr(codes.noPass, op+": passphrase required")
+	}
+	if ok, wait := securedRPCLimiter.Allow(); !ok {
+		l.Warn(op+" throttled", slog.Duration("retry_in", wait))
+		msg := fmt.Sprintf(
+			"%s throttled: retry in ~%s (max %d attempts per %s)",
+			op,
+			wait.Round(time.Second),
+			securedAttemptLimit,
+			securedAttemptWindow,
+		)
+		return marshalErr(codes.throttled, msg)
+	}
+	if err := kr.VerifyMasterPassword(pass); err != nil {
+		if errors.Is(err, keychain.ErrStoreCorrupted) {
+			l.Error(op+": store corrupted", slog.Any("err", err))
+			return marshalErr(rpcStoreCorrupted, op+": "+err.Error())
+		}
+		l.Warn(op+": bad passphrase", slog.Any("err", err))
+		return marshalErr(codes.badPass, op+": invalid passphrase")
+	}
+	return nil
+}
+
+// gadgetServices is the keystore and the services layered over it, which
+// both channels' brokers serve. The FunctionFS gadget and the emulator share
+// it.
+type gadgetServices struct {
+	fs      *keychain.FileStore
+	kr      *keychain.KeyRing
+	vault   *dataVault
+	updater *appUpdater
+	noise   broker.Option
+	l       *slog.Logger
+}
+
+// newGadgetServices opens the keystore in baseDir. With vaultEnabled the
+// directory is the mount point of the encrypted data vault.
+func newGadgetServices(baseDir string, vaultEnabled bool, l *slog.Logger) (*gadgetServices, error) {
+	// Ensure keystore dir exists (0700 since it holds secrets)
+	if err := os.MkdirAll(baseDir, 0o700); err != nil {
+		return nil, fmt.Errorf("keystore mkdir %q: %w", baseDir, err)
+	}
+
+	fs, err := keychain.NewFileStore(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("store: %w", err)
+	}
+
+	kr := keychain.NewKeyRing(logging.Named(l, logging.ModuleKeychain), fs)
+	runSelfTest(l)
+
+	vault, err := newDataVault(vaultEnabled, fs, l)
+	if err != nil {
+		return nil, err
+	}
+
+	// a missing key must not take the signer offline; run unencrypted
+	// instead, unless encryption is required (see gadgetNoise)
+	noise, err := gadgetNoise(filepath.Dir(baseDir), l)
+	if err != nil {
+		l.Error("broker static key unavailable; channel stays unencrypted", slog.Any("err", err))
+		noise = broker.WithNoise(broker.NoiseConfig{}) // no static key: no-op
+	}
+
+	// pushed signer binaries wait here for app_update on the next boot
+	updater := newAppUpdater(filepath.Join(filepath.Dir(baseDir), appupdate.Dir))
+
+	return &gadgetServices{fs: fs, kr: kr, vault: vault, updater: updater, noise: noise, l: l}, nil
+}
+
+func (s *gadgetServices) brokerOptions(handler broker.Handler, extra ...broker.Option) []broker.Option {
+	bLogger := broker.WithLogger(logging.Named(s.l, logging.ModuleBroker))
+	opts := []broker.Option{bLogger, broker.WithHandshake(), broker.WithCompression(), broker.WithFragmentation(), broker.WithChecksums(), broker.WithFlowControl(), broker.WithDeadlines(), broker.WithSequencing(), broker.WithErrorFrames(), broker.WithStreaming(), broker.WithDrainTimeout(brokerDrainTimeout), s.noise}
+	return append(append(opts, extra...), broker.WithHandler(handler))
+}
+
+// signBroker serves the sign channel (IF0) over r and w.
+func (s *gadgetServices) signBroker(r broker.ReadContexter, w broker.WriteContexter) *broker.Broker {
+	hLogger := logging.Named(s.l, logging.ModuleGadget)
+	handler := handleSignAndStatus(handleSelfTest(hLogger, handleDataVault(s.vault, handleRequestsFactory(s.fs, s.kr, hLogger))))
+	return broker.New(r, w, s.brokerOptions(handler, broker.WithRequestPriority(signPriority))...)
+}
+
+// mgmtBroker serves the management channel (IF1) over r and w.
+func (s *gadgetServices) mgmtBroker(r broker.ReadContexter, w broker.WriteContexter) *broker.Broker {
+	hLogger := logging.Named(s.l, logging.ModuleGadget)
+	handler := handleMgmtOnly(handleSelfTest(hLogger, handleDataVault(s.vault, handleAppUpdate(s.updater, s.kr, hLogger, handleRequestsFactory(s.fs, s.kr, hLogger)))))
+	return broker.New(r, w, s.brokerOptions(handler)...)
+}
+
+func runBrokers(ctx context.Context, svc *gadgetServices, l *slog.Logger) error {
 	l.Info("Waiting for endpoints...")
 	in0, out0, in1, out1, err := waitForFunctionFSEndpoints(common.FfsInstanceRoot, waitEndpointsTime)
 	if err != nil {
@@ -410,7 +865,6 @@ func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRin
 	default:
 	}
 
-	bLogger := broker.WithLogger(l.With("component", "broker"))
 	// IF0 (sign) endpoints
 	in0Fd, err := os.OpenFile(in0, os.O_WRONLY, 0) // device -> host
 	if err != nil {
@@ -447,10 +901,10 @@ func runBrokers(ctx context.Context, fs *keychain.FileStore, kr *keychain.KeyRin
 	}
 
 	// IF0: sign channel
-	signBroker := broker.New(r0, w0, bLogger, broker.WithHandler(handleSignAndStatus(handleRequestsFactory(fs, kr, l))))
+	signBroker := svc.signBroker(r0, w0)
 	defer signBroker.Stop()
 	// IF1: management channel
-	mgmtBroker := broker.New(r1, w1, bLogger, broker.WithHandler(handleMgmtOnly(handleRequestsFactory(fs, kr, l))))
+	mgmtBroker := svc.mgmtBroker(r1, w1)
 	defer mgmtBroker.Stop()
 
 	// Advertise readiness only after both broker loops are live.
@@ -472,18 +926,14 @@ func run(l *slog.Logger) error {
 	} else {
 		baseDir = logging.DefaultFileInExecDir("keystore") // e.g. /path/to/bin/keystore
 	}
-	// Ensure keystore dir exists (0700 since it holds secrets)
-	if err := os.MkdirAll(baseDir, 0o700); err != nil {
-		return fmt.Errorf("keystore mkdir %q: %w", baseDir, err)
-	}
 
-	fs, err := keychain.NewFileStore(baseDir)
+	// DATA_VAULT=1: the keystore dir is the mount point of the encrypted data
+	// vault, opened on the first request carrying the master passphrase
+	svc, err := newGadgetServices(baseDir, strings.TrimSpace(os.Getenv("DATA_VAULT")) == "1", l)
 	if err != nil {
-		return fmt.Errorf("store: %w", err)
+		return err
 	}
 
-	kr := keychain.NewKeyRing(l, fs)
-
 	// --- broker handler: parse → validate → sign/deny → respond ---
 
 	for {
@@ -503,10 +953,28 @@ func run(l *slog.Logger) error {
 			_ = enabled.Close()
 		}()
 
-		err = runBrokers(ctx, fs, kr, l)
+		err = runBrokers(ctx, svc, l)
 		if err != nil {
 			l.Error("broker error", "err", err)
 			continue
 		}
 	}
 }
+
+// marshalPolicy answers set_policy and get_policy with the key's policy as
+// stored.
+func marshalPolicy(kr *keychain.KeyRing, keyID string) ([]byte, error) {
+	policy, validity, err := kr.Policy(keyID)
+	if err != nil {
+		if errors.Is(err, keychain.ErrKeyNotFound) {
+			return marshalErr(rpcKeyNotFound, keychain.ErrKeyNotFound.Error()), nil
+		}
+		return marshalErr(116, fmt.Sprintf("get_policy for key=%s error: %v", keyID, err)), nil
+	}
+
+	return proto.Marshal(&signerpb.Response{
+		Payload: &signerpb.Response_Policy{
+			Policy: &signerpb.PolicyResponse{Policy: keychain.PolicyToProto(policy, validity)},
+		},
+	})
+}
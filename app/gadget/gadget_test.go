@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// unlockedKey is a key store with one unlocked key, id, whose window stops
+// at level 20, and the request handler over it.
+func unlockedKey(t *testing.T, pass []byte) (kr *keychain.KeyRing, id string, call func(*signerpb.Request) *signerpb.Response) {
+	t.Helper()
+	prev := securedRPCLimiter
+	securedRPCLimiter = newAttemptLimiter(100, securedAttemptWindow)
+	t.Cleanup(func() { securedRPCLimiter = prev })
+
+	fs, err := keychain.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.InitMaster(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteSeed(pass, false); err != nil {
+		t.Fatal(err)
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	kr = keychain.NewKeyRing(l, fs)
+	id, _, _, err = kr.CreateKey("baker", pass, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Unlock(id, pass, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.SetValidity(id, keychain.Validity{MaxLevel: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := handleRequestsFactory(fs, kr, l)
+	return kr, id, func(req *signerpb.Request) *signerpb.Response {
+		t.Helper()
+		payload, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := h(context.Background(), payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodeResponse(t, out)
+	}
+}
+
+func TestSetValidityRequiresPassphrase(t *testing.T) {
+	pass := []byte("master-pass")
+	kr, id, call := unlockedKey(t, pass)
+	setValidity := func(p []byte, v *signerpb.Validity) *signerpb.Response {
+		return call(&signerpb.Request{Payload: &signerpb.Request_SetValidity{
+			SetValidity: &signerpb.SetValidityRequest{KeyId: id, Validity: v, Passphrase: p},
+		}})
+	}
+
+	// clearing the window would let the key sign past level 20
+	if resp := setValidity(nil, &signerpb.Validity{}); resp.GetError().GetCode() != restrictionRPCCodes.noPass {
+		t.Fatalf("set_validity without a passphrase: %v", resp)
+	}
+	if resp := setValidity([]byte("wrong"), &signerpb.Validity{}); resp.GetError().GetCode() != rpcRestrictionBadPass {
+		t.Fatalf("set_validity with a wrong passphrase: %v", resp)
+	}
+	if _, v, err := kr.Policy(id); err != nil || v.MaxLevel != 20 {
+		t.Fatalf("refused set_validity changed the window: %+v, %v", v, err)
+	}
+	if resp := setValidity(pass, &signerpb.Validity{}); !resp.GetOk().GetOk() {
+		t.Fatalf("set_validity: %v", resp)
+	}
+	if _, v, err := kr.Policy(id); err != nil || v.MaxLevel != 0 {
+		t.Fatalf("set_validity left the window: %+v, %v", v, err)
+	}
+}
+
+func TestSetPolicyRequiresPassphrase(t *testing.T) {
+	pass := []byte("master-pass")
+	kr, id, call := unlockedKey(t, pass)
+	if err := kr.SetPolicy(id, keychain.Policy{Kinds: []string{"attestation"}}, keychain.Validity{MaxLevel: 20}); err != nil {
+		t.Fatal(err)
+	}
+	setPolicy := func(p []byte) *signerpb.Response {
+		// an empty policy allows every kind at every level
+		return call(&signerpb.Request{Payload: &signerpb.Request_SetPolicy{
+			SetPolicy: &signerpb.SetPolicyRequest{KeyId: id, Policy: &signerpb.Policy{}, Passphrase: p},
+		}})
+	}
+
+	if resp := setPolicy(nil); resp.GetError().GetCode() != restrictionRPCCodes.noPass {
+		t.Fatalf("set_policy without a passphrase: %v", resp)
+	}
+	if resp := setPolicy([]byte("wrong")); resp.GetError().GetCode() != rpcRestrictionBadPass {
+		t.Fatalf("set_policy with a wrong passphrase: %v", resp)
+	}
+	if p, v, err := kr.Policy(id); err != nil || len(p.Kinds) != 1 || v.MaxLevel != 20 {
+		t.Fatalf("refused set_policy changed the policy: %+v %+v, %v", p, v, err)
+	}
+	if resp := setPolicy(pass); resp.GetPolicy() == nil {
+		t.Fatalf("set_policy: %v", resp)
+	}
+	if p, v, err := kr.Policy(id); err != nil || len(p.Kinds) != 0 || v.MaxLevel != 0 {
+		t.Fatalf("set_policy left the policy: %+v %+v, %v", p, v, err)
+	}
+}
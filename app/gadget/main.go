@@ -0,0 +1,44 @@
+//go:build !emulator
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/logging"
+)
+
+func main() {
+	logCfg := logging.NewConfigFromEnv()
+	if logCfg.RingLines == 0 {
+		logCfg.RingLines = logRingLines
+	}
+	if logCfg.File == "" && !logCfg.UsesJournal() {
+		dataStore := strings.TrimSpace(os.Getenv("DATA_STORE"))
+		if dataStore != "" {
+			if err := os.MkdirAll(dataStore, 0o700); err != nil {
+				panic(fmt.Errorf("could not create DATA_STORE=%q: %w", dataStore, err))
+			}
+			logCfg.File = filepath.Join(dataStore, "gadget.log")
+		} else {
+			logCfg.File = logging.DefaultFileInExecDir("gadget.log")
+		}
+	}
+
+	if err := logging.EnsureDir(logCfg.File); err != nil {
+		panic("Could not create dir for path of configuration file!")
+	}
+
+	l, _ := logging.New(logCfg)
+
+	l.Debug("logging to file", "path", logging.CurrentFile())
+
+	if err := run(l); err != nil {
+		l.Error("RUN ERROR", slog.Any("err", err))
+		os.Exit(1)
+	}
+}
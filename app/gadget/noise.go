@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+const (
+	brokerKeyFile   = "broker.key"
+	brokerHostsFile = "broker_hosts"
+)
+
+var errHostNotAllowed = errors.New("host key not in " + brokerHostsFile)
+
+// gadgetNoise loads (or creates) the gadget's static key in dir, beside the
+// keystore so it is readable before the data vault opens. When dir holds a
+// broker_hosts file (one hex public key per line), only those hosts may
+// connect, and never in plaintext; otherwise any host is accepted and its
+// fingerprint logged. BROKER_REQUIRE_ENCRYPTION=1 refuses hosts that predate
+// the encrypted channel without an allow list too.
+func gadgetNoise(dir string, l *slog.Logger) (broker.Option, error) {
+	hostsPath := filepath.Join(dir, brokerHostsFile)
+	// a plaintext host skips VerifyPeer, so an allow list, or one that
+	// cannot be read, requires the handshake
+	allowed, err := readAllowedHosts(hostsPath)
+	require := allowed != nil || err != nil || strings.TrimSpace(os.Getenv("BROKER_REQUIRE_ENCRYPTION")) == "1"
+
+	key, err := broker.LoadOrCreateStaticKey(filepath.Join(dir, brokerKeyFile))
+	if err != nil {
+		if !require {
+			return nil, err
+		}
+		// hosts that pinned the lost key refuse this one, which beats
+		// serving them in plaintext
+		l.Error("broker static key unavailable; using a one-off key", slog.Any("err", err))
+		if key, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+	l.Info("broker static key", slog.String("fingerprint", broker.Fingerprint(key.PublicKey().Bytes())))
+
+	return broker.WithNoise(broker.NoiseConfig{
+		Static:  key,
+		Require: require,
+		VerifyPeer: func(static []byte) error {
+			allowed, err := readAllowedHosts(hostsPath)
+			if err != nil {
+				return err
+			}
+			fp := broker.Fingerprint(static)
+			if allowed == nil {
+				l.Info("host connected", slog.String("fingerprint", fp))
+				return nil
+			}
+			for _, k := range allowed {
+				if bytes.Equal(k, static) {
+					return nil
+				}
+			}
+			l.Warn("host rejected", slog.String("fingerprint", fp), slog.String("key", hex.EncodeToString(static)))
+			return errHostNotAllowed
+		},
+	}), nil
+}
+
+// readAllowedHosts returns nil when there is no allow list.
+func readAllowedHosts(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	allowed := [][]byte{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, err := hex.DecodeString(line)
+		if err != nil || len(k) != 32 {
+			continue
+		}
+		allowed = append(allowed, k)
+	}
+	return allowed, sc.Err()
+}
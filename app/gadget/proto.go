@@ -38,15 +38,87 @@ func wipeReq(r *signerpb.Request) {
 			secure.MemoryWipe(p.Unlock.Passphrase)
 			p.Unlock.Passphrase = nil
 		}
+		if p.Unlock != nil {
+			for id, kp := range p.Unlock.KeyPassphrases {
+				secure.MemoryWipe(kp)
+				delete(p.Unlock.KeyPassphrases, id)
+			}
+		}
 	case *signerpb.Request_NewKeys:
 		if p.NewKeys != nil && p.NewKeys.Passphrase != nil {
 			secure.MemoryWipe(p.NewKeys.Passphrase)
 			p.NewKeys.Passphrase = nil
 		}
+		if p.NewKeys != nil && p.NewKeys.KeyPassphrase != nil {
+			secure.MemoryWipe(p.NewKeys.KeyPassphrase)
+			p.NewKeys.KeyPassphrase = nil
+		}
+	case *signerpb.Request_ExportWatermarks:
+		if p.ExportWatermarks != nil && p.ExportWatermarks.Passphrase != nil {
+			secure.MemoryWipe(p.ExportWatermarks.Passphrase)
+			p.ExportWatermarks.Passphrase = nil
+		}
+	case *signerpb.Request_ImportWatermarks:
+		if p.ImportWatermarks != nil && p.ImportWatermarks.Passphrase != nil {
+			secure.MemoryWipe(p.ImportWatermarks.Passphrase)
+			p.ImportWatermarks.Passphrase = nil
+		}
 	case *signerpb.Request_DeleteKeys:
 		if p.DeleteKeys != nil && p.DeleteKeys.Passphrase != nil {
 			secure.MemoryWipe(p.DeleteKeys.Passphrase)
 			p.DeleteKeys.Passphrase = nil
 		}
+	case *signerpb.Request_InitMaster:
+		if p.InitMaster != nil && p.InitMaster.Passphrase != nil {
+			secure.MemoryWipe(p.InitMaster.Passphrase)
+			p.InitMaster.Passphrase = nil
+		}
+	case *signerpb.Request_UpgradeKdf:
+		if p.UpgradeKdf != nil && p.UpgradeKdf.Passphrase != nil {
+			secure.MemoryWipe(p.UpgradeKdf.Passphrase)
+			p.UpgradeKdf.Passphrase = nil
+		}
+	case *signerpb.Request_BeginUpdate:
+		if p.BeginUpdate != nil && p.BeginUpdate.Passphrase != nil {
+			secure.MemoryWipe(p.BeginUpdate.Passphrase)
+			p.BeginUpdate.Passphrase = nil
+		}
+	case *signerpb.Request_SetValidity:
+		if p.SetValidity != nil && p.SetValidity.Passphrase != nil {
+			secure.MemoryWipe(p.SetValidity.Passphrase)
+			p.SetValidity.Passphrase = nil
+		}
+	case *signerpb.Request_SetPolicy:
+		if p.SetPolicy != nil && p.SetPolicy.Passphrase != nil {
+			secure.MemoryWipe(p.SetPolicy.Passphrase)
+			p.SetPolicy.Passphrase = nil
+		}
+	}
+}
+
+// masterPassphrase returns the master passphrase a request carries, if any.
+func masterPassphrase(r *signerpb.Request) []byte {
+	switch p := r.Payload.(type) {
+	case *signerpb.Request_Unlock:
+		return p.Unlock.GetPassphrase()
+	case *signerpb.Request_NewKeys:
+		return p.NewKeys.GetPassphrase()
+	case *signerpb.Request_DeleteKeys:
+		return p.DeleteKeys.GetPassphrase()
+	case *signerpb.Request_ExportWatermarks:
+		return p.ExportWatermarks.GetPassphrase()
+	case *signerpb.Request_ImportWatermarks:
+		return p.ImportWatermarks.GetPassphrase()
+	case *signerpb.Request_InitMaster:
+		return p.InitMaster.GetPassphrase()
+	case *signerpb.Request_UpgradeKdf:
+		return p.UpgradeKdf.GetPassphrase()
+	case *signerpb.Request_BeginUpdate:
+		return p.BeginUpdate.GetPassphrase()
+	case *signerpb.Request_SetValidity:
+		return p.SetValidity.GetPassphrase()
+	case *signerpb.Request_SetPolicy:
+		return p.SetPolicy.GetPassphrase()
 	}
+	return nil
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// selfTestFailure is why the power-on self-test failed, or empty. It is set
+// before the brokers start and never changes afterwards: a gadget that failed
+// stays degraded until it is rebooted (and passes).
+var selfTestFailure string
+
+func runSelfTest(l *slog.Logger) {
+	if err := keychain.SelfTest(); err != nil {
+		selfTestFailure = err.Error()
+		l.Error("POWER-ON SELF-TEST FAILED; refusing to sign or create keys", slog.Any("err", err))
+		return
+	}
+	l.Info("power-on self-test passed")
+}
+
+// handleSelfTest refuses everything that produces signatures or key material
+// once the self-test has failed. Status, logs and the like keep working so
+// the host can see why.
+func handleSelfTest(l *slog.Logger, base broker.Handler) broker.Handler {
+	if selfTestFailure == "" {
+		return base
+	}
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		var req signerpb.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
+		}
+		switch p := req.Payload.(type) {
+		case *signerpb.Request_Sign:
+			auditRefusedSign(l, p.Sign, reasonSelfTestFailed)
+			wipeReq(&req)
+			return marshalErr(rpcSelfTestFailed, "gadget is degraded: "+selfTestFailure), nil
+		case *signerpb.Request_NewKeys, *signerpb.Request_ImportKeyShare,
+			*signerpb.Request_RegeneratePop, *signerpb.Request_InitMaster, *signerpb.Request_UpgradeKdf:
+			wipeReq(&req)
+			return marshalErr(rpcSelfTestFailed, "gadget is degraded: "+selfTestFailure), nil
+		}
+		return base(ctx, payload)
+	}
+}
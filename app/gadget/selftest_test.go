@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFailedSelfTestRefusesSigning(t *testing.T) {
+	t.Cleanup(func() { selfTestFailure = "" })
+	selfTestFailure = "bls: signature: known answer mismatch"
+
+	served := 0
+	h := handleSelfTest(slog.New(slog.NewTextHandler(io.Discard, nil)), func(ctx context.Context, payload []byte) ([]byte, error) {
+		served++
+		return marshalOK(true), nil
+	})
+	call := func(req *signerpb.Request) *signerpb.Response {
+		payload, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := h(context.Background(), payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp signerpb.Response
+		if err := proto.Unmarshal(out, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return &resp
+	}
+
+	resp := call(&signerpb.Request{Payload: &signerpb.Request_Sign{Sign: &signerpb.SignRequest{Tz4: "tz4x"}}})
+	if resp.GetError().GetCode() != rpcSelfTestFailed {
+		t.Fatalf("sign while degraded: %v", resp)
+	}
+	if served != 0 {
+		t.Fatalf("degraded gadget passed a sign request on")
+	}
+
+	if resp := call(&signerpb.Request{Payload: &signerpb.Request_Status{Status: &signerpb.StatusRequest{}}}); resp.GetError() != nil || served != 1 {
+		t.Fatalf("status while degraded: %v", resp)
+	}
+}
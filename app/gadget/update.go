@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/app/gadget/common"
+	"github.com/tez-capital/tezsign/appupdate"
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/secure"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// appUpdater holds the upload of a signer binary pushed over the management
+// interface. A committed upload waits in dir until app_update installs it on
+// the next boot; this process keeps running the old binary.
+type appUpdater struct {
+	dir string
+	// machine is the running signer's; an update must be built for it
+	machine func() (elf.Machine, error)
+	// trust is the image's release key and markers; an update must be
+	// signed by the key as the image release's binary, no older than the
+	// installed one
+	trust func() (appupdate.Trust, error)
+
+	mu     sync.Mutex
+	upload *appupdate.Upload
+}
+
+func newAppUpdater(dir string) *appUpdater {
+	return &appUpdater{dir: dir, machine: func() (elf.Machine, error) {
+		exe, err := os.Executable()
+		if err != nil {
+			return 0, err
+		}
+		return appupdate.Machine(exe)
+	}, trust: func() (appupdate.Trust, error) {
+		return appupdate.LoadTrust(common.AppMountPoint)
+	}}
+}
+
+func marshalUpload(received int64) ([]byte, error) {
+	return proto.Marshal(&signerpb.Response{
+		Payload: &signerpb.Response_UpdateUpload{
+			UpdateUpload: &signerpb.UpdateUploadResponse{Received: uint64(received)},
+		},
+	})
+}
+
+// handleAppUpdate answers begin_update, update_chunk and commit_update and
+// passes every other request on to base. Only begin_update carries the master
+// passphrase; the chunks and the commit belong to the upload it started. The
+// passphrase and the hash come from the host, so they prove nothing about
+// the binary: only its signature by the image's release key does, naming the
+// image release's binary and a build no older than the installed one.
+func handleAppUpdate(u *appUpdater, kr *keychain.KeyRing, l *slog.Logger, base broker.Handler) broker.Handler {
+	return func(ctx context.Context, payload []byte) ([]byte, error) {
+		var req signerpb.Request
+		if err := proto.Unmarshal(payload, &req); err != nil {
+			return marshalErr(1, fmt.Sprintf("bad protobuf: %v", err)), nil
+		}
+
+		switch p := req.Payload.(type) {
+		case *signerpb.Request_BeginUpdate:
+			defer wipeReq(&req)
+			defer secure.MemoryWipe(payload)
+			pass := p.BeginUpdate.GetPassphrase()
+			if len(pass) == 0 {
+				return marshalErr(180, "begin_update: passphrase required"), nil
+			}
+			if ok, wait := securedRPCLimiter.Allow(); !ok {
+				l.Warn("begin_update throttled", slog.Duration("retry_in", wait))
+				msg := fmt.Sprintf(
+					"begin_update throttled: retry in ~%s (max %d attempts per %s)",
+					wait.Round(time.Second),
+					securedAttemptLimit,
+					securedAttemptWindow,
+				)
+				return marshalErr(rpcUpdateThrottled, msg), nil
+			}
+			if err := kr.VerifyMasterPassword(pass); err != nil {
+				l.Warn("begin_update: bad passphrase", slog.Any("err", err))
+				return marshalErr(rpcUpdateBadPass, "begin_update: invalid passphrase"), nil
+			}
+			sum := p.BeginUpdate.GetSha256()
+			if len(sum) != 32 {
+				return marshalErr(181, "begin_update: sha256 must be 32 bytes"), nil
+			}
+			if _, err := u.trust(); err != nil {
+				l.Error("begin_update: no release key or release", slog.Any("err", err))
+				return marshalErr(rpcUpdateRejected, "begin_update: "+err.Error()), nil
+			}
+
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if u.upload != nil {
+				u.upload.Abort()
+				u.upload = nil
+			}
+			upload, err := appupdate.Begin(u.dir, int64(p.BeginUpdate.GetSize()), hex.EncodeToString(sum), p.BeginUpdate.GetSignature())
+			if errors.Is(err, appupdate.ErrUnsigned) {
+				return marshalErr(rpcUpdateRejected, "begin_update: "+err.Error()), nil
+			}
+			if err != nil {
+				return marshalErr(rpcUpdateFailed, "begin_update: "+err.Error()), nil
+			}
+			u.upload = upload
+			l.Warn("app update upload started", "size", p.BeginUpdate.GetSize(), "sha256", hex.EncodeToString(sum))
+			return marshalUpload(0)
+
+		case *signerpb.Request_UpdateChunk:
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if u.upload == nil {
+				return marshalErr(rpcUpdateRejected, "update_chunk: no update in progress; begin_update first"), nil
+			}
+			if err := u.upload.Write(int64(p.UpdateChunk.GetOffset()), p.UpdateChunk.GetData()); err != nil {
+				return marshalErr(rpcUpdateRejected, "update_chunk: "+err.Error()), nil
+			}
+			return marshalUpload(u.upload.Received())
+
+		case *signerpb.Request_CommitUpdate:
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if u.upload == nil {
+				return marshalErr(rpcUpdateRejected, "commit_update: no update in progress; begin_update first"), nil
+			}
+			upload := u.upload
+			u.upload = nil
+			machine, err := u.machine()
+			if err != nil {
+				upload.Abort()
+				return marshalErr(rpcUpdateFailed, "commit_update: "+err.Error()), nil
+			}
+			trust, err := u.trust()
+			if err != nil {
+				upload.Abort()
+				return marshalErr(rpcUpdateRejected, "commit_update: "+err.Error()), nil
+			}
+			if err := upload.Commit(trust, machine); err != nil {
+				upload.Abort()
+				code := rpcUpdateFailed
+				if errors.Is(err, appupdate.ErrMismatch) || errors.Is(err, appupdate.ErrNotELF) ||
+					errors.Is(err, appupdate.ErrUnsigned) || errors.Is(err, appupdate.ErrDowngrade) {
+					code = rpcUpdateRejected
+				}
+				l.Error("app update rejected", slog.Any("err", err))
+				return marshalErr(code, "commit_update: "+err.Error()), nil
+			}
+			l.Warn("app update staged; it is installed on the next boot", "dir", u.dir)
+			return proto.Marshal(&signerpb.Response{
+				Payload: &signerpb.Response_Ok{Ok: &signerpb.Ok{Ok: true}},
+			})
+		}
+
+		return base(ctx, payload)
+	}
+}
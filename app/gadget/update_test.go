@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"debug/elf"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tez-capital/tezsign/appupdate"
+	"github.com/tez-capital/tezsign/keychain"
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/signerpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAppUpdateUpload(t *testing.T) {
+	// every begin_update counts against the passphrase limiter, and this
+	// test begins more uploads than it allows in a window
+	prev := securedRPCLimiter
+	securedRPCLimiter = newAttemptLimiter(100, securedAttemptWindow)
+	t.Cleanup(func() { securedRPCLimiter = prev })
+
+	pass := []byte("master-pass")
+	fs, err := keychain.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.InitMaster(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteSeed(pass, false); err != nil {
+		t.Fatal(err)
+	}
+	l := slog.New(slog.NewTextHandler(io.Discard, nil))
+	kr := keychain.NewKeyRing(l, fs)
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(binary)
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{5}, ed25519.SeedSize))
+	sign := func(key ed25519.PrivateKey) []byte {
+		sig, err := minisign.Sign(key, bytes.NewReader(binary), "timestamp:1\tfile:rpi4.tezsign\tversion:v1.1.0\tdate:2026-02-01T00:00:00Z\thashed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	sig := sign(key)
+
+	u := newAppUpdater(filepath.Join(t.TempDir(), appupdate.Dir))
+	machine, err := u.machine()
+	if err != nil {
+		t.Skipf("test binary is not ELF: %v", err)
+	}
+	// an image built without a release key takes no updates
+	u.trust = func() (appupdate.Trust, error) { return appupdate.Trust{}, appupdate.ErrNoKey }
+	passed := 0
+	h := handleAppUpdate(u, kr, l, func(context.Context, []byte) ([]byte, error) {
+		passed++
+		return marshalOK(true), nil
+	})
+	call := func(req *signerpb.Request) *signerpb.Response {
+		payload, err := proto.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := h(context.Background(), payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decodeResponse(t, out)
+	}
+	begin := func(p []byte) *signerpb.Response {
+		return call(&signerpb.Request{Payload: &signerpb.Request_BeginUpdate{
+			BeginUpdate: &signerpb.BeginUpdateRequest{Passphrase: p, Size: uint64(len(binary)), Sha256: sum[:], Signature: sig},
+		}})
+	}
+	chunk := func(off int) *signerpb.Response {
+		return call(&signerpb.Request{Payload: &signerpb.Request_UpdateChunk{
+			UpdateChunk: &signerpb.UpdateChunkRequest{Offset: uint64(off), Data: binary[off:min(off+1<<20, len(binary))]},
+		}})
+	}
+	commit := func() *signerpb.Response {
+		return call(&signerpb.Request{Payload: &signerpb.Request_CommitUpdate{CommitUpdate: &signerpb.CommitUpdateRequest{}}})
+	}
+
+	if resp := chunk(0); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("chunk before begin_update: %v", resp)
+	}
+	if resp := begin([]byte("wrong")); resp.GetError().GetCode() != rpcUpdateBadPass {
+		t.Fatalf("begin_update with a wrong passphrase: %v", resp)
+	}
+	if resp := begin(pass); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("begin_update without a release key: %v", resp)
+	}
+	trust := appupdate.Trust{Key: minisign.NewPublicKey(key), Release: "rpi4", Date: "2026-01-01T00:00:00Z"}
+	u.trust = func() (appupdate.Trust, error) { return trust, nil }
+	if resp := begin(pass); resp.GetError() != nil {
+		t.Fatalf("begin_update: %v", resp)
+	}
+	for off := 0; off < len(binary); off += 1 << 20 {
+		if resp := chunk(off); resp.GetError() != nil || resp.GetUpdateUpload().GetReceived() != uint64(min(off+1<<20, len(binary))) {
+			t.Fatalf("chunk at %d: %v", off, resp)
+		}
+	}
+	if resp := commit(); !resp.GetOk().GetOk() {
+		t.Fatalf("commit_update: %v", resp)
+	}
+	if staged, err := appupdate.Staged(u.dir, trust, machine); err != nil || staged.Sum == "" {
+		t.Fatalf("nothing staged after commit: %v", err)
+	}
+	if resp := commit(); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("second commit_update: %v", resp)
+	}
+
+	// a binary that does not run on the device is not staged
+	u.machine = func() (elf.Machine, error) { return machine + 1, nil }
+	if resp := begin(pass); resp.GetError() != nil {
+		t.Fatalf("begin_update: %v", resp)
+	}
+	for off := 0; off < len(binary); off += 1 << 20 {
+		chunk(off)
+	}
+	if resp := commit(); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("commit_update for another machine: %v", resp)
+	}
+	u.machine = func() (elf.Machine, error) { return machine, nil }
+
+	// the host's passphrase and hash do not make a binary a release
+	sig = nil
+	if resp := begin(pass); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("begin_update without a signature: %v", resp)
+	}
+	sig = sign(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{6}, ed25519.SeedSize)))
+	if resp := begin(pass); resp.GetError() != nil {
+		t.Fatalf("begin_update: %v", resp)
+	}
+	for off := 0; off < len(binary); off += 1 << 20 {
+		chunk(off)
+	}
+	if resp := commit(); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("commit_update signed by another key: %v", resp)
+	}
+
+	// nor a release built before the installed signer
+	sig = sign(key)
+	trust.Date = "2026-03-01T00:00:00Z"
+	if resp := begin(pass); resp.GetError() != nil {
+		t.Fatalf("begin_update: %v", resp)
+	}
+	for off := 0; off < len(binary); off += 1 << 20 {
+		chunk(off)
+	}
+	if resp := commit(); resp.GetError().GetCode() != rpcUpdateRejected {
+		t.Fatalf("commit_update of an older build: %v", resp)
+	}
+
+	call(&signerpb.Request{Payload: &signerpb.Request_Status{Status: &signerpb.StatusRequest{}}})
+	if passed != 1 {
+		t.Fatalf("%d requests passed on, want only the status request", passed)
+	}
+}
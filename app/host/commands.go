@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +13,8 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -19,7 +24,10 @@ import (
 	"github.com/samber/lo"
 	"github.com/tez-capital/tezsign/broker"
 	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/minisign"
 	"github.com/tez-capital/tezsign/secure"
+	"github.com/tez-capital/tezsign/signer"
 	"github.com/tez-capital/tezsign/signerpb"
 	"github.com/urfave/cli/v3"
 	"golang.org/x/term"
@@ -93,6 +101,89 @@ func cmdVersion() *cli.Command {
 	}
 }
 
+func cmdInfo() *cli.Command {
+	return &cli.Command{
+		Name:  "info",
+		Usage: "Show gadget serial, image, key store and supported features",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			info, err := common.ReqDeviceInfo(h.Session.Broker)
+			if err != nil {
+				var re *common.RemoteError
+				if errors.As(err, &re) && re.Code == 1000 {
+					return fmt.Errorf("gadget does not support device info; update required: %w", err)
+				}
+				return err
+			}
+
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(info)
+			}
+
+			st := info.GetStore()
+			fmt.Printf("Serial:   %s\n", info.GetSerial())
+			fmt.Printf("Image:    %s %s (built %s)\n", info.GetImageFlavour(), info.GetVersion(), info.GetBuildDate())
+			switch {
+			case st.GetDataLocked():
+				fmt.Println("Store:    data vault locked")
+			case !st.GetMasterPresent():
+				fmt.Println("Store:    not initialized")
+			default:
+				mode := "random-only"
+				if st.GetDeterministic() {
+					mode = "deterministic"
+				}
+				fmt.Printf("Store:    %s, %s, %d keys (%d unlocked)\n", mode, st.GetCipher(), st.GetKeys(), st.GetUnlockedKeys())
+				if st.GetKdfWeak() {
+					fmt.Println("          master KDF is weaker than recommended; run `kdf upgrade`")
+				}
+			}
+			fmt.Printf("Features: %s\n", strings.Join(info.GetFeatures(), ", "))
+			if f := info.GetSelfTestFailure(); f != "" {
+				fmt.Printf("Self-test: FAILED, signing refused until a reboot passes it: %s\n", f)
+			}
+			return nil
+		},
+	}
+}
+
+func cmdTime() *cli.Command {
+	return &cli.Command{
+		Name:  "time",
+		Usage: "Show the gadget clock and its offset from this host",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			// withSession has already pushed our clock
+			ts, err := common.ReqGetTime(h.Session.Broker)
+			if err != nil {
+				var re *common.RemoteError
+				if errors.As(err, &re) && re.Code == 1000 {
+					return fmt.Errorf("gadget does not support time sync; update required: %w", err)
+				}
+				return err
+			}
+
+			gadget := time.UnixMilli(ts.GetUnixMs())
+			offset := gadget.Sub(time.Now()).Round(time.Millisecond)
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(struct {
+					Time     time.Time `json:"time"`
+					OffsetMs int64     `json:"offset_ms"`
+					Synced   bool      `json:"synced"`
+				}{Time: gadget.UTC(), OffsetMs: offset.Milliseconds(), Synced: ts.GetSynced()})
+			}
+
+			fmt.Printf("Gadget time: %s (offset %s)\n", gadget.UTC().Format(time.RFC3339), offset)
+			if !ts.GetSynced() {
+				fmt.Println("The gadget clock has not been set by a host since boot.")
+			}
+			return nil
+		},
+	}
+}
+
 func cmdInit() *cli.Command {
 	return &cli.Command{
 		Name:  "init",
@@ -102,6 +193,10 @@ func cmdInit() *cli.Command {
 				Name:  "deterministic",
 				Usage: "Enable deterministic HD mode (EIP-2333 path)",
 			},
+			&cli.StringFlag{
+				Name:  "cipher",
+				Usage: "Store cipher suite: aes-256-gcm (default) or xchacha20-poly1305",
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
@@ -113,6 +208,10 @@ func cmdInit() *cli.Command {
 				return fmt.Errorf("init: query: %w", err)
 			}
 
+			if info.GetDataLocked() {
+				fmt.Println("Already initialized (data vault locked; unlock a key to open it).")
+				return nil
+			}
 			if info.GetMasterPresent() {
 				mode := "random-only"
 				if info.GetDeterministicEnabled() {
@@ -138,7 +237,7 @@ func cmdInit() *cli.Command {
 				return fmt.Errorf("passphrases do not match")
 			}
 
-			ok, err := common.ReqInitMaster(b, c.Bool("deterministic"), pass)
+			ok, err := common.ReqInitMaster(b, c.Bool("deterministic"), pass, c.String("cipher"))
 			if err != nil {
 				l.Warn("init master", slog.Any("err", err))
 			} else if ok {
@@ -163,6 +262,16 @@ func cmdRun() *cli.Command {
 				Name:  "keep-alive",
 				Usage: fmt.Sprintf("Idle write keep-alive interval (example: 100ms, minimum: %s). Disabled when unset.", minKeepAlive),
 			},
+			&cli.DurationFlag{
+				Name:  "heartbeat",
+				Usage: fmt.Sprintf("Ping interval used to detect a gadget that stopped answering (minimum: %s, 0 disables)", minHeartbeat),
+				Value: defaultHeartbeat,
+			},
+			&cli.IntFlag{
+				Name:  "heartbeat-misses",
+				Usage: "Silent heartbeat intervals after which the session is rebuilt",
+				Value: defaultHeartbeatMisses,
+			},
 			&cli.BoolFlag{
 				Name:  "no-retry",
 				Usage: "Exit with non-zero on disconnect instead of auto-retrying",
@@ -263,7 +372,7 @@ func cmdRun() *cli.Command {
 
 			httpErrCh := make(chan error, 1)
 			go func() {
-				l.Debug("HTTP server listening", slog.String("addr", addr))
+				logging.Named(l, logging.ModuleHTTP).Debug("HTTP server listening", slog.String("addr", addr))
 				if err := app.Listen(addr); err != nil {
 					httpErrCh <- err
 				}
@@ -305,15 +414,24 @@ func cmdList() *cli.Command {
 	return &cli.Command{
 		Name:  "list",
 		Usage: "List keys in the gadget store",
+		Flags: []cli.Flag{tagFilterFlag()},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
 			b := h.Session.Broker
 
+			tagFilter, err := parseTags(c.StringSlice("tag"))
+			if err != nil {
+				return err
+			}
+
 			st, err := common.ReqStatus(b)
 			if err != nil {
 				return err
 			}
-			if st == nil || len(st.GetKeys()) == 0 {
+			filtered := lo.Filter(st.GetKeys(), func(ks *signerpb.KeyStatus, _ int) bool {
+				return matchesTags(ks, tagFilter)
+			})
+			if len(filtered) == 0 {
 				if !isTTY(os.Stdout) {
 					return json.NewEncoder(os.Stdout).Encode([]string{})
 				}
@@ -323,8 +441,8 @@ func cmdList() *cli.Command {
 			}
 
 			// collect aliases (keys) and tz4 only
-			keys := make(map[string]string, len(st.GetKeys()))
-			for _, k := range st.GetKeys() {
+			keys := make(map[string]string, len(filtered))
+			for _, k := range filtered {
 				keys[k.GetKeyId()] = k.GetTz4()
 			}
 
@@ -349,6 +467,16 @@ func cmdNewKeys() *cli.Command {
 		Name:      "new",
 		Usage:     "Create one or more keys (deterministic if seed enabled)",
 		ArgsUsage: "[alias1 alias2 ...]  (no args => one auto-assigned key)",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "key-passphrase",
+				Usage: "Protect the new key(s) with an additional per-key passphrase required at unlock",
+			},
+			&cli.StringFlag{
+				Name:  "path",
+				Usage: "EIP-2333 derivation path for a single deterministic key, e.g. m/12381/1729/0/0/7",
+			},
+		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
 			b := h.Session.Broker
@@ -367,9 +495,22 @@ func cmdNewKeys() *cli.Command {
 			}
 			defer secure.MemoryWipe(pass)
 
+			var keyPass []byte
+			if c.Bool("key-passphrase") {
+				keyPass, err = obtainConfirmedPassword("Key passphrase")
+				if err != nil {
+					return fmt.Errorf("new keys: %w", err)
+				}
+				defer secure.MemoryWipe(keyPass)
+			}
+
 			keys := c.Args().Slice()
+			path := strings.TrimSpace(c.String("path"))
+			if path != "" && len(keys) > 1 {
+				return fmt.Errorf("--path creates a single key; got %d aliases", len(keys))
+			}
 
-			results, err := common.ReqNewKeys(b, keys, pass)
+			results, err := common.ReqNewKeys(b, keys, pass, keyPass, path)
 			if err != nil {
 				return err
 			}
@@ -401,19 +542,33 @@ func cmdStatus() *cli.Command {
 				Name:  "full",
 				Usage: "Disable styling and print pubkey and PoP",
 			},
+			tagFilterFlag(),
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
 			b := h.Session.Broker
 
+			tagFilter, err := parseTags(c.StringSlice("tag"))
+			if err != nil {
+				return err
+			}
+
 			st, err := common.ReqStatus(b)
 			if err != nil {
 				return err
 			}
+			if f := st.GetSelfTestFailure(); f != "" {
+				fmt.Fprintf(os.Stderr, "WARNING: gadget self-test failed, signing refused: %s\n", f)
+			}
 			filter := map[string]bool{}
 			for _, k := range c.Args().Slice() {
 				filter[k] = true
 			}
+			if st != nil && len(tagFilter) > 0 {
+				st.Keys = lo.Filter(st.GetKeys(), func(ks *signerpb.KeyStatus, _ int) bool {
+					return matchesTags(ks, tagFilter)
+				})
+			}
 
 			if !isTTY(os.Stdout) {
 				var out []keyStatusJSON
@@ -442,10 +597,31 @@ func cmdStatus() *cli.Command {
 					fmt.Printf("%s  [%s]\n", k.GetKeyId(), state)
 					fmt.Printf("  tz4:       %s\n", k.GetTz4())
 					fmt.Printf("  BLpk:      %s\n", k.GetBlPubkey())
-					fmt.Printf("  PoP(BLsig): %s\n", k.GetPop())
+					fmt.Printf("  PoP(BLsig): %s%s\n", k.GetPop(), popNote(k.GetBlPubkey(), k.GetPop()))
+					if tags := k.GetTags(); len(tags) > 0 {
+						pairs := make([]string, 0, len(tags))
+						for tk, tv := range tags {
+							pairs = append(pairs, tk+"="+tv)
+						}
+						sort.Strings(pairs)
+						fmt.Printf("  tags:      %s\n", strings.Join(pairs, " "))
+					}
+					if v := k.GetValidity(); v != nil {
+						fmt.Printf("  validity:  %s\n", formatValidity(v))
+					}
+					if path := k.GetDerivationPath(); path != "" {
+						fmt.Printf("  path:      %s\n", path)
+					}
 					fmt.Printf("  last block:        level=%d round=%d\n", k.GetLastBlockLevel(), k.GetLastBlockRound())
 					fmt.Printf("  last preattest.:   level=%d round=%d\n", k.GetLastPreattestationLevel(), k.GetLastPreattestationRound())
 					fmt.Printf("  last attest.:      level=%d round=%d\n", k.GetLastAttestationLevel(), k.GetLastAttestationRound())
+					if k.GetLockState() == signerpb.LockState_UNLOCKED {
+						lastUsed := "never"
+						if ts := k.GetLastUsedUnix(); ts > 0 {
+							lastUsed = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+						}
+						fmt.Printf("  signatures:        %d (last used %s)\n", k.GetSignCount(), lastUsed)
+					}
 				}
 
 				return nil
@@ -468,6 +644,10 @@ func cmdLogs() *cli.Command {
 				Aliases: []string{"n"},
 				Usage:   "Max number of lines (newest last, 0 = gadget default)",
 			},
+			&cli.BoolFlag{
+				Name:  "file",
+				Usage: "Tail the gadget's log file instead of its in-memory buffer (records since boot)",
+			},
 		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
@@ -478,7 +658,7 @@ func cmdLogs() *cli.Command {
 				return fmt.Errorf("limit must be >= 0")
 			}
 
-			lines, err := common.ReqLogs(b, limit)
+			lines, err := common.ReqLogs(b, limit, c.Bool("file"))
 			if err != nil {
 				return err
 			}
@@ -496,6 +676,60 @@ func cmdLogs() *cli.Command {
 	}
 }
 
+func cmdEntropy() *cli.Command {
+	return &cli.Command{
+		Name:  "entropy",
+		Usage: "Read random bytes from the gadget's hardware RNG (mixed with its kernel CSPRNG)",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "bytes",
+				Aliases: []string{"n"},
+				Value:   32,
+				Usage:   "Number of bytes to read",
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "Write the bytes to stdout as is instead of hex",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			n := c.Int("bytes")
+			if n <= 0 || n > 1<<20 {
+				return fmt.Errorf("bytes must be 1..%d", 1<<20)
+			}
+
+			data, hardware, err := common.ReqGetEntropy(h.Session.Broker, n)
+			if err != nil {
+				var re *common.RemoteError
+				if errors.As(err, &re) && re.Code == 1000 {
+					return fmt.Errorf("gadget does not support entropy requests; update required: %w", err)
+				}
+				return err
+			}
+			defer secure.MemoryWipe(data)
+
+			if !hardware {
+				fmt.Fprintln(os.Stderr, "warning: gadget has no hardware RNG; bytes come from its kernel CSPRNG only")
+			}
+			if c.Bool("raw") {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(struct {
+					Hex      string `json:"hex"`
+					Hardware bool   `json:"hardware"`
+				}{Hex: hex.EncodeToString(data), Hardware: hardware})
+			}
+
+			fmt.Println(hex.EncodeToString(data))
+			return nil
+		},
+	}
+}
+
 func cmdUnlockKeys() *cli.Command {
 	return &cli.Command{
 		Name:      "unlock",
@@ -532,17 +766,27 @@ func cmdUnlockKeys() *cli.Command {
 			}
 			defer secure.MemoryWipe(pass)
 
+			st, err := common.ReqStatus(b)
+			if err != nil {
+				return err
+			}
 			if len(keys) == 0 {
-				st, err := common.ReqStatus(b)
-				if err != nil {
-					return err
-				}
 				keys = lo.Map(st.GetKeys(), func(key *signerpb.KeyStatus, _ int) string {
 					return key.GetKeyId()
 				})
 			}
 
-			res, err := common.ReqUnlockKeys(b, keys, pass)
+			keyPasses, err := obtainKeyPassphrases(st.GetKeys(), keys)
+			if err != nil {
+				return fmt.Errorf("unlock keys: %w", err)
+			}
+			defer func() {
+				for _, kp := range keyPasses {
+					secure.MemoryWipe(kp)
+				}
+			}()
+
+			res, err := common.ReqUnlockKeys(b, keys, pass, keyPasses)
 			if err != nil {
 				if errors.Is(err, context.DeadlineExceeded) {
 					return fmt.Errorf("unlock keys: context deadline exceeded: %w", err)
@@ -606,6 +850,11 @@ func cmdUnlockKeys() *cli.Command {
 					fmt.Println()
 				}
 				fmt.Println(renderChips(errLabels, chipErrStyle, w))
+				for _, r := range res {
+					if !r.GetOk() {
+						fmt.Printf("  %s: %s\n", r.GetKeyId(), r.GetError())
+					}
+				}
 			}
 			if hasDeadlineExceeded {
 				return fmt.Errorf("unlock keys: context deadline exceeded")
@@ -780,84 +1029,1202 @@ func cmdDeleteKeys() *cli.Command {
 	}
 }
 
-func cmdAdvanced() *cli.Command {
-	return &cli.Command{
-		Name:  "advanced",
-		Usage: "Advanced / low-level maintenance commands",
-		Commands: []*cli.Command{
-			withBefore(cmdUSBPortReset(), withLoggerOnly()),
-			withBefore(cmdSetLevel(), withLoggerOnly()), // IMPORTANT: do NOT use withSession here
-
-		},
+func tagFilterFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Only include keys with this tag (key=value, repeatable)",
 	}
 }
 
-func cmdUSBPortReset() *cli.Command {
+func cmdTagKey() *cli.Command {
 	return &cli.Command{
-		Name:  "usb-port-reset",
-		Usage: "Force a USB port reset on the gadget (it will re-enumerate)",
+		Name:      "tag",
+		Usage:     "Set or remove tags on a key",
+		ArgsUsage: "<alias> [key=value ...]",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "remove",
+				Usage: "Tag key to remove (repeatable)",
+			},
+		},
 		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
-			serial := c.String("device") // global flag
-			if err := common.ResetDevice(serial, h.Log); err != nil {
+			b := h.Session.Broker
+
+			args := c.Args().Slice()
+			if len(args) < 1 {
+				return fmt.Errorf("usage: tag <alias> [key=value ...] [--remove key]")
+			}
+			keyID := strings.TrimSpace(args[0])
+			set, err := parseTags(args[1:])
+			if err != nil {
 				return err
 			}
+			remove := c.StringSlice("remove")
+			if len(set) == 0 && len(remove) == 0 {
+				return fmt.Errorf("nothing to do: provide key=value pairs or --remove")
+			}
 
-			fmt.Println("OK: USB reset sent. The device will re-enumerate.")
+			tags, err := common.ReqSetTags(b, keyID, set, remove)
+			if err != nil {
+				return err
+			}
 
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(tags)
+			}
+
+			labels := make([]string, 0, len(tags))
+			for k, v := range tags {
+				labels = append(labels, k+"="+v)
+			}
+			sort.Strings(labels)
+			if len(labels) == 0 {
+				fmt.Printf("OK: %s has no tags\n", keyID)
+				return nil
+			}
+			w, _, _ := term.GetSize(int(os.Stdout.Fd()))
+			fmt.Println(renderChips(labels, chipOkStyle, w))
 			return nil
 		},
 	}
 }
 
-func cmdSetLevel() *cli.Command {
+func cmdSetValidity() *cli.Command {
 	return &cli.Command{
-		Name:      "set-level",
-		Usage:     "Set level for a key alias (round will be reset to 0)",
-		ArgsUsage: "<alias> <level>",
+		Name:      "set-validity",
+		Usage:     "Restrict when an unlocked key may sign (no flags clears the window; requires master passphrase)",
+		ArgsUsage: "<alias>",
+		Flags: []cli.Flag{
+			&cli.TimestampFlag{
+				Name:   "not-before",
+				Usage:  "Refuse signatures before this time (RFC3339, gadget clock)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.TimestampFlag{
+				Name:   "not-after",
+				Usage:  "Refuse signatures at or after this time (RFC3339, gadget clock)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.Uint64Flag{
+				Name:  "min-level",
+				Usage: "Lowest level the key may sign (inclusive)",
+			},
+			&cli.Uint64Flag{
+				Name:  "max-level",
+				Usage: "Highest level the key may sign (inclusive)",
+			},
+		},
 		Action: func(ctx context.Context, c *cli.Command) error {
-			args := c.Args().Slice()
-			if len(args) < 2 {
-				return fmt.Errorf("usage: set-level <alias> <level>")
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			keyID := strings.TrimSpace(c.Args().First())
+			if keyID == "" {
+				return fmt.Errorf("usage: set-validity <alias> [--not-before T] [--not-after T] [--min-level N] [--max-level N]")
 			}
 
-			keyID := strings.TrimSpace(args[0])
-			lvlStr := strings.TrimSpace(args[1])
+			v := &signerpb.Validity{
+				MinLevel: c.Uint64("min-level"),
+				MaxLevel: c.Uint64("max-level"),
+			}
+			if c.IsSet("not-before") {
+				v.NotBefore = c.Timestamp("not-before").Unix()
+			}
+			if c.IsSet("not-after") {
+				v.NotAfter = c.Timestamp("not-after").Unix()
+			}
 
-			if keyID == "" {
-				return fmt.Errorf("empty alias")
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("set-validity: %w", err)
 			}
-			level, err := strconv.ParseUint(lvlStr, 10, 64)
+			defer secure.MemoryWipe(pass)
+
+			ok, err := common.ReqSetValidity(b, keyID, v, pass)
 			if err != nil {
-				return fmt.Errorf("invalid level %q: %w", lvlStr, err)
+				return err
 			}
+			if !ok {
+				return fmt.Errorf("set-validity failed")
+			}
+
+			fmt.Printf("OK: %s validity %s\n", keyID, formatValidity(v))
+			return nil
+		},
+	}
+}
+
+func cmdPoP() *cli.Command {
+	return &cli.Command{
+		Name:  "pop",
+		Usage: "Check or regenerate keys' proofs of possession",
+		Commands: []*cli.Command{
+			withBefore(cmdRegeneratePoP(), withSession(common.ChanMgmt)),
+		},
+	}
+}
 
+func cmdRegeneratePoP() *cli.Command {
+	return &cli.Command{
+		Name:      "regenerate",
+		Usage:     "Re-sign and store the PoP of unlocked keys, e.g. after meta.json damage",
+		ArgsUsage: "<alias1> [alias2 ...]",
+		Action: func(ctx context.Context, c *cli.Command) error {
 			h := mustHost(ctx)
-			l := h.Log
 
-			devSerial := c.String("device")
-			sess, err := common.Connect(common.ConnectParams{
-				Serial:  devSerial,
-				Logger:  l,
-				Channel: common.ChanMgmt,
-			})
-			if err != nil {
-				return err
+			keys := c.Args().Slice()
+			if len(keys) == 0 {
+				return fmt.Errorf("usage: pop regenerate <alias1> [alias2 ...]")
 			}
-			defer sess.Close()
 
-			b := sess.Broker
+			failed := 0
+			for _, keyID := range keys {
+				pk, err := common.ReqRegeneratePoP(h.Session.Broker, keyID)
+				if err == nil && !signer.VerifyPoP(pk.GetBlPubkey(), pk.GetPop()) {
+					err = fmt.Errorf("gadget returned a PoP that does not verify")
+				}
+				if err != nil {
+					fmt.Printf("FAIL id=%s  err=%v\n", keyID, err)
+					failed++
+					continue
+				}
+				fmt.Printf("OK   id=%s  tz4=%s  PoP=%s\n", pk.GetKeyId(), pk.GetTz4(), pk.GetPop())
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to regenerate %d/%d PoP(s)", failed, len(keys))
+			}
+			return nil
+		},
+	}
+}
 
-			ok, err := common.ReqSetLevel(b, keyID, level)
+func cmdPolicy() *cli.Command {
+	return &cli.Command{
+		Name:  "policy",
+		Usage: "Show or replace what a key may sign",
+		Commands: []*cli.Command{
+			withBefore(cmdShowPolicy(), withSession(common.ChanMgmt)),
+			withBefore(cmdSetPolicy(), withSession(common.ChanMgmt)),
+		},
+	}
+}
+
+func cmdShowPolicy() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "Show a key's policy",
+		ArgsUsage: "<alias>",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			keyID := strings.TrimSpace(c.Args().First())
+			if keyID == "" {
+				return fmt.Errorf("usage: policy show <alias>")
+			}
+
+			p, err := common.ReqGetPolicy(h.Session.Broker, keyID)
 			if err != nil {
 				return err
 			}
-			if !ok {
-				return fmt.Errorf("set-level failed: %v", err)
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(p)
 			}
 
-			fmt.Printf("OK: %s level set to %d (round reset to 0)\n", keyID, level)
+			fmt.Printf("%s: %s\n", keyID, formatPolicy(p))
 			return nil
 		},
 	}
 }
+
+func cmdSetPolicy() *cli.Command {
+	return &cli.Command{
+		Name:      "set",
+		Usage:     "Replace an unlocked key's policy, validity window included (no flags clears it; requires master passphrase)",
+		ArgsUsage: "<alias>",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "kind",
+				Usage: "Sign kind the key may sign, e.g. attestation (repeatable; none allows all)",
+			},
+			&cli.StringFlag{
+				Name:  "chain-id",
+				Usage: "Chain (Net…) the payloads must be for",
+			},
+			&cli.Uint32Flag{
+				Name:  "max-per-minute",
+				Usage: "Most signatures per minute (0 = unlimited)",
+			},
+			&cli.StringFlag{
+				Name:  "validation",
+				Usage: "Payload validation profile: standard or strict",
+				Value: "standard",
+			},
+			&cli.TimestampFlag{
+				Name:   "not-before",
+				Usage:  "Refuse signatures before this time (RFC3339, gadget clock)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.TimestampFlag{
+				Name:   "not-after",
+				Usage:  "Refuse signatures at or after this time (RFC3339, gadget clock)",
+				Config: cli.TimestampConfig{Layouts: []string{time.RFC3339}},
+			},
+			&cli.Uint64Flag{
+				Name:  "min-level",
+				Usage: "Lowest level the key may sign (inclusive)",
+			},
+			&cli.Uint64Flag{
+				Name:  "max-level",
+				Usage: "Highest level the key may sign (inclusive)",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			keyID := strings.TrimSpace(c.Args().First())
+			if keyID == "" {
+				return fmt.Errorf("usage: policy set <alias> [--kind K ...] [--chain-id Net…] [--max-per-minute N] [--validation strict] [validity flags]")
+			}
+
+			p := &signerpb.Policy{
+				AllowedKinds:      c.StringSlice("kind"),
+				ChainId:           strings.TrimSpace(c.String("chain-id")),
+				MaxSignsPerMinute: c.Uint32("max-per-minute"),
+				Validation:        strings.TrimSpace(c.String("validation")),
+				Validity: &signerpb.Validity{
+					MinLevel: c.Uint64("min-level"),
+					MaxLevel: c.Uint64("max-level"),
+				},
+			}
+			if c.IsSet("not-before") {
+				p.Validity.NotBefore = c.Timestamp("not-before").Unix()
+			}
+			if c.IsSet("not-after") {
+				p.Validity.NotAfter = c.Timestamp("not-after").Unix()
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("policy set: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+
+			stored, err := common.ReqSetPolicy(h.Session.Broker, keyID, p, pass)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("OK: %s policy %s\n", keyID, formatPolicy(stored))
+			return nil
+		},
+	}
+}
+
+func cmdWatermarks() *cli.Command {
+	return &cli.Command{
+		Name:  "watermarks",
+		Usage: "Show watermarks, or export and import authenticated snapshots",
+		Commands: []*cli.Command{
+			withBefore(cmdShowWatermarks(), withSession(common.ChanMgmt)),
+			withBefore(cmdExportWatermarks(), withSession(common.ChanMgmt)),
+			withBefore(cmdImportWatermarks(), withSession(common.ChanMgmt)),
+		},
+	}
+}
+
+func cmdShowWatermarks() *cli.Command {
+	return &cli.Command{
+		Name:      "show",
+		Usage:     "Show every watermark kind of unlocked keys",
+		ArgsUsage: "[alias ...] (all keys if none)",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			keys, err := common.ReqGetWatermarks(h.Session.Broker, c.Args().Slice())
+			if err != nil {
+				var re *common.RemoteError
+				if errors.As(err, &re) && re.Code == 1000 {
+					return fmt.Errorf("gadget does not support watermark queries; update required: %w", err)
+				}
+				return err
+			}
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(keys)
+			}
+
+			for _, k := range keys {
+				switch {
+				case k.GetStateCorrupted():
+					fmt.Printf("%s (%s): state corrupted\n", k.GetKeyId(), k.GetTz4())
+					continue
+				case k.GetLockState() != signerpb.LockState_UNLOCKED:
+					fmt.Printf("%s (%s): locked\n", k.GetKeyId(), k.GetTz4())
+					continue
+				}
+				lastUsed := "never"
+				if k.GetLastUsedUnix() != 0 {
+					lastUsed = time.Unix(k.GetLastUsedUnix(), 0).UTC().Format(time.RFC3339)
+				}
+				fmt.Printf("%s (%s): %d signatures, last %s, state seq %d\n", k.GetKeyId(), k.GetTz4(), k.GetSignCount(), lastUsed, k.GetSequence())
+				for _, w := range k.GetWatermarks() {
+					fmt.Printf("  %-16s 0x%02x  level %d round %d\n", w.GetKind(), w.GetKindId(), w.GetLevel(), w.GetRound())
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func cmdExportWatermarks() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "Export watermarks of all unlocked keys (requires master passphrase)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "out",
+				Aliases: []string{"o"},
+				Usage:   "Write the snapshot to this file instead of stdout",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("export watermarks: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+
+			res, err := common.ReqExportWatermarks(b, pass)
+			if err != nil {
+				return err
+			}
+			for _, id := range res.GetSkipped() {
+				fmt.Fprintf(os.Stderr, "skipped %s (locked or state corrupted)\n", id)
+			}
+
+			out := c.String("out")
+			if out == "" {
+				_, err := os.Stdout.Write(append(res.GetSnapshot(), '\n'))
+				return err
+			}
+			if err := os.WriteFile(out, res.GetSnapshot(), 0o600); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "OK: snapshot written to %s\n", out)
+			return nil
+		},
+	}
+}
+
+func cmdImportWatermarks() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "Raise watermarks of unlocked keys from a snapshot (never lowers; requires master passphrase)",
+		ArgsUsage: "<snapshot.json>",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			path := c.Args().First()
+			if path == "" {
+				return fmt.Errorf("usage: watermarks import <snapshot.json>")
+			}
+			snapshot, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("import watermarks: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+
+			res, err := common.ReqImportWatermarks(b, pass, snapshot)
+			if err != nil {
+				return err
+			}
+
+			if !isTTY(os.Stdout) {
+				out := make([]keyStateJSON, 0, len(res))
+				for _, r := range res {
+					o := keyStateJSON{ID: r.GetKeyId(), OK: r.GetOk()}
+					if o.ID == "" {
+						o.ID = r.GetTz4()
+					}
+					if !r.GetOk() {
+						o.Err = r.GetError()
+					}
+					out = append(out, o)
+				}
+				return json.NewEncoder(os.Stdout).Encode(out)
+			}
+
+			failed := 0
+			for _, r := range res {
+				switch {
+				case !r.GetOk():
+					fmt.Printf("FAIL tz4=%s  err=%s\n", r.GetTz4(), r.GetError())
+					failed++
+				case r.GetRaised():
+					fmt.Printf("OK   id=%s  raised\n", r.GetKeyId())
+				default:
+					fmt.Printf("OK   id=%s  already at or above snapshot\n", r.GetKeyId())
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to import %d/%d key(s)", failed, len(res))
+			}
+			return nil
+		},
+	}
+}
+
+func cmdKDF() *cli.Command {
+	return &cli.Command{
+		Name:  "kdf",
+		Usage: "Inspect or upgrade the master passphrase KDF parameters",
+		Commands: []*cli.Command{
+			withBefore(cmdKDFStatus(), withSession(common.ChanMgmt)),
+			withBefore(cmdUpgradeKDF(), withSession(common.ChanMgmt)),
+		},
+	}
+}
+
+type kdfStatusJSON struct {
+	Time                 uint32 `json:"time"`
+	MemoryKiB            uint32 `json:"memory_kib"`
+	Threads              uint32 `json:"threads"`
+	RecommendedTime      uint32 `json:"recommended_time"`
+	RecommendedMemoryKiB uint32 `json:"recommended_memory_kib"`
+	RecommendedThreads   uint32 `json:"recommended_threads"`
+	Weak                 bool   `json:"weak"`
+	Pending              bool   `json:"pending"`
+}
+
+func printKDFStatus(st *signerpb.KDFStatusResponse) {
+	fmt.Printf("current:     argon2id t=%d m=%dKiB p=%d\n", st.GetTime(), st.GetMemoryKib(), st.GetThreads())
+	fmt.Printf("recommended: argon2id t=%d m=%dKiB p=%d\n", st.GetRecommendedTime(), st.GetRecommendedMemoryKib(), st.GetRecommendedThreads())
+	switch {
+	case st.GetPending():
+		fmt.Println("An interrupted upgrade is pending; run `kdf upgrade` to complete it.")
+	case st.GetWeak():
+		fmt.Println("The current parameters are weaker than recommended; run `kdf upgrade`.")
+	}
+}
+
+func cmdKDFStatus() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Show the current and recommended master KDF parameters",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			st, err := common.ReqKDFStatus(h.Session.Broker)
+			if err != nil {
+				return err
+			}
+
+			if !isTTY(os.Stdout) {
+				return json.NewEncoder(os.Stdout).Encode(kdfStatusJSON{
+					Time:                 st.GetTime(),
+					MemoryKiB:            st.GetMemoryKib(),
+					Threads:              st.GetThreads(),
+					RecommendedTime:      st.GetRecommendedTime(),
+					RecommendedMemoryKiB: st.GetRecommendedMemoryKib(),
+					RecommendedThreads:   st.GetRecommendedThreads(),
+					Weak:                 st.GetWeak(),
+					Pending:              st.GetPending(),
+				})
+			}
+			printKDFStatus(st)
+			return nil
+		},
+	}
+}
+
+func cmdUpgradeKDF() *cli.Command {
+	return &cli.Command{
+		Name:  "upgrade",
+		Usage: "Re-wrap all keys and the seed under the recommended KDF parameters (requires master passphrase)",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			st, err := common.ReqKDFStatus(b)
+			if err != nil {
+				return err
+			}
+			if !st.GetWeak() && !st.GetPending() {
+				fmt.Fprintln(os.Stderr, "KDF parameters are already up to date.")
+				return nil
+			}
+			if isTTY(os.Stdout) {
+				printKDFStatus(st)
+				fmt.Println("Watermark snapshots exported before the upgrade will no longer verify.")
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("upgrade kdf: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+
+			confirm, err := obtainPassword("Confirm master passphrase to upgrade", false)
+			if err != nil {
+				return fmt.Errorf("upgrade kdf: %w", err)
+			}
+			defer secure.MemoryWipe(confirm)
+
+			if subtle.ConstantTimeCompare(pass, confirm) != 1 {
+				return fmt.Errorf("passphrases do not match")
+			}
+
+			upgraded, err := common.ReqUpgradeKDF(b, pass)
+			if err != nil {
+				return err
+			}
+			switch {
+			case upgraded:
+				fmt.Fprintln(os.Stderr, "OK: KDF upgraded.")
+			case st.GetPending():
+				fmt.Fprintln(os.Stderr, "OK: pending upgrade completed.")
+			default:
+				fmt.Fprintln(os.Stderr, "OK: nothing to upgrade.")
+			}
+			return nil
+		},
+	}
+}
+
+func cmdAdvanced() *cli.Command {
+	return &cli.Command{
+		Name:  "advanced",
+		Usage: "Advanced / low-level maintenance commands",
+		Commands: []*cli.Command{
+			withBefore(cmdUSBPortReset(), withLoggerOnly()),
+			withBefore(cmdSetWatermarkLevel(), withLoggerOnly()), // IMPORTANT: do NOT use withSession here
+			withBefore(cmdSetLogLevel(), withSession(common.ChanMgmt)),
+			withBefore(cmdUpdateApp(), withSession(common.ChanMgmt)),
+			cmdVerifyLog(), // offline
+
+		},
+	}
+}
+
+func cmdUSBPortReset() *cli.Command {
+	return &cli.Command{
+		Name:  "usb-port-reset",
+		Usage: "Force a USB port reset on the gadget (it will re-enumerate)",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			serial := c.String("device") // global flag
+			if err := common.ResetDevice(serial, h.Log); err != nil {
+				return err
+			}
+
+			fmt.Println("OK: USB reset sent. The device will re-enumerate.")
+
+			return nil
+		},
+	}
+}
+
+// cmdSetWatermarkLevel moves a key's watermark; for log verbosity see
+// cmdSetLogLevel.
+func cmdSetWatermarkLevel() *cli.Command {
+	return &cli.Command{
+		Name:      "set-level",
+		Aliases:   []string{"set-watermark-level"},
+		Usage:     "Set the watermark level for a key alias (round will be reset to 0)",
+		ArgsUsage: "<alias> <level>",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			args := c.Args().Slice()
+			if len(args) < 2 {
+				return fmt.Errorf("usage: set-level <alias> <level>")
+			}
+
+			keyID := strings.TrimSpace(args[0])
+			lvlStr := strings.TrimSpace(args[1])
+
+			if keyID == "" {
+				return fmt.Errorf("empty alias")
+			}
+			level, err := strconv.ParseUint(lvlStr, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid level %q: %w", lvlStr, err)
+			}
+
+			h := mustHost(ctx)
+			l := h.Log
+
+			devSerial := c.String("device")
+			transport, address := channelAddress(c, common.ChanMgmt)
+			sess, err := common.Connect(common.ConnectParams{
+				Serial:    devSerial,
+				Logger:    l,
+				Channel:   common.ChanMgmt,
+				Transport: transport,
+				Address:   address,
+			})
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+
+			b := sess.Broker
+
+			ok, err := common.ReqSetLevel(b, keyID, level)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("set-level failed: %v", err)
+			}
+
+			fmt.Printf("OK: %s level set to %d (round reset to 0)\n", keyID, level)
+			return nil
+		},
+	}
+}
+
+func cmdSetLogLevel() *cli.Command {
+	return &cli.Command{
+		Name:      "set-log-level",
+		Usage:     "Change the running gadget's log levels until it restarts (no spec: show them)",
+		ArgsUsage: "[spec]  e.g. debug, or broker=debug,keychain=info (module=default drops an override)",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			levels, err := common.ReqSetLogLevel(h.Session.Broker, strings.Join(c.Args().Slice(), ","))
+			if err != nil {
+				return err
+			}
+			fmt.Println(levels)
+			return nil
+		},
+	}
+}
+
+// updateChunkSize keeps each update_chunk request in one pooled broker frame.
+const updateChunkSize = 256 * 1024
+
+func cmdUpdateApp() *cli.Command {
+	return &cli.Command{
+		Name:  "update",
+		Usage: "Push a new signer binary to the gadget; it is installed on the next boot (requires master passphrase)",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "app",
+				Usage:    "Signer binary published with a release (<release>.tezsign), with its .minisig next to it",
+				Required: true,
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+			b := h.Session.Broker
+
+			binary, err := os.ReadFile(c.String("app"))
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			sum := sha256.Sum256(binary)
+			// the gadget installs only binaries signed by its release key
+			sig, err := os.ReadFile(c.String("app") + minisign.SignatureExt)
+			if err != nil {
+				return fmt.Errorf("update: the gadget takes only signed binaries: %w", err)
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+
+			if err := common.ReqBeginUpdate(b, pass, int64(len(binary)), sum[:], sig); err != nil {
+				return err
+			}
+			tty := isTTY(os.Stderr)
+			for off := 0; off < len(binary); off += updateChunkSize {
+				received, err := common.ReqUpdateChunk(b, int64(off), binary[off:min(off+updateChunkSize, len(binary))])
+				if err != nil {
+					return fmt.Errorf("update: upload stopped at %d of %d bytes: %w", off, len(binary), err)
+				}
+				if tty {
+					fmt.Fprintf(os.Stderr, "\rUploading %s: %d%%", filepath.Base(c.String("app")), received*100/int64(len(binary)))
+				}
+			}
+			if tty {
+				fmt.Fprintln(os.Stderr)
+			}
+			if err := common.ReqCommitUpdate(b); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "OK: update staged (sha256 %s); power-cycle the gadget to install it.\n", hex.EncodeToString(sum[:]))
+			return nil
+		},
+	}
+}
+
+func cmdVerifyLog() *cli.Command {
+	return &cli.Command{
+		Name:      "verify-log",
+		Usage:     "Verify the hash chain (LOG_CHAIN=1) of log files, rotated .gz backups included",
+		ArgsUsage: "<file...>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "pubkey",
+				Usage: "hex ed25519 key that must have signed every checkpoint (the key= of LOG_CHAIN_START)",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("usage: advanced verify-log [--pubkey hex] <file...>")
+			}
+			var pub ed25519.PublicKey
+			if s := strings.TrimSpace(c.String("pubkey")); s != "" {
+				b, err := hex.DecodeString(s)
+				if err != nil || len(b) != ed25519.PublicKeySize {
+					return fmt.Errorf("--pubkey: want %d hex-encoded bytes", ed25519.PublicKeySize)
+				}
+				pub = b
+			}
+
+			failed := 0
+			for _, path := range c.Args().Slice() {
+				rep, err := logging.VerifyChainFile(path, pub)
+				if err != nil {
+					failed++
+					fmt.Printf("%s: FAIL after %d records: %v\n", path, rep.Records, err)
+					continue
+				}
+				note := ""
+				if rep.Anchored {
+					note = " (starts mid-chain)"
+				}
+				fmt.Printf("%s: OK %d records, %d starts, %d checkpoints (%d signed)%s\n", path, rep.Records, rep.Starts, rep.Checkpoints, rep.Signed, note)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files failed verification", failed, c.Args().Len())
+			}
+			return nil
+		},
+	}
+}
+
+func cmdBLS() *cli.Command {
+	return &cli.Command{
+		Name:  "bls",
+		Usage: "Offline BLS helpers: aggregate signatures and keys, verify aggregates",
+		Commands: []*cli.Command{
+			cmdBLSAggregate(),
+			cmdBLSAggregateKeys(),
+			cmdBLSVerifyAggregate(),
+		},
+	}
+}
+
+func cmdBLSAggregate() *cli.Command {
+	return &cli.Command{
+		Name:      "aggregate",
+		Usage:     "Combine BLS signatures into one",
+		ArgsUsage: "<BLsig...> [BLsig...]",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("usage: bls aggregate <BLsig...> [BLsig...]")
+			}
+			sigs, err := decodeAll(c.Args().Slice(), signer.DecodeBLSignature)
+			if err != nil {
+				return err
+			}
+			agg, err := signer.AggregateCompressed(sigs)
+			if err != nil {
+				return err
+			}
+			out, err := signer.EncodeBLSignature(agg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(out)
+			return nil
+		},
+	}
+}
+
+func cmdBLSAggregateKeys() *cli.Command {
+	return &cli.Command{
+		Name:      "aggregate-keys",
+		Usage:     "Combine BLS public keys into the key of their same-message aggregate",
+		ArgsUsage: "<BLpk...> [BLpk...]",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("usage: bls aggregate-keys <BLpk...> [BLpk...]")
+			}
+			pks, err := decodeAll(c.Args().Slice(), signer.DecodeBLPubkey)
+			if err != nil {
+				return err
+			}
+			agg, err := signer.AggregatePublicKeysCompressed(pks)
+			if err != nil {
+				return err
+			}
+			out, err := signer.EncodeBLPubkey(agg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(out)
+			return nil
+		},
+	}
+}
+
+func cmdBLSVerifyAggregate() *cli.Command {
+	return &cli.Command{
+		Name:      "verify-aggregate",
+		Usage:     "Check an aggregate signature against its signers' keys and messages",
+		ArgsUsage: "<BLsig…>",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:     "pk",
+				Usage:    "Signer public key, BLpk… (repeatable, in signing order)",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "pop",
+				Usage:    "Proof of possession of each --pk, BLsig… (repeatable, same order, as status shows it)",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "msg",
+				Usage:    "Signed bytes as hex, watermark included; once for all signers or once per --pk",
+				Required: true,
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("usage: bls verify-aggregate <BLsig…> --pk BLpk… --pop BLsig… [--pk ... --pop ...] --msg HEX [--msg ...]")
+			}
+			sig, err := signer.DecodeBLSignature(c.Args().First())
+			if err != nil {
+				return err
+			}
+			pks, err := decodeAll(c.StringSlice("pk"), signer.DecodeBLPubkey)
+			if err != nil {
+				return err
+			}
+			pops, err := decodeAll(c.StringSlice("pop"), signer.DecodeBLSignature)
+			if err != nil {
+				return err
+			}
+			msgs, err := decodeAll(c.StringSlice("msg"), func(s string) ([]byte, error) {
+				return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+			})
+			if err != nil {
+				return err
+			}
+
+			// without a PoP, a key can be made up to cancel out the
+			// others and forge the aggregate
+			if len(pops) != len(pks) {
+				return fmt.Errorf("got %d --pop for %d --pk; pass the proof of possession of every key", len(pops), len(pks))
+			}
+			for i, pk := range c.StringSlice("pk") {
+				if !signer.VerifyPoPCompressed(pks[i], pops[i]) {
+					return fmt.Errorf("proof of possession of %s does NOT verify", pk)
+				}
+			}
+
+			var ok bool
+			switch len(msgs) {
+			case 1:
+				ok = signer.FastAggregateVerifyCompressed(pks, sig, msgs[0])
+			case len(pks):
+				ok = signer.AggregateVerifyCompressed(pks, pops, sig, msgs)
+			default:
+				return fmt.Errorf("got %d --msg for %d --pk; pass one message or one per key", len(msgs), len(pks))
+			}
+			if !ok {
+				return fmt.Errorf("aggregate signature does NOT verify")
+			}
+			fmt.Println("OK: aggregate signature verifies")
+			return nil
+		},
+	}
+}
+
+// decodeAll applies decode to each of in, naming the first one it rejects.
+func decodeAll(in []string, decode func(string) ([]byte, error)) ([][]byte, error) {
+	out := make([][]byte, 0, len(in))
+	for _, s := range in {
+		b, err := decode(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// thresholdShares is what `threshold split` writes and `threshold import`
+// reads. It holds every share secret: keep it offline and destroy it once
+// each share is on its device.
+type thresholdShares struct {
+	GroupPubkey string           `json:"group_pubkey"`
+	GroupTZ4    string           `json:"group_tz4"`
+	GroupPoP    string           `json:"group_pop"`
+	Threshold   uint32           `json:"threshold"`
+	Total       uint32           `json:"total"`
+	Shares      []thresholdShare `json:"shares"`
+}
+
+type thresholdShare struct {
+	Index     uint32 `json:"index"`
+	BLPubkey  string `json:"bl_pubkey"`
+	TZ4       string `json:"tz4"`
+	SecretKey string `json:"secret_key"` // BLsk…
+}
+
+func cmdThreshold() *cli.Command {
+	return &cli.Command{
+		Name:  "threshold",
+		Usage: "M-of-N BLS keys: split a key into shares, import shares, combine partial signatures",
+		Commands: []*cli.Command{
+			cmdThresholdSplit(),
+			withBefore(cmdThresholdImport(), withSession(common.ChanMgmt)),
+			cmdThresholdCombine(),
+			cmdThresholdGroupKey(),
+		},
+	}
+}
+
+func cmdThresholdSplit() *cli.Command {
+	return &cli.Command{
+		Name:  "split",
+		Usage: "Create (or split an existing BLsk) group key into shares, offline; writes every share secret",
+		Flags: []cli.Flag{
+			&cli.Uint32Flag{Name: "threshold", Aliases: []string{"m"}, Usage: "Shares needed to sign", Required: true},
+			&cli.Uint32Flag{Name: "shares", Aliases: []string{"n"}, Usage: "Shares to create", Required: true},
+			&cli.BoolFlag{Name: "from-secret", Usage: "Split an existing key, prompted for as BLsk…, instead of a new one"},
+			&cli.StringFlag{Name: "out", Usage: "Write the shares here (0600) instead of stdout"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			var secretKey *signer.SecretKey
+			if c.Bool("from-secret") {
+				blsk, err := obtainPassword("Secret key to split (BLsk…)", false)
+				if err != nil {
+					return fmt.Errorf("threshold split: %w", err)
+				}
+				defer secure.MemoryWipe(blsk)
+				if secretKey, err = signer.ImportBLSecretKey(string(blsk)); err != nil {
+					return fmt.Errorf("threshold split: %w", err)
+				}
+			} else {
+				secretKey, _, _ = signer.GenerateRandomKey()
+			}
+			defer secretKey.Zeroize()
+
+			groupPK, groupBLpk := signer.PublicKeyFromSecret(secretKey)
+			groupTZ4, _ := signer.Tz4FromBLPubkeyBytes(groupPK)
+			_, groupPoP, err := signer.SignPoPCompressed(secretKey, groupPK)
+			if err != nil {
+				return err
+			}
+
+			shares, err := signer.SplitSecretKey(secretKey, c.Uint32("threshold"), c.Uint32("shares"))
+			if err != nil {
+				return fmt.Errorf("threshold split: %w", err)
+			}
+			out := thresholdShares{
+				GroupPubkey: groupBLpk,
+				GroupTZ4:    groupTZ4,
+				GroupPoP:    groupPoP,
+				Threshold:   c.Uint32("threshold"),
+				Total:       c.Uint32("shares"),
+			}
+			for _, s := range shares {
+				pk, blpk := signer.PublicKeyFromSecret(s.Secret)
+				tz4, _ := signer.Tz4FromBLPubkeyBytes(pk)
+				out.Shares = append(out.Shares, thresholdShare{
+					Index:     s.Index,
+					BLPubkey:  blpk,
+					TZ4:       tz4,
+					SecretKey: signer.EncodeBLSecretKey(s.Secret),
+				})
+				s.Secret.Zeroize()
+			}
+
+			data, err := json.MarshalIndent(out, "", "  ")
+			if err != nil {
+				return err
+			}
+			data = append(data, '\n')
+			if path := c.String("out"); path != "" {
+				if err := os.WriteFile(path, data, 0o600); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "OK: %d-of-%d shares of %s written to %s\n", out.Threshold, out.Total, groupTZ4, path)
+				return nil
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+}
+
+func cmdThresholdImport() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "Store one share from a `threshold split` file as a key on the device",
+		ArgsUsage: "[alias]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "file", Usage: "Shares file written by `threshold split`", Required: true},
+			&cli.Uint32Flag{Name: "index", Usage: "Share to import (1-based)", Required: true},
+			&cli.BoolFlag{Name: "key-passphrase", Usage: "Protect the share with an additional per-key passphrase required at unlock"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			h := mustHost(ctx)
+
+			data, err := os.ReadFile(c.String("file"))
+			if err != nil {
+				return err
+			}
+			defer secure.MemoryWipe(data)
+			var file thresholdShares
+			if err := json.Unmarshal(data, &file); err != nil {
+				return fmt.Errorf("threshold import: %s: %w", c.String("file"), err)
+			}
+			share, ok := lo.Find(file.Shares, func(s thresholdShare) bool { return s.Index == c.Uint32("index") })
+			if !ok {
+				return fmt.Errorf("threshold import: no share %d in %s", c.Uint32("index"), c.String("file"))
+			}
+
+			pass, err := obtainPassword("Master passphrase", false)
+			if err != nil {
+				return fmt.Errorf("threshold import: %w", err)
+			}
+			defer secure.MemoryWipe(pass)
+			var keyPass []byte
+			if c.Bool("key-passphrase") {
+				if keyPass, err = obtainConfirmedPassword("Key passphrase"); err != nil {
+					return fmt.Errorf("threshold import: %w", err)
+				}
+				defer secure.MemoryWipe(keyPass)
+			}
+
+			r, err := common.ReqImportKeyShare(h.Session.Broker, strings.TrimSpace(c.Args().First()), share.SecretKey, &signerpb.ThresholdShare{
+				GroupPubkey: file.GroupPubkey,
+				GroupTz4:    file.GroupTZ4,
+				Index:       share.Index,
+				Threshold:   file.Threshold,
+				Total:       file.Total,
+			}, pass, keyPass)
+			if err != nil {
+				return err
+			}
+			if !r.GetOk() {
+				return fmt.Errorf("threshold import: %s", r.GetError())
+			}
+			if r.GetTz4() != share.TZ4 {
+				return fmt.Errorf("threshold import: device reports %s for share %d, file says %s", r.GetTz4(), share.Index, share.TZ4)
+			}
+			fmt.Printf("OK   id=%s  tz4=%s  share=%d/%d of %s\n", r.GetKeyId(), r.GetTz4(), share.Index, file.Total, file.GroupTZ4)
+			return nil
+		},
+	}
+}
+
+func cmdThresholdCombine() *cli.Command {
+	return &cli.Command{
+		Name:      "combine",
+		Usage:     "Combine threshold partial signatures into the group signature, offline",
+		ArgsUsage: "<index:BLsig…> [index:BLsig…]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "group-pubkey", Usage: "Verify the result against this BLpk…"},
+			&cli.StringFlag{Name: "msg", Usage: "Signed bytes as hex, watermark included (with --group-pubkey)"},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("usage: threshold combine <index:BLsig…> [index:BLsig…] [--group-pubkey BLpk… --msg HEX]")
+			}
+			indices, sigs, err := parseIndexed(c.Args().Slice(), signer.DecodeBLSignature)
+			if err != nil {
+				return err
+			}
+			combined, err := signer.CombineSignatures(indices, sigs)
+			if err != nil {
+				return err
+			}
+
+			if groupPK := strings.TrimSpace(c.String("group-pubkey")); groupPK != "" {
+				pk, err := signer.DecodeBLPubkey(groupPK)
+				if err != nil {
+					return err
+				}
+				msg, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(c.String("msg")), "0x"))
+				if err != nil || len(msg) == 0 {
+					return fmt.Errorf("--group-pubkey needs --msg with the signed bytes as hex")
+				}
+				if !signer.VerifyCompressed(pk, combined, msg) {
+					return fmt.Errorf("combined signature does NOT verify under %s (too few or wrong partials?)", groupPK)
+				}
+			}
+
+			out, err := signer.EncodeBLSignature(combined)
+			if err != nil {
+				return err
+			}
+			fmt.Println(out)
+			return nil
+		},
+	}
+}
+
+func cmdThresholdGroupKey() *cli.Command {
+	return &cli.Command{
+		Name:      "group-key",
+		Usage:     "Recover the group key from threshold share keys, e.g. to check devices hold shares of one key",
+		ArgsUsage: "<index:BLpk…> [index:BLpk…]",
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return fmt.Errorf("usage: threshold group-key <index:BLpk…> [index:BLpk…]")
+			}
+			indices, pks, err := parseIndexed(c.Args().Slice(), signer.DecodeBLPubkey)
+			if err != nil {
+				return err
+			}
+			group, err := signer.CombinePublicKeys(indices, pks)
+			if err != nil {
+				return err
+			}
+			blpk, err := signer.EncodeBLPubkey(group)
+			if err != nil {
+				return err
+			}
+			tz4, _ := signer.Tz4FromBLPubkeyBytes(group)
+			fmt.Printf("%s %s\n", blpk, tz4)
+			return nil
+		},
+	}
+}
+
+// parseIndexed splits "index:value" arguments and decodes each value.
+func parseIndexed(args []string, decode func(string) ([]byte, error)) ([]uint32, [][]byte, error) {
+	indices := make([]uint32, 0, len(args))
+	values := make([]string, 0, len(args))
+	for _, a := range args {
+		idx, val, ok := strings.Cut(strings.TrimSpace(a), ":")
+		n, err := strconv.ParseUint(idx, 10, 32)
+		if !ok || err != nil {
+			return nil, nil, fmt.Errorf("%q: want index:value", a)
+		}
+		indices = append(indices, uint32(n))
+		values = append(values, val)
+	}
+	decoded, err := decodeAll(values, decode)
+	if err != nil {
+		return nil, nil, err
+	}
+	return indices, decoded, nil
+}
@@ -6,10 +6,20 @@ const (
 	envDevice = "TEZSIGN_DEVICE"
 	envKeys   = "TEZSIGN_UNLOCK_KEYS"
 	envPass   = "TEZSIGN_UNLOCK_PASS"
+	// comma separated rpc=duration pairs, see --rpc-timeout
+	envRPCTimeouts = "TEZSIGN_RPC_TIMEOUTS"
+	// the gadget's transport and, for tcp and unix, each channel's address
+	envTransport   = "TEZSIGN_TRANSPORT"
+	envSignAddress = "TEZSIGN_SIGN_ADDRESS"
+	envMgmtAddress = "TEZSIGN_MGMT_ADDRESS"
 
 	logFileName = "host.log"
 
 	defaultPort = "20090"
 
 	minKeepAlive = 10 * time.Millisecond
+
+	defaultHeartbeat       = 1 * time.Second
+	defaultHeartbeatMisses = 3
+	minHeartbeat           = 100 * time.Millisecond
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
@@ -31,20 +32,53 @@ func main() {
 				Usage:   "USB serial to select (if multiple gadgets present)",
 				Sources: cli.EnvVars(envDevice),
 			},
+			&cli.StringSliceFlag{
+				Name:    "rpc-timeout",
+				Usage:   "override a request timeout as rpc=duration, e.g. sign=2s or unlock=1m (repeatable)",
+				Sources: cli.EnvVars(envRPCTimeouts),
+			},
+			&cli.StringFlag{
+				Name:    "transport",
+				Usage:   fmt.Sprintf("how to reach the gadget: %s (tcp and unix reach tezsign-emulator)", strings.Join(common.Transports(), ", ")),
+				Value:   common.TransportUSB,
+				Sources: cli.EnvVars(envTransport),
+			},
+			&cli.StringFlag{
+				Name:    "sign-address",
+				Usage:   "address of the sign channel for the tcp and unix transports",
+				Sources: cli.EnvVars(envSignAddress),
+			},
+			&cli.StringFlag{
+				Name:    "mgmt-address",
+				Usage:   "address of the management channel for the tcp and unix transports",
+				Sources: cli.EnvVars(envMgmtAddress),
+			},
 		},
-		After: closeSession,
+		Before: applyRPCTimeouts,
+		After:  closeSession,
 		Commands: []*cli.Command{
 			withBefore(cmdListDevices(), withLoggerOnly()), // no session needed
 			withBefore(cmdVersion(), withSession(common.ChanMgmt)),
+			withBefore(cmdInfo(), withSession(common.ChanMgmt)),
+			withBefore(cmdTime(), withSession(common.ChanMgmt)),
 			withBefore(cmdRun(), withSession(common.ChanSign)),  // signer interface
 			withBefore(cmdInit(), withSession(common.ChanMgmt)), // mgmt interface
 			withBefore(cmdList(), withSession(common.ChanMgmt)),
 			withBefore(cmdNewKeys(), withSession(common.ChanMgmt)),
 			withBefore(cmdStatus(), withSession(common.ChanMgmt)),
 			withBefore(cmdLogs(), withSession(common.ChanMgmt)),
+			withBefore(cmdEntropy(), withSession(common.ChanMgmt)),
 			withBefore(cmdUnlockKeys(), withSession(common.ChanMgmt)),
 			withBefore(cmdLockKeys(), withSession(common.ChanMgmt)),
 			withBefore(cmdDeleteKeys(), withSession(common.ChanMgmt)),
+			withBefore(cmdTagKey(), withSession(common.ChanMgmt)),
+			withBefore(cmdSetValidity(), withSession(common.ChanMgmt)),
+			cmdPolicy(),
+			cmdPoP(),
+			cmdWatermarks(),
+			cmdKDF(),
+			cmdBLS(), // offline, no session
+			cmdThreshold(),
 
 			cmdAdvanced(),
 		},
@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -23,7 +25,46 @@ type tz4CacheEntry struct {
 	pop       string
 }
 
-func buildFiberApp(getB func() *broker.Broker, allowedTZ4 map[string]struct{}, cache map[string]tz4CacheEntry) *fiber.App {
+// tz4Cache holds the identities served by /keys and /bls_prove_possession.
+// What run did not prefill is fetched with GetPublicKey on first use.
+type tz4Cache struct {
+	mu      sync.RWMutex
+	entries map[string]tz4CacheEntry
+}
+
+func (tc *tz4Cache) get(b *broker.Broker, tz4 string) (tz4CacheEntry, error) {
+	tc.mu.RLock()
+	entry, ok := tc.entries[tz4]
+	tc.mu.RUnlock()
+	if ok {
+		return entry, nil
+	}
+
+	pk, err := common.ReqGetPublicKey(b, tz4)
+	if err != nil {
+		return tz4CacheEntry{}, err
+	}
+	entry = tz4CacheEntry{publicKey: pk.GetBlPubkey(), pop: pk.GetPop()}
+
+	tc.mu.Lock()
+	tc.entries[tz4] = entry
+	tc.mu.Unlock()
+	return entry, nil
+}
+
+func keyLookupError(c *fiber.Ctx, err error) error {
+	if re, ok := err.(*common.RemoteError); ok && re.Code == common.RpcKeyNotFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+func buildFiberApp(getB func() *broker.Broker, allowedTZ4 map[string]struct{}, cached map[string]tz4CacheEntry) *fiber.App {
+	if cached == nil {
+		cached = make(map[string]tz4CacheEntry)
+	}
+	cache := &tz4Cache{entries: cached}
+
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: true,
 		ReadTimeout:           10 * time.Second,
@@ -64,9 +105,9 @@ func buildFiberApp(getB func() *broker.Broker, allowedTZ4 map[string]struct{}, c
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
 		}
 
-		entry, ok := cache[tz4]
-		if !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
+		entry, err := cache.get(getB(), tz4)
+		if err != nil {
+			return keyLookupError(c, err)
 		}
 
 		return c.JSON(fiber.Map{"public_key": entry.publicKey})
@@ -85,9 +126,9 @@ func buildFiberApp(getB func() *broker.Broker, allowedTZ4 map[string]struct{}, c
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
 		}
 
-		entry, ok := cache[tz4]
-		if !ok {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found"})
+		entry, err := cache.get(getB(), tz4)
+		if err != nil {
+			return keyLookupError(c, err)
 		}
 
 		return c.JSON(fiber.Map{"bls_prove_possession": entry.pop})
@@ -127,10 +168,22 @@ func buildFiberApp(getB func() *broker.Broker, allowedTZ4 map[string]struct{}, c
 					return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": re.Msg})
 				case common.RpcBadPayload:
 					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": re.Msg})
+				case common.RpcOutsideValidity, common.RpcPolicyRefused:
+					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": re.Msg})
+				case common.RpcRateLimited:
+					return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": re.Msg})
+				case common.RpcSelfTestFailed:
+					return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": re.Msg})
 				default:
 					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": re.Msg})
 				}
 			}
+			switch {
+			case errors.Is(err, broker.ErrPeerOverloaded):
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, broker.ErrPeerTooLarge):
+				return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 		}
 
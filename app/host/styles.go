@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"os"
 	"sort"
@@ -13,6 +15,8 @@ import (
 	"github.com/charmbracelet/x/term"
 	"github.com/tez-capital/tezsign/broker"
 	"github.com/tez-capital/tezsign/common"
+	"github.com/tez-capital/tezsign/secure"
+	"github.com/tez-capital/tezsign/signer"
 	"github.com/tez-capital/tezsign/signerpb"
 )
 
@@ -23,18 +27,25 @@ type keyStateJSON struct {
 }
 
 type keyStatusJSON struct {
-	ID                   string `json:"id"`
-	LockState            string `json:"lock_state"`
-	TZ4                  string `json:"tz4"`
-	BLPubkey             string `json:"bl_pubkey"`
-	Pop                  string `json:"pop"`
-	LastBlockLevel       uint64 `json:"last_block_level"`
-	LastBlockRound       uint32 `json:"last_block_round"`
-	LastPreattestLevel   uint64 `json:"last_preattestation_level"`
-	LastPreattestRound   uint32 `json:"last_preattestation_round"`
-	LastAttestationLevel uint64 `json:"last_attestation_level"`
-	LastAttestationRound uint32 `json:"last_attestation_round"`
-	StateCorrupted       bool   `json:"state_corrupted"`
+	ID                   string             `json:"id"`
+	LockState            string             `json:"lock_state"`
+	TZ4                  string             `json:"tz4"`
+	BLPubkey             string             `json:"bl_pubkey"`
+	Pop                  string             `json:"pop"`
+	PopValid             bool               `json:"pop_valid"`
+	LastBlockLevel       uint64             `json:"last_block_level"`
+	LastBlockRound       uint32             `json:"last_block_round"`
+	LastPreattestLevel   uint64             `json:"last_preattestation_level"`
+	LastPreattestRound   uint32             `json:"last_preattestation_round"`
+	LastAttestationLevel uint64             `json:"last_attestation_level"`
+	LastAttestationRound uint32             `json:"last_attestation_round"`
+	StateCorrupted       bool               `json:"state_corrupted"`
+	KeyPassphrase        bool               `json:"key_passphrase,omitempty"`
+	Tags                 map[string]string  `json:"tags,omitempty"`
+	Validity             *signerpb.Validity `json:"validity,omitempty"`
+	DerivationPath       string             `json:"derivation_path,omitempty"`
+	SignCount            uint64             `json:"sign_count,omitempty"`
+	LastUsedUnix         int64              `json:"last_used_unix,omitempty"`
 }
 
 func getKeysStatusJSON(ks *signerpb.KeyStatus) keyStatusJSON {
@@ -44,6 +55,7 @@ func getKeysStatusJSON(ks *signerpb.KeyStatus) keyStatusJSON {
 		TZ4:                  ks.GetTz4(),
 		BLPubkey:             ks.GetBlPubkey(),
 		Pop:                  ks.GetPop(),
+		PopValid:             signer.VerifyPoP(ks.GetBlPubkey(), ks.GetPop()),
 		LastBlockLevel:       ks.GetLastBlockLevel(),
 		LastBlockRound:       ks.GetLastBlockRound(),
 		LastPreattestLevel:   ks.GetLastPreattestationLevel(),
@@ -51,6 +63,12 @@ func getKeysStatusJSON(ks *signerpb.KeyStatus) keyStatusJSON {
 		LastAttestationLevel: ks.GetLastAttestationLevel(),
 		LastAttestationRound: ks.GetLastAttestationRound(),
 		StateCorrupted:       ks.GetStateCorrupted(),
+		KeyPassphrase:        ks.GetKeyPassphrase(),
+		Tags:                 ks.GetTags(),
+		Validity:             ks.GetValidity(),
+		DerivationPath:       ks.GetDerivationPath(),
+		SignCount:            ks.GetSignCount(),
+		LastUsedUnix:         ks.GetLastUsedUnix(),
 	}
 }
 
@@ -159,6 +177,53 @@ func obtainPassword(prompt string, withEnv bool) ([]byte, error) {
 	return nil, ErrEmptyPassphrase
 }
 
+// obtainConfirmedPassword prompts twice for a new passphrase.
+func obtainConfirmedPassword(prompt string) ([]byte, error) {
+	pass, err := obtainPassword(prompt, false)
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := obtainPassword("Confirm "+strings.ToLower(prompt[:1])+prompt[1:], false)
+	if err != nil {
+		secure.MemoryWipe(pass)
+		return nil, err
+	}
+	defer secure.MemoryWipe(confirm)
+	if subtle.ConstantTimeCompare(pass, confirm) != 1 {
+		secure.MemoryWipe(pass)
+		return nil, errors.New("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// obtainKeyPassphrases prompts for the per-key passphrase of every selected key
+// that has one. Keys left without one are reported as failed by the gadget.
+func obtainKeyPassphrases(statuses []*signerpb.KeyStatus, keys []string) (map[string][]byte, error) {
+	selected := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		selected[k] = true
+	}
+
+	out := map[string][]byte{}
+	for _, ks := range statuses {
+		if !ks.GetKeyPassphrase() || !selected[ks.GetKeyId()] {
+			continue
+		}
+		kp, err := obtainPassword(fmt.Sprintf("Key passphrase for %s", ks.GetKeyId()), false)
+		if err != nil {
+			if errors.Is(err, ErrEmptyPassphrase) {
+				continue
+			}
+			for _, v := range out {
+				secure.MemoryWipe(v)
+			}
+			return nil, err
+		}
+		out[ks.GetKeyId()] = kp
+	}
+	return out, nil
+}
+
 // renderAliasChips lays out multi-line “chips” with the provided style and wraps by terminal width.
 func renderChips(labels []string, style lipgloss.Style, maxWidth int) string {
 	if maxWidth < 30 {
@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/tez-capital/tezsign/broker"
 	"github.com/tez-capital/tezsign/common"
 	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/signerpb"
 	"github.com/urfave/cli/v3"
 )
 
@@ -38,6 +42,79 @@ func resolveKeysFromEnvOrArgs(args []string) []string {
 	return nil
 }
 
+// parseTags parses key=value pairs as given to `tag` and `--tag`.
+func parseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid tag %q (expected key=value)", pair)
+		}
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+func matchesTags(ks *signerpb.KeyStatus, filter map[string]string) bool {
+	tags := ks.GetTags()
+	for k, v := range filter {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func formatValidity(v *signerpb.Validity) string {
+	bound := func(set bool, s string) string {
+		if !set {
+			return "-"
+		}
+		return s
+	}
+
+	if v.GetNotBefore() == 0 && v.GetNotAfter() == 0 && v.GetMinLevel() == 0 && v.GetMaxLevel() == 0 {
+		return "unrestricted"
+	}
+	return fmt.Sprintf("time=[%s, %s) level=[%s, %s]",
+		bound(v.GetNotBefore() != 0, time.Unix(v.GetNotBefore(), 0).UTC().Format(time.RFC3339)),
+		bound(v.GetNotAfter() != 0, time.Unix(v.GetNotAfter(), 0).UTC().Format(time.RFC3339)),
+		bound(v.GetMinLevel() != 0, strconv.FormatUint(v.GetMinLevel(), 10)),
+		bound(v.GetMaxLevel() != 0, strconv.FormatUint(v.GetMaxLevel(), 10)),
+	)
+}
+
+// popNote flags a stored PoP that does not verify against the key's BLpk.
+func popNote(blPubkey, pop string) string {
+	if signer.VerifyPoP(blPubkey, pop) {
+		return ""
+	}
+	return "  (INVALID; run `pop regenerate` on the unlocked key)"
+}
+
+func formatPolicy(p *signerpb.Policy) string {
+	parts := []string{}
+	if kinds := p.GetAllowedKinds(); len(kinds) > 0 {
+		parts = append(parts, "kinds="+strings.Join(kinds, ","))
+	}
+	if p.GetChainId() != "" {
+		parts = append(parts, "chain="+p.GetChainId())
+	}
+	if p.GetMaxSignsPerMinute() > 0 {
+		parts = append(parts, fmt.Sprintf("rate=%d/min", p.GetMaxSignsPerMinute()))
+	}
+	if v := p.GetValidation(); v != "" && v != "standard" {
+		parts = append(parts, "validation="+v)
+	}
+	if v := formatValidity(p.GetValidity()); v != "unrestricted" {
+		parts = append(parts, v)
+	}
+	if len(parts) == 0 {
+		return "unrestricted"
+	}
+	return strings.Join(parts, " ")
+}
+
 func mustHost(ctx context.Context) *HostContext {
 	v := ctx.Value(hostCtxKey{})
 	if v == nil {
@@ -57,15 +134,16 @@ func runWatchdog(ctx context.Context, curRef *atomic.Value, initial *HostContext
 		case <-ticker.C:
 			v := curRef.Load().(*cur)
 
-			// Probe EP0 vendor ready
-			idx := uint16(0)
-			if v.sess != nil && v.sess.Intf != nil {
-				idx = uint16(v.sess.Intf.Setting.Number)
-			}
-			ok, err := common.VendorReadyInInterface(v.sess.Dev, common.VendorReqReady, idx, v.sess.Log)
-
+			// Probe EP0 vendor ready (or the stream, off USB)
+			ok, err := v.sess.Ready()
 			if ok && err == nil {
-				continue
+				// the endpoint answers; make sure the gadget behind it does too
+				select {
+				case <-v.sess.Broker.Dead():
+					err = broker.ErrPeerDead
+				default:
+					continue
+				}
 			}
 
 			// Not ready or errored → handle policy
@@ -93,6 +171,12 @@ func runWatchdog(ctx context.Context, curRef *atomic.Value, initial *HostContext
 				Logger:    initial.Log,
 				Channel:   oldSess.Channel,
 				KeepAlive: oldSess.KeepAlive,
+
+				Heartbeat:       oldSess.Heartbeat,
+				HeartbeatMisses: oldSess.HeartbeatMisses,
+
+				Transport: oldSess.Transport,
+				Address:   oldSess.Address,
 			}
 			oldSess.Close()
 
@@ -101,11 +185,25 @@ func runWatchdog(ctx context.Context, curRef *atomic.Value, initial *HostContext
 				return rerr
 			}
 			v.sess.Log.Info("reconnected", slog.String("serial", s.Serial))
+			syncGadgetClock(s)
 			curRef.Store(&cur{sess: s})
 		}
 	}
 }
 
+// syncGadgetClock gives the RTC-less gadget our time, so its logs and key
+// metadata are not stamped near the epoch. The gadget takes the time on the
+// management channel only; sign sessions and gadgets without the RPC are
+// left alone.
+func syncGadgetClock(sess *common.Session) {
+	if sess.Channel != common.ChanMgmt {
+		return
+	}
+	if _, err := common.ReqSetTime(sess.Broker, time.Now()); err != nil {
+		sess.Log.Debug("gadget clock not synced", slog.Any("err", err))
+	}
+}
+
 func tryReconnect(ctx context.Context, p common.ConnectParams) (*common.Session, error) {
 	for {
 		select {
@@ -121,6 +219,32 @@ func tryReconnect(ctx context.Context, p common.ConnectParams) (*common.Session,
 }
 
 // shared before-hook that opens USB on a specific channel
+// applyRPCTimeouts installs the --rpc-timeout overrides before any command
+// talks to the gadget.
+func applyRPCTimeouts(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	for _, v := range cmd.StringSlice("rpc-timeout") {
+		rpc, d, err := common.ParseRPCTimeout(v)
+		if err != nil {
+			return ctx, err
+		}
+		common.SetRPCTimeout(rpc, d)
+	}
+	return ctx, nil
+}
+
+// channelAddress is the --transport and the channel's address on it; both
+// are empty for USB.
+func channelAddress(cmd *cli.Command, channel common.Channel) (transport, address string) {
+	transport = cmd.String("transport")
+	if transport == "" || transport == common.TransportUSB {
+		return "", ""
+	}
+	if channel == common.ChanMgmt {
+		return transport, cmd.String("mgmt-address")
+	}
+	return transport, cmd.String("sign-address")
+}
+
 func withSession(channel common.Channel) func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 	return func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 		logCfg := logging.NewConfigFromEnv()
@@ -139,23 +263,38 @@ func withSession(channel common.Channel) func(ctx context.Context, cmd *cli.Comm
 				return ctx, fmt.Errorf("--keep-alive must be at least %s", minKeepAlive)
 			}
 		}
+		heartbeat, heartbeatMisses := time.Duration(0), 0
+		if cmd.Name == "run" {
+			heartbeat, heartbeatMisses = cmd.Duration("heartbeat"), cmd.Int("heartbeat-misses")
+			if heartbeat != 0 && heartbeat < minHeartbeat {
+				return ctx, fmt.Errorf("--heartbeat must be at least %s (or 0 to disable)", minHeartbeat)
+			}
+		}
 
 		l.Debug("opening USB", slog.String("cmd", cmd.Name), slog.String("channel", map[common.Channel]string{
 			common.ChanSign: "sign",
 			common.ChanMgmt: "mgmt",
 		}[channel]))
 
+		transport, address := channelAddress(cmd, channel)
 		sess, err := common.Connect(common.ConnectParams{
 			Serial:    devSerial,
 			Logger:    l,
 			Channel:   channel,
 			KeepAlive: keepAlive,
+
+			Heartbeat:       heartbeat,
+			HeartbeatMisses: heartbeatMisses,
+
+			Transport: transport,
+			Address:   address,
 		})
 		if err != nil {
 			return ctx, err
 		}
 		l.Debug("connected", slog.String("serial", sess.Serial))
 		h.Session = sess
+		syncGadgetClock(sess)
 
 		return context.WithValue(ctx, hostCtxKey{}, h), nil
 	}
@@ -164,7 +303,7 @@ func withSession(channel common.Channel) func(ctx context.Context, cmd *cli.Comm
 func withLoggerOnly() func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 	return func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
 		logCfg := logging.NewConfigFromEnv()
-		if logCfg.File == "" {
+		if logCfg.File == "" && !logCfg.UsesJournal() {
 			logCfg.File = logging.DefaultFileInExecDir(logFileName)
 		}
 		if err := logging.EnsureDir(logCfg.File); err != nil {
@@ -0,0 +1,292 @@
+// installer runs on installer images, before anything mounts a partition.
+// When an SD card or USB drive carrying tezsign-installer on its boot
+// partition is present, it copies that disk onto the board's eMMC or NVMe,
+// grows the data partition to fill the target, removes the marker from the
+// copy and powers the board off. Booted from the installed copy with the card
+// gone, it finds no marker and does nothing. A target that already holds a
+// TezSign data partition is left alone unless the card also carries
+// tezsign-installer-overwrite.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/installer"
+	"github.com/tez-capital/tezsign/logging"
+	"golang.org/x/sys/unix"
+)
+
+type installConfig struct {
+	Target   string // eMMC or NVMe disk, e.g. mmcblk0; found when empty
+	Mount    string // private mount point for boot partitions
+	Wait     time.Duration
+	PowerOff bool
+	sys      installer.Sys
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() (*installConfig, error) {
+	seconds, err := strconv.Atoi(envOr("INSTALLER_WAIT_SECONDS", "15"))
+	if err != nil || seconds < 1 {
+		return nil, fmt.Errorf("INSTALLER_WAIT_SECONDS must be a number >= 1")
+	}
+	return &installConfig{
+		Target:   envOr("INSTALLER_TARGET", ""),
+		Mount:    envOr("INSTALLER_MOUNT", "/run/tezsign-installer"),
+		Wait:     time.Duration(seconds) * time.Second,
+		PowerOff: envOr("INSTALLER_POWEROFF", "1") == "1",
+		sys:      installer.DefaultSys(),
+	}, nil
+}
+
+// withBoot mounts a boot partition for the duration of fn.
+func (c *installConfig) withBoot(dev string, flags uintptr, fn func(dir string) error) error {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return err
+	}
+	var mountErr error
+	mounted := false
+	for _, fstype := range []string{"vfat", "ext4"} {
+		if mountErr = unix.Mount(dev, c.Mount, fstype, flags|unix.MS_NOATIME|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); mountErr == nil {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return fmt.Errorf("mount %s: %w", dev, mountErr)
+	}
+	err := fn(c.Mount)
+	unix.Sync()
+	if uerr := unix.Unmount(c.Mount, 0); uerr != nil && err == nil {
+		err = fmt.Errorf("unmount %s: %w", c.Mount, uerr)
+	}
+	return err
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// source is the removable disk with an installer boot partition.
+type source struct {
+	disk      string
+	boot      int // number of the boot partition
+	overwrite bool
+}
+
+func (c *installConfig) findSource() (*source, error) {
+	disks, err := c.sys.Disks()
+	if err != nil {
+		return nil, err
+	}
+	for _, disk := range disks {
+		if !c.sys.Removable(disk) {
+			continue
+		}
+		parts, err := c.sys.Partitions(disk)
+		if err != nil {
+			continue
+		}
+		for _, part := range parts {
+			if c.sys.Label(disk, part) != "boot" {
+				continue
+			}
+			var marked, overwrite bool
+			err := c.withBoot(filepath.Join("/dev", part), unix.MS_RDONLY, func(dir string) error {
+				marked = exists(filepath.Join(dir, installer.MarkerName))
+				overwrite = exists(filepath.Join(dir, installer.OverwriteName))
+				return nil
+			})
+			if err != nil || !marked {
+				continue
+			}
+			n, err := c.sys.PartitionNumber(disk, part)
+			if err != nil {
+				return nil, err
+			}
+			return &source{disk: disk, boot: n, overwrite: overwrite}, nil
+		}
+	}
+	return nil, nil
+}
+
+// findTarget waits for the eMMC or NVMe to appear; NVMe drives can take a
+// few seconds after boot.
+func (c *installConfig) findTarget() (string, error) {
+	if c.Target != "" {
+		return c.Target, nil
+	}
+	deadline := time.Now().Add(c.Wait)
+	for {
+		target, err := c.sys.Target()
+		if !errors.Is(err, installer.ErrNoTarget) || time.Now().After(deadline) {
+			return target, err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// waitForDevice waits for udev to create a partition device after the
+// partition table was reread.
+func waitForDevice(dev string) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for !exists(dev) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not appear", dev)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return nil
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// resize grows the filesystem of the grown partition. e2fsck exits 1 when
+// it fixed something, which still allows the resize.
+func resize(dev string) error {
+	if err := exec.Command("e2fsck", "-f", "-y", dev).Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.ExitCode() > 1 {
+			return fmt.Errorf("e2fsck %s: %w", dev, err)
+		}
+	}
+	return run("resize2fs", dev)
+}
+
+func (c *installConfig) install(src *source, target string, l *slog.Logger) error {
+	extent, err := c.sys.Extent(src.disk)
+	if err != nil {
+		return err
+	}
+	size, err := c.sys.Size(target)
+	if err != nil {
+		return err
+	}
+	if extent > size {
+		return fmt.Errorf("%w: %s has %d MiB, the image needs %d MiB", installer.ErrTooSmall, target, size>>20, extent>>20)
+	}
+
+	in, err := os.Open(filepath.Join("/dev", src.disk))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(filepath.Join("/dev", target), os.O_RDWR|unix.O_EXCL, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	l.Info("copying the card", "from", src.disk, "to", target, "mib", extent>>20)
+	next := int64(10)
+	err = installer.Copy(out, in, extent, func(done int64) {
+		if pct := done * 100 / extent; pct >= next {
+			l.Info("copying the card", "percent", pct)
+			next = pct/10*10 + 10
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+
+	n, err := installer.GrowLast(out, size)
+	if err != nil {
+		return fmt.Errorf("grow partition table: %w", err)
+	}
+	if err := unix.IoctlSetInt(int(out.Fd()), unix.BLKRRPART, 0); err != nil {
+		return fmt.Errorf("reread %s partition table: %w", target, err)
+	}
+	data := filepath.Join("/dev", installer.PartitionDevice(target, n))
+	if err := waitForDevice(data); err != nil {
+		return err
+	}
+	if err := resize(data); err != nil {
+		return err
+	}
+	l.Info("data partition grown", "device", data, "mib", size>>20)
+
+	boot := filepath.Join("/dev", installer.PartitionDevice(target, src.boot))
+	if err := waitForDevice(boot); err != nil {
+		return err
+	}
+	return c.withBoot(boot, 0, func(dir string) error {
+		for _, name := range []string{installer.MarkerName, installer.OverwriteName} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *installConfig) runInstaller(l *slog.Logger) error {
+	src, err := c.findSource()
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return nil
+	}
+	target, err := c.findTarget()
+	if err != nil {
+		return err
+	}
+	if target == src.disk {
+		return fmt.Errorf("target %s is the installer card", target)
+	}
+	holds, err := c.sys.HoldsTezSign(target)
+	if err != nil {
+		return err
+	}
+	if holds && !src.overwrite {
+		// also what the installed system sees when the card is left in
+		l.Warn("target already holds a TezSign data partition; not installing. Remove the card, or add "+installer.OverwriteName+" to it to replace the installed signer and its keys", "target", target, "card", src.disk)
+		return nil
+	}
+
+	if err := c.install(src, target, l); err != nil {
+		return err
+	}
+	l.Info("installed; remove the card before powering the board on again", "target", target)
+	if c.PowerOff {
+		return exec.Command("systemctl", "poweroff").Run()
+	}
+	return nil
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	cfg, err := loadConfig()
+	if err == nil {
+		err = cfg.runInstaller(l)
+	}
+	if err != nil {
+		l.Error("installer failed; the target may be incomplete and tezsign will not start", "err", err)
+		os.Exit(1)
+	}
+}
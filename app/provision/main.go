@@ -0,0 +1,201 @@
+// provision applies the per-device settings of provision.toml. When the boot
+// partition holds a new file it is validated, its one-time settings (USB
+// serial, authorized hosts) are written, and the file moves to the data
+// partition; the copy on the boot partition is deleted. A file that does not
+// validate is renamed to provision.toml.rejected and nothing in it is
+// applied. Every boot then sets the hostname from the stored copy and writes
+// the environment file that setup-gadget.service and tezsign.service read.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/provision"
+	"golang.org/x/sys/unix"
+)
+
+type provisionConfig struct {
+	Device    string // boot partition
+	Mount     string // private mount point for the boot partition
+	State     string // stored copy on the data partition
+	Env       string // environment file for the units
+	IDFile    string // USB serial on the app partition
+	DataStore string // broker_hosts goes here
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func loadConfig() *provisionConfig {
+	return &provisionConfig{
+		Device:    envOr("PROVISION_DEVICE", "/dev/disk/by-label/boot"),
+		Mount:     envOr("PROVISION_MOUNT", "/run/tezsign-provision"),
+		State:     envOr("PROVISION_STATE", "/data/provision.toml"),
+		Env:       envOr("PROVISION_ENV", "/run/tezsign/provision.env"),
+		IDFile:    envOr("PROVISION_ID_FILE", "/app/tezsign_id"),
+		DataStore: envOr("DATA_STORE", "/data/tezsign"),
+	}
+}
+
+// withBoot mounts the boot partition read-write for the duration of fn.
+func (c *provisionConfig) withBoot(fn func(dir string) error) error {
+	if err := os.MkdirAll(c.Mount, 0o700); err != nil {
+		return err
+	}
+	var mountErr error
+	mounted := false
+	for _, fstype := range []string{"vfat", "ext4"} {
+		if mountErr = unix.Mount(c.Device, c.Mount, fstype, unix.MS_NOATIME|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); mountErr == nil {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return fmt.Errorf("mount %s: %w", c.Device, mountErr)
+	}
+
+	err := fn(c.Mount)
+	unix.Sync()
+	if uerr := unix.Unmount(c.Mount, 0); uerr != nil && err == nil {
+		err = fmt.Errorf("unmount %s: %w", c.Mount, uerr)
+	}
+	return err
+}
+
+// consume takes a new provision.toml off the boot partition.
+func (c *provisionConfig) consume(l *slog.Logger) error {
+	return c.withBoot(func(dir string) error {
+		path := filepath.Join(dir, provision.FileName)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := provision.Parse(data)
+		if err != nil {
+			l.Error("provision.toml rejected; nothing applied", "err", err, "renamed", provision.RejectedName)
+			return os.Rename(path, filepath.Join(dir, provision.RejectedName))
+		}
+		if err := c.applyOnce(p, l); err != nil {
+			return err
+		}
+		if err := writeFileSync(c.State, data, 0o600); err != nil {
+			return fmt.Errorf("store %s: %w", c.State, err)
+		}
+		// stored first: a power cut here re-applies the same file
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		l.Info("provision.toml applied and removed from the boot partition", "stored", c.State)
+		return nil
+	})
+}
+
+// applyOnce writes the settings that live on the partitions themselves.
+func (c *provisionConfig) applyOnce(p *provision.Config, l *slog.Logger) error {
+	if p.Gadget.Serial != "" {
+		if err := c.writeSerial(p.Gadget.Serial); err != nil {
+			return fmt.Errorf("write serial: %w", err)
+		}
+		l.Info("USB serial set", "serial", p.Gadget.Serial)
+	}
+	if hosts := p.HostsFile(); hosts != nil {
+		if err := os.MkdirAll(c.DataStore, 0o700); err != nil {
+			return err
+		}
+		// setup-gadget hands DATA_STORE to the tezsign user afterwards
+		if err := writeFileSync(filepath.Join(c.DataStore, "broker_hosts"), hosts, 0o600); err != nil {
+			return fmt.Errorf("write broker_hosts: %w", err)
+		}
+		l.Info("authorized hosts set", "count", len(p.Gadget.AuthorizedHosts))
+	}
+	return nil
+}
+
+// writeSerial replaces tezsign_id, remounting /app read-write the way
+// generate-serial-number does.
+func (c *provisionConfig) writeSerial(serial string) error {
+	app := filepath.Dir(c.IDFile)
+	if err := unix.Mount("", app, "", unix.MS_REMOUNT, ""); err != nil {
+		return fmt.Errorf("remount %s read-write: %w", app, err)
+	}
+	err := writeFileSync(c.IDFile, []byte(serial), 0o600)
+	if rerr := unix.Mount("", app, "", unix.MS_REMOUNT|unix.MS_RDONLY, ""); rerr != nil && err == nil {
+		err = fmt.Errorf("remount %s read-only: %w", app, rerr)
+	}
+	return err
+}
+
+// apply sets what does not survive a reboot from the stored copy.
+func (c *provisionConfig) apply(l *slog.Logger) error {
+	data, err := os.ReadFile(c.State)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, err := provision.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.State, err)
+	}
+	if p.Hostname != "" {
+		if err := unix.Sethostname([]byte(p.Hostname)); err != nil {
+			return fmt.Errorf("set hostname: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Env), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.Env, p.Env(), 0o644); err != nil {
+		return err
+	}
+	l.Info("provisioned", "hostname", p.Hostname, "product", p.Product())
+	return nil
+}
+
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func main() {
+	l, _ := logging.NewFromEnv()
+
+	cfg := loadConfig()
+	if err := cfg.consume(l); err != nil {
+		// keep booting with the stored settings; the file stays for the next boot
+		l.Error("provision.toml", "err", err)
+	}
+	if err := cfg.apply(l); err != nil {
+		l.Error("provision", "err", err)
+		os.Exit(1)
+	}
+}
@@ -178,7 +178,7 @@ func main() {
 	if info, err := common.ReqInitInfo(getMgmtBroker()); err != nil {
 		l.Warn("init info", slog.Any("err", err))
 	} else if !info.GetMasterPresent() {
-		ok, err := common.ReqInitMaster(getMgmtBroker(), false, masterPass)
+		ok, err := common.ReqInitMaster(getMgmtBroker(), false, masterPass, "")
 		if err != nil {
 			l.Error("init master", slog.Any("err", err))
 			os.Exit(1)
@@ -416,7 +416,7 @@ func resolveBenchmarkKeyByID(mgmtBroker *broker.Broker, masterPass []byte, reque
 	}
 
 	keyID := fmt.Sprintf("%s-%d", prefix, time.Now().UTC().UnixNano())
-	results, err := common.ReqNewKeys(mgmtBroker, []string{keyID}, masterPass)
+	results, err := common.ReqNewKeys(mgmtBroker, []string{keyID}, masterPass, nil, "")
 	if err != nil {
 		return benchmarkKey{}, err
 	}
@@ -437,7 +437,7 @@ func resolveBenchmarkKeyByID(mgmtBroker *broker.Broker, masterPass []byte, reque
 
 func unlockBenchmarkKeys(mgmtBroker *broker.Broker, keys []benchmarkKey, masterPass []byte, cfg benchmarkConfig) error {
 	keyIDs := benchmarkKeyIDs(keys)
-	results, err := common.ReqUnlockKeys(mgmtBroker, keyIDs, masterPass)
+	results, err := common.ReqUnlockKeys(mgmtBroker, keyIDs, masterPass, nil)
 	if err != nil {
 		return fmt.Errorf("unlock: %w", err)
 	}
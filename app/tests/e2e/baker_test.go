@@ -0,0 +1,222 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tez-capital/tezsign/common"
+)
+
+const (
+	passphrase = "correct horse battery staple"
+	keyID      = "baker"
+)
+
+// Watermark bytes of the Tenderbake payloads, as the gadget reports them.
+const (
+	kindBlock          = 0x11
+	kindPreattestation = 0x12
+	kindAttestation    = 0x13
+)
+
+var kindNames = map[byte]string{kindBlock: "block", kindPreattestation: "preattestation", kindAttestation: "attestation"}
+
+var chainID = []byte{0x7a, 0x06, 0xa7, 0x70}
+
+// payload builds a Tenderbake payload of kind at level and round. Payloads
+// that differ only in salt are the conflicting operations a double-signing
+// baker would sign.
+func payload(kind byte, level, round uint32, salt byte) []byte {
+	b := append([]byte{kind}, chainID...)
+	if kind == kindBlock {
+		b = binary.BigEndian.AppendUint32(b, level)
+		b = append(b, 1)                                                  // proto
+		b = append(b, bytes.Repeat([]byte{salt}, 32)...)                  // predecessor
+		b = binary.BigEndian.AppendUint64(b, 1_700_000_000+uint64(level)) // timestamp
+		b = append(b, 0)                                                  // validation pass
+		b = append(b, make([]byte, 32)...)                                // operations hash
+		b = binary.BigEndian.AppendUint32(b, 4)                           // fitness length
+		return binary.BigEndian.AppendUint32(b, round)
+	}
+	b = append(b, bytes.Repeat([]byte{salt}, 32)...) // branch
+	b = append(b, 21)                                // operation tag
+	b = binary.BigEndian.AppendUint32(b, level)
+	return binary.BigEndian.AppendUint32(b, round)
+}
+
+// initGadget sets up the master secret and one key, as `tezsign init` and
+// `tezsign new` do, and returns the key's tz4.
+func (r *rig) initGadget(id string) string {
+	r.t.Helper()
+	b := r.session().Broker
+	if ok, err := common.ReqInitMaster(b, false, []byte(passphrase), ""); err != nil || !ok {
+		r.t.Fatalf("init: %v, %v", ok, err)
+	}
+	res, err := common.ReqNewKeys(b, []string{id}, []byte(passphrase), nil, "")
+	if err != nil || len(res) != 1 || !res[0].GetOk() {
+		r.t.Fatalf("new: %v, %v", res, err)
+	}
+	return res[0].GetTz4()
+}
+
+func (r *rig) mustSign(tz4 string, p []byte) signResult {
+	r.t.Helper()
+	res, err := r.signHTTP(tz4, p)
+	if err != nil {
+		r.t.Fatalf("sign: %v", err)
+	}
+	return res
+}
+
+func (r *rig) expectSign(tz4 string, want int, kind byte, level, round uint32, salt byte) {
+	r.t.Helper()
+	res := r.mustSign(tz4, payload(kind, level, round, salt))
+	if res.status != want {
+		r.t.Fatalf("%s at %d/%d: status %d, want %d", kindNames[kind], level, round, res.status, want)
+	}
+	if want == http.StatusOK && !strings.HasPrefix(res.signature, "BLsig") {
+		r.t.Fatalf("%s at %d/%d: signature %q", kindNames[kind], level, round, res.signature)
+	}
+}
+
+// watermarks reads the gadget's high watermarks of id by kind byte.
+func (r *rig) watermarks(id string) map[byte][2]uint64 {
+	r.t.Helper()
+	keys, err := common.ReqGetWatermarks(r.session().Broker, []string{id})
+	if err != nil || len(keys) != 1 {
+		r.t.Fatalf("watermarks: %v, %v", keys, err)
+	}
+	out := map[byte][2]uint64{}
+	for _, w := range keys[0].GetWatermarks() {
+		out[byte(w.GetKindId())] = [2]uint64{w.GetLevel(), uint64(w.GetRound())}
+	}
+	return out
+}
+
+func TestBakerFlow(t *testing.T) {
+	r := newRig(t)
+	r.startEmulator()
+	tz4 := r.initGadget(keyID)
+	r.startSigner(keyID)
+
+	// the signer serves a locked key, but refuses to sign with it
+	r.expectSign(tz4, http.StatusForbidden, kindAttestation, 100, 0, 0)
+	r.unlock(passphrase, keyID)
+
+	// a baker's level: each kind keeps its own watermark
+	for _, kind := range []byte{kindBlock, kindPreattestation, kindAttestation} {
+		r.expectSign(tz4, http.StatusOK, kind, 100, 0, 0)
+	}
+	// a conflicting operation at the same level and round, or an older one,
+	// is a double sign
+	r.expectSign(tz4, http.StatusConflict, kindAttestation, 100, 0, 1)
+	r.expectSign(tz4, http.StatusConflict, kindBlock, 99, 5, 0)
+	// the next round and the next level advance
+	r.expectSign(tz4, http.StatusOK, kindAttestation, 100, 1, 0)
+	r.expectSign(tz4, http.StatusOK, kindAttestation, 101, 0, 0)
+
+	// bakers racing for the same levels: each level and round is signed
+	// once, whoever gets there first
+	const first, last, bakers = 200, 219, 4
+	signed := r.signConcurrently(tz4, first, last, bakers)
+
+	for _, kind := range []byte{kindPreattestation, kindAttestation} {
+		for level := uint32(first); level <= last; level++ {
+			if n := signed[[2]uint32{uint32(kind), level}]; n > 1 {
+				t.Errorf("%s at %d signed %d times", kindNames[kind], level, n)
+			}
+		}
+		// nothing above the last level was asked for, so the first
+		// request for it cannot be stale
+		if signed[[2]uint32{uint32(kind), last}] != 1 {
+			t.Errorf("%s at the last level was never signed", kindNames[kind])
+		}
+	}
+	wm := r.watermarks(keyID)
+	for _, kind := range []byte{kindPreattestation, kindAttestation} {
+		if wm[kind] != [2]uint64{last, 0} {
+			t.Errorf("%s watermark = %v, want level %d", kindNames[kind], wm[kind], last)
+		}
+	}
+	if wm[kindBlock] != [2]uint64{100, 0} {
+		t.Errorf("block watermark = %v, moved by attestations", wm[kindBlock])
+	}
+	// everything the race left behind is stale now
+	for level := uint32(first); level <= last; level++ {
+		r.expectSign(tz4, http.StatusConflict, kindAttestation, level, 0, 0xff)
+	}
+	if t.Failed() {
+		return
+	}
+
+	// pull the plug: the host reconnects on its own, and the gadget comes
+	// back locked
+	r.killEmulator()
+	r.startEmulator()
+	r.eventually("host reconnect", func() error {
+		res, err := r.signHTTP(tz4, payload(kindAttestation, 300, 0, 0))
+		if err != nil {
+			return err
+		}
+		if res.status != http.StatusForbidden {
+			return fmt.Errorf("status %d, want %d", res.status, http.StatusForbidden)
+		}
+		return nil
+	})
+	r.unlock(passphrase, keyID)
+
+	// watermarks were on disk before the signatures left the gadget
+	if wm := r.watermarks(keyID); wm[kindAttestation] != [2]uint64{last, 0} || wm[kindBlock] != [2]uint64{100, 0} {
+		t.Fatalf("watermarks after restart = %v", wm)
+	}
+	r.expectSign(tz4, http.StatusConflict, kindAttestation, last, 0, 0xfe)
+	r.expectSign(tz4, http.StatusConflict, kindBlock, 100, 0, 0xfe)
+	r.expectSign(tz4, http.StatusOK, kindAttestation, last+1, 0, 0)
+	r.expectSign(tz4, http.StatusOK, kindBlock, 101, 0, 0)
+}
+
+// signConcurrently has bakers race through the levels first to last, each
+// signing its own preattestation and attestation at every level, and counts
+// the signatures by kind and level. Every answer must be a signature or a
+// stale watermark.
+func (r *rig) signConcurrently(tz4 string, first, last uint32, bakers int) map[[2]uint32]int {
+	r.t.Helper()
+	var (
+		mu     sync.Mutex
+		signed = map[[2]uint32]int{}
+		wg     sync.WaitGroup
+	)
+	errs := make(chan error, bakers)
+	for baker := range bakers {
+		wg.Go(func() {
+			for level := first; level <= last; level++ {
+				for _, kind := range []byte{kindPreattestation, kindAttestation} {
+					res, err := r.signHTTP(tz4, payload(kind, level, 0, byte(baker+1)))
+					switch {
+					case err != nil:
+						errs <- err
+						return
+					case res.status == http.StatusOK:
+						mu.Lock()
+						signed[[2]uint32{uint32(kind), level}]++
+						mu.Unlock()
+					case res.status != http.StatusConflict:
+						errs <- fmt.Errorf("baker %d, %s at %d: status %d", baker, kindNames[kind], level, res.status)
+						return
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.t.Fatal(err)
+	}
+	return signed
+}
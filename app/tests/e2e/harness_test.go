@@ -0,0 +1,287 @@
+// Package e2e drives the host CLI and its HTTP signer against the gadget
+// emulator, the way a baker does: init, new, unlock, sign, lose the gadget
+// and get it back. Both programs are built once per run and talk over Unix
+// sockets in a temporary store, so the tests need no board but take a while;
+// -short skips them.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tez-capital/tezsign/common"
+)
+
+var bin struct {
+	emulator, host string
+	skip           string // why the tests cannot run, if they cannot
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	switch {
+	case testing.Short():
+		bin.skip = "end-to-end tests skipped in -short mode"
+	case runtime.GOOS != "linux":
+		// the gadget code, and so the emulator, is Linux only
+		bin.skip = "the emulator builds on Linux only"
+	}
+	if bin.skip != "" {
+		os.Exit(m.Run())
+	}
+
+	dir, err := os.MkdirTemp("", "tezsign-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	bin.emulator = filepath.Join(dir, "tezsign-emulator")
+	bin.host = filepath.Join(dir, "tezsign")
+	for _, args := range [][]string{
+		{"build", "-tags", "emulator", "-o", bin.emulator, "github.com/tez-capital/tezsign/app/gadget"},
+		{"build", "-o", bin.host, "github.com/tez-capital/tezsign/app/host"},
+	} {
+		if out, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "go %s: %v\n%s", strings.Join(args, " "), err, out)
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// rig is one emulated gadget with the host CLI and HTTP signer in front of
+// it. Everything lives in a temporary directory: the gadget's store, its
+// sockets, the host's key and pins, and the logs, which a failing test
+// prints.
+type rig struct {
+	t        *testing.T
+	dir      string
+	sign     string // sign channel socket
+	mgmt     string // management channel socket
+	emulator *exec.Cmd
+	signer   *exec.Cmd
+	httpURL  string
+	client   *http.Client
+}
+
+func newRig(t *testing.T) *rig {
+	t.Helper()
+	if bin.skip != "" {
+		t.Skip(bin.skip)
+	}
+	dir := t.TempDir()
+	r := &rig{
+		t:      t,
+		dir:    dir,
+		sign:   filepath.Join(dir, "store", "sign.sock"),
+		mgmt:   filepath.Join(dir, "store", "mgmt.sock"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	// in-process sessions share the CLI's host key and gadget pins
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+
+	// registered first, so it runs after the processes below are stopped
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		for _, name := range []string{"store/emulator.log", "host.log", "signer.log"} {
+			if out, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+				t.Logf("--- %s ---\n%s", name, tail(out, 60))
+			}
+		}
+	})
+	t.Cleanup(func() {
+		r.stop(r.signer)
+		r.stop(r.emulator)
+	})
+	return r
+}
+
+// env is the environment of the host CLI: the emulator's sockets, and logs
+// to a file rather than the test's output.
+func (r *rig) env() []string {
+	return append(os.Environ(),
+		"XDG_CONFIG_HOME="+filepath.Join(r.dir, "config"),
+		"TEZSIGN_TRANSPORT="+common.TransportUnix,
+		"TEZSIGN_SIGN_ADDRESS="+r.sign,
+		"TEZSIGN_MGMT_ADDRESS="+r.mgmt,
+		"LOG_FILE="+filepath.Join(r.dir, "host.log"),
+		"LOG_STDERR=0",
+	)
+}
+
+// startEmulator starts the gadget on the rig's store, which survives
+// restarts as the data partition survives a replug.
+func (r *rig) startEmulator() {
+	r.t.Helper()
+	// sockets of a killed emulator linger; wait for fresh ones
+	_ = os.Remove(r.sign)
+	_ = os.Remove(r.mgmt)
+
+	cmd := exec.Command(bin.emulator, "--transport", "unix", "--store", filepath.Join(r.dir, "store"))
+	cmd.Env = append(os.Environ(), "LOG_STDERR=0", "LOG_FILE=")
+	if err := cmd.Start(); err != nil {
+		r.t.Fatal(err)
+	}
+	r.emulator = cmd
+	r.eventually("emulator sockets", func() error {
+		for _, sock := range []string{r.sign, r.mgmt} {
+			if _, err := os.Stat(sock); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// killEmulator pulls the plug: no shutdown, no flushing beyond what the
+// gadget already did.
+func (r *rig) killEmulator() {
+	r.t.Helper()
+	r.stop(r.emulator)
+	r.emulator = nil
+}
+
+func (r *rig) stop(cmd *exec.Cmd) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+}
+
+// session opens a management session in process, for the steps the CLI
+// only does behind a passphrase prompt.
+func (r *rig) session() *common.Session {
+	r.t.Helper()
+	sess, err := common.Connect(common.ConnectParams{
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Channel:   common.ChanMgmt,
+		Transport: common.TransportUnix,
+		Address:   r.mgmt,
+	})
+	if err != nil {
+		r.t.Fatalf("connect: %v", err)
+	}
+	r.t.Cleanup(sess.Close)
+	return sess
+}
+
+// cli runs the host CLI with extra environment and returns its output.
+func (r *rig) cli(env []string, args ...string) (string, error) {
+	cmd := exec.Command(bin.host, args...)
+	cmd.Env = append(r.env(), env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func (r *rig) unlock(pass string, keys ...string) {
+	r.t.Helper()
+	if out, err := r.cli([]string{"TEZSIGN_UNLOCK_PASS=" + pass}, append([]string{"unlock"}, keys...)...); err != nil {
+		r.t.Fatalf("unlock: %v\n%s", err, out)
+	}
+}
+
+// startSigner runs `tezsign run --listen` for keys and waits for its HTTP
+// API to answer.
+func (r *rig) startSigner(keys ...string) {
+	r.t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	logFile, err := os.Create(filepath.Join(r.dir, "signer.log"))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.t.Cleanup(func() { _ = logFile.Close() })
+	cmd := exec.Command(bin.host, append([]string{"run", "--listen", addr}, keys...)...)
+	cmd.Env = r.env()
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		r.t.Fatal(err)
+	}
+	r.signer = cmd
+	r.httpURL = "http://" + addr
+	r.eventually("HTTP signer", func() error {
+		resp, err := r.client.Get(r.httpURL + "/authorized_keys")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("authorized_keys: %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// signResult is the answer of the HTTP signer to one payload.
+type signResult struct {
+	status    int
+	signature string
+}
+
+// signHTTP posts payload to the signer as octez-client does.
+func (r *rig) signHTTP(tz4 string, payload []byte) (signResult, error) {
+	body, _ := json.Marshal(fmt.Sprintf("%x", payload))
+	resp, err := r.client.Post(r.httpURL+"/keys/"+tz4, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return signResult{}, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Signature string `json:"signature"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return signResult{}, err
+		}
+	}
+	return signResult{status: resp.StatusCode, signature: out.Signature}, nil
+}
+
+// eventually retries check until it passes or 20 seconds are gone.
+func (r *rig) eventually(what string, check func() error) {
+	r.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			r.t.Fatalf("%s: %v", what, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func tail(b []byte, lines int) string {
+	all := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	return strings.Join(all[max(0, len(all)-lines):], "\n")
+}
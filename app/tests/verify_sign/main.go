@@ -40,6 +40,39 @@ func main() {
 	}
 	fmt.Println("aggSig(hex):", hex.EncodeToString(agg))
 
+	// 5) Aggregate across signers: same message, then one message each
+	secretKey_b, pubkeyBytes_b, _ := signer.GenerateRandomKey()
+	sigBytes_b, _ := signer.SignCompressed(secretKey_b, msg)
+	aggSame, err := signer.AggregateCompressed([][]byte{sigBytes, sigBytes_b})
+	if err != nil {
+		log.Fatalf("aggregate: %v", err)
+	}
+	pubkeys := [][]byte{pubkeyBytes, pubkeyBytes_b}
+	aggPubkey, err := signer.AggregatePublicKeysCompressed(pubkeys)
+	if err != nil {
+		log.Fatalf("aggregate pubkeys: %v", err)
+	}
+	fmt.Println("fast aggregate verify:", signer.FastAggregateVerifyCompressed(pubkeys, aggSame, msg))
+	fmt.Println("verify with aggregate pubkey:", signer.VerifyCompressed(aggPubkey, aggSame, msg))
+
+	msg_b := []byte("hello-tezos-b")
+	sigBytes_b2, _ := signer.SignCompressed(secretKey_b, msg_b)
+	aggDistinct, err := signer.AggregateCompressed([][]byte{sigBytes, sigBytes_b2})
+	if err != nil {
+		log.Fatalf("aggregate: %v", err)
+	}
+	pop, _, err := signer.SignPoPCompressed(secretKey, pubkeyBytes)
+	if err != nil {
+		log.Fatalf("pop: %v", err)
+	}
+	pop_b, _, err := signer.SignPoPCompressed(secretKey_b, pubkeyBytes_b)
+	if err != nil {
+		log.Fatalf("pop: %v", err)
+	}
+	pops := [][]byte{pop, pop_b}
+	fmt.Println("aggregate verify:", signer.AggregateVerifyCompressed(pubkeys, pops, aggDistinct, [][]byte{msg, msg_b}))
+	fmt.Println("aggregate verify (swapped msgs):", signer.AggregateVerifyCompressed(pubkeys, pops, aggDistinct, [][]byte{msg_b, msg}))
+
 	// ----- Compare with octez-client -----
 	// ./octez-client -E https://mainnet.api.tez.ie show address bls1 -S
 	// copy unencrypted:BLsk...
@@ -0,0 +1,101 @@
+// Package apphash is the expected hash of the signer binary that the boot
+// partition carries next to the kernel. Before tezsign.service starts, the
+// app_verify helper in the initramfs hashes /app/tezsign and compares it with
+// this file, so a binary swapped on the app partition offline does not run.
+// The image build writes the file, the builder rewrites it after changing
+// the app partition, and the updater copies it along with the kernel.
+package apphash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tez-capital/tezsign/bootslot"
+)
+
+const (
+	// FileName is the sha256sum-format file on the boot partition.
+	FileName = "tezsign.sha256"
+	// Binary is the signer's path on the app partition.
+	Binary = "tezsign"
+)
+
+var (
+	ErrInvalid  = errors.New("invalid app hash file")
+	ErrMismatch = errors.New("app binary does not match the boot partition hash")
+)
+
+// Path is where the hash file sits on the boot partition. Slotted images keep
+// one per slot, in the slot's directory with its kernel; slot is "" for the
+// plain layout.
+func Path(slot string) string {
+	if slot == "" {
+		return FileName
+	}
+	return path.Join(bootslot.Dir(slot), FileName)
+}
+
+// Sum returns the hex SHA-256 of r.
+func Sum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SumFile returns the hex SHA-256 of the file at name.
+func SumFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Sum(f)
+}
+
+// Marshal writes sum the way sha256sum does, so `sha256sum -c` run in a
+// mounted app partition checks it too.
+func Marshal(sum string) []byte {
+	return []byte(sum + "  " + Binary + "\n")
+}
+
+// Parse returns the hash recorded for Binary.
+func Parse(data []byte) (string, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		sum, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		if strings.TrimPrefix(strings.TrimSpace(name), "*") != Binary {
+			continue
+		}
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return "", fmt.Errorf("%w: bad hash %q", ErrInvalid, sum)
+		}
+		return strings.ToLower(sum), nil
+	}
+	return "", fmt.Errorf("%w: no entry for %s", ErrInvalid, Binary)
+}
+
+// Check compares the binary read from r with the hash file data.
+func Check(data []byte, r io.Reader) error {
+	want, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	got, err := Sum(r)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s, want %s", ErrMismatch, got, want)
+	}
+	return nil
+}
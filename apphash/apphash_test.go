@@ -0,0 +1,49 @@
+package apphash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMarshalParseRoundTrip(t *testing.T) {
+	sum, err := Sum(strings.NewReader("signer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(Marshal(sum))
+	if err != nil || got != sum {
+		t.Fatalf("Parse = %q, %v; want %q", got, err, sum)
+	}
+	// sha256sum -b marks binary mode with '*'
+	if got, err := Parse([]byte(strings.ToUpper(sum) + " *tezsign\n")); err != nil || got != sum {
+		t.Fatalf("Parse binary mode = %q, %v", got, err)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, in := range []string{"", "deadbeef  tezsign\n", strings.Repeat("a", 64) + "  other\n"} {
+		if _, err := Parse([]byte(in)); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("Parse(%q) err = %v, want ErrInvalid", in, err)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	sum, _ := Sum(strings.NewReader("signer"))
+	if err := Check(Marshal(sum), strings.NewReader("signer")); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := Check(Marshal(sum), strings.NewReader("swapped")); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Check swapped binary err = %v, want ErrMismatch", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	if got := Path(""); got != FileName {
+		t.Fatalf("Path(\"\") = %q", got)
+	}
+	if got := Path("b"); got != "slot_b/"+FileName {
+		t.Fatalf("Path(b) = %q", got)
+	}
+}
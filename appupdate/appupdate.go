@@ -0,0 +1,333 @@
+// Package appupdate is the staging area of signer binaries pushed from the
+// host over USB. The gadget receives the upload into Dir under DATA_STORE and
+// checks it before it names it Binary; on the next boot the app_update helper
+// checks it again, installs it as /app/tezsign with its hash on the boot
+// partition and empties the staging area.
+//
+// The host is not trusted with the binary: the passphrase only proves the
+// master secret's owner asked for the update, and the hash comes from the
+// same host. A binary is staged and installed only with a minisign signature
+// by the release key, which the image carries as KeyFile on the app
+// partition; an image without one takes no pushed binaries. The signature's
+// trusted comment must name the binary of the image's release
+// (<release>.tezsign) and a build no older than the installed signer's, so
+// neither another board's binary nor an older signer passes.
+package appupdate
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/minisign"
+)
+
+const (
+	// Dir holds the staging area under DATA_STORE.
+	Dir = "update"
+	// Binary is a staged signer that passed the gadget's checks.
+	Binary = apphash.Binary
+	// HashFile is Binary's hash in apphash format, copied to the boot
+	// partition as it is.
+	HashFile = apphash.FileName
+	// SignatureFile is Binary's release signature.
+	SignatureFile = Binary + minisign.SignatureExt
+	// KeyFile is the release public key on the app partition.
+	KeyFile = "release.pub"
+	// ReleaseFile, VersionFile and DateFile are the app partition's markers
+	// of the release the image was built as, its version and build date.
+	// Installing a binary moves the version and date to its own.
+	ReleaseFile = ".image-release"
+	VersionFile = ".image-version"
+	DateFile    = ".image-date"
+	// BinaryExt is appended to the release name of a published binary.
+	BinaryExt = ".tezsign"
+
+	// MaxSize bounds an upload: the app partition is 24 MiB.
+	MaxSize = 24 << 20
+
+	partSuffix = ".part"
+)
+
+var (
+	ErrMismatch  = errors.New("update does not match its hash")
+	ErrNotELF    = errors.New("not an executable for this device")
+	ErrUnsigned  = errors.New("update is not signed by the release key")
+	ErrNoKey     = errors.New("this image has no release key (" + KeyFile + ") and takes no pushed updates")
+	ErrNoRelease = errors.New("this image names no release (" + ReleaseFile + ") and takes no pushed updates")
+	ErrDowngrade = errors.New("update is older than the installed signer")
+)
+
+// Trust is what a pushed binary is checked against, all of it read from the
+// app partition.
+type Trust struct {
+	Key minisign.PublicKey
+	// Release names the image's release; the binary must be signed as
+	// Release+BinaryExt.
+	Release string
+	// Date is the RFC 3339 build date of the installed signer; the binary's
+	// must not be earlier. An unreadable date leaves the age unchecked.
+	Date string
+}
+
+// LoadTrust reads the release key and markers of the app partition mounted
+// at dir.
+func LoadTrust(dir string) (Trust, error) {
+	key, err := LoadKey(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return Trust{}, err
+	}
+	name := readMarker(filepath.Join(dir, ReleaseFile))
+	if name == "" {
+		return Trust{}, ErrNoRelease
+	}
+	return Trust{Key: key, Release: name, Date: readMarker(filepath.Join(dir, DateFile))}, nil
+}
+
+// readMarker returns the one-line marker at path, "" when it is missing or
+// was not filled in at build time.
+func readMarker(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if v := strings.TrimSpace(string(data)); v != "unknown" {
+		return v
+	}
+	return ""
+}
+
+// LoadKey reads the release public key at path, KeyFile on the app
+// partition.
+func LoadKey(path string) (minisign.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return minisign.PublicKey{}, ErrNoKey
+	}
+	if err != nil {
+		return minisign.PublicKey{}, err
+	}
+	// images built without a key carry an empty file
+	if strings.TrimSpace(string(data)) == "" {
+		return minisign.PublicKey{}, ErrNoKey
+	}
+	return minisign.ParsePublicKey(string(data))
+}
+
+// Upload receives a binary into dir, in order.
+type Upload struct {
+	dir      string
+	f        *os.File
+	size     int64
+	sum      string
+	sig      []byte
+	received int64
+}
+
+// Begin starts an upload of size bytes with the hex SHA-256 sum and the
+// binary's minisign signature, dropping a staged binary. An update is applied
+// on boot only once it is committed.
+func Begin(dir string, size int64, sum string, sig []byte) (*Upload, error) {
+	if size <= 0 || size > MaxSize {
+		return nil, fmt.Errorf("update size %d out of range (max %d)", size, MaxSize)
+	}
+	if len(sig) == 0 {
+		return nil, ErrUnsigned
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := Clear(dir); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, Binary+partSuffix), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Upload{dir: dir, f: f, size: size, sum: sum, sig: sig}, nil
+}
+
+func (u *Upload) Received() int64 { return u.received }
+
+// Write appends data at off, which must be where the upload stands.
+func (u *Upload) Write(off int64, data []byte) error {
+	if off != u.received {
+		return fmt.Errorf("chunk at offset %d, expected %d", off, u.received)
+	}
+	if u.received+int64(len(data)) > u.size {
+		return fmt.Errorf("chunk runs past the announced size %d", u.size)
+	}
+	n, err := u.f.Write(data)
+	u.received += int64(n)
+	return err
+}
+
+// Commit checks the complete upload against its announced hash, its
+// signature by trust and the ELF machine of the running signer, then stages
+// it.
+func (u *Upload) Commit(trust Trust, machine elf.Machine) error {
+	if u.received != u.size {
+		return fmt.Errorf("received %d of %d bytes", u.received, u.size)
+	}
+	part := u.f.Name()
+	err := u.f.Sync()
+	if closeErr := u.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := check(part, u.sum, u.sig, trust, machine); err != nil {
+		os.Remove(part)
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(u.dir, HashFile), apphash.Marshal(u.sum), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(u.dir, SignatureFile), u.sig, 0o600); err != nil {
+		return err
+	}
+	// Binary appears last: the helper only looks at complete stagings
+	if err := os.Rename(part, filepath.Join(u.dir, Binary)); err != nil {
+		return err
+	}
+	return syncDir(u.dir)
+}
+
+// Abort drops the upload.
+func (u *Upload) Abort() {
+	u.f.Close()
+	os.Remove(u.f.Name())
+}
+
+// StagedBinary is a staged binary that passed the checks.
+type StagedBinary struct {
+	Path string
+	Sum  string // hex SHA-256
+	// Version and Date are the release its signature names, for the app
+	// partition's markers.
+	Version string
+	Date    string
+}
+
+// Staged returns the binary staged in dir, checked again and against trust.
+// os.ErrNotExist means nothing is staged.
+func Staged(dir string, trust Trust, machine elf.Machine) (*StagedBinary, error) {
+	path := filepath.Join(dir, Binary)
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, HashFile))
+	if err != nil {
+		return nil, err
+	}
+	want, err := apphash.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(filepath.Join(dir, SignatureFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrUnsigned
+	}
+	if err != nil {
+		return nil, err
+	}
+	return check(path, want, sig, trust, machine)
+}
+
+// Clear empties the staging area.
+func Clear(dir string) error {
+	for _, name := range []string{Binary, Binary + partSuffix, HashFile, SignatureFile} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Machine is the ELF machine of the executable at path, e.g. the running
+// signer's.
+func Machine(path string) (elf.Machine, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Machine, nil
+}
+
+// check hashes the binary at path, verifies sig over it against trust and
+// makes sure it is an executable for machine.
+func check(path, want string, sig []byte, trust Trust, machine elf.Machine) (*StagedBinary, error) {
+	sum, err := apphash.SumFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if sum != want {
+		return nil, fmt.Errorf("%w: %s, want %s", ErrMismatch, sum, want)
+	}
+	comment, err := verify(path, sig, trust.Key)
+	if err != nil {
+		return nil, err
+	}
+	staged, err := checkRelease(comment, trust)
+	if err != nil {
+		return nil, err
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotELF, err)
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN || f.Machine != machine {
+		return nil, fmt.Errorf("%w: %s %s, want %s", ErrNotELF, f.Type, f.Machine, machine)
+	}
+	staged.Path, staged.Sum = path, sum
+	return staged, nil
+}
+
+func verify(path string, sig []byte, key minisign.PublicKey) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	comment, err := minisign.Verify(key, f, sig)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsigned, err)
+	}
+	return comment, nil
+}
+
+// checkRelease holds the signed trusted comment to trust: the signature must
+// be for the image release's binary, and of a build no older than the
+// installed one. The comment, unlike the file name or what the host says, is
+// covered by the signature.
+func checkRelease(comment string, trust Trust) (*StagedBinary, error) {
+	if name, want := minisign.CommentField(comment, "file"), trust.Release+BinaryExt; name != want {
+		return nil, fmt.Errorf("%w: signature is for %q, want %q", ErrUnsigned, name, want)
+	}
+	staged := &StagedBinary{Version: minisign.CommentField(comment, "version"), Date: minisign.CommentField(comment, "date")}
+	date, err := time.Parse(time.RFC3339, staged.Date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature names no build date", ErrUnsigned)
+	}
+	if installed, err := time.Parse(time.RFC3339, trust.Date); err == nil && date.Before(installed) {
+		return nil, fmt.Errorf("%w: %s (%s) is older than %s", ErrDowngrade, staged.Version, staged.Date, trust.Date)
+	}
+	return staged, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
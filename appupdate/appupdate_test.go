@@ -0,0 +1,263 @@
+package appupdate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"debug/elf"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/minisign"
+)
+
+var (
+	testKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{5}, ed25519.SeedSize))
+	testPub = minisign.NewPublicKey(testKey)
+	// testTrust is an rpi4 image whose signer was built on 2026-01-01
+	testTrust = Trust{Key: testPub, Release: "rpi4", Date: "2026-01-01T00:00:00Z"}
+)
+
+// testComment is the trusted comment of a later rpi4 build, as the builder
+// signs it.
+const testComment = "timestamp:1\tfile:rpi4.tezsign\tversion:v1.1.0\tdate:2026-02-01T00:00:00Z\thashed"
+
+func sign(t *testing.T, key ed25519.PrivateKey, data []byte) []byte {
+	t.Helper()
+	return signComment(t, key, data, testComment)
+}
+
+func signComment(t *testing.T, key ed25519.PrivateKey, data []byte, comment string) []byte {
+	t.Helper()
+	sig, err := minisign.Sign(key, bytes.NewReader(data), comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// testBinary is the test executable, an ELF file for this machine.
+func testBinary(t *testing.T) ([]byte, string, elf.Machine) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, err := Machine(exe)
+	if err != nil {
+		t.Skipf("test binary is not ELF: %v", err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := apphash.SumFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, sum, machine
+}
+
+// upload sends data with sig, a signature by testKey of it when nil.
+func upload(t *testing.T, dir string, data []byte, sum string, sig []byte) *Upload {
+	t.Helper()
+	if sig == nil {
+		sig = sign(t, testKey, data)
+	}
+	u, err := Begin(dir, int64(len(data)), sum, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 0; off < len(data); off += 1 << 20 {
+		if err := u.Write(int64(off), data[off:min(off+1<<20, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return u
+}
+
+func TestUploadStagesBinary(t *testing.T) {
+	data, sum, machine := testBinary(t)
+	dir := filepath.Join(t.TempDir(), Dir)
+
+	u := upload(t, dir, data, sum, nil)
+	if _, err := Staged(dir, testTrust, machine); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("uncommitted upload staged: %v", err)
+	}
+	if err := u.Commit(testTrust, machine); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	staged, err := Staged(dir, testTrust, machine)
+	if err != nil {
+		t.Fatalf("Staged: %v", err)
+	}
+	if want := (StagedBinary{Path: filepath.Join(dir, Binary), Sum: sum, Version: "v1.1.0", Date: "2026-02-01T00:00:00Z"}); *staged != want {
+		t.Fatalf("Staged = %+v, want %+v", *staged, want)
+	}
+
+	if err := Clear(dir); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Clear left %d files", len(entries))
+	}
+}
+
+func TestUploadRejects(t *testing.T) {
+	data, sum, machine := testBinary(t)
+	dir := t.TempDir()
+
+	u := upload(t, dir, data[:len(data)/2], sum, nil)
+	if err := u.Commit(testTrust, machine); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("truncated binary: %v", err)
+	}
+
+	text := []byte("#!/bin/sh\necho not a signer\n")
+	textSum, _ := apphash.SumFile(writeFile(t, text))
+	if err := upload(t, dir, text, textSum, nil).Commit(testTrust, machine); !errors.Is(err, ErrNotELF) {
+		t.Fatalf("shell script: %v", err)
+	}
+	if err := upload(t, dir, data, sum, nil).Commit(testTrust, machine+1); !errors.Is(err, ErrNotELF) {
+		t.Fatalf("binary for another machine: %v", err)
+	}
+	// the host announces the hash, so only the signature tells a release
+	// binary from any other
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{6}, ed25519.SeedSize))
+	if err := upload(t, dir, data, sum, sign(t, other, data)).Commit(testTrust, machine); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("binary signed by another key: %v", err)
+	}
+	if err := upload(t, dir, data, sum, sign(t, testKey, text)).Commit(testTrust, machine); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("signature of another file: %v", err)
+	}
+	// the signed comment, not the host, says which release the binary is
+	for _, c := range []struct {
+		name, comment string
+		want          error
+	}{
+		{"another board's binary", "timestamp:1\tfile:rpi5.tezsign\tversion:v1.1.0\tdate:2026-02-01T00:00:00Z\thashed", ErrUnsigned},
+		{"an image signature", "timestamp:1\tfile:rpi4.img.xz\tversion:v1.1.0\tdate:2026-02-01T00:00:00Z\thashed", ErrUnsigned},
+		{"no build date", "timestamp:1\tfile:rpi4.tezsign\thashed", ErrUnsigned},
+		{"an older build", "timestamp:1\tfile:rpi4.tezsign\tversion:v1.0.0\tdate:2025-12-01T00:00:00Z\thashed", ErrDowngrade},
+	} {
+		if err := upload(t, dir, data, sum, signComment(t, testKey, data, c.comment)).Commit(testTrust, machine); !errors.Is(err, c.want) {
+			t.Fatalf("%s: %v, want %v", c.name, err, c.want)
+		}
+	}
+	if _, err := Begin(dir, int64(len(data)), sum, []byte{}); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("unsigned upload begun: %v", err)
+	}
+	if _, err := Staged(dir, testTrust, machine); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("rejected upload staged: %v", err)
+	}
+
+	u, err := Begin(dir, 10, sum, sign(t, testKey, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Abort()
+	if err := u.Write(5, []byte("x")); err == nil {
+		t.Fatal("chunk out of order accepted")
+	}
+	if err := u.Write(0, make([]byte, 11)); err == nil {
+		t.Fatal("chunk past the size accepted")
+	}
+	if err := u.Commit(testTrust, machine); err == nil {
+		t.Fatal("incomplete upload committed")
+	}
+}
+
+// TestStagedChecksSignature changes a staging behind the gadget's back, as
+// anything with the data partition can, before app_update installs it.
+func TestStagedChecksSignature(t *testing.T) {
+	data, sum, machine := testBinary(t)
+	dir := filepath.Join(t.TempDir(), Dir)
+	if err := upload(t, dir, data, sum, nil).Commit(testTrust, machine); err != nil {
+		t.Fatal(err)
+	}
+	other := minisign.NewPublicKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{6}, ed25519.SeedSize)))
+	if _, err := Staged(dir, Trust{Key: other, Release: testTrust.Release}, machine); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("staging checked against another key: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, SignatureFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Staged(dir, testTrust, machine); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("staging without a signature: %v", err)
+	}
+}
+
+// TestStagedReinstall stages a binary again after app_update moved the
+// markers to it, as when the boot hash could not be written.
+func TestStagedReinstall(t *testing.T) {
+	data, sum, machine := testBinary(t)
+	dir := filepath.Join(t.TempDir(), Dir)
+	if err := upload(t, dir, data, sum, nil).Commit(testTrust, machine); err != nil {
+		t.Fatal(err)
+	}
+	installed := testTrust
+	installed.Date = "2026-02-01T00:00:00Z"
+	if _, err := Staged(dir, installed, machine); err != nil {
+		t.Fatalf("same build: %v", err)
+	}
+	// a local image without a build date leaves the age unchecked
+	installed.Date = ""
+	if _, err := Staged(dir, installed, machine); err != nil {
+		t.Fatalf("no installed date: %v", err)
+	}
+	installed.Date = "2026-03-01T00:00:00Z"
+	if _, err := Staged(dir, installed, machine); !errors.Is(err, ErrDowngrade) {
+		t.Fatalf("staging older than the installed signer: %v", err)
+	}
+}
+
+func TestLoadTrust(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadTrust(dir); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("no key: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, KeyFile), testPub.File(), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ReleaseFile), []byte("unknown\n"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTrust(dir); !errors.Is(err, ErrNoRelease) {
+		t.Fatalf("release marker not filled in: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ReleaseFile), []byte("rpi4\n"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, DateFile), []byte("2026-01-01T00:00:00Z\n"), 0o444); err != nil {
+		t.Fatal(err)
+	}
+	trust, err := LoadTrust(dir)
+	if err != nil || trust.Key.String() != testPub.String() || trust.Release != "rpi4" || trust.Date != "2026-01-01T00:00:00Z" {
+		t.Fatalf("LoadTrust = %+v, %v", trust, err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadKey(filepath.Join(dir, KeyFile)); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("missing key: %v", err)
+	}
+	path := writeFile(t, []byte("\n"))
+	if _, err := LoadKey(path); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("empty key file: %v", err)
+	}
+	path = writeFile(t, testPub.File())
+	if key, err := LoadKey(path); err != nil || key.String() != testPub.String() {
+		t.Fatalf("LoadKey = %s, %v", key, err)
+	}
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
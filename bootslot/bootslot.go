@@ -0,0 +1,253 @@
+// Package bootslot is the A/B slot state of slotted images. The boot
+// partition holds one kernel (with the rootfs bundled as initramfs) per slot
+// under slot_a/ and slot_b/, a selector the boot loader reads, and a small
+// state file. Each slot has its own app partition (app_a, app_b). The
+// on-device boot_slot helper and the host-side updater share this package.
+package bootslot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	A = "a"
+	B = "b"
+
+	// StateFile sits in the root of the boot partition.
+	StateFile = "tezsign-slot"
+	// PiSelectorFile is included from config.txt and sets os_prefix.
+	PiSelectorFile = "slot.txt"
+	// ExtlinuxFile is the U-Boot selector: its default label picks the slot.
+	ExtlinuxFile = "extlinux/extlinux.conf"
+
+	// CmdlineKey tells the booted kernel which slot it came from.
+	CmdlineKey = "tezsign.slot"
+)
+
+var (
+	ErrInvalidSlot  = errors.New("invalid boot slot")
+	ErrInvalidState = errors.New("invalid boot slot state")
+	ErrNoSelector   = errors.New("boot partition has no slot selector")
+)
+
+// State is what StateFile records. Active is the committed slot; Trial is
+// the slot an update has installed and not yet committed.
+type State struct {
+	Active string
+	Trial  string
+}
+
+func Valid(slot string) bool {
+	return slot == A || slot == B
+}
+
+func Other(slot string) string {
+	if slot == A {
+		return B
+	}
+	return A
+}
+
+// AppLabel is the filesystem label of the slot's app partition.
+func AppLabel(slot string) string {
+	return "app_" + slot
+}
+
+// Dir is the slot's directory on the boot partition.
+func Dir(slot string) string {
+	return "slot_" + slot
+}
+
+// ExtlinuxLabel is the slot's entry in extlinux.conf.
+func ExtlinuxLabel(slot string) string {
+	return "TezSign-" + slot
+}
+
+func (s State) Validate() error {
+	if !Valid(s.Active) {
+		return fmt.Errorf("%w: active slot %q", ErrInvalidState, s.Active)
+	}
+	if s.Trial != "" && (!Valid(s.Trial) || s.Trial == s.Active) {
+		return fmt.Errorf("%w: trial slot %q", ErrInvalidState, s.Trial)
+	}
+	return nil
+}
+
+// Next is the slot the boot loader should start on the next boot.
+func (s State) Next() string {
+	if s.Trial != "" {
+		return s.Trial
+	}
+	return s.Active
+}
+
+func ParseState(data []byte) (State, error) {
+	var s State
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return State{}, fmt.Errorf("%w: %q", ErrInvalidState, line)
+		}
+		switch strings.TrimSpace(key) {
+		case "active":
+			s.Active = strings.TrimSpace(value)
+		case "trial":
+			s.Trial = strings.TrimSpace(value)
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return State{}, err
+	}
+	return s, nil
+}
+
+func (s State) Marshal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "active=%s\n", s.Active)
+	if s.Trial != "" {
+		fmt.Fprintf(&b, "trial=%s\n", s.Trial)
+	}
+	return b.Bytes()
+}
+
+func ReadState(bootDir string) (State, error) {
+	data, err := os.ReadFile(filepath.Join(bootDir, StateFile))
+	if err != nil {
+		return State{}, err
+	}
+	return ParseState(data)
+}
+
+func WriteState(bootDir string, s State) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return writeFileSync(filepath.Join(bootDir, StateFile), s.Marshal())
+}
+
+// FromCmdline returns the slot named on a kernel command line, or "".
+func FromCmdline(cmdline string) string {
+	for _, field := range strings.Fields(cmdline) {
+		if value, ok := strings.CutPrefix(field, CmdlineKey+"="); ok && Valid(value) {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetCmdline returns cmdline with its slot token replaced by slot, appending
+// one if there is none.
+func SetCmdline(cmdline, slot string) string {
+	fields := strings.Fields(cmdline)
+	token := CmdlineKey + "=" + slot
+	found := false
+	for i, field := range fields {
+		if strings.HasPrefix(field, CmdlineKey+"=") {
+			fields[i] = token
+			found = true
+		}
+	}
+	if !found {
+		fields = append(fields, token)
+	}
+	return strings.Join(fields, " ")
+}
+
+// SetSelector points the boot loader on the mounted boot partition at slot.
+// Images with extlinux.conf switch its default label; Raspberry Pi images
+// rewrite os_prefix in slot.txt.
+func SetSelector(bootDir, slot string) error {
+	if !Valid(slot) {
+		return fmt.Errorf("%w: %q", ErrInvalidSlot, slot)
+	}
+
+	extlinux := filepath.Join(bootDir, ExtlinuxFile)
+	if conf, err := os.ReadFile(extlinux); err == nil {
+		updated, err := setExtlinuxDefault(conf, slot)
+		if err != nil {
+			return err
+		}
+		return writeFileSync(extlinux, updated)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	selector := filepath.Join(bootDir, PiSelectorFile)
+	if _, err := os.Stat(selector); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNoSelector
+		}
+		return err
+	}
+	return writeFileSync(selector, []byte("os_prefix="+Dir(slot)+"/\n"))
+}
+
+func setExtlinuxDefault(conf []byte, slot string) ([]byte, error) {
+	label := ExtlinuxLabel(slot)
+	lines := strings.Split(string(conf), "\n")
+	hasLabel, hasDefault := false, false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "default":
+			lines[i] = "default " + label
+			hasDefault = true
+		case "label":
+			if fields[1] == label {
+				hasLabel = true
+			}
+		}
+	}
+	if !hasLabel {
+		return nil, fmt.Errorf("%w: extlinux.conf has no %s entry", ErrNoSelector, label)
+	}
+	if !hasDefault {
+		lines = append([]string{"default " + label}, lines...)
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// writeFileSync replaces path through a temporary file so that a power cut
+// leaves either the old or the new contents; the boot partition may be FAT.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
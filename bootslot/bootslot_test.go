@@ -0,0 +1,140 @@
+package bootslot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	for _, s := range []State{{Active: A}, {Active: B, Trial: A}} {
+		got, err := ParseState(s.Marshal())
+		if err != nil {
+			t.Fatalf("ParseState(%q): %v", s.Marshal(), err)
+		}
+		if got != s {
+			t.Fatalf("round trip = %+v, want %+v", got, s)
+		}
+	}
+}
+
+func TestParseStateRejectsBadSlots(t *testing.T) {
+	for _, in := range []string{"", "active=c\n", "active=a\ntrial=a\n", "active=a\ntrial=x\n", "garbage\n"} {
+		if _, err := ParseState([]byte(in)); !errors.Is(err, ErrInvalidState) {
+			t.Fatalf("ParseState(%q) err = %v, want ErrInvalidState", in, err)
+		}
+	}
+}
+
+func TestStateNext(t *testing.T) {
+	if got := (State{Active: A}).Next(); got != A {
+		t.Fatalf("Next = %q, want a", got)
+	}
+	if got := (State{Active: A, Trial: B}).Next(); got != B {
+		t.Fatalf("Next with trial = %q, want b", got)
+	}
+}
+
+func TestCmdline(t *testing.T) {
+	cmdline := "console=tty1 root=/dev/ram0 tezsign.slot=b rw"
+	if got := FromCmdline(cmdline); got != B {
+		t.Fatalf("FromCmdline = %q, want b", got)
+	}
+	if got := FromCmdline("console=tty1 tezsign.slot=z"); got != "" {
+		t.Fatalf("FromCmdline with bad slot = %q, want empty", got)
+	}
+
+	if got := SetCmdline(cmdline, A); got != "console=tty1 root=/dev/ram0 tezsign.slot=a rw" {
+		t.Fatalf("SetCmdline replace = %q", got)
+	}
+	if got := SetCmdline("console=tty1\n", B); got != "console=tty1 tezsign.slot=b" {
+		t.Fatalf("SetCmdline append = %q", got)
+	}
+}
+
+const testExtlinux = `default TezSign-a
+label TezSign-a
+   kernel /slot_a/Image
+   append root=/dev/ram0 tezsign.slot=a
+label TezSign-b
+   kernel /slot_b/Image
+   append root=/dev/ram0 tezsign.slot=b
+`
+
+func TestSetSelectorExtlinux(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, ExtlinuxFile)
+	if err := os.MkdirAll(filepath.Dir(conf), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(conf, []byte(testExtlinux), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetSelector(dir, B); err != nil {
+		t.Fatalf("SetSelector: %v", err)
+	}
+	got, _ := os.ReadFile(conf)
+	if !strings.HasPrefix(string(got), "default TezSign-b\n") {
+		t.Fatalf("extlinux.conf = %q, want default TezSign-b", got)
+	}
+	if string(got[len("default TezSign-b"):]) != testExtlinux[len("default TezSign-a"):] {
+		t.Fatalf("SetSelector changed more than the default line: %q", got)
+	}
+}
+
+func TestSetSelectorExtlinuxNeedsSlotEntry(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, ExtlinuxFile)
+	if err := os.MkdirAll(filepath.Dir(conf), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := "default TezSign\nlabel TezSign\n   kernel /Image\n"
+	if err := os.WriteFile(conf, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetSelector(dir, B); !errors.Is(err, ErrNoSelector) {
+		t.Fatalf("SetSelector on single-slot extlinux.conf err = %v, want ErrNoSelector", err)
+	}
+	if got, _ := os.ReadFile(conf); string(got) != legacy {
+		t.Fatalf("extlinux.conf rewritten on error: %q", got)
+	}
+}
+
+func TestSetSelectorPi(t *testing.T) {
+	dir := t.TempDir()
+	if err := SetSelector(dir, A); !errors.Is(err, ErrNoSelector) {
+		t.Fatalf("SetSelector without selector err = %v, want ErrNoSelector", err)
+	}
+
+	selector := filepath.Join(dir, PiSelectorFile)
+	if err := os.WriteFile(selector, []byte("os_prefix=slot_a/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetSelector(dir, B); err != nil {
+		t.Fatalf("SetSelector: %v", err)
+	}
+	if got, _ := os.ReadFile(selector); string(got) != "os_prefix=slot_b/\n" {
+		t.Fatalf("slot.txt = %q", got)
+	}
+	if _, err := os.Stat(selector + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("temporary selector left behind: %v", err)
+	}
+}
+
+func TestWriteStateValidates(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteState(dir, State{Active: A, Trial: A}); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("WriteState err = %v, want ErrInvalidState", err)
+	}
+	if err := WriteState(dir, State{Active: A, Trial: B}); err != nil {
+		t.Fatalf("WriteState: %v", err)
+	}
+	got, err := ReadState(dir)
+	if err != nil || got != (State{Active: A, Trial: B}) {
+		t.Fatalf("ReadState = %+v, %v", got, err)
+	}
+}
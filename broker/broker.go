@@ -6,7 +6,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,10 +21,18 @@ type ReadContexter interface {
 	ReadContext(ctx context.Context, p []byte) (int, error)
 }
 
+// WriteContexter implementations must be done with p when WriteContext
+// returns; the broker reuses frame buffers (see pool.go).
 type WriteContexter interface {
 	WriteContext(ctx context.Context, p []byte) (int, error)
 }
 
+// Handler answers a request. payload is recycled once it returns, so keep a
+// copy of anything needed later; returning (part of) payload as the response
+// is fine.
+// A *TransportError returned as the error is sent to the peer as an error
+// frame in place of the response, when both ends have FeatureErrors; any
+// other error is ignored.
 type Handler func(ctx context.Context, payload []byte) ([]byte, error)
 
 type options struct {
@@ -31,6 +40,19 @@ type options struct {
 	handler   Handler
 	logger    *slog.Logger
 	keepAlive time.Duration
+	handshake bool
+	features  Feature
+	noise     *NoiseConfig
+	priority  func(payload []byte) Priority
+
+	heartbeat       time.Duration
+	heartbeatMisses int
+
+	drainTimeout time.Duration
+	reapInterval time.Duration
+
+	ackTimeout  time.Duration
+	retransmits int
 }
 
 type Option func(*options)
@@ -55,6 +77,13 @@ func WithLogger(l *slog.Logger) Option {
 	}
 }
 
+// WithHandshake makes the broker announce itself with a hello frame at start
+// and answer the peer's. Without it the broker behaves as protocol version 0
+// and ignores hellos.
+func WithHandshake() Option {
+	return func(o *options) { o.handshake = true }
+}
+
 func WithKeepAlive(d time.Duration) Option {
 	return func(o *options) {
 		if d > 0 {
@@ -72,9 +101,17 @@ type Broker struct {
 	waiters waiterMap
 	handler Handler
 
-	writeChan           chan []byte
-	processingRequests  requestMap[struct{}]
-	unconfirmedRequests requestMap[[]byte]
+	// two write lanes, see priority.go
+	writeChan           chan outFrame
+	writeHigh           chan outFrame
+	priority            func(payload []byte) Priority
+	seen                *seenRequests // see dedup.go
+	unconfirmedRequests requestMap[outFrame]
+
+	// retransmission of unaccepted requests; see retransmit.go
+	ackTimeout    time.Duration
+	retransmits   int
+	retransmitted atomic.Uint64
 
 	capacity int
 	logger   *slog.Logger
@@ -83,6 +120,75 @@ type Broker struct {
 	keepAliveTimer *time.Timer
 	keepAliveTick  <-chan time.Time
 
+	// handshake: local features and the peer's last hello
+	handshake     bool
+	features      Feature
+	peer          atomic.Pointer[PeerInfo]
+	handshakeOnce sync.Once
+	handshakeDone chan struct{}
+
+	// noise: see secure.go. The session's send side is used by the writer
+	// loop only, its receive side by the read loop only.
+	noise          *NoiseConfig
+	noiseMu        sync.Mutex
+	noisePending   *noiseHandshake
+	noisePendingID [16]byte
+	noiseErr       error
+	noiseChanged   chan struct{}
+	noiseSince     time.Time
+	session        atomic.Pointer[noiseSession]
+	secured        atomic.Bool // a session has been up; see requireNoise
+
+	// fragmented messages in progress; the read loop's, and the reaper's
+	// under assemblyMu
+	assemblyMu sync.Mutex
+	assemblies map[[16]byte]*assembly
+
+	// sequencing: txSeq is the writer loop's, rxSeq the read loop's
+	txSeq        uint64
+	rxSeq        uint64
+	rxSeqSeen    bool
+	seqGaps      atomic.Uint64
+	orderedQueue chan func()
+
+	// streamed responses: streams are RequestStream callers, parts the
+	// chunks a plain Request has received so far; see stream.go
+	streams sync.Map // [16]byte -> *ResponseStream
+	partsMu sync.Mutex
+	parts   map[[16]byte][]byte
+
+	// requests in the handler right now; see errframe.go
+	activeRequests atomic.Int32
+
+	// heartbeat: lastRx is the unix nano time of the last byte read
+	heartbeat       time.Duration
+	heartbeatMisses int
+	lastRx          atomic.Int64
+	rtt             atomic.Int64
+	dead            chan struct{}
+	deadOnce        sync.Once
+	heartbeatDone   <-chan struct{}
+
+	// flow control: outstanding is what we sent the peer that it has not
+	// handed back yet; pendingCredit is what we owe the peer
+	flowMu        sync.Mutex
+	outstanding   int64
+	creditChanged chan struct{}
+	pendingCredit atomic.Int64
+
+	// leftovers dropped by the reaper; see reap.go
+	reapedWaiters    atomic.Uint64
+	reapedAssemblies atomic.Uint64
+	reapedStash      atomic.Uint64
+
+	// drain: calls are our requests awaiting an answer, inflight the
+	// peer's being handled, queued the frames not yet written; see drain.go
+	drainTimeout time.Duration
+	closing      atomic.Bool
+	calls        atomic.Int64
+	inflight     atomic.Int64
+	queued       atomic.Int64
+
 	ctx            context.Context
 	cancel         context.CancelFunc
 	readLoopDone   <-chan struct{}
@@ -91,7 +197,8 @@ type Broker struct {
 
 func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
 	o := &options{
-		bufSize: DEFAULT_BROKER_CAPACITY,
+		bufSize:      DEFAULT_BROKER_CAPACITY,
+		reapInterval: DefaultReapInterval,
 	}
 	for _, fn := range opts {
 		fn(o)
@@ -104,6 +211,10 @@ func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
 	if o.handler == nil {
 		panic("broker: handler is required (use WithHandler)")
 	}
+	if o.handshake {
+		// every broker that speaks the handshake answers pings
+		o.features |= FeatureHeartbeat
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	b := &Broker{
@@ -114,10 +225,29 @@ func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
 		handler:       o.handler,
 		keepAlive:     o.keepAlive,
 		keepAliveTick: nil,
-
-		writeChan:           make(chan []byte, 32),
-		processingRequests:  NewRequestMap[struct{}](),
-		unconfirmedRequests: NewRequestMap[[]byte](),
+		handshake:     o.handshake,
+		features:      o.features,
+		handshakeDone: make(chan struct{}),
+		noise:         o.noise,
+		noiseChanged:  make(chan struct{}),
+		noiseSince:    time.Now(),
+		assemblies:    make(map[[16]byte]*assembly),
+		parts:         make(map[[16]byte][]byte),
+
+		heartbeat:       o.heartbeat,
+		heartbeatMisses: o.heartbeatMisses,
+		dead:            make(chan struct{}),
+		creditChanged:   make(chan struct{}),
+		drainTimeout:    o.drainTimeout,
+		ackTimeout:      o.ackTimeout,
+		retransmits:     o.retransmits,
+		orderedQueue:    make(chan func(), orderedQueueLen),
+
+		writeChan:           make(chan outFrame, 32),
+		writeHigh:           make(chan outFrame, 32),
+		priority:            o.priority,
+		seen:                newSeenRequests(seenRequestsMax),
+		unconfirmedRequests: NewRequestMap[outFrame](),
 
 		stash:  newStash(o.bufSize, o.logger),
 		ctx:    ctx,
@@ -128,45 +258,110 @@ func New(r ReadContexter, w WriteContexter, opts ...Option) *Broker {
 		b.keepAliveTick = b.keepAliveTimer.C
 	}
 
+	go b.orderedLoop()
+	go b.reapLoop(o.reapInterval)
 	b.readLoopDone = b.readLoop()
 	b.writerLoopDone = b.writerLoop()
+	if b.heartbeat > 0 {
+		b.heartbeatDone = b.heartbeatLoop()
+	}
+	if b.handshake {
+		b.sendHello(false)
+	}
 	return b
 }
 
 func (b *Broker) Request(ctx context.Context, payload []byte) ([]byte, [16]byte, error) {
-	var id [16]byte
+	id, ch, err := b.send(ctx, payload, nil)
+	if err != nil {
+		return nil, id, err
+	}
+	defer b.calls.Add(-1)
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, id, r.err
+		}
+		return r.payload, id, nil
+	case <-ctx.Done():
+		b.forgetRequest(id)
+		return nil, id, ctx.Err()
+	case <-b.dead:
+		b.forgetRequest(id)
+		return nil, id, ErrPeerDead
+	case <-b.ctx.Done():
+		b.forgetRequest(id)
+		return nil, id, io.EOF
+	}
+}
+
+// send queues payload as a new request and returns the channel its reply
+// arrives on. A stream is registered before the request goes out, so no part
+// can beat it.
+func (b *Broker) send(ctx context.Context, payload []byte, stream *ResponseStream) (id [16]byte, ch chan reply, err error) {
+	if b.closing.Load() {
+		return id, nil, ErrClosing
+	}
+	b.calls.Add(1)
+	defer func() {
+		if err != nil {
+			b.calls.Add(-1)
+		}
+	}()
 	payloadLen := len(payload)
 	if payloadLen > int(^uint32(0)) {
-		return nil, id, fmt.Errorf("payload too large")
+		return id, nil, fmt.Errorf("payload too large")
+	}
+
+	select {
+	case <-b.dead:
+		return id, nil, ErrPeerDead
+	default:
+	}
+	if b.negotiated(FeatureFragmentation) {
+		if payloadLen > MAX_REASSEMBLED_PAYLOAD {
+			return id, nil, fmt.Errorf("payload exceeds maximum reassembled payload (%d bytes)", MAX_REASSEMBLED_PAYLOAD)
+		}
+	} else {
+		if payloadLen > MAX_MESSAGE_PAYLOAD {
+			return id, nil, fmt.Errorf("payload exceeds maximum message payload (%d bytes)", MAX_MESSAGE_PAYLOAD)
+		}
+		if peer, ok := b.Peer(); ok && payloadLen > int(peer.MaxPayload) {
+			return id, nil, fmt.Errorf("%w (%d bytes)", ErrPeerPayloadSize, peer.MaxPayload)
+		}
 	}
 
-	if payloadLen > MAX_MESSAGE_PAYLOAD {
-		return nil, id, fmt.Errorf("payload exceeds maximum message payload (%d bytes)", MAX_MESSAGE_PAYLOAD)
+	sealed, err := b.secureRequests(ctx)
+	if err != nil {
+		return id, nil, err
 	}
 
-	id, ch := b.waiters.NewWaiter()
-	b.unconfirmedRequests.Store(id, payload)
+	id, ch = b.waiters.NewWaiter(ctx)
+	if stream != nil {
+		stream.ID = id
+		b.streams.Store(id, stream)
+	}
+	req := outFrame{typ: payloadTypeRequest, id: id, payload: payload, seal: sealed, prio: priorityFrom(ctx), deadline: b.requestDeadline(ctx), ordered: orderedFrom(ctx)}
+	b.unconfirmedRequests.Store(id, req)
 
-	b.logger.Debug("tx req", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", payloadLen))
+	b.logger.Debug("tx req", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", payloadLen), slog.Bool("sealed", sealed))
 
-	if err := b.writeFrame(ctx, payloadTypeRequest, id, payload); err != nil {
+	if err := b.enqueue(ctx, req); err != nil {
 		b.logger.Debug("tx req write failed", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
-		b.waiters.Delete(id)
-		return nil, id, err
+		b.forgetRequest(id)
+		return id, nil, err
 	}
+	b.armAck(id, 0)
+	return id, ch, nil
+}
 
-	select {
-	case resp := <-ch:
-		return resp, id, nil
-	case <-ctx.Done():
-		b.unconfirmedRequests.Delete(id)
-		b.waiters.Delete(id)
-		return nil, id, ctx.Err()
-	case <-b.ctx.Done():
-		b.unconfirmedRequests.Delete(id)
-		b.waiters.Delete(id)
-		return nil, id, io.EOF
-	}
+// forgetRequest drops what is kept for a request its caller gave up on.
+func (b *Broker) forgetRequest(id [16]byte) {
+	b.unconfirmedRequests.Delete(id)
+	b.waiters.Delete(id)
+	b.streams.Delete(id)
+	b.dropParts(id)
 }
 
 func (b *Broker) writerLoop() <-chan struct{} {
@@ -181,14 +376,40 @@ func (b *Broker) writerLoop() <-chan struct{} {
 		}
 
 		for {
-			var data []byte
-			select {
-			case data = <-b.writeChan:
-			case <-b.keepAliveTick:
+			var (
+				data []byte
+				enc  *frameBuf
+			)
+			f, ok, done := b.nextFrame()
+			switch {
+			case done:
+				return
+			case !ok:
 				b.logger.Log(context.Background(), -100, "keep-alive tick")
 				data = keepAliveFrame
-			case <-b.ctx.Done():
-				return
+			default:
+				f = b.sequenceFrame(f)
+				if f.seal {
+					if f, err = b.sealFrame(f); err != nil {
+						b.logger.Warn("no secure session; dropping frame", slog.String("id", fmt.Sprintf("%x", f.id)))
+						b.releaseCredit(f.credit)
+						f.release()
+						b.queued.Add(-1)
+						continue
+					}
+				}
+				enc, err = b.encodeFrame(f)
+				f.release() // the encoded copy is all the writer needs now
+				if err != nil {
+					b.logger.Error("failed to create message frame", slog.Any("error", err))
+					b.releaseCredit(f.credit)
+					b.queued.Add(-1)
+					continue
+				}
+				data = enc.B
+				if f.credit > 0 {
+					b.adjustCredit(int64(len(data)) - f.credit)
+				}
 			}
 			retries := 0
 
@@ -205,6 +426,9 @@ func (b *Broker) writerLoop() <-chan struct{} {
 							continue writeAttempt
 						}
 						b.logger.Error("write retry limit reached; dropping frame", slog.Int("retries", retries), slog.Any("err", err))
+						if f.credit > 0 {
+							b.releaseCredit(int64(len(data)))
+						}
 						break writeAttempt
 					}
 					b.logger.Error("write loop exit", slog.Any("err", err))
@@ -215,6 +439,12 @@ func (b *Broker) writerLoop() <-chan struct{} {
 				b.resetKeepAliveTimerAfterSuccessfulWrite()
 				break writeAttempt
 			}
+			// on shutdown the loop returns above without this: a writer
+			// that gave up on its ctx may still be reading the buffer
+			if enc != nil {
+				enc.release()
+				b.queued.Add(-1)
+			}
 		}
 	}()
 	return done
@@ -228,6 +458,9 @@ func (b *Broker) readLoop() <-chan struct{} {
 		for {
 			n, err := b.r.ReadContext(b.ctx, buf[:])
 			if n > 0 {
+				now := time.Now()
+				b.lastRx.Store(now.UnixNano())
+				b.reapStash(now)
 				b.stash.Write(buf[:n])
 				clear(buf[:n]) // clear buffer after we used it
 				b.processStash()
@@ -254,68 +487,273 @@ func (b *Broker) readLoop() <-chan struct{} {
 
 func (b *Broker) processStash() {
 	for {
-		id, pt, payload, err := b.stash.ReadPayload()
+		h, fb, err := b.stash.ReadFrame()
 		switch {
 		case errors.Is(err, ErrNoPayloadFound):
 			fallthrough
+		case errors.Is(err, ErrIncompleteHeader):
+			fallthrough
 		case errors.Is(err, ErrIncompletePayload):
-			runtime.GC() // encourage freeing stash buffers
+			b.flushCredit()
+			b.stash.shrink()
 			return
 		case errors.Is(err, ErrInvalidPayloadSize):
 			continue // resync
+		case errors.Is(err, ErrChecksumMismatch):
+			continue // counted and logged by the stash; resync
 		case err != nil:
 			b.logger.Warn("bad payload; resync", slog.Any("err", err))
 			continue // resync
 		}
+		b.receiveFrame(h, fb)
+	}
+}
 
-		go func(id [16]byte, payloadType payloadType, payload []byte) {
-			switch payloadType {
-			case payloadTypeResponse:
-				b.logger.Debug("rx resp", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(payload)))
-				if ch, ok := b.waiters.LoadAndDelete(id); ok && ch != nil {
-					ch <- payload
-				}
-			case payloadTypeRequest:
-				b.logger.Debug("rx req", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(payload)))
-				if processing := b.processingRequests.HasRequest(id); processing {
-					b.logger.Debug("duplicate request being processed; ignoring", slog.String("id", fmt.Sprintf("%x", id)))
+// receiveFrame unwraps one frame off the stash and dispatches it. fb holds
+// the payload; whichever step ends up owning the bytes releases it.
+func (b *Broker) receiveFrame(h Header, fb *frameBuf) {
+	id, pt, payload := h.ID, h.Type, fb.B
+	owned := fb
+	defer func() {
+		if owned != nil {
+			owned.release()
+		}
+	}()
+	// replace hands the payload over to a new buffer (nil: a heap slice)
+	replace := func(next *frameBuf) {
+		if owned != nil {
+			owned.release()
+		}
+		owned = next
+	}
+	b.consumed(pt, h.Len()+len(payload))
+
+	var err error
+	sealed := false
+	switch pt {
+	case payloadTypeNoise:
+		// in line: the session must exist before the next frame is opened
+		b.handleNoise(id, payload)
+		return
+	case payloadTypeSealed:
+		var plain *frameBuf
+		if pt, payload, plain, err = b.openFrame(id, payload); err != nil {
+			b.logger.Warn("dropping sealed frame", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+			return
+		}
+		replace(plain)
+		sealed = true
+	}
+	ordered := false
+	if pt == payloadTypeSequenced {
+		if pt, payload, ordered, err = b.openSequence(id, payload); err != nil {
+			b.logger.Warn("dropping sequenced frame", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+			return
+		}
+	}
+	if pt == payloadTypeFragment {
+		var whole bool
+		request := isRequestFragment(payload)
+		if pt, payload, whole, err = b.reassemble(id, payload, sealed); err != nil {
+			b.logger.Warn("dropping fragment", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+			if errors.Is(err, ErrInvalidPayloadSize) && request {
+				b.sendError(id, ErrorCodeTooLarge, fmt.Sprintf("over %d bytes reassembled", MAX_REASSEMBLED_PAYLOAD))
+			}
+			return
+		}
+		if !whole {
+			return
+		}
+		replace(nil)
+	}
+	var budget time.Duration
+	if pt == payloadTypeDeadline {
+		if pt, payload, budget, err = openDeadline(payload); err != nil {
+			b.logger.Warn("dropping request with a bad deadline", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+			return
+		}
+	}
+	if pt == payloadTypeCompressedRequest || pt == payloadTypeCompressedResponse {
+		request := pt == payloadTypeCompressedRequest
+		if pt, payload, err = inflateFrame(pt, payload); err != nil {
+			b.logger.Warn("dropping compressed frame", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+			if errors.Is(err, ErrInvalidPayloadSize) && request {
+				b.sendError(id, ErrorCodeTooLarge, fmt.Sprintf("over %d bytes inflated", MAX_REASSEMBLED_PAYLOAD))
+			}
+			return
+		}
+		replace(nil)
+	}
+	if !sealed && (pt == payloadTypeRequest || pt == payloadTypeResponse || pt == payloadTypeResponsePart) && !b.acceptPlaintext() {
+		b.logger.Warn("dropping plaintext frame on a secure link", slog.String("id", fmt.Sprintf("%x", id)))
+		return
+	}
+	if pt == payloadTypeResponsePart {
+		// in line, ahead of the final response; the bytes go to the caller
+		b.handlePart(id, payload)
+		owned = nil
+		return
+	}
+
+	handle := func(id [16]byte, payloadType payloadType, payload []byte, budget time.Duration, buf *frameBuf) {
+		if payloadType != payloadTypeRequest && payloadType != payloadTypeResponse {
+			defer buf.release() // control frames are done with once handled
+		}
+		switch payloadType {
+		case payloadTypeResponse:
+			// the bytes go to the caller of Request; buf is left to the GC
+			b.logger.Debug("rx resp", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(payload)))
+			// a response confirms the request as well as an accept does
+			b.unconfirmedRequests.Delete(id)
+			if ch, ok := b.waiters.LoadAndDelete(id); ok && ch != nil {
+				ch <- reply{payload: b.takeParts(id, payload)}
+			}
+		case payloadTypeRequest:
+			defer b.inflight.Add(-1)
+			b.logger.Debug("rx req", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(payload)))
+			first, cached := b.seen.begin(id)
+
+			// accept the request immediately, handing back flow control
+			// credit; a duplicate is accepted again as the first accept
+			// may be what got lost
+			b.writeFrame(b.ctx, payloadTypeAcceptRequest, id, b.takeCredit())
+
+			prio := PriorityNormal
+			if b.priority != nil {
+				prio = b.priority(payload)
+			}
+			if !first {
+				buf.release()
+				if cached == nil {
+					b.logger.Debug("duplicate request; ignoring", slog.String("id", fmt.Sprintf("%x", id)))
 					return
 				}
-				b.processingRequests.Store(id, struct{}{})
+				b.logger.Debug("duplicate of a finished request; resending response", slog.String("id", fmt.Sprintf("%x", id)))
+				_ = b.enqueue(b.ctx, outFrame{typ: payloadTypeResponse, id: id, payload: cached, seal: sealed, prio: prio, ordered: ordered})
+				return
+			}
 
-				// accept the request immediately
-				b.writeFrame(b.ctx, payloadTypeAcceptRequest, id, nil)
+			if b.handler == nil {
+				buf.release()
+				return
+			}
+			if b.closing.Load() && b.negotiated(FeatureErrors) {
+				buf.release()
+				b.seen.forget(id)
+				b.sendError(id, ErrorCodeShuttingDown, "")
+				return
+			}
+			if n := b.activeRequests.Add(1); n > maxActiveRequests && b.negotiated(FeatureErrors) {
+				b.activeRequests.Add(-1)
+				buf.release()
+				b.seen.forget(id)
+				b.sendError(id, ErrorCodeOverload, fmt.Sprintf("%d requests in progress", n-1))
+				return
+			}
+			hctx, cancel := b.handlerContext(budget)
+			ps := &partSender{b: b, id: id, sealed: sealed, prio: prio, ordered: ordered}
+			hctx = context.WithValue(hctx, partSenderKey{}, ps)
+			resp, herr := b.handler(hctx, payload)
+			b.activeRequests.Add(-1)
+			if te, ok := handlerError(herr); ok && b.negotiated(FeatureErrors) {
+				buf.release()
+				cancel()
+				b.seen.forget(id)
+				b.sendError(id, te.Code, te.Message)
+				return
+			}
+			if ps.sent.Load() {
+				// the final chunk alone would be a truncated answer
+				b.seen.finish(id, nil)
+			} else {
+				b.seen.finish(id, resp)
+			}
+			// unless the handler answered with (part of) the request itself
+			buf.releaseUnless(resp)
+			expired := budget > 0 && hctx.Err() != nil
+			cancel()
+			if expired {
+				// the caller has stopped waiting for it
+				b.logger.Debug("request deadline passed; dropping response", slog.String("id", fmt.Sprintf("%x", id)), slog.Duration("budget", budget))
+				return
+			}
 
-				if b.handler == nil {
-					return
-				}
-				defer b.processingRequests.Delete(id)
-				resp, _ := b.handler(b.ctx, payload)
-
-				b.logger.Debug("tx resp", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(resp)))
-				_ = b.writeFrame(b.ctx, payloadTypeResponse, id, resp) // Put is deferred inside writeFrame if pooled
-			case payloadTypeAcceptRequest:
-				b.logger.Debug("rx accept", slog.String("id", fmt.Sprintf("%x", id)))
-				b.unconfirmedRequests.Delete(id)
-			case payloadTypeRetry:
-				b.logger.Debug("rx retry", slog.String("id", fmt.Sprintf("%x", id)))
-				allUnconfirmed := b.unconfirmedRequests.All()
-				for reqID, reqPayload := range allUnconfirmed {
-					b.writeFrame(b.ctx, payloadTypeRequest, reqID, reqPayload)
-				}
-			case payloadTypeKeepAlive:
-				b.logger.Log(context.Background(), -100, "rx keep-alive")
-			default:
-				b.logger.Warn("unknown type; resync", slog.String("type", fmt.Sprintf("%02x", payloadType)), slog.String("id", fmt.Sprintf("%x", id)))
+			b.logger.Debug("tx resp", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("size", len(resp)))
+			_ = b.enqueue(b.ctx, outFrame{typ: payloadTypeResponse, id: id, payload: resp, seal: sealed, prio: prio, ordered: ordered})
+		case payloadTypeAcceptRequest:
+			b.logger.Debug("rx accept", slog.String("id", fmt.Sprintf("%x", id)))
+			b.unconfirmedRequests.Delete(id)
+			b.handleCredit(payload)
+		case payloadTypeCredit:
+			b.handleCredit(payload)
+		case payloadTypeError:
+			b.handleError(id, payload)
+		case payloadTypeRetry:
+			b.logger.Debug("rx retry", slog.String("id", fmt.Sprintf("%x", id)))
+			allUnconfirmed := b.unconfirmedRequests.All()
+			reseal := b.session.Load() != nil
+			for _, req := range allUnconfirmed {
+				req.seal = reseal
+				b.enqueue(b.ctx, req)
 			}
-		}(id, pt, payload)
+		case payloadTypeKeepAlive:
+			b.logger.Log(context.Background(), -100, "rx keep-alive")
+		case payloadTypeHello:
+			b.handleHello(payload)
+		case payloadTypePing:
+			b.handlePing(payload)
+		case payloadTypePong:
+			b.handlePong(payload)
+		default:
+			b.logger.Warn("unknown type; resync", slog.String("type", fmt.Sprintf("%02x", payloadType)), slog.String("id", fmt.Sprintf("%x", id)))
+			if id != ([16]byte{}) {
+				b.sendError(id, ErrorCodeUnsupportedVersion, fmt.Sprintf("frame type %02x", payloadType))
+			}
+		}
+	}
+	buf := owned
+	owned = nil // handle releases it
+	if pt == payloadTypeRequest {
+		b.inflight.Add(1) // before handle runs, so Close cannot miss it
 	}
+	if ordered {
+		b.deliverOrdered(func() { handle(id, pt, payload, budget, buf) })
+	} else {
+		go handle(id, pt, payload, budget, buf)
+	}
+}
+
+// outFrame is a frame queued for the writer loop, which encodes it (and
+// seals it, see secure.go) just before it goes out.
+type outFrame struct {
+	typ     payloadType
+	id      [16]byte
+	payload []byte
+	seal    bool
+	prio    Priority
+	credit  int64 // flow control bytes booked for it, see flow.go
+	// deadline is the caller's, sent along with requests; see deadline.go
+	deadline time.Time
+	ordered  bool // see sequence.go
+	// pooled holds the buffers behind payload once the writer loop wraps
+	// it; see pool.go
+	pooled []*frameBuf
 }
 
-// writeFrame writes header+payload in one go.
-// Uses pooled buffer for payloads <= MAX_POOLED_PAYLOAD and defers Put.
-// Larger payloads allocate a right-sized frame (no Put).
-func (b *Broker) writeFrame(ctx context.Context, msgType payloadType, id [16]byte, payload []byte) (err error) {
+// release returns the frame's pooled buffers.
+func (f *outFrame) release() {
+	for _, fb := range f.pooled {
+		fb.release()
+	}
+	f.pooled = nil
+}
+
+// writeFrame queues a plaintext control frame on the high lane.
+func (b *Broker) writeFrame(ctx context.Context, msgType payloadType, id [16]byte, payload []byte) error {
+	return b.enqueue(ctx, outFrame{typ: msgType, id: id, payload: payload, prio: PriorityHigh})
+}
+
+func (b *Broker) enqueue(ctx context.Context, frame outFrame) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			b.logger.Error("panic in Request", slog.Any("recover", r))
@@ -323,10 +761,10 @@ func (b *Broker) writeFrame(ctx context.Context, msgType payloadType, id [16]byt
 		}
 	}()
 
-	frame, err := newMessage(msgType, id, payload)
-	if err != nil {
-		b.logger.Error("failed to create message frame", slog.Any("error", err))
-		return err
+	frame = deadlineFrame(b.compressFrame(frame))
+	if len(frame.payload) > int(^uint32(0)) {
+		b.logger.Error("failed to create message frame", slog.Any("error", ErrEncodeHeaderPayloadLarge))
+		return ErrEncodeHeaderPayloadLarge
 	}
 	if err := ctx.Err(); err != nil {
 		return err
@@ -335,20 +773,55 @@ func (b *Broker) writeFrame(ctx context.Context, msgType payloadType, id [16]byt
 		return io.EOF
 	}
 
-	select {
-	case b.writeChan <- frame:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-b.ctx.Done():
-		return io.EOF
+	lane := b.writeChan
+	if frame.prio == PriorityHigh {
+		lane = b.writeHigh
 	}
+	for _, f := range b.fragmentFrame(frame) {
+		if isDataFrame(f.typ) {
+			if f.credit, err = b.acquireCredit(ctx, frameCreditEstimate(f)); err != nil {
+				return err
+			}
+		}
+		b.queued.Add(1)
+		select {
+		case lane <- f:
+		case <-ctx.Done():
+			b.queued.Add(-1)
+			b.releaseCredit(f.credit)
+			return ctx.Err()
+		case <-b.ctx.Done():
+			b.queued.Add(-1)
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// Done is closed once the broker stops, including when its reader hits a
+// non-retryable error such as the stream closing.
+func (b *Broker) Done() <-chan struct{} {
+	return b.ctx.Done()
 }
 
+// Stop tears the broker down, draining first when WithDrainTimeout is set.
 func (b *Broker) Stop() {
+	if b.drainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), b.drainTimeout)
+		defer cancel()
+		_ = b.Close(ctx)
+		return
+	}
+	b.shutdown()
+}
+
+func (b *Broker) shutdown() {
 	b.cancel()
 	<-b.readLoopDone
 	<-b.writerLoopDone
+	if b.heartbeatDone != nil {
+		<-b.heartbeatDone
+	}
 	b.stopKeepAliveTimer()
 }
 
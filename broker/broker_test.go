@@ -1,13 +1,22 @@
 package broker
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/tez-capital/tezsign/broker/brokertest"
 )
 
 type blockingReader struct{}
@@ -41,7 +50,7 @@ func (w *scriptedWriter) Calls() int {
 	return w.calls
 }
 
-func newTestBroker(t *testing.T, writer WriteContexter) *Broker {
+func newTestBroker(t testing.TB, writer WriteContexter) *Broker {
 	t.Helper()
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	return New(blockingReader{}, writer,
@@ -52,6 +61,58 @@ func newTestBroker(t *testing.T, writer WriteContexter) *Broker {
 	)
 }
 
+// chanPipe is one direction of an in-memory link between two brokers.
+type chanPipe struct {
+	ch   chan []byte
+	mu   sync.Mutex
+	rest []byte // unread tail of a write larger than the reader's buffer
+}
+
+func newChanPipe() *chanPipe { return &chanPipe{ch: make(chan []byte, 64)} }
+
+func (p *chanPipe) ReadContext(ctx context.Context, buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rest) == 0 {
+		select {
+		case p.rest = <-p.ch:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	n := copy(buf, p.rest)
+	p.rest = p.rest[n:]
+	return n, nil
+}
+
+func (p *chanPipe) WriteContext(ctx context.Context, data []byte) (int, error) {
+	select {
+	case p.ch <- append([]byte(nil), data...):
+		return len(data), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// newBrokerPair links a host-side and a gadget-side broker; the gadget echoes
+// requests back.
+func newBrokerPair(t *testing.T, hostOpts, gadgetOpts []Option) (host, gadget *Broker) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := newChanPipe(), newChanPipe()
+
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte(nil), payload...), nil
+	})
+	gadget = New(toGadget, toHost, append([]Option{WithLogger(logger), echo}, gadgetOpts...)...)
+	host = New(toHost, toGadget, append([]Option{WithLogger(logger), echo}, hostOpts...)...)
+	t.Cleanup(func() {
+		host.Stop()
+		gadget.Stop()
+	})
+	return host, gadget
+}
+
 func waitForCondition(t *testing.T, condition func() bool) {
 	t.Helper()
 	deadline := time.Now().Add(2 * time.Second)
@@ -121,3 +182,1248 @@ func TestWriterLoopDropsFrameAfterRetryLimit(t *testing.T) {
 		t.Fatalf("broker canceled unexpectedly after next frame: %v", err)
 	}
 }
+
+func TestHandshakeExchangesPeerInfo(t *testing.T) {
+	host, gadget := newBrokerPair(t, []Option{WithHandshake()}, []Option{WithHandshake()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, b := range []*Broker{host, gadget} {
+		peer, err := b.WaitHandshake(ctx)
+		if err != nil {
+			t.Fatalf("WaitHandshake: %v", err)
+		}
+		if peer.Version != ProtocolVersion || peer.MaxPayload != MAX_MESSAGE_PAYLOAD {
+			t.Fatalf("unexpected peer info: %+v", peer)
+		}
+	}
+
+	resp, _, err := host.Request(ctx, []byte("ping"))
+	if err != nil || string(resp) != "ping" {
+		t.Fatalf("Request after handshake: %q, %v", resp, err)
+	}
+}
+
+func TestHandshakeDegradesWithLegacyPeer(t *testing.T) {
+	host, gadget := newBrokerPair(t, []Option{WithHandshake()}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, _, err := host.Request(ctx, []byte("ping"))
+	if err != nil || string(resp) != "ping" {
+		t.Fatalf("Request to legacy peer: %q, %v", resp, err)
+	}
+	if _, ok := host.Peer(); ok {
+		t.Fatalf("legacy peer must not look handshaken")
+	}
+	if _, ok := gadget.Peer(); ok {
+		t.Fatalf("broker without handshake recorded a hello")
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if _, err := host.WaitHandshake(short); err == nil {
+		t.Fatalf("expected WaitHandshake to time out against a legacy peer")
+	}
+}
+
+// tapWriter records everything written to the link.
+type tapWriter struct {
+	*chanPipe
+	mu   sync.Mutex
+	wire []byte
+}
+
+func (w *tapWriter) WriteContext(ctx context.Context, data []byte) (int, error) {
+	w.mu.Lock()
+	w.wire = append(w.wire, data...)
+	w.mu.Unlock()
+	return w.chanPipe.WriteContext(ctx, data)
+}
+
+func newStaticKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func TestNoiseSealsTrafficBetweenPinnedPeers(t *testing.T) {
+	hostKey, gadgetKey := newStaticKey(t), newStaticKey(t)
+	pin := func(want *ecdh.PrivateKey) func([]byte) error {
+		return func(static []byte) error {
+			if !bytes.Equal(static, want.PublicKey().Bytes()) {
+				return errors.New("unexpected static key")
+			}
+			return nil
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := &tapWriter{chanPipe: newChanPipe()}, &tapWriter{chanPipe: newChanPipe()}
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte(nil), payload...), nil
+	})
+	gadget := New(toGadget.chanPipe, toHost, WithLogger(logger), echo,
+		WithNoise(NoiseConfig{Static: gadgetKey, VerifyPeer: pin(hostKey), Require: true}))
+	defer gadget.Stop()
+	host := New(toHost.chanPipe, toGadget, WithLogger(logger), echo,
+		WithNoise(NoiseConfig{Static: hostKey, Initiator: true, VerifyPeer: pin(gadgetKey), Require: true}))
+	defer host.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	secret := []byte("correct horse battery staple")
+	for range 3 {
+		resp, _, err := host.Request(ctx, secret)
+		if err != nil || !bytes.Equal(resp, secret) {
+			t.Fatalf("Request over noise: %q, %v", resp, err)
+		}
+	}
+
+	if peer, ok := gadget.PeerStatic(); !ok || !bytes.Equal(peer, hostKey.PublicKey().Bytes()) {
+		t.Fatalf("gadget sees wrong host key")
+	}
+	for _, w := range []*tapWriter{toGadget, toHost} {
+		w.mu.Lock()
+		leaked := bytes.Contains(w.wire, secret)
+		w.mu.Unlock()
+		if leaked {
+			t.Fatalf("plaintext payload on the wire")
+		}
+	}
+}
+
+func TestNoiseFailsAgainstUnexpectedPeerKey(t *testing.T) {
+	errPin := errors.New("gadget key changed")
+	host, _ := newBrokerPair(t,
+		[]Option{WithNoise(NoiseConfig{Static: newStaticKey(t), Initiator: true, Require: true,
+			VerifyPeer: func([]byte) error { return errPin }})},
+		[]Option{WithNoise(NoiseConfig{Static: newStaticKey(t)})},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := host.Request(ctx, []byte("ping")); !errors.Is(err, errPin) {
+		t.Fatalf("expected pin failure, got %v", err)
+	}
+}
+
+func TestNoiseRequiredRefusesLegacyPeer(t *testing.T) {
+	host, _ := newBrokerPair(t, []Option{WithNoise(NoiseConfig{Static: newStaticKey(t), Initiator: true, Require: true})}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, _, err := host.Request(ctx, []byte("ping")); !errors.Is(err, ErrInsecurePeer) {
+		t.Fatalf("expected ErrInsecurePeer, got %v", err)
+	}
+}
+
+// TestNoiseSessionDropsPlaintext injects a plaintext request on a secure
+// link: the gadget must drop it and keep its authenticated session.
+func TestNoiseSessionDropsPlaintext(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := newChanPipe(), newChanPipe()
+	var mu sync.Mutex
+	var seen [][]byte
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		mu.Lock()
+		seen = append(seen, append([]byte(nil), payload...))
+		mu.Unlock()
+		return append([]byte(nil), payload...), nil
+	})
+	gadget := New(toGadget, toHost, WithLogger(logger), echo, WithNoise(NoiseConfig{Static: newStaticKey(t)}))
+	defer gadget.Stop()
+	host := New(toHost, toGadget, WithLogger(logger), echo, WithNoise(NoiseConfig{Static: newStaticKey(t), Initiator: true}))
+	defer host.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := host.Request(ctx, []byte("sealed")); err != nil {
+		t.Fatalf("Request over noise: %v", err)
+	}
+	forged, err := newMessage(payloadTypeRequest, NewMessageID(), []byte("plain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toGadget.WriteContext(ctx, forged); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := host.Request(ctx, []byte("sealed again")); err != nil {
+		t.Fatalf("Request after the plaintext frame: %v", err)
+	}
+
+	if _, ok := gadget.PeerStatic(); !ok {
+		t.Fatal("plaintext frame dropped the gadget's session")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range seen {
+		if string(p) == "plain" {
+			t.Fatal("gadget handled a plaintext request on a secure link")
+		}
+	}
+}
+
+// TestNoiseSessionRefusesDowngrade: once a session has been up, a hello
+// without FeatureNoise (which anyone on the link can forge) does not bring
+// plaintext back.
+func TestNoiseSessionRefusesDowngrade(t *testing.T) {
+	host, _ := newBrokerPair(t,
+		[]Option{WithNoise(NoiseConfig{Static: newStaticKey(t), Initiator: true})},
+		[]Option{WithNoise(NoiseConfig{Static: newStaticKey(t)})},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := host.Request(ctx, []byte("ping")); err != nil {
+		t.Fatalf("Request over noise: %v", err)
+	}
+
+	host.noiseMu.Lock()
+	host.session.Store(nil)
+	host.peer.Store(&PeerInfo{Version: ProtocolVersion, MaxPayload: MAX_MESSAGE_PAYLOAD})
+	host.noiseMu.Unlock()
+	if _, _, err := host.Request(ctx, []byte("ping")); !errors.Is(err, ErrInsecurePeer) {
+		t.Fatalf("expected ErrInsecurePeer after a downgrade, got %v", err)
+	}
+}
+
+func TestCompressionShrinksLargePayloads(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := &tapWriter{chanPipe: newChanPipe()}, &tapWriter{chanPipe: newChanPipe()}
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte(nil), payload...), nil
+	})
+	gadget := New(toGadget.chanPipe, toHost, WithLogger(logger), echo, WithCompression())
+	defer gadget.Stop()
+	host := New(toHost.chanPipe, toGadget, WithLogger(logger), echo, WithCompression())
+	defer host.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+
+	logDump := bytes.Repeat([]byte("level=INFO msg=\"signed preattestation\" level=123456\n"), 2000)
+	resp, _, err := host.Request(ctx, logDump)
+	if err != nil || !bytes.Equal(resp, logDump) {
+		t.Fatalf("Request with compression: %d bytes, %v", len(resp), err)
+	}
+	for _, w := range []*tapWriter{toGadget, toHost} {
+		w.mu.Lock()
+		n := len(w.wire)
+		w.mu.Unlock()
+		if n >= len(logDump)/2 {
+			t.Fatalf("expected compressed frames, %d bytes on the wire for a %d byte payload", n, len(logDump))
+		}
+	}
+}
+
+func TestCompressionNeedsBothPeers(t *testing.T) {
+	host, _ := newBrokerPair(t, []Option{WithCompression()}, []Option{WithHandshake()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	peer, err := host.WaitHandshake(ctx)
+	if err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+	if peer.Features&FeatureCompression != 0 {
+		t.Fatalf("compression negotiated with a peer that does not offer it")
+	}
+	payload := bytes.Repeat([]byte{'a'}, 64*KB)
+	if resp, _, err := host.Request(ctx, payload); err != nil || !bytes.Equal(resp, payload) {
+		t.Fatalf("Request: %d bytes, %v", len(resp), err)
+	}
+}
+
+func TestFragmentationCarriesOversizedPayloads(t *testing.T) {
+	opts := []Option{WithFragmentation(), WithNoise(NoiseConfig{Static: newStaticKey(t)})}
+	host, _ := newBrokerPair(t,
+		[]Option{WithFragmentation(), WithNoise(NoiseConfig{Static: newStaticKey(t), Initiator: true, Require: true})},
+		opts,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+
+	payload := make([]byte, MAX_MESSAGE_PAYLOAD+3*FRAGMENT_SIZE/2)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	resp, _, err := host.Request(ctx, payload)
+	if err != nil || !bytes.Equal(resp, payload) {
+		t.Fatalf("fragmented Request: %d bytes, %v", len(resp), err)
+	}
+
+	if _, _, err := host.Request(ctx, make([]byte, MAX_REASSEMBLED_PAYLOAD+1)); err == nil {
+		t.Fatalf("expected the reassembly cap to be enforced")
+	}
+}
+
+func TestReassemblyRejectsInconsistentFragments(t *testing.T) {
+	b := newTestBroker(t, &scriptedWriter{})
+	defer b.Stop()
+
+	frames := func(id [16]byte, n int) []outFrame {
+		b.peer.Store(&PeerInfo{Version: ProtocolVersion, MaxPayload: MAX_MESSAGE_PAYLOAD, Features: FeatureFragmentation})
+		return b.fragmentFrame(outFrame{typ: payloadTypeRequest, id: id, payload: make([]byte, n)})
+	}
+
+	id := NewMessageID()
+	parts := frames(id, 2*FRAGMENT_SIZE+1)
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 fragments, got %d", len(parts))
+	}
+	if _, _, whole, err := b.reassemble(id, parts[0].payload, false); whole || err != nil {
+		t.Fatalf("first fragment: whole=%v err=%v", whole, err)
+	}
+	// the same id arriving sealed is a different message
+	if _, _, _, err := b.reassemble(id, parts[1].payload, true); !errors.Is(err, ErrInvalidFragment) {
+		t.Fatalf("expected mixed sealing to be rejected, got %v", err)
+	}
+
+	id = NewMessageID()
+	parts = frames(id, 2*FRAGMENT_SIZE+1)
+	for i, p := range []outFrame{parts[2], parts[0], parts[0], parts[1]} {
+		pt, whole, done, err := b.reassemble(id, p.payload, false)
+		if err != nil {
+			t.Fatalf("fragment %d: %v", i, err)
+		}
+		if done != (i == 3) {
+			t.Fatalf("fragment %d: done=%v", i, done)
+		}
+		if done && (pt != payloadTypeRequest || len(whole) != 2*FRAGMENT_SIZE+1) {
+			t.Fatalf("reassembled %v with %d bytes", pt, len(whole))
+		}
+	}
+}
+
+// gatedWriter holds the first write until released and records frame ids.
+type gatedWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	ids     [][16]byte
+}
+
+func (w *gatedWriter) WriteContext(ctx context.Context, p []byte) (int, error) {
+	select {
+	case <-w.release:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	h, err := DecodeHeader(p)
+	if err != nil {
+		return 0, err
+	}
+	w.mu.Lock()
+	w.ids = append(w.ids, h.ID)
+	w.mu.Unlock()
+	return len(p), nil
+}
+
+func TestHighPriorityFramesOvertakeQueuedTraffic(t *testing.T) {
+	w := &gatedWriter{release: make(chan struct{})}
+	b := newTestBroker(t, w)
+	defer b.Stop()
+
+	ctx := context.Background()
+	first, bulk, sign := NewMessageID(), NewMessageID(), NewMessageID()
+	if err := b.enqueue(ctx, outFrame{typ: payloadTypeResponse, id: first}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// wait until the writer holds the first frame
+	waitForCondition(t, func() bool { return len(b.writeChan) == 0 })
+	for range 5 {
+		if err := b.enqueue(ctx, outFrame{typ: payloadTypeResponse, id: bulk}); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+	if err := b.enqueue(ctx, outFrame{typ: payloadTypeResponse, id: sign, prio: PriorityHigh}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	close(w.release)
+
+	waitForCondition(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return len(w.ids) == 7
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ids[0] != first || w.ids[1] != sign {
+		t.Fatalf("high priority frame did not jump the queue: got %x then %x", w.ids[0], w.ids[1])
+	}
+}
+
+func TestHeartbeatDeclaresSilentPeerDead(t *testing.T) {
+	host, gadget := newBrokerPair(t, []Option{WithHeartbeat(20*time.Millisecond, 3)}, []Option{WithHandshake()})
+
+	waitForCondition(t, func() bool { return host.RTT() > 0 })
+	select {
+	case <-host.Dead():
+		t.Fatalf("live peer declared dead")
+	default:
+	}
+
+	gadget.Stop()
+	select {
+	case <-host.Dead():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("silent peer not declared dead")
+	}
+	if _, _, err := host.Request(context.Background(), []byte("ping")); !errors.Is(err, ErrPeerDead) {
+		t.Fatalf("expected ErrPeerDead, got %v", err)
+	}
+}
+
+func TestHeartbeatIdleWithLegacyPeer(t *testing.T) {
+	host, _ := newBrokerPair(t, []Option{WithHeartbeat(10*time.Millisecond, 2)}, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-host.Dead():
+		t.Fatalf("peer without heartbeats declared dead")
+	default:
+	}
+}
+
+func TestStashDropsFramesFailingChecksum(t *testing.T) {
+	s := newStash(DEFAULT_BROKER_CAPACITY, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	badID, goodID := NewMessageID(), NewMessageID()
+	bad, _ := newChecksummedMessage(payloadTypeRequest, badID, []byte("sign this block"))
+	bad[HeaderLenChecksum+3] ^= 0x10 // bit error in the payload
+	good, _ := newChecksummedMessage(payloadTypeRequest, goodID, []byte("sign this block"))
+	s.Write(append(bad, good...))
+
+	for {
+		id, _, payload, err := s.ReadPayload()
+		if errors.Is(err, ErrNoPayloadFound) || errors.Is(err, ErrIncompletePayload) {
+			t.Fatalf("good frame lost after the corrupted one")
+		}
+		if err != nil {
+			continue
+		}
+		if id != goodID || string(payload) != "sign this block" {
+			t.Fatalf("unexpected frame %x %q", id, payload)
+		}
+		break
+	}
+	if n := s.corrupt.Load(); n != 1 {
+		t.Fatalf("expected one corrupted frame counted, got %d", n)
+	}
+}
+
+func TestChecksummedFramesRoundTrip(t *testing.T) {
+	host, _ := newBrokerPair(t, []Option{WithChecksums()}, []Option{WithChecksums()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if peer, err := host.WaitHandshake(ctx); err != nil || peer.Features&FeatureChecksum == 0 {
+		t.Fatalf("checksums not negotiated: %+v, %v", peer, err)
+	}
+	if resp, _, err := host.Request(ctx, []byte("ping")); err != nil || string(resp) != "ping" {
+		t.Fatalf("Request: %q, %v", resp, err)
+	}
+	if n := host.CorruptFrames(); n != 0 {
+		t.Fatalf("clean link counted %d corrupted frames", n)
+	}
+}
+
+// gatedReader stops reading while closed, like a peer that fell behind.
+type gatedReader struct {
+	r    ReadContexter
+	open atomic.Bool
+}
+
+func (g *gatedReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	for !g.open.Load() {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	return g.r.ReadContext(ctx, p)
+}
+
+func TestFlowControlHoldsDataWithinPeerWindow(t *testing.T) {
+	const bufSize = 64 * KB // window of 32 KiB
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := &tapWriter{chanPipe: newChanPipe()}, newChanPipe()
+	gadgetIn := &gatedReader{r: toGadget.chanPipe}
+	gadgetIn.open.Store(true)
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte(nil), payload...), nil
+	})
+	gadget := New(gadgetIn, toHost, WithLogger(logger), echo, WithBufferSize(bufSize), WithFlowControl())
+	defer gadget.Stop()
+	host := New(toHost, toGadget, WithLogger(logger), echo, WithFlowControl())
+	defer host.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peer, err := host.WaitHandshake(ctx)
+	if err != nil || peer.Window != bufSize/2 {
+		t.Fatalf("window not negotiated: %+v, %v", peer, err)
+	}
+
+	gadgetIn.open.Store(false)
+	toGadget.mu.Lock()
+	before := len(toGadget.wire)
+	toGadget.mu.Unlock()
+
+	payload := make([]byte, 8*KB)
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := host.Request(ctx, payload); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	toGadget.mu.Lock()
+	sent := len(toGadget.wire) - before
+	toGadget.mu.Unlock()
+	if sent > bufSize/2+len(payload)+HeaderLenChecksum {
+		t.Fatalf("sent %d bytes to a stalled peer with a %d byte window", sent, bufSize/2)
+	}
+
+	gadgetIn.open.Store(true)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Request: %v", err)
+	}
+}
+
+func TestDeadlineReachesGadgetHandler(t *testing.T) {
+	aborted := make(chan time.Duration, 1)
+	slow := WithHandler(func(ctx context.Context, payload []byte) ([]byte, error) {
+		d, ok := ctx.Deadline()
+		if !ok {
+			aborted <- 0
+			return payload, nil
+		}
+		<-ctx.Done()
+		aborted <- time.Until(d)
+		return payload, nil
+	})
+	host, _ := newBrokerPair(t, []Option{WithDeadlines()}, []Option{WithDeadlines(), slow})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+
+	rctx, rcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer rcancel()
+	if _, _, err := host.Request(rctx, []byte("slow")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Request: got %v, want deadline exceeded", err)
+	}
+	select {
+	case left := <-aborted:
+		if left > 0 {
+			t.Fatalf("handler ran without the caller's deadline")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handler kept running after the caller gave up")
+	}
+}
+
+func TestDeadlineNeedsBothPeers(t *testing.T) {
+	seen := make(chan bool, 1)
+	probe := WithHandler(func(ctx context.Context, payload []byte) ([]byte, error) {
+		_, ok := ctx.Deadline()
+		seen <- ok
+		return payload, nil
+	})
+	host, _ := newBrokerPair(t, []Option{WithDeadlines()}, []Option{WithHandshake(), probe})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+	if _, _, err := host.Request(ctx, []byte("ping")); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if <-seen {
+		t.Fatalf("a peer without FeatureDeadline got a deadline frame")
+	}
+}
+
+func TestDuplicateOfFinishedRequestIsNotRerun(t *testing.T) {
+	var calls atomic.Int32
+	counting := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		calls.Add(1)
+		return append([]byte("done:"), payload...), nil
+	})
+	host, _ := newBrokerPair(t, nil, []Option{counting})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, id, err := host.Request(ctx, []byte("new key"))
+	if err != nil || string(resp) != "done:new key" {
+		t.Fatalf("Request: %q, %v", resp, err)
+	}
+
+	// what a retry frame does with a request whose accept went missing
+	ch := make(chan reply, 1)
+	host.waiters.Store(id, &waiter{ch: ch, ctx: ctx})
+	if err := host.enqueue(ctx, outFrame{typ: payloadTypeRequest, id: id, payload: []byte("new key")}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	select {
+	case r := <-ch:
+		if string(r.payload) != "done:new key" {
+			t.Fatalf("resent response: %q", r.payload)
+		}
+	case <-ctx.Done():
+		t.Fatalf("no response to the duplicate")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+}
+
+func TestSeenRequestsEvictFinishedFirst(t *testing.T) {
+	s := newSeenRequests(2)
+	running, finished, next := NewMessageID(), NewMessageID(), NewMessageID()
+	s.begin(running)
+	s.begin(finished)
+	s.finish(finished, []byte("resp"))
+	if first, cached := s.begin(finished); first || string(cached) != "resp" {
+		t.Fatalf("finished request: first=%v cached=%q", first, cached)
+	}
+
+	s.begin(next)
+	if s.len() != 2 {
+		t.Fatalf("len = %d, want 2", s.len())
+	}
+	if first, _ := s.begin(running); first {
+		t.Fatalf("running request was evicted")
+	}
+	if first, _ := s.begin(finished); !first {
+		t.Fatalf("expected the finished request to be evicted")
+	}
+}
+
+func TestOrderedRequestsRunInSendOrder(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		finished []byte
+		running  atomic.Int32
+		overlap  atomic.Bool
+	)
+	slowFirst := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		if running.Add(1) > 1 {
+			overlap.Store(true)
+		}
+		defer running.Add(-1)
+		// earlier requests take longer, so concurrent handling would reverse them
+		time.Sleep(time.Duration(8-payload[0]) * 10 * time.Millisecond)
+		mu.Lock()
+		finished = append(finished, payload[0])
+		mu.Unlock()
+		return payload, nil
+	})
+	opts := []Option{WithSequencing()}
+	host, gadget := newBrokerPair(t, opts, append(opts, slowFirst))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		t.Fatalf("WaitHandshake: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range byte(8) {
+		time.Sleep(5 * time.Millisecond) // fixes the send order
+		wg.Go(func() {
+			if _, _, err := host.Request(WithOrdered(ctx), []byte{i}); err != nil {
+				t.Errorf("Request %d: %v", i, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if overlap.Load() {
+		t.Fatalf("ordered requests were handled concurrently")
+	}
+	if !bytes.Equal(finished, []byte{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Fatalf("handled in order %v", finished)
+	}
+	if gaps := gadget.SequenceGaps() + host.SequenceGaps(); gaps != 0 {
+		t.Fatalf("%d sequence gaps on a lossless link", gaps)
+	}
+}
+
+func TestSequenceGapsCountLostFrames(t *testing.T) {
+	b := newTestBroker(t, &scriptedWriter{})
+	defer b.Stop()
+	b.peer.Store(&PeerInfo{Version: ProtocolVersion, Features: FeatureSequence})
+	b.features |= FeatureSequence
+
+	frames := make([]outFrame, 4)
+	for i := range frames {
+		frames[i] = b.sequenceFrame(outFrame{typ: payloadTypeRequest, id: NewMessageID(), payload: []byte{byte(i)}})
+	}
+	// frame 2 never arrives
+	for _, f := range []outFrame{frames[0], frames[1], frames[3]} {
+		pt, payload, _, err := b.openSequence(f.id, f.payload)
+		if err != nil || pt != payloadTypeRequest || len(payload) != 1 {
+			t.Fatalf("openSequence: %v %v %v", pt, payload, err)
+		}
+	}
+	if gaps := b.SequenceGaps(); gaps != 1 {
+		t.Fatalf("SequenceGaps = %d, want 1", gaps)
+	}
+}
+
+// BenchmarkSignRoundTrip times sign-sized requests over an in-memory link
+// with the options the host and gadget use, reporting the p99 next to the
+// mean.
+func BenchmarkSignRoundTrip(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	toGadget, toHost := newChanPipe(), newChanPipe()
+	opts := func(extra ...Option) []Option {
+		return append([]Option{WithLogger(logger), WithHandshake(), WithCompression(), WithFragmentation(),
+			WithChecksums(), WithFlowControl(), WithDeadlines(), WithSequencing()}, extra...)
+	}
+	sig := make([]byte, 96)
+	gadget := New(toGadget, toHost, opts(WithHandler(func(context.Context, []byte) ([]byte, error) {
+		return sig, nil
+	}))...)
+	host := New(toHost, toGadget, opts(WithHandler(func(context.Context, []byte) ([]byte, error) {
+		return nil, nil
+	}))...)
+	defer gadget.Stop()
+	defer host.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := host.WaitHandshake(ctx); err != nil {
+		b.Fatalf("WaitHandshake: %v", err)
+	}
+
+	req := make([]byte, 160) // a tz4 and a block/attestation preimage
+	lat := make([]time.Duration, 0, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		start := time.Now()
+		if _, _, err := host.Request(WithPriority(ctx, PriorityHigh), req); err != nil {
+			b.Fatalf("Request: %v", err)
+		}
+		lat = append(lat, time.Since(start))
+	}
+	b.StopTimer()
+	slices.Sort(lat)
+	b.ReportMetric(float64(lat[len(lat)*99/100].Nanoseconds()), "p99-ns")
+}
+
+func BenchmarkEncodeFrame(b *testing.B) {
+	br := newTestBroker(b, &scriptedWriter{})
+	defer br.Stop()
+	br.peer.Store(&PeerInfo{Version: ProtocolVersion, Features: FeatureChecksum})
+	br.features |= FeatureChecksum
+	f := outFrame{typ: payloadTypeResponse, id: NewMessageID(), payload: make([]byte, 96)}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		data, err := br.encodeFrame(f)
+		if err != nil {
+			b.Fatalf("encodeFrame: %v", err)
+		}
+		data.release()
+	}
+}
+
+func BenchmarkStashReadFrame(b *testing.B) {
+	s := newStash(DEFAULT_BROKER_CAPACITY, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	frame, _ := newChecksummedMessage(payloadTypeRequest, NewMessageID(), make([]byte, 160))
+
+	b.ReportAllocs()
+	for b.Loop() {
+		s.Write(frame)
+		_, payload, err := s.ReadFrame()
+		if err != nil {
+			b.Fatalf("ReadFrame: %v", err)
+		}
+		payload.release()
+	}
+}
+
+func TestFrameBufsAreWipedAndRecycled(t *testing.T) {
+	fb := getFrameBuf(100)
+	copy(fb.B, "passphrase")
+	raw := fb.B[:cap(fb.B)]
+	fb.release()
+	if !bytes.Equal(raw, make([]byte, len(raw))) {
+		t.Fatalf("released buffer still holds its payload")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected a double release to panic")
+			}
+		}()
+		fb.release()
+	}()
+
+	kept := getFrameBuf(100)
+	copy(kept.B, "response")
+	resp := kept.B[:8]
+	kept.releaseUnless(resp)
+	if string(resp) != "response" {
+		t.Fatalf("buffer aliased by the response was recycled: %q", resp)
+	}
+
+	if big := getFrameBuf(MAX_POOLED_PAYLOAD + HeaderLenChecksum + 1); big.class != -1 {
+		t.Fatalf("oversized buffer got pool class %d", big.class)
+	}
+}
+
+func TestOverloadedPeerAnswersWithErrorFrame(t *testing.T) {
+	release := make(chan struct{})
+	blocking := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		<-release
+		return payload, nil
+	})
+	host, gadget := newBrokerPair(t, []Option{WithErrorFrames()}, []Option{WithErrorFrames(), blocking})
+	waitForCondition(t, func() bool { return host.negotiated(FeatureErrors) && gadget.negotiated(FeatureErrors) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, maxActiveRequests)
+	for range maxActiveRequests {
+		wg.Go(func() {
+			_, _, err := host.Request(ctx, []byte("sign"))
+			errs <- err
+		})
+	}
+	waitForCondition(t, func() bool { return gadget.activeRequests.Load() == maxActiveRequests })
+
+	_, _, err := host.Request(ctx, []byte("one too many"))
+	if !errors.Is(err, ErrPeerOverloaded) {
+		t.Fatalf("Request over the limit: got %v, want ErrPeerOverloaded", err)
+	}
+	var te *TransportError
+	if !errors.As(err, &te) || te.Message == "" {
+		t.Fatalf("expected a *TransportError with a message, got %#v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("request under the limit: %v", err)
+		}
+	}
+}
+
+func TestHandlerTransportErrorReachesRequest(t *testing.T) {
+	refusing := WithHandler(func(_ context.Context, _ []byte) ([]byte, error) {
+		return []byte("fallback"), &TransportError{Code: ErrorCodeTooLarge, Message: "key file over quota"}
+	})
+
+	t.Run("negotiated", func(t *testing.T) {
+		host, gadget := newBrokerPair(t, []Option{WithErrorFrames()}, []Option{WithErrorFrames(), refusing})
+		waitForCondition(t, func() bool { return host.negotiated(FeatureErrors) && gadget.negotiated(FeatureErrors) })
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, _, err := host.Request(ctx, []byte("import"))
+		if !errors.Is(err, ErrPeerTooLarge) || errors.Is(err, ErrPeerOverloaded) {
+			t.Fatalf("Request: got %v, want ErrPeerTooLarge", err)
+		}
+		if n := len(host.unconfirmedRequests.All()); n != 0 {
+			t.Fatalf("the error frame left the request unconfirmed")
+		}
+	})
+
+	t.Run("old peer", func(t *testing.T) {
+		host, gadget := newBrokerPair(t, []Option{WithHandshake()}, []Option{WithErrorFrames(), refusing})
+		waitForCondition(t, func() bool { _, ok := gadget.Peer(); return ok })
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		resp, _, err := host.Request(ctx, []byte("import"))
+		if err != nil || string(resp) != "fallback" {
+			t.Fatalf("Request: %q, %v", resp, err)
+		}
+	})
+}
+
+func TestStreamedResponseArrivesInOrder(t *testing.T) {
+	big := bytes.Repeat([]byte{0xab}, FRAGMENT_SIZE+100)
+	streaming := WithHandler(func(ctx context.Context, payload []byte) ([]byte, error) {
+		var whole []byte
+		for i := range 50 {
+			part := fmt.Appendf(nil, "%s-%02d;", payload, i)
+			if i == 25 {
+				part = big
+			}
+			if err := SendPart(ctx, part); errors.Is(err, ErrStreamingUnsupported) {
+				whole = append(whole, part...)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return append(whole, "end"...), nil
+	})
+	var want []byte
+	for i := range 50 {
+		if i == 25 {
+			want = append(want, big...)
+			continue
+		}
+		want = fmt.Appendf(want, "log-%02d;", i)
+	}
+	want = append(want, "end"...)
+
+	opts := []Option{WithStreaming(), WithFragmentation()}
+	host, gadget := newBrokerPair(t, opts, append([]Option{streaming}, opts...))
+	waitForCondition(t, func() bool { return host.negotiated(FeatureStreaming) && gadget.negotiated(FeatureStreaming) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("stream", func(t *testing.T) {
+		s, err := host.RequestStream(ctx, []byte("log"))
+		if err != nil {
+			t.Fatalf("RequestStream: %v", err)
+		}
+		var got []byte
+		chunks := 0
+		for {
+			part, err := s.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			got = append(got, part...)
+			chunks++
+		}
+		if chunks != 51 || !bytes.Equal(got, want) {
+			t.Fatalf("got %d chunks, in order: %v", chunks, bytes.Equal(got, want))
+		}
+	})
+
+	t.Run("plain request", func(t *testing.T) {
+		resp, _, err := host.Request(ctx, []byte("log"))
+		if err != nil || !bytes.Equal(resp, want) {
+			t.Fatalf("Request: %d bytes, joined in order: %v, %v", len(resp), bytes.Equal(resp, want), err)
+		}
+	})
+
+	t.Run("old peer", func(t *testing.T) {
+		old, _ := newBrokerPair(t, []Option{WithFragmentation()}, append([]Option{streaming}, opts...))
+		waitForCondition(t, func() bool { return old.negotiated(FeatureFragmentation) })
+		s, err := old.RequestStream(ctx, []byte("log"))
+		if err != nil {
+			t.Fatalf("RequestStream: %v", err)
+		}
+		part, err := s.Next()
+		if err != nil || !bytes.Equal(part, want) {
+			t.Fatalf("Next: %d bytes, %v", len(part), err)
+		}
+		if _, err := s.Next(); !errors.Is(err, io.EOF) {
+			t.Fatalf("Next after the only chunk: %v", err)
+		}
+	})
+}
+
+func TestCloseDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{}, 1)
+	slow := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		started <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		return append([]byte("signed:"), payload...), nil
+	})
+	host, gadget := newBrokerPair(t, []Option{WithErrorFrames()}, []Option{WithErrorFrames(), slow})
+	waitForCondition(t, func() bool { return host.negotiated(FeatureErrors) && gadget.negotiated(FeatureErrors) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type result struct {
+		resp []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, _, err := host.Request(ctx, []byte("block"))
+		done <- result{resp, err}
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- gadget.Close(ctx) }()
+	waitForCondition(t, func() bool { return gadget.closing.Load() })
+
+	if _, _, err := host.Request(ctx, []byte("late")); !errors.Is(err, ErrPeerShuttingDown) {
+		t.Fatalf("request to a draining peer: got %v, want ErrPeerShuttingDown", err)
+	}
+	if _, _, err := gadget.Request(ctx, []byte("from the gadget")); !errors.Is(err, ErrClosing) {
+		t.Fatalf("request from a draining broker: got %v, want ErrClosing", err)
+	}
+
+	r := <-done
+	if r.err != nil || string(r.resp) != "signed:block" {
+		t.Fatalf("in-flight request: %q, %v", r.resp, r.err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-gadget.Done():
+	default:
+		t.Fatalf("broker still running after Close")
+	}
+}
+
+func TestCloseGivesUpAtItsDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := WithHandler(func(_ context.Context, _ []byte) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+	host, gadget := newBrokerPair(t, nil, []Option{stuck, WithDrainTimeout(50 * time.Millisecond)})
+
+	go host.Request(context.Background(), []byte("never answered"))
+	waitForCondition(t, func() bool { return gadget.inflight.Load() == 1 })
+
+	start := time.Now()
+	gadget.Stop()
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("Stop waited %v past its drain timeout", took)
+	}
+	select {
+	case <-gadget.Done():
+	default:
+		t.Fatalf("broker still running after Stop")
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	other, _ := newBrokerPair(t, nil, []Option{stuck})
+	go other.Request(context.Background(), []byte("never answered"))
+	waitForCondition(t, func() bool { return other.calls.Load() == 1 })
+	if err := other.Close(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close: got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestReaperDropsStaleState(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stuck := WithHandler(func(_ context.Context, _ []byte) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+	host, _ := newBrokerPair(t, nil, []Option{stuck})
+
+	// a stream its reader walked away from
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := host.RequestStream(ctx, []byte("follow")); err != nil {
+		t.Fatalf("RequestStream: %v", err)
+	}
+	cancel()
+
+	// half a fragmented message, and half a frame
+	frag := make([]byte, fragmentHeaderLen+3)
+	frag[0] = byte(payloadTypeRequest)
+	frag[5] = 2 // count
+	if _, _, _, err := host.reassemble(NewMessageID(), frag, false); err != nil {
+		t.Fatalf("reassemble: %v", err)
+	}
+	frame, err := newMessage(payloadTypeRequest, NewMessageID(), []byte("cut short"))
+	if err != nil {
+		t.Fatalf("newMessage: %v", err)
+	}
+	partial := frame[:len(frame)-4]
+	host.stash.Write(partial)
+
+	host.reap(time.Now())
+	if got := host.Reaped(); got.Waiters != 1 || got.Assemblies != 0 || got.StashBytes != 0 {
+		t.Fatalf("first pass: %+v", got)
+	}
+	if n := host.calls.Load(); n != 0 {
+		t.Fatalf("reaped stream still counts as %d calls", n)
+	}
+	if n := len(host.unconfirmedRequests.All()); n != 0 {
+		t.Fatalf("%d unconfirmed requests left", n)
+	}
+
+	host.reap(time.Now().Add(assemblyTTL + time.Second))
+	want := ReapStats{Waiters: 1, Assemblies: 1, StashBytes: uint64(len(partial))}
+	if got := host.Reaped(); got != want {
+		t.Fatalf("after the TTL: got %+v, want %+v", got, want)
+	}
+	if n := host.stash.Len(); n != 0 {
+		t.Fatalf("stash still holds %d bytes", n)
+	}
+}
+
+// lossyPipe drops the next drop request frames written to it.
+type lossyPipe struct {
+	*chanPipe
+	drop atomic.Int32
+}
+
+func (p *lossyPipe) WriteContext(ctx context.Context, data []byte) (int, error) {
+	if len(data) > 1 && payloadType(data[1]) == payloadTypeRequest && p.drop.Add(-1) >= 0 {
+		return len(data), nil
+	}
+	return p.chanPipe.WriteContext(ctx, data)
+}
+
+func TestUnacceptedRequestIsRetransmitted(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newPair := func(t *testing.T, drop int32, handler Handler) (*Broker, *lossyPipe) {
+		toGadget, toHost := &lossyPipe{chanPipe: newChanPipe()}, newChanPipe()
+		toGadget.drop.Store(drop)
+		gadget := New(toGadget, toHost, WithLogger(logger), WithHandler(handler))
+		host := New(toHost, toGadget, WithLogger(logger), WithHandler(handler), WithRetransmit(50*time.Millisecond, 3))
+		t.Cleanup(func() {
+			host.Stop()
+			gadget.Stop()
+		})
+		return host, toGadget
+	}
+	echo := func(_ context.Context, payload []byte) ([]byte, error) {
+		return append([]byte(nil), payload...), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("lost once", func(t *testing.T) {
+		host, _ := newPair(t, 1, echo)
+		resp, _, err := host.Request(ctx, []byte("sign"))
+		if err != nil || string(resp) != "sign" {
+			t.Fatalf("Request: %q, %v", resp, err)
+		}
+		if n := host.Retransmits(); n != 1 {
+			t.Fatalf("retransmits: got %d, want 1", n)
+		}
+	})
+
+	t.Run("never arrives", func(t *testing.T) {
+		host, _ := newPair(t, 100, echo)
+		if _, _, err := host.Request(ctx, []byte("sign")); !errors.Is(err, ErrNoAck) {
+			t.Fatalf("Request: got %v, want ErrNoAck", err)
+		}
+		if n := host.Retransmits(); n != 3 {
+			t.Fatalf("retransmits: got %d, want 3", n)
+		}
+	})
+
+	t.Run("accepted but slow", func(t *testing.T) {
+		host, _ := newPair(t, 0, func(ctx context.Context, payload []byte) ([]byte, error) {
+			time.Sleep(300 * time.Millisecond)
+			return echo(ctx, payload)
+		})
+		if _, _, err := host.Request(ctx, []byte("sign")); err != nil {
+			t.Fatalf("Request: %v", err)
+		}
+		if n := host.Retransmits(); n != 0 {
+			t.Fatalf("an accepted request was resent %d times", n)
+		}
+	})
+}
+
+// newFlakyPair links a host and a gadget that count the requests they run
+// through link, which starts clean so the handshake completes; tests inject
+// faults afterwards.
+func newFlakyPair(t *testing.T, link *brokertest.Link, opts ...Option) (host *Broker, runs *atomic.Int32) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	runs = new(atomic.Int32)
+	echo := WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+		runs.Add(1)
+		return append([]byte(nil), payload...), nil
+	})
+	gadget := New(link.ToGadget, link.ToHost, append([]Option{WithLogger(logger), echo}, opts...)...)
+	host = New(link.ToHost, link.ToGadget, append([]Option{WithLogger(logger), echo, WithRetransmit(50*time.Millisecond, 5)}, opts...)...)
+	t.Cleanup(func() {
+		link.Close()
+		host.Stop()
+		gadget.Stop()
+	})
+	return host, runs
+}
+
+func requestEchoes(t *testing.T, host *Broker, n int) {
+	t.Helper()
+	for i := range n {
+		want := fmt.Sprintf("request %d", i)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		resp, _, err := host.Request(ctx, []byte(want))
+		cancel()
+		if err != nil || string(resp) != want {
+			t.Fatalf("request %d: %q, %v", i, resp, err)
+		}
+	}
+}
+
+func TestReadEndingInsideHeaderWaitsForTheRest(t *testing.T) {
+	// every read stops short, so reads end inside headers as well as payloads
+	link := brokertest.NewLink(brokertest.Faults{Seed: 1, ShortRead: 1})
+	host, runs := newFlakyPair(t, link, WithChecksums())
+	requestEchoes(t, host, 20)
+	if n := runs.Load(); n != 20 {
+		t.Fatalf("gadget ran %d requests, want 20", n)
+	}
+	if link.Stats().ShortReads == 0 {
+		t.Fatal("no short reads injected")
+	}
+}
+
+func TestRequestsSurviveFlakyLink(t *testing.T) {
+	link := brokertest.NewLink(brokertest.Faults{})
+	host, runs := newFlakyPair(t, link, WithHandshake(), WithCompression(), WithFragmentation(), WithChecksums(), WithFlowControl(), WithDeadlines(), WithSequencing(), WithErrorFrames(), WithStreaming())
+	requestEchoes(t, host, 1)
+
+	link.Inject(brokertest.Faults{Seed: 2, ShortRead: 0.3, ReadEAGAIN: 0.1, Duplicate: 0.2})
+	requestEchoes(t, host, 50)
+	// duplicated requests are answered from the dedup cache, not rerun
+	if n := runs.Load(); n != 51 {
+		t.Fatalf("gadget ran %d requests, want 51", n)
+	}
+	if s := link.Stats(); s.ShortReads == 0 || s.ReadEAGAINs == 0 || s.Duplicated == 0 {
+		t.Fatalf("faults not injected: %+v", s)
+	}
+}
+
+func TestRetransmitCoversWritesLostToEAGAIN(t *testing.T) {
+	link := brokertest.NewLink(brokertest.Faults{})
+	host, runs := newFlakyPair(t, link, WithHandshake(), WithSequencing())
+	requestEchoes(t, host, 1)
+
+	// a frame whose write fails twice is dropped; only the host side
+	// flakes, as requests are what retransmission covers
+	link.ToGadget.Inject(brokertest.Faults{Seed: 3, WriteEAGAIN: 0.5})
+	requestEchoes(t, host, 20)
+	if n := runs.Load(); n != 21 {
+		t.Fatalf("gadget ran %d requests, want 21", n)
+	}
+	if host.Retransmits() == 0 {
+		t.Fatalf("no request needed a retransmit (%+v)", link.Stats())
+	}
+}
@@ -0,0 +1,243 @@
+// Package brokertest stands in for the FunctionFS endpoints a broker reads
+// and writes, in process, with faults injected the way flaky USB produces
+// them: short reads, EAGAIN, reordered and duplicated transfers. Faults are
+// drawn from a seeded generator, so a failing run replays with its seed.
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrClosed is returned by a closed pipe. Unlike the EOF of an endpoint that
+// is rebinding, a broker does not retry it.
+var ErrClosed = errors.New("brokertest: pipe closed")
+
+// pipeDepth is how many writes a pipe holds before writers block, as a
+// queue of USB transfers would.
+const pipeDepth = 64
+
+// Faults says how often a pipe misbehaves: each rate is the chance, from 0
+// to 1, that a read or write suffers the fault. The same seed and the same
+// traffic give the same faults.
+type Faults struct {
+	Seed uint64
+	// ShortRead is the chance a read returns only part of the transfer
+	// it is reading; the rest comes with the next reads.
+	ShortRead float64
+	// ReadEAGAIN and WriteEAGAIN are the chances a read or write fails with
+	// syscall.EAGAIN before moving any data, as an endpoint does while the
+	// host reopens it.
+	ReadEAGAIN  float64
+	WriteEAGAIN float64
+	// Reorder is the chance a write is held back and delivered after the
+	// next one. A held write waits for that next write.
+	Reorder float64
+	// Duplicate is the chance a write is delivered twice.
+	Duplicate float64
+}
+
+// Stats counts the faults a pipe has injected.
+type Stats struct {
+	ShortReads   int
+	ReadEAGAINs  int
+	WriteEAGAINs int
+	Reordered    int
+	Duplicated   int
+}
+
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		ShortReads:   s.ShortReads + o.ShortReads,
+		ReadEAGAINs:  s.ReadEAGAINs + o.ReadEAGAINs,
+		WriteEAGAINs: s.WriteEAGAINs + o.WriteEAGAINs,
+		Reordered:    s.Reordered + o.Reordered,
+		Duplicated:   s.Duplicated + o.Duplicated,
+	}
+}
+
+// Pipe is one direction of an endpoint pair: every write is a transfer that
+// reads return in order, unless a fault says otherwise. It has the
+// ReadContext and WriteContext methods of broker.ReadContexter and
+// broker.WriteContexter, for one reader and one writer.
+type Pipe struct {
+	ch        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readMu sync.Mutex
+	rest   []byte // unread tail of the transfer being read
+	reads  faultSource
+
+	writeMu sync.Mutex
+	held    []byte // a reordered write, delivered after the next
+	writes  faultSource
+
+	shortReads, readEAGAINs, writeEAGAINs, reordered, duplicated atomic.Int64
+}
+
+// NewPipe returns a pipe that injects f.
+func NewPipe(f Faults) *Pipe {
+	p := &Pipe{ch: make(chan []byte, pipeDepth), closed: make(chan struct{})}
+	p.Inject(f)
+	return p
+}
+
+// Inject replaces the pipe's faults, e.g. to let a handshake through before
+// the link turns flaky. The generators restart from f.Seed.
+func (p *Pipe) Inject(f Faults) {
+	p.reads.reset(f, 0)
+	p.writes.reset(f, 1)
+}
+
+func (p *Pipe) ReadContext(ctx context.Context, buf []byte) (int, error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+	if p.reads.hit(func(f Faults) float64 { return f.ReadEAGAIN }) {
+		p.readEAGAINs.Add(1)
+		return 0, syscall.EAGAIN
+	}
+	if len(p.rest) == 0 {
+		select {
+		case p.rest = <-p.ch:
+		case <-p.closed:
+			return 0, ErrClosed
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	n := min(len(buf), len(p.rest))
+	if n > 1 && p.reads.hit(func(f Faults) float64 { return f.ShortRead }) {
+		n = 1 + p.reads.intN(n-1)
+		p.shortReads.Add(1)
+	}
+	copy(buf, p.rest[:n])
+	p.rest = p.rest[n:]
+	return n, nil
+}
+
+func (p *Pipe) WriteContext(ctx context.Context, data []byte) (int, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	select {
+	case <-p.closed:
+		return 0, ErrClosed
+	default:
+	}
+	if p.writes.hit(func(f Faults) float64 { return f.WriteEAGAIN }) {
+		p.writeEAGAINs.Add(1)
+		return 0, syscall.EAGAIN
+	}
+	// the broker reuses data once the write returns
+	transfer := append([]byte(nil), data...)
+	if p.held == nil && p.writes.hit(func(f Faults) float64 { return f.Reorder }) {
+		p.held = transfer
+		p.reordered.Add(1)
+		return len(data), nil
+	}
+	out := [][]byte{transfer}
+	if p.writes.hit(func(f Faults) float64 { return f.Duplicate }) {
+		out = append(out, transfer)
+		p.duplicated.Add(1)
+	}
+	if p.held != nil {
+		out = append(out, p.held)
+		p.held = nil
+	}
+	for _, t := range out {
+		select {
+		case p.ch <- t:
+		case <-p.closed:
+			return 0, ErrClosed
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return len(data), nil
+}
+
+// Close ends the pipe: reads and writes return ErrClosed, and so does a
+// read waiting for data.
+func (p *Pipe) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+// Stats returns the faults injected so far.
+func (p *Pipe) Stats() Stats {
+	return Stats{
+		ShortReads:   int(p.shortReads.Load()),
+		ReadEAGAINs:  int(p.readEAGAINs.Load()),
+		WriteEAGAINs: int(p.writeEAGAINs.Load()),
+		Reordered:    int(p.reordered.Load()),
+		Duplicated:   int(p.duplicated.Load()),
+	}
+}
+
+// faultSource draws the faults of one side of a pipe. Reads and writes run
+// on different goroutines, so each side has its own generator and the draws
+// of one do not depend on how the two interleave.
+type faultSource struct {
+	mu sync.Mutex // not held while a read or write blocks, so Inject can run
+	f  Faults
+	r  *rand.Rand
+}
+
+func (s *faultSource) reset(f Faults, stream uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f, s.r = f, rand.New(rand.NewPCG(f.Seed, stream))
+}
+
+func (s *faultSource) hit(rate func(Faults) float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := rate(s.f)
+	return r > 0 && s.r.Float64() < r
+}
+
+func (s *faultSource) intN(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.IntN(n)
+}
+
+// Link connects a host and a gadget broker through two pipes:
+//
+//	host := broker.New(link.ToHost, link.ToGadget, ...)
+//	gadget := broker.New(link.ToGadget, link.ToHost, ...)
+type Link struct {
+	ToGadget *Pipe
+	ToHost   *Pipe
+}
+
+// NewLink returns a link that injects f in both directions, each drawing
+// its own faults from the seed.
+func NewLink(f Faults) *Link {
+	back := f
+	back.Seed = f.Seed + 1
+	return &Link{ToGadget: NewPipe(f), ToHost: NewPipe(back)}
+}
+
+// Inject replaces the faults of both directions, as NewLink sets them.
+func (l *Link) Inject(f Faults) {
+	back := f
+	back.Seed = f.Seed + 1
+	l.ToGadget.Inject(f)
+	l.ToHost.Inject(back)
+}
+
+// Close unplugs the link: both brokers see their reads fail and stop.
+func (l *Link) Close() error {
+	l.ToGadget.Close()
+	return l.ToHost.Close()
+}
+
+// Stats adds up the faults of both directions.
+func (l *Link) Stats() Stats {
+	return l.ToGadget.Stats().add(l.ToHost.Stats())
+}
@@ -0,0 +1,141 @@
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// drain reads everything p holds, one transfer piece per read, and the
+// EAGAINs in between.
+func drain(t *testing.T, p *Pipe) (reads []string, eagains int) {
+	t.Helper()
+	buf := make([]byte, 64)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		n, err := p.ReadContext(ctx, buf)
+		cancel()
+		switch {
+		case errors.Is(err, syscall.EAGAIN):
+			eagains++
+		case errors.Is(err, context.DeadlineExceeded):
+			return reads, eagains
+		case err != nil:
+			t.Fatal(err)
+		default:
+			reads = append(reads, string(buf[:n]))
+		}
+	}
+}
+
+func write(t *testing.T, p *Pipe, transfers ...string) (eagains int) {
+	t.Helper()
+	for _, s := range transfers {
+		for {
+			_, err := p.WriteContext(context.Background(), []byte(s))
+			if errors.Is(err, syscall.EAGAIN) {
+				eagains++
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	return eagains
+}
+
+func TestPipeWithoutFaultsKeepsTransfers(t *testing.T) {
+	p := NewPipe(Faults{})
+	write(t, p, "one", "two", "three")
+	if reads, _ := drain(t, p); !slices.Equal(reads, []string{"one", "two", "three"}) {
+		t.Fatalf("reads = %q", reads)
+	}
+	if s := p.Stats(); s != (Stats{}) {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestPipeInjectsEachFault(t *testing.T) {
+	transfers := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"}
+
+	t.Run("short reads", func(t *testing.T) {
+		p := NewPipe(Faults{Seed: 7, ShortRead: 1})
+		write(t, p, transfers...)
+		reads, _ := drain(t, p)
+		if len(reads) <= len(transfers) || strings.Join(reads, "") != strings.Join(transfers, "") {
+			t.Fatalf("reads = %q", reads)
+		}
+		if p.Stats().ShortReads == 0 {
+			t.Fatal("no short reads counted")
+		}
+	})
+
+	t.Run("eagain", func(t *testing.T) {
+		p := NewPipe(Faults{Seed: 7, ReadEAGAIN: 0.5, WriteEAGAIN: 0.5})
+		writeEAGAINs := write(t, p, transfers...)
+		reads, readEAGAINs := drain(t, p)
+		if !slices.Equal(reads, transfers) {
+			t.Fatalf("reads = %q", reads)
+		}
+		if s := p.Stats(); writeEAGAINs == 0 || readEAGAINs == 0 || s.WriteEAGAINs != writeEAGAINs || s.ReadEAGAINs != readEAGAINs {
+			t.Fatalf("stats = %+v, saw %d write and %d read EAGAINs", s, writeEAGAINs, readEAGAINs)
+		}
+	})
+
+	t.Run("reorder", func(t *testing.T) {
+		p := NewPipe(Faults{Seed: 7, Reorder: 1})
+		write(t, p, "first", "second", "third")
+		// the held third write waits for a fourth
+		if reads, _ := drain(t, p); !slices.Equal(reads, []string{"second", "first"}) {
+			t.Fatalf("reads = %q", reads)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		p := NewPipe(Faults{Seed: 7, Duplicate: 1})
+		write(t, p, "once", "twice")
+		if reads, _ := drain(t, p); !slices.Equal(reads, []string{"once", "once", "twice", "twice"}) {
+			t.Fatalf("reads = %q", reads)
+		}
+	})
+}
+
+func TestPipeFaultsReplayWithTheirSeed(t *testing.T) {
+	run := func(seed uint64) string {
+		p := NewPipe(Faults{Seed: seed, ShortRead: 0.5, ReadEAGAIN: 0.3, WriteEAGAIN: 0.3, Reorder: 0.3, Duplicate: 0.3})
+		for i := range 20 {
+			write(t, p, fmt.Sprintf("transfer-%02d", i))
+		}
+		reads, eagains := drain(t, p)
+		return fmt.Sprintf("%q %d %+v", reads, eagains, p.Stats())
+	}
+	if a, b := run(42), run(42); a != b {
+		t.Fatalf("same seed, different faults:\n%s\n%s", a, b)
+	}
+	if a, b := run(42), run(43); a == b {
+		t.Fatalf("different seeds, same faults:\n%s", a)
+	}
+}
+
+func TestClosedLinkFailsBothEnds(t *testing.T) {
+	l := NewLink(Faults{})
+	read := make(chan error, 1)
+	go func() {
+		_, err := l.ToHost.ReadContext(context.Background(), make([]byte, 8))
+		read <- err
+	}()
+	l.Close()
+	if err := <-read; !errors.Is(err, ErrClosed) {
+		t.Fatalf("waiting read: %v", err)
+	}
+	if _, err := l.ToGadget.WriteContext(context.Background(), []byte("late")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("write after close: %v", err)
+	}
+}
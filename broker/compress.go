@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// FeatureCompression: large request and response payloads may be sent
+// deflated. Callers of Request see the original bytes either way.
+const FeatureCompression Feature = 1 << 1
+
+// Payloads below this go out as they are. Besides being pointless for small
+// frames, keeping passphrase-sized requests uncompressed means their length on
+// an encrypted link says nothing about their content.
+const compressThreshold = 4 * KB
+
+// WithCompression turns on the handshake and advertises FeatureCompression.
+func WithCompression() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureCompression
+	}
+}
+
+func compressedType(pt payloadType) (payloadType, bool) {
+	switch pt {
+	case payloadTypeRequest:
+		return payloadTypeCompressedRequest, true
+	case payloadTypeResponse:
+		return payloadTypeCompressedResponse, true
+	}
+	return pt, false
+}
+
+// compressFrame deflates f when both ends support it and it pays off.
+func (b *Broker) compressFrame(f outFrame) outFrame {
+	if len(f.payload) < compressThreshold || !b.negotiated(FeatureCompression) {
+		return f
+	}
+	ct, ok := compressedType(f.typ)
+	if !ok {
+		return f
+	}
+
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestSpeed) // level is valid
+	if _, err := zw.Write(f.payload); err != nil || zw.Close() != nil {
+		return f
+	}
+	if buf.Len() >= len(f.payload) {
+		return f
+	}
+	f.typ, f.payload = ct, buf.Bytes()
+	return f
+}
+
+// inflateFrame undoes compressFrame. Output is capped at
+// MAX_REASSEMBLED_PAYLOAD so a small frame cannot expand into an unbounded
+// allocation.
+func inflateFrame(pt payloadType, payload []byte) (payloadType, []byte, error) {
+	base := payloadTypeRequest
+	if pt == payloadTypeCompressedResponse {
+		base = payloadTypeResponse
+	}
+
+	zr := flate.NewReader(bytes.NewReader(payload))
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, MAX_REASSEMBLED_PAYLOAD+1))
+	if err != nil {
+		return payloadTypeUnknown, nil, ErrInvalidCompressedPayload
+	}
+	if len(out) > MAX_REASSEMBLED_PAYLOAD {
+		return payloadTypeUnknown, nil, ErrInvalidPayloadSize
+	}
+	return base, out, nil
+}
@@ -17,6 +17,13 @@ const (
 	// DEFAULT_READ_BUFFER is the size of the temporary read buffer per syscall.
 	DEFAULT_READ_BUFFER = 256 * KB
 
+	// FRAGMENT_SIZE is the largest chunk of a fragmented payload; with
+	// fragmentation negotiated anything bigger is split.
+	FRAGMENT_SIZE = 1 * MB
+
+	// MAX_REASSEMBLED_PAYLOAD caps a fragmented payload after reassembly.
+	MAX_REASSEMBLED_PAYLOAD = 64 * MB
+
 	// MAX_POOLED_PAYLOAD is the largest payload (excluding header) that uses the pool.
 	MAX_POOLED_PAYLOAD = 512 * KB
 )
@@ -27,6 +34,13 @@ const (
 
 	// Header fields: magic(1) + type(1) + id(16) + size(4) + parity(1)
 	HeaderLen = 1 + 1 + 16 + 4 + 1
+
+	// MagicByteChecksum starts a frame whose header carries a CRC-32C of the
+	// header fields and payload, see checksum.go.
+	MagicByteChecksum = 0x57
+
+	// Checksummed header: magic(1) + type(1) + id(16) + size(4) + crc(4) + parity(1)
+	HeaderLenChecksum = HeaderLen + 4
 )
 
 type payloadType byte
@@ -38,4 +52,18 @@ const (
 	payloadTypeAcceptRequest payloadType = 0x03
 	payloadTypeRetry         payloadType = 0x04
 	payloadTypeKeepAlive     payloadType = 0x05
+	payloadTypeHello         payloadType = 0x06 // protocol version and features, see handshake.go
+	payloadTypeNoise         payloadType = 0x07 // Noise XX handshake message, see secure.go
+	payloadTypeSealed        payloadType = 0x08 // encrypted request or response
+
+	payloadTypeCompressedRequest  payloadType = 0x09 // deflated, see compress.go
+	payloadTypeCompressedResponse payloadType = 0x0a
+	payloadTypeFragment           payloadType = 0x0b // one piece of a large payload, see fragment.go
+	payloadTypePing               payloadType = 0x0c // heartbeat, see heartbeat.go
+	payloadTypePong               payloadType = 0x0d
+	payloadTypeCredit             payloadType = 0x0e // flow control window update, see flow.go
+	payloadTypeDeadline           payloadType = 0x0f // request with the caller's remaining time, see deadline.go
+	payloadTypeSequenced          payloadType = 0x10 // numbered data frame, see sequence.go
+	payloadTypeError              payloadType = 0x11 // transport error answering a request, see errframe.go
+	payloadTypeResponsePart       payloadType = 0x12 // one chunk of a streamed response, see stream.go
 )
@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// FeatureDeadline: requests carry the time the caller is still willing to
+// wait, and the handler's context expires with it.
+const FeatureDeadline Feature = 1 << 6
+
+const (
+	// deadline payload: budget ms(4) inner type(1) inner payload
+	deadlineHeaderLen = 4 + 1
+
+	// budgets are capped so the uint32 cannot wrap
+	maxDeadlineBudget = time.Duration(^uint32(0)) * time.Millisecond
+)
+
+// WithDeadlines turns on the handshake and advertises FeatureDeadline.
+func WithDeadlines() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureDeadline
+	}
+}
+
+// requestDeadline is the deadline Request puts on the frame: the ctx's, when
+// the peer can use it.
+func (b *Broker) requestDeadline(ctx context.Context) time.Time {
+	d, ok := ctx.Deadline()
+	if !ok || !b.negotiated(FeatureDeadline) {
+		return time.Time{}
+	}
+	return d
+}
+
+// deadlineFrame wraps a request with the time left until f.deadline. The
+// budget is relative because the gadget's clock cannot be trusted to match
+// the host's, and it is worked out at enqueue time so a retried request
+// carries what is actually left.
+func deadlineFrame(f outFrame) outFrame {
+	if f.deadline.IsZero() {
+		return f
+	}
+	switch f.typ {
+	case payloadTypeRequest, payloadTypeCompressedRequest:
+	default:
+		return f
+	}
+	budget := min(max(time.Until(f.deadline), time.Millisecond), maxDeadlineBudget)
+
+	p := make([]byte, deadlineHeaderLen+len(f.payload))
+	binary.LittleEndian.PutUint32(p[0:4], uint32(budget/time.Millisecond))
+	p[4] = byte(f.typ)
+	copy(p[deadlineHeaderLen:], f.payload)
+	f.typ, f.payload = payloadTypeDeadline, p
+	return f
+}
+
+// openDeadline undoes deadlineFrame.
+func openDeadline(payload []byte) (payloadType, []byte, time.Duration, error) {
+	if len(payload) < deadlineHeaderLen {
+		return payloadTypeUnknown, nil, 0, ErrInvalidPayload
+	}
+	budget := time.Duration(binary.LittleEndian.Uint32(payload[0:4])) * time.Millisecond
+	switch pt := payloadType(payload[4]); pt {
+	case payloadTypeRequest, payloadTypeCompressedRequest:
+		return pt, payload[deadlineHeaderLen:], budget, nil
+	default:
+		return payloadTypeUnknown, nil, 0, ErrInvalidPayload
+	}
+}
+
+// handlerContext is the context a request with the given budget is handled
+// under; zero means the caller sent none.
+func (b *Broker) handlerContext(budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return b.ctx, func() {}
+	}
+	return context.WithTimeout(b.ctx, budget)
+}
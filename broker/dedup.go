@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// request ids remembered for duplicate suppression
+	seenRequestsMax = 1024
+	// finished requests keep responses up to this size for resending
+	seenResponseMax = 64 * KB
+)
+
+// seenRequests remembers recent request ids, running or finished, so a
+// request retransmitted after a retry frame is never handled twice. Key
+// creation and deletion are not idempotent; a second run would not be
+// harmless. Entries are evicted oldest first, running ones last.
+type seenRequests struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // of *seenRequest, most recent at the front
+	items map[[16]byte]*list.Element
+}
+
+type seenRequest struct {
+	id   [16]byte
+	done bool
+	// resp is the finished request's response when small enough to keep
+	resp []byte
+}
+
+func newSeenRequests(max int) *seenRequests {
+	return &seenRequests{max: max, order: list.New(), items: make(map[[16]byte]*list.Element)}
+}
+
+// begin records id as running. It returns false for an id seen before,
+// along with a copy of the response when that request already finished
+// and its response was kept.
+func (s *seenRequests) begin(id [16]byte) (bool, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[id]; ok {
+		s.order.MoveToFront(el)
+		r := el.Value.(*seenRequest)
+		if r.done && r.resp != nil {
+			return false, append([]byte(nil), r.resp...)
+		}
+		return false, nil
+	}
+	s.items[id] = s.order.PushFront(&seenRequest{id: id})
+	for s.order.Len() > s.max {
+		s.evictLocked()
+	}
+	return true, nil
+}
+
+// finish marks id done and keeps a copy of resp when it is small.
+func (s *seenRequests) finish(id [16]byte, resp []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[id]
+	if !ok {
+		return
+	}
+	r := el.Value.(*seenRequest)
+	r.done = true
+	if len(resp) <= seenResponseMax {
+		r.resp = append([]byte{}, resp...)
+	}
+}
+
+// forget drops id, for a request refused before it ran; the caller sends it
+// again under a new id, and a duplicate of this one may be tried afresh.
+func (s *seenRequests) forget(id [16]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[id]; ok {
+		s.order.Remove(el)
+		delete(s.items, id)
+	}
+}
+
+func (s *seenRequests) evictLocked() {
+	victim := s.order.Back()
+	// a running request must stay; its duplicate would run alongside it
+	for el := victim; el != nil; el = el.Prev() {
+		if el.Value.(*seenRequest).done {
+			victim = el
+			break
+		}
+	}
+	r := s.order.Remove(victim).(*seenRequest)
+	clear(r.resp) // responses can carry key material
+	delete(s.items, r.id)
+}
+
+func (s *seenRequests) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,54 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// how often Close checks whether the broker has gone idle
+const drainPoll = 10 * time.Millisecond
+
+var ErrClosing = errors.New("broker is closing")
+
+// WithDrainTimeout makes Stop drain like Close for up to d before tearing
+// the broker down. Zero, the default, stops at once.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.drainTimeout = d
+		}
+	}
+}
+
+// Close stops taking new requests, waits until the broker's own requests are
+// answered, the peer's are handled and every queued frame is written, then
+// stops it. When ctx ends first the rest is dropped and ctx's error returned.
+// A dead or closed link is not waited on.
+func (b *Broker) Close(ctx context.Context) error {
+	b.closing.Store(true)
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+
+	var err error
+wait:
+	for !b.idle() {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		case <-b.dead:
+			break wait
+		case <-b.ctx.Done():
+			break wait
+		}
+	}
+	b.shutdown()
+	return err
+}
+
+// idle reports whether nothing is left to drain.
+func (b *Broker) idle() bool {
+	return b.calls.Load() == 0 && b.inflight.Load() == 0 && b.queued.Load() == 0
+}
@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// FeatureErrors: a request the broker cannot take is answered with an error
+// frame instead of being dropped, so Request fails with a *TransportError
+// rather than waiting out its context.
+const FeatureErrors Feature = 1 << 8
+
+const (
+	// error payload: code(2) message
+	errorHeaderLen = 2
+	// longer messages are cut; they are for logs, not for parsing
+	maxErrorMessage = 256
+
+	// requests handled at once before new ones are refused as overload
+	maxActiveRequests = 64
+)
+
+// ErrorCode is a transport-level failure reported by the peer's broker. It
+// is separate from whatever errors the application encodes in responses.
+type ErrorCode uint16
+
+const (
+	ErrorCodeUnknown            ErrorCode = 0
+	ErrorCodeOverload           ErrorCode = 1 // too many requests in progress
+	ErrorCodeTooLarge           ErrorCode = 2 // over the peer's size limits
+	ErrorCodeUnsupportedVersion ErrorCode = 3 // a frame the peer does not speak
+	ErrorCodeShuttingDown       ErrorCode = 4 // the peer is draining, see drain.go
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorCodeOverload:
+		return "peer is overloaded"
+	case ErrorCodeTooLarge:
+		return "request too large for the peer"
+	case ErrorCodeUnsupportedVersion:
+		return "peer does not support this protocol version"
+	case ErrorCodeShuttingDown:
+		return "peer is shutting down"
+	}
+	return fmt.Sprintf("transport error %d", uint16(c))
+}
+
+// TransportError is what Request returns when the peer answers with an error
+// frame. errors.Is matches it against the ErrPeer* values by code.
+type TransportError struct {
+	Code    ErrorCode
+	Message string
+}
+
+var (
+	ErrPeerOverloaded         = &TransportError{Code: ErrorCodeOverload}
+	ErrPeerTooLarge           = &TransportError{Code: ErrorCodeTooLarge}
+	ErrPeerUnsupportedVersion = &TransportError{Code: ErrorCodeUnsupportedVersion}
+	ErrPeerShuttingDown       = &TransportError{Code: ErrorCodeShuttingDown}
+)
+
+func (e *TransportError) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Code.String() + ": " + e.Message
+}
+
+func (e *TransportError) Is(target error) bool {
+	t, ok := target.(*TransportError)
+	return ok && t.Code == e.Code
+}
+
+// WithErrorFrames turns on the handshake and advertises FeatureErrors.
+func WithErrorFrames() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureErrors
+	}
+}
+
+func encodeError(code ErrorCode, msg string) []byte {
+	if len(msg) > maxErrorMessage {
+		msg = msg[:maxErrorMessage]
+	}
+	p := make([]byte, errorHeaderLen+len(msg))
+	binary.LittleEndian.PutUint16(p[0:2], uint16(code))
+	copy(p[errorHeaderLen:], msg)
+	return p
+}
+
+func decodeError(p []byte) (*TransportError, error) {
+	if len(p) < errorHeaderLen {
+		return nil, ErrInvalidPayload
+	}
+	msg := p[errorHeaderLen:]
+	if len(msg) > maxErrorMessage {
+		msg = msg[:maxErrorMessage]
+	}
+	return &TransportError{Code: ErrorCode(binary.LittleEndian.Uint16(p[0:2])), Message: string(msg)}, nil
+}
+
+// sendError answers request id with an error frame, when the peer reads them.
+// A peer without FeatureErrors keeps the old behaviour: no answer at all.
+func (b *Broker) sendError(id [16]byte, code ErrorCode, msg string) {
+	if !b.negotiated(FeatureErrors) {
+		return
+	}
+	b.logger.Debug("tx error", slog.String("id", fmt.Sprintf("%x", id)), slog.String("code", code.String()), slog.String("msg", msg))
+	if err := b.writeFrame(b.ctx, payloadTypeError, id, encodeError(code, msg)); err != nil {
+		b.logger.Debug("tx error failed", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+	}
+}
+
+// handlerError picks out a *TransportError the handler returned, which is
+// sent as an error frame in place of a response.
+func handlerError(err error) (*TransportError, bool) {
+	var te *TransportError
+	if errors.As(err, &te) {
+		return te, true
+	}
+	return nil, false
+}
+
+func (b *Broker) handleError(id [16]byte, payload []byte) {
+	te, err := decodeError(payload)
+	if err != nil {
+		b.logger.Warn("bad error frame", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+		return
+	}
+	b.logger.Debug("rx error", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", te))
+	b.unconfirmedRequests.Delete(id)
+	if ch, ok := b.waiters.LoadAndDelete(id); ok && ch != nil {
+		ch <- reply{err: te}
+	}
+}
@@ -18,4 +18,12 @@ var (
 	ErrDecodeHeaderShort             = errors.New("short header")
 	ErrDecodeHeaderBadMagic          = errors.New("bad magic")
 	ErrDecodeHeaderBadParity         = errors.New("bad parity")
+
+	ErrInvalidHello    = errors.New("invalid hello")
+	ErrPeerPayloadSize = errors.New("payload exceeds the peer's maximum message payload")
+
+	ErrInvalidCompressedPayload = errors.New("invalid compressed payload")
+	ErrInvalidFragment          = errors.New("invalid fragment")
+	ErrPeerDead                 = errors.New("peer stopped answering heartbeats")
+	ErrChecksumMismatch         = errors.New("frame checksum mismatch")
 )
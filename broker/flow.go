@@ -0,0 +1,166 @@
+package broker
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// FeatureFlowControl: a sender keeps at most the peer's window (from its
+// hello) of data frames in flight. The receiver hands credit back as it
+// parses frames off its stash, on accept frames or on a credit frame.
+// Control frames (accepts, hellos, pings, noise) are never held back.
+const FeatureFlowControl Feature = 1 << 5
+
+const (
+	// credit payload, also appended to accept frames: bytes consumed(4)
+	creditLen = 4
+
+	// a sender starved this long assumes credit was lost (bytes dropped on
+	// overflow or corruption are never returned) and sends anyway
+	creditStall = 2 * time.Second
+)
+
+// WithFlowControl turns on the handshake and advertises FeatureFlowControl.
+func WithFlowControl() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureFlowControl
+	}
+}
+
+// window is what this broker advertises: half its stash, leaving the other
+// half for control frames and a frame being reassembled.
+func (b *Broker) window() uint32 {
+	if b.features&FeatureFlowControl == 0 {
+		return 0
+	}
+	return uint32(b.capacity / 2)
+}
+
+func isDataFrame(pt payloadType) bool {
+	switch pt {
+	case payloadTypeRequest, payloadTypeResponse, payloadTypeSealed,
+		payloadTypeCompressedRequest, payloadTypeCompressedResponse, payloadTypeFragment, payloadTypeDeadline, payloadTypeSequenced, payloadTypeResponsePart:
+		return true
+	}
+	return false
+}
+
+// frameCreditEstimate is the wire size of f before sealing; the writer
+// corrects it to the real size (see adjustCredit).
+func frameCreditEstimate(f outFrame) int64 {
+	n := int64(HeaderLenChecksum + len(f.payload))
+	if f.seal {
+		n += 1 + noiseNonceLen + noiseTagLen
+	}
+	return n
+}
+
+func (b *Broker) flowNotifyLocked() {
+	close(b.creditChanged)
+	b.creditChanged = make(chan struct{})
+}
+
+// acquireCredit blocks until n more bytes fit the peer's window and returns
+// what it booked (zero without flow control). A frame larger than the whole
+// window goes out once nothing else is in flight.
+func (b *Broker) acquireCredit(ctx context.Context, n int64) (int64, error) {
+	if !b.negotiated(FeatureFlowControl) {
+		return 0, nil
+	}
+	peer, _ := b.Peer()
+	window := int64(peer.Window)
+	stall := time.NewTimer(creditStall)
+	defer stall.Stop()
+
+	for {
+		b.flowMu.Lock()
+		if b.outstanding == 0 || b.outstanding+n <= window {
+			b.outstanding += n
+			b.flowMu.Unlock()
+			return n, nil
+		}
+		changed := b.creditChanged
+		b.flowMu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stall.C:
+			b.logger.Warn("no credit from peer; assuming it was lost", slog.Int64("outstanding", b.inFlight()), slog.Int64("window", window))
+			b.flowMu.Lock()
+			b.outstanding = n
+			b.flowNotifyLocked()
+			b.flowMu.Unlock()
+			return n, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-b.ctx.Done():
+			return 0, io.EOF
+		}
+	}
+}
+
+// adjustCredit books the difference between estimate and the bytes that
+// actually went out. Writer loop only.
+func (b *Broker) adjustCredit(delta int64) {
+	if delta == 0 {
+		return
+	}
+	b.flowMu.Lock()
+	b.outstanding = max(0, b.outstanding+delta)
+	b.flowMu.Unlock()
+}
+
+func (b *Broker) releaseCredit(n int64) {
+	b.flowMu.Lock()
+	b.outstanding = max(0, b.outstanding-n)
+	b.flowNotifyLocked()
+	b.flowMu.Unlock()
+}
+
+func (b *Broker) inFlight() int64 {
+	b.flowMu.Lock()
+	defer b.flowMu.Unlock()
+	return b.outstanding
+}
+
+// consumed records a data frame parsed off the stash. Read loop only.
+func (b *Broker) consumed(pt payloadType, frameLen int) {
+	if isDataFrame(pt) && b.negotiated(FeatureFlowControl) {
+		b.pendingCredit.Add(int64(frameLen))
+	}
+}
+
+// takeCredit returns the credit not yet handed back, as an accept or credit
+// frame payload, or nil when there is none.
+func (b *Broker) takeCredit() []byte {
+	n := b.pendingCredit.Swap(0)
+	if n <= 0 {
+		return nil
+	}
+	p := make([]byte, creditLen)
+	binary.LittleEndian.PutUint32(p, uint32(min(n, int64(^uint32(0)))))
+	return p
+}
+
+// flushCredit sends pending credit on its own frame once the stash is
+// drained or a good part of the window is waiting to be returned.
+func (b *Broker) flushCredit() {
+	pending := b.pendingCredit.Load()
+	if pending <= 0 || (b.stash.Len() > 0 && pending < int64(b.window()/4)) {
+		return
+	}
+	if p := b.takeCredit(); p != nil {
+		_ = b.writeFrame(b.ctx, payloadTypeCredit, [16]byte{}, p)
+	}
+}
+
+func (b *Broker) handleCredit(payload []byte) {
+	if len(payload) < creditLen {
+		return
+	}
+	b.releaseCredit(int64(binary.LittleEndian.Uint32(payload)))
+}
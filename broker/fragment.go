@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// FeatureFragmentation: payloads over FRAGMENT_SIZE travel as sequenced
+// fragments under one message id and are reassembled before the handler or
+// the waiting Request sees them.
+const FeatureFragmentation Feature = 1 << 2
+
+const (
+	// fragment payload: inner type(1) index(4) count(4) data
+	fragmentHeaderLen = 1 + 4 + 4
+
+	// at most this many partial messages are kept; older ones are dropped
+	maxAssemblies = 4
+	// a partial message with no new fragment for this long is dropped
+	assemblyTTL = 30 * time.Second
+)
+
+// WithFragmentation turns on the handshake and advertises FeatureFragmentation.
+func WithFragmentation() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureFragmentation
+	}
+}
+
+// fragmentFrame splits f when it is too large for one frame. Frames that need
+// no splitting come back as they are.
+func (b *Broker) fragmentFrame(f outFrame) []outFrame {
+	if len(f.payload) <= FRAGMENT_SIZE || !b.negotiated(FeatureFragmentation) {
+		return []outFrame{f}
+	}
+	switch f.typ {
+	case payloadTypeRequest, payloadTypeResponse, payloadTypeCompressedRequest, payloadTypeCompressedResponse, payloadTypeDeadline, payloadTypeResponsePart:
+	default:
+		return []outFrame{f}
+	}
+
+	count := (len(f.payload) + FRAGMENT_SIZE - 1) / FRAGMENT_SIZE
+	frames := make([]outFrame, 0, count)
+	for i := range count {
+		chunk := f.payload[i*FRAGMENT_SIZE : min((i+1)*FRAGMENT_SIZE, len(f.payload))]
+		fb := getFrameBuf(fragmentHeaderLen + len(chunk))
+		p := fb.B
+		p[0] = byte(f.typ)
+		binary.LittleEndian.PutUint32(p[1:5], uint32(i))
+		binary.LittleEndian.PutUint32(p[5:9], uint32(count))
+		copy(p[fragmentHeaderLen:], chunk)
+		frames = append(frames, outFrame{typ: payloadTypeFragment, id: f.id, payload: p, seal: f.seal, ordered: f.ordered, pooled: []*frameBuf{fb}})
+	}
+	return frames
+}
+
+// isRequestFragment reports whether a fragment belongs to a request, which
+// the peer waits on an answer for.
+func isRequestFragment(payload []byte) bool {
+	if len(payload) < fragmentHeaderLen {
+		return false
+	}
+	switch payloadType(payload[0]) {
+	case payloadTypeRequest, payloadTypeCompressedRequest, payloadTypeDeadline:
+		return true
+	}
+	return false
+}
+
+type assembly struct {
+	typ     payloadType
+	sealed  bool
+	parts   [][]byte
+	got     int
+	size    int
+	updated time.Time
+}
+
+// reassemble collects one fragment. It returns the whole message once the
+// last missing fragment arrives. Runs on the read loop.
+func (b *Broker) reassemble(id [16]byte, payload []byte, sealed bool) (payloadType, []byte, bool, error) {
+	b.assemblyMu.Lock()
+	defer b.assemblyMu.Unlock()
+	if len(payload) < fragmentHeaderLen {
+		return payloadTypeUnknown, nil, false, ErrInvalidFragment
+	}
+	typ := payloadType(payload[0])
+	index := binary.LittleEndian.Uint32(payload[1:5])
+	count := binary.LittleEndian.Uint32(payload[5:9])
+	data := payload[fragmentHeaderLen:]
+	switch typ {
+	case payloadTypeRequest, payloadTypeResponse, payloadTypeCompressedRequest, payloadTypeCompressedResponse, payloadTypeDeadline, payloadTypeResponsePart:
+	default:
+		return payloadTypeUnknown, nil, false, ErrInvalidFragment
+	}
+	if count < 2 || index >= count || uint64(count)*FRAGMENT_SIZE > MAX_REASSEMBLED_PAYLOAD+FRAGMENT_SIZE {
+		return payloadTypeUnknown, nil, false, ErrInvalidFragment
+	}
+
+	now := time.Now()
+	b.expireAssemblies(now)
+
+	a, ok := b.assemblies[id]
+	if !ok {
+		if len(b.assemblies) >= maxAssemblies {
+			b.dropOldestAssembly()
+		}
+		a = &assembly{typ: typ, sealed: sealed, parts: make([][]byte, count)}
+		b.assemblies[id] = a
+	}
+	if a.typ != typ || a.sealed != sealed || len(a.parts) != int(count) {
+		delete(b.assemblies, id)
+		return payloadTypeUnknown, nil, false, ErrInvalidFragment
+	}
+	a.updated = now
+	if a.parts[index] != nil {
+		return payloadTypeUnknown, nil, false, nil // resent after a retry
+	}
+	if a.size+len(data) > MAX_REASSEMBLED_PAYLOAD {
+		delete(b.assemblies, id)
+		return payloadTypeUnknown, nil, false, ErrInvalidPayloadSize
+	}
+	a.parts[index] = append([]byte(nil), data...) // data is in a pooled buffer
+	a.got++
+	a.size += len(data)
+	if a.got < len(a.parts) {
+		return payloadTypeUnknown, nil, false, nil
+	}
+
+	delete(b.assemblies, id)
+	whole := make([]byte, 0, a.size)
+	for _, part := range a.parts {
+		whole = append(whole, part...)
+		clear(part)
+	}
+	return a.typ, whole, true, nil
+}
+
+// expireAssemblies drops partial messages idle past assemblyTTL. Callers
+// hold assemblyMu.
+func (b *Broker) expireAssemblies(now time.Time) {
+	for id, a := range b.assemblies {
+		if now.Sub(a.updated) > assemblyTTL {
+			b.logger.Warn("dropping incomplete message", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("fragments", a.got), slog.Int("of", len(a.parts)))
+			for _, part := range a.parts {
+				clear(part)
+			}
+			delete(b.assemblies, id)
+			b.reapedAssemblies.Add(1)
+		}
+	}
+}
+
+func (b *Broker) dropOldestAssembly() {
+	var oldest [16]byte
+	var at time.Time
+	for id, a := range b.assemblies {
+		if at.IsZero() || a.updated.Before(at) {
+			oldest, at = id, a.updated
+		}
+	}
+	b.logger.Warn("too many incomplete messages; dropping the oldest", slog.String("id", fmt.Sprintf("%x", oldest)))
+	delete(b.assemblies, oldest)
+}
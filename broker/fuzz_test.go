@@ -0,0 +1,148 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// The fuzz targets here cover the bytes a broker takes from the wire before
+// anything authenticates them. A failing input is written to
+// testdata/fuzz/<target>, where plain `go test` replays it; keep it there as
+// a regression case once fixed.
+
+func fuzzFrames() [][]byte {
+	id := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	plain, _ := newMessage(payloadTypeRequest, id, []byte("sign this"))
+	empty, _ := newMessage(payloadTypeKeepAlive, [16]byte{}, nil)
+	summed, _ := newChecksummedMessage(payloadTypeSealed, id, bytes.Repeat([]byte{0xab}, 48))
+	return [][]byte{plain, empty, summed}
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	for _, frame := range fuzzFrames() {
+		f.Add(frame)
+		f.Add(frame[:len(frame)/2])
+	}
+	f.Add([]byte{MagicByte})
+	f.Add([]byte{MagicByteChecksum, 0x01})
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		h, err := DecodeHeader(src)
+		if err != nil {
+			return
+		}
+		if h.Magic != MagicByte && h.Magic != MagicByteChecksum {
+			t.Fatalf("accepted magic %#x", h.Magic)
+		}
+		if len(src) < h.Len() {
+			t.Fatalf("decoded a %d byte header from %d bytes", h.Len(), len(src))
+		}
+		// what decodes must be what an encoder writes for it, byte for byte
+		if enc := encodeHeader(h); !bytes.Equal(enc, src[:h.Len()]) {
+			t.Fatalf("header %x re-encodes as %x", src[:h.Len()], enc)
+		}
+	})
+}
+
+// encodeHeader writes h as a frame header; the CRC of a checksummed header
+// is taken from h rather than computed.
+func encodeHeader(h Header) []byte {
+	dst := make([]byte, h.Len())
+	dst[0] = h.Magic
+	dst[1] = byte(h.Type)
+	copy(dst[2:18], h.ID[:])
+	binary.LittleEndian.PutUint32(dst[18:22], h.Size)
+	if h.Magic == MagicByteChecksum {
+		binary.LittleEndian.PutUint32(dst[22:26], h.CRC)
+	}
+	p, _ := headerParity(dst[:len(dst)-1])
+	dst[len(dst)-1] = p
+	return dst
+}
+
+// FuzzStashReadFrame feeds a byte stream to the stash in the pieces cuts
+// describes, as short USB reads deliver it, and reads frames the way the
+// read loop does. The frames must not depend on the pieces, must each be a
+// frame the stream really holds, and reading must never stall on bytes it
+// could drop.
+func FuzzStashReadFrame(f *testing.F) {
+	frames := fuzzFrames()
+	stream := bytes.Join(frames, nil)
+	f.Add(stream, []byte{})
+	f.Add(stream, []byte{1})
+	f.Add(stream, []byte{HeaderLen - 1, 5, HeaderLenChecksum + 3})
+	// garbage, a damaged checksum, a header claiming too much, a cut frame
+	damaged := bytes.Clone(frames[2])
+	damaged[len(damaged)-1] ^= 0xff
+	oversized, _ := newMessage(payloadTypeRequest, [16]byte{}, nil)
+	oversized[21] = 0x7f
+	oversized[22], _ = headerParity(oversized[:22])
+	f.Add(bytes.Join([][]byte{[]byte("noise"), damaged, frames[0], oversized, frames[1], frames[2][:30]}, nil), []byte{3, 7})
+
+	f.Fuzz(func(t *testing.T, stream, cuts []byte) {
+		whole := readStashFrames(t, stream, nil)
+		pieces := readStashFrames(t, stream, cuts)
+		if len(whole) != len(pieces) {
+			t.Fatalf("%d frames in one piece, %d in pieces %v", len(whole), len(pieces), cuts)
+		}
+		pos := 0
+		for i, fr := range whole {
+			if fr != pieces[i] {
+				t.Fatalf("frame %d: %q in one piece, %q in pieces %v", i, fr, pieces[i], cuts)
+			}
+			at := bytes.Index(stream[pos:], []byte(fr))
+			if at < 0 {
+				t.Fatalf("frame %d (%x) is not in the stream after offset %d", i, fr, pos)
+			}
+			pos += at + len(fr)
+		}
+	})
+}
+
+// readStashFrames returns every frame read from stream, re-encoded, after
+// writing it in pieces of the sizes in cuts (the rest in one piece).
+func readStashFrames(t *testing.T, stream, cuts []byte) []string {
+	s := newStash(len(stream)+1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var out []string
+	drain := func() {
+		for {
+			before := s.Len()
+			h, fb, err := s.ReadFrame()
+			switch {
+			case err == nil:
+				if int(h.Size) > MAX_MESSAGE_PAYLOAD || len(fb.B) != int(h.Size) {
+					t.Fatalf("frame %+v with %d payload bytes", h, len(fb.B))
+				}
+				dst := make([]byte, h.Len()+len(fb.B))
+				if h.Magic == MagicByteChecksum {
+					_ = encodeChecksummedMessage(dst, h.Type, h.ID, fb.B)
+				} else {
+					_ = encodeMessage(dst, h.Type, h.ID, fb.B)
+				}
+				fb.release()
+				out = append(out, string(dst))
+			case errors.Is(err, ErrIncompleteHeader), errors.Is(err, ErrIncompletePayload), errors.Is(err, ErrNoPayloadFound):
+				return // waits for more bytes
+			case s.Len() >= before:
+				// a refused frame that drops nothing is read again forever
+				t.Fatalf("ReadFrame refused %d stashed bytes without dropping any: %v", before, err)
+			}
+		}
+	}
+	for _, c := range cuts {
+		n := min(len(stream), max(1, int(c)))
+		if n == 0 {
+			break
+		}
+		_, _ = s.Write(stream[:n])
+		stream = stream[n:]
+		drain()
+	}
+	_, _ = s.Write(stream)
+	drain()
+	return out
+}
@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// ProtocolVersion is the broker protocol spoken by this build. A peer that
+// never sends a hello predates the handshake and is treated as version 0:
+// same framing, no optional features.
+const ProtocolVersion uint16 = 1
+
+// Feature is a bit set of optional protocol features. Only features both
+// ends advertise are used.
+type Feature uint32
+
+// PeerInfo is what the peer announced in its hello, with Features already
+// narrowed to the ones both ends support.
+type PeerInfo struct {
+	Version    uint16
+	MaxPayload uint32
+	Features   Feature
+	// Window is the data the peer accepts in flight (flow control); zero
+	// when it did not say.
+	Window uint32
+}
+
+const (
+	// hello payload: flags(1) version(2) max payload(4) features(4) [window(4)]
+	helloLen       = 1 + 2 + 4 + 4
+	helloLenWindow = helloLen + 4
+	helloFlagReply = 0x01 // answer to the peer's hello; do not answer again
+)
+
+func encodeHello(reply bool, features Feature, window uint32) []byte {
+	p := make([]byte, helloLenWindow)
+	if reply {
+		p[0] = helloFlagReply
+	}
+	binary.LittleEndian.PutUint16(p[1:3], ProtocolVersion)
+	binary.LittleEndian.PutUint32(p[3:7], MAX_MESSAGE_PAYLOAD)
+	binary.LittleEndian.PutUint32(p[7:11], uint32(features))
+	binary.LittleEndian.PutUint32(p[11:15], window)
+	return p
+}
+
+// decodeHello ignores bytes past helloLen, so later versions can append
+// fields without breaking this one.
+func decodeHello(p []byte) (reply bool, info PeerInfo, err error) {
+	if len(p) < helloLen {
+		return false, PeerInfo{}, ErrInvalidHello
+	}
+	info.Version = binary.LittleEndian.Uint16(p[1:3])
+	info.MaxPayload = binary.LittleEndian.Uint32(p[3:7])
+	info.Features = Feature(binary.LittleEndian.Uint32(p[7:11]))
+	if len(p) >= helloLenWindow {
+		info.Window = binary.LittleEndian.Uint32(p[11:15])
+	}
+	if info.Version == 0 || info.MaxPayload == 0 {
+		return false, PeerInfo{}, ErrInvalidHello
+	}
+	return p[0]&helloFlagReply != 0, info, nil
+}
+
+func (b *Broker) sendHello(reply bool) {
+	if err := b.writeFrame(b.ctx, payloadTypeHello, [16]byte{}, encodeHello(reply, b.features, b.window())); err != nil {
+		b.logger.Debug("tx hello failed", slog.Any("err", err))
+	}
+}
+
+func (b *Broker) handleHello(payload []byte) {
+	if !b.handshake {
+		b.logger.Debug("rx hello ignored (handshake disabled)")
+		return
+	}
+	reply, info, err := decodeHello(payload)
+	if err != nil {
+		b.logger.Warn("bad hello", slog.Any("err", err))
+		return
+	}
+	info.Features &= b.features
+	if info.Window == 0 {
+		info.Features &^= FeatureFlowControl
+	}
+	b.peer.Store(&info)
+	b.handshakeOnce.Do(func() { close(b.handshakeDone) })
+
+	b.logger.Debug("rx hello",
+		slog.Int("version", int(info.Version)),
+		slog.Int("max_payload", int(info.MaxPayload)),
+		slog.String("features", fmt.Sprintf("%#x", info.Features)),
+	)
+	if info.Version > ProtocolVersion {
+		b.logger.Info("peer speaks a newer broker protocol; using ours", slog.Int("peer", int(info.Version)), slog.Int("local", int(ProtocolVersion)))
+	}
+	if !reply {
+		// the peer (re)started; tell it who we are
+		b.sendHello(true)
+	}
+	if b.noise != nil && b.noise.Initiator && info.Features&FeatureNoise != 0 {
+		b.noiseMu.Lock()
+		idle := b.session.Load() == nil && b.noisePending == nil
+		b.noiseMu.Unlock()
+		if !reply || idle {
+			b.startNoise()
+		}
+	}
+}
+
+// Peer returns the peer's hello, or false while none has arrived (or the
+// peer predates the handshake).
+func (b *Broker) Peer() (PeerInfo, bool) {
+	if info := b.peer.Load(); info != nil {
+		return *info, true
+	}
+	return PeerInfo{}, false
+}
+
+// negotiated reports whether both ends advertised f.
+func (b *Broker) negotiated(f Feature) bool {
+	info := b.peer.Load()
+	return info != nil && info.Features&f != 0
+}
+
+// WaitHandshake blocks until the peer's first hello. A peer that predates the
+// handshake never sends one; callers bound the wait with ctx and carry on
+// with the version 0 behaviour.
+func (b *Broker) WaitHandshake(ctx context.Context) (PeerInfo, error) {
+	select {
+	case <-b.handshakeDone:
+		info, _ := b.Peer()
+		return info, nil
+	case <-ctx.Done():
+		return PeerInfo{}, ctx.Err()
+	case <-b.ctx.Done():
+		return PeerInfo{}, io.EOF
+	}
+}
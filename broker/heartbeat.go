@@ -0,0 +1,107 @@
+package broker
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"time"
+)
+
+// FeatureHeartbeat: the broker answers ping frames with pong frames. Every
+// broker with the handshake advertises it.
+const FeatureHeartbeat Feature = 1 << 3
+
+const (
+	// ping/pong payload: send time in unix nanoseconds(8), echoed back
+	pingLen = 8
+
+	DefaultHeartbeatMisses = 3
+)
+
+// WithHeartbeat pings the peer every interval once both ends negotiated
+// heartbeats and declares it dead (see Dead) after misses intervals without
+// any frame from it. A broker with the handshake but no WithHeartbeat still
+// answers pings.
+func WithHeartbeat(interval time.Duration, misses int) Option {
+	return func(o *options) {
+		if interval <= 0 {
+			return
+		}
+		if misses <= 0 {
+			misses = DefaultHeartbeatMisses
+		}
+		o.handshake = true
+		o.features |= FeatureHeartbeat
+		o.heartbeat, o.heartbeatMisses = interval, misses
+	}
+}
+
+// Dead is closed once the peer has been silent past the heartbeat threshold.
+// Requests fail with ErrPeerDead from then on; the owner is expected to tear
+// the session down and reconnect.
+func (b *Broker) Dead() <-chan struct{} {
+	return b.dead
+}
+
+// RTT is the round trip of the last answered ping, zero before the first.
+func (b *Broker) RTT() time.Duration {
+	return time.Duration(b.rtt.Load())
+}
+
+func (b *Broker) markDead(silence time.Duration) {
+	b.deadOnce.Do(func() {
+		b.logger.Warn("peer missed heartbeats; declaring it dead", slog.Duration("silence", silence))
+		close(b.dead)
+	})
+}
+
+func (b *Broker) heartbeatLoop() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(b.heartbeat)
+		defer t.Stop()
+
+		threshold := b.heartbeat * time.Duration(b.heartbeatMisses)
+		var armed bool
+		for {
+			select {
+			case <-b.ctx.Done():
+				return
+			case now := <-t.C:
+				if !b.negotiated(FeatureHeartbeat) {
+					continue // older peer: nothing would answer
+				}
+				if !armed {
+					// count silence from the first ping, not from start
+					b.lastRx.Store(now.UnixNano())
+					armed = true
+				}
+				if silence := now.Sub(time.Unix(0, b.lastRx.Load())); silence > threshold {
+					b.markDead(silence)
+					return
+				}
+				p := make([]byte, pingLen)
+				binary.LittleEndian.PutUint64(p, uint64(now.UnixNano()))
+				_ = b.writeFrame(b.ctx, payloadTypePing, [16]byte{}, p)
+			}
+		}
+	}()
+	return done
+}
+
+func (b *Broker) handlePing(payload []byte) {
+	if !b.handshake || len(payload) != pingLen {
+		return
+	}
+	_ = b.writeFrame(b.ctx, payloadTypePong, [16]byte{}, payload)
+}
+
+func (b *Broker) handlePong(payload []byte) {
+	if len(payload) != pingLen {
+		return
+	}
+	sent := int64(binary.LittleEndian.Uint64(payload))
+	if rtt := time.Now().UnixNano() - sent; rtt >= 0 {
+		b.rtt.Store(rtt)
+	}
+}
@@ -0,0 +1,337 @@
+package broker
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Noise_XX_25519_ChaChaPoly_SHA256 (noiseprotocol.org, revision 34), written
+// out because it is small and the gadget image carries no Noise library.
+// Transport messages carry their nonce explicitly (spec §11.4) so frames can
+// be resealed on retry; see secure.go.
+const (
+	noiseProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+	noisePrologue     = "tezsign-broker/v1"
+
+	noiseKeyLen = 32
+	noiseTagLen = 16
+
+	noiseMsg1Len = noiseKeyLen                               // e
+	noiseMsg2Len = noiseKeyLen + noiseKeyLen + 2*noiseTagLen // e, enc(s), enc(payload)
+	noiseMsg3Len = noiseKeyLen + 2*noiseTagLen               // enc(s), enc(payload)
+)
+
+var (
+	ErrNoiseHandshake = errors.New("noise handshake failed")
+	ErrNoiseDecrypt   = errors.New("noise decrypt failed")
+	ErrNoiseReplay    = errors.New("noise nonce replayed")
+)
+
+type noiseCipherState struct {
+	aead cipher.AEAD // nil until a key is mixed in
+	n    uint64
+}
+
+func noiseNonce(n uint64) []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce[:]
+}
+
+func (cs *noiseCipherState) setKey(k []byte) {
+	cs.aead, _ = chacha20poly1305.New(k) // a 32-byte key cannot fail
+	cs.n = 0
+}
+
+func (cs *noiseCipherState) encrypt(ad, plain []byte) []byte {
+	if cs.aead == nil {
+		return append([]byte(nil), plain...)
+	}
+	out := cs.aead.Seal(nil, noiseNonce(cs.n), plain, ad)
+	cs.n++
+	return out
+}
+
+func (cs *noiseCipherState) decrypt(ad, ct []byte) ([]byte, error) {
+	if cs.aead == nil {
+		return append([]byte(nil), ct...), nil
+	}
+	out, err := cs.aead.Open(nil, noiseNonce(cs.n), ct, ad)
+	if err != nil {
+		return nil, ErrNoiseHandshake
+	}
+	cs.n++
+	return out, nil
+}
+
+type noiseSymmetricState struct {
+	cs noiseCipherState
+	ck [32]byte
+	h  [32]byte
+}
+
+func newNoiseSymmetricState() *noiseSymmetricState {
+	ss := &noiseSymmetricState{}
+	// the name is exactly HASHLEN bytes, so it is used as h directly
+	copy(ss.h[:], noiseProtocolName)
+	ss.ck = ss.h
+	ss.mixHash([]byte(noisePrologue))
+	return ss
+}
+
+func (ss *noiseSymmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(ss.h[:])
+	h.Write(data)
+	h.Sum(ss.h[:0])
+}
+
+// noiseHKDF is the spec's HKDF with two outputs.
+func noiseHKDF(ck, ikm []byte) (out1, out2 [32]byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{0x01})
+	mac.Sum(out1[:0])
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1[:])
+	mac.Write([]byte{0x02})
+	mac.Sum(out2[:0])
+	clear(temp)
+	return out1, out2
+}
+
+func (ss *noiseSymmetricState) mixKey(ikm []byte) {
+	ck, k := noiseHKDF(ss.ck[:], ikm)
+	ss.ck = ck
+	ss.cs.setKey(k[:])
+	clear(k[:])
+}
+
+func (ss *noiseSymmetricState) encryptAndHash(plain []byte) []byte {
+	ct := ss.cs.encrypt(ss.h[:], plain)
+	ss.mixHash(ct)
+	return ct
+}
+
+func (ss *noiseSymmetricState) decryptAndHash(ct []byte) ([]byte, error) {
+	plain, err := ss.cs.decrypt(ss.h[:], ct)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ct)
+	return plain, nil
+}
+
+func (ss *noiseSymmetricState) split() (k1, k2 [32]byte) {
+	k1, k2 = noiseHKDF(ss.ck[:], nil)
+	clear(ss.ck[:])
+	return k1, k2
+}
+
+// noiseHandshake runs one side of XX:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+type noiseHandshake struct {
+	initiator bool
+	ss        *noiseSymmetricState
+	s         *ecdh.PrivateKey
+	e         *ecdh.PrivateKey
+	re        *ecdh.PublicKey
+	rs        *ecdh.PublicKey
+}
+
+func newNoiseHandshake(initiator bool, static *ecdh.PrivateKey) (*noiseHandshake, error) {
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &noiseHandshake{initiator: initiator, ss: newNoiseSymmetricState(), s: static, e: e}, nil
+}
+
+func (hs *noiseHandshake) dh(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) error {
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return ErrNoiseHandshake
+	}
+	hs.ss.mixKey(shared)
+	clear(shared)
+	return nil
+}
+
+func (hs *noiseHandshake) readKey(raw []byte) (*ecdh.PublicKey, error) {
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, ErrNoiseHandshake
+	}
+	return pub, nil
+}
+
+// writeMessage1 (initiator): -> e
+func (hs *noiseHandshake) writeMessage1() []byte {
+	e := hs.e.PublicKey().Bytes()
+	hs.ss.mixHash(e)
+	return append(e, hs.ss.encryptAndHash(nil)...)
+}
+
+// readMessage1 (responder)
+func (hs *noiseHandshake) readMessage1(msg []byte) error {
+	if len(msg) != noiseMsg1Len {
+		return ErrNoiseHandshake
+	}
+	re, err := hs.readKey(msg)
+	if err != nil {
+		return err
+	}
+	hs.re = re
+	hs.ss.mixHash(msg)
+	_, err = hs.ss.decryptAndHash(nil)
+	return err
+}
+
+// writeMessage2 (responder): <- e, ee, s, es
+func (hs *noiseHandshake) writeMessage2() ([]byte, error) {
+	e := hs.e.PublicKey().Bytes()
+	hs.ss.mixHash(e)
+	msg := e
+	if err := hs.dh(hs.e, hs.re); err != nil {
+		return nil, err
+	}
+	msg = append(msg, hs.ss.encryptAndHash(hs.s.PublicKey().Bytes())...)
+	if err := hs.dh(hs.s, hs.re); err != nil {
+		return nil, err
+	}
+	return append(msg, hs.ss.encryptAndHash(nil)...), nil
+}
+
+// readMessage2 (initiator); returns the responder's static key.
+func (hs *noiseHandshake) readMessage2(msg []byte) ([]byte, error) {
+	if len(msg) != noiseMsg2Len {
+		return nil, ErrNoiseHandshake
+	}
+	re, err := hs.readKey(msg[:noiseKeyLen])
+	if err != nil {
+		return nil, err
+	}
+	hs.re = re
+	hs.ss.mixHash(msg[:noiseKeyLen])
+	if err := hs.dh(hs.e, hs.re); err != nil {
+		return nil, err
+	}
+	rawS, err := hs.ss.decryptAndHash(msg[noiseKeyLen : 2*noiseKeyLen+noiseTagLen])
+	if err != nil {
+		return nil, err
+	}
+	if hs.rs, err = hs.readKey(rawS); err != nil {
+		return nil, err
+	}
+	if err := hs.dh(hs.e, hs.rs); err != nil {
+		return nil, err
+	}
+	if _, err := hs.ss.decryptAndHash(msg[2*noiseKeyLen+noiseTagLen:]); err != nil {
+		return nil, err
+	}
+	return rawS, nil
+}
+
+// writeMessage3 (initiator): -> s, se
+func (hs *noiseHandshake) writeMessage3() ([]byte, error) {
+	msg := hs.ss.encryptAndHash(hs.s.PublicKey().Bytes())
+	if err := hs.dh(hs.s, hs.re); err != nil {
+		return nil, err
+	}
+	return append(msg, hs.ss.encryptAndHash(nil)...), nil
+}
+
+// readMessage3 (responder); returns the initiator's static key.
+func (hs *noiseHandshake) readMessage3(msg []byte) ([]byte, error) {
+	if len(msg) != noiseMsg3Len {
+		return nil, ErrNoiseHandshake
+	}
+	rawS, err := hs.ss.decryptAndHash(msg[:noiseKeyLen+noiseTagLen])
+	if err != nil {
+		return nil, err
+	}
+	if hs.rs, err = hs.readKey(rawS); err != nil {
+		return nil, err
+	}
+	if err := hs.dh(hs.e, hs.rs); err != nil {
+		return nil, err
+	}
+	if _, err := hs.ss.decryptAndHash(msg[noiseKeyLen+noiseTagLen:]); err != nil {
+		return nil, err
+	}
+	return rawS, nil
+}
+
+// transport derives the two directions once the handshake is complete.
+func (hs *noiseHandshake) transport() *noiseTransport {
+	k1, k2 := hs.ss.split()
+	defer clear(k1[:])
+	defer clear(k2[:])
+
+	send, recv := k1, k2
+	if !hs.initiator {
+		send, recv = k2, k1
+	}
+	t := &noiseTransport{}
+	t.send, _ = chacha20poly1305.New(send[:])
+	t.recv, _ = chacha20poly1305.New(recv[:])
+	return t
+}
+
+// noiseTransport seals frames with an explicit, strictly increasing nonce.
+// USB bulk transfers arrive in order, so the receiver can simply refuse
+// anything not newer than the last frame it opened.
+type noiseTransport struct {
+	send     cipher.AEAD
+	sendN    uint64
+	recv     cipher.AEAD
+	recvNext uint64
+}
+
+const noiseNonceLen = 8
+
+// sealedLen is the size seal produces for a plaintext of n bytes.
+func sealedLen(n int) int {
+	return noiseNonceLen + n + noiseTagLen
+}
+
+// seal appends the sealed frame to dst. It is called from the single writer
+// goroutine only.
+func (t *noiseTransport) seal(dst, ad, plain []byte) []byte {
+	n := t.sendN
+	t.sendN++
+	dst = binary.LittleEndian.AppendUint64(dst, n)
+	return t.send.Seal(dst, noiseNonce(n), plain, ad)
+}
+
+// open appends the plaintext to dst. It is called from the single reader
+// goroutine only.
+func (t *noiseTransport) open(dst, ad, sealed []byte) ([]byte, error) {
+	if len(sealed) < noiseNonceLen+noiseTagLen {
+		return nil, ErrNoiseDecrypt
+	}
+	n := binary.LittleEndian.Uint64(sealed)
+	if n < t.recvNext {
+		return nil, ErrNoiseReplay
+	}
+	plain, err := t.recv.Open(dst, noiseNonce(n), sealed[noiseNonceLen:], ad)
+	if err != nil {
+		return nil, ErrNoiseDecrypt
+	}
+	t.recvNext = n + 1
+	return plain, nil
+}
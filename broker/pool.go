@@ -0,0 +1,100 @@
+package broker
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Frame buffers come in power-of-two size classes from minPooledBuf up to
+// the class holding MAX_POOLED_PAYLOAD plus a header. Larger buffers are
+// plain allocations that are never pooled.
+const (
+	minPooledBufShift = 8 // 256 bytes
+	minPooledBuf      = 1 << minPooledBufShift
+)
+
+var pooledClasses = bits.Len(uint(MAX_POOLED_PAYLOAD+HeaderLenChecksum-1)) - minPooledBufShift + 1
+
+var framePools = make([]sync.Pool, pooledClasses)
+
+// frameBuf is a reference-counted buffer. Whoever holds a reference calls
+// release when done; the last release wipes the bytes (frames carry
+// passphrases) and returns the buffer to its pool.
+type frameBuf struct {
+	B     []byte
+	refs  atomic.Int32
+	class int // -1 when not pooled
+}
+
+func bufClass(n int) int {
+	if n > MAX_POOLED_PAYLOAD+HeaderLenChecksum {
+		return -1
+	}
+	if n <= minPooledBuf {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minPooledBufShift
+}
+
+// getFrameBuf returns a buffer of length n holding one reference.
+func getFrameBuf(n int) *frameBuf {
+	c := bufClass(n)
+	var fb *frameBuf
+	switch v := framePoolGet(c); {
+	case v != nil:
+		fb = v
+		fb.B = fb.B[:n]
+	case c < 0:
+		fb = &frameBuf{B: make([]byte, n), class: -1}
+	default:
+		fb = &frameBuf{B: make([]byte, n, minPooledBuf<<c), class: c}
+	}
+	fb.refs.Store(1)
+	return fb
+}
+
+func framePoolGet(c int) *frameBuf {
+	if c < 0 {
+		return nil
+	}
+	v, _ := framePools[c].Get().(*frameBuf)
+	return v
+}
+
+// release drops one reference; nil stands for a heap slice and is a no-op.
+func (fb *frameBuf) release() {
+	if fb == nil {
+		return
+	}
+	switch n := fb.refs.Add(-1); {
+	case n > 0:
+		return
+	case n < 0:
+		panic("broker: frame buffer released twice")
+	}
+	clear(fb.B[:cap(fb.B)])
+	if fb.class < 0 {
+		return
+	}
+	fb.B = fb.B[:0]
+	framePools[fb.class].Put(fb)
+}
+
+// releaseUnless releases fb unless keep points into it, in which case the
+// bytes now belong to whoever holds keep and the buffer is left to the GC.
+func (fb *frameBuf) releaseUnless(keep []byte) {
+	if fb == nil || overlaps(keep, fb.B[:cap(fb.B)]) {
+		return
+	}
+	fb.release()
+}
+
+func overlaps(a, b []byte) bool {
+	if cap(a) == 0 || cap(b) == 0 {
+		return false
+	}
+	pa, pb := uintptr(unsafe.Pointer(unsafe.SliceData(a))), uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	return pa < pb+uintptr(cap(b)) && pb < pa+uintptr(cap(a))
+}
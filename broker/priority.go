@@ -0,0 +1,51 @@
+package broker
+
+import "context"
+
+// Priority picks the write lane for a frame. The writer always drains the
+// high lane first, so a consensus signature never queues behind the
+// fragments of a log or status transfer on the same channel.
+type Priority uint8
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+// WithPriority marks requests made with ctx.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// WithRequestPriority classifies incoming requests; the response goes out on
+// the lane fn picks. Without it responses use the normal lane.
+func WithRequestPriority(fn func(payload []byte) Priority) Option {
+	return func(o *options) { o.priority = fn }
+}
+
+// nextFrame waits for the next frame to write, preferring the high lane.
+// ok is false when the keep-alive timer fired instead.
+func (b *Broker) nextFrame() (f outFrame, ok bool, done bool) {
+	select {
+	case f = <-b.writeHigh:
+		return f, true, false
+	default:
+	}
+	select {
+	case f = <-b.writeHigh:
+		return f, true, false
+	case f = <-b.writeChan:
+		return f, true, false
+	case <-b.keepAliveTick:
+		return outFrame{}, false, false
+	case <-b.ctx.Done():
+		return outFrame{}, false, true
+	}
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	// how often the reaper looks for leftovers
+	DefaultReapInterval = 30 * time.Second
+	// bytes of a frame that stopped arriving are dropped after this long
+	stashTTL = assemblyTTL
+)
+
+// ReapStats counts what the reaper (and the read loop, for partial messages
+// it found expired) threw away.
+type ReapStats struct {
+	// Waiters are requests whose caller went away without cleaning up,
+	// e.g. a ResponseStream nobody read to the end.
+	Waiters uint64
+	// Assemblies are fragmented messages missing fragments past their TTL.
+	Assemblies uint64
+	// StashBytes are the bytes of frames whose rest never arrived.
+	StashBytes uint64
+}
+
+// WithReapInterval sets how often the reaper runs; DefaultReapInterval
+// otherwise.
+func WithReapInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.reapInterval = d
+		}
+	}
+}
+
+// Reaped returns the reaper's counters.
+func (b *Broker) Reaped() ReapStats {
+	return ReapStats{
+		Waiters:    b.reapedWaiters.Load(),
+		Assemblies: b.reapedAssemblies.Load(),
+		StashBytes: b.reapedStash.Load(),
+	}
+}
+
+func (b *Broker) reapLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			b.reap(now)
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// reap drops state nothing will come back for. Long sessions over a flaky
+// link would otherwise collect it.
+func (b *Broker) reap(now time.Time) {
+	var waiters int
+	b.waiters.Range(func(k, v any) bool {
+		id, w := k.([16]byte), v.(*waiter)
+		if w.ctx.Err() == nil {
+			return true
+		}
+		if s, ok := b.streams.Load(id); ok {
+			s.(*ResponseStream).Close()
+		} else {
+			b.forgetRequest(id)
+		}
+		waiters++
+		return true
+	})
+	// a request is only retransmitted for a waiter still listening
+	for id := range b.unconfirmedRequests.All() {
+		if _, ok := b.waiters.Load(id); !ok {
+			b.unconfirmedRequests.Delete(id)
+		}
+	}
+	b.reapedWaiters.Add(uint64(waiters))
+
+	before := b.reapedAssemblies.Load()
+	b.assemblyMu.Lock()
+	b.expireAssemblies(now)
+	b.assemblyMu.Unlock()
+
+	stash := b.reapStash(now)
+	if assemblies := b.reapedAssemblies.Load() - before; waiters > 0 || assemblies > 0 || stash > 0 {
+		b.logger.Info("reaped stale state", slog.Int("waiters", waiters), slog.Uint64("assemblies", assemblies), slog.Int("stash_bytes", stash))
+	}
+}
+
+// reapStash drops a partial frame that has had no new bytes for stashTTL. The
+// read loop calls it too before stashing new bytes, which would otherwise be
+// taken for the rest of that frame.
+func (b *Broker) reapStash(now time.Time) int {
+	n := b.stash.reap(now.Add(-stashTTL))
+	if n > 0 {
+		b.reapedStash.Add(uint64(n))
+		b.logger.Debug("dropped stale partial frame", slog.Int("bytes", n))
+	}
+	return n
+}
@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultAckTimeout is how long a request waits for its accept before
+	// WithRetransmit sends it again. It leaves room for a queue of large
+	// fragmented frames ahead of it.
+	DefaultAckTimeout = 5 * time.Second
+	// DefaultRetransmits is how many times a request is resent before
+	// Request gives up with ErrNoAck.
+	DefaultRetransmits = 3
+)
+
+var ErrNoAck = errors.New("peer did not acknowledge the request")
+
+// WithRetransmit resends a request that got neither an accept nor a response
+// within timeout, up to attempts times, then fails it with ErrNoAck. Without
+// it a request is only resent when the peer sends a retry frame. The peer
+// must suppress duplicates (see dedup.go), which every build with the
+// handshake does.
+func WithRetransmit(timeout time.Duration, attempts int) Option {
+	return func(o *options) {
+		if timeout <= 0 {
+			timeout = DefaultAckTimeout
+		}
+		if attempts < 0 {
+			attempts = DefaultRetransmits
+		}
+		o.ackTimeout, o.retransmits = timeout, attempts
+	}
+}
+
+// Retransmits counts requests resent because their accept did not arrive in
+// time.
+func (b *Broker) Retransmits() uint64 {
+	return b.retransmitted.Load()
+}
+
+// armAck starts the accept timer for request id, sent attempt times so far.
+func (b *Broker) armAck(id [16]byte, attempt int) {
+	if b.ackTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(b.ackTimeout, func() { b.ackExpired(id, attempt) })
+}
+
+func (b *Broker) ackExpired(id [16]byte, attempt int) {
+	if b.ctx.Err() != nil || !b.unconfirmedRequests.HasRequest(id) {
+		return // accepted, answered or given up on meanwhile
+	}
+	v, ok := b.waiters.Load(id)
+	if !ok {
+		b.unconfirmedRequests.Delete(id)
+		return
+	}
+	w := v.(*waiter)
+	if attempt >= b.retransmits {
+		b.logger.Warn("request never acknowledged; giving up", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("retransmits", attempt))
+		b.unconfirmedRequests.Delete(id)
+		if ch, ok := b.waiters.LoadAndDelete(id); ok && ch != nil {
+			ch <- reply{err: ErrNoAck}
+		}
+		return
+	}
+
+	req, ok := b.unconfirmedRequests.Load(id)
+	if !ok {
+		return
+	}
+	req.seal = b.session.Load() != nil
+	b.retransmitted.Add(1)
+	b.logger.Debug("no accept in time; resending request", slog.String("id", fmt.Sprintf("%x", id)), slog.Int("attempt", attempt+1))
+	if err := b.enqueue(w.ctx, req); err != nil {
+		b.logger.Debug("resend failed", slog.String("id", fmt.Sprintf("%x", id)), slog.Any("err", err))
+		return
+	}
+	b.armAck(id, attempt+1)
+}
@@ -0,0 +1,353 @@
+package broker
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FeatureNoise: request and response payloads travel in sealed frames once
+// the two ends have run a Noise XX handshake with their static keys.
+const FeatureNoise Feature = 1 << 0
+
+const (
+	// noise frame payload: step(1) message
+	noiseStepMsg1   = 0x01
+	noiseStepMsg2   = 0x02
+	noiseStepMsg3   = 0x03
+	noiseStepReject = 0x04 // responder refused our static key
+
+	// how long after start requests wait for the peer's hello before
+	// treating the peer as one that predates the handshake
+	noiseHelloWait = 2 * time.Second
+)
+
+var (
+	ErrInsecurePeer         = errors.New("peer does not support an encrypted channel")
+	ErrPeerRejectedIdentity = errors.New("peer rejected our static key")
+)
+
+// NoiseConfig enables the encrypted channel. The host is the initiator; the
+// gadget responds.
+type NoiseConfig struct {
+	Static    *ecdh.PrivateKey
+	Initiator bool
+	// VerifyPeer decides whether the peer's static public key is acceptable
+	// (pinning, allow lists). Nil accepts any key.
+	VerifyPeer func(static []byte) error
+	// Require refuses plaintext requests and responses, including with
+	// peers that predate the handshake. Set it whenever the peer is known
+	// (pinned, allow-listed): the hello that says a peer has no Noise is
+	// unauthenticated, so without Require anyone on the link can strip it.
+	Require bool
+}
+
+// WithNoise turns on the handshake and advertises FeatureNoise. A config
+// without a static key leaves the broker unencrypted.
+func WithNoise(cfg NoiseConfig) Option {
+	return func(o *options) {
+		if cfg.Static == nil {
+			return
+		}
+		o.handshake = true
+		o.features |= FeatureNoise
+		o.noise = &cfg
+	}
+}
+
+type noiseSession struct {
+	t          *noiseTransport
+	peerStatic []byte
+}
+
+// Fingerprint is the short form of a static key used in logs and prompts.
+func Fingerprint(static []byte) string {
+	sum := sha256.Sum256(static)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadOrCreateStaticKey reads a raw X25519 private key from path, creating
+// one (0600) when the file does not exist.
+func LoadOrCreateStaticKey(path string) (*ecdh.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		key, err := ecdh.X25519().NewPrivateKey(raw)
+		clear(raw)
+		if err != nil {
+			return nil, fmt.Errorf("static key %q: %w", path, err)
+		}
+		return key, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, key.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return key, nil
+}
+
+// PeerStatic returns the peer's authenticated static key once a secure
+// session is up.
+func (b *Broker) PeerStatic() ([]byte, bool) {
+	if s := b.session.Load(); s != nil {
+		return append([]byte(nil), s.peerStatic...), true
+	}
+	return nil, false
+}
+
+// noiseChangedLocked wakes everyone waiting on the session state. Callers hold
+// noiseMu.
+func (b *Broker) noiseChangedLocked() {
+	close(b.noiseChanged)
+	b.noiseChanged = make(chan struct{})
+}
+
+func (b *Broker) sendNoise(id [16]byte, step byte, msg []byte) {
+	if err := b.writeFrame(b.ctx, payloadTypeNoise, id, append([]byte{step}, msg...)); err != nil {
+		b.logger.Debug("tx noise failed", slog.Int("step", int(step)), slog.Any("err", err))
+	}
+}
+
+// startNoise (initiator) begins a fresh handshake, dropping any previous
+// session. Called when the peer's hello shows it speaks Noise.
+func (b *Broker) startNoise() {
+	hs, err := newNoiseHandshake(true, b.noise.Static)
+	if err != nil {
+		b.logger.Error("noise handshake init", slog.Any("err", err))
+		return
+	}
+	id := NewMessageID()
+
+	b.noiseMu.Lock()
+	b.noisePending, b.noisePendingID = hs, id
+	b.noiseErr = nil
+	b.session.Store(nil)
+	b.noiseChangedLocked()
+	b.noiseMu.Unlock()
+
+	b.logger.Debug("tx noise msg1")
+	b.sendNoise(id, noiseStepMsg1, hs.writeMessage1())
+}
+
+// handleNoise runs on the read loop so a session is in place before the
+// next sealed frame is opened.
+func (b *Broker) handleNoise(id [16]byte, payload []byte) {
+	if b.noise == nil || len(payload) == 0 {
+		b.logger.Debug("rx noise ignored")
+		return
+	}
+	step, msg := payload[0], payload[1:]
+
+	b.noiseMu.Lock()
+	defer b.noiseMu.Unlock()
+
+	switch {
+	case step == noiseStepMsg1 && !b.noise.Initiator:
+		hs, err := newNoiseHandshake(false, b.noise.Static)
+		if err == nil {
+			err = hs.readMessage1(msg)
+		}
+		var reply []byte
+		if err == nil {
+			reply, err = hs.writeMessage2()
+		}
+		if err != nil {
+			b.logger.Warn("noise msg1", slog.Any("err", err))
+			return
+		}
+		// a new msg1 means the initiator restarted
+		b.noisePending, b.noisePendingID = hs, id
+		b.session.Store(nil)
+		b.sendNoise(id, noiseStepMsg2, reply)
+
+	case step == noiseStepMsg2 && b.noise.Initiator && b.noisePending != nil && id == b.noisePendingID:
+		hs := b.noisePending
+		b.noisePending = nil
+		peer, err := hs.readMessage2(msg)
+		if err == nil && b.noise.VerifyPeer != nil {
+			err = b.noise.VerifyPeer(peer)
+		}
+		var final []byte
+		if err == nil {
+			final, err = hs.writeMessage3()
+		}
+		if err != nil {
+			b.logger.Warn("noise handshake failed", slog.Any("err", err))
+			b.noiseErr = err
+			b.noiseChangedLocked()
+			return
+		}
+		// queue msg3 before anything sealed can be
+		b.sendNoise(id, noiseStepMsg3, final)
+		b.session.Store(&noiseSession{t: hs.transport(), peerStatic: peer})
+		b.secured.Store(true)
+		b.noiseChangedLocked()
+		b.logger.Debug("noise session up", slog.String("peer", Fingerprint(peer)))
+
+	case step == noiseStepMsg3 && !b.noise.Initiator && b.noisePending != nil && id == b.noisePendingID:
+		hs := b.noisePending
+		b.noisePending = nil
+		peer, err := hs.readMessage3(msg)
+		if err == nil && b.noise.VerifyPeer != nil {
+			if err = b.noise.VerifyPeer(peer); err != nil {
+				b.sendNoise(id, noiseStepReject, nil)
+			}
+		}
+		if err != nil {
+			b.logger.Warn("noise handshake failed", slog.Any("err", err))
+			return
+		}
+		b.session.Store(&noiseSession{t: hs.transport(), peerStatic: peer})
+		b.secured.Store(true)
+		b.noiseChangedLocked()
+		b.logger.Info("noise session up", slog.String("peer", Fingerprint(peer)))
+
+	case step == noiseStepReject && b.noise.Initiator && id == b.noisePendingID:
+		b.noisePending = nil
+		b.session.Store(nil)
+		b.noiseErr = ErrPeerRejectedIdentity
+		b.noiseChangedLocked()
+		b.logger.Warn("noise handshake failed", slog.Any("err", ErrPeerRejectedIdentity))
+
+	default:
+		b.logger.Debug("rx noise out of sequence", slog.Int("step", int(step)))
+	}
+}
+
+// requireNoise reports whether plaintext is refused: by configuration, or
+// because a session has been up on this link, after which a peer that stops
+// speaking Noise is far more likely to be an attacker than a downgrade.
+func (b *Broker) requireNoise() bool {
+	return b.noise.Require || b.secured.Load()
+}
+
+// secureRequests reports whether the next request must be sealed, waiting
+// (within ctx) for the hello and the handshake as needed.
+func (b *Broker) secureRequests(ctx context.Context) (bool, error) {
+	if b.noise == nil {
+		return false, nil
+	}
+	if b.session.Load() != nil {
+		return true, nil
+	}
+
+	peer, ok := b.Peer()
+	if !ok {
+		// counted from broker start, so only the first requests to an older
+		// peer pay for the wait
+		wctx, cancel := context.WithDeadline(ctx, b.noiseSince.Add(noiseHelloWait))
+		peer, ok = b.waitHelloOrNil(wctx)
+		cancel()
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+	if !ok || peer.Features&FeatureNoise == 0 {
+		if b.requireNoise() {
+			return false, ErrInsecurePeer
+		}
+		return false, nil
+	}
+
+	for {
+		b.noiseMu.Lock()
+		s, err, changed := b.session.Load(), b.noiseErr, b.noiseChanged
+		b.noiseMu.Unlock()
+		switch {
+		case s != nil:
+			return true, nil
+		case err != nil:
+			return false, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-b.ctx.Done():
+			return false, io.EOF
+		}
+	}
+}
+
+func (b *Broker) waitHelloOrNil(ctx context.Context) (PeerInfo, bool) {
+	info, err := b.WaitHandshake(ctx)
+	return info, err == nil
+}
+
+// sealFrame runs on the writer loop: frames are sealed in the order they hit
+// the wire, which keeps the nonces increasing for the receiver.
+func (b *Broker) sealFrame(f outFrame) (outFrame, error) {
+	s := b.session.Load()
+	if s == nil {
+		return f, ErrInsecurePeer
+	}
+	plain := getFrameBuf(1 + len(f.payload))
+	defer plain.release()
+	plain.B[0] = byte(f.typ)
+	copy(plain.B[1:], f.payload)
+
+	out := getFrameBuf(sealedLen(len(plain.B)))
+	f.typ, f.payload = payloadTypeSealed, s.t.seal(out.B[:0], f.id[:], plain.B)
+	f.pooled = append(f.pooled, out)
+	return f, nil
+}
+
+// openFrame runs on the read loop and returns the inner type and payload,
+// which live in the returned buffer.
+func (b *Broker) openFrame(id [16]byte, sealed []byte) (payloadType, []byte, *frameBuf, error) {
+	s := b.session.Load()
+	if s == nil {
+		return payloadTypeUnknown, nil, nil, ErrNoiseDecrypt
+	}
+	fb := getFrameBuf(len(sealed))
+	plain, err := s.t.open(fb.B[:0], id[:], sealed)
+	if err != nil {
+		fb.release()
+		return payloadTypeUnknown, nil, nil, err
+	}
+	if len(plain) == 0 {
+		fb.release()
+		return payloadTypeUnknown, nil, nil, ErrInvalidPayload
+	}
+	switch pt := payloadType(plain[0]); pt {
+	case payloadTypeRequest, payloadTypeResponse, payloadTypeCompressedRequest, payloadTypeCompressedResponse, payloadTypeFragment, payloadTypeDeadline, payloadTypeSequenced, payloadTypeResponsePart:
+		return pt, plain[1:], fb, nil
+	default:
+		fb.release()
+		return payloadTypeUnknown, nil, nil, ErrInvalidPayload
+	}
+}
+
+// acceptPlaintext reports whether an unsealed request or response may be
+// handled while Noise is configured. It never is on a link that requires
+// Noise or has a session: the frame is dropped and the session stays, so a
+// plaintext frame cannot talk an end out of its authenticated peer.
+func (b *Broker) acceptPlaintext() bool {
+	if b.noise == nil {
+		return true
+	}
+	return !b.requireNoise() && b.session.Load() == nil
+}
@@ -0,0 +1,131 @@
+package broker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+)
+
+// FeatureSequence: data frames carry a per-broker sequence number, and
+// frames marked ordered reach the handler (or the waiting Request) in the
+// order they were sent.
+const FeatureSequence Feature = 1 << 7
+
+const (
+	// sequenced payload: seq(8) flags(1) inner type(1) inner payload
+	sequenceHeaderLen = 8 + 1 + 1
+
+	sequenceFlagOrdered = 0x01
+
+	// ordered frames waiting for the delivery goroutine; the read loop
+	// blocks once this many are queued
+	orderedQueueLen = 64
+)
+
+// WithSequencing turns on the handshake and advertises FeatureSequence.
+func WithSequencing() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureSequence
+	}
+}
+
+type orderedKey struct{}
+
+// WithOrdered marks requests made with ctx as ordered: the peer handles them
+// one at a time in the order they were sent, and sends their responses
+// ordered too. Unordered requests keep running concurrently beside them.
+// Without FeatureSequence on both ends the mark has no effect.
+func WithOrdered(ctx context.Context) context.Context {
+	return context.WithValue(ctx, orderedKey{}, true)
+}
+
+func orderedFrom(ctx context.Context) bool {
+	ordered, _ := ctx.Value(orderedKey{}).(bool)
+	return ordered
+}
+
+// SequenceGaps counts sequence numbers that never arrived, i.e. data frames
+// the link lost or the stash dropped as corrupt.
+func (b *Broker) SequenceGaps() uint64 {
+	return b.seqGaps.Load()
+}
+
+func isSequencedType(pt payloadType) bool {
+	switch pt {
+	case payloadTypeRequest, payloadTypeResponse, payloadTypeCompressedRequest,
+		payloadTypeCompressedResponse, payloadTypeFragment, payloadTypeDeadline, payloadTypeResponsePart:
+		return true
+	}
+	return false
+}
+
+// sequenceFrame numbers a data frame. It runs on the writer loop, just before
+// sealing, so the numbers follow the order frames hit the wire regardless of
+// write lane.
+func (b *Broker) sequenceFrame(f outFrame) outFrame {
+	if !isSequencedType(f.typ) || !b.negotiated(FeatureSequence) {
+		return f
+	}
+	fb := getFrameBuf(sequenceHeaderLen + len(f.payload))
+	f.pooled = append(f.pooled, fb)
+	p := fb.B
+	binary.LittleEndian.PutUint64(p[0:8], b.txSeq)
+	if f.ordered {
+		p[8] = sequenceFlagOrdered
+	}
+	p[9] = byte(f.typ)
+	copy(p[sequenceHeaderLen:], f.payload)
+	b.txSeq++
+	f.typ, f.payload = payloadTypeSequenced, p
+	return f
+}
+
+// openSequence undoes sequenceFrame and tracks the peer's numbering. Runs on
+// the read loop only.
+func (b *Broker) openSequence(id [16]byte, payload []byte) (payloadType, []byte, bool, error) {
+	if len(payload) < sequenceHeaderLen {
+		return payloadTypeUnknown, nil, false, ErrInvalidPayload
+	}
+	seq := binary.LittleEndian.Uint64(payload[0:8])
+	ordered := payload[8]&sequenceFlagOrdered != 0
+	pt := payloadType(payload[9])
+	if !isSequencedType(pt) {
+		return payloadTypeUnknown, nil, false, ErrInvalidPayload
+	}
+
+	switch {
+	case !b.rxSeqSeen || seq == 0:
+		// the first frame, or the peer restarted its numbering
+	case seq > b.rxSeq:
+		gap := seq - b.rxSeq
+		b.seqGaps.Add(gap)
+		b.logger.Warn("sequence gap", slog.String("id", fmt.Sprintf("%x", id)), slog.Uint64("missing", gap))
+	case seq < b.rxSeq:
+		b.logger.Warn("sequence went backwards", slog.String("id", fmt.Sprintf("%x", id)), slog.Uint64("seq", seq), slog.Uint64("want", b.rxSeq))
+	}
+	b.rxSeq, b.rxSeqSeen = seq+1, true
+	return pt, payload[sequenceHeaderLen:], ordered, nil
+}
+
+// orderedLoop runs ordered frames one after another in arrival order, which
+// the sequence numbers make the send order.
+func (b *Broker) orderedLoop() {
+	for {
+		select {
+		case fn := <-b.orderedQueue:
+			fn()
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverOrdered queues fn behind the ordered frames before it.
+func (b *Broker) deliverOrdered(fn func()) {
+	select {
+	case b.orderedQueue <- fn:
+	case <-b.ctx.Done():
+	}
+}
@@ -2,15 +2,27 @@ package broker
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// The stash is filled and read by the read loop; mu is for the reaper, which
+// drops what is left of a frame that stopped arriving.
 type stash struct {
+	mu       sync.Mutex
 	buf      bytes.Buffer
 	capacity int
 	logger   *slog.Logger
+	// lastWrite is when bytes last arrived
+	lastWrite time.Time
+
+	// frames dropped on a checksum mismatch
+	corrupt atomic.Uint64
 }
 
 func newStash(size int, logger *slog.Logger) *stash {
@@ -21,10 +33,15 @@ func newStash(size int, logger *slog.Logger) *stash {
 }
 
 func (s *stash) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.buf.Len()
 }
 
 func (s *stash) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWrite = time.Now()
 	dataLen := len(data)
 
 	if s.buf.Len()+dataLen > s.capacity {
@@ -36,30 +53,71 @@ func (s *stash) Write(data []byte) (int, error) {
 	return s.buf.Write(data)
 }
 
+// shrinkCap is the stash capacity kept across an empty stash; a larger
+// backing array (left by a burst of big frames) is let go.
+const shrinkCap = 4 * DEFAULT_READ_BUFFER
+
+// shrink drops an oversized backing array once everything in it has been
+// read, so a past burst does not pin memory until the next GC cycle nudge.
+func (s *stash) shrink() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf.Len() == 0 && s.buf.Cap() > shrinkCap {
+		s.buf = bytes.Buffer{}
+	}
+}
+
+// reap wipes and drops bytes that have waited since before cutoff for the
+// rest of their frame, returning how many there were.
+func (s *stash) reap(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.buf.Len()
+	if n == 0 || !s.lastWrite.Before(cutoff) {
+		return 0
+	}
+	clear(s.buf.Bytes()) // a partial frame can still hold key material
+	s.buf = bytes.Buffer{}
+	return n
+}
+
 func (s *stash) ReadPayload() ([16]byte, payloadType, []byte, error) {
-	var id [16]byte
+	h, fb, err := s.ReadFrame()
+	if err != nil {
+		return h.ID, h.Type, nil, err
+	}
+	return h.ID, h.Type, fb.B, nil // not released: the caller keeps the bytes
+}
+
+// ReadFrame is ReadPayload returning the whole header, with the payload in a
+// pooled buffer the caller releases.
+func (s *stash) ReadFrame() (Header, *frameBuf, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var id Header
 	data := s.buf.Bytes()
 
-	idx := bytes.IndexByte(data, MagicByte)
+	idx := bytes.IndexAny(data, string([]byte{MagicByte, MagicByteChecksum}))
 	if idx < 0 {
 		// no magic at all: drop everything except a small tail to avoid growth
-		if drop := s.buf.Len() - (HeaderLen - 1); drop > 0 {
+		if drop := s.buf.Len() - (HeaderLenChecksum - 1); drop > 0 {
 			s.buf.Next(drop)
 		}
-		return id, payloadTypeUnknown, nil, ErrNoPayloadFound
+		return id, nil, ErrNoPayloadFound
 	}
 
 	s.buf.Next(idx) // drop bytes in front of magic
 	data = s.buf.Bytes()
 
 	h, err := DecodeHeader(data)
+	if err == ErrIncompleteHeader {
+		// a read ended inside the header; wait for the rest
+		return id, nil, err
+	}
 	if err != nil {
 		s.logger.Debug("bad header decode; resync")
-		if err != ErrIncompleteHeader {
-			// skip magic byte only if header is definitely bad
-			s.buf.Next(1)
-		}
-		return id, payloadTypeUnknown, nil, errors.Join(ErrInvalidPayload, err)
+		s.buf.Next(1) // skip the magic byte of a header that is definitely bad
+		return id, nil, errors.Join(ErrInvalidPayload, err)
 	}
 
 	if int(h.Size) > MAX_MESSAGE_PAYLOAD {
@@ -69,18 +127,29 @@ func (s *stash) ReadPayload() ([16]byte, payloadType, []byte, error) {
 		// because someone could send a lot of garbage with valid headers
 		// and we would end up dropping a lot of valid messages.
 		// Instead, we just drop the header and try to resync.
-		s.buf.Next(HeaderLen)
-		return id, payloadTypeUnknown, nil, ErrInvalidPayloadSize
+		s.buf.Next(h.Len())
+		return id, nil, ErrInvalidPayloadSize
 	}
 
-	total := int(HeaderLen + h.Size)
+	hl := h.Len()
+	total := hl + int(h.Size)
 	if len(data) < total {
 		// wait for full payload
-		return id, payloadTypeUnknown, nil, ErrIncompletePayload
+		return id, nil, ErrIncompletePayload
+	}
+	if h.Magic == MagicByteChecksum && frameChecksum(data[:22], data[hl:total]) != h.CRC {
+		// the size may be the damaged part, so only step past the magic
+		// and let resync find the next frame
+		s.corrupt.Add(1)
+		s.logger.Warn("drop corrupted frame", slog.String("type", fmt.Sprintf("%02x", h.Type)), slog.String("id", fmt.Sprintf("%x", h.ID)), slog.Int("size", int(h.Size)))
+		s.buf.Next(1)
+		return id, nil, ErrChecksumMismatch
 	}
 
-	s.logger.Debug("rx hdr", slog.String("type", fmt.Sprintf("%02x", h.Type)), slog.String("id", fmt.Sprintf("%x", h.ID)), slog.Int("size", int(h.Size)))
-	s.buf.Next(HeaderLen) // consume header
+	if s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		s.logger.Debug("rx hdr", slog.String("type", fmt.Sprintf("%02x", h.Type)), slog.String("id", fmt.Sprintf("%x", h.ID)), slog.Int("size", int(h.Size)))
+	}
+	s.buf.Next(hl) // consume header
 
 	// we do not need io.ReadFull here, because we already verified that we have full payload
 	payloadBuffer := s.buf.Next(int(h.Size))
@@ -89,10 +158,8 @@ func (s *stash) ReadPayload() ([16]byte, payloadType, []byte, error) {
 	// who is responsible for clearing it after use
 	defer clear(payloadBuffer)
 
-	// we don't use bytes.Clone, because it can create a larger than needed slice
-	// and we know exact size here
-	result := make([]byte, h.Size)
-	copy(result, payloadBuffer)
+	result := getFrameBuf(int(h.Size))
+	copy(result.B, payloadBuffer)
 
-	return h.ID, h.Type, result, nil
+	return h, result, nil
 }
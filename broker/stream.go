@@ -0,0 +1,221 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// FeatureStreaming: a handler may answer one request with any number of
+// response part frames before its final response, which ends the stream.
+const FeatureStreaming Feature = 1 << 9
+
+// parts a caller has not read yet are capped at this many bytes; past that
+// the stream fails rather than growing without bound
+const maxStreamBuffered = 16 * MB
+
+var (
+	ErrStreamingUnsupported = errors.New("peer does not support streamed responses")
+	ErrStreamOverflow       = errors.New("stream reader fell too far behind")
+)
+
+// WithStreaming turns on the handshake and advertises FeatureStreaming.
+func WithStreaming() Option {
+	return func(o *options) {
+		o.handshake = true
+		o.features |= FeatureStreaming
+	}
+}
+
+type partSenderKey struct{}
+
+// partSender is what the handler's context carries to send response parts
+// for the request it is answering.
+type partSender struct {
+	b       *Broker
+	id      [16]byte
+	sealed  bool
+	prio    Priority
+	ordered bool
+	sent    atomic.Bool // the handler streamed; its final response alone is not the answer
+}
+
+// SendPart sends part as the next chunk of the response to the request ctx
+// belongs to. The handler's own return value follows as the last chunk.
+// It fails with ErrStreamingUnsupported outside a handler or when the peer
+// cannot take parts, in which case the handler answers in one piece.
+func SendPart(ctx context.Context, part []byte) error {
+	ps, _ := ctx.Value(partSenderKey{}).(*partSender)
+	if ps == nil || !ps.b.negotiated(FeatureStreaming) {
+		return ErrStreamingUnsupported
+	}
+	ps.sent.Store(true)
+	return ps.b.enqueue(ctx, outFrame{typ: payloadTypeResponsePart, id: ps.id, payload: part, seal: ps.sealed, prio: ps.prio, ordered: ps.ordered})
+}
+
+// ResponseStream reads a streamed response part by part; see RequestStream.
+type ResponseStream struct {
+	ID [16]byte
+
+	b    *Broker
+	ctx  context.Context
+	done chan reply // the final response, or the error ending the stream
+
+	settled sync.Once // the call is off the broker's drain count
+
+	mu       sync.Mutex
+	queue    [][]byte
+	buffered int
+	err      error
+	ready    chan struct{}
+}
+
+// RequestStream sends payload like Request but returns the response as it
+// arrives. Against a peer that answers in one piece the stream has a single
+// chunk.
+func (b *Broker) RequestStream(ctx context.Context, payload []byte) (*ResponseStream, error) {
+	s := &ResponseStream{b: b, ctx: ctx, ready: make(chan struct{}, 1)}
+	id, ch, err := b.send(ctx, payload, s)
+	if err != nil {
+		return nil, err
+	}
+	s.ID, s.done = id, ch
+	return s, nil
+}
+
+// Next returns the next chunk, io.EOF after the last one, or the error that
+// ended the stream.
+func (s *ResponseStream) Next() ([]byte, error) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			part := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.buffered -= len(part)
+			s.mu.Unlock()
+			return part, nil
+		}
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-s.ready:
+		case r := <-s.done:
+			// every part was read before the frame that ended the stream
+			s.finish(r)
+		case <-s.ctx.Done():
+			s.Close()
+			s.fail(s.ctx.Err())
+		case <-s.b.dead:
+			s.Close()
+			s.fail(ErrPeerDead)
+		case <-s.b.ctx.Done():
+			s.Close()
+			s.fail(io.EOF)
+		}
+	}
+}
+
+// Close stops listening for the rest of the stream. The peer's handler is
+// not interrupted; parts still arriving are dropped.
+func (s *ResponseStream) Close() {
+	s.b.forgetRequest(s.ID)
+	s.settle()
+}
+
+func (s *ResponseStream) settle() {
+	s.settled.Do(func() { s.b.calls.Add(-1) })
+}
+
+func (s *ResponseStream) finish(r reply) {
+	s.b.streams.Delete(s.ID)
+	s.settle()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.err != nil {
+		s.err = r.err
+		return
+	}
+	if len(r.payload) > 0 {
+		s.queue = append(s.queue, r.payload)
+	}
+	s.err = io.EOF
+}
+
+func (s *ResponseStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *ResponseStream) push(part []byte) {
+	s.mu.Lock()
+	switch {
+	case s.err != nil:
+	case s.buffered+len(part) > maxStreamBuffered:
+		s.queue, s.buffered, s.err = nil, 0, ErrStreamOverflow
+	default:
+		s.queue = append(s.queue, part)
+		s.buffered += len(part)
+	}
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// handlePart runs on the read loop, so parts reach the stream in the order
+// they were sent, all of them ahead of the final response. A plain Request
+// gets them joined in front of that response.
+func (b *Broker) handlePart(id [16]byte, part []byte) {
+	if v, ok := b.streams.Load(id); ok {
+		v.(*ResponseStream).push(part)
+		return
+	}
+	if _, ok := b.waiters.Load(id); !ok {
+		b.logger.Debug("response part for no one; dropping", slog.String("id", fmt.Sprintf("%x", id)))
+		return
+	}
+	b.partsMu.Lock()
+	defer b.partsMu.Unlock()
+	joined := append(b.parts[id], part...)
+	if len(joined) > MAX_REASSEMBLED_PAYLOAD {
+		delete(b.parts, id)
+		b.logger.Warn("streamed response too large; dropping", slog.String("id", fmt.Sprintf("%x", id)))
+		if ch, ok := b.waiters.LoadAndDelete(id); ok && ch != nil {
+			ch <- reply{err: ErrInvalidPayloadSize}
+		}
+		return
+	}
+	b.parts[id] = joined
+}
+
+func (b *Broker) dropParts(id [16]byte) {
+	b.partsMu.Lock()
+	delete(b.parts, id)
+	b.partsMu.Unlock()
+}
+
+// takeParts returns the parts joined so far for a plain Request, with the
+// final response appended.
+func (b *Broker) takeParts(id [16]byte, final []byte) []byte {
+	b.partsMu.Lock()
+	defer b.partsMu.Unlock()
+	joined, ok := b.parts[id]
+	if !ok {
+		return final
+	}
+	delete(b.parts, id)
+	return append(joined, final...)
+}
@@ -1,19 +1,34 @@
 package broker
 
 import (
+	"context"
 	"maps"
 	"sync"
 )
 
+// reply is what a waiting Request gets: the response, or the error frame
+// the peer answered with (see errframe.go).
+type reply struct {
+	payload []byte
+	err     error
+}
+
+// waiter is a Request waiting on its reply; ctx is the caller's, which the
+// reaper checks for callers that went away (see reap.go).
+type waiter struct {
+	ch  chan reply
+	ctx context.Context
+}
+
 // waiterMap is a tiny typed wrapper for sync.Map keyed by [16]byte.
 type waiterMap struct {
 	sync.Map
 }
 
-func (wm *waiterMap) NewWaiter() ([16]byte, chan []byte) {
+func (wm *waiterMap) NewWaiter(ctx context.Context) ([16]byte, chan reply) {
 	id := NewMessageID()
-	ch := make(chan []byte, 1)
-	wm.Map.Store(id, ch)
+	ch := make(chan reply, 1)
+	wm.Map.Store(id, &waiter{ch: ch, ctx: ctx})
 	return id, ch
 }
 
@@ -21,13 +36,13 @@ func (wm *waiterMap) Delete(id [16]byte) {
 	wm.Map.Delete(id)
 }
 
-func (wm *waiterMap) LoadAndDelete(id [16]byte) (chan []byte, bool) {
+func (wm *waiterMap) LoadAndDelete(id [16]byte) (chan reply, bool) {
 	v, ok := wm.Map.LoadAndDelete(id)
 	if !ok {
 		return nil, false
 	}
 
-	return v.(chan []byte), true
+	return v.(*waiter).ch, true
 }
 
 type requestMap[T any] struct {
@@ -50,6 +65,13 @@ func (rm *requestMap[T]) Store(id [16]byte, payload T) {
 	rm.store[id] = payload
 }
 
+func (rm *requestMap[T]) Load(id [16]byte) (T, bool) {
+	rm.mtx.RLock()
+	defer rm.mtx.RUnlock()
+	v, ok := rm.store[id]
+	return v, ok
+}
+
 func (rm *requestMap[T]) HasRequest(id [16]byte) bool {
 	rm.mtx.RLock()
 	defer rm.mtx.RUnlock()
@@ -6,20 +6,29 @@ import (
 )
 
 type Header struct {
-	Magic byte // must be 0x56
+	Magic byte // MagicByte or MagicByteChecksum
 	Type  payloadType
 	ID    [16]byte
 	Size  uint32
+	CRC   uint32 // checksummed frames only
 	// Parity is not stored here (it’s derived on encode/decode)
 }
 
-// parity over bytes[0:22] (magic,type,id,size), XOR of all
-func headerParity(bytes22 []byte) (byte, error) {
-	if len(bytes22) != HeaderLen-1 { // 22
+// Len is the encoded header length.
+func (h Header) Len() int {
+	if h.Magic == MagicByteChecksum {
+		return HeaderLenChecksum
+	}
+	return HeaderLen
+}
+
+// parity over everything before the parity byte (magic,type,id,size[,crc]), XOR of all
+func headerParity(fields []byte) (byte, error) {
+	if len(fields) != HeaderLen-1 && len(fields) != HeaderLenChecksum-1 { // 22 or 26
 		return 0, ErrInvalidHeaderParity
 	}
 	var x byte
-	for _, b := range bytes22 {
+	for _, b := range fields {
 		x ^= b
 	}
 
@@ -28,23 +37,29 @@ func headerParity(bytes22 []byte) (byte, error) {
 
 // DecodeHeader validates magic & parity and returns the parsed header.
 func DecodeHeader(src []byte) (Header, error) {
-	if len(src) < HeaderLen {
+	if len(src) < 1 {
 		return Header{}, ErrIncompleteHeader
 	}
-	if src[0] != MagicByte {
+	h := Header{Magic: src[0]}
+	if h.Magic != MagicByte && h.Magic != MagicByteChecksum {
 		return Header{}, ErrInvalidHeaderBadMagic
 	}
-	// verify parity over [0..21]
-	p, _ := headerParity(src[:22])
-	if src[22] != p {
+	hl := h.Len()
+	if len(src) < hl {
+		return Header{}, ErrIncompleteHeader
+	}
+	// verify parity over [0..hl-2]
+	p, _ := headerParity(src[:hl-1])
+	if src[hl-1] != p {
 		return Header{}, ErrInvalidHeaderBadMagic
 	}
 
-	var h Header
-	h.Magic = src[0]
 	h.Type = payloadType(src[1])
 	copy(h.ID[:], src[2:18])
 	h.Size = binary.LittleEndian.Uint32(src[18:22])
+	if h.Magic == MagicByteChecksum {
+		h.CRC = binary.LittleEndian.Uint32(src[22:26])
+	}
 
 	return h, nil
 }
@@ -57,19 +72,27 @@ func NewMessageID() [16]byte {
 }
 
 func newMessage(msgType payloadType, id [16]byte, payload []byte) ([]byte, error) {
-	payloadLen := len(payload)
-	requiredSize := HeaderLen + payloadLen
-
-	dst := make([]byte, requiredSize)
-	if cap(dst) < requiredSize {
-		return nil, ErrEncodeHeaderDestTooSmall
+	if len(payload) > int(^uint32(0)) {
+		return nil, ErrEncodeHeaderPayloadLarge
+	}
+	dst := make([]byte, HeaderLen+len(payload))
+	if err := encodeMessage(dst, msgType, id, payload); err != nil {
+		return nil, err
 	}
+	return dst, nil
+}
 
-	if payloadLen < 0 || payloadLen > int(^uint32(0)) {
-		return nil, ErrEncodeHeaderPayloadLarge
+// encodeMessage writes the frame into dst, which must be exactly
+// HeaderLen+len(payload) bytes.
+func encodeMessage(dst []byte, msgType payloadType, id [16]byte, payload []byte) error {
+	payloadLen := len(payload)
+	if len(dst) != HeaderLen+payloadLen {
+		return ErrEncodeHeaderDestTooSmall
+	}
+	if payloadLen > int(^uint32(0)) {
+		return ErrEncodeHeaderPayloadLarge
 	}
 
-	// reslice to requiredSize
 	dst[0] = MagicByte
 	dst[1] = byte(msgType)
 	copy(dst[2:18], id[:])
@@ -79,5 +102,5 @@ func newMessage(msgType payloadType, id [16]byte, payload []byte) ([]byte, error
 	dst[22] = p
 	copy(dst[HeaderLen:], payload)
 
-	return dst, nil
+	return nil
 }
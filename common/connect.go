@@ -10,6 +10,7 @@ import (
 
 	"github.com/google/gousb"
 	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/logging"
 )
 
 type DeviceInfo struct {
@@ -28,7 +29,15 @@ type ConnectParams struct {
 	BrokerHandler broker.Handler
 	// Optional write-idle keep-alive interval. Zero disables keep-alive.
 	KeepAlive time.Duration
-	Channel   Channel
+	// Optional ping interval and the number of silent intervals after which
+	// the broker declares the gadget dead. Zero disables heartbeats.
+	Heartbeat       time.Duration
+	HeartbeatMisses int
+	Channel         Channel
+	// Optional: the transport to the gadget and, for the socket transports,
+	// its address for this channel (see transport.go). Empty means USB.
+	Transport string
+	Address   string
 }
 
 type Channel int
@@ -38,6 +47,9 @@ const (
 	ChanMgmt                // IF1: management
 )
 
+// queued frames and in-flight requests get this long when a session closes
+const brokerDrainTimeout = 2 * time.Second
+
 // Session owns the whole USB + broker stack and knows how to clean up.
 type Session struct {
 	Ctx *gousb.Context
@@ -51,13 +63,24 @@ type Session struct {
 	Channel   Channel
 	KeepAlive time.Duration
 
+	Heartbeat       time.Duration
+	HeartbeatMisses int
+
+	// set for the socket transports, where the USB fields stay nil
+	Transport string
+	Address   string
+	stream    *Stream
+
 	Serial string
 	Log    *slog.Logger
 }
 
 // Close in reverse order of creation
 func (s *Session) Close() {
-	s.Broker.Stop() // this blocks until broker is fully stopped
+	s.Broker.Stop() // this blocks until broker is drained and fully stopped
+	if s.stream != nil {
+		_ = s.stream.Close()
+	}
 	if s.Intf != nil {
 		s.Intf.Close()
 	}
@@ -165,6 +188,24 @@ func ResetDevice(serial string, l *slog.Logger) error {
 	return nil
 }
 
+// Ready probes the gadget behind the session: the vendor request on USB, the
+// state of the stream otherwise.
+func (s *Session) Ready() (bool, error) {
+	if s.Dev == nil {
+		select {
+		case <-s.Broker.Done():
+			return false, ErrStreamClosed
+		default:
+			return true, nil
+		}
+	}
+	idx := uint16(0)
+	if s.Intf != nil {
+		idx = uint16(s.Intf.Setting.Number)
+	}
+	return VendorReadyInInterface(s.Dev, VendorReqReady, idx, s.Log)
+}
+
 // VendorReadyInInterface: IN | vendor | interface = 0x81; pass wIndex = interface number
 func VendorReadyInInterface(d *gousb.Device, bRequest byte, iface uint16, l *slog.Logger) (bool, error) {
 	n, buf, err := ctrlIn(l, d, bmReqTypeVendorIn, bRequest, 0, iface, 8)
@@ -194,6 +235,10 @@ func Connect(p ConnectParams) (*Session, error) {
 	if p.Channel != ChanSign && p.Channel != ChanMgmt {
 		return nil, ErrInvalidChannel
 	}
+	p.Logger = l
+	if p.Transport != "" && p.Transport != TransportUSB {
+		return connectStream(p)
+	}
 
 	ctx := gousb.NewContext()
 
@@ -374,15 +419,7 @@ func Connect(p ConnectParams) (*Session, error) {
 		return nil, err
 	}
 
-	// Build broker
-	brokerOpts := []broker.Option{
-		broker.WithLogger(l.With("component", "broker", "chan", map[Channel]string{ChanSign: "sign", ChanMgmt: "mgmt"}[p.Channel])),
-		broker.WithHandler(p.BrokerHandler),
-	}
-	if p.KeepAlive > 0 {
-		brokerOpts = append(brokerOpts, broker.WithKeepAlive(p.KeepAlive))
-	}
-	br := broker.New(inEp, newLibusbWriter(outEp), brokerOpts...)
+	br := newHostBroker(p, chosenSerial, inEp, newLibusbWriter(outEp))
 
 	l.Debug("using device", slog.String("serial", chosenSerial))
 
@@ -398,11 +435,71 @@ func Connect(p ConnectParams) (*Session, error) {
 		Channel:   p.Channel,
 		KeepAlive: p.KeepAlive,
 
+		Heartbeat:       p.Heartbeat,
+		HeartbeatMisses: p.HeartbeatMisses,
+
 		Serial: chosenSerial,
 		Log:    l,
 	}, nil
 }
 
+// newHostBroker starts the host broker over an open transport; serial names
+// the gadget for key pinning.
+func newHostBroker(p ConnectParams, serial string, r broker.ReadContexter, w broker.WriteContexter) *broker.Broker {
+	l := p.Logger
+	brokerOpts := []broker.Option{
+		broker.WithLogger(logging.Named(l, logging.ModuleBroker).With("chan", map[Channel]string{ChanSign: "sign", ChanMgmt: "mgmt"}[p.Channel])),
+		broker.WithHandler(p.BrokerHandler),
+		broker.WithHandshake(),
+		broker.WithCompression(),
+		broker.WithFragmentation(),
+		broker.WithChecksums(),
+		broker.WithFlowControl(),
+		broker.WithDeadlines(),
+		broker.WithSequencing(),
+		broker.WithErrorFrames(),
+		broker.WithStreaming(),
+		broker.WithDrainTimeout(brokerDrainTimeout),
+		broker.WithRetransmit(broker.DefaultAckTimeout, broker.DefaultRetransmits),
+	}
+	if p.KeepAlive > 0 {
+		brokerOpts = append(brokerOpts, broker.WithKeepAlive(p.KeepAlive))
+	}
+	if p.Heartbeat > 0 {
+		brokerOpts = append(brokerOpts, broker.WithHeartbeat(p.Heartbeat, p.HeartbeatMisses))
+	}
+	if noise, err := hostNoise(serial, l); err != nil {
+		l.Warn("host static key unavailable; channel stays unencrypted", slog.Any("err", err))
+	} else {
+		brokerOpts = append(brokerOpts, broker.WithNoise(noise))
+	}
+	br := broker.New(r, w, brokerOpts...)
+	go logPeerHandshake(br, l)
+	return br
+}
+
+// logPeerHandshake notes which broker protocol the gadget speaks. Gadgets
+// from before the handshake never answer; they keep working as version 0.
+func logPeerHandshake(br *broker.Broker, l *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	peer, err := br.WaitHandshake(ctx)
+	if err != nil {
+		l.Debug("no broker hello from gadget; assuming protocol 0", slog.Any("err", err))
+		return
+	}
+	l.Debug("broker handshake",
+		slog.Int("peer_version", int(peer.Version)),
+		slog.Int("peer_max_payload", int(peer.MaxPayload)),
+		slog.Bool("encrypted", peer.Features&broker.FeatureNoise != 0),
+		slog.Bool("compressed", peer.Features&broker.FeatureCompression != 0),
+		slog.Bool("fragmented", peer.Features&broker.FeatureFragmentation != 0),
+		slog.Bool("checksummed", peer.Features&broker.FeatureChecksum != 0),
+		slog.Int("peer_window", int(peer.Window)),
+	)
+}
+
 func interfaceClaimError(ifaceNum int, err error) error {
 	switch ifaceNum {
 	case 0:
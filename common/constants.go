@@ -8,8 +8,13 @@ const (
 	bmReqTypeVendorIn = 0x81
 
 	// error codes from rpc
-	RpcKeyNotFound    uint32 = 31
-	RpcKeyLocked      uint32 = 32
-	RpcStaleWatermark uint32 = 33
-	RpcBadPayload     uint32 = 34
+	RpcKeyNotFound     uint32 = 31
+	RpcKeyLocked       uint32 = 32
+	RpcStaleWatermark  uint32 = 33
+	RpcBadPayload      uint32 = 34
+	RpcOutsideValidity uint32 = 35
+	RpcPolicyRefused   uint32 = 36
+	RpcRateLimited     uint32 = 37
+	RpcSelfCheckFailed uint32 = 38
+	RpcSelfTestFailed  uint32 = 39
 )
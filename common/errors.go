@@ -14,4 +14,7 @@ var (
 	ErrInterfaceClaimFailed = errors.New("claim interface failed")
 	ErrSignInterfaceBusy    = errors.New("Unable to connect to sign interface of the device, device is busy")
 	ErrMgmtInterfaceBusy    = errors.New("Unable to connect to management interface of the device, device is busy")
+	ErrUnknownTransport     = errors.New("unknown transport")
+	ErrNoAddress            = errors.New("no address given")
+	ErrStreamClosed         = errors.New("transport stream closed")
 )
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+// EnvRequireEncryption=1 refuses gadgets that predate the encrypted channel
+// instead of falling back to plaintext. A gadget whose key is pinned is
+// always refused in plaintext.
+const EnvRequireEncryption = "TEZSIGN_REQUIRE_ENCRYPTION"
+
+const (
+	hostKeyFile      = "host.key"
+	knownGadgetsFile = "known_gadgets"
+)
+
+var ErrGadgetKeyChanged = errors.New("gadget static key does not match the pinned one")
+
+// knownGadgetsMu serializes pinning between the sign and management
+// sessions of one process.
+var knownGadgetsMu sync.Mutex
+
+// hostConfigDir holds the host's static key and the pinned gadget keys.
+func hostConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tezsign"), nil
+}
+
+// hostNoise builds the initiator config for the gadget with this serial. The
+// gadget's key is pinned on first use (trust on first use) in known_gadgets;
+// a different key later fails the handshake. Once pinned, the gadget has
+// spoken Noise, so a hello saying it does not is refused rather than trusted.
+func hostNoise(serial string, l *slog.Logger) (broker.NoiseConfig, error) {
+	dir, err := hostConfigDir()
+	if err != nil {
+		return broker.NoiseConfig{}, err
+	}
+	pins := filepath.Join(dir, knownGadgetsFile)
+	knownGadgetsMu.Lock()
+	_, pinned, err := pinnedGadgetKey(pins, serial)
+	knownGadgetsMu.Unlock()
+	if err != nil {
+		// an unreadable pin file must not turn into a plaintext channel
+		l.Warn("cannot read pinned gadget keys", slog.Any("err", err))
+		pinned = true
+	}
+	require := pinned || strings.TrimSpace(os.Getenv(EnvRequireEncryption)) == "1"
+
+	key, err := broker.LoadOrCreateStaticKey(filepath.Join(dir, hostKeyFile))
+	if err != nil {
+		if !require {
+			return broker.NoiseConfig{}, err
+		}
+		// still encrypt to the gadget and check its pin; a gadget with an
+		// allow list refuses the one-off key
+		l.Warn("host static key unavailable; using a one-off key", slog.Any("err", err))
+		if key, err = ecdh.X25519().GenerateKey(rand.Reader); err != nil {
+			return broker.NoiseConfig{}, err
+		}
+	}
+
+	return broker.NoiseConfig{
+		Static:    key,
+		Initiator: true,
+		Require:   require,
+		VerifyPeer: func(static []byte) error {
+			return pinGadgetKey(pins, serial, static, l)
+		},
+	}, nil
+}
+
+// pinnedGadgetKey returns the hex key pinned for serial in path, if any.
+// Callers hold knownGadgetsMu.
+func pinnedGadgetKey(path, serial string) (string, bool, error) {
+	if serial == "" {
+		serial = "-"
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[0] == serial {
+			return fields[1], true, nil
+		}
+	}
+	return "", false, sc.Err()
+}
+
+func pinGadgetKey(path, serial string, static []byte, l *slog.Logger) error {
+	knownGadgetsMu.Lock()
+	defer knownGadgetsMu.Unlock()
+
+	if serial == "" {
+		serial = "-"
+	}
+	want := hex.EncodeToString(static)
+	pinned, ok, err := pinnedGadgetKey(path, serial)
+	switch {
+	case err != nil:
+		return err
+	case ok && pinned != want:
+		return fmt.Errorf("%w: device %s presents %s; if the device was reflashed, remove its line from %s",
+			ErrGadgetKeyChanged, serial, broker.Fingerprint(static), path)
+	case ok:
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "%s %s\n", serial, want); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	l.Info("pinned gadget key", slog.String("serial", serial), slog.String("fingerprint", broker.Fingerprint(static)))
+	return nil
+}
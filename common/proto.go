@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/tez-capital/tezsign/broker"
@@ -41,18 +42,32 @@ func perKeyTimeout(keyCount int, base, perKey, max time.Duration) time.Duration
 	return timeout
 }
 
-func ReqUnlockKeys(b *broker.Broker, keys []string, pass []byte) ([]*signerpb.PerKeyResult, error) {
+// ReqUnlockKeys unlocks keys with the master passphrase; keyPasses carries the
+// per-key passphrases (by key id) for keys that were created with one.
+func ReqUnlockKeys(b *broker.Broker, keys []string, pass []byte, keyPasses map[string][]byte) ([]*signerpb.PerKeyResult, error) {
 	p := append([]byte(nil), pass...)
 	defer secure.MemoryWipe(p)
 
-	resp, err := doReq(b, &signerpb.Request{
+	kp := make(map[string][]byte, len(keyPasses))
+	for id, v := range keyPasses {
+		kp[id] = append([]byte(nil), v...)
+	}
+	defer func() {
+		for _, v := range kp {
+			secure.MemoryWipe(v)
+		}
+	}()
+
+	// keys with their own passphrase pay for a second Argon2 derivation
+	resp, err := doReq(b, RPCUnlock, &signerpb.Request{
 		Payload: &signerpb.Request_Unlock{
 			Unlock: &signerpb.UnlockRequest{
-				KeyIds:     keys,
-				Passphrase: p,
+				KeyIds:         keys,
+				Passphrase:     p,
+				KeyPassphrases: kp,
 			},
 		},
-	}, unlockTimeout(len(keys)))
+	}, unlockTimeout(len(keys)+len(kp)))
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +76,7 @@ func ReqUnlockKeys(b *broker.Broker, keys []string, pass []byte) ([]*signerpb.Pe
 }
 
 func ReqLockKeys(b *broker.Broker, keys []string) ([]*signerpb.PerKeyResult, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCLock, &signerpb.Request{
 		Payload: &signerpb.Request_Lock{
 			Lock: &signerpb.LockRequest{
 				KeyIds: keys,
@@ -76,7 +91,7 @@ func ReqLockKeys(b *broker.Broker, keys []string) ([]*signerpb.PerKeyResult, err
 }
 
 func ReqStatus(b *broker.Broker) (*signerpb.StatusResponse, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCStatus, &signerpb.Request{
 		Payload: &signerpb.Request_Status{
 			Status: &signerpb.StatusRequest{},
 		},
@@ -88,15 +103,44 @@ func ReqStatus(b *broker.Broker) (*signerpb.StatusResponse, error) {
 	return resp.GetStatus(), nil
 }
 
+// ReqGetPublicKey fetches one key's identity by tz4 or key id.
+func ReqGetPublicKey(b *broker.Broker, key string) (*signerpb.GetPublicKeyResponse, error) {
+	resp, err := doReq(b, RPCGetPublicKey, &signerpb.Request{
+		Payload: &signerpb.Request_GetPublicKey{
+			GetPublicKey: &signerpb.GetPublicKeyRequest{Key: key},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetGetPublicKey(), nil
+}
+
+// ReqRegeneratePoP has an unlocked key re-sign its stored proof of
+// possession and returns the key's identity with the new PoP.
+func ReqRegeneratePoP(b *broker.Broker, keyID string) (*signerpb.GetPublicKeyResponse, error) {
+	resp, err := doReq(b, RPCRegeneratePoP, &signerpb.Request{
+		Payload: &signerpb.Request_RegeneratePop{
+			RegeneratePop: &signerpb.RegeneratePoPRequest{KeyId: keyID},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.GetGetPublicKey(), nil
+}
+
 func ReqSign(b *broker.Broker, tz4 string, rawMsg []byte) ([]byte, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReqPriority(b, RPCSign, &signerpb.Request{
 		Payload: &signerpb.Request_Sign{
 			Sign: &signerpb.SignRequest{
 				Tz4:     tz4,
 				Message: rawMsg,
 			},
 		},
-	}, 5*time.Second)
+	}, 5*time.Second, broker.PriorityHigh)
 	if err != nil {
 		return nil, err
 	}
@@ -106,29 +150,75 @@ func ReqSign(b *broker.Broker, tz4 string, rawMsg []byte) ([]byte, error) {
 	return s.GetSignature(), nil
 }
 
-func ReqNewKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signerpb.NewKeyPerKeyResult, error) {
+// ReqNewKeys creates keys; a non-empty keyPass protects each of them with an
+// additional per-key passphrase. derivationPath (single key only) selects an
+// explicit EIP-2333 path on deterministic stores.
+func ReqNewKeys(b *broker.Broker, keyIDs []string, pass, keyPass []byte, derivationPath string) ([]*signerpb.NewKeyPerKeyResult, error) {
 	p := append([]byte(nil), pass...)
 	defer secure.MemoryWipe(p)
+	kp := append([]byte(nil), keyPass...)
+	defer secure.MemoryWipe(kp)
+
+	keyCount := len(keyIDs)
+	if len(kp) > 0 {
+		keyCount *= 2
+	}
 
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCNewKeys, &signerpb.Request{
 		Payload: &signerpb.Request_NewKeys{
 			NewKeys: &signerpb.NewKeysRequest{
-				KeyIds:     keyIDs,
-				Passphrase: p,
+				KeyIds:         keyIDs,
+				Passphrase:     p,
+				KeyPassphrase:  kp,
+				DerivationPath: derivationPath,
 			},
 		},
-	}, newKeysTimeout(len(keyIDs)))
+	}, newKeysTimeout(keyCount))
 	if err != nil {
 		return nil, err
 	}
 	return resp.GetNewKey().GetResults(), nil
 }
 
+// ReqImportKeyShare stores a threshold share (BLsk…) as a new key; keyID may
+// be empty to auto-assign.
+func ReqImportKeyShare(b *broker.Broker, keyID, blSecretKey string, share *signerpb.ThresholdShare, pass, keyPass []byte) (*signerpb.NewKeyPerKeyResult, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+	kp := append([]byte(nil), keyPass...)
+	defer secure.MemoryWipe(kp)
+
+	keyCount := 1
+	if len(kp) > 0 {
+		keyCount = 2
+	}
+
+	resp, err := doReq(b, RPCImportKeyShare, &signerpb.Request{
+		Payload: &signerpb.Request_ImportKeyShare{
+			ImportKeyShare: &signerpb.ImportKeyShareRequest{
+				KeyId:         keyID,
+				SecretKey:     blSecretKey,
+				Share:         share,
+				Passphrase:    p,
+				KeyPassphrase: kp,
+			},
+		},
+	}, newKeysTimeout(keyCount))
+	if err != nil {
+		return nil, err
+	}
+	results := resp.GetNewKey().GetResults()
+	if len(results) != 1 {
+		return nil, fmt.Errorf("import_key_share: expected 1 result, got %d", len(results))
+	}
+	return results[0], nil
+}
+
 func ReqDeleteKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signerpb.PerKeyResult, error) {
 	p := append([]byte(nil), pass...)
 	defer secure.MemoryWipe(p)
 
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCDeleteKeys, &signerpb.Request{
 		Payload: &signerpb.Request_DeleteKeys{
 			DeleteKeys: &signerpb.DeleteKeysRequest{
 				KeyIds:     keyIDs,
@@ -142,10 +232,10 @@ func ReqDeleteKeys(b *broker.Broker, keyIDs []string, pass []byte) ([]*signerpb.
 	return resp.GetDeleteKeys().GetResults(), nil
 }
 
-func ReqLogs(b *broker.Broker, limit int) ([]string, error) {
-	resp, err := doReq(b, &signerpb.Request{
+func ReqLogs(b *broker.Broker, limit int, fromFile bool) ([]string, error) {
+	resp, err := doReq(b, RPCLogs, &signerpb.Request{
 		Payload: &signerpb.Request_Logs{
-			Logs: &signerpb.LogsRequest{Limit: uint32(limit)},
+			Logs: &signerpb.LogsRequest{Limit: uint32(limit), FromFile: fromFile},
 		},
 	}, 3*time.Second)
 	if err != nil {
@@ -154,15 +244,68 @@ func ReqLogs(b *broker.Broker, limit int) ([]string, error) {
 	return resp.GetLogs().GetLines(), nil
 }
 
-func ReqInitMaster(b *broker.Broker, deterministic bool, pass []byte) (bool, error) {
+// ReqSetLogLevel applies spec to the gadget's log levels and returns the
+// result; an empty spec just reads them.
+func ReqSetLogLevel(b *broker.Broker, spec string) (string, error) {
+	resp, err := doReq(b, RPCSetLogLevel, &signerpb.Request{
+		Payload: &signerpb.Request_SetLogLevel{
+			SetLogLevel: &signerpb.SetLogLevelRequest{Spec: spec},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return "", err
+	}
+	return resp.GetSetLogLevel().GetLevels(), nil
+}
+
+// ReqBeginUpdate starts pushing a signer binary of size bytes with the given
+// SHA-256 and release signature (its .minisig) to the gadget; pass is the
+// master passphrase.
+func ReqBeginUpdate(b *broker.Broker, pass []byte, size int64, sum, sig []byte) error {
 	p := append([]byte(nil), pass...)
 	defer secure.MemoryWipe(p)
 
-	resp, err := doReq(b, &signerpb.Request{
+	_, err := doReq(b, RPCBeginUpdate, &signerpb.Request{
+		Payload: &signerpb.Request_BeginUpdate{
+			BeginUpdate: &signerpb.BeginUpdateRequest{Passphrase: p, Size: uint64(size), Sha256: sum, Signature: sig},
+		},
+	}, 10*time.Second)
+	return err
+}
+
+// ReqUpdateChunk sends the chunk of the binary at off and returns how much
+// of it the gadget holds.
+func ReqUpdateChunk(b *broker.Broker, off int64, data []byte) (int64, error) {
+	resp, err := doReq(b, RPCUpdateChunk, &signerpb.Request{
+		Payload: &signerpb.Request_UpdateChunk{
+			UpdateChunk: &signerpb.UpdateChunkRequest{Offset: uint64(off), Data: data},
+		},
+	}, 10*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.GetUpdateUpload().GetReceived()), nil
+}
+
+// ReqCommitUpdate has the gadget check the complete upload and stage it for
+// the next boot.
+func ReqCommitUpdate(b *broker.Broker) error {
+	_, err := doReq(b, RPCCommitUpdate, &signerpb.Request{
+		Payload: &signerpb.Request_CommitUpdate{CommitUpdate: &signerpb.CommitUpdateRequest{}},
+	}, 30*time.Second)
+	return err
+}
+
+func ReqInitMaster(b *broker.Broker, deterministic bool, pass []byte, cipher string) (bool, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCInitMaster, &signerpb.Request{
 		Payload: &signerpb.Request_InitMaster{
 			InitMaster: &signerpb.InitMasterRequest{
 				Deterministic: deterministic,
 				Passphrase:    p,
+				Cipher:        cipher,
 			},
 		},
 	}, 5*time.Second)
@@ -173,7 +316,7 @@ func ReqInitMaster(b *broker.Broker, deterministic bool, pass []byte) (bool, err
 }
 
 func ReqInitInfo(b *broker.Broker) (*signerpb.InitInfoResponse, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCInitInfo, &signerpb.Request{
 		Payload: &signerpb.Request_InitInfo{InitInfo: &signerpb.InitInfoRequest{}},
 	}, 3*time.Second)
 	if err != nil {
@@ -183,7 +326,7 @@ func ReqInitInfo(b *broker.Broker) (*signerpb.InitInfoResponse, error) {
 }
 
 func ReqSetLevel(b *broker.Broker, keyID string, level uint64) (bool, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCSetLevel, &signerpb.Request{
 		Payload: &signerpb.Request_SetLevel{
 			SetLevel: &signerpb.SetLevelRequest{
 				KeyId: keyID,
@@ -197,8 +340,152 @@ func ReqSetLevel(b *broker.Broker, keyID string, level uint64) (bool, error) {
 	return resp.GetOk().GetOk(), nil
 }
 
+func ReqSetTags(b *broker.Broker, keyID string, set map[string]string, remove []string) (map[string]string, error) {
+	resp, err := doReq(b, RPCSetTags, &signerpb.Request{
+		Payload: &signerpb.Request_SetTags{
+			SetTags: &signerpb.SetTagsRequest{
+				KeyId:  keyID,
+				Set:    set,
+				Remove: remove,
+			},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetSetTags().GetTags(), nil
+}
+
+func ReqSetValidity(b *broker.Broker, keyID string, validity *signerpb.Validity, pass []byte) (bool, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCSetValidity, &signerpb.Request{
+		Payload: &signerpb.Request_SetValidity{
+			SetValidity: &signerpb.SetValidityRequest{
+				KeyId:      keyID,
+				Validity:   validity,
+				Passphrase: p,
+			},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return false, err
+	}
+	return resp.GetOk().GetOk(), nil
+}
+
+// ReqSetPolicy replaces the key's policy, validity window included, and
+// returns it as stored.
+func ReqSetPolicy(b *broker.Broker, keyID string, policy *signerpb.Policy, pass []byte) (*signerpb.Policy, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCSetPolicy, &signerpb.Request{
+		Payload: &signerpb.Request_SetPolicy{
+			SetPolicy: &signerpb.SetPolicyRequest{
+				KeyId:      keyID,
+				Policy:     policy,
+				Passphrase: p,
+			},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPolicy().GetPolicy(), nil
+}
+
+func ReqGetPolicy(b *broker.Broker, keyID string) (*signerpb.Policy, error) {
+	resp, err := doReq(b, RPCGetPolicy, &signerpb.Request{
+		Payload: &signerpb.Request_GetPolicy{
+			GetPolicy: &signerpb.GetPolicyRequest{KeyId: keyID},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPolicy().GetPolicy(), nil
+}
+
+// ReqGetWatermarks returns every kind's watermark for keyIDs, or for all keys
+// when keyIDs is empty. Like status it reads each key's state file.
+func ReqGetWatermarks(b *broker.Broker, keyIDs []string) ([]*signerpb.KeyWatermarks, error) {
+	resp, err := doReq(b, RPCGetWatermarks, &signerpb.Request{
+		Payload: &signerpb.Request_GetWatermarks{
+			GetWatermarks: &signerpb.GetWatermarksRequest{KeyIds: keyIDs},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetGetWatermarks().GetKeys(), nil
+}
+
+func ReqExportWatermarks(b *broker.Broker, pass []byte) (*signerpb.ExportWatermarksResponse, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCExportWatermarks, &signerpb.Request{
+		Payload: &signerpb.Request_ExportWatermarks{
+			ExportWatermarks: &signerpb.ExportWatermarksRequest{Passphrase: p},
+		},
+	}, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetExportWatermarks(), nil
+}
+
+func ReqImportWatermarks(b *broker.Broker, pass, snapshot []byte) ([]*signerpb.ImportWatermarksPerKeyResult, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCImportWatermarks, &signerpb.Request{
+		Payload: &signerpb.Request_ImportWatermarks{
+			ImportWatermarks: &signerpb.ImportWatermarksRequest{
+				Passphrase: p,
+				Snapshot:   snapshot,
+			},
+		},
+	}, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetImportWatermarks().GetResults(), nil
+}
+
+func ReqKDFStatus(b *broker.Broker) (*signerpb.KDFStatusResponse, error) {
+	resp, err := doReq(b, RPCKDFStatus, &signerpb.Request{
+		Payload: &signerpb.Request_KdfStatus{
+			KdfStatus: &signerpb.KDFStatusRequest{},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetKdfStatus(), nil
+}
+
+// ReqUpgradeKDF runs the master KDF upgrade. It pays for the old and the new
+// derivation and rewrites every key, so it gets a generous timeout.
+func ReqUpgradeKDF(b *broker.Broker, pass []byte) (bool, error) {
+	p := append([]byte(nil), pass...)
+	defer secure.MemoryWipe(p)
+
+	resp, err := doReq(b, RPCUpgradeKDF, &signerpb.Request{
+		Payload: &signerpb.Request_UpgradeKdf{
+			UpgradeKdf: &signerpb.UpgradeKDFRequest{Passphrase: p},
+		},
+	}, 60*time.Second)
+	if err != nil {
+		return false, err
+	}
+	return resp.GetOk().GetOk(), nil
+}
+
 func ReqVersion(b *broker.Broker) (*signerpb.VersionResponse, error) {
-	resp, err := doReq(b, &signerpb.Request{
+	resp, err := doReq(b, RPCVersion, &signerpb.Request{
 		Payload: &signerpb.Request_Version{
 			Version: &signerpb.VersionRequest{},
 		},
@@ -209,13 +496,91 @@ func ReqVersion(b *broker.Broker) (*signerpb.VersionResponse, error) {
 	return resp.GetVersion(), nil
 }
 
-func doReq(b *broker.Broker, req *signerpb.Request, timeout time.Duration) (*signerpb.Response, error) {
+func ReqDeviceInfo(b *broker.Broker) (*signerpb.DeviceInfoResponse, error) {
+	resp, err := doReq(b, RPCDeviceInfo, &signerpb.Request{
+		Payload: &signerpb.Request_DeviceInfo{
+			DeviceInfo: &signerpb.DeviceInfoRequest{},
+		},
+	}, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetDeviceInfo(), nil
+}
+
+// ReqSetTime pushes t to the gadget's clock, which only moves forward, and
+// returns the clock as the gadget then reads it.
+func ReqSetTime(b *broker.Broker, t time.Time) (*signerpb.TimeResponse, error) {
+	resp, err := doReq(b, RPCSetTime, &signerpb.Request{
+		Payload: &signerpb.Request_SetTime{
+			SetTime: &signerpb.SetTimeRequest{UnixMs: t.UnixMilli()},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetTime(), nil
+}
+
+func ReqGetTime(b *broker.Broker) (*signerpb.TimeResponse, error) {
+	resp, err := doReq(b, RPCGetTime, &signerpb.Request{
+		Payload: &signerpb.Request_GetTime{
+			GetTime: &signerpb.GetTimeRequest{},
+		},
+	}, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetTime(), nil
+}
+
+// maxEntropyChunk is the most the gadget hands out per GetEntropy request.
+const maxEntropyChunk = 4096
+
+// ReqGetEntropy collects n bytes from the gadget's RNG, in as many requests
+// as it takes. hardware is false if any of them came without the hardware
+// RNG mixed in.
+func ReqGetEntropy(b *broker.Broker, n int) (data []byte, hardware bool, err error) {
+	data = make([]byte, 0, n)
+	hardware = true
+	for len(data) < n {
+		chunk := min(n-len(data), maxEntropyChunk)
+		resp, err := doReq(b, RPCGetEntropy, &signerpb.Request{
+			Payload: &signerpb.Request_GetEntropy{
+				GetEntropy: &signerpb.GetEntropyRequest{Length: uint32(chunk)},
+			},
+		}, 3*time.Second)
+		if err != nil {
+			secure.MemoryWipe(data)
+			return nil, false, err
+		}
+		got := resp.GetGetEntropy()
+		if len(got.GetData()) != chunk {
+			secure.MemoryWipe(data)
+			return nil, false, fmt.Errorf("gadget returned %d entropy bytes, asked for %d", len(got.GetData()), chunk)
+		}
+		data = append(data, got.GetData()...)
+		hardware = hardware && got.GetHardware()
+	}
+	return data, hardware, nil
+}
+
+// doReq sends req and waits up to timeout, or the override set for rpc (see
+// SetRPCTimeout). The deadline travels with the request, so the gadget stops
+// working on it once the host has given up.
+func doReq(b *broker.Broker, rpc RPC, req *signerpb.Request, timeout time.Duration) (*signerpb.Response, error) {
+	return doReqPriority(b, rpc, req, timeout, broker.PriorityNormal)
+}
+
+// doReqPriority is doReq on the given broker write lane.
+func doReqPriority(b *broker.Broker, rpc RPC, req *signerpb.Request, timeout time.Duration, prio broker.Priority) (*signerpb.Response, error) {
 	pb, err := proto.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout(rpc, timeout))
 	defer cancel()
+	ctx = broker.WithPriority(ctx, prio)
 	raw, _, err := b.Request(ctx, pb)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RPC names a request kind for timeout overrides.
+type RPC string
+
+const (
+	RPCUnlock           RPC = "unlock"
+	RPCLock             RPC = "lock"
+	RPCStatus           RPC = "status"
+	RPCGetPublicKey     RPC = "get_public_key"
+	RPCSign             RPC = "sign"
+	RPCNewKeys          RPC = "new_keys"
+	RPCImportKeyShare   RPC = "import_key_share"
+	RPCDeleteKeys       RPC = "delete_keys"
+	RPCLogs             RPC = "logs"
+	RPCSetLogLevel      RPC = "set_log_level"
+	RPCInitMaster       RPC = "init_master"
+	RPCInitInfo         RPC = "init_info"
+	RPCSetLevel         RPC = "set_level"
+	RPCSetTags          RPC = "set_tags"
+	RPCSetValidity      RPC = "set_validity"
+	RPCSetPolicy        RPC = "set_policy"
+	RPCGetPolicy        RPC = "get_policy"
+	RPCGetWatermarks    RPC = "get_watermarks"
+	RPCRegeneratePoP    RPC = "regenerate_pop"
+	RPCExportWatermarks RPC = "export_watermarks"
+	RPCImportWatermarks RPC = "import_watermarks"
+	RPCKDFStatus        RPC = "kdf_status"
+	RPCUpgradeKDF       RPC = "upgrade_kdf"
+	RPCVersion          RPC = "version"
+	RPCDeviceInfo       RPC = "device_info"
+	RPCGetEntropy       RPC = "entropy"
+	RPCSetTime          RPC = "set_time"
+	RPCGetTime          RPC = "get_time"
+	RPCBeginUpdate      RPC = "begin_update"
+	RPCUpdateChunk      RPC = "update_chunk"
+	RPCCommitUpdate     RPC = "commit_update"
+)
+
+var knownRPCs = []RPC{
+	RPCUnlock, RPCLock, RPCStatus, RPCGetPublicKey, RPCSign, RPCNewKeys, RPCImportKeyShare, RPCDeleteKeys, RPCLogs, RPCSetLogLevel,
+	RPCInitMaster, RPCInitInfo, RPCSetLevel, RPCSetTags, RPCSetValidity, RPCSetPolicy, RPCGetPolicy,
+	RPCGetWatermarks, RPCRegeneratePoP, RPCExportWatermarks, RPCImportWatermarks, RPCKDFStatus, RPCUpgradeKDF, RPCVersion,
+	RPCDeviceInfo, RPCGetEntropy, RPCSetTime, RPCGetTime, RPCBeginUpdate, RPCUpdateChunk, RPCCommitUpdate,
+}
+
+var (
+	rpcTimeoutsMu sync.RWMutex
+	rpcTimeouts   = map[RPC]time.Duration{}
+)
+
+// SetRPCTimeout replaces the built-in timeout of one RPC, including the ones
+// that otherwise scale with the number of keys. Zero restores the default.
+func SetRPCTimeout(rpc RPC, d time.Duration) {
+	rpcTimeoutsMu.Lock()
+	defer rpcTimeoutsMu.Unlock()
+	if d <= 0 {
+		delete(rpcTimeouts, rpc)
+		return
+	}
+	rpcTimeouts[rpc] = d
+}
+
+// ParseRPCTimeout reads one "rpc=duration" override, e.g. "sign=2s".
+func ParseRPCTimeout(s string) (RPC, time.Duration, error) {
+	name, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return "", 0, fmt.Errorf("rpc timeout %q: want rpc=duration", s)
+	}
+	rpc := RPC(strings.TrimSpace(name))
+	known := false
+	for _, r := range knownRPCs {
+		known = known || r == rpc
+	}
+	if !known {
+		return "", 0, fmt.Errorf("rpc timeout %q: unknown rpc %q", s, rpc)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || d <= 0 {
+		return "", 0, fmt.Errorf("rpc timeout %q: want a positive duration", s)
+	}
+	return rpc, d, nil
+}
+
+// rpcTimeout is the override for rpc, or def.
+func rpcTimeout(rpc RPC, def time.Duration) time.Duration {
+	rpcTimeoutsMu.RLock()
+	defer rpcTimeoutsMu.RUnlock()
+	if d, ok := rpcTimeouts[rpc]; ok {
+		return d
+	}
+	return def
+}
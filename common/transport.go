@@ -0,0 +1,167 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+)
+
+const (
+	TransportUSB  = "usb"
+	TransportTCP  = "tcp"
+	TransportUnix = "unix"
+
+	transportDialTimeout = 5 * time.Second
+)
+
+// Stream is what a transport hands the broker: one end of a byte stream to
+// the gadget, closed when the session ends.
+type Stream struct {
+	R     broker.ReadContexter
+	W     broker.WriteContexter
+	Close func() error
+}
+
+// TransportDialer opens a stream for p.Channel at p.Address.
+type TransportDialer func(ctx context.Context, p ConnectParams) (*Stream, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]TransportDialer{
+		TransportTCP:  dialNet("tcp"),
+		TransportUnix: dialNet("unix"),
+	}
+)
+
+// RegisterTransport makes a transport selectable through
+// ConnectParams.Transport. USB (FunctionFS) is built in and cannot be
+// replaced.
+func RegisterTransport(name string, dial TransportDialer) {
+	if name == "" || name == TransportUSB {
+		panic(fmt.Sprintf("common: transport name %q is reserved", name))
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = dial
+}
+
+// Transports lists the selectable transports.
+func Transports() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	names := []string{TransportUSB}
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func lookupTransport(name string) (TransportDialer, error) {
+	transportsMu.RLock()
+	dial, ok := transports[name]
+	transportsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (have: %v)", ErrUnknownTransport, name, Transports())
+	}
+	return dial, nil
+}
+
+func dialNet(network string) TransportDialer {
+	return func(ctx context.Context, p ConnectParams) (*Stream, error) {
+		if p.Address == "" {
+			return nil, fmt.Errorf("%s transport: %w", network, ErrNoAddress)
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, p.Address)
+		if err != nil {
+			return nil, err
+		}
+		return NewConnStream(conn), nil
+	}
+}
+
+// NewConnStream adapts a net.Conn for the broker, for either end: the host
+// transports dial one, an emulated gadget wraps the ones it accepts.
+func NewConnStream(conn net.Conn) *Stream {
+	c := &connAdapter{conn: conn}
+	return &Stream{R: c, W: c, Close: conn.Close}
+}
+
+// connAdapter maps context cancellation onto the connection's deadlines. The
+// broker has one reader and one writer, so the two deadlines are never
+// contended.
+type connAdapter struct {
+	conn net.Conn
+}
+
+func (c *connAdapter) ReadContext(ctx context.Context, p []byte) (int, error) {
+	d, _ := ctx.Deadline()
+	_ = c.conn.SetReadDeadline(d)
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	n, err := c.conn.Read(p)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		return n, ctx.Err()
+	case errors.Is(err, io.EOF):
+		// unlike a USB endpoint, a socket at EOF does not come back; the
+		// broker must not treat it as retryable
+		return n, ErrStreamClosed
+	}
+	return n, err
+}
+
+func (c *connAdapter) WriteContext(ctx context.Context, p []byte) (int, error) {
+	d, _ := ctx.Deadline()
+	_ = c.conn.SetWriteDeadline(d)
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetWriteDeadline(time.Now()) })
+	defer stop()
+	n, err := c.conn.Write(p)
+	if err != nil && ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	return n, err
+}
+
+// connectStream is Connect for every transport but USB.
+func connectStream(p ConnectParams) (*Session, error) {
+	dial, err := lookupTransport(p.Transport)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), transportDialTimeout)
+	defer cancel()
+	st, err := dial(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s transport: %w", p.Transport, err)
+	}
+
+	// the address stands in for the USB serial, e.g. for key pinning
+	id := p.Transport + ":" + p.Address
+	p.Logger.Debug("using stream", slog.String("transport", p.Transport), slog.String("address", p.Address))
+	return &Session{
+		Broker:    newHostBroker(p, id, st.R, st.W),
+		Channel:   p.Channel,
+		KeepAlive: p.KeepAlive,
+
+		Heartbeat:       p.Heartbeat,
+		HeartbeatMisses: p.HeartbeatMisses,
+
+		Transport: p.Transport,
+		Address:   p.Address,
+		stream:    st,
+
+		Serial: id,
+		Log:    p.Logger,
+	}, nil
+}
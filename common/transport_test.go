@@ -0,0 +1,161 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tez-capital/tezsign/broker"
+	"github.com/tez-capital/tezsign/broker/brokertest"
+)
+
+func TestConnectOverSocketTransports(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir()) // host key and pins
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, tc := range []struct{ network, address string }{
+		{TransportTCP, "127.0.0.1:0"},
+		{TransportUnix, filepath.Join(t.TempDir(), "gadget.sock")},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			ln, err := net.Listen(tc.network, tc.address)
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			defer ln.Close()
+
+			gadgetKey, err := broker.LoadOrCreateStaticKey(filepath.Join(t.TempDir(), "gadget.key"))
+			if err != nil {
+				t.Fatalf("static key: %v", err)
+			}
+			accepted := make(chan *Stream, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- NewConnStream(conn)
+			}()
+
+			sess, err := Connect(ConnectParams{
+				Logger:    logger,
+				Channel:   ChanSign,
+				Transport: tc.network,
+				Address:   ln.Addr().String(),
+			})
+			if err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			defer sess.Close()
+
+			st := <-accepted
+			gadget := broker.New(st.R, st.W,
+				broker.WithLogger(logger),
+				broker.WithHandshake(),
+				broker.WithNoise(broker.NoiseConfig{Static: gadgetKey}),
+				broker.WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+					return append([]byte(nil), payload...), nil
+				}),
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, _, err := sess.Broker.Request(ctx, []byte("over a socket"))
+			if err != nil || !bytes.Equal(resp, []byte("over a socket")) {
+				t.Fatalf("Request: %q, %v", resp, err)
+			}
+			if _, ok := sess.Broker.PeerStatic(); !ok {
+				t.Fatalf("expected the socket session to be encrypted")
+			}
+			if ok, err := sess.Ready(); !ok || err != nil {
+				t.Fatalf("Ready: %v, %v", ok, err)
+			}
+
+			// the gadget going away must end the session, not spin on retries
+			gadget.Stop()
+			_ = st.Close()
+			select {
+			case <-sess.Broker.Done():
+			case <-ctx.Done():
+				t.Fatalf("host broker outlived the closed stream")
+			}
+			if ok, err := sess.Ready(); ok || !errors.Is(err, ErrStreamClosed) {
+				t.Fatalf("Ready after close: %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestConnectRejectsUnknownTransport(t *testing.T) {
+	_, err := Connect(ConnectParams{Channel: ChanSign, Transport: "carrier-pigeon", Address: "x"})
+	if !errors.Is(err, ErrUnknownTransport) {
+		t.Fatalf("Connect: got %v, want ErrUnknownTransport", err)
+	}
+}
+
+func TestSessionOverFlakyStream(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	gadgetKey, err := broker.LoadOrCreateStaticKey(filepath.Join(t.TempDir(), "gadget.key"))
+	if err != nil {
+		t.Fatalf("static key: %v", err)
+	}
+
+	link := brokertest.NewLink(brokertest.Faults{})
+	RegisterTransport("flaky-usb", func(context.Context, ConnectParams) (*Stream, error) {
+		return &Stream{R: link.ToHost, W: link.ToGadget, Close: link.Close}, nil
+	})
+	gadget := broker.New(link.ToGadget, link.ToHost,
+		broker.WithLogger(logger),
+		broker.WithHandshake(), broker.WithChecksums(), broker.WithSequencing(),
+		broker.WithNoise(broker.NoiseConfig{Static: gadgetKey}),
+		broker.WithHandler(func(_ context.Context, payload []byte) ([]byte, error) {
+			return append([]byte(nil), payload...), nil
+		}),
+	)
+	defer gadget.Stop()
+
+	sess, err := Connect(ConnectParams{Logger: logger, Channel: ChanSign, Transport: "flaky-usb", Address: "link"})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer sess.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := sess.Broker.Request(ctx, []byte("handshake")); err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if _, ok := sess.Broker.PeerStatic(); !ok {
+		t.Fatalf("expected the session to be encrypted")
+	}
+
+	link.Inject(brokertest.Faults{Seed: 4, ShortRead: 0.5, ReadEAGAIN: 0.2, Duplicate: 0.2})
+	for i := range 20 {
+		want := []byte{byte(i)}
+		resp, _, err := sess.Broker.Request(ctx, want)
+		if err != nil || !bytes.Equal(resp, want) {
+			t.Fatalf("request %d: %q, %v", i, resp, err)
+		}
+	}
+	if ok, err := sess.Ready(); !ok || err != nil {
+		t.Fatalf("Ready on a flaky link: %v, %v", ok, err)
+	}
+
+	// unplugging ends the session rather than leaving it retrying
+	link.Close()
+	select {
+	case <-sess.Broker.Done():
+	case <-ctx.Done():
+		t.Fatalf("host broker outlived the unplugged link")
+	}
+	if ok, err := sess.Ready(); ok || !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("Ready after unplug: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,339 @@
+// Package installer copies a TezSign installer card onto the board's eMMC or
+// NVMe. An installer image is a regular image whose boot partition carries
+// MarkerName; the helper in app/installer finds the card by it, copies the
+// card byte for byte up to the end of its last partition, grows that
+// partition (the data partition) to the end of the target and removes the
+// marker from the copy, so the installed system boots as a plain signer.
+// This package holds the parts that need no board: reading the disks from
+// sysfs and the udev database, the copy and the partition table change.
+package installer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+const (
+	// MarkerName sits in the root of an installer image's boot partition.
+	MarkerName = "tezsign-installer"
+	// OverwriteName next to it lets the installer replace a target that
+	// already holds a TezSign data partition.
+	OverwriteName = "tezsign-installer-overwrite"
+)
+
+// sectorSize is the unit of the start and size files in sysfs, whatever the
+// device's own sector size.
+const sectorSize = 512
+
+var (
+	ErrNoTarget       = errors.New("no eMMC or NVMe disk found")
+	ErrSeveralTargets = errors.New("more than one eMMC or NVMe disk")
+	ErrTooSmall       = errors.New("target is smaller than the image")
+)
+
+// dataLabels are the labels of a TezSign data partition: plain, and as
+// data_luks formats it.
+var dataLabels = []string{"data", "data-luks"}
+
+var (
+	mmcDisk  = regexp.MustCompile(`^mmcblk[0-9]+$`)
+	nvmeDisk = regexp.MustCompile(`^nvme[0-9]+n[0-9]+$`)
+)
+
+// Sys is where the disks are read from; tests point it at a fake tree.
+type Sys struct {
+	Block    string // /sys/block
+	UdevData string // /run/udev/data
+}
+
+func DefaultSys() Sys {
+	return Sys{Block: "/sys/block", UdevData: "/run/udev/data"}
+}
+
+func (s Sys) read(parts ...string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(append([]string{s.Block}, parts...)...))
+	return strings.TrimSpace(string(data)), err
+}
+
+func (s Sys) number(parts ...string) (int64, error) {
+	v, err := s.read(parts...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Disks lists the whole disks the kernel knows.
+func (s Sys) Disks() ([]string, error) {
+	entries, err := os.ReadDir(s.Block)
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	for _, e := range entries {
+		disks = append(disks, e.Name())
+	}
+	return disks, nil
+}
+
+// Partitions lists the partitions of disk in partition number order.
+func (s Sys) Partitions(disk string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Block, disk))
+	if err != nil {
+		return nil, err
+	}
+	type numbered struct {
+		name string
+		n    int64
+	}
+	var parts []numbered
+	for _, e := range entries {
+		if n, err := s.number(disk, e.Name(), "partition"); err == nil {
+			parts = append(parts, numbered{e.Name(), n})
+		}
+	}
+	slices.SortFunc(parts, func(a, b numbered) int { return int(a.n - b.n) })
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		names[i] = p.name
+	}
+	return names, nil
+}
+
+// PartitionNumber is the number of partition part of disk.
+func (s Sys) PartitionNumber(disk, part string) (int, error) {
+	n, err := s.number(disk, part, "partition")
+	return int(n), err
+}
+
+// Removable reports whether disk can carry an installer: an SD card or a
+// USB drive.
+func (s Sys) Removable(disk string) bool {
+	if strings.HasPrefix(disk, "sd") {
+		return true
+	}
+	if !mmcDisk.MatchString(disk) {
+		return false
+	}
+	kind, _ := s.read(disk, "device", "type")
+	return kind == "SD"
+}
+
+// Targets lists the disks an installer can write to: eMMC and NVMe.
+func (s Sys) Targets() ([]string, error) {
+	disks, err := s.Disks()
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, d := range disks {
+		switch {
+		case nvmeDisk.MatchString(d):
+			targets = append(targets, d)
+		case mmcDisk.MatchString(d):
+			if kind, _ := s.read(d, "device", "type"); kind == "MMC" {
+				targets = append(targets, d)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Target picks the only eMMC or NVMe disk.
+func (s Sys) Target() (string, error) {
+	targets, err := s.Targets()
+	if err != nil {
+		return "", err
+	}
+	switch len(targets) {
+	case 0:
+		return "", ErrNoTarget
+	case 1:
+		return targets[0], nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrSeveralTargets, strings.Join(targets, ", "))
+}
+
+// Size is the size of disk in bytes.
+func (s Sys) Size(disk string) (int64, error) {
+	n, err := s.number(disk, "size")
+	return n * sectorSize, err
+}
+
+// Extent is the end of the last partition of disk in bytes: what an
+// installer copies.
+func (s Sys) Extent(disk string) (int64, error) {
+	parts, err := s.Partitions(disk)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) == 0 {
+		return 0, fmt.Errorf("%s has no partitions", disk)
+	}
+	var end int64
+	for _, p := range parts {
+		start, err := s.number(disk, p, "start")
+		if err != nil {
+			return 0, err
+		}
+		size, err := s.number(disk, p, "size")
+		if err != nil {
+			return 0, err
+		}
+		end = max(end, (start+size)*sectorSize)
+	}
+	return end, nil
+}
+
+// Label is the filesystem (or LUKS) label udev's blkid probe recorded for
+// a partition, "" if it has none.
+func (s Sys) Label(disk, part string) string {
+	dev, err := s.read(disk, part, "dev")
+	if err != nil {
+		return ""
+	}
+	f, err := os.Open(filepath.Join(s.UdevData, "b"+dev))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "E:ID_FS_LABEL="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// HoldsTezSign reports whether disk has a TezSign data partition, which an
+// installer must not overwrite unasked.
+func (s Sys) HoldsTezSign(disk string) (bool, error) {
+	parts, err := s.Partitions(disk)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range parts {
+		if slices.Contains(dataLabels, s.Label(disk, p)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PartitionDevice is the device name of partition n of disk.
+func PartitionDevice(disk string, n int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, n)
+	}
+	return fmt.Sprintf("%s%d", disk, n)
+}
+
+const copyChunk = 4 << 20
+
+// Copy copies the first n bytes of src to dst. progress, if set, gets the
+// bytes copied so far after every chunk.
+func Copy(dst io.WriterAt, src io.ReaderAt, n int64, progress func(done int64)) error {
+	buf := make([]byte, copyChunk)
+	for off := int64(0); off < n; {
+		chunk := buf[:min(int64(len(buf)), n-off)]
+		if _, err := src.ReadAt(chunk, off); err != nil {
+			return fmt.Errorf("read at %d: %w", off, err)
+		}
+		if _, err := dst.WriteAt(chunk, off); err != nil {
+			return fmt.Errorf("write at %d: %w", off, err)
+		}
+		off += int64(len(chunk))
+		if progress != nil {
+			progress(off)
+		}
+	}
+	return nil
+}
+
+// GrowLast extends the partition that ends last to the end of a disk of
+// size bytes and returns its number. A GPT gets its backup header moved to
+// the new end; an MBR partition stops at 2 TiB.
+func GrowLast(f *os.File, size int64) (int, error) {
+	d, err := diskfs.OpenBackend(file.New(f, false), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		return 0, err
+	}
+	n, err := GrowLastPartition(d, size)
+	if err != nil {
+		return 0, err
+	}
+	return n, f.Sync()
+}
+
+// GrowLastPartition is GrowLast on an open disk, for callers that reach the
+// device through their own backend; they flush it themselves.
+func GrowLastPartition(d *disk.Disk, size int64) (int, error) {
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return 0, fmt.Errorf("read partition table: %w", err)
+	}
+	w, err := d.Backend.Writable()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch t := table.(type) {
+	case *gpt.Table:
+		if len(t.Partitions) == 0 {
+			return 0, errors.New("partition table is empty")
+		}
+		last := t.Partitions[0]
+		for _, p := range t.Partitions {
+			if p.End > last.End {
+				last = p
+			}
+		}
+		n = last.Index
+		t.Resize(uint64(size))
+		if last.End > t.LastDataSector() {
+			return 0, ErrTooSmall
+		}
+		last.End = t.LastDataSector()
+		last.Size = (last.End - last.Start + 1) * uint64(t.LogicalSectorSize)
+	case *mbr.Table:
+		var last *mbr.Partition
+		for i, p := range t.Partitions {
+			if p.Size == 0 {
+				continue
+			}
+			if last == nil || p.Start > last.Start {
+				last, n = p, i+1
+			}
+		}
+		if last == nil {
+			return 0, errors.New("partition table is empty")
+		}
+		sectors := size / int64(t.LogicalSectorSize)
+		if int64(last.Start)+int64(last.Size) > sectors {
+			return 0, ErrTooSmall
+		}
+		last.Size = uint32(min(sectors-int64(last.Start), 1<<32-1))
+	default:
+		return 0, fmt.Errorf("unsupported partition table %s", table.Type())
+	}
+	if err := table.Write(w, size); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
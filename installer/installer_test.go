@@ -0,0 +1,242 @@
+package installer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeSys is a Radxa Zero 3W with an SD card (mmcblk1) holding the image
+// and an eMMC (mmcblk0) holding an earlier TezSign install.
+func fakeSys(t *testing.T) Sys {
+	s := Sys{Block: t.TempDir(), UdevData: t.TempDir()}
+	writeFiles(t, s.Block, map[string]string{
+		"mmcblk1/device/type":          "SD",
+		"mmcblk1/size":                 "62333952",
+		"mmcblk1/mmcblk1p1/partition":  "1",
+		"mmcblk1/mmcblk1p1/start":      "32768",
+		"mmcblk1/mmcblk1p1/size":       "131072",
+		"mmcblk1/mmcblk1p1/dev":        "179:97",
+		"mmcblk1/mmcblk1p2/partition":  "2",
+		"mmcblk1/mmcblk1p2/start":      "163840",
+		"mmcblk1/mmcblk1p2/size":       "49152",
+		"mmcblk1/mmcblk1p10/partition": "3",
+		"mmcblk1/mmcblk1p10/start":     "212992",
+		"mmcblk1/mmcblk1p10/size":      "262144",
+		"mmcblk0/device/type":          "MMC",
+		"mmcblk0/size":                 "30535680",
+		"mmcblk0/mmcblk0p3/partition":  "3",
+		"mmcblk0/mmcblk0p3/dev":        "179:3",
+		"mmcblk0boot0/size":            "8192",
+		"loop0/size":                   "0",
+	})
+	writeFiles(t, s.UdevData, map[string]string{
+		"b179:97": "S:disk/by-label/boot\nE:ID_FS_TYPE=ext4\nE:ID_FS_LABEL=boot",
+		"b179:3":  "E:ID_FS_TYPE=crypto_LUKS\nE:ID_FS_LABEL=data-luks",
+	})
+	return s
+}
+
+func TestSysFindsDisks(t *testing.T) {
+	s := fakeSys(t)
+
+	if !s.Removable("mmcblk1") || s.Removable("mmcblk0") || !s.Removable("sda") || s.Removable("loop0") {
+		t.Fatal("SD card and USB drive must be removable, eMMC and loop devices not")
+	}
+	target, err := s.Target()
+	if err != nil || target != "mmcblk0" {
+		t.Fatalf("Target() = %q, %v", target, err)
+	}
+	parts, err := s.Partitions("mmcblk1")
+	if err != nil || strings.Join(parts, ",") != "mmcblk1p1,mmcblk1p2,mmcblk1p10" {
+		t.Fatalf("Partitions = %v, %v", parts, err)
+	}
+	if end, err := s.Extent("mmcblk1"); err != nil || end != (212992+262144)*512 {
+		t.Fatalf("Extent = %d, %v", end, err)
+	}
+	if l := s.Label("mmcblk1", "mmcblk1p1"); l != "boot" {
+		t.Fatalf("Label = %q", l)
+	}
+	if holds, err := s.HoldsTezSign("mmcblk0"); err != nil || !holds {
+		t.Fatalf("HoldsTezSign(eMMC) = %v, %v", holds, err)
+	}
+	if holds, _ := s.HoldsTezSign("mmcblk1"); holds {
+		t.Fatal("HoldsTezSign(card without data label)")
+	}
+
+	writeFiles(t, s.Block, map[string]string{"nvme0n1/size": "1000215216"})
+	if _, err := s.Target(); !errors.Is(err, ErrSeveralTargets) {
+		t.Fatalf("eMMC and NVMe: %v, want ErrSeveralTargets", err)
+	}
+}
+
+func TestPartitionDevice(t *testing.T) {
+	for disk, want := range map[string]string{"mmcblk0": "mmcblk0p4", "nvme0n1": "nvme0n1p4", "sda": "sda4"} {
+		if got := PartitionDevice(disk, 4); got != want {
+			t.Fatalf("PartitionDevice(%s) = %s, want %s", disk, got, want)
+		}
+	}
+}
+
+func TestCopyStopsAtExtent(t *testing.T) {
+	src := bytes.Repeat([]byte{0xa5}, copyChunk+100)
+	dst, err := os.Create(filepath.Join(t.TempDir(), "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	var calls int
+	if err := Copy(dst, bytes.NewReader(src), copyChunk+10, func(int64) { calls++ }); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(dst.Name())
+	if !bytes.Equal(got, src[:copyChunk+10]) || calls != 2 {
+		t.Fatalf("copied %d bytes in %d chunks", len(got), calls)
+	}
+}
+
+const (
+	mib      = 1 << 20
+	imgSize  = 32 * mib
+	diskSize = 64 * mib
+)
+
+func newDisk(t *testing.T, size int64) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// install partitions an image, copies it onto a larger disk and grows the
+// copy, as the helper does.
+func install(t *testing.T, write func(d *disk.Disk) error) *os.File {
+	t.Helper()
+	img := newDisk(t, imgSize)
+	d, err := diskfs.OpenBackend(file.New(img, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(d); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newDisk(t, diskSize)
+	if err := Copy(target, img, imgSize, nil); err != nil {
+		t.Fatal(err)
+	}
+	n, err := GrowLast(target, diskSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("grew partition %d, want 3", n)
+	}
+	return target
+}
+
+func TestGrowLastGPT(t *testing.T) {
+	target := install(t, func(d *disk.Disk) error {
+		return d.Partition(&gpt.Table{
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			ProtectiveMBR:      true,
+			Partitions: []*gpt.Partition{
+				{Index: 1, Start: 2048, End: 10239, Type: gpt.LinuxFilesystem, Name: "boot"},
+				{Index: 2, Start: 10240, End: 20479, Type: gpt.LinuxFilesystem},
+				{Index: 3, Start: 20480, End: 40959, Type: gpt.LinuxFilesystem},
+			},
+		})
+	})
+
+	table, err := gpt.Read(file.New(target, true), 512, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(file.New(target, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Verify(d.Backend, diskSize); err != nil {
+		t.Fatalf("backup GPT: %v", err)
+	}
+	last := table.Partitions[2]
+	if last.Start != 20480 || last.End != table.LastDataSector() || last.End < diskSize/512-40 {
+		t.Fatalf("last partition %d-%d, disk ends at %d", last.Start, last.End, diskSize/512)
+	}
+	if table.Partitions[0].Name != "boot" || table.Partitions[1].End != 20479 {
+		t.Fatal("other partitions changed")
+	}
+}
+
+func TestGrowLastMBR(t *testing.T) {
+	target := install(t, func(d *disk.Disk) error {
+		return d.Partition(&mbr.Table{
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			Partitions: []*mbr.Partition{
+				{Bootable: true, Type: mbr.Fat32LBA, Start: 8192, Size: 8192},
+				{Type: mbr.Linux, Start: 16384, Size: 8192},
+				{Type: mbr.Linux, Start: 24576, Size: 16384},
+			},
+		})
+	})
+
+	table, err := mbr.Read(file.New(target, true), 512, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := table.Partitions[2]; last.Start != 24576 || int64(last.Start+last.Size) != diskSize/512 {
+		t.Fatalf("last partition %d+%d, disk ends at %d", last.Start, last.Size, diskSize/512)
+	}
+	if !table.Partitions[0].Bootable || table.Partitions[1].Size != 8192 {
+		t.Fatal("other partitions changed")
+	}
+}
+
+func TestGrowLastRejectsSmallerTarget(t *testing.T) {
+	img := newDisk(t, imgSize)
+	d, err := diskfs.OpenBackend(file.New(img, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Partition(&mbr.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		Partitions:         []*mbr.Partition{{Type: mbr.Linux, Start: 2048, Size: imgSize/512 - 2048}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GrowLast(img, imgSize/2); !errors.Is(err, ErrTooSmall) {
+		t.Fatalf("err = %v, want ErrTooSmall", err)
+	}
+}
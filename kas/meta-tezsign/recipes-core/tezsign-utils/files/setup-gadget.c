@@ -193,6 +193,7 @@ static int recursive_chown(const char *path, uid_t uid, gid_t gid) {
 
 int main() {
     char serial[64] = "000000000000"; // Default fallback
+    const char *product = getenv("GADGET_PRODUCT"); // provision.toml label
     FILE *f;
     uid_t reg_uid, tez_uid;
     gid_t dm_gid, reg_gid, tez_gid;
@@ -250,12 +251,16 @@ int main() {
         return EXIT_FAILURE;
     }
 
+    if (product == NULL || product[0] == '\0') {
+        product = "tezsign-gadget";
+    }
+
     log_message("INFO", "Writing gadget descriptors");
     if (write_attr(GADGET_BASE "/idVendor", "0x9997") != 0 ||
         write_attr(GADGET_BASE "/idProduct", "0x0001") != 0 ||
         write_attr(GADGET_BASE "/strings/0x409/serialnumber", "%s", serial) != 0 ||
         write_attr(GADGET_BASE "/strings/0x409/manufacturer", "TzC") != 0 ||
-        write_attr(GADGET_BASE "/strings/0x409/product", "tezsign-gadget") != 0) {
+        write_attr(GADGET_BASE "/strings/0x409/product", "%s", product) != 0) {
         return EXIT_FAILURE;
     }
 
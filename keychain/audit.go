@@ -0,0 +1,118 @@
+package keychain
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Sign decisions are logged as one structured record with fixed field names,
+// so monitoring can parse them instead of free text:
+//
+//	event=sign_decision key_id tz4 kind op_level round decision reason duration_us
+//
+// op_level is the operation's block level ("level" is the record's own).
+// decision is "signed" or "refused"; reason is empty when signed and one of
+// the SignReason* codes otherwise (the gadget adds its own for requests it
+// refuses before they reach the keyring). kind is "" and op_level/round 0 when the
+// payload did not decode.
+const (
+	AuditEventSignDecision = "sign_decision"
+
+	DecisionSigned  = "signed"
+	DecisionRefused = "refused"
+
+	SignReasonKeyNotFound     = "key_not_found"
+	SignReasonKeyLocked       = "key_locked"
+	SignReasonStale           = "stale_watermark"
+	SignReasonBadPayload      = "bad_payload"
+	SignReasonUnsupported     = "unsupported_kind"
+	SignReasonOutsideValidity = "outside_validity"
+	SignReasonPolicyRefused   = "policy_refused"
+	SignReasonRateLimited     = "rate_limited"
+	SignReasonSelfCheckFailed = "self_check_failed"
+	SignReasonStateCorrupted  = "state_corrupted"
+	SignReasonError           = "error"
+)
+
+// SignEvent is one sign decision.
+type SignEvent struct {
+	KeyID    string
+	TZ4      string
+	Kind     string
+	Level    uint64
+	Round    uint32
+	Decision string
+	Reason   string
+	Duration time.Duration
+}
+
+// NewSignEvent starts the event of a request for tz4 over raw, filling kind,
+// level and round if raw decodes.
+func NewSignEvent(tz4 string, raw []byte) SignEvent {
+	ev := SignEvent{TZ4: tz4}
+	if knd, level, round, _, err := DecodeAndValidateSignPayload(raw); err == nil {
+		if spec, ok := lookupSignKind(knd); ok {
+			ev.Kind = spec.Name
+		}
+		ev.Level, ev.Round = level, round
+	}
+	return ev
+}
+
+// Finish sets the decision from err and the duration since start.
+func (e *SignEvent) Finish(start time.Time, err error) {
+	e.Duration = time.Since(start)
+	if err == nil {
+		e.Decision, e.Reason = DecisionSigned, ""
+		return
+	}
+	e.Decision, e.Reason = DecisionRefused, SignRefusalReason(err)
+}
+
+// Log emits the event: Info when signed, Warn when refused.
+func (e SignEvent) Log(l *slog.Logger) {
+	lvl := slog.LevelInfo
+	if e.Decision != DecisionSigned {
+		lvl = slog.LevelWarn
+	}
+	l.LogAttrs(context.Background(), lvl, "sign decision",
+		slog.String("event", AuditEventSignDecision),
+		slog.String("key_id", e.KeyID),
+		slog.String("tz4", e.TZ4),
+		slog.String("kind", e.Kind),
+		slog.Uint64("op_level", e.Level),
+		slog.Uint64("round", uint64(e.Round)),
+		slog.String("decision", e.Decision),
+		slog.String("reason", e.Reason),
+		slog.Int64("duration_us", e.Duration.Microseconds()),
+	)
+}
+
+// SignRefusalReason maps a SignAndUpdate error to its reason code.
+func SignRefusalReason(err error) string {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return SignReasonKeyNotFound
+	case errors.Is(err, ErrKeyLocked):
+		return SignReasonKeyLocked
+	case errors.Is(err, ErrStaleWatermark):
+		return SignReasonStale
+	case errors.Is(err, ErrBadPayload):
+		return SignReasonBadPayload
+	case errors.Is(err, ErrUnsupportedOperation):
+		return SignReasonUnsupported
+	case errors.Is(err, ErrOutsideValidity):
+		return SignReasonOutsideValidity
+	case errors.Is(err, ErrRateLimited):
+		return SignReasonRateLimited
+	case errors.Is(err, ErrPolicyRefused):
+		return SignReasonPolicyRefused
+	case errors.Is(err, ErrSignatureSelfCheck):
+		return SignReasonSelfCheckFailed
+	case errors.Is(err, ErrKeyStateCorrupted):
+		return SignReasonStateCorrupted
+	}
+	return SignReasonError
+}
@@ -0,0 +1,306 @@
+package keychain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/secure"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	backupVersion = 1
+
+	// magic(4) version(2) argon time(4) memory(4) threads(1) salt(16)
+	// nonce(12) created(8) plain length(8) header crc(4)
+	backupHeaderSize = 4 + 2 + 4 + 4 + 1 + 16 + 12 + 8 + 8 + 4
+	backupMaxFile    = 1 << 20
+)
+
+var backupMagic = [4]byte{'T', 'Z', 'S', 'B'}
+
+var (
+	ErrBackupCorrupted = errors.New("backup corrupted")
+	ErrBackupBadPass   = errors.New("bad backup passphrase or corrupted backup")
+	ErrStoreNotEmpty   = errors.New("store already initialized")
+)
+
+// BackupFile is one store file, path relative to the store base ("/"-separated).
+type BackupFile struct {
+	Path string
+	Mode fs.FileMode
+	Data []byte
+}
+
+// BackupBundle is a snapshot of every file the store needs: master.json,
+// seed.bin and each key's meta, bundle and watermark files. Temporary files
+// are never included. Watermark files are read as-is; their double buffer
+// keeps a concurrent write from producing an unreadable copy.
+type BackupBundle struct {
+	Created time.Time
+	Files   []BackupFile
+}
+
+// NewBackupBundle collects the store contents.
+func NewBackupBundle(store *FileStore) (*BackupBundle, error) {
+	store.masterMu.Lock()
+	defer store.masterMu.Unlock()
+
+	b := &BackupBundle{Created: time.Now().UTC().Truncate(time.Second)}
+	add := func(rel string, required bool) error {
+		data, err := os.ReadFile(filepath.Join(store.base, filepath.FromSlash(rel)))
+		if err != nil {
+			if !required && errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("backup %s: %w", rel, err)
+		}
+		b.Files = append(b.Files, BackupFile{Path: rel, Mode: 0o600, Data: data})
+		return nil
+	}
+
+	if err := add(masterFileName, true); err != nil {
+		return nil, err
+	}
+	if err := add(seedFileName, false); err != nil {
+		return nil, err
+	}
+
+	ids, err := store.list()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, id := range ids {
+		dir := path.Join(keysDirName, id)
+		if err := add(path.Join(dir, keyMetaFileName), true); err != nil {
+			return nil, err
+		}
+		if err := add(path.Join(dir, keyBinFileName), true); err != nil {
+			return nil, err
+		}
+		if err := add(path.Join(dir, keyStateFileName), false); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Seal encrypts the bundle with a key derived from passphrase (Argon2id with
+// the store defaults and a fresh salt). The header is bound as AAD.
+func (b *BackupBundle) Seal(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backup passphrase required")
+	}
+
+	plain, err := b.encodeFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer secure.MemoryWipe(plain)
+
+	params := defaultArgon2Params
+	salt := randBytes(16)
+	nonce := randBytes(12)
+
+	header := make([]byte, backupHeaderSize)
+	off := copy(header, backupMagic[:])
+	binary.BigEndian.PutUint16(header[off:], backupVersion)
+	off += 2
+	binary.BigEndian.PutUint32(header[off:], params.Time)
+	off += 4
+	binary.BigEndian.PutUint32(header[off:], params.Memory)
+	off += 4
+	header[off] = params.Threads
+	off++
+	off += copy(header[off:], salt)
+	off += copy(header[off:], nonce)
+	binary.BigEndian.PutUint64(header[off:], uint64(b.Created.Unix()))
+	off += 8
+	binary.BigEndian.PutUint64(header[off:], uint64(len(plain)))
+	off += 8
+	binary.BigEndian.PutUint32(header[off:], crc32.ChecksumIEEE(header[:off]))
+
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	defer secure.MemoryWipe(key)
+	gcm, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(header, nonce, plain, header), nil
+}
+
+// OpenBackupBundle verifies and decrypts a sealed bundle.
+func OpenBackupBundle(blob, passphrase []byte) (*BackupBundle, error) {
+	if len(blob) < backupHeaderSize {
+		return nil, fmt.Errorf("%w: short header", ErrBackupCorrupted)
+	}
+	header := blob[:backupHeaderSize]
+	crcOff := backupHeaderSize - 4
+	if crc32.ChecksumIEEE(header[:crcOff]) != binary.BigEndian.Uint32(header[crcOff:]) {
+		return nil, fmt.Errorf("%w: header checksum", ErrBackupCorrupted)
+	}
+	if !bytes.Equal(header[:4], backupMagic[:]) {
+		return nil, fmt.Errorf("%w: magic", ErrBackupCorrupted)
+	}
+
+	off := 4
+	if v := binary.BigEndian.Uint16(header[off:]); v != backupVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBackupCorrupted, v)
+	}
+	off += 2
+	params := argon2Params{KeyLen: 32}
+	params.Time = binary.BigEndian.Uint32(header[off:])
+	off += 4
+	params.Memory = binary.BigEndian.Uint32(header[off:])
+	off += 4
+	params.Threads = header[off]
+	off++
+	salt := header[off : off+16]
+	off += 16
+	nonce := header[off : off+12]
+	off += 12
+	created := time.Unix(int64(binary.BigEndian.Uint64(header[off:])), 0).UTC()
+	off += 8
+	plainLen := binary.BigEndian.Uint64(header[off:])
+
+	if params.Time == 0 || params.Time > 16 || params.Memory == 0 || params.Memory > 1<<20 || params.Threads == 0 {
+		return nil, fmt.Errorf("%w: kdf params", ErrBackupCorrupted)
+	}
+
+	key := argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	defer secure.MemoryWipe(key)
+	gcm, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, blob[backupHeaderSize:], header)
+	if err != nil {
+		return nil, ErrBackupBadPass
+	}
+	defer secure.MemoryWipe(plain)
+	if uint64(len(plain)) != plainLen {
+		return nil, fmt.Errorf("%w: length", ErrBackupCorrupted)
+	}
+
+	files, err := decodeBackupFiles(plain)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupBundle{Created: created, Files: files}, nil
+}
+
+// Restore writes the bundle into an uninitialized store directory.
+func (b *BackupBundle) Restore(store *FileStore) error {
+	store.masterMu.Lock()
+	defer store.masterMu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(store.base, masterFileName)); err == nil {
+		return ErrStoreNotEmpty
+	}
+	for _, f := range b.Files {
+		if err := validBackupPath(f.Path); err != nil {
+			return err
+		}
+	}
+
+	// files are synced one by one, directories once at the end;
+	// master.json goes last so a partial restore still reads as empty.
+	dirs := map[string]struct{}{}
+	var master *BackupFile
+	for i := range b.Files {
+		f := &b.Files[i]
+		if f.Path == masterFileName {
+			master = f
+			continue
+		}
+		dst := filepath.Join(store.base, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			return err
+		}
+		if err := writeBytesAtomic(dst, f.Data, f.Mode.Perm(), skipDirSync); err != nil {
+			return fmt.Errorf("restore %s: %w", f.Path, err)
+		}
+		// the key dir holds the files, its parent the new key dir entry
+		if dir := filepath.Dir(dst); dir != store.base {
+			dirs[dir] = struct{}{}
+			dirs[filepath.Dir(dir)] = struct{}{}
+		}
+		dirs[store.base] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	if master == nil {
+		return fmt.Errorf("%w: missing %s", ErrBackupCorrupted, masterFileName)
+	}
+	return writeBytesSync(filepath.Join(store.base, masterFileName), master.Data, master.Mode.Perm())
+}
+
+func validBackupPath(p string) error {
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || strings.HasPrefix(p, "../") || p == ".." || strings.HasSuffix(p, tmpSuffix) {
+		return fmt.Errorf("%w: bad path %q", ErrBackupCorrupted, p)
+	}
+	return nil
+}
+
+// entry layout: [u16 path len][path][u32 mode][u32 size][data]
+func (b *BackupBundle) encodeFiles() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, f := range b.Files {
+		if err := validBackupPath(f.Path); err != nil {
+			return nil, err
+		}
+		if len(f.Path) > 0xffff || len(f.Data) > backupMaxFile {
+			return nil, fmt.Errorf("backup entry %s too large", f.Path)
+		}
+		var hdr [2]byte
+		binary.BigEndian.PutUint16(hdr[:], uint16(len(f.Path)))
+		buf.Write(hdr[:])
+		buf.WriteString(f.Path)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(f.Mode.Perm())))
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(f.Data))))
+		buf.Write(f.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeBackupFiles(in []byte) ([]BackupFile, error) {
+	var files []BackupFile
+	for p := 0; p < len(in); {
+		if len(in)-p < 2 {
+			return nil, fmt.Errorf("%w: truncated entry", ErrBackupCorrupted)
+		}
+		pathLen := int(binary.BigEndian.Uint16(in[p:]))
+		p += 2
+		if len(in)-p < pathLen+8 {
+			return nil, fmt.Errorf("%w: truncated entry", ErrBackupCorrupted)
+		}
+		name := string(in[p : p+pathLen])
+		p += pathLen
+		mode := fs.FileMode(binary.BigEndian.Uint32(in[p:])).Perm()
+		p += 4
+		size := int(binary.BigEndian.Uint32(in[p:]))
+		p += 4
+		if size > backupMaxFile || len(in)-p < size {
+			return nil, fmt.Errorf("%w: truncated entry", ErrBackupCorrupted)
+		}
+		if err := validBackupPath(name); err != nil {
+			return nil, err
+		}
+		files = append(files, BackupFile{Path: name, Mode: mode, Data: append([]byte(nil), in[p:p+size]...)})
+		p += size
+	}
+	return files, nil
+}
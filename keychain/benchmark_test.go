@@ -38,11 +38,11 @@ func newBenchmarkSetup(tb testing.TB) *benchmarkSetup {
 	}
 
 	ring := NewKeyRing(log, store)
-	keyID, _, tz4, err := ring.CreateKey("bench", pass)
+	keyID, _, tz4, err := ring.CreateKey("bench", pass, nil)
 	if err != nil {
 		tb.Fatalf("CreateKey: %v", err)
 	}
-	if err := ring.Unlock(keyID, pass); err != nil {
+	if err := ring.Unlock(keyID, pass, nil); err != nil {
 		tb.Fatalf("Unlock: %v", err)
 	}
 
@@ -73,7 +73,7 @@ func BenchmarkStatePersist(b *testing.B) {
 		state := key.keyStateSnapshot()
 		file := key.hwmFile
 		seq := key.hwmSeq
-		dek := append([]byte(nil), key.dek...)
+		dek := append([]byte(nil), key.dek.Bytes()...)
 		tz4 := key.tz4
 		unlock()
 		defer secure.MemoryWipe(dek)
@@ -95,7 +95,7 @@ func BenchmarkStatePersist(b *testing.B) {
 }
 
 func BenchmarkSignAndPersistLocal(b *testing.B) {
-	for _, kind := range allSignKinds {
+	for _, kind := range registeredSignKinds() {
 		kind := kind
 		b.Run(kind.String()+"/double_buffer", func(b *testing.B) {
 			setup := newBenchmarkSetup(b)
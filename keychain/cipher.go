@@ -0,0 +1,72 @@
+package keychain
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite is the AEAD a store uses for DEK wraps, secrets, the seed and
+// key state. It is chosen at init and recorded in master.json.
+type CipherSuite string
+
+const (
+	CipherAESGCM CipherSuite = "aes-256-gcm"
+	// XChaCha20-Poly1305 draws 192-bit random nonces, so there is no
+	// practical nonce collision bound however often the state is rewritten.
+	CipherXChaCha20Poly1305 CipherSuite = "xchacha20-poly1305"
+
+	DefaultCipherSuite = CipherAESGCM
+)
+
+var ErrUnknownCipherSuite = errors.New("unknown cipher suite")
+
+// ParseCipherSuite accepts a suite name; empty selects the default.
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	switch s := CipherSuite(name); s {
+	case "":
+		return DefaultCipherSuite, nil
+	case CipherAESGCM, CipherXChaCha20Poly1305:
+		return s, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownCipherSuite, name)
+	}
+}
+
+func (s CipherSuite) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch s {
+	case CipherAESGCM:
+		return newAESGCM(key)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCipherSuite, string(s))
+	}
+}
+
+func (s CipherSuite) nonceSize() int {
+	if s == CipherXChaCha20Poly1305 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return 12
+}
+
+// suite returns the store's cipher suite; stores from before the choice
+// existed are AES-GCM.
+func (mf *masterFile) suite() CipherSuite {
+	if mf.Cipher == "" {
+		return CipherAESGCM
+	}
+	return mf.Cipher
+}
+
+// CipherSuite reports the suite recorded in master.json.
+func (fs *FileStore) CipherSuite() (CipherSuite, error) {
+	mf, err := fs.readMaster()
+	if err != nil {
+		return "", err
+	}
+	return mf.suite(), nil
+}
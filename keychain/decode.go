@@ -11,6 +11,7 @@ var (
 	errNegativeLevel      = errors.New("negative level")
 	errNegativeRound      = errors.New("negative round")
 	errNegativeFitnessLen = errors.New("negative fitness length")
+	errShortFitness       = errors.New("fitness too short to hold a round")
 )
 
 // decodeSignPayload parses Tenderbake payloads and extracts (kind, level, round).
@@ -21,6 +22,7 @@ var (
 //	0x11 — block
 //	0x12 — preattestation
 //	0x13 — attestation
+//	any kind added with RegisterSignKind
 //
 // Notes:
 //   - We assume tz4 (BLS) keys on the gadget. For Tenderbake attestations with tz4,
@@ -60,6 +62,11 @@ func DecodeAndValidateSignPayload(raw []byte) (SIGN_KIND, uint64, uint32, []byte
 		if fitnessLenI32 < 0 {
 			return UNSPECIFIED, 0, 0, nil, errNegativeFitnessLen
 		}
+		// the round is the fitness's last 4 bytes; a shorter fitness would
+		// have it read from the length field
+		if fitnessLenI32 < 4 {
+			return UNSPECIFIED, 0, 0, nil, errShortFitness
+		}
 
 		// Calculate round offset and check final bounds.
 		roundOff := fitnessOff + int(fitnessLenI32)
@@ -102,7 +109,15 @@ func DecodeAndValidateSignPayload(raw []byte) (SIGN_KIND, uint64, uint32, []byte
 		return ATTESTATION, uint64(levelI32), uint32(roundI32), raw, nil
 
 	default:
-		// This is a cold path; fmt.Errorf is acceptable here for better debug info.
-		return UNSPECIFIED, 0, 0, nil, ErrUnsupportedOperation
+		// Kinds registered through RegisterSignKind bring their own decoder.
+		spec, ok := lookupSignKind(SIGN_KIND(raw[0]))
+		if !ok || spec.Decode == nil {
+			return UNSPECIFIED, 0, 0, nil, ErrUnsupportedOperation
+		}
+		level, round, err := spec.Decode(raw)
+		if err != nil {
+			return UNSPECIFIED, 0, 0, nil, err
+		}
+		return spec.Kind, level, round, raw, nil
 	}
 }
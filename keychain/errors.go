@@ -8,4 +8,16 @@ var (
 	ErrStaleWatermark       = errors.New("stale level/round")
 	ErrBadPayload           = errors.New("bad sign payload")
 	ErrUnsupportedOperation = errors.New("unsupported operation")
+	ErrSignatureSelfCheck   = errors.New("signature failed self-check")
+
+	ErrKeyPassphraseRequired = errors.New("key passphrase required")
+	ErrInvalidTag            = errors.New("invalid tag")
+
+	// Told apart by the KEK check value in master.json (stores created or
+	// unlocked since it was added).
+	ErrBadPassphrase  = errors.New("bad master passphrase")
+	ErrStoreCorrupted = errors.New("store corrupted")
+
+	ErrInvalidDerivationPath   = errors.New("invalid derivation path")
+	ErrDerivationPathNeedsSeed = errors.New("derivation path requires a deterministic (seeded) store")
 )
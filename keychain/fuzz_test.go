@@ -0,0 +1,184 @@
+package keychain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// The fuzz targets here cover the payloads a baker (or whoever reaches the
+// signer) sends for signing. A failing input is written to
+// testdata/fuzz/<target>, where plain `go test` replays it; keep it there as
+// a regression case once fixed.
+
+// referenceLevelRound reads kind, level and round out of a Tenderbake payload
+// the way the protocol lays it out, independently of
+// DecodeAndValidateSignPayload: the block round is the last element of the
+// fitness, which must be there to be read.
+func referenceLevelRound(raw []byte) (kind SIGN_KIND, level uint64, round uint32, ok bool) {
+	i32 := func(off int) (uint32, bool) {
+		if off < 0 || off+4 > len(raw) {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(raw[off:])
+		return v, v <= math.MaxInt32
+	}
+	if len(raw) == 0 {
+		return UNSPECIFIED, 0, 0, false
+	}
+	switch raw[0] {
+	case 0x11:
+		lvl, ok1 := i32(1 + 4)
+		fitnessLen, ok2 := i32(1 + 4 + 4 + 1 + 32 + 8 + 1 + 32)
+		fitnessEnd := 1 + 4 + 4 + 1 + 32 + 8 + 1 + 32 + 4 + int(fitnessLen)
+		if !ok1 || !ok2 || fitnessLen < 4 || fitnessEnd > len(raw) {
+			return UNSPECIFIED, 0, 0, false
+		}
+		r, ok3 := i32(fitnessEnd - 4)
+		return BLOCK, uint64(lvl), r, ok3
+	case 0x12, 0x13:
+		lvl, ok1 := i32(1 + 4 + 32 + 1)
+		r, ok2 := i32(1 + 4 + 32 + 1 + 4)
+		if raw[0] == 0x12 {
+			return PREATTESTATION, uint64(lvl), r, ok1 && ok2
+		}
+		return ATTESTATION, uint64(lvl), r, ok1 && ok2
+	}
+	return UNSPECIFIED, 0, 0, false
+}
+
+func FuzzDecodeAndValidateSignPayload(f *testing.F) {
+	for _, kind := range []SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION} {
+		f.Add(buildBenchmarkPayload(kind, 42, 1))
+	}
+	f.Add(fullBlock(7, 7, 2))
+	f.Add([]byte{})
+	f.Add([]byte{0x11, 0, 0, 0, 0})
+	f.Add([]byte{0x42})
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		kind, level, round, signBytes, err := DecodeAndValidateSignPayload(raw)
+		refKind, refLevel, refRound, refOK := referenceLevelRound(raw)
+		if err != nil {
+			if refOK {
+				t.Fatalf("refused %s %d/%d: %v", refKind, refLevel, refRound, err)
+			}
+			return
+		}
+		// the signature covers exactly what was watermarked
+		if !bytes.Equal(signBytes, raw) {
+			t.Fatalf("signs %x for payload %x", signBytes, raw)
+		}
+		if level > math.MaxInt32 || round > math.MaxInt32 {
+			t.Fatalf("level %d round %d beyond int32", level, round)
+		}
+		if _, ok := lookupSignKind(kind); !ok {
+			t.Fatalf("decoded unregistered kind %d", kind)
+		}
+		if raw[0] < 0x11 || raw[0] > 0x13 {
+			return // a registered kind, with its own decoder
+		}
+		if !refOK {
+			t.Fatalf("watermarked %s %d/%d from a payload the protocol does not lay out that way", kind, level, round)
+		}
+		if kind != refKind || level != refLevel || round != refRound {
+			t.Fatalf("decoded %s %d/%d, payload says %s %d/%d", kind, level, round, refKind, refLevel, refRound)
+		}
+	})
+}
+
+// signOp is one request of FuzzSignWatermarks, encoded in signOpLen bytes:
+// kind, level, round, then a byte to flip with a mask, so the fuzzer can
+// both walk watermarks and bend payloads.
+const signOpLen = 5
+
+type signOp struct {
+	kind         SIGN_KIND
+	level        uint64
+	round        uint32
+	flipAt, mask byte
+}
+
+func decodeSignOps(data []byte) []signOp {
+	ops := make([]signOp, 0, len(data)/signOpLen)
+	for ; len(data) >= signOpLen; data = data[signOpLen:] {
+		ops = append(ops, signOp{
+			kind:   []SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION}[data[0]%3],
+			level:  uint64(data[1]),
+			round:  uint32(data[2] % 4),
+			flipAt: data[3],
+			mask:   data[4],
+		})
+	}
+	return ops
+}
+
+func (op signOp) payload() []byte {
+	var raw []byte
+	if op.kind == BLOCK {
+		raw = fullBlock(op.level, op.level, op.round)
+	} else {
+		raw = buildBenchmarkPayload(op.kind, op.level, op.round)
+	}
+	raw[int(op.flipAt)%len(raw)] ^= op.mask
+	return raw
+}
+
+func (op signOp) String() string {
+	return fmt.Sprintf("%s %d/%d flip %d^%#x", op.kind, op.level, op.round, op.flipAt, op.mask)
+}
+
+// FuzzSignWatermarks replays a run of sign requests against an unlocked key
+// that starts from fresh watermarks and checks every answer against a model
+// of the watermarks: a signature for a kind, level and round that does not
+// strictly advance the last one signed is a watermark bypass.
+func FuzzSignWatermarks(f *testing.F) {
+	setup := newBenchmarkSetup(f)
+	f.Add([]byte{2, 10, 0, 0, 0, 2, 10, 0, 0, 0, 2, 9, 3, 0, 0, 2, 10, 1, 0, 0})
+	f.Add([]byte{0, 5, 1, 0, 0, 1, 5, 1, 0, 0, 2, 5, 1, 0, 0, 0, 5, 1, 0, 0})
+	// a level byte bent to a negative int32
+	f.Add([]byte{2, 10, 0, 0, 0, 2, 10, 0, 38, 0x80})
+	// a block whose fitness length is bent
+	f.Add([]byte{0, 10, 0, 0, 0, 0, 11, 0, 86, 0x22})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops := decodeSignOps(data)
+		if len(ops) > 32 {
+			ops = ops[:32]
+		}
+		unlock := setup.key.lock()
+		setup.key.resetWatermarks()
+		unlock()
+
+		model := map[SIGN_KIND]HighWatermark{}
+		for i, op := range ops {
+			raw := op.payload()
+			_, err := setup.ring.SignAndUpdate(setup.tz4, raw)
+			kind, level, round, ok := referenceLevelRound(raw)
+			next := HighWatermark{level: level, round: round}
+			switch {
+			case err == nil && !ok:
+				t.Fatalf("op %d (%s): signed a payload without a readable level and round; ops %v", i, op, ops)
+			case err == nil && !LevelRoundIncreasing(model[kind], next):
+				t.Fatalf("op %d (%s): signed %s %d/%d at watermark %d/%d; ops %v", i, op, kind, level, round, model[kind].level, model[kind].round, ops)
+			case err == nil:
+				model[kind] = next
+			case errors.Is(err, ErrStaleWatermark) && ok && LevelRoundIncreasing(model[kind], next):
+				t.Fatalf("op %d (%s): refused %s %d/%d as stale at watermark %d/%d; ops %v", i, op, kind, level, round, model[kind].level, model[kind].round, ops)
+			case !errors.Is(err, ErrStaleWatermark) && !errors.Is(err, ErrBadPayload):
+				t.Fatalf("op %d (%s): %v", i, op, err)
+			}
+		}
+
+		unlock = setup.key.lock()
+		defer unlock()
+		for _, kind := range []SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION} {
+			if got := setup.key.watermark[kind]; got != model[kind] {
+				t.Fatalf("%s watermark %d/%d, model %d/%d; ops %v", kind, got.level, got.round, model[kind].level, model[kind].round, ops)
+			}
+		}
+	})
+}
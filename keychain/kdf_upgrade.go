@@ -0,0 +1,252 @@
+package keychain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tez-capital/tezsign/secure"
+)
+
+// KDFParams are the master Argon2id parameters as reported to clients.
+type KDFParams struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+type KDFStatus struct {
+	Current     KDFParams
+	Recommended KDFParams
+	// Weak is set when Current is cheaper than Recommended.
+	Weak bool
+	// Pending is set when an interrupted upgrade still has files to fold in;
+	// the store works as is and UpgradeKDF completes it.
+	Pending bool
+}
+
+func (p argon2Params) public() KDFParams {
+	return KDFParams{Time: p.Time, MemoryKiB: p.Memory, Threads: p.Threads}
+}
+
+func (p argon2Params) weakerThan(want argon2Params) bool {
+	return p.Time < want.Time || p.Memory < want.Memory || p.KeyLen < want.KeyLen
+}
+
+func (kr *KeyRing) KDFStatus() (KDFStatus, error) {
+	mf, err := kr.store.readMaster()
+	if err != nil {
+		return KDFStatus{}, err
+	}
+	st := KDFStatus{
+		Current:     mf.Params.public(),
+		Recommended: defaultArgon2Params.public(),
+		Weak:        mf.Params.weakerThan(defaultArgon2Params),
+		Pending:     mf.UpgradeSeed != nil,
+	}
+	if !st.Pending {
+		ids, err := kr.store.list()
+		if err != nil {
+			return KDFStatus{}, err
+		}
+		for _, id := range ids {
+			if meta, err := kr.store.readKeyMeta(id); err == nil && meta.UpgradeWrap != nil {
+				st.Pending = true
+				break
+			}
+		}
+	}
+	return st, nil
+}
+
+// UpgradeKDF re-derives the master KEK with the recommended parameters and a
+// fresh salt, then re-wraps every DEK and the seed. Unlocked keys keep
+// signing: their DEKs do not change. Watermark snapshots exported before the
+// upgrade no longer verify (their MAC key comes from the old KEK).
+//
+// Every step is crash safe: new wraps are staged in meta.json first, the
+// master.json rewrite is the commit point, and the staged data is folded in
+// afterwards (again by the next call if interrupted).
+func (kr *KeyRing) UpgradeKDF(masterPassword []byte) (upgraded bool, err error) {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	upgraded, err = kr.store.upgradeKDF(masterPassword, defaultArgon2Params)
+	if err != nil {
+		return false, err
+	}
+	kr.log.Info("master KDF upgrade", "upgraded", upgraded,
+		"time", defaultArgon2Params.Time, "memory_kib", defaultArgon2Params.Memory, "threads", defaultArgon2Params.Threads)
+	return upgraded, nil
+}
+
+func (fs *FileStore) upgradeKDF(masterPassword []byte, next argon2Params) (bool, error) {
+	fs.masterMu.Lock()
+	defer fs.masterMu.Unlock()
+
+	mf, err := fs.readMaster()
+	if err != nil {
+		return false, err
+	}
+	if err := fs.finishKDFUpgradeLocked(mf); err != nil {
+		return false, err
+	}
+	if !mf.Params.weakerThan(next) {
+		return false, nil
+	}
+
+	release := fs.openKEKCache(masterPassword)
+	defer release()
+
+	// the seed doubles as the password check
+	enabled, seed, err := fs.readSeed(masterPassword)
+	if err != nil {
+		return false, err
+	}
+	defer seed.Close()
+
+	oldKEK, _, err := fs.deriveKEK(masterPassword)
+	if err != nil {
+		return false, err
+	}
+	defer oldKEK.Close()
+	suite := mf.suite()
+	oldGCM, err := suite.newAEAD(oldKEK.Bytes())
+	if err != nil {
+		return false, err
+	}
+
+	upgraded := *mf
+	upgraded.Salt = randBytes(16)
+	upgraded.Params = next
+	upgraded.HDSalt = mf.hdSalt()
+
+	newKEK, err := secure.SecretBufferFrom(masterKDF(masterPassword, upgraded.Salt, next.Time, next.Memory, next.Threads, next.KeyLen))
+	if err != nil {
+		return false, err
+	}
+	defer newKEK.Close()
+	newGCM, err := suite.newAEAD(newKEK.Bytes())
+	if err != nil {
+		return false, err
+	}
+	upgraded.KEKCheck = kekCheckValue(newKEK.Bytes())
+
+	// unwrap everything before touching disk, so a bad key aborts cleanly
+	ids, err := fs.list()
+	if err != nil {
+		return false, err
+	}
+	wraps := make(map[string]*keyWrap, len(ids))
+	for _, id := range ids {
+		meta, err := fs.readKeyMeta(id)
+		if err != nil {
+			return false, err
+		}
+		raw, err := os.ReadFile(fs.keyBinPath(id))
+		if err != nil {
+			return false, err
+		}
+		bundle, err := decodeBundle(raw)
+		if err != nil {
+			return false, fmt.Errorf("key %s: %w", id, err)
+		}
+
+		aad := []byte("id=" + id + "|tz4=" + meta.TZ4)
+		wrapNonce, wrappedDEK := meta.masterWrap(mf, bundle)
+		inner, err := openSecret(oldGCM, wrapNonce, wrappedDEK, aad)
+		if err != nil {
+			return false, fmt.Errorf("key %s: cannot unwrap under current KEK", id)
+		}
+		nonce := randBytes(suite.nonceSize())
+		wraps[id] = &keyWrap{Salt: upgraded.Salt, Nonce: nonce, WrappedDEK: newGCM.Seal(nil, nonce, inner.Bytes(), aad)}
+		inner.Close()
+	}
+
+	seedNonce := randBytes(suite.nonceSize())
+	seedOut := make([]byte, 0, 1+len(seedNonce)+32+16)
+	if enabled {
+		seedOut = append(seedOut, 0x01)
+	} else {
+		seedOut = append(seedOut, 0x00)
+	}
+	seedOut = append(seedOut, seedNonce...)
+	upgraded.UpgradeSeed = newGCM.Seal(seedOut, seedNonce, seed.Bytes(), masterAAD(&upgraded))
+
+	// stage
+	for _, id := range ids {
+		err := fs.updateKeyMeta(id, func(meta *keyMeta) error {
+			meta.UpgradeWrap = wraps[id]
+			if meta.hasKeyPassphrase() && meta.KeyKDFParams == nil {
+				p := mf.Params
+				meta.KeyKDFParams = &p
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// commit
+	if err := writeJSONSync(filepath.Join(fs.base, masterFileName), &upgraded, 0o600); err != nil {
+		return false, err
+	}
+	return true, fs.finishKDFUpgradeLocked(&upgraded)
+}
+
+// finishKDFUpgradeLocked folds staged upgrade data into the regular files and
+// drops staging left behind by an upgrade that never committed.
+func (fs *FileStore) finishKDFUpgradeLocked(mf *masterFile) error {
+	if mf.UpgradeSeed != nil {
+		if err := writeBytesSync(filepath.Join(fs.base, seedFileName), mf.UpgradeSeed, 0o600); err != nil {
+			return err
+		}
+		mf.UpgradeSeed = nil
+		if err := writeJSONSync(filepath.Join(fs.base, masterFileName), mf, 0o600); err != nil {
+			return err
+		}
+	}
+
+	ids, err := fs.list()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		meta, err := fs.readKeyMeta(id)
+		if err != nil {
+			return err
+		}
+		w := meta.UpgradeWrap
+		if w == nil {
+			continue
+		}
+		if bytes.Equal(w.Salt, mf.Salt) {
+			// bundle first: until meta drops UpgradeWrap, it still wins
+			raw, err := os.ReadFile(fs.keyBinPath(id))
+			if err != nil {
+				return err
+			}
+			bundle, err := decodeBundle(raw)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", id, err)
+			}
+			bundle.WrappedDEK = w.WrappedDEK
+			if err := writeBytesSync(fs.keyBinPath(id), encodeBundle(bundle), 0o600); err != nil {
+				return err
+			}
+		}
+		err = fs.updateKeyMeta(id, func(meta *keyMeta) error {
+			if bytes.Equal(meta.UpgradeWrap.Salt, mf.Salt) {
+				meta.WrapNonce = meta.UpgradeWrap.Nonce
+			}
+			meta.UpgradeWrap = nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package keychain
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"slices"
+
+	"github.com/tez-capital/tezsign/secure"
+	"golang.org/x/crypto/argon2"
+)
+
+// masterKDF is the Argon2id call behind every master KEK; tests swap it to
+// count derivations.
+var masterKDF = argon2.IDKey
+
+// kekCache holds one derived master KEK for the duration of a batch. It only
+// serves the password it was opened with, and only for unchanged master
+// params, so a concurrent caller with another password still pays (and is
+// checked by) the full derivation.
+type kekCache struct {
+	password []byte
+	salt     []byte
+	params   argon2Params
+	kek      *secure.SecretBuffer
+}
+
+func (c *kekCache) matches(password, salt []byte, params argon2Params) bool {
+	return c.kek != nil &&
+		subtle.ConstantTimeCompare(c.password, password) == 1 &&
+		bytes.Equal(c.salt, salt) &&
+		c.params == params
+}
+
+func (c *kekCache) wipe() {
+	secure.MemoryWipe(c.password)
+	_ = c.kek.Close()
+	c.password, c.kek = nil, nil
+}
+
+// WithCachedKEK runs fn with master KEK derivations for masterPassword done at
+// most once; the cached KEK is wiped when fn returns. Use it around loops
+// that create or unlock several keys with the same passphrase.
+func (kr *KeyRing) WithCachedKEK(masterPassword []byte, fn func()) {
+	release := kr.store.openKEKCache(masterPassword)
+	defer release()
+	fn()
+}
+
+func (fs *FileStore) openKEKCache(masterPassword []byte) (release func()) {
+	fs.kekMu.Lock()
+	defer fs.kekMu.Unlock()
+
+	if fs.kekCache != nil {
+		// nested scope: the outer one owns the cache
+		return func() {}
+	}
+	fs.kekCache = &kekCache{password: slices.Clone(masterPassword)}
+	return func() {
+		fs.kekMu.Lock()
+		defer fs.kekMu.Unlock()
+		fs.kekCache.wipe()
+		fs.kekCache = nil
+	}
+}
+
+// cachedKEK returns a copy of the cached KEK, or nil on a miss.
+func (fs *FileStore) cachedKEK(masterPassword []byte, mf *masterFile) *secure.SecretBuffer {
+	fs.kekMu.Lock()
+	defer fs.kekMu.Unlock()
+
+	if fs.kekCache == nil || !fs.kekCache.matches(masterPassword, mf.Salt, mf.Params) {
+		return nil
+	}
+	kek, err := fs.kekCache.kek.Clone()
+	if err != nil {
+		return nil
+	}
+	return kek
+}
+
+func (fs *FileStore) storeKEK(masterPassword []byte, mf *masterFile, kek *secure.SecretBuffer) {
+	fs.kekMu.Lock()
+	defer fs.kekMu.Unlock()
+
+	c := fs.kekCache
+	if c == nil || c.kek != nil || subtle.ConstantTimeCompare(c.password, masterPassword) != 1 {
+		return
+	}
+	cached, err := kek.Clone()
+	if err != nil {
+		return
+	}
+	c.salt = slices.Clone(mf.Salt)
+	c.params = mf.Params
+	c.kek = cached
+}
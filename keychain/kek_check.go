@@ -0,0 +1,64 @@
+package keychain
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"path/filepath"
+)
+
+// The check value is a truncated HMAC of the master KEK, kept in master.json.
+// It says nothing the seed ciphertext does not already (both confirm a
+// passphrase guess), but it is checked before any unwrap, so a failed unwrap
+// afterwards means damage on disk rather than a typo.
+const (
+	kekCheckLabel = "tezsign/kek-check/v1"
+	kekCheckSize  = 16
+)
+
+func kekCheckValue(kek []byte) []byte {
+	mac := hmac.New(sha256.New, kek)
+	mac.Write([]byte(kekCheckLabel))
+	return mac.Sum(nil)[:kekCheckSize]
+}
+
+// checkKEK returns ErrBadPassphrase when mf carries a check value that kek
+// does not match. Stores without one pass, and keep the ambiguous errors.
+func checkKEK(mf *masterFile, kek []byte) error {
+	if len(mf.KEKCheck) == 0 {
+		return nil
+	}
+	if !hmac.Equal(mf.KEKCheck, kekCheckValue(kek)) {
+		return ErrBadPassphrase
+	}
+	return nil
+}
+
+// recordKEKCheck adds the check value to a store created before it existed,
+// once masterPassword has proven itself by opening something.
+func (fs *FileStore) recordKEKCheck(masterPassword []byte) error {
+	mf, err := fs.readMaster()
+	if err != nil || len(mf.KEKCheck) > 0 {
+		return err
+	}
+	kek, mf, err := fs.deriveKEK(masterPassword)
+	if err != nil {
+		return err
+	}
+	defer kek.Close()
+	return fs.storeKEKCheck(mf.Salt, kek.Bytes())
+}
+
+// storeKEKCheck writes the check value for the KEK derived with salt, unless
+// master.json has moved on (KDF upgrade) or already has one.
+func (fs *FileStore) storeKEKCheck(salt, kek []byte) error {
+	fs.masterMu.Lock()
+	defer fs.masterMu.Unlock()
+
+	mf, err := fs.readMaster()
+	if err != nil || len(mf.KEKCheck) > 0 || !bytes.Equal(mf.Salt, salt) {
+		return err
+	}
+	mf.KEKCheck = kekCheckValue(kek)
+	return writeJSONSync(filepath.Join(fs.base, masterFileName), mf, 0o600)
+}
@@ -8,20 +8,63 @@ import (
 	"hash/crc32"
 	"io"
 	"os"
+	"slices"
 	"syscall"
 
 	"github.com/tez-capital/tezsign/secure"
 )
 
 const (
-	keyStateSlotSize     = 100
-	keyStateSlotDataSize = keyStateSlotSize - 4
-	keyStateFileSize     = 2 * keyStateSlotSize
+	keyStateSlotEntries  = 16
+	keyStateHeaderSize   = 4 + 8 + 1 // magic, seq, kind count
+	keyStateEntrySize    = 1 + 8 + 4 // kind, level, round
+	keyStatePlainSize    = keyStateHeaderSize + keyStateSlotEntries*keyStateEntrySize
 	keyStateNonceSize    = 12
-	keyStatePlainSize    = 68
+	keyStateTagSize      = 16
+	keyStateSlotDataSize = keyStateNonceSize + keyStatePlainSize + keyStateTagSize
+	keyStateSlotSize     = keyStateSlotDataSize + 4 // AES-GCM; see keyStateSlotSizeFor
+	keyStateFileSize     = 2 * keyStateSlotSize
+
+	// The usage trailer (sign count, last used) and the flags byte before it
+	// sit in the tail of the plain slot, which older builds always left zero,
+	// so they cost two kind entries but no format change.
+	keyStateUsageSize   = 8 + 8
+	keyStateUsageOffset = keyStatePlainSize - keyStateUsageSize
+	keyStateFlagsOffset = keyStateUsageOffset - 1
+	keyStateMaxKinds    = (keyStateFlagsOffset - keyStateHeaderSize) / keyStateEntrySize
+
+	keyStateFlagValidity = 1 << 0
+	keyStateFlagPolicy   = 1 << 1
+
+	// HWM1 slots hold a fixed (block, preattestation, attestation) tuple.
+	// They are only read, then migrated to the current layout on open.
+	legacyKeyStateSlotSize  = 100
+	legacyKeyStatePlainSize = 68
+	legacyKeyStateFileSize  = 2 * legacyKeyStateSlotSize
+)
+
+var (
+	keyStateMagic       = [4]byte{'H', 'W', 'M', '2'}
+	legacyKeyStateMagic = [4]byte{'H', 'W', 'M', '1'}
+	legacyKeyStateKinds = [...]SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION}
 )
 
-var keyStateMagic = [4]byte{'H', 'W', 'M', '1'}
+// keyStateSlotSizeFor returns the slot size for a store's cipher suite. Only
+// the nonce length differs, so the suite of a file follows from its size.
+func keyStateSlotSizeFor(suite CipherSuite) int {
+	return suite.nonceSize() + keyStatePlainSize + keyStateTagSize + 4
+}
+
+func keyStateSlotSuite(slotSize int) (CipherSuite, error) {
+	switch slotSize {
+	case keyStateSlotSize, legacyKeyStateSlotSize:
+		return CipherAESGCM, nil
+	case keyStateSlotSizeFor(CipherXChaCha20Poly1305):
+		return CipherXChaCha20Poly1305, nil
+	default:
+		return "", fmt.Errorf("invalid slot size %d", slotSize)
+	}
+}
 
 func newEmptyKeyState() *KeyState {
 	return &KeyState{ByKind: map[int32]*KindState{}}
@@ -29,7 +72,7 @@ func newEmptyKeyState() *KeyState {
 
 func newZeroKeyState() *KeyState {
 	ks := newEmptyKeyState()
-	for _, kind := range allSignKinds {
+	for _, kind := range registeredSignKinds() {
 		ks.ByKind[int32(kind)] = &KindState{}
 	}
 	return ks
@@ -42,50 +85,69 @@ func ensureKeyState(ks *KeyState) *KeyState {
 	return ks
 }
 
-func mergeKeyStates(primary *KeyState, secondary *KeyState) *KeyState {
-	merged := newEmptyKeyState()
-	for _, kind := range allSignKinds {
-		var best HighWatermark
+func validStateKind(kind int32) bool {
+	return kind > int32(UNSPECIFIED) && kind <= 0xff
+}
 
-		if primary != nil && primary.ByKind != nil {
-			if state := primary.ByKind[int32(kind)]; state != nil {
-				best = HighWatermark{level: state.GetLevel(), round: state.GetRound()}
-			}
+// keyStateKinds lists every kind to persist: the registered ones plus any
+// carried in the given states (e.g. read from disk by a newer build).
+func keyStateKinds(states ...*KeyState) []SIGN_KIND {
+	kinds := slices.Clone(registeredSignKinds())
+	for _, ks := range states {
+		if ks == nil {
+			continue
 		}
-
-		if secondary != nil && secondary.ByKind != nil {
-			if state := secondary.ByKind[int32(kind)]; state != nil {
-				candidate := HighWatermark{level: state.GetLevel(), round: state.GetRound()}
-				if candidate.level > best.level || (candidate.level == best.level && candidate.round > best.round) {
-					best = candidate
-				}
+		for kind := range ks.ByKind {
+			if validStateKind(kind) && !slices.Contains(kinds, SIGN_KIND(kind)) {
+				kinds = append(kinds, SIGN_KIND(kind))
 			}
 		}
+	}
+	slices.Sort(kinds)
+	return kinds
+}
 
+func kindWatermark(ks *KeyState, kind SIGN_KIND) HighWatermark {
+	if ks == nil || ks.ByKind == nil {
+		return HighWatermark{}
+	}
+	state := ks.ByKind[int32(kind)]
+	if state == nil {
+		return HighWatermark{}
+	}
+	return HighWatermark{level: state.GetLevel(), round: state.GetRound()}
+}
+
+func mergeKeyStates(primary *KeyState, secondary *KeyState) *KeyState {
+	merged := newEmptyKeyState()
+	for _, kind := range keyStateKinds(primary, secondary) {
+		best := kindWatermark(primary, kind)
+		if candidate := kindWatermark(secondary, kind); LevelRoundIncreasing(best, candidate) {
+			best = candidate
+		}
 		merged.ByKind[int32(kind)] = best.ToKeyState()
 	}
+	merged.SignCount = max(primary.GetSignCount(), secondary.GetSignCount())
+	merged.LastUsedUnix = max(primary.GetLastUsedUnix(), secondary.GetLastUsedUnix())
+	merged.HasValidity = primary.GetHasValidity() || secondary.GetHasValidity()
+	merged.HasPolicy = primary.GetHasPolicy() || secondary.GetHasPolicy()
 	return merged
 }
 
 func statePlusOne(base *KeyState) *KeyState {
 	newState := newEmptyKeyState()
 
-	for _, kind := range allSignKinds {
-		var state *KindState
-		if base != nil && base.ByKind != nil {
-			state = base.ByKind[int32(kind)]
-		}
-
-		var level uint64
-		if state != nil {
-			level = state.GetLevel()
-		}
-
+	for _, kind := range keyStateKinds(base) {
 		newState.ByKind[int32(kind)] = &KindState{
-			Level: level + 1,
+			Level: kindWatermark(base, kind).level + 1,
 			Round: 0,
 		}
 	}
+	// the lost slot may have counted one more signature
+	newState.SignCount = base.GetSignCount() + 1
+	newState.LastUsedUnix = base.GetLastUsedUnix()
+	newState.HasValidity = base.GetHasValidity()
+	newState.HasPolicy = base.GetHasPolicy()
 
 	return newState
 }
@@ -99,48 +161,94 @@ func slotIsZero(slot []byte) bool {
 	return true
 }
 
-func encodeKeyStatePlain(dst []byte, ks *KeyState, seq uint64) {
+func encodeKeyStatePlain(dst []byte, ks *KeyState, seq uint64) error {
 	for i := range dst {
 		dst[i] = 0
 	}
 
+	kinds := keyStateKinds(ks)
+	if len(kinds) > keyStateMaxKinds {
+		return fmt.Errorf("%w: %d kinds exceed state slot capacity %d", ErrTooManySignKinds, len(kinds), keyStateMaxKinds)
+	}
+
 	copy(dst[:len(keyStateMagic)], keyStateMagic[:])
 	binary.BigEndian.PutUint64(dst[4:12], seq)
+	dst[12] = byte(len(kinds))
 
-	offset := 12
-	for _, kind := range allSignKinds {
-		var state *KindState
-		if ks != nil && ks.ByKind != nil {
-			state = ks.ByKind[int32(kind)]
-		}
+	offset := keyStateHeaderSize
+	for _, kind := range kinds {
+		hw := kindWatermark(ks, kind)
 
-		var level uint64
-		var round uint32
-		if state != nil {
-			level = state.GetLevel()
-			round = state.GetRound()
-		}
-
-		binary.BigEndian.PutUint64(dst[offset:offset+8], level)
+		dst[offset] = byte(kind)
+		offset++
+		binary.BigEndian.PutUint64(dst[offset:offset+8], hw.level)
 		offset += 8
-		binary.BigEndian.PutUint32(dst[offset:offset+4], round)
+		binary.BigEndian.PutUint32(dst[offset:offset+4], hw.round)
 		offset += 4
 	}
+
+	binary.BigEndian.PutUint64(dst[keyStateUsageOffset:], ks.GetSignCount())
+	binary.BigEndian.PutUint64(dst[keyStateUsageOffset+8:], uint64(ks.GetLastUsedUnix()))
+	if ks.GetHasValidity() {
+		dst[keyStateFlagsOffset] |= keyStateFlagValidity
+	}
+	if ks.GetHasPolicy() {
+		dst[keyStateFlagsOffset] |= keyStateFlagPolicy
+	}
+	return nil
 }
 
 func decodeKeyStatePlain(src []byte) (*KeyState, uint64, error) {
-	if len(src) != keyStatePlainSize {
+	switch {
+	case len(src) == legacyKeyStatePlainSize && string(src[:4]) == string(legacyKeyStateMagic[:]):
+		return decodeLegacyKeyStatePlain(src)
+	case len(src) != keyStatePlainSize:
 		return nil, 0, fmt.Errorf("%w: invalid plain slot size", ErrKeyStateCorrupted)
-	}
-	if string(src[:len(keyStateMagic)]) != string(keyStateMagic[:]) {
+	case string(src[:len(keyStateMagic)]) != string(keyStateMagic[:]):
 		return nil, 0, fmt.Errorf("%w: invalid slot header", ErrKeyStateCorrupted)
 	}
 
+	seq := binary.BigEndian.Uint64(src[4:12])
+	count := int(src[12])
+	if count > keyStateSlotEntries {
+		return nil, 0, fmt.Errorf("%w: invalid kind count", ErrKeyStateCorrupted)
+	}
+
+	ks := newEmptyKeyState()
+	offset := keyStateHeaderSize
+	for range count {
+		kind := int32(src[offset])
+		offset++
+		level := binary.BigEndian.Uint64(src[offset : offset+8])
+		offset += 8
+		round := binary.BigEndian.Uint32(src[offset : offset+4])
+		offset += 4
+
+		if !validStateKind(kind) || ks.ByKind[kind] != nil {
+			return nil, 0, fmt.Errorf("%w: invalid kind entry", ErrKeyStateCorrupted)
+		}
+		ks.ByKind[kind] = &KindState{
+			Level: level,
+			Round: round,
+		}
+	}
+	// slots written with more kinds than fit next to the trailer predate it
+	if count <= keyStateMaxKinds {
+		ks.SignCount = binary.BigEndian.Uint64(src[keyStateUsageOffset:])
+		ks.LastUsedUnix = int64(binary.BigEndian.Uint64(src[keyStateUsageOffset+8:]))
+		ks.HasValidity = src[keyStateFlagsOffset]&keyStateFlagValidity != 0
+		ks.HasPolicy = src[keyStateFlagsOffset]&keyStateFlagPolicy != 0
+	}
+
+	return ks, seq, nil
+}
+
+func decodeLegacyKeyStatePlain(src []byte) (*KeyState, uint64, error) {
 	seq := binary.BigEndian.Uint64(src[4:12])
 	ks := newEmptyKeyState()
 
 	offset := 12
-	for _, kind := range allSignKinds {
+	for _, kind := range legacyKeyStateKinds {
 		level := binary.BigEndian.Uint64(src[offset : offset+8])
 		offset += 8
 		round := binary.BigEndian.Uint32(src[offset : offset+4])
@@ -156,51 +264,58 @@ func decodeKeyStatePlain(src []byte) (*KeyState, uint64, error) {
 }
 
 func encodeKeyStateSlot(slot []byte, dek []byte, id, tz4 string, ks *KeyState, seq uint64) error {
-	if len(slot) != keyStateSlotSize {
+	suite, err := keyStateSlotSuite(len(slot))
+	if err != nil || len(slot) == legacyKeyStateSlotSize {
 		return fmt.Errorf("invalid slot size %d", len(slot))
 	}
 
 	var plain [keyStatePlainSize]byte
-	encodeKeyStatePlain(plain[:], ks, seq)
 	defer secure.MemoryWipe(plain[:])
+	if err := encodeKeyStatePlain(plain[:], ks, seq); err != nil {
+		return err
+	}
 
-	gcm, err := newAESGCM(dek)
+	aead, err := suite.newAEAD(dek)
 	if err != nil {
 		return err
 	}
 
-	payload := slot[:keyStateSlotDataSize]
-	if _, err := io.ReadFull(crypto_rand.Reader, payload[:keyStateNonceSize]); err != nil {
+	dataSize := len(slot) - 4
+	nonceSize := aead.NonceSize()
+	payload := slot[:dataSize]
+	if _, err := io.ReadFull(crypto_rand.Reader, payload[:nonceSize]); err != nil {
 		return err
 	}
 
 	aad := []byte("state|id=" + id + "|tz4=" + tz4)
-	sealed := gcm.Seal(payload[:keyStateNonceSize], payload[:keyStateNonceSize], plain[:], aad)
-	if len(sealed) != keyStateSlotDataSize {
+	sealed := aead.Seal(payload[:nonceSize], payload[:nonceSize], plain[:], aad)
+	if len(sealed) != dataSize {
 		return fmt.Errorf("invalid sealed slot size %d", len(sealed))
 	}
 
 	checksum := crc32.ChecksumIEEE(payload)
-	binary.BigEndian.PutUint32(slot[keyStateSlotDataSize:], checksum)
+	binary.BigEndian.PutUint32(slot[dataSize:], checksum)
 	return nil
 }
 
 func decodeKeyStateSlot(slot []byte, dek []byte, id, tz4 string) (*KeyState, uint64, bool, error) {
-	if len(slot) != keyStateSlotSize {
-		return nil, 0, false, fmt.Errorf("invalid slot size %d", len(slot))
+	suite, err := keyStateSlotSuite(len(slot))
+	if err != nil {
+		return nil, 0, false, err
 	}
 	if slotIsZero(slot) {
 		return newZeroKeyState(), 0, true, nil
 	}
 
-	payload := slot[:keyStateSlotDataSize]
-	wantChecksum := binary.BigEndian.Uint32(slot[keyStateSlotDataSize:])
+	dataSize := len(slot) - 4
+	payload := slot[:dataSize]
+	wantChecksum := binary.BigEndian.Uint32(slot[dataSize:])
 	gotChecksum := crc32.ChecksumIEEE(payload)
 	if gotChecksum != wantChecksum {
 		return nil, 0, false, fmt.Errorf("%w: checksum", ErrKeyStateCorrupted)
 	}
 
-	gcm, err := newAESGCM(dek)
+	aead, err := suite.newAEAD(dek)
 	if err != nil {
 		return nil, 0, false, err
 	}
@@ -209,7 +324,8 @@ func decodeKeyStateSlot(slot []byte, dek []byte, id, tz4 string) (*KeyState, uin
 	defer secure.MemoryWipe(plain[:])
 
 	aad := []byte("state|id=" + id + "|tz4=" + tz4)
-	out, err := gcm.Open(plain[:0], payload[:keyStateNonceSize], payload[keyStateNonceSize:], aad)
+	nonceSize := aead.NonceSize()
+	out, err := aead.Open(plain[:0], payload[:nonceSize], payload[nonceSize:], aad)
 	if err != nil {
 		return nil, 0, false, fmt.Errorf("%w: decrypt", ErrKeyStateCorrupted)
 	}
@@ -222,33 +338,33 @@ func decodeKeyStateSlot(slot []byte, dek []byte, id, tz4 string) (*KeyState, uin
 	return ks, seq, false, nil
 }
 
-func writeMirroredKeyState(file *os.File, dek []byte, id, tz4 string, ks *KeyState, seq uint64) error {
-	var slot [keyStateSlotSize]byte
-	if err := encodeKeyStateSlot(slot[:], dek, id, tz4, ks, seq); err != nil {
+func writeMirroredKeyState(file *os.File, slotSize int, dek []byte, id, tz4 string, ks *KeyState, seq uint64) error {
+	slot := make([]byte, slotSize)
+	if err := encodeKeyStateSlot(slot, dek, id, tz4, ks, seq); err != nil {
 		return err
 	}
-	if _, err := file.WriteAt(slot[:], 0); err != nil {
+	if _, err := file.WriteAt(slot, 0); err != nil {
 		return err
 	}
-	if _, err := file.WriteAt(slot[:], keyStateSlotSize); err != nil {
+	if _, err := file.WriteAt(slot, int64(slotSize)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func readDoubleBufferKeyState(reader io.ReaderAt, dek []byte, id, tz4 string) (*KeyState, uint64, bool, bool, error) {
-	var slotA [keyStateSlotSize]byte
-	if _, err := reader.ReadAt(slotA[:], 0); err != nil {
+func readDoubleBufferKeyStateSlots(reader io.ReaderAt, slotSize int, dek []byte, id, tz4 string) (*KeyState, uint64, bool, bool, error) {
+	slotA := make([]byte, slotSize)
+	if _, err := reader.ReadAt(slotA, 0); err != nil {
 		return nil, 0, false, false, fmt.Errorf("%w: read slot A", ErrKeyStateCorrupted)
 	}
 
-	var slotB [keyStateSlotSize]byte
-	if _, err := reader.ReadAt(slotB[:], keyStateSlotSize); err != nil {
+	slotB := make([]byte, slotSize)
+	if _, err := reader.ReadAt(slotB, int64(slotSize)); err != nil {
 		return nil, 0, false, false, fmt.Errorf("%w: read slot B", ErrKeyStateCorrupted)
 	}
 
-	stateA, seqA, missingA, errA := decodeKeyStateSlot(slotA[:], dek, id, tz4)
-	stateB, seqB, missingB, errB := decodeKeyStateSlot(slotB[:], dek, id, tz4)
+	stateA, seqA, missingA, errA := decodeKeyStateSlot(slotA, dek, id, tz4)
+	stateB, seqB, missingB, errB := decodeKeyStateSlot(slotB, dek, id, tz4)
 
 	missingAll := missingA && missingB
 	corrupted := errors.Is(errA, ErrKeyStateCorrupted) || errors.Is(errB, ErrKeyStateCorrupted)
@@ -299,14 +415,50 @@ func readKeyHWMState(path string, dek []byte, id, tz4 string) (*KeyState, uint64
 	}
 	defer file.Close()
 
-	return readDoubleBufferKeyState(file, dek, id, tz4)
+	slotSize := keyStateSlotSize
+	if info, err := file.Stat(); err == nil {
+		switch xSlot := int64(keyStateSlotSizeFor(CipherXChaCha20Poly1305)); info.Size() {
+		case legacyKeyStateFileSize:
+			slotSize = legacyKeyStateSlotSize
+		case 2 * xSlot:
+			slotSize = int(xSlot)
+		}
+	}
+	return readDoubleBufferKeyStateSlots(file, slotSize, dek, id, tz4)
 }
 
-func openKeyHWMFile(path string, dek []byte, id, tz4 string) (*keyHWMFile, *KeyState, uint64, bool, error) {
+// migrateLegacyKeyHWMFile atomically replaces an HWM1 file with a mirrored
+// file in the current layout, so a crash mid-way leaves one of the two intact.
+func migrateLegacyKeyHWMFile(path string, suite CipherSuite, dek []byte, id, tz4 string, ks *KeyState, seq uint64) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != legacyKeyStateFileSize {
+		return seq, nil
+	}
+
+	if seq == 0 {
+		seq = 1
+	}
+	slotSize := keyStateSlotSizeFor(suite)
+	buf := make([]byte, 2*slotSize)
+	if err := encodeKeyStateSlot(buf[:slotSize], dek, id, tz4, ks, seq); err != nil {
+		return 0, err
+	}
+	copy(buf[slotSize:], buf[:slotSize])
+	return seq, writeBytesSync(path, buf, 0o600)
+}
+
+// openKeyHWMFile opens (creating or migrating as needed) the state file in
+// the slot layout of the store's cipher suite.
+func openKeyHWMFile(path string, suite CipherSuite, dek []byte, id, tz4 string) (*keyHWMFile, *KeyState, uint64, bool, error) {
 	ks, seq, missing, corrupted, err := readKeyHWMState(path, dek, id, tz4)
 	if err != nil {
 		return nil, nil, 0, corrupted, err
 	}
+	if !missing {
+		if seq, err = migrateLegacyKeyHWMFile(path, suite, dek, id, tz4, ks, seq); err != nil {
+			return nil, nil, 0, corrupted, err
+		}
+	}
 
 	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_SYNC, 0o600)
 	if err != nil {
@@ -317,8 +469,9 @@ func openKeyHWMFile(path string, dek []byte, id, tz4 string) (*keyHWMFile, *KeyS
 		file.Close()
 		return nil, nil, 0, corrupted, e
 	}
+	slotSize := keyStateSlotSizeFor(suite)
 	finish := func(nextSlot int, outKS *KeyState, outSeq uint64) (*keyHWMFile, *KeyState, uint64, bool, error) {
-		return newKeyHWMFile(file, nextSlot), outKS, outSeq, corrupted, nil
+		return newKeyHWMFile(file, slotSize, nextSlot), outKS, outSeq, corrupted, nil
 	}
 
 	needsInit := false
@@ -327,23 +480,23 @@ func openKeyHWMFile(path string, dek []byte, id, tz4 string) (*keyHWMFile, *KeyS
 		if err != nil {
 			return fail(err)
 		}
-		needsInit = info.Size() != keyStateFileSize
+		needsInit = info.Size() != int64(2*slotSize)
 	} else {
 		needsInit = true
 	}
 
 	if !needsInit {
-		nextSlot := chooseNextKeyHWMFileSlot(file, dek, id, tz4)
+		nextSlot := chooseNextKeyHWMFileSlot(file, slotSize, dek, id, tz4)
 		return finish(nextSlot, ks, seq)
 	}
 
-	if err := file.Truncate(keyStateFileSize); err != nil {
+	if err := file.Truncate(int64(2 * slotSize)); err != nil {
 		return fail(err)
 	}
 
 	ks = ensureKeyState(ks)
 	if missing {
-		if err := writeMirroredKeyState(file, dek, id, tz4, ks, 0); err != nil {
+		if err := writeMirroredKeyState(file, slotSize, dek, id, tz4, ks, 0); err != nil {
 			return fail(err)
 		}
 		if err := file.Sync(); err != nil {
@@ -358,7 +511,10 @@ func openKeyHWMFile(path string, dek []byte, id, tz4 string) (*keyHWMFile, *KeyS
 	if seq == 0 {
 		seq = 1
 	}
-	if err := writeMirroredKeyState(file, dek, id, tz4, ks, seq); err != nil {
+	if err := writeMirroredKeyState(file, slotSize, dek, id, tz4, ks, seq); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
 		return fail(err)
 	}
 	if err := syncParentDir(path); err != nil {
@@ -380,20 +536,22 @@ type keyHWMWriteRequest struct {
 // The worker owns the next slot index and performs the primary persisted
 // write per request before accepting the next one.
 type keyHWMFile struct {
-	file    *os.File
-	workCh  chan keyHWMWriteRequest
-	syncCh  chan struct{}
-	respCh  chan error
-	stopped chan struct{}
+	file     *os.File
+	slotSize int
+	workCh   chan keyHWMWriteRequest
+	syncCh   chan struct{}
+	respCh   chan error
+	stopped  chan struct{}
 }
 
-func newKeyHWMFile(file *os.File, nextSlot int) *keyHWMFile {
+func newKeyHWMFile(file *os.File, slotSize, nextSlot int) *keyHWMFile {
 	hwmFile := &keyHWMFile{
-		file:    file,
-		workCh:  make(chan keyHWMWriteRequest),
-		syncCh:  make(chan struct{}),
-		respCh:  make(chan error, 1),
-		stopped: make(chan struct{}),
+		file:     file,
+		slotSize: slotSize,
+		workCh:   make(chan keyHWMWriteRequest),
+		syncCh:   make(chan struct{}),
+		respCh:   make(chan error, 1),
+		stopped:  make(chan struct{}),
 	}
 	go hwmFile.worker(nextSlot % 2)
 	return hwmFile
@@ -407,13 +565,13 @@ func (file *keyHWMFile) worker(nextSlot int) {
 			if !ok {
 				return
 			}
-			var slot [keyStateSlotSize]byte
-			if err := encodeKeyStateSlot(slot[:], req.dek, req.id, req.tz4, req.ks, req.seq); err != nil {
+			slot := make([]byte, file.slotSize)
+			if err := encodeKeyStateSlot(slot, req.dek, req.id, req.tz4, req.ks, req.seq); err != nil {
 				file.respCh <- err
 				continue
 			}
-			offset := int64(nextSlot) * keyStateSlotSize
-			_, err := file.file.WriteAt(slot[:], offset)
+			offset := int64(nextSlot) * int64(file.slotSize)
+			_, err := file.file.WriteAt(slot, offset)
 			file.respCh <- err
 			nextSlot ^= 1
 		case <-file.syncCh:
@@ -422,18 +580,18 @@ func (file *keyHWMFile) worker(nextSlot int) {
 	}
 }
 
-func chooseNextKeyHWMFileSlot(reader io.ReaderAt, dek []byte, id, tz4 string) int {
-	var slotA [keyStateSlotSize]byte
-	if _, err := reader.ReadAt(slotA[:], 0); err != nil {
+func chooseNextKeyHWMFileSlot(reader io.ReaderAt, slotSize int, dek []byte, id, tz4 string) int {
+	slotA := make([]byte, slotSize)
+	if _, err := reader.ReadAt(slotA, 0); err != nil {
 		return 0
 	}
-	var slotB [keyStateSlotSize]byte
-	if _, err := reader.ReadAt(slotB[:], keyStateSlotSize); err != nil {
+	slotB := make([]byte, slotSize)
+	if _, err := reader.ReadAt(slotB, int64(slotSize)); err != nil {
 		return 1
 	}
 
-	_, seqA, missingA, errA := decodeKeyStateSlot(slotA[:], dek, id, tz4)
-	_, seqB, missingB, errB := decodeKeyStateSlot(slotB[:], dek, id, tz4)
+	_, seqA, missingA, errA := decodeKeyStateSlot(slotA, dek, id, tz4)
+	_, seqB, missingB, errB := decodeKeyStateSlot(slotB, dek, id, tz4)
 
 	aBad := errA != nil || missingA
 	bBad := errB != nil || missingB
@@ -468,7 +626,7 @@ func (file *keyHWMFile) load(dek []byte, id, tz4 string) (*KeyState, uint64, boo
 	if len(dek) != 32 {
 		return nil, 0, false, false, fmt.Errorf("invalid DEK (len=%d)", len(dek))
 	}
-	return readDoubleBufferKeyState(file.file, dek, id, tz4)
+	return readDoubleBufferKeyStateSlots(file.file, file.slotSize, dek, id, tz4)
 }
 
 func (file *keyHWMFile) waitIdle() {
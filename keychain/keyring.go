@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/tez-capital/tezsign/logging"
 	"github.com/tez-capital/tezsign/secure"
@@ -24,13 +25,14 @@ const (
 	ATTESTATION    SIGN_KIND = 0x13
 )
 
-var allSignKinds = [...]SIGN_KIND{BLOCK, PREATTESTATION, ATTESTATION}
-
 type HighWatermark struct {
 	level uint64
 	round uint32
 }
 
+func (hw HighWatermark) Level() uint64 { return hw.level }
+func (hw HighWatermark) Round() uint32 { return hw.round }
+
 func (hw HighWatermark) ToKeyState() *KindState {
 	return &KindState{
 		Level: hw.level,
@@ -39,16 +41,10 @@ func (hw HighWatermark) ToKeyState() *KindState {
 }
 
 func (sk SIGN_KIND) String() string {
-	switch sk {
-	case BLOCK:
-		return "block"
-	case PREATTESTATION:
-		return "preattestation"
-	case ATTESTATION:
-		return "attestation"
-	default:
-		return "unknown"
+	if spec, ok := lookupSignKind(sk); ok {
+		return spec.Name
 	}
+	return "unknown"
 }
 
 type KeyRing struct {
@@ -67,7 +63,23 @@ func NewKeyRing(log *slog.Logger, store *FileStore) *KeyRing {
 	return &KeyRing{log: log, store: store}
 }
 
-func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey, tz4 string, err error) {
+// CreateKey creates a key under the master passphrase. A non-empty
+// keyPassphrase adds a second, per-key wrap that Unlock will then require.
+func (kr *KeyRing) CreateKey(wanted string, masterPassword, keyPassphrase []byte) (id, blPubkey, tz4 string, err error) {
+	return kr.createKey(wanted, "", masterPassword, keyPassphrase)
+}
+
+// CreateKeyAtPath is CreateKey for a deterministic store with an explicit
+// EIP-2333 derivation path (e.g. "m/12381/1729/0/0/7") instead of the next
+// internal index. Deriving a path that already backs a key is refused.
+func (kr *KeyRing) CreateKeyAtPath(wanted, derivationPath string, masterPassword, keyPassphrase []byte) (id, blPubkey, tz4 string, err error) {
+	if strings.TrimSpace(derivationPath) == "" {
+		return "", "", "", ErrInvalidDerivationPath
+	}
+	return kr.createKey(wanted, derivationPath, masterPassword, keyPassphrase)
+}
+
+func (kr *KeyRing) createKey(wanted, derivationPath string, masterPassword, keyPassphrase []byte) (id, blPubkey, tz4 string, err error) {
 	kr.lifecycleMu.Lock()
 	defer kr.lifecycleMu.Unlock()
 
@@ -77,14 +89,24 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 		return "", "", "", fmt.Errorf("invalid key_id")
 	}
 
+	var customPath []uint32
+	if derivationPath != "" {
+		if customPath, err = signer.ParseDerivationPath(derivationPath); err != nil {
+			return "", "", "", fmt.Errorf("%w: %v", ErrInvalidDerivationPath, err)
+		}
+	}
+
 	// --- Decide deterministic vs random ---
 	enabled, seed, seedErr := kr.store.readSeed(masterPassword)
-	defer secure.MemoryWipe(seed)
 	if seedErr != nil {
 		return "", "", "", seedErr
 	}
+	defer seed.Close()
 
 	useDeterministic := enabled
+	if customPath != nil && !useDeterministic {
+		return "", "", "", ErrDerivationPathNeedsSeed
+	}
 
 	var mf *masterFile
 	if useDeterministic {
@@ -117,21 +139,42 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 			popBLsig    string
 			index       uint32
 		)
+		var keyPath string
 		if useDeterministic {
-			if !detIndexReserved {
-				deterministicIndex, err = kr.store.nextDeterministicIndex()
+			for {
+				hdPath := customPath
+				if hdPath == nil {
+					if !detIndexReserved {
+						deterministicIndex, err = kr.store.nextDeterministicIndex()
+						if err != nil {
+							return "", "", "", err
+						}
+						detIndexReserved = true
+					}
+					index = deterministicIndex
+					hdPath = signer.TezSignPath(index)
+				}
+				keyPath = signer.FormatDerivationPath(hdPath)
+				// Build HD params (domain-separated with store salt)
+				secretKey, pubkeyBytes, blPubkey, err = signer.GenerateHDKeyAtPath(mf.hdSalt(), seed.Bytes(), hdPath)
 				if err != nil {
 					return "", "", "", err
 				}
-				detIndexReserved = true
-			}
-			index = deterministicIndex
-			// Build HD params (domain-separated with store salt)
-			secretKey, pubkeyBytes, blPubkey, err = signer.GenerateHDKey(mf.Salt, seed, index)
-			if err != nil {
-				return "", "", "", err
+				tz4, _ = signer.Tz4FromBLPubkeyBytes(pubkeyBytes)
+				existing, found, lErr := kr.store.lookupTZ4(tz4)
+				if lErr != nil {
+					return "", "", "", lErr
+				}
+				if !found {
+					break
+				}
+				if customPath != nil {
+					return "", "", "", fmt.Errorf("%w: path already used by %s", ErrKeyExists, existing)
+				}
+				// an auto index taken by a key created at its path: move on
+				kr.log.Info(fmt.Sprintf("NEWKEY skipping index=%d used by id=%s", index, existing))
+				detIndexReserved = false
 			}
-			tz4, _ = signer.Tz4FromBLPubkeyBytes(pubkeyBytes)
 		} else {
 			// legacy random
 			secretKey, pubkeyBytes, blPubkey = signer.GenerateRandomKey()
@@ -148,7 +191,7 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 			skLE := secretKey.ToLEndian()
 			defer secure.MemoryWipe(skLE)
 
-			pErr := kr.store.createKey(candidate, masterPassword, skLE, blPubkey, tz4, popBLsig)
+			pErr := kr.store.createKey(candidate, masterPassword, keyPassphrase, skLE, blPubkey, tz4, popBLsig, keyPath)
 			if pErr == nil {
 				id = candidate
 				err = nil
@@ -180,19 +223,21 @@ func (kr *KeyRing) CreateKey(wanted string, masterPassword []byte) (id, blPubkey
 		if !kr.keys.Insert(id, newGKey(blPubkey, tz4)) {
 			return "", "", "", ErrKeyExists
 		}
-		kr.log.Info(fmt.Sprintf("NEWKEY id=%s tz4=%s deterministic=%v index=%d", id, tz4, useDeterministic, index))
+		kr.log.Info(fmt.Sprintf("NEWKEY id=%s tz4=%s deterministic=%v index=%d path=%s key_passphrase=%v", id, tz4, useDeterministic, index, keyPath, len(keyPassphrase) > 0))
 		return id, blPubkey, tz4, nil
 	}
 }
 
-func (kr *KeyRing) Unlock(id string, masterPassword []byte) error {
+// Unlock loads the key into memory. keyPassphrase is only used (and then
+// required) for keys created with a per-key passphrase.
+func (kr *KeyRing) Unlock(id string, masterPassword, keyPassphrase []byte) error {
 	kr.lifecycleMu.Lock()
 	defer kr.lifecycleMu.Unlock()
 
 	key := kr.get(id)
 	if key == nil {
 		loadedKey := newGKey("", "")
-		if err := loadedKey.unlock(kr.log, kr.store, id, masterPassword); err != nil {
+		if err := loadedKey.unlock(kr.log, kr.store, id, masterPassword, keyPassphrase); err != nil {
 			return err
 		}
 
@@ -201,12 +246,15 @@ func (kr *KeyRing) Unlock(id string, masterPassword []byte) error {
 			return fmt.Errorf("key already present in registry")
 		}
 	} else {
-		if err := key.unlock(kr.log, kr.store, id, masterPassword); err != nil {
+		if err := key.unlock(kr.log, kr.store, id, masterPassword, keyPassphrase); err != nil {
 			return err
 		}
 	}
 
 	kr.log.Info("key unlocked", "key", id)
+	if err := kr.store.recordKEKCheck(masterPassword); err != nil {
+		kr.log.Warn("record kek check", "err", err)
+	}
 
 	return nil
 }
@@ -250,8 +298,8 @@ func (kr *KeyRing) VerifyMasterPassword(masterPassword []byte) error {
 	if err != nil {
 		return err
 	}
-	secure.MemoryWipe(seed)
-	return nil
+	seed.Close()
+	return kr.store.recordKEKCheck(masterPassword)
 }
 
 func (kr *KeyRing) Status() []*signerpb.KeyStatus {
@@ -275,6 +323,12 @@ func (kr *KeyRing) Status() []*signerpb.KeyStatus {
 		ks.Tz4 = meta.TZ4
 		ks.BlPubkey = meta.BLPubkey
 		ks.Pop = meta.Pop
+		ks.KeyPassphrase = meta.hasKeyPassphrase()
+		ks.Tags = meta.Tags
+		ks.DerivationPath = meta.DerivationPath
+		if meta.Validity != nil {
+			ks.Validity = meta.Validity.toProto()
+		}
 
 		// If key is present + unlocked, include watermarks
 		if key := kr.get(id); key != nil {
@@ -286,38 +340,109 @@ func (kr *KeyRing) Status() []*signerpb.KeyStatus {
 	return out
 }
 
-// resolveKeyIDByTZ4 scans the store to find the key id for a given tz4.
-// Works whether the key is locked or unlocked.
+// Watermarks returns the full watermark map of the given keys, or of every
+// key when ids is empty. Unknown ids are ErrKeyNotFound.
+func (kr *KeyRing) Watermarks(ids []string) ([]*signerpb.KeyWatermarks, error) {
+	if len(ids) == 0 {
+		var err error
+		if ids, err = kr.store.list(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*signerpb.KeyWatermarks, 0, len(ids))
+	for _, id := range ids {
+		if !kr.store.hasKey(id) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+		}
+		wm := &signerpb.KeyWatermarks{KeyId: id, LockState: signerpb.LockState_LOCKED}
+		if meta, err := kr.store.readKeyMeta(id); err == nil {
+			wm.Tz4 = meta.TZ4
+		} else {
+			kr.log.Error("watermarks: read meta", "key", id, "err", err)
+		}
+		if key := kr.get(id); key != nil {
+			key.populateWatermarks(id, wm, kr.log)
+		}
+		out = append(out, wm)
+	}
+	return out, nil
+}
+
+// PublicKey returns the identity of one key, looked up by tz4 or by key id.
+// Only that key's meta.json is read, unlike Status.
+func (kr *KeyRing) PublicKey(ref string) (*signerpb.GetPublicKeyResponse, error) {
+	ref = strings.TrimSpace(ref)
+	id := normalizeID(ref)
+	if strings.HasPrefix(ref, "tz4") {
+		var err error
+		if id, err = kr.resolveKeyIDByTZ4(ref); err != nil {
+			return nil, ErrKeyNotFound
+		}
+	}
+	if !isValidID(id) || !kr.store.hasKey(id) {
+		return nil, ErrKeyNotFound
+	}
+
+	meta, err := kr.store.readKeyMeta(id)
+	if err != nil {
+		return nil, fmt.Errorf("read meta: %w", err)
+	}
+	resp := &signerpb.GetPublicKeyResponse{
+		KeyId:    id,
+		Tz4:      meta.TZ4,
+		BlPubkey: meta.BLPubkey,
+		Pop:      meta.Pop,
+	}
+	if meta.Share != nil {
+		resp.Share = meta.Share.toProto()
+	}
+	return resp, nil
+}
+
+// resolveKeyIDByTZ4 finds the key id for a given tz4, whether the key is
+// locked or unlocked.
 func (kr *KeyRing) resolveKeyIDByTZ4(tz4 string) (string, error) {
 	if strings.TrimSpace(tz4) == "" {
 		return "", fmt.Errorf("empty tz4")
 	}
-	ids, err := kr.store.list()
+	if id, _, ok := kr.keys.LoadByTZ4(tz4); ok {
+		return id, nil
+	}
+	id, ok, err := kr.store.lookupTZ4(tz4)
 	if err != nil {
 		return "", err
 	}
-	for _, id := range ids {
-		meta, mErr := kr.store.readKeyMeta(id)
-		if mErr != nil {
-			kr.log.Error("resolve tz4: read meta", "key", id, "err", mErr)
-			continue
-		}
-		if meta.TZ4 == tz4 {
-			return id, nil
-		}
+	if !ok {
+		return "", fmt.Errorf("unknown tz4")
 	}
-	return "", fmt.Errorf("unknown tz4")
+	return id, nil
 }
 
-// SignAndUpdate validates key state + monotonic (level, round) and signs.
-// Monotonic rule: (level > lastLevel) OR (level == lastLevel && round > lastRound)
+// SignAndUpdate validates key state + the kind's monotonic rule and signs.
+// Default rule: (level > lastLevel) OR (level == lastLevel && round > lastRound)
+// Every call logs a SignEvent.
 func (kr *KeyRing) SignAndUpdate(tz4 string, raw []byte) (sig []byte, err error) {
+	start := time.Now()
 	keyID, key := kr.getByTz4(tz4)
 	if key == nil {
-		return nil, ErrKeyNotFound
+		// keys stay out of memory until unlocked, e.g. after a restart; one
+		// that is on disk is locked, not unknown
+		ev := NewSignEvent(tz4, raw)
+		err = ErrKeyNotFound
+		if id, ok, lookupErr := kr.store.lookupTZ4(tz4); lookupErr == nil && ok {
+			ev.KeyID, err = id, ErrKeyLocked
+		}
+		ev.Finish(start, err)
+		ev.Log(kr.log)
+		return nil, err
 	}
 
-	return key.signAndUpdate(keyID, raw)
+	ev := SignEvent{KeyID: keyID, TZ4: tz4}
+	sig, err = key.signAndUpdate(keyID, raw, &ev)
+	ev.Finish(start, err)
+	ev.Log(kr.log)
+	return sig, err
 }
 
 func (kr *KeyRing) SetLevel(id string, level uint64) error {
@@ -332,6 +457,141 @@ func (kr *KeyRing) SetLevel(id string, level uint64) error {
 	return key.setLevel(id, level)
 }
 
+// SetValidity sets (or, with a zero Validity, clears) the key's signing window.
+// The key must be unlocked because the window is authenticated under its DEK.
+func (kr *KeyRing) SetValidity(id string, v Validity) error {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	if err := v.validate(); err != nil {
+		return err
+	}
+	key := kr.get(id)
+	if key == nil {
+		if kr.store.hasKey(id) {
+			return ErrKeyLocked
+		}
+		return ErrKeyNotFound
+	}
+	if err := key.setValidity(kr.store, id, v); err != nil {
+		return err
+	}
+
+	kr.log.Info("key validity updated", "key", id, "not_before", v.NotBefore, "not_after", v.NotAfter, "min_level", v.MinLevel, "max_level", v.MaxLevel)
+	return nil
+}
+
+// RegeneratePoP replaces the key's stored proof of possession with a freshly
+// signed one, e.g. after meta.json's copy was damaged. The key must be
+// unlocked.
+func (kr *KeyRing) RegeneratePoP(id string) (*signerpb.GetPublicKeyResponse, error) {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	key := kr.get(id)
+	if key == nil {
+		if kr.store.hasKey(id) {
+			return nil, ErrKeyLocked
+		}
+		return nil, ErrKeyNotFound
+	}
+	if _, err := key.regeneratePoP(kr.store, id); err != nil {
+		return nil, err
+	}
+	kr.log.Info("key proof of possession regenerated", "key", id)
+	return kr.PublicKey(id)
+}
+
+// SetPolicy replaces the key's policy and validity window. Like SetValidity
+// it needs the key unlocked, to authenticate them under its DEK.
+func (kr *KeyRing) SetPolicy(id string, p Policy, v Validity) error {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	if err := v.validate(); err != nil {
+		return err
+	}
+	key := kr.get(id)
+	if key == nil {
+		if kr.store.hasKey(id) {
+			return ErrKeyLocked
+		}
+		return ErrKeyNotFound
+	}
+	if err := key.setPolicy(kr.store, id, p, v); err != nil {
+		return err
+	}
+
+	kr.log.Info("key policy updated", "key", id, "kinds", p.Kinds, "chain_id", p.ChainID, "max_per_minute", p.MaxPerMinute)
+	return nil
+}
+
+// Policy returns the key's policy and validity window as stored; a locked key
+// does not need unlocking to show them.
+func (kr *KeyRing) Policy(id string) (Policy, Validity, error) {
+	if !kr.store.hasKey(id) {
+		return Policy{}, Validity{}, ErrKeyNotFound
+	}
+	meta, err := kr.store.readKeyMeta(id)
+	if err != nil {
+		return Policy{}, Validity{}, err
+	}
+	var p Policy
+	var v Validity
+	if meta.Policy != nil {
+		p = *meta.Policy
+	}
+	if meta.Validity != nil {
+		v = *meta.Validity
+	}
+	return p, v, nil
+}
+
+// SetTags applies set and then remove to the key's tags and returns the result.
+func (kr *KeyRing) SetTags(id string, set map[string]string, remove []string) (map[string]string, error) {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	if !kr.store.hasKey(id) {
+		return nil, ErrKeyNotFound
+	}
+	for k, v := range set {
+		if !isValidTagKey(k) {
+			return nil, fmt.Errorf("%w: key %q", ErrInvalidTag, k)
+		}
+		if !isValidTagValue(v) {
+			return nil, fmt.Errorf("%w: value for %q", ErrInvalidTag, k)
+		}
+	}
+
+	var tags map[string]string
+	err := kr.store.updateKeyMeta(id, func(meta *keyMeta) error {
+		if meta.Tags == nil {
+			meta.Tags = make(map[string]string, len(set))
+		}
+		for k, v := range set {
+			meta.Tags[k] = v
+		}
+		for _, k := range remove {
+			delete(meta.Tags, k)
+		}
+		if len(meta.Tags) > maxKeyTags {
+			return fmt.Errorf("%w: at most %d tags per key", ErrInvalidTag, maxKeyTags)
+		}
+		if len(meta.Tags) == 0 {
+			meta.Tags = nil
+		}
+		tags = meta.Tags
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	kr.log.Info("key tags updated", "key", id, "tags", tags)
+	return tags, nil
+}
+
 func (kr *KeyRing) get(id string) *gKey {
 	key, ok := kr.keys.Load(id)
 	if !ok {
@@ -342,18 +602,8 @@ func (kr *KeyRing) get(id string) *gKey {
 }
 
 func (kr *KeyRing) getByTz4(tz4 string) (string, *gKey) {
-	// TODO: optimize with a secondary map if needed
-	var foundKey *gKey
-	var foundID string
-	kr.keys.Range(func(id string, key *gKey) bool {
-		if key.matchesTz4(tz4) {
-			foundKey = key
-			foundID = id
-			return false
-		}
-		return true
-	})
-	return foundID, foundKey
+	id, key, _ := kr.keys.LoadByTZ4(tz4)
+	return id, key
 }
 
 func normalizeID(s string) string {
@@ -365,3 +615,26 @@ var idRE = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
 func isValidID(id string) bool {
 	return idRE.MatchString(id)
 }
+
+const (
+	maxKeyTags     = 16
+	maxTagValueLen = 64
+)
+
+var tagKeyRE = regexp.MustCompile(`^[a-z0-9_.-]{1,32}$`)
+
+func isValidTagKey(k string) bool {
+	return tagKeyRE.MatchString(k)
+}
+
+func isValidTagValue(v string) bool {
+	if v == "" || len(v) > maxTagValueLen {
+		return false
+	}
+	for _, r := range v {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
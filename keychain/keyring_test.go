@@ -1,14 +1,22 @@
 package keychain
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 
 	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/signerpb"
 )
 
 func TestSignAndUpdateRejectsCorruptedHWM(t *testing.T) {
@@ -24,7 +32,7 @@ func TestSignAndUpdateRejectsCorruptedHWM(t *testing.T) {
 func TestUnlockFailureDoesNotLeaveGhostKey(t *testing.T) {
 	setup := newBenchmarkSetup(t)
 
-	err := setup.ring.Unlock("ghost-key", []byte("wrong-password"))
+	err := setup.ring.Unlock("ghost-key", []byte("wrong-password"), nil)
 	if err == nil {
 		t.Fatalf("expected unlock error for unknown key")
 	}
@@ -32,7 +40,7 @@ func TestUnlockFailureDoesNotLeaveGhostKey(t *testing.T) {
 		t.Fatalf("unlock failure left a ghost key in memory")
 	}
 
-	createdID, _, _, err := setup.ring.CreateKey("ghost-key", []byte("bench-passphrase"))
+	createdID, _, _, err := setup.ring.CreateKey("ghost-key", []byte("bench-passphrase"), nil)
 	if err != nil {
 		t.Fatalf("CreateKey after failed unlock: %v", err)
 	}
@@ -94,6 +102,58 @@ func TestSignAndUpdateRejectsConcurrentDuplicateLevel(t *testing.T) {
 	}
 }
 
+const testSignKind = SIGN_KIND(0x7e)
+
+var registerTestSignKind = sync.OnceValue(func() error {
+	return RegisterSignKind(SignKindSpec{
+		Kind: testSignKind,
+		Name: "test",
+		Decode: func(raw []byte) (uint64, uint32, error) {
+			if len(raw) < 9 {
+				return 0, 0, errOutOfBounds
+			}
+			return uint64(binary.BigEndian.Uint32(raw[1:5])), binary.BigEndian.Uint32(raw[5:9]), nil
+		},
+		Advances: LevelIncreasing,
+	})
+})
+
+func TestRegisteredSignKindUsesOwnRule(t *testing.T) {
+	if err := registerTestSignKind(); err != nil {
+		t.Fatalf("RegisterSignKind: %v", err)
+	}
+	if err := RegisterSignKind(SignKindSpec{Kind: testSignKind, Name: "dup", Decode: func([]byte) (uint64, uint32, error) { return 0, 0, nil }}); !errors.Is(err, ErrSignKindExists) {
+		t.Fatalf("expected ErrSignKindExists, got %v", err)
+	}
+	if testSignKind.String() != "test" {
+		t.Fatalf("unexpected kind name %q", testSignKind.String())
+	}
+
+	setup := newBenchmarkSetup(t)
+	payload := func(level, round uint32) []byte {
+		buf := make([]byte, 9)
+		buf[0] = byte(testSignKind)
+		be32(buf[1:5], level)
+		be32(buf[5:9], round)
+		return buf
+	}
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, payload(5, 0)); err != nil {
+		t.Fatalf("SignAndUpdate level 5: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, payload(5, 1)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected ErrStaleWatermark for same level, got %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, payload(6, 0)); err != nil {
+		t.Fatalf("SignAndUpdate level 6: %v", err)
+	}
+
+	state := setup.key.GetKeyState()
+	if st := state.ByKind[int32(testSignKind)]; st == nil || st.Level != 6 {
+		t.Fatalf("unexpected test kind watermark: %v", st)
+	}
+}
+
 func be32(b []byte, v uint32) {
 	b[0] = byte(v >> 24)
 	b[1] = byte(v >> 16)
@@ -155,12 +215,12 @@ func BenchmarkSignAndUpdate(b *testing.B) {
 	// 2. Create and Unlock a key for benchmarking
 	password := []byte("super-secret")
 	id := "bench-key"
-	err := store.createKey("bench-key", password, skLE, blPubkey, tz4, "")
+	err := store.createKey("bench-key", password, nil, skLE, blPubkey, tz4, "", "")
 	// id, _, tz4, err := kr.CreateKey("bench-key", password)
 	if err != nil {
 		b.Fatalf("failed to create key: %v", err)
 	}
-	if err := kr.Unlock(id, password); err != nil {
+	if err := kr.Unlock(id, password, nil); err != nil {
 		b.Fatalf("failed to unlock: %v", err)
 	}
 
@@ -187,3 +247,1104 @@ func BenchmarkSignAndUpdate(b *testing.B) {
 		level++
 	}
 }
+
+func TestKeyPassphraseRequiredForUnlock(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	master := []byte("bench-passphrase")
+	keyPass := []byte("team-a")
+
+	id, _, _, err := setup.ring.CreateKey("team-a-key", master, keyPass)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	if err := setup.ring.Unlock(id, master, nil); !errors.Is(err, ErrKeyPassphraseRequired) {
+		t.Fatalf("expected ErrKeyPassphraseRequired, got %v", err)
+	}
+	if err := setup.ring.Unlock(id, master, []byte("team-b")); err == nil {
+		t.Fatalf("expected unlock with wrong key passphrase to fail")
+	}
+	if err := setup.ring.Unlock(id, master, keyPass); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+
+	for _, ks := range setup.ring.Status() {
+		switch ks.GetKeyId() {
+		case id:
+			if !ks.GetKeyPassphrase() || ks.GetLockState() != signerpb.LockState_UNLOCKED {
+				t.Fatalf("unexpected status for %s: %v", id, ks)
+			}
+		case setup.keyID:
+			if ks.GetKeyPassphrase() {
+				t.Fatalf("key %s reported a key passphrase", setup.keyID)
+			}
+		}
+	}
+}
+
+func TestSetTagsPersistsAndValidates(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+
+	tags, err := setup.ring.SetTags(setup.keyID, map[string]string{"role": "baker", "network": "mainnet"}, nil)
+	if err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	if len(tags) != 2 || tags["role"] != "baker" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+
+	tags, err = setup.ring.SetTags(setup.keyID, map[string]string{"role": "companion"}, []string{"network"})
+	if err != nil {
+		t.Fatalf("SetTags update: %v", err)
+	}
+	if len(tags) != 1 || tags["role"] != "companion" {
+		t.Fatalf("unexpected tags after update: %v", tags)
+	}
+
+	if _, err := setup.ring.SetTags(setup.keyID, map[string]string{"Bad Key": "x"}, nil); !errors.Is(err, ErrInvalidTag) {
+		t.Fatalf("expected ErrInvalidTag, got %v", err)
+	}
+	if _, err := setup.ring.SetTags("missing", map[string]string{"role": "baker"}, nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	meta, err := setup.store.readKeyMeta(setup.keyID)
+	if err != nil {
+		t.Fatalf("readKeyMeta: %v", err)
+	}
+	if len(meta.Tags) != 1 || meta.Tags["role"] != "companion" {
+		t.Fatalf("tags not persisted: %v", meta.Tags)
+	}
+}
+
+func TestValidityWindowRefusesOutsideSignatures(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	if err := setup.ring.SetValidity(setup.keyID, Validity{MinLevel: 10, MaxLevel: 20}); err != nil {
+		t.Fatalf("SetValidity: %v", err)
+	}
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(9, 0)); !errors.Is(err, ErrOutsideValidity) {
+		t.Fatalf("expected ErrOutsideValidity below window, got %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(10, 0)); err != nil {
+		t.Fatalf("SignAndUpdate inside window: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(21, 0)); !errors.Is(err, ErrOutsideValidity) {
+		t.Fatalf("expected ErrOutsideValidity above window, got %v", err)
+	}
+
+	// the window survives lock/unlock and is authenticated on disk
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(25, 0)); !errors.Is(err, ErrOutsideValidity) {
+		t.Fatalf("expected ErrOutsideValidity after unlock, got %v", err)
+	}
+
+	if err := setup.store.updateKeyMeta(setup.keyID, func(meta *keyMeta) error {
+		meta.Validity.MaxLevel = 1000
+		return nil
+	}); err != nil {
+		t.Fatalf("updateKeyMeta: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); !errors.Is(err, ErrValidityTampered) {
+		t.Fatalf("expected ErrValidityTampered, got %v", err)
+	}
+
+	// and so is there being a window at all
+	if err := setup.store.updateKeyMeta(setup.keyID, func(meta *keyMeta) error {
+		meta.Validity, meta.ValidityMAC = nil, nil
+		return nil
+	}); err != nil {
+		t.Fatalf("updateKeyMeta: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); !errors.Is(err, ErrValidityTampered) {
+		t.Fatalf("expected ErrValidityTampered for a deleted window, got %v", err)
+	}
+}
+
+func TestClearedValidityWindowUnlocks(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	if err := setup.ring.SetValidity(setup.keyID, Validity{MaxLevel: 20}); err != nil {
+		t.Fatalf("SetValidity: %v", err)
+	}
+	if err := setup.ring.SetValidity(setup.keyID, Validity{}); err != nil {
+		t.Fatalf("SetValidity: %v", err)
+	}
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); err != nil {
+		t.Fatalf("Unlock after clearing the window: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(21, 0)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+}
+
+func TestPolicyRestrictsKindChainAndRate(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	if err := setup.ring.SetPolicy(setup.keyID, Policy{Kinds: []string{"nope"}}, Validity{}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy for unknown kind, got %v", err)
+	}
+	if err := setup.ring.SetPolicy(setup.keyID, Policy{ChainID: "NetXbogus"}, Validity{}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy for bad chain id, got %v", err)
+	}
+
+	payloadChain, _ := signer.EncodeChainID(buildPreattestationPayload(1, 0)[1:5])
+	otherChain, _ := signer.EncodeChainID([]byte{0x7a, 0x06, 0xa7, 0x70})
+	policy := Policy{Kinds: []string{"preattestation"}, ChainID: payloadChain, MaxPerMinute: 2}
+	if err := setup.ring.SetPolicy(setup.keyID, policy, Validity{MaxLevel: 100}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildBenchmarkAttestationPayload(1, 0)); !errors.Is(err, ErrPolicyRefused) {
+		t.Fatalf("expected ErrPolicyRefused for attestation, got %v", err)
+	}
+	for level := uint64(1); level <= 2; level++ {
+		if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(level, 0)); err != nil {
+			t.Fatalf("SignAndUpdate level %d: %v", level, err)
+		}
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(3, 0)); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	got, v, err := setup.ring.Policy(setup.keyID)
+	if err != nil || got.ChainID != payloadChain || got.MaxPerMinute != 2 || v.MaxLevel != 100 {
+		t.Fatalf("Policy: %+v %+v %v", got, v, err)
+	}
+
+	if err := setup.ring.SetPolicy(setup.keyID, Policy{ChainID: otherChain}, Validity{}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(3, 0)); !errors.Is(err, ErrPolicyRefused) {
+		t.Fatalf("expected ErrPolicyRefused for wrong chain, got %v", err)
+	}
+	if _, v, _ := setup.ring.Policy(setup.keyID); !v.IsZero() {
+		t.Fatalf("SetPolicy did not clear the validity window: %+v", v)
+	}
+
+	// the policy is authenticated on disk like the validity window
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if err := setup.store.updateKeyMeta(setup.keyID, func(meta *keyMeta) error {
+		meta.Policy.ChainID = payloadChain
+		return nil
+	}); err != nil {
+		t.Fatalf("updateKeyMeta: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); !errors.Is(err, ErrPolicyTampered) {
+		t.Fatalf("expected ErrPolicyTampered, got %v", err)
+	}
+
+	// deleting it is tampering too
+	if err := setup.store.updateKeyMeta(setup.keyID, func(meta *keyMeta) error {
+		meta.Policy, meta.PolicyMAC = nil, nil
+		return nil
+	}); err != nil {
+		t.Fatalf("updateKeyMeta: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); !errors.Is(err, ErrPolicyTampered) {
+		t.Fatalf("expected ErrPolicyTampered for a deleted policy, got %v", err)
+	}
+}
+
+// fullAttestation is a complete tz4 consensus operation: watermark, chain,
+// branch, tag, level, round, block payload hash.
+func fullAttestation(watermark SIGN_KIND, tag byte, level uint64) []byte {
+	buf := make([]byte, 1+4+32+1+4+4+32)
+	buf[0] = byte(watermark)
+	buf[37] = tag
+	binary.BigEndian.PutUint32(buf[38:], uint32(level))
+	return buf
+}
+
+// fullBlock is a complete unsigned block header with a Tenderbake fitness
+// claiming fitnessLevel.
+func fullBlock(level, fitnessLevel uint64, round uint32) []byte {
+	elem := func(b []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...)
+	}
+	var fitness []byte
+	fitness = append(fitness, elem([]byte{2})...)
+	fitness = append(fitness, elem(binary.BigEndian.AppendUint32(nil, uint32(fitnessLevel)))...)
+	fitness = append(fitness, elem(nil)...)
+	fitness = append(fitness, elem(binary.BigEndian.AppendUint32(nil, 0xffffffff))...)
+	fitness = append(fitness, elem(binary.BigEndian.AppendUint32(nil, round))...)
+
+	buf := make([]byte, 1+4)
+	buf[0] = byte(BLOCK)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(level))
+	buf = append(buf, make([]byte, 1+32+8+1+32)...)
+	buf = append(buf, elem(fitness)...)
+	buf = append(buf, make([]byte, 32+32)...) // context, payload hash
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	buf = append(buf, make([]byte, 8)...)
+	buf = append(buf, 0x00, 0x00) // no seed nonce hash, votes
+	return buf
+}
+
+func TestStrictValidationProfile(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+
+	if err := setup.ring.SetPolicy(setup.keyID, Policy{Validation: "paranoid"}, Validity{}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Fatalf("expected ErrInvalidPolicy for unknown profile, got %v", err)
+	}
+
+	// standard accepts the truncated payloads the benchmarks use
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildBenchmarkAttestationPayload(1, 0)); err != nil {
+		t.Fatalf("standard SignAndUpdate: %v", err)
+	}
+
+	if err := setup.ring.SetPolicy(setup.keyID, Policy{Validation: ValidationStrict}, Validity{}); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+	if got, _, _ := setup.ring.Policy(setup.keyID); got.Validation != ValidationStrict {
+		t.Fatalf("Policy validation = %q", got.Validation)
+	}
+
+	trailing := append(fullAttestation(ATTESTATION, opAttestation, 3), 0)
+	withDAL := append(fullAttestation(ATTESTATION, opAttestationWithDAL, 4), 0x81, 0x01)
+	bad := map[string][]byte{
+		"truncated":            buildBenchmarkAttestationPayload(2, 0),
+		"trailing bytes":       trailing,
+		"tag mismatch":         fullAttestation(PREATTESTATION, opAttestation, 3),
+		"unterminated DAL":     append(fullAttestation(ATTESTATION, opAttestationWithDAL, 3), 0x81),
+		"fitness level":        fullBlock(3, 4, 0),
+		"block trailing bytes": append(fullBlock(3, 3, 0), 0),
+	}
+	for name, raw := range bad {
+		if _, err := setup.ring.SignAndUpdate(setup.tz4, raw); !errors.Is(err, ErrBadPayload) {
+			t.Fatalf("%s: expected ErrBadPayload, got %v", name, err)
+		}
+	}
+
+	for _, raw := range [][]byte{
+		fullAttestation(PREATTESTATION, opPreattestation, 3),
+		fullAttestation(ATTESTATION, opAttestation, 3),
+		withDAL,
+		fullBlock(3, 3, 1),
+	} {
+		if _, err := setup.ring.SignAndUpdate(setup.tz4, raw); err != nil {
+			t.Fatalf("strict SignAndUpdate kind %#x: %v", raw[0], err)
+		}
+	}
+}
+
+func TestSignatureSelfCheckFailsClosed(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(1, 0)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+
+	// stand-in for memory corruption: the key no longer matches its BLpk
+	_, other, _ := signer.GenerateRandomKey()
+	good := setup.key.pubkey
+	setup.key.pubkey = other
+	sig, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(2, 0))
+	if !errors.Is(err, ErrSignatureSelfCheck) || sig != nil {
+		t.Fatalf("expected ErrSignatureSelfCheck and no signature, got %x, %v", sig, err)
+	}
+
+	setup.key.pubkey = good
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(2, 0)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected the failed level to stay used, got %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(3, 0)); err != nil {
+		t.Fatalf("SignAndUpdate after recovery: %v", err)
+	}
+}
+
+func TestWatermarksReportEveryKind(t *testing.T) {
+	if err := registerTestSignKind(); err != nil {
+		t.Fatalf("RegisterSignKind: %v", err)
+	}
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(7, 2)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+	lockedID, _, _, err := setup.ring.CreateKey("cold", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	keys, err := setup.ring.Watermarks(nil)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Watermarks: %d keys, %v", len(keys), err)
+	}
+	byID := map[string]*signerpb.KeyWatermarks{}
+	for _, k := range keys {
+		byID[k.GetKeyId()] = k
+	}
+
+	if cold := byID[lockedID]; cold.GetLockState() != signerpb.LockState_LOCKED || len(cold.GetWatermarks()) != 0 {
+		t.Fatalf("locked key reported watermarks: %v", cold)
+	}
+	hot := byID[setup.keyID]
+	if hot.GetTz4() != setup.tz4 || hot.GetSignCount() != 1 || hot.GetLastUsedUnix() == 0 || hot.GetSequence() == 0 {
+		t.Fatalf("unexpected key detail: %v", hot)
+	}
+	kinds := map[string]*signerpb.WatermarkEntry{}
+	for _, w := range hot.GetWatermarks() {
+		kinds[w.GetKind()] = w
+	}
+	for _, name := range []string{"block", "preattestation", "attestation", "test"} {
+		if kinds[name] == nil {
+			t.Fatalf("kind %s missing from %v", name, hot.GetWatermarks())
+		}
+	}
+	if pre := kinds["preattestation"]; pre.GetLevel() != 7 || pre.GetRound() != 2 || pre.GetKindId() != uint32(PREATTESTATION) {
+		t.Fatalf("unexpected preattestation entry: %v", pre)
+	}
+
+	if _, err := setup.ring.Watermarks([]string{"missing"}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestWatermarkSnapshotImportOnlyRaises(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	// replacement device: a copy of the store taken before signing
+	replacementDir := t.TempDir()
+	if err := os.CopyFS(replacementDir, os.DirFS(setup.store.base)); err != nil {
+		t.Fatalf("CopyFS: %v", err)
+	}
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(30, 2)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+	data, skipped, err := setup.ring.ExportWatermarks(pass, "device-a")
+	if err != nil {
+		t.Fatalf("ExportWatermarks: %v", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("unexpected skipped keys: %v", skipped)
+	}
+
+	store, err := NewFileStore(replacementDir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	ring := NewKeyRing(slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+	if err := ring.Unlock(setup.keyID, pass, nil); err != nil {
+		t.Fatalf("Unlock replacement: %v", err)
+	}
+	t.Cleanup(func() { _ = ring.Lock(setup.keyID) })
+
+	if _, err := ring.ImportWatermarks([]byte("wrong"), data); err == nil {
+		t.Fatalf("expected import with wrong passphrase to fail")
+	}
+	tampered := []byte(strings.Replace(string(data), `"level": 30`, `"level": 31`, 1))
+	if _, err := ring.ImportWatermarks(pass, tampered); !errors.Is(err, ErrSnapshotInvalid) {
+		t.Fatalf("expected ErrSnapshotInvalid for tampered snapshot, got %v", err)
+	}
+
+	results, err := ring.ImportWatermarks(pass, data)
+	if err != nil {
+		t.Fatalf("ImportWatermarks: %v", err)
+	}
+	if len(results) != 1 || results[0].Err != nil || !results[0].Raised || results[0].KeyID != setup.keyID {
+		t.Fatalf("unexpected import results: %+v", results)
+	}
+	if _, err := ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(30, 2)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected ErrStaleWatermark after import, got %v", err)
+	}
+
+	if _, err := ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(40, 0)); err != nil {
+		t.Fatalf("SignAndUpdate past snapshot: %v", err)
+	}
+	results, err = ring.ImportWatermarks(pass, data)
+	if err != nil {
+		t.Fatalf("ImportWatermarks again: %v", err)
+	}
+	if results[0].Raised {
+		t.Fatalf("import lowered or rewrote an already higher watermark")
+	}
+	pre := ring.get(setup.keyID).GetKeyState().ByKind[int32(PREATTESTATION)]
+	if pre.GetLevel() != 40 {
+		t.Fatalf("expected preattestation level 40, got %d", pre.GetLevel())
+	}
+}
+
+func TestBackupBundleRoundTrip(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(50, 1)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+	// leftovers from an interrupted write must not be picked up
+	stray := filepath.Join(setup.store.keyDir(setup.keyID), keyMetaFileName+tmpSuffix)
+	if err := os.WriteFile(stray, []byte("partial"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	bundle, err := NewBackupBundle(setup.store)
+	if err != nil {
+		t.Fatalf("NewBackupBundle: %v", err)
+	}
+	blob, err := bundle.Seal([]byte("backup-pass"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	if _, err := OpenBackupBundle(blob, []byte("wrong")); !errors.Is(err, ErrBackupBadPass) {
+		t.Fatalf("expected ErrBackupBadPass, got %v", err)
+	}
+	tampered := slices.Clone(blob)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := OpenBackupBundle(tampered, []byte("backup-pass")); !errors.Is(err, ErrBackupBadPass) {
+		t.Fatalf("expected tampered body to fail, got %v", err)
+	}
+	tampered = slices.Clone(blob)
+	tampered[8] ^= 0x01
+	if _, err := OpenBackupBundle(tampered, []byte("backup-pass")); !errors.Is(err, ErrBackupCorrupted) {
+		t.Fatalf("expected tampered header to fail, got %v", err)
+	}
+
+	opened, err := OpenBackupBundle(blob, []byte("backup-pass"))
+	if err != nil {
+		t.Fatalf("OpenBackupBundle: %v", err)
+	}
+	for _, f := range opened.Files {
+		if strings.HasSuffix(f.Path, tmpSuffix) {
+			t.Fatalf("backup contains temporary file %s", f.Path)
+		}
+	}
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := opened.Restore(store); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if err := opened.Restore(store); !errors.Is(err, ErrStoreNotEmpty) {
+		t.Fatalf("expected ErrStoreNotEmpty on second restore, got %v", err)
+	}
+
+	ring := NewKeyRing(slog.New(slog.NewTextHandler(io.Discard, nil)), store)
+	if err := ring.Unlock(setup.keyID, pass, nil); err != nil {
+		t.Fatalf("Unlock restored: %v", err)
+	}
+	t.Cleanup(func() { _ = ring.Lock(setup.keyID) })
+	if _, err := ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(50, 1)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected restored watermark to refuse replay, got %v", err)
+	}
+}
+
+func TestTz4IndexFollowsKeyLifecycle(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	otherID, _, otherTz4, err := setup.ring.CreateKey("other", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+
+	// a fresh ring over the same store has no unlocked keys and no index yet
+	ring := NewKeyRing(slog.New(slog.NewTextHandler(io.Discard, nil)), setup.store)
+	for tz4, want := range map[string]string{setup.tz4: setup.keyID, otherTz4: otherID} {
+		got, err := ring.resolveKeyIDByTZ4(tz4)
+		if err != nil || got != want {
+			t.Fatalf("resolve %s: got %q, %v; want %q", tz4, got, err, want)
+		}
+	}
+	if id, key := ring.getByTz4(setup.tz4); key != nil || id != "" {
+		t.Fatalf("locked key returned from in-memory index")
+	}
+	if _, err := ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(1, 0)); !errors.Is(err, ErrKeyLocked) {
+		t.Fatalf("expected ErrKeyLocked for a key not loaded yet, got %v", err)
+	}
+
+	if err := ring.Unlock(otherID, pass, nil); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if id, key := ring.getByTz4(otherTz4); key == nil || id != otherID {
+		t.Fatalf("unlocked key missing from index: %q", id)
+	}
+
+	if err := ring.DeleteKey(otherID); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+	if _, key := ring.getByTz4(otherTz4); key != nil {
+		t.Fatalf("deleted key still indexed")
+	}
+	if _, err := ring.resolveKeyIDByTZ4(otherTz4); err == nil {
+		t.Fatalf("expected deleted tz4 to be unknown")
+	}
+	if _, err := ring.SignAndUpdate(otherTz4, buildPreattestationPayload(1, 0)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestPublicKeyByTz4OrID(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	for _, ref := range []string{setup.tz4, setup.keyID, " " + strings.ToUpper(setup.keyID)} {
+		pk, err := setup.ring.PublicKey(ref)
+		if err != nil {
+			t.Fatalf("PublicKey(%q): %v", ref, err)
+		}
+		if pk.GetKeyId() != setup.keyID || pk.GetTz4() != setup.tz4 {
+			t.Fatalf("PublicKey(%q) = %s/%s", ref, pk.GetKeyId(), pk.GetTz4())
+		}
+		if !strings.HasPrefix(pk.GetBlPubkey(), "BLpk") || !strings.HasPrefix(pk.GetPop(), "BLsig") {
+			t.Fatalf("PublicKey(%q) missing pubkey or pop: %v", ref, pk)
+		}
+	}
+
+	for _, ref := range []string{"", "missing", "tz4missing", "../bench"} {
+		if _, err := setup.ring.PublicKey(ref); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("PublicKey(%q): expected ErrKeyNotFound, got %v", ref, err)
+		}
+	}
+}
+
+func TestWithCachedKEKDerivesOnce(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	var derivations atomic.Int32
+	orig := masterKDF
+	masterKDF = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		derivations.Add(1)
+		return orig(password, salt, time, memory, threads, keyLen)
+	}
+	t.Cleanup(func() { masterKDF = orig })
+
+	var ids []string
+	setup.ring.WithCachedKEK(pass, func() {
+		for range 3 {
+			id, _, _, err := setup.ring.CreateKey("", pass, nil)
+			if err != nil {
+				t.Fatalf("CreateKey: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		if err := setup.ring.Unlock(ids[0], []byte("wrong"), nil); err == nil {
+			t.Fatalf("expected unlock with wrong passphrase to fail")
+		}
+		for _, id := range ids {
+			if err := setup.ring.Unlock(id, pass, nil); err != nil {
+				t.Fatalf("Unlock %s: %v", id, err)
+			}
+		}
+	})
+	// one for the batch passphrase, one for the wrong one
+	if got := derivations.Load(); got != 2 {
+		t.Fatalf("expected 2 derivations, got %d", got)
+	}
+	if setup.store.kekCache != nil {
+		t.Fatalf("KEK cache left behind after scope")
+	}
+
+	derivations.Store(0)
+	if err := setup.ring.Unlock(ids[0], pass, nil); err != nil {
+		t.Fatalf("Unlock outside scope: %v", err)
+	}
+	if got := derivations.Load(); got != 1 {
+		t.Fatalf("expected a fresh derivation outside the scope, got %d", got)
+	}
+}
+
+func TestCreateKeyAtPathIsDeterministic(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	pass := []byte("path-passphrase")
+	if err := store.InitMaster(); err != nil {
+		t.Fatalf("InitMaster: %v", err)
+	}
+	if err := store.WriteSeed(pass, true); err != nil {
+		t.Fatalf("WriteSeed: %v", err)
+	}
+	ring := NewKeyRing(log, store)
+
+	_, _, autoTz4, err := ring.CreateKey("auto", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if _, _, _, err := ring.CreateKeyAtPath("same", "m/12381/1729/0/0/1", pass, nil); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists for the auto key's path, got %v", err)
+	}
+	if _, _, _, err := ring.CreateKeyAtPath("bad", "12381/x", pass, nil); !errors.Is(err, ErrInvalidDerivationPath) {
+		t.Fatalf("expected ErrInvalidDerivationPath, got %v", err)
+	}
+	_, _, customTz4, err := ring.CreateKeyAtPath("custom", "m/12381/3600/0/0'/0'", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKeyAtPath: %v", err)
+	}
+	if customTz4 == autoTz4 {
+		t.Fatalf("custom path produced the auto key")
+	}
+
+	paths := map[string]string{}
+	for _, ks := range ring.Status() {
+		paths[ks.GetKeyId()] = ks.GetDerivationPath()
+	}
+	if paths["auto"] != "m/12381/1729/0/0/1" || paths["custom"] != "m/12381/3600/0/0/0" {
+		t.Fatalf("unexpected derivation paths: %v", paths)
+	}
+
+	random := newBenchmarkSetup(t)
+	if _, _, _, err := random.ring.CreateKeyAtPath("x", "m/1", []byte("bench-passphrase"), nil); !errors.Is(err, ErrDerivationPathNeedsSeed) {
+		t.Fatalf("expected ErrDerivationPathNeedsSeed, got %v", err)
+	}
+}
+
+func TestCreateKeySkipsIndexTakenByPath(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	pass := []byte("path-passphrase")
+	if err := store.InitMaster(); err != nil {
+		t.Fatalf("InitMaster: %v", err)
+	}
+	if err := store.WriteSeed(pass, true); err != nil {
+		t.Fatalf("WriteSeed: %v", err)
+	}
+	ring := NewKeyRing(log, store)
+
+	// the paths of the next two auto keys, taken ahead of them
+	_, _, first, err := ring.CreateKeyAtPath("first", "m/12381/1729/0/0/1", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKeyAtPath: %v", err)
+	}
+	_, _, second, err := ring.CreateKeyAtPath("second", "m/12381/1729/0/0/2", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKeyAtPath: %v", err)
+	}
+	_, _, auto, err := ring.CreateKey("auto", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	if auto == first || auto == second {
+		t.Fatalf("auto key %s duplicates a key created at its path", auto)
+	}
+
+	paths := map[string]string{}
+	for _, ks := range ring.Status() {
+		paths[ks.GetKeyId()] = ks.GetDerivationPath()
+	}
+	if paths["auto"] != "m/12381/1729/0/0/3" {
+		t.Fatalf("auto key at %s, want the first free index", paths["auto"])
+	}
+}
+
+func TestUpgradeKDFRewrapsKeysAndSeed(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.InitMaster(); err != nil {
+		t.Fatalf("InitMaster: %v", err)
+	}
+	// an old, cheaper store
+	mf, err := store.readMaster()
+	if err != nil {
+		t.Fatalf("readMaster: %v", err)
+	}
+	mf.Params = argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	if err := writeJSONSync(filepath.Join(store.base, masterFileName), mf, 0o600); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+
+	pass := []byte("upgrade-passphrase")
+	keyPass := []byte("key-passphrase")
+	if err := store.WriteSeed(pass, true); err != nil {
+		t.Fatalf("WriteSeed: %v", err)
+	}
+	ring := NewKeyRing(log, store)
+	plainID, _, plainTz4, err := ring.CreateKey("plain", pass, nil)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	guardedID, _, _, err := ring.CreateKey("guarded", pass, keyPass)
+	if err != nil {
+		t.Fatalf("CreateKey guarded: %v", err)
+	}
+	if err := ring.Unlock(plainID, pass, nil); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	t.Cleanup(func() { _ = ring.Lock(plainID) })
+
+	st, err := ring.KDFStatus()
+	if err != nil || !st.Weak || st.Pending {
+		t.Fatalf("unexpected status before upgrade: %+v, %v", st, err)
+	}
+	if _, err := ring.UpgradeKDF([]byte("wrong")); err == nil {
+		t.Fatalf("expected upgrade with wrong passphrase to fail")
+	}
+	upgraded, err := ring.UpgradeKDF(pass)
+	if err != nil || !upgraded {
+		t.Fatalf("UpgradeKDF: %v (upgraded=%v)", err, upgraded)
+	}
+	if st, err = ring.KDFStatus(); err != nil || st.Weak || st.Pending {
+		t.Fatalf("unexpected status after upgrade: %+v, %v", st, err)
+	}
+	if upgraded, err = ring.UpgradeKDF(pass); err != nil || upgraded {
+		t.Fatalf("second UpgradeKDF should be a no-op: %v (upgraded=%v)", err, upgraded)
+	}
+
+	// already unlocked keys keep signing
+	if _, err := ring.SignAndUpdate(plainTz4, buildPreattestationPayload(5, 0)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+
+	fresh := NewKeyRing(log, store)
+	if err := fresh.Unlock(plainID, pass, nil); err != nil {
+		t.Fatalf("Unlock after upgrade: %v", err)
+	}
+	t.Cleanup(func() { _ = fresh.Lock(plainID) })
+	if err := fresh.Unlock(guardedID, pass, keyPass); err != nil {
+		t.Fatalf("Unlock guarded after upgrade: %v", err)
+	}
+	t.Cleanup(func() { _ = fresh.Lock(guardedID) })
+	if err := fresh.VerifyMasterPassword(pass); err != nil {
+		t.Fatalf("seed unreadable after upgrade: %v", err)
+	}
+	// HD derivation still uses the original salt
+	if _, _, _, err := fresh.CreateKeyAtPath("again", "m/12381/1729/0/0/1", pass, nil); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected the first HD key's path to still map to it, got %v", err)
+	}
+}
+
+func TestUsageCountersPersistAcrossUnlock(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+
+	for level := uint64(1); level <= 3; level++ {
+		if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(level, 0)); err != nil {
+			t.Fatalf("SignAndUpdate level %d: %v", level, err)
+		}
+	}
+	// refused signatures are not counted
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(3, 0)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected ErrStaleWatermark, got %v", err)
+	}
+
+	usage := func() (uint64, int64) {
+		for _, st := range setup.ring.Status() {
+			if st.GetKeyId() == setup.keyID {
+				return st.GetSignCount(), st.GetLastUsedUnix()
+			}
+		}
+		t.Fatalf("key %q missing from status", setup.keyID)
+		return 0, 0
+	}
+	count, lastUsed := usage()
+	if count != 3 || lastUsed == 0 {
+		t.Fatalf("unexpected usage: count=%d last_used=%d", count, lastUsed)
+	}
+
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if count, _ := usage(); count != 0 {
+		t.Fatalf("locked key reported usage: count=%d", count)
+	}
+	if err := setup.ring.Unlock(setup.keyID, []byte("bench-passphrase"), nil); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if count, ts := usage(); count != 3 || ts != lastUsed {
+		t.Fatalf("usage not persisted: count=%d last_used=%d", count, ts)
+	}
+
+	ring := NewKeyRing(slog.New(slog.NewTextHandler(io.Discard, nil)), setup.store)
+	if err := ring.Unlock(setup.keyID, []byte("bench-passphrase"), nil); err != nil {
+		t.Fatalf("Unlock in fresh ring: %v", err)
+	}
+	defer ring.Lock(setup.keyID)
+	if _, err := ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(4, 0)); err != nil {
+		t.Fatalf("SignAndUpdate in fresh ring: %v", err)
+	}
+	if ks := ring.get(setup.keyID).GetKeyState(); ks.GetSignCount() != 4 {
+		t.Fatalf("expected count to continue from disk, got %d", ks.GetSignCount())
+	}
+}
+
+func TestXChaChaStoreRoundTrip(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.InitMasterWithCipher(CipherSuite("rot13")); !errors.Is(err, ErrUnknownCipherSuite) {
+		t.Fatalf("expected ErrUnknownCipherSuite, got %v", err)
+	}
+	if err := store.InitMasterWithCipher(CipherXChaCha20Poly1305); err != nil {
+		t.Fatalf("InitMasterWithCipher: %v", err)
+	}
+	if suite, err := store.CipherSuite(); err != nil || suite != CipherXChaCha20Poly1305 {
+		t.Fatalf("unexpected suite %q (%v)", suite, err)
+	}
+
+	pass := []byte("xchacha-passphrase")
+	keyPass := []byte("key-passphrase")
+	if err := store.WriteSeed(pass, true); err != nil {
+		t.Fatalf("WriteSeed: %v", err)
+	}
+	ring := NewKeyRing(log, store)
+	id, _, tz4, err := ring.CreateKey("x", pass, keyPass)
+	if err != nil {
+		t.Fatalf("CreateKey: %v", err)
+	}
+	meta, err := store.readKeyMeta(id)
+	if err != nil {
+		t.Fatalf("readKeyMeta: %v", err)
+	}
+	if len(meta.WrapNonce) != 24 || len(meta.DataNonce) != 24 || len(meta.KeyWrapNonce) != 24 {
+		t.Fatalf("expected 24-byte nonces, got %d/%d/%d", len(meta.WrapNonce), len(meta.DataNonce), len(meta.KeyWrapNonce))
+	}
+
+	if err := ring.Unlock(id, pass, keyPass); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, err := ring.SignAndUpdate(tz4, buildPreattestationPayload(7, 0)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+	if err := ring.Lock(id); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	info, err := os.Stat(store.keyStatePath(id))
+	if err != nil {
+		t.Fatalf("stat state: %v", err)
+	}
+	if want := int64(2 * keyStateSlotSizeFor(CipherXChaCha20Poly1305)); info.Size() != want {
+		t.Fatalf("expected state file size %d, got %d", want, info.Size())
+	}
+
+	if err := ring.Unlock(id, pass, keyPass); err != nil {
+		t.Fatalf("re-Unlock: %v", err)
+	}
+	defer ring.Lock(id)
+	if _, err := ring.SignAndUpdate(tz4, buildPreattestationPayload(7, 0)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected watermark to survive re-unlock, got %v", err)
+	}
+	if _, _, _, err := ring.CreateKey("hd", pass, nil); err != nil {
+		t.Fatalf("CreateKey from seed: %v", err)
+	}
+}
+
+func TestKEKCheckSeparatesWrongPassphraseFromCorruption(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	if err := setup.ring.Unlock(setup.keyID, []byte("wrong"), nil); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase, got %v", err)
+	}
+	if err := setup.ring.VerifyMasterPassword([]byte("wrong")); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ErrBadPassphrase from verify, got %v", err)
+	}
+
+	binPath := setup.store.keyBinPath(setup.keyID)
+	raw, err := os.ReadFile(binPath)
+	if err != nil {
+		t.Fatalf("read bundle: %v", err)
+	}
+	damaged := slices.Clone(raw)
+	damaged[4] ^= 0xff // inside the wrapped DEK
+	if err := os.WriteFile(binPath, damaged, 0o600); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); !errors.Is(err, ErrStoreCorrupted) {
+		t.Fatalf("expected ErrStoreCorrupted, got %v", err)
+	}
+	if err := os.WriteFile(binPath, raw, 0o600); err != nil {
+		t.Fatalf("restore bundle: %v", err)
+	}
+
+	// stores from before the check value get one on the next good unlock
+	mf, err := setup.store.readMaster()
+	if err != nil {
+		t.Fatalf("readMaster: %v", err)
+	}
+	mf.KEKCheck = nil
+	if err := writeJSONSync(filepath.Join(setup.store.base, masterFileName), mf, 0o600); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, []byte("wrong"), nil); err == nil || errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("expected ambiguous unlock error without check value, got %v", err)
+	}
+	if err := setup.ring.Unlock(setup.keyID, pass, nil); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if mf, err = setup.store.readMaster(); err != nil || len(mf.KEKCheck) != kekCheckSize {
+		t.Fatalf("check value not recorded: %x (%v)", mf.KEKCheck, err)
+	}
+}
+
+func TestThresholdSharesCombineToGroupSignature(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	pass := []byte("bench-passphrase")
+
+	groupSK, groupPK, groupBLpk := signer.GenerateRandomKey()
+	shares, err := signer.SplitSecretKey(groupSK, 2, 3)
+	if err != nil {
+		t.Fatalf("SplitSecretKey: %v", err)
+	}
+	info := func(idx uint32) ShareInfo {
+		return ShareInfo{GroupPubkey: groupBLpk, Index: idx, Threshold: 2, Total: 3}
+	}
+
+	if _, _, _, err := setup.ring.ImportShare("s", signer.EncodeBLSecretKey(groupSK), info(1), pass, nil); !errors.Is(err, ErrInvalidShare) {
+		t.Fatalf("expected ErrInvalidShare for the group key itself, got %v", err)
+	}
+	if _, _, _, err := setup.ring.ImportShare("s", signer.EncodeBLSecretKey(shares[0].Secret), info(4), pass, nil); !errors.Is(err, ErrInvalidShare) {
+		t.Fatalf("expected ErrInvalidShare for index past total, got %v", err)
+	}
+
+	// shares 1 and 3 sign on "their devices"
+	var (
+		indices []uint32
+		pks     [][]byte
+		sigs    [][]byte
+	)
+	raw := buildPreattestationPayload(5, 0)
+	for _, s := range []signer.KeyShare{shares[0], shares[2]} {
+		id, blpk, tz4, err := setup.ring.ImportShare("", signer.EncodeBLSecretKey(s.Secret), info(s.Index), pass, nil)
+		if err != nil {
+			t.Fatalf("ImportShare %d: %v", s.Index, err)
+		}
+		if err := setup.ring.Unlock(id, pass, nil); err != nil {
+			t.Fatalf("Unlock %s: %v", id, err)
+		}
+		pub, err := setup.ring.PublicKey(tz4)
+		if err != nil || pub.GetShare().GetGroupTz4() == "" || pub.GetShare().GetIndex() != s.Index {
+			t.Fatalf("PublicKey %s: %+v %v", id, pub, err)
+		}
+		sig, err := setup.ring.SignAndUpdate(tz4, raw)
+		if err != nil {
+			t.Fatalf("SignAndUpdate share %d: %v", s.Index, err)
+		}
+		pk, _ := signer.DecodeBLPubkey(blpk)
+		indices, pks, sigs = append(indices, s.Index), append(pks, pk), append(sigs, sig)
+	}
+	if _, _, _, err := setup.ring.ImportShare("again", signer.EncodeBLSecretKey(shares[0].Secret), info(1), pass, nil); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists for a share imported twice, got %v", err)
+	}
+
+	combined, err := signer.CombineSignatures(indices, sigs)
+	if err != nil {
+		t.Fatalf("CombineSignatures: %v", err)
+	}
+	if !signer.VerifyCompressed(groupPK, combined, raw) {
+		t.Fatal("combined signature does not verify under the group key")
+	}
+	if group, err := signer.CombinePublicKeys(indices, pks); err != nil || !slices.Equal(group, groupPK) {
+		t.Fatalf("CombinePublicKeys: %x %v", group, err)
+	}
+	// one partial is not enough
+	if one, err := signer.CombineSignatures(indices[:1], sigs[:1]); err != nil || signer.VerifyCompressed(groupPK, one, raw) {
+		t.Fatalf("a single partial verified under the group key (%v)", err)
+	}
+}
+
+func TestRegeneratePoPRepairsDamagedMeta(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+
+	if err := setup.store.updateKeyMeta(setup.keyID, func(meta *keyMeta) error {
+		meta.Pop = "BLsigdamaged"
+		return nil
+	}); err != nil {
+		t.Fatalf("updateKeyMeta: %v", err)
+	}
+	pk, err := setup.ring.RegeneratePoP(setup.keyID)
+	if err != nil {
+		t.Fatalf("RegeneratePoP: %v", err)
+	}
+	if !signer.VerifyPoP(pk.GetBlPubkey(), pk.GetPop()) {
+		t.Fatalf("regenerated PoP does not verify: %s", pk.GetPop())
+	}
+	if meta, _ := setup.store.readKeyMeta(setup.keyID); meta.Pop != pk.GetPop() {
+		t.Fatalf("meta.json PoP = %q, want %q", meta.Pop, pk.GetPop())
+	}
+
+	if err := setup.ring.Lock(setup.keyID); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if _, err := setup.ring.RegeneratePoP(setup.keyID); !errors.Is(err, ErrKeyLocked) {
+		t.Fatalf("expected ErrKeyLocked, got %v", err)
+	}
+	if _, err := setup.ring.RegeneratePoP("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestSignAndUpdateLogsSignDecisions(t *testing.T) {
+	setup := newBenchmarkSetup(t)
+	var out strings.Builder
+	setup.ring.log = slog.New(slog.NewJSONHandler(&out, nil))
+
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(4, 1)); err != nil {
+		t.Fatalf("SignAndUpdate: %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate(setup.tz4, buildPreattestationPayload(4, 1)); !errors.Is(err, ErrStaleWatermark) {
+		t.Fatalf("expected ErrStaleWatermark, got %v", err)
+	}
+	if _, err := setup.ring.SignAndUpdate("tz4unknown", buildPreattestationPayload(5, 0)); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	type event struct {
+		Level      string `json:"level"`
+		Event      string `json:"event"`
+		KeyID      string `json:"key_id"`
+		TZ4        string `json:"tz4"`
+		Kind       string `json:"kind"`
+		OpLevel    uint64 `json:"op_level"`
+		Round      uint32 `json:"round"`
+		Decision   string `json:"decision"`
+		Reason     string `json:"reason"`
+		DurationUS *int64 `json:"duration_us"`
+	}
+	var got []event
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var ev event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("bad record %q: %v", line, err)
+		}
+		if ev.Event == AuditEventSignDecision {
+			ev.DurationUS = nil // only checked for presence below
+			if !strings.Contains(line, `"duration_us":`) {
+				t.Fatalf("no duration in %s", line)
+			}
+			got = append(got, ev)
+		}
+	}
+	want := []event{
+		{Level: "INFO", Event: AuditEventSignDecision, KeyID: setup.keyID, TZ4: setup.tz4, Kind: "preattestation", OpLevel: 4, Round: 1, Decision: DecisionSigned},
+		{Level: "WARN", Event: AuditEventSignDecision, KeyID: setup.keyID, TZ4: setup.tz4, Kind: "preattestation", OpLevel: 4, Round: 1, Decision: DecisionRefused, Reason: SignReasonStale},
+		{Level: "WARN", Event: AuditEventSignDecision, TZ4: "tz4unknown", Kind: "preattestation", OpLevel: 5, Decision: DecisionRefused, Reason: SignReasonKeyNotFound},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("sign decisions:\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -1,10 +1,14 @@
 package keychain
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/tez-capital/tezsign/secure"
 	"github.com/tez-capital/tezsign/signer"
@@ -12,8 +16,9 @@ import (
 )
 
 type Keys struct {
-	mu   sync.RWMutex
-	byID map[string]*gKey
+	mu    sync.RWMutex
+	byID  map[string]*gKey
+	byTZ4 map[string]string // tz4 -> id, kept in step with byID
 }
 
 type keyEntry struct {
@@ -37,12 +42,27 @@ func (keys *Keys) Insert(id string, key *gKey) bool {
 	}
 	if keys.byID == nil {
 		keys.byID = make(map[string]*gKey)
+		keys.byTZ4 = make(map[string]string)
 	}
 
 	keys.byID[id] = key
+	if tz4 := key.address(); tz4 != "" {
+		keys.byTZ4[tz4] = id
+	}
 	return true
 }
 
+func (keys *Keys) LoadByTZ4(tz4 string) (string, *gKey, bool) {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	id, ok := keys.byTZ4[tz4]
+	if !ok {
+		return "", nil, false
+	}
+	return id, keys.byID[id], true
+}
+
 func (keys *Keys) LoadAndDelete(id string) (*gKey, bool) {
 	keys.mu.Lock()
 	defer keys.mu.Unlock()
@@ -52,6 +72,9 @@ func (keys *Keys) LoadAndDelete(id string) (*gKey, bool) {
 		return nil, false
 	}
 	delete(keys.byID, id)
+	if tz4 := key.address(); keys.byTZ4[tz4] == id {
+		delete(keys.byTZ4, tz4)
+	}
 	return key, true
 }
 
@@ -75,24 +98,40 @@ type gKey struct {
 	mu sync.Mutex // per-key lock
 
 	// in-memory working material (present only while "unlocked")
-	dek       []byte // 32B per-key data encryption key (wrapped by master on disk)
-	encSecret []byte // ciphertext of 32B LE scalar (AES-GCM with DEK)
-	dataNonce []byte // 12B AES-GCM nonce for encSecret
+	dek       *secure.SecretBuffer // 32B per-key data encryption key (wrapped by master on disk)
+	encSecret []byte               // ciphertext of 32B LE scalar (AEAD with DEK)
+	dataNonce []byte               // AEAD nonce for encSecret
+	suite     CipherSuite          // store cipher suite
 
 	// AAD binding (needed at decrypt time to authenticate metadata)
 	blPubkey string
 	tz4      string
+	pubkey   []byte // blPubkey decoded, for checking signatures before they leave
 
+	// watermark also carries kinds found on disk that this build does not
+	// know, so rewriting the state file never drops them.
 	watermark map[SIGN_KIND]HighWatermark
+	validity  Validity
+	policy    policyCheck
 	hwmFile   *keyHWMFile
 	hwmSeq    uint64
 
+	// usage counters persisted with the watermarks
+	signCount uint64
+	lastUsed  int64 // unix seconds
+
+	// whether meta.json must carry a validity window and a policy,
+	// persisted with the watermarks (see loadValidity, loadPolicy)
+	hasValidity bool
+	hasPolicy   bool
+
 	hwmCorrupted bool
 }
 
 func newWatermarks() map[SIGN_KIND]HighWatermark {
-	watermarks := make(map[SIGN_KIND]HighWatermark, len(allSignKinds))
-	for _, kind := range allSignKinds {
+	kinds := registeredSignKinds()
+	watermarks := make(map[SIGN_KIND]HighWatermark, len(kinds))
+	for _, kind := range kinds {
 		watermarks[kind] = HighWatermark{}
 	}
 	return watermarks
@@ -113,10 +152,10 @@ func (k *gKey) lock() func() {
 	}
 }
 
-func (k *gKey) matchesTz4(tz4 string) bool {
+func (k *gKey) address() string {
 	unlock := k.lock()
 	defer unlock()
-	return k.tz4 == tz4
+	return k.tz4
 }
 
 func (k *gKey) markHWMCorrupted(corrupted bool) {
@@ -125,24 +164,57 @@ func (k *gKey) markHWMCorrupted(corrupted bool) {
 	k.hwmCorrupted = corrupted
 }
 
-func (k *gKey) unlock(log *slog.Logger, store *FileStore, id string, masterPassword []byte) error {
-	dek, encSecret, dataNonce, blPubkey, tz4, err := store.unlock(id, masterPassword)
+func (k *gKey) unlock(log *slog.Logger, store *FileStore, id string, masterPassword, keyPassphrase []byte) error {
+	dek, encSecret, dataNonce, blPubkey, tz4, err := store.unlock(id, masterPassword, keyPassphrase)
 	if err != nil {
 		return err
 	}
-	if len(dek) != 32 {
-		secure.MemoryWipe(dek)
-		return fmt.Errorf("load state: bad DEK length %d", len(dek))
+	if dek.Len() != 32 {
+		dek.Close()
+		return fmt.Errorf("load state: bad DEK length %d", dek.Len())
 	}
 
-	hwmFile, keyState, hwmSeq, corrupted, err := openKeyHWMFile(store.keyStatePath(id), dek, id, tz4)
+	meta, err := store.readKeyMeta(id)
+	if err != nil {
+		dek.Close()
+		return err
+	}
+	suite, err := store.CipherSuite()
+	if err != nil {
+		dek.Close()
+		return err
+	}
+	pubkey, err := signer.DecodeBLPubkey(blPubkey)
+	if err != nil {
+		dek.Close()
+		return fmt.Errorf("load key: %w", err)
+	}
+	hwmFile, keyState, hwmSeq, corrupted, err := openKeyHWMFile(store.keyStatePath(id), suite, dek.Bytes(), id, tz4)
 	if err != nil {
 		if errors.Is(err, ErrKeyStateCorrupted) {
 			k.markHWMCorrupted(true)
 		}
-		secure.MemoryWipe(dek)
+		dek.Close()
 		return fmt.Errorf("load state: %w", err)
 	}
+	fail := func(err error) error {
+		_ = hwmFile.Close()
+		dek.Close()
+		return err
+	}
+
+	validity, err := loadValidity(meta, dek.Bytes(), id, keyState.GetHasValidity())
+	if err != nil {
+		return fail(err)
+	}
+	policy, err := loadPolicy(meta, dek.Bytes(), id, keyState.GetHasPolicy())
+	if err != nil {
+		return fail(err)
+	}
+	check, err := policy.compile()
+	if err != nil {
+		return fail(err)
+	}
 
 	unlock := k.lock()
 	defer unlock()
@@ -155,13 +227,21 @@ func (k *gKey) unlock(log *slog.Logger, store *FileStore, id string, masterPassw
 	k.dek = dek
 	k.encSecret = encSecret
 	k.dataNonce = dataNonce
+	k.suite = suite
 	k.blPubkey = blPubkey
 	k.tz4 = tz4
+	k.pubkey = pubkey
 	k.hwmFile = hwmFile
 	k.hwmSeq = hwmSeq
 	k.hwmCorrupted = corrupted
+	k.validity = validity
+	k.policy = check
 	k.applyKeyState(keyState)
 
+	// windows and policies set before the state recorded them
+	if err := k.sealRestrictions(id, !validity.IsZero(), !policy.IsZero()); err != nil {
+		log.Warn("record key restrictions in state", "key", id, "err", err)
+	}
 	return nil
 }
 
@@ -183,33 +263,41 @@ func (k *gKey) dispose(log *slog.Logger, id string) {
 	}
 }
 
+// reloadState rereads the state file of an unlocked key, so what is reported
+// is what is on disk. Callers hold the key lock.
+func (k *gKey) reloadState(id string, log *slog.Logger) {
+	ksDisk, seqDisk, missingState, corrupted, err := k.hwmFile.load(k.dek.Bytes(), id, k.tz4)
+	if err != nil {
+		if errors.Is(err, ErrKeyStateCorrupted) {
+			k.hwmCorrupted = true
+		} else {
+			log.Error("status: check state", "key", id, "err", err)
+		}
+		return
+	}
+	k.hwmSeq = seqDisk
+	switch {
+	case corrupted:
+		k.hwmCorrupted = true
+		k.resetWatermarks()
+	case missingState:
+		k.hwmCorrupted = false
+		k.resetWatermarks()
+		k.signCount, k.lastUsed = 0, 0
+		k.hwmSeq = 0
+	default:
+		k.hwmCorrupted = false
+		k.applyKeyState(ksDisk)
+	}
+}
+
 func (k *gKey) populateStatus(id string, status *signerpb.KeyStatus, log *slog.Logger) {
 	unlock := k.lock()
 	defer unlock()
 
 	isUnlocked := k.isUnlocked()
 	if isUnlocked {
-		if ksDisk, seqDisk, missingState, corrupted, err := k.hwmFile.load(k.dek, id, k.tz4); err != nil {
-			if errors.Is(err, ErrKeyStateCorrupted) {
-				k.hwmCorrupted = true
-			} else {
-				log.Error("status: check state", "key", id, "err", err)
-			}
-		} else {
-			k.hwmSeq = seqDisk
-			switch {
-			case corrupted:
-				k.hwmCorrupted = true
-				k.resetWatermarks()
-			case missingState:
-				k.hwmCorrupted = false
-				k.resetWatermarks()
-				k.hwmSeq = 0
-			default:
-				k.hwmCorrupted = false
-				k.applyKeyState(ksDisk)
-			}
-		}
+		k.reloadState(id, log)
 	}
 
 	if k.hwmCorrupted && isUnlocked {
@@ -232,13 +320,54 @@ func (k *gKey) populateStatus(id string, status *signerpb.KeyStatus, log *slog.L
 	status.LastBlockRound = block.round
 	status.LastPreattestationRound = preattestation.round
 	status.LastAttestationRound = attestation.round
+
+	status.SignCount = k.signCount
+	status.LastUsedUnix = k.lastUsed
 }
 
-func (k *gKey) signAndUpdate(keyID string, raw []byte) ([]byte, error) {
+// populateWatermarks fills in every kind's watermark, including kinds on disk
+// this build does not know, in kind order.
+func (k *gKey) populateWatermarks(id string, out *signerpb.KeyWatermarks, log *slog.Logger) {
+	unlock := k.lock()
+	defer unlock()
+
+	if !k.isUnlocked() {
+		return
+	}
+	k.reloadState(id, log)
+	if k.hwmCorrupted {
+		out.StateCorrupted = true
+		return
+	}
+	out.LockState = signerpb.LockState_UNLOCKED
+
+	kinds := slices.Sorted(maps.Keys(k.watermark))
+	out.Watermarks = make([]*signerpb.WatermarkEntry, 0, len(kinds))
+	for _, kind := range kinds {
+		hw := k.watermark[kind]
+		out.Watermarks = append(out.Watermarks, &signerpb.WatermarkEntry{
+			Kind:   kind.String(),
+			KindId: uint32(kind),
+			Level:  hw.level,
+			Round:  hw.round,
+		})
+	}
+	out.Sequence = k.hwmSeq
+	out.SignCount = k.signCount
+	out.LastUsedUnix = k.lastUsed
+}
+
+// signAndUpdate fills ev's kind, level and round as soon as they are known.
+func (k *gKey) signAndUpdate(keyID string, raw []byte, ev *SignEvent) ([]byte, error) {
 	knd, level, round, signBytes, err := DecodeAndValidateSignPayload(raw)
 	if err != nil {
 		return nil, ErrBadPayload
 	}
+	spec, ok := lookupSignKind(knd)
+	if !ok {
+		return nil, ErrUnsupportedOperation
+	}
+	ev.Kind, ev.Level, ev.Round = spec.Name, level, round
 
 	unlock := k.lock()
 	defer unlock()
@@ -252,9 +381,16 @@ func (k *gKey) signAndUpdate(keyID string, raw []byte) ([]byte, error) {
 	if k.hwmCorrupted {
 		return nil, ErrKeyStateCorrupted
 	}
+	now := time.Now()
+	if !k.validity.allows(now, level) {
+		return nil, ErrOutsideValidity
+	}
+	if err := k.policy.allows(knd, raw, now); err != nil {
+		return nil, err
+	}
 
 	prev := k.watermark[knd]
-	if !(level > prev.level || (level == prev.level && round > prev.round)) {
+	if !spec.Advances(prev, HighWatermark{level: level, round: round}) {
 		return nil, ErrStaleWatermark
 	}
 
@@ -263,44 +399,116 @@ func (k *gKey) signAndUpdate(keyID string, raw []byte) ([]byte, error) {
 		Level: level,
 		Round: round,
 	}
+	nextState.SignCount = k.signCount + 1
+	nextState.LastUsedUnix = max(k.lastUsed, now.Unix())
 	nextSeq := k.hwmSeq + 1
 
-	k.hwmFile.persistAsync(k.dek, keyID, k.tz4, nextState, nextSeq)
+	k.hwmFile.persistAsync(k.dek.Bytes(), keyID, k.tz4, nextState, nextSeq)
 
-	gcmDEK, err := newAESGCM(k.dek)
-	if err != nil {
+	var sig []byte
+	var checkErr error
+	if err := k.withSecretKey(func(sk *signer.SecretKey) {
+		sig, _ = signer.SignCompressed(sk, signBytes)
+		checkErr = k.checkSignature(sk, sig, signBytes)
+	}); err != nil {
 		return nil, err
 	}
+
+	if err := k.hwmFile.waitPersist(); err != nil {
+		return nil, fmt.Errorf("persist state: %w", err)
+	}
+
+	k.watermark[knd] = HighWatermark{level: level, round: round}
+	k.signCount = nextState.SignCount
+	k.lastUsed = nextState.LastUsedUnix
+	k.hwmSeq = nextSeq
+	k.hwmCorrupted = false
+	k.policy.record(now)
+
+	// the watermark stays raised: the level was as good as signed
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return sig, nil
+}
+
+// withSecretKey decrypts the secret for the duration of fn; the caller holds
+// the key lock and has checked that the key is unlocked.
+func (k *gKey) withSecretKey(fn func(sk *signer.SecretKey)) error {
+	gcmDEK, err := k.suite.newAEAD(k.dek.Bytes())
+	if err != nil {
+		return err
+	}
 	aad := []byte("bl=" + k.blPubkey + "|tz4=" + k.tz4)
 
-	le, err := gcmDEK.Open(nil, k.dataNonce, k.encSecret, aad)
+	le, err := openSecret(gcmDEK, k.dataNonce, k.encSecret, aad)
 	if err != nil {
-		return nil, fmt.Errorf("corrupted key (secret)")
+		return fmt.Errorf("corrupted key (secret)")
 	}
-	if len(le) != 32 {
-		secure.MemoryWipe(le)
-		return nil, fmt.Errorf("secret length invalid")
+	defer le.Close()
+	if le.Len() != 32 {
+		return fmt.Errorf("secret length invalid")
 	}
 
 	var sk signer.SecretKey
-	if sk.FromLEndian(le) == nil {
-		secure.MemoryWipe(le)
-		return nil, fmt.Errorf("invalid scalar")
+	if sk.FromLEndian(le.Bytes()) == nil {
+		return fmt.Errorf("invalid scalar")
 	}
+	defer sk.Zeroize()
+	fn(&sk)
+	return nil
+}
 
-	sig, _ := signer.SignCompressed(&sk, signBytes)
-	secure.MemoryWipe(le)
-	sk.Zeroize()
+// regeneratePoP signs a fresh proof of possession and stores it in meta.json,
+// after checking that the secret still matches the key's BLpk.
+func (k *gKey) regeneratePoP(store *FileStore, id string) (string, error) {
+	unlock := k.lock()
+	defer unlock()
 
-	if err := k.hwmFile.waitPersist(); err != nil {
-		return nil, fmt.Errorf("persist state: %w", err)
+	if k.dek == nil || k.encSecret == nil || k.dataNonce == nil {
+		return "", ErrKeyLocked
+	}
+	var pop string
+	var popErr error
+	if err := k.withSecretKey(func(sk *signer.SecretKey) {
+		if pub, _ := signer.PublicKeyFromSecret(sk); !bytes.Equal(pub, k.pubkey) {
+			popErr = fmt.Errorf("%w: secret does not match public key", ErrSignatureSelfCheck)
+			return
+		}
+		var popBytes []byte
+		popBytes, pop, popErr = signer.SignPoPCompressed(sk, k.pubkey)
+		if popErr == nil && !signer.VerifyPoPCompressed(k.pubkey, popBytes) {
+			popErr = fmt.Errorf("%w: proof of possession does not verify", ErrSignatureSelfCheck)
+		}
+	}); err != nil {
+		return "", err
+	}
+	if popErr != nil {
+		return "", popErr
 	}
 
-	k.watermark[knd] = HighWatermark{level: level, round: round}
-	k.hwmSeq = nextSeq
-	k.hwmCorrupted = false
+	if err := store.updateKeyMeta(id, func(meta *keyMeta) error {
+		meta.Pop = pop
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return pop, nil
+}
 
-	return sig, nil
+// checkSignature fails closed on a signature that would not verify against
+// the key's published BLpk, e.g. after a bit flip in the secret or in the
+// signature itself. The key check catches a scalar that no longer matches
+// the public key even where the signature happens to verify for it.
+func (k *gKey) checkSignature(sk *signer.SecretKey, sig, msg []byte) error {
+	pub, _ := signer.PublicKeyFromSecret(sk)
+	if !bytes.Equal(pub, k.pubkey) {
+		return fmt.Errorf("%w: secret does not match public key", ErrSignatureSelfCheck)
+	}
+	if !signer.VerifyCompressed(k.pubkey, sig, msg) {
+		return fmt.Errorf("%w: signature does not verify", ErrSignatureSelfCheck)
+	}
+	return nil
 }
 
 func (k *gKey) setLevel(id string, level uint64) error {
@@ -314,7 +522,8 @@ func (k *gKey) setLevel(id string, level uint64) error {
 		return fmt.Errorf("high-watermark file is not open")
 	}
 
-	for _, kind := range allSignKinds {
+	kinds := registeredSignKinds()
+	for _, kind := range kinds {
 		current := k.watermark[kind].level
 		if level <= current {
 			return fmt.Errorf("level must be greater than current %s level (current=%d)", kind, current)
@@ -322,7 +531,7 @@ func (k *gKey) setLevel(id string, level uint64) error {
 	}
 
 	nextState := k.keyStateSnapshot()
-	for _, kind := range allSignKinds {
+	for _, kind := range kinds {
 		nextState.ByKind[int32(kind)] = &KindState{
 			Level: level,
 			Round: 0,
@@ -330,11 +539,11 @@ func (k *gKey) setLevel(id string, level uint64) error {
 	}
 	nextSeq := k.hwmSeq + 1
 
-	if err := k.hwmFile.persist(k.dek, id, k.tz4, nextState, nextSeq); err != nil {
+	if err := k.hwmFile.persist(k.dek.Bytes(), id, k.tz4, nextState, nextSeq); err != nil {
 		return err
 	}
 
-	for _, kind := range allSignKinds {
+	for _, kind := range kinds {
 		k.watermark[kind] = HighWatermark{level: level, round: 0}
 	}
 	k.hwmSeq = nextSeq
@@ -342,22 +551,132 @@ func (k *gKey) setLevel(id string, level uint64) error {
 	return nil
 }
 
+func (k *gKey) setValidity(store *FileStore, id string, v Validity) error {
+	unlock := k.lock()
+	defer unlock()
+
+	if k.dek == nil {
+		return ErrKeyLocked
+	}
+
+	var mac []byte
+	if !v.IsZero() {
+		var err error
+		if mac, err = validityMAC(k.dek.Bytes(), id, k.tz4, v); err != nil {
+			return err
+		}
+	}
+	// a window being cleared leaves the state before meta.json, so a crash
+	// in between leaves it enforced rather than the key refused
+	if err := k.sealRestrictions(id, k.hasValidity && !v.IsZero(), k.hasPolicy); err != nil {
+		return err
+	}
+	err := store.updateKeyMeta(id, func(meta *keyMeta) error {
+		if v.IsZero() {
+			meta.Validity, meta.ValidityMAC = nil, nil
+			return nil
+		}
+		meta.Validity, meta.ValidityMAC = &v, mac
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	k.validity = v
+	return k.sealRestrictions(id, !v.IsZero(), k.hasPolicy)
+}
+
+// sealRestrictions records in the state file whether meta.json carries a
+// validity window and a policy, persisting only on a change. Callers hold
+// the key lock.
+func (k *gKey) sealRestrictions(id string, hasValidity, hasPolicy bool) error {
+	if k.hasValidity == hasValidity && k.hasPolicy == hasPolicy {
+		return nil
+	}
+	nextState := k.keyStateSnapshot()
+	nextState.HasValidity = hasValidity
+	nextState.HasPolicy = hasPolicy
+	nextSeq := k.hwmSeq + 1
+	if err := k.hwmFile.persist(k.dek.Bytes(), id, k.tz4, nextState, nextSeq); err != nil {
+		return err
+	}
+	k.hasValidity, k.hasPolicy = hasValidity, hasPolicy
+	k.hwmSeq = nextSeq
+	return nil
+}
+
+// setPolicy replaces the policy and the validity window in one meta.json
+// write.
+func (k *gKey) setPolicy(store *FileStore, id string, p Policy, v Validity) error {
+	check, err := p.compile()
+	if err != nil {
+		return err
+	}
+
+	unlock := k.lock()
+	defer unlock()
+
+	if k.dek == nil {
+		return ErrKeyLocked
+	}
+
+	var pMAC, vMAC []byte
+	if !p.IsZero() {
+		if pMAC, err = policyMAC(k.dek.Bytes(), id, k.tz4, p); err != nil {
+			return err
+		}
+	}
+	if !v.IsZero() {
+		if vMAC, err = validityMAC(k.dek.Bytes(), id, k.tz4, v); err != nil {
+			return err
+		}
+	}
+	// as in setValidity, what is cleared leaves the state first
+	if err := k.sealRestrictions(id, k.hasValidity && !v.IsZero(), k.hasPolicy && !p.IsZero()); err != nil {
+		return err
+	}
+	err = store.updateKeyMeta(id, func(meta *keyMeta) error {
+		meta.Policy, meta.PolicyMAC = nil, nil
+		if !p.IsZero() {
+			meta.Policy, meta.PolicyMAC = &p, pMAC
+		}
+		meta.Validity, meta.ValidityMAC = nil, nil
+		if !v.IsZero() {
+			meta.Validity, meta.ValidityMAC = &v, vMAC
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// signatures already made still count against a new rate limit
+	check.recent = k.policy.recent
+	k.policy = check
+	k.validity = v
+	return k.sealRestrictions(id, !v.IsZero(), !p.IsZero())
+}
+
 // These helpers operate on the current key state; callers establish locking.
 func (k *gKey) resetWatermarks() {
-	for _, kind := range allSignKinds {
-		k.watermark[kind] = HighWatermark{}
-	}
+	k.watermark = newWatermarks()
 }
 
 func (k *gKey) applyKeyState(ks *KeyState) {
 	k.resetWatermarks()
+	k.signCount = ks.GetSignCount()
+	k.lastUsed = ks.GetLastUsedUnix()
+	k.hasValidity = ks.GetHasValidity()
+	k.hasPolicy = ks.GetHasPolicy()
 	if ks == nil || ks.ByKind == nil {
 		return
 	}
-	for _, kind := range allSignKinds {
-		if st, ok := ks.ByKind[int32(kind)]; ok && st != nil {
-			k.watermark[kind] = HighWatermark{level: st.Level, round: st.Round}
+	for kind, st := range ks.ByKind {
+		if st == nil || !validStateKind(kind) {
+			continue
 		}
+		k.watermark[SIGN_KIND(kind)] = HighWatermark{level: st.Level, round: st.Round}
 	}
 }
 
@@ -368,9 +687,15 @@ func (k *gKey) GetKeyState() *KeyState {
 }
 
 func (k *gKey) keyStateSnapshot() *KeyState {
-	ks := &KeyState{ByKind: map[int32]*KindState{}}
-	for _, sk := range allSignKinds {
-		ks.ByKind[int32(sk)] = k.watermark[sk].ToKeyState()
+	ks := &KeyState{
+		ByKind:       map[int32]*KindState{},
+		SignCount:    k.signCount,
+		LastUsedUnix: k.lastUsed,
+		HasValidity:  k.hasValidity,
+		HasPolicy:    k.hasPolicy,
+	}
+	for sk, hw := range k.watermark {
+		ks.ByKind[int32(sk)] = hw.ToKeyState()
 	}
 	return ks
 }
@@ -381,7 +706,7 @@ func (k *gKey) isUnlocked() bool {
 
 func (k *gKey) clearSensitiveMaterial() {
 	if k.dek != nil {
-		secure.MemoryWipe(k.dek)
+		_ = k.dek.Close()
 		k.dek = nil
 	}
 	k.encSecret = nil
@@ -0,0 +1,124 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrSignKindExists   = errors.New("sign kind already registered")
+	ErrSignKindInvalid  = errors.New("invalid sign kind")
+	ErrTooManySignKinds = errors.New("too many sign kinds")
+)
+
+// WatermarkRule reports whether a signature at next may follow the last
+// signature at prev for the same kind.
+type WatermarkRule func(prev, next HighWatermark) bool
+
+// SignKindDecoder extracts (level, round) from a payload whose first byte
+// matched the registered kind. The whole payload is what gets signed.
+type SignKindDecoder func(raw []byte) (level uint64, round uint32, err error)
+
+// SignKindSpec describes a watermarked operation kind.
+type SignKindSpec struct {
+	Kind SIGN_KIND
+	Name string
+	// Decode is required for kinds beyond the built-in Tenderbake ones;
+	// those are decoded inline by DecodeAndValidateSignPayload.
+	Decode SignKindDecoder
+	// Advances defaults to LevelRoundIncreasing when nil.
+	Advances WatermarkRule
+}
+
+// LevelRoundIncreasing is the Tenderbake rule:
+// (level > lastLevel) OR (level == lastLevel && round > lastRound)
+func LevelRoundIncreasing(prev, next HighWatermark) bool {
+	return next.level > prev.level || (next.level == prev.level && next.round > prev.round)
+}
+
+// LevelIncreasing ignores rounds and only accepts a strictly higher level.
+func LevelIncreasing(prev, next HighWatermark) bool {
+	return next.level > prev.level
+}
+
+type signKindRegistry struct {
+	mu    sync.RWMutex
+	specs map[SIGN_KIND]SignKindSpec
+	// kinds is replaced (never mutated in place) so readers can keep it.
+	kinds []SIGN_KIND
+}
+
+var signKinds = newSignKindRegistry(
+	SignKindSpec{Kind: BLOCK, Name: "block", Advances: LevelRoundIncreasing},
+	SignKindSpec{Kind: PREATTESTATION, Name: "preattestation", Advances: LevelRoundIncreasing},
+	SignKindSpec{Kind: ATTESTATION, Name: "attestation", Advances: LevelRoundIncreasing},
+)
+
+func newSignKindRegistry(builtin ...SignKindSpec) *signKindRegistry {
+	reg := &signKindRegistry{specs: make(map[SIGN_KIND]SignKindSpec, len(builtin))}
+	for _, spec := range builtin {
+		reg.specs[spec.Kind] = spec
+		reg.kinds = append(reg.kinds, spec.Kind)
+	}
+	slices.Sort(reg.kinds)
+	return reg
+}
+
+// RegisterSignKind adds a watermarked operation kind. Register kinds during
+// start-up, before keys are unlocked, so every key tracks them from the first
+// state file write.
+func RegisterSignKind(spec SignKindSpec) error {
+	if spec.Kind == UNSPECIFIED {
+		return fmt.Errorf("%w: 0x%02x", ErrSignKindInvalid, byte(spec.Kind))
+	}
+	if spec.Name == "" || spec.Decode == nil {
+		return fmt.Errorf("%w: name and decoder are required", ErrSignKindInvalid)
+	}
+	if spec.Advances == nil {
+		spec.Advances = LevelRoundIncreasing
+	}
+
+	signKinds.mu.Lock()
+	defer signKinds.mu.Unlock()
+
+	if _, ok := signKinds.specs[spec.Kind]; ok {
+		return fmt.Errorf("%w: 0x%02x", ErrSignKindExists, byte(spec.Kind))
+	}
+	if len(signKinds.kinds) >= keyStateMaxKinds {
+		return ErrTooManySignKinds
+	}
+
+	kinds := append(slices.Clone(signKinds.kinds), spec.Kind)
+	slices.Sort(kinds)
+	signKinds.specs[spec.Kind] = spec
+	signKinds.kinds = kinds
+	return nil
+}
+
+// registeredSignKinds returns the registered kinds in ascending order.
+// The returned slice must not be modified.
+func registeredSignKinds() []SIGN_KIND {
+	signKinds.mu.RLock()
+	defer signKinds.mu.RUnlock()
+	return signKinds.kinds
+}
+
+func lookupSignKind(kind SIGN_KIND) (SignKindSpec, bool) {
+	signKinds.mu.RLock()
+	defer signKinds.mu.RUnlock()
+	spec, ok := signKinds.specs[kind]
+	return spec, ok
+}
+
+func signKindByName(name string) (SIGN_KIND, bool) {
+	signKinds.mu.RLock()
+	defer signKinds.mu.RUnlock()
+	for kind, spec := range signKinds.specs {
+		if spec.Name == name {
+			return kind, true
+		}
+	}
+	return UNSPECIFIED, false
+}
@@ -0,0 +1,177 @@
+package keychain
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/signerpb"
+)
+
+var (
+	ErrPolicyRefused  = errors.New("refused by key policy")
+	ErrRateLimited    = errors.New("key signing rate limit reached")
+	ErrPolicyTampered = errors.New("key policy failed authentication")
+	ErrInvalidPolicy  = errors.New("invalid policy")
+)
+
+// Policy narrows what a key signs beyond its watermarks and validity window.
+// Zero fields leave that part open.
+type Policy struct {
+	// Kinds are sign kind names (see kinds.go).
+	Kinds []string `json:"kinds,omitempty"`
+	// ChainID is the Net… id the payload must carry. Only the built-in
+	// Tenderbake kinds have a known chain id position; other kinds are
+	// refused while it is set.
+	ChainID string `json:"chain_id,omitempty"`
+	// MaxPerMinute counts signatures over a sliding minute, in memory only.
+	MaxPerMinute uint32 `json:"max_per_minute,omitempty"`
+	// Validation is the payload validation profile (see strict.go).
+	Validation ValidationProfile `json:"validation,omitempty"`
+}
+
+func (p Policy) IsZero() bool {
+	return len(p.Kinds) == 0 && p.ChainID == "" && p.MaxPerMinute == 0 && p.Validation == ValidationStandard
+}
+
+func PolicyFromProto(pp *signerpb.Policy) (Policy, Validity) {
+	p := Policy{
+		Kinds:        slices.Clone(pp.GetAllowedKinds()),
+		ChainID:      pp.GetChainId(),
+		MaxPerMinute: pp.GetMaxSignsPerMinute(),
+		Validation:   ValidationProfile(pp.GetValidation()),
+	}
+	if p.Validation == "standard" {
+		p.Validation = ValidationStandard
+	}
+	slices.Sort(p.Kinds)
+	p.Kinds = slices.Compact(p.Kinds)
+	return p, ValidityFromProto(pp.GetValidity())
+}
+
+func PolicyToProto(p Policy, v Validity) *signerpb.Policy {
+	pp := &signerpb.Policy{
+		AllowedKinds:      p.Kinds,
+		ChainId:           p.ChainID,
+		MaxSignsPerMinute: p.MaxPerMinute,
+		Validation:        string(p.Validation),
+	}
+	if !v.IsZero() {
+		pp.Validity = v.toProto()
+	}
+	return pp
+}
+
+// compile checks the policy against the registered kinds and turns it into
+// what signAndUpdate tests.
+func (p Policy) compile() (policyCheck, error) {
+	c := policyCheck{perMinute: p.MaxPerMinute}
+	profile, err := ParseValidationProfile(string(p.Validation))
+	if err != nil {
+		return policyCheck{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	c.strict = profile == ValidationStrict
+	if len(p.Kinds) > 0 {
+		c.kinds = make(map[SIGN_KIND]bool, len(p.Kinds))
+		for _, name := range p.Kinds {
+			kind, ok := signKindByName(name)
+			if !ok {
+				return policyCheck{}, fmt.Errorf("%w: unknown sign kind %q", ErrInvalidPolicy, name)
+			}
+			c.kinds[kind] = true
+		}
+	}
+	if p.ChainID != "" {
+		chain, err := signer.DecodeChainID(p.ChainID)
+		if err != nil {
+			return policyCheck{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		c.chainID = chain
+	}
+	return c, nil
+}
+
+// policyCheck is a compiled Policy plus the signatures its rate limit
+// remembers.
+type policyCheck struct {
+	kinds     map[SIGN_KIND]bool // nil allows every kind
+	chainID   []byte
+	perMinute uint32
+	strict    bool
+	recent    []time.Time
+}
+
+func (c *policyCheck) allows(kind SIGN_KIND, raw []byte, now time.Time) error {
+	if c.strict {
+		if err := validateStrict(kind, raw); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadPayload, err)
+		}
+	}
+	if c.kinds != nil && !c.kinds[kind] {
+		return fmt.Errorf("%w: %s not allowed", ErrPolicyRefused, kind)
+	}
+	if c.chainID != nil {
+		switch kind {
+		case BLOCK, PREATTESTATION, ATTESTATION:
+			// watermark byte, then the chain id
+			if len(raw) < 5 || !bytes.Equal(raw[1:5], c.chainID) {
+				return fmt.Errorf("%w: wrong chain", ErrPolicyRefused)
+			}
+		default:
+			return fmt.Errorf("%w: chain of %s payloads cannot be checked", ErrPolicyRefused, kind)
+		}
+	}
+	if c.perMinute > 0 {
+		cutoff := now.Add(-time.Minute)
+		c.recent = slices.DeleteFunc(c.recent, func(t time.Time) bool { return !t.After(cutoff) })
+		if len(c.recent) >= int(c.perMinute) {
+			return ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// record counts a signature made under the policy.
+func (c *policyCheck) record(now time.Time) {
+	if c.perMinute > 0 {
+		c.recent = append(c.recent, now)
+	}
+}
+
+// policyMAC authenticates the policy under the key's DEK, as validityMAC does
+// the window; whether there is a policy is sealed in the state file.
+func policyMAC(dek []byte, id, tz4 string, p Policy) ([]byte, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, dek)
+	mac.Write([]byte("policy|id=" + id + "|tz4=" + tz4 + "|"))
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// loadPolicy returns the policy stored in meta, verifying its MAC.
+// required is the key state's record that a policy was set.
+func loadPolicy(meta keyMeta, dek []byte, id string, required bool) (Policy, error) {
+	if meta.Policy == nil {
+		if required || len(meta.PolicyMAC) != 0 {
+			return Policy{}, ErrPolicyTampered
+		}
+		return Policy{}, nil
+	}
+	want, err := policyMAC(dek, id, meta.TZ4, *meta.Policy)
+	if err != nil {
+		return Policy{}, err
+	}
+	if !hmac.Equal(want, meta.PolicyMAC) {
+		return Policy{}, ErrPolicyTampered
+	}
+	return *meta.Policy, nil
+}
@@ -0,0 +1,122 @@
+package keychain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/tez-capital/tezsign/signer"
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrSelfTest = errors.New("cryptographic self-test failed")
+
+// Known answers for SelfTest. The AES-GCM vectors are test cases 13 and 14
+// of the GCM specification; the others were produced by this code on a
+// known-good machine and only have to stay stable.
+const (
+	katBLSMessage   = "tezsign power-on self-test"
+	katBLSPubkey    = "9112a0386a2340714ba0c6d2df235377a8679c3899d03e6ef04dba7a50ef49e5a1dc93105e9374e93ed301b63487e17c"
+	katBLSSignature = "991727926db8d8ee0838673c7357b8b4f13e69f3aab0e8cdb5d6221dcb5e35dc7662558cc134947590661621b3ea424f09db6dd964c33e07e163b190827c1b54b03a635b2bf1600ba4da3be5d75bbb4290127f7c19c704e94a12659eb7853922"
+
+	katGCMEmpty   = "530f8afbc74536b9a963b4f1c4cb738b"
+	katGCMBlock   = "cea7403d4d606b6e074ec5d3baf39d18d0d1c8a799996bf0265b98b5d48ab919"
+	katXChaCha    = "789e9689e5208d7fd9e1f3c5b5341f483959fc0b770c8e6d6116830dcb630cc5"
+	katArgon2id   = "16253e23a29b20d7c7a0c05f9150e74baaa6cf1832edc838d484fc250a02bef9"
+	katArgon2Pass = "password"
+	katArgon2Salt = "somesaltsomesalt"
+)
+
+// SelfTest runs known-answer tests of every primitive the store and signer
+// depend on. It is meant for startup: faulty RAM or flash can corrupt crypto
+// without crashing anything.
+func SelfTest() error {
+	var errs []error
+	for _, t := range []struct {
+		name string
+		run  func() error
+	}{
+		{"bls", selfTestBLS},
+		{string(CipherAESGCM), func() error { return selfTestAEAD(CipherAESGCM) }},
+		{string(CipherXChaCha20Poly1305), func() error { return selfTestAEAD(CipherXChaCha20Poly1305) }},
+		{"argon2id", selfTestArgon2},
+	} {
+		if err := t.run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrSelfTest, errors.Join(errs...))
+	}
+	return nil
+}
+
+func selfTestBLS() error {
+	ikm := make([]byte, 32)
+	for i := range ikm {
+		ikm[i] = byte(i)
+	}
+	sk := signer.KeyFromIKM(ikm)
+	defer sk.Zeroize()
+
+	msg := []byte(katBLSMessage)
+	pub, _ := signer.PublicKeyFromSecret(sk)
+	if err := expectHex(pub, katBLSPubkey); err != nil {
+		return fmt.Errorf("public key: %w", err)
+	}
+	sig, _ := signer.SignCompressed(sk, msg)
+	if err := expectHex(sig, katBLSSignature); err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+	if !signer.VerifyCompressed(pub, sig, msg) {
+		return errors.New("known signature does not verify")
+	}
+	msg[0] ^= 1
+	if signer.VerifyCompressed(pub, sig, msg) {
+		return errors.New("signature verifies for another message")
+	}
+	return nil
+}
+
+func selfTestAEAD(suite CipherSuite) error {
+	aead, err := suite.newAEAD(make([]byte, 32))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, suite.nonceSize())
+	plain := make([]byte, 16)
+
+	if suite == CipherAESGCM {
+		if err := expectHex(aead.Seal(nil, nonce, nil, nil), katGCMEmpty); err != nil {
+			return fmt.Errorf("empty seal: %w", err)
+		}
+		if err := expectHex(aead.Seal(nil, nonce, plain, nil), katGCMBlock); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+	} else if err := expectHex(aead.Seal(nil, nonce, plain, nil), katXChaCha); err != nil {
+		return fmt.Errorf("seal: %w", err)
+	}
+
+	sealed := aead.Seal(nil, nonce, plain, nil)
+	if got, err := aead.Open(nil, nonce, sealed, nil); err != nil || !bytes.Equal(got, plain) {
+		return errors.New("open of known ciphertext failed")
+	}
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+		return errors.New("tampered ciphertext opened")
+	}
+	return nil
+}
+
+func selfTestArgon2() error {
+	out := argon2.IDKey([]byte(katArgon2Pass), []byte(katArgon2Salt), 1, 1024, 1, 32)
+	return expectHex(out, katArgon2id)
+}
+
+func expectHex(got []byte, want string) error {
+	if hex.EncodeToString(got) != want {
+		return errors.New("known answer mismatch")
+	}
+	return nil
+}
@@ -76,7 +76,15 @@ func (x *KindState) GetRound() uint32 {
 type KeyState struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Index by SING_KIND numeric value (0x11,0x12,0x13).
-	ByKind        map[int32]*KindState `protobuf:"bytes,1,rep,name=by_kind,json=byKind,proto3" json:"by_kind,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ByKind map[int32]*KindState `protobuf:"bytes,1,rep,name=by_kind,json=byKind,proto3" json:"by_kind,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Usage: signatures made with the key and when the last one was, in unix
+	// seconds. Both only ever grow.
+	SignCount    uint64 `protobuf:"varint,2,opt,name=sign_count,json=signCount,proto3" json:"sign_count,omitempty"`
+	LastUsedUnix int64  `protobuf:"varint,3,opt,name=last_used_unix,json=lastUsedUnix,proto3" json:"last_used_unix,omitempty"`
+	// Set while meta.json carries a validity window or a policy, so deleting
+	// one there along with its MAC refuses the key instead of lifting it.
+	HasValidity   bool `protobuf:"varint,4,opt,name=has_validity,json=hasValidity,proto3" json:"has_validity,omitempty"`
+	HasPolicy     bool `protobuf:"varint,5,opt,name=has_policy,json=hasPolicy,proto3" json:"has_policy,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -118,6 +126,34 @@ func (x *KeyState) GetByKind() map[int32]*KindState {
 	return nil
 }
 
+func (x *KeyState) GetSignCount() uint64 {
+	if x != nil {
+		return x.SignCount
+	}
+	return 0
+}
+
+func (x *KeyState) GetLastUsedUnix() int64 {
+	if x != nil {
+		return x.LastUsedUnix
+	}
+	return 0
+}
+
+func (x *KeyState) GetHasValidity() bool {
+	if x != nil {
+		return x.HasValidity
+	}
+	return false
+}
+
+func (x *KeyState) GetHasPolicy() bool {
+	if x != nil {
+		return x.HasPolicy
+	}
+	return false
+}
+
 var File_state_proto protoreflect.FileDescriptor
 
 const file_state_proto_rawDesc = "" +
@@ -125,9 +161,15 @@ const file_state_proto_rawDesc = "" +
 	"\vstate.proto\x12\bkeychain\"7\n" +
 	"\tKindState\x12\x14\n" +
 	"\x05level\x18\x01 \x01(\x04R\x05level\x12\x14\n" +
-	"\x05round\x18\x02 \x01(\rR\x05round\"\x93\x01\n" +
+	"\x05round\x18\x02 \x01(\rR\x05round\"\x9a\x02\n" +
 	"\bKeyState\x127\n" +
-	"\aby_kind\x18\x01 \x03(\v2\x1e.keychain.KeyState.ByKindEntryR\x06byKind\x1aN\n" +
+	"\aby_kind\x18\x01 \x03(\v2\x1e.keychain.KeyState.ByKindEntryR\x06byKind\x12\x1d\n" +
+	"\n" +
+	"sign_count\x18\x02 \x01(\x04R\tsignCount\x12$\n" +
+	"\x0elast_used_unix\x18\x03 \x01(\x03R\flastUsedUnix\x12!\n" +
+	"\fhas_validity\x18\x04 \x01(\bR\vhasValidity\x12\x1d\n" +
+	"\n" +
+	"has_policy\x18\x05 \x01(\bR\thasPolicy\x1aN\n" +
 	"\vByKindEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\x05R\x03key\x12)\n" +
 	"\x05value\x18\x02 \x01(\v2\x13.keychain.KindStateR\x05value:\x028\x01B\x15Z\x13./keychain;keychainb\x06proto3"
@@ -1,6 +1,7 @@
 package keychain
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	crypto_rand "crypto/rand"
@@ -12,6 +13,7 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/tez-capital/tezsign/secure"
@@ -21,7 +23,7 @@ import (
 const (
 	storeFormatVersion = 1
 	masterFileName     = "master.json"
-	seedFileName       = "seed.bin" // [1 flag byte][12 nonce][GCM(seed32)]
+	seedFileName       = "seed.bin" // [1 flag byte][nonce][AEAD(seed32)]
 	keysDirName        = "keys"
 	keyMetaFileName    = "meta.json"
 	keyBinFileName     = "encrypted.bin"
@@ -34,11 +36,24 @@ var (
 	ErrKeyExists                    = errors.New("key_id already exists")
 	ErrMasterJSONAlreadyInitialized = errors.New("master json already initialized")
 	ErrKeyStateCorrupted            = errors.New("state corrupted")
+	ErrStoreLocked                  = errors.New("store in use by another process")
 )
 
 type FileStore struct {
 	base     string
 	masterMu sync.Mutex
+
+	// tz4 -> id for all keys on disk; built on first lookup so locked keys
+	// resolve without reading every meta.json.
+	tz4Mu    sync.Mutex
+	tz4Index map[string]string
+
+	kekMu    sync.Mutex
+	kekCache *kekCache // set only inside WithCachedKEK
+
+	// exclusive flock on base, held for the life of the store so that no
+	// second process ever writes the same key state files
+	lock *os.File
 }
 
 // ----- on-disk formats -----
@@ -49,6 +64,20 @@ type masterFile struct {
 	Params                 argon2Params `json:"params"`
 	Created                time.Time    `json:"created"`
 	NextDeterministicIndex uint64       `json:"next_det_index,omitempty"`
+	Cipher                 CipherSuite  `json:"cipher,omitempty"` // empty: aes-256-gcm
+	KEKCheck               []byte       `json:"kek_check,omitempty"`
+
+	// Set by a KDF upgrade: HD keys keep deriving from the original salt, and
+	// UpgradeSeed holds the re-wrapped seed.bin until it has been rewritten.
+	HDSalt      []byte `json:"hd_salt,omitempty"`
+	UpgradeSeed []byte `json:"upgrade_seed,omitempty"`
+}
+
+func (mf *masterFile) hdSalt() []byte {
+	if len(mf.HDSalt) > 0 {
+		return mf.HDSalt
+	}
+	return mf.Salt
 }
 
 type argon2Params struct {
@@ -58,6 +87,8 @@ type argon2Params struct {
 	KeyLen  uint32 `json:"key_len"`
 }
 
+var defaultArgon2Params = argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32}
+
 type keyMeta struct {
 	Version  int       `json:"version"`
 	KeyID    string    `json:"key_id"`
@@ -69,11 +100,65 @@ type keyMeta struct {
 	WrapNonce []byte `json:"wrap_nonce"` // for wrapped DEK (with KEK)
 	DataNonce []byte `json:"data_nonce"` // for encrypted secret (with DEK)
 
+	// Optional per-key passphrase: the DEK is sealed under a KEK derived from
+	// it (same Argon2 params as master, own salt) before the master wrap.
+	KeySalt      []byte `json:"key_salt,omitempty"`
+	KeyWrapNonce []byte `json:"key_wrap_nonce,omitempty"`
+
+	// EIP-2333 path of deterministic keys ("m/12381/1729/0/0/<index>" unless
+	// chosen at creation); empty for random keys and keys predating it.
+	DerivationPath string `json:"derivation_path,omitempty"`
+
+	// Operator labels (e.g. role=baker); not covered by any AAD.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Signing window, authenticated by ValidityMAC (HMAC under the DEK).
+	Validity    *Validity `json:"validity,omitempty"`
+	ValidityMAC []byte    `json:"validity_mac,omitempty"`
+
+	// What the key may sign, authenticated by PolicyMAC like Validity.
+	Policy    *Policy `json:"policy,omitempty"`
+	PolicyMAC []byte  `json:"policy_mac,omitempty"`
+
+	// Set for threshold shares (see threshold.go).
+	Share *ShareInfo `json:"threshold_share,omitempty"`
+
+	// KeyKDFParams pins the per-key Argon2 params once a master KDF upgrade
+	// has moved master.json on; unset means "same as master".
+	KeyKDFParams *argon2Params `json:"key_kdf_params,omitempty"`
+	// UpgradeWrap is the DEK wrapped under an upgraded master KEK; it is used
+	// while its salt matches master.json and folded into the bundle afterwards.
+	UpgradeWrap *keyWrap `json:"upgrade_wrap,omitempty"`
+}
+
+type keyWrap struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	WrappedDEK []byte `json:"wrapped_dek"`
+}
+
+func (m keyMeta) hasKeyPassphrase() bool {
+	return len(m.KeySalt) > 0
+}
+
+func (m keyMeta) keyKDFParams(mf *masterFile) argon2Params {
+	if m.KeyKDFParams != nil {
+		return *m.KeyKDFParams
+	}
+	return mf.Params
+}
+
+// masterWrap returns the wrap nonce and wrapped DEK valid for mf.
+func (m keyMeta) masterWrap(mf *masterFile, bundle keyBundle) (nonce, wrapped []byte) {
+	if w := m.UpgradeWrap; w != nil && bytes.Equal(w.Salt, mf.Salt) {
+		return w.Nonce, w.WrappedDEK
+	}
+	return m.WrapNonce, bundle.WrappedDEK
 }
 
 type keyBundle struct {
 	// binary blobs; you can also inline base64 into keyMeta if you prefer single JSON file
-	WrappedDEK []byte // AES-GCM(KEK, DEK, WrapNonce, AAD=id|tz4)
+	WrappedDEK []byte // AES-GCM(KEK, DEK, WrapNonce, AAD=id|tz4); DEK pre-wrapped with the key KEK if set
 	EncSecret  []byte // AES-GCM(DEK, skLE32, DataNonce, AAD=blpubkey|tz4)
 }
 
@@ -100,19 +185,53 @@ func writeJSONSync(path string, v any, perm os.FileMode) error {
 	return writeBytesSync(path, b, perm)
 }
 
+// dirSync selects whether writeBytesAtomic also fsyncs the parent directory.
+// Without it a crash right after the rename can bring back the old file, so
+// only skip it for files whose loss is harmless or that a caller syncs itself.
+type dirSync bool
+
+const (
+	withDirSync dirSync = true
+	skipDirSync dirSync = false
+)
+
+// writeBytesSync is writeBytesAtomic with the directory fsync; anything that
+// carries key material or watermarks goes through here.
 func writeBytesSync(path string, b []byte, perm os.FileMode) error {
+	return writeBytesAtomic(path, b, perm, withDirSync)
+}
+
+// writeBytesAtomic writes b to a temp file, fsyncs it, renames it over path
+// and, with withDirSync, fsyncs the directory so the rename is durable.
+func writeBytesAtomic(path string, b []byte, perm os.FileMode, mode dirSync) error {
 	tmp := path + tmpSuffix
 	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_SYNC, perm)
 	if err != nil {
 		return err
 	}
+	fail := func(err error) error {
+		_ = os.Remove(tmp)
+		return err
+	}
+
 	if _, err := file.Write(b); err != nil {
 		file.Close()
-		return err
+		return fail(err)
+	}
+	// O_SYNC covers the data on Linux; the explicit Sync keeps that true on
+	// filesystems/platforms that ignore the flag.
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		return fail(err)
 	}
-	file.Close()
 	if err := os.Rename(tmp, path); err != nil {
-		return err
+		return fail(err)
+	}
+	if mode == skipDirSync {
+		return nil
 	}
 	return syncParentDir(path)
 }
@@ -120,7 +239,11 @@ func writeBytesSync(path string, b []byte, perm os.FileMode) error {
 // syncParentDir fsyncs the parent directory to persist a newly
 // created or renamed directory entry.
 func syncParentDir(path string) error {
-	d, err := os.Open(filepath.Dir(path))
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
@@ -149,7 +272,83 @@ func NewFileStore(base string) (*FileStore, error) {
 	if err := mkDirs(base); err != nil {
 		return nil, err
 	}
-	return &FileStore{base: base}, nil
+	lock, err := lockDir(base)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{base: base, lock: lock}, nil
+}
+
+// lockDir takes a non-blocking exclusive flock on dir. The lock goes away
+// with the process, so a crash never leaves a stale one behind.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrStoreLocked, dir)
+		}
+		return nil, fmt.Errorf("lock %s: %w", dir, err)
+	}
+	return f, nil
+}
+
+// relockLocked moves the lock to base when something was mounted over it:
+// the lock taken at open time sits on the directory now hidden under the
+// mount. Caller holds masterMu.
+func (fs *FileStore) relockLocked() error {
+	if fs.lock != nil {
+		held, err1 := fs.lock.Stat()
+		cur, err2 := os.Stat(fs.base)
+		if err1 == nil && err2 == nil && os.SameFile(held, cur) {
+			return nil
+		}
+	}
+	lock, err := lockDir(fs.base)
+	if err != nil {
+		return err
+	}
+	if fs.lock != nil {
+		_ = fs.lock.Close()
+	}
+	fs.lock = lock
+	return nil
+}
+
+// Close releases the store lock. The store must not be used afterwards.
+func (fs *FileStore) Close() error {
+	fs.masterMu.Lock()
+	defer fs.masterMu.Unlock()
+
+	if fs.lock == nil {
+		return nil
+	}
+	err := fs.lock.Close()
+	fs.lock = nil
+	return err
+}
+
+// Reopen repeats the open-time setup of NewFileStore. Call it when a volume
+// was mounted over the base directory after the store was created (the
+// gadget's encrypted data vault).
+func (fs *FileStore) Reopen() error {
+	fs.masterMu.Lock()
+	defer fs.masterMu.Unlock()
+
+	if err := mkDirs(fs.base); err != nil {
+		return err
+	}
+	if err := fs.relockLocked(); err != nil {
+		return err
+	}
+
+	fs.tz4Mu.Lock()
+	fs.tz4Index = nil
+	fs.tz4Mu.Unlock()
+	return nil
 }
 
 // ----- per-key paths -----
@@ -177,6 +376,15 @@ func (fs *FileStore) keyStatePath(id string) string {
 // InitMaster creates master.json with Argon2id params & a random salt.
 // It is idempotent-safe: returns error if already exists.
 func (fs *FileStore) InitMaster() error {
+	return fs.InitMasterWithCipher(DefaultCipherSuite)
+}
+
+// InitMasterWithCipher is InitMaster with an explicit cipher suite for
+// everything the store encrypts from then on.
+func (fs *FileStore) InitMasterWithCipher(suite CipherSuite) error {
+	if _, err := suite.newAEAD(make([]byte, 32)); err != nil {
+		return err
+	}
 	masterPath := filepath.Join(fs.base, masterFileName)
 	if _, err := os.Stat(masterPath); err == nil {
 		return ErrMasterJSONAlreadyInitialized
@@ -184,9 +392,10 @@ func (fs *FileStore) InitMaster() error {
 	mf := masterFile{
 		Version:                storeFormatVersion,
 		Salt:                   randBytes(16),
-		Params:                 argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4, KeyLen: 32},
+		Params:                 defaultArgon2Params,
 		Created:                time.Now().UTC(),
 		NextDeterministicIndex: 1,
+		Cipher:                 suite,
 	}
 	return writeJSONSync(masterPath, &mf, 0o600)
 }
@@ -251,16 +460,52 @@ func (fs *FileStore) InitInfo() (masterPresent, deterministic bool, err error) {
 	return masterPresent, deterministic, nil
 }
 
-func (fs *FileStore) deriveKEK(masterPassword []byte) ([]byte, *masterFile, error) {
+// deriveKEK returns the master KEK in a SecretBuffer the caller must Close.
+func (fs *FileStore) deriveKEK(masterPassword []byte) (*secure.SecretBuffer, *masterFile, error) {
 	mf, err := fs.readMaster()
 	if err != nil {
 		return nil, nil, err
 	}
 	params := mf.Params
-	kek := argon2.IDKey(masterPassword, mf.Salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	if kek := fs.cachedKEK(masterPassword, mf); kek != nil {
+		return kek, mf, nil
+	}
+	kek, err := secure.SecretBufferFrom(masterKDF(masterPassword, mf.Salt, params.Time, params.Memory, params.Threads, params.KeyLen))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkKEK(mf, kek.Bytes()); err != nil {
+		kek.Close()
+		return nil, nil, err
+	}
+	fs.storeKEK(masterPassword, mf, kek)
 	return kek, mf, nil
 }
 
+func deriveKeyKEK(keyPassphrase, salt []byte, params argon2Params) (*secure.SecretBuffer, error) {
+	return secure.SecretBufferFrom(argon2.IDKey(keyPassphrase, salt, params.Time, params.Memory, params.Threads, params.KeyLen))
+}
+
+// openSecret decrypts ct straight into a SecretBuffer.
+func openSecret(aead cipher.AEAD, nonce, ct, aad []byte) (*secure.SecretBuffer, error) {
+	if len(ct) <= aead.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	buf, err := secure.NewSecretBuffer(len(ct) - aead.Overhead())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := aead.Open(buf.Bytes()[:0], nonce, ct, aad); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	return buf, nil
+}
+
+func keyWrapAAD(id, tz4 string) []byte {
+	return []byte("key|id=" + id + "|tz4=" + tz4)
+}
+
 func (fs *FileStore) readMaster() (*masterFile, error) {
 	masterPath := filepath.Join(fs.base, masterFileName)
 	var mf masterFile
@@ -300,7 +545,7 @@ func (fs *FileStore) list() ([]string, error) {
 	return ids, nil
 }
 
-func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte, blPubkey, tz4, pop string) error {
+func (fs *FileStore) createKey(id string, masterPassword, keyPassphrase []byte, skLE32 []byte, blPubkey, tz4, pop, derivationPath string) error {
 	if id == "" {
 		return errors.New("id required")
 	}
@@ -317,28 +562,50 @@ func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte,
 	}
 
 	// derive KEK
-	kek, _, err := fs.deriveKEK(masterPassword)
+	kek, mf, err := fs.deriveKEK(masterPassword)
 	if err != nil {
 		return err
 	}
-	defer secure.MemoryWipe(kek)
+	defer kek.Close()
 
 	// random DEK
-	dek := randBytes(32)
-	defer secure.MemoryWipe(dek)
+	dek, err := secure.SecretBufferFrom(randBytes(32))
+	if err != nil {
+		return err
+	}
+	defer dek.Close()
+
+	// optional inner wrap with the per-key KEK
+	suite := mf.suite()
+	innerDEK := dek.Bytes()
+	var keySalt, keyWrapNonce []byte
+	if len(keyPassphrase) > 0 {
+		keySalt = randBytes(16)
+		keyWrapNonce = randBytes(suite.nonceSize())
+		keyKEK, err := deriveKeyKEK(keyPassphrase, keySalt, mf.Params)
+		if err != nil {
+			return err
+		}
+		gcmKey, err := suite.newAEAD(keyKEK.Bytes())
+		keyKEK.Close()
+		if err != nil {
+			return err
+		}
+		innerDEK = gcmKey.Seal(nil, keyWrapNonce, dek.Bytes(), keyWrapAAD(id, tz4))
+	}
 
 	// wrap DEK with KEK
-	wrapNonce := randBytes(12)
-	gcmKEK, err := newAESGCM(kek)
+	wrapNonce := randBytes(suite.nonceSize())
+	gcmKEK, err := suite.newAEAD(kek.Bytes())
 	if err != nil {
 		return err
 	}
 	wrapAAD := []byte("id=" + id + "|tz4=" + tz4)
-	wrappedDEK := gcmKEK.Seal(nil, wrapNonce, dek, wrapAAD)
+	wrappedDEK := gcmKEK.Seal(nil, wrapNonce, innerDEK, wrapAAD)
 
 	// enc secret with DEK
-	dataNonce := randBytes(12)
-	gcmDEK, err := newAESGCM(dek)
+	dataNonce := randBytes(suite.nonceSize())
+	gcmDEK, err := suite.newAEAD(dek.Bytes())
 	if err != nil {
 		return err
 	}
@@ -354,6 +621,11 @@ func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte,
 		Created:   time.Now().UTC(),
 		WrapNonce: wrapNonce,
 		DataNonce: dataNonce,
+
+		KeySalt:      keySalt,
+		KeyWrapNonce: keyWrapNonce,
+
+		DerivationPath: derivationPath,
 	}
 	bundle := keyBundle{
 		WrappedDEK: wrappedDEK,
@@ -365,17 +637,73 @@ func (fs *FileStore) createKey(id string, masterPassword []byte, skLE32 []byte,
 		return err
 	}
 
-	return writeBytesSync(binPath, encodeBundle(bundle), 0o600)
+	if err := writeBytesSync(binPath, encodeBundle(bundle), 0o600); err != nil {
+		return err
+	}
+	fs.indexTZ4(id, tz4)
+	return nil
 }
 
 func (fs *FileStore) removeKey(id string) error {
 	if id == "" {
 		return fmt.Errorf("refusing to remove empty key id")
 	}
+	fs.forgetTZ4(id)
 	return os.RemoveAll(fs.keyDir(id))
 }
 
-func (fs *FileStore) unlock(id string, masterPassword []byte) (dek []byte, encSecret, dataNonce []byte, blPubkey, tz4 string, err error) {
+// lookupTZ4 resolves a tz4 through the index. A miss rebuilds the index once
+// in case the store changed underneath (restore, manual copy).
+func (fs *FileStore) lookupTZ4(tz4 string) (string, bool, error) {
+	fs.tz4Mu.Lock()
+	defer fs.tz4Mu.Unlock()
+
+	if id, ok := fs.tz4Index[tz4]; ok && fs.hasKey(id) {
+		return id, true, nil
+	}
+	if err := fs.rebuildTZ4IndexLocked(); err != nil {
+		return "", false, err
+	}
+	id, ok := fs.tz4Index[tz4]
+	return id, ok, nil
+}
+
+func (fs *FileStore) rebuildTZ4IndexLocked() error {
+	ids, err := fs.list()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]string, len(ids))
+	for _, id := range ids {
+		meta, err := fs.readKeyMeta(id)
+		if err != nil || meta.TZ4 == "" {
+			continue
+		}
+		index[meta.TZ4] = id
+	}
+	fs.tz4Index = index
+	return nil
+}
+
+func (fs *FileStore) indexTZ4(id, tz4 string) {
+	fs.tz4Mu.Lock()
+	defer fs.tz4Mu.Unlock()
+	if fs.tz4Index != nil {
+		fs.tz4Index[tz4] = id
+	}
+}
+
+func (fs *FileStore) forgetTZ4(id string) {
+	fs.tz4Mu.Lock()
+	defer fs.tz4Mu.Unlock()
+	for tz4, indexed := range fs.tz4Index {
+		if indexed == id {
+			delete(fs.tz4Index, tz4)
+		}
+	}
+}
+
+func (fs *FileStore) unlock(id string, masterPassword, keyPassphrase []byte) (dek *secure.SecretBuffer, encSecret, dataNonce []byte, blPubkey, tz4 string, err error) {
 	var meta keyMeta
 	metaPath := fs.keyMetaPath(id)
 	binPath := fs.keyBinPath(id)
@@ -391,21 +719,49 @@ func (fs *FileStore) unlock(id string, masterPassword []byte) (dek []byte, encSe
 		return nil, nil, nil, "", "", err
 	}
 
-	kek, _, err := fs.deriveKEK(masterPassword)
+	if meta.hasKeyPassphrase() && len(keyPassphrase) == 0 {
+		return nil, nil, nil, "", "", ErrKeyPassphraseRequired
+	}
+
+	kek, mf, err := fs.deriveKEK(masterPassword)
 	if err != nil {
 		return nil, nil, nil, "", "", err
 	}
-	defer secure.MemoryWipe(kek)
+	defer kek.Close()
 
-	gcmKEK, err := newAESGCM(kek)
+	gcmKEK, err := mf.suite().newAEAD(kek.Bytes())
 	if err != nil {
 		return nil, nil, nil, "", "", err
 	}
-	dek, err = gcmKEK.Open(nil, meta.WrapNonce, bundle.WrappedDEK, []byte("id="+id+"|tz4="+meta.TZ4))
+	wrapNonce, wrappedDEK := meta.masterWrap(mf, bundle)
+	dek, err = openSecret(gcmKEK, wrapNonce, wrappedDEK, []byte("id="+id+"|tz4="+meta.TZ4))
 	if err != nil {
+		if len(mf.KEKCheck) > 0 {
+			return nil, nil, nil, "", "", fmt.Errorf("%w: key %s (unwrap)", ErrStoreCorrupted, id)
+		}
 		return nil, nil, nil, "", "", fmt.Errorf("bad password or corrupted key (unwrap)")
 	}
 
+	if meta.hasKeyPassphrase() {
+		inner := dek
+		defer inner.Close()
+
+		keyKEK, err := deriveKeyKEK(keyPassphrase, meta.KeySalt, meta.keyKDFParams(mf))
+		if err != nil {
+			return nil, nil, nil, "", "", err
+		}
+		defer keyKEK.Close()
+
+		gcmKey, err := mf.suite().newAEAD(keyKEK.Bytes())
+		if err != nil {
+			return nil, nil, nil, "", "", err
+		}
+		dek, err = openSecret(gcmKey, meta.KeyWrapNonce, inner.Bytes(), keyWrapAAD(id, meta.TZ4))
+		if err != nil {
+			return nil, nil, nil, "", "", fmt.Errorf("bad key passphrase or corrupted key (unwrap)")
+		}
+	}
+
 	return dek, bundle.EncSecret, meta.DataNonce, meta.BLPubkey, meta.TZ4, nil
 }
 
@@ -417,6 +773,18 @@ func (fs *FileStore) readKeyMeta(id string) (keyMeta, error) {
 	return m, nil
 }
 
+// updateKeyMeta rewrites meta.json after fn mutates it; callers serialize.
+func (fs *FileStore) updateKeyMeta(id string, fn func(*keyMeta) error) error {
+	meta, err := fs.readKeyMeta(id)
+	if err != nil {
+		return err
+	}
+	if err := fn(&meta); err != nil {
+		return err
+	}
+	return writeJSONSync(fs.keyMetaPath(id), &meta, 0o600)
+}
+
 func (fs *FileStore) hasKey(id string) bool {
 	metaPath := fs.keyMetaPath(id)
 	_, err := os.Stat(metaPath)
@@ -477,12 +845,13 @@ func (fs *FileStore) WriteSeed(masterPassword []byte, enabled bool) error {
 	if err != nil {
 		return err
 	}
-	defer secure.MemoryWipe(kek)
+	defer kek.Close()
 
 	seed := randBytes(32)
 
-	nonce := randBytes(12)
-	gcm, err := newAESGCM(kek)
+	suite := mf.suite()
+	nonce := randBytes(suite.nonceSize())
+	gcm, err := suite.newAEAD(kek.Bytes())
 	if err != nil {
 		return err
 	}
@@ -491,55 +860,62 @@ func (fs *FileStore) WriteSeed(masterPassword []byte, enabled bool) error {
 
 	ct := gcm.Seal(nil, nonce, seed, aad)
 
-	out := make([]byte, 1+12+len(ct))
+	out := make([]byte, 1+len(nonce)+len(ct))
 	if enabled {
 		out[0] = 0x01
 	} else {
 		out[0] = 0x00
 	}
 	copy(out[1:], nonce)
-	copy(out[1+12:], ct)
+	copy(out[1+len(nonce):], ct)
 
 	path := filepath.Join(fs.base, seedFileName)
-	return writeBytesSync(path, out, 0o600)
+	if err := writeBytesSync(path, out, 0o600); err != nil {
+		return err
+	}
+	// the passphrase that creates the seed defines the store
+	return fs.storeKEKCheck(mf.Salt, kek.Bytes())
 }
 
-// readSeed loads seed.bin and returns (enabled, seed32).
-func (fs *FileStore) readSeed(masterPassword []byte) (bool, []byte, error) {
-	path := filepath.Join(fs.base, seedFileName)
-	b, err := os.ReadFile(path)
+// readSeed loads seed.bin and returns (enabled, seed32); Close the seed.
+func (fs *FileStore) readSeed(masterPassword []byte) (bool, *secure.SecretBuffer, error) {
+	kek, mf, err := fs.deriveKEK(masterPassword)
 	if err != nil {
 		return false, nil, err
 	}
-	if len(b) < 1+12+16 {
+	defer kek.Close()
+
+	b := mf.UpgradeSeed
+	if b == nil {
+		if b, err = os.ReadFile(filepath.Join(fs.base, seedFileName)); err != nil {
+			return false, nil, err
+		}
+	}
+	suite := mf.suite()
+	nonceSize := suite.nonceSize()
+	if len(b) < 1+nonceSize+16 {
 		return false, nil, fmt.Errorf("seed file too short")
 	}
 	enabled := b[0] == 0x01
-	nonce := b[1 : 1+12]
-	ct := b[1+12:]
+	nonce := b[1 : 1+nonceSize]
+	ct := b[1+nonceSize:]
 
 	// AAD from master.json
-	mf, err := fs.readMaster()
-	if err != nil {
-		return false, nil, err
-	}
 	aad := masterAAD(mf)
 
-	kek, _, err := fs.deriveKEK(masterPassword)
-	if err != nil {
-		return false, nil, err
-	}
-	defer secure.MemoryWipe(kek)
-
-	gcm, err := newAESGCM(kek)
+	gcm, err := suite.newAEAD(kek.Bytes())
 	if err != nil {
 		return false, nil, err
 	}
-	seed, err := gcm.Open(nil, nonce, ct, aad)
+	seed, err := openSecret(gcm, nonce, ct, aad)
 	if err != nil {
+		if len(mf.KEKCheck) > 0 {
+			return false, nil, fmt.Errorf("%w: seed", ErrStoreCorrupted)
+		}
 		return false, nil, fmt.Errorf("seed corrupted or bad password")
 	}
-	if len(seed) != 32 {
+	if seed.Len() != 32 {
+		seed.Close()
 		return false, nil, fmt.Errorf("seed length invalid")
 	}
 	return enabled, seed, nil
@@ -1,13 +1,16 @@
 package keychain
 
 import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"os"
 	"testing"
 )
 
 func testKeyState(values map[SIGN_KIND]HighWatermark) *KeyState {
 	ks := newEmptyKeyState()
-	for _, kind := range allSignKinds {
+	for _, kind := range registeredSignKinds() {
 		ks.ByKind[int32(kind)] = values[kind].ToKeyState()
 	}
 	return ks
@@ -16,7 +19,7 @@ func testKeyState(values map[SIGN_KIND]HighWatermark) *KeyState {
 func assertKeyStateEqual(t *testing.T, got *KeyState, want map[SIGN_KIND]HighWatermark) {
 	t.Helper()
 
-	for _, kind := range allSignKinds {
+	for _, kind := range registeredSignKinds() {
 		state := got.GetByKind()[int32(kind)]
 		if state == nil {
 			t.Fatalf("missing state for kind %v", kind)
@@ -52,7 +55,7 @@ func TestDoubleBufferWriteReadRoundTrip(t *testing.T) {
 
 	dek := []byte("0123456789abcdef0123456789abcdef")
 
-	file, ks, seq, corrupted, err := openKeyHWMFile(fs.keyStatePath(id), dek, id, tz4)
+	file, ks, seq, corrupted, err := openKeyHWMFile(fs.keyStatePath(id), CipherAESGCM, dek, id, tz4)
 	if err != nil {
 		t.Fatalf("openKeyHWMFile: %v", err)
 	}
@@ -114,7 +117,7 @@ func TestDoubleBufferRecoversFromCorruptedSecondSlot(t *testing.T) {
 
 	dek := []byte("abcdef0123456789abcdef0123456789")
 
-	file, _, _, _, err := openKeyHWMFile(fs.keyStatePath(id), dek, id, tz4)
+	file, _, _, _, err := openKeyHWMFile(fs.keyStatePath(id), CipherAESGCM, dek, id, tz4)
 	if err != nil {
 		t.Fatalf("openKeyHWMFile: %v", err)
 	}
@@ -173,7 +176,7 @@ func TestDoubleBufferPrefersNewerSequence(t *testing.T) {
 
 	dek := []byte("fedcba9876543210fedcba9876543210")
 
-	file, _, _, _, err := openKeyHWMFile(fs.keyStatePath(id), dek, id, tz4)
+	file, _, _, _, err := openKeyHWMFile(fs.keyStatePath(id), CipherAESGCM, dek, id, tz4)
 	if err != nil {
 		t.Fatalf("openKeyHWMFile: %v", err)
 	}
@@ -222,3 +225,152 @@ func TestDoubleBufferPrefersNewerSequence(t *testing.T) {
 	}
 	assertKeyStateEqual(t, got, newer)
 }
+
+func TestDoubleBufferPreservesUnknownKinds(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	const (
+		id   = "unknown-kind"
+		tz4  = "tz4-unknown-kind"
+		kind = SIGN_KIND(0x55)
+	)
+
+	if err := os.MkdirAll(fs.keyDir(id), 0o700); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	dek := []byte("0123456789abcdef0123456789abcdef")
+
+	file, _, _, _, err := openKeyHWMFile(fs.keyStatePath(id), CipherAESGCM, dek, id, tz4)
+	if err != nil {
+		t.Fatalf("openKeyHWMFile: %v", err)
+	}
+	defer file.Close()
+
+	ks := testKeyState(map[SIGN_KIND]HighWatermark{BLOCK: {level: 5}})
+	ks.ByKind[int32(kind)] = &KindState{Level: 77, Round: 4}
+	if err := file.persist(dek, id, tz4, ks, 1); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+	file.waitIdle()
+
+	got, _, _, _, err := file.load(dek, id, tz4)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	state := got.GetByKind()[int32(kind)]
+	if state == nil || state.GetLevel() != 77 || state.GetRound() != 4 {
+		t.Fatalf("unknown kind not preserved: %v", state)
+	}
+	assertKeyStateEqual(t, got, map[SIGN_KIND]HighWatermark{BLOCK: {level: 5}})
+}
+
+func TestLegacyKeyStateFileIsMigrated(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	const (
+		id  = "legacy"
+		tz4 = "tz4-legacy"
+	)
+
+	if err := os.MkdirAll(fs.keyDir(id), 0o700); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	dek := []byte("0123456789abcdef0123456789abcdef")
+	want := map[SIGN_KIND]HighWatermark{
+		BLOCK:          {level: 100, round: 2},
+		PREATTESTATION: {level: 101, round: 0},
+		ATTESTATION:    {level: 99, round: 7},
+	}
+
+	var plain [legacyKeyStatePlainSize]byte
+	copy(plain[:4], legacyKeyStateMagic[:])
+	binary.BigEndian.PutUint64(plain[4:12], 9)
+	offset := 12
+	for _, kind := range legacyKeyStateKinds {
+		binary.BigEndian.PutUint64(plain[offset:], want[kind].level)
+		binary.BigEndian.PutUint32(plain[offset+8:], want[kind].round)
+		offset += 12
+	}
+
+	gcm, err := newAESGCM(dek)
+	if err != nil {
+		t.Fatalf("newAESGCM: %v", err)
+	}
+	var legacy [legacyKeyStateFileSize]byte
+	slot := legacy[:legacyKeyStateSlotSize]
+	nonce := slot[:keyStateNonceSize]
+	copy(nonce, "legacy-nonce")
+	gcm.Seal(nonce, nonce, plain[:], []byte("state|id="+id+"|tz4="+tz4))
+	binary.BigEndian.PutUint32(slot[legacyKeyStateSlotSize-4:], crc32.ChecksumIEEE(slot[:legacyKeyStateSlotSize-4]))
+	copy(legacy[legacyKeyStateSlotSize:], slot)
+
+	if err := os.WriteFile(fs.keyStatePath(id), legacy[:], 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	file, ks, seq, corrupted, err := openKeyHWMFile(fs.keyStatePath(id), CipherAESGCM, dek, id, tz4)
+	if err != nil {
+		t.Fatalf("openKeyHWMFile: %v", err)
+	}
+	defer file.Close()
+
+	if corrupted {
+		t.Fatalf("expected clean legacy state")
+	}
+	if seq != 9 {
+		t.Fatalf("expected seq=9, got %d", seq)
+	}
+	assertKeyStateEqual(t, ks, want)
+
+	info, err := os.Stat(fs.keyStatePath(id))
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size() != keyStateFileSize {
+		t.Fatalf("expected migrated file size %d, got %d", keyStateFileSize, info.Size())
+	}
+
+	got, gotSeq, _, corrupted, err := file.load(dek, id, tz4)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if corrupted || gotSeq != 9 {
+		t.Fatalf("unexpected migrated state: seq=%d corrupted=%v", gotSeq, corrupted)
+	}
+	assertKeyStateEqual(t, got, want)
+}
+
+func TestFileStoreLockRejectsSecondOpener(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	if _, err := NewFileStore(dir); !errors.Is(err, ErrStoreLocked) {
+		t.Fatalf("expected ErrStoreLocked, got %v", err)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	again, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore after Close: %v", err)
+	}
+	_ = again.Close()
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatalf("SelfTest: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package keychain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ValidationProfile is how closely a key's payloads are checked before it
+// signs them. DecodeAndValidateSignPayload alone is the standard profile: it
+// reads kind, level and round and ignores the rest.
+type ValidationProfile string
+
+const (
+	ValidationStandard ValidationProfile = ""
+	// ValidationStrict also parses the whole Tenderbake payload: block
+	// fitness must agree with the header and the payload must end where the
+	// encoding does. Registered kinds have no known layout and are checked
+	// as in the standard profile.
+	ValidationStrict ValidationProfile = "strict"
+)
+
+var (
+	errTrailingBytes    = errors.New("unexpected trailing bytes")
+	errBadFitness       = errors.New("malformed fitness")
+	errFitnessMismatch  = errors.New("fitness does not match block header")
+	errBadPayloadRound  = errors.New("payload round after block round")
+	errBadSeedNonceFlag = errors.New("malformed seed nonce hash")
+	errOperationTag     = errors.New("operation tag does not match watermark")
+	errBadDALContent    = errors.New("malformed DAL content")
+)
+
+// ParseValidationProfile accepts "standard" (or empty) and "strict".
+func ParseValidationProfile(s string) (ValidationProfile, error) {
+	switch s {
+	case "", "standard":
+		return ValidationStandard, nil
+	case string(ValidationStrict):
+		return ValidationStrict, nil
+	}
+	return ValidationStandard, fmt.Errorf("unknown validation profile %q", s)
+}
+
+// Tenderbake consensus operation tags.
+const (
+	opPreattestation         = 20
+	opAttestation            = 21
+	opAttestationWithDAL     = 23
+	consensusContentEnd      = 1 + 4 + 32 + 1 + 4 + 4 + 32 // up to block_payload_hash
+	tenderbakeFitnessVersion = 2
+)
+
+// validateStrict checks what the strict profile adds on top of
+// DecodeAndValidateSignPayload, which has already accepted raw.
+func validateStrict(kind SIGN_KIND, raw []byte) error {
+	switch kind {
+	case BLOCK:
+		return validateBlockStrict(raw)
+	case PREATTESTATION, ATTESTATION:
+		return validateConsensusStrict(kind, raw)
+	}
+	return nil
+}
+
+// validateBlockStrict walks the unsigned block header:
+//
+//	watermark | chain_id | level | proto | predecessor | timestamp |
+//	validation_pass | operations_hash | fitness | context | payload_hash |
+//	payload_round | proof_of_work_nonce | seed_nonce_hash? | per_block_votes
+func validateBlockStrict(raw []byte) error {
+	const (
+		levelOff   = 1 + 4
+		fitnessOff = 1 + 4 + 4 + 1 + 32 + 8 + 1 + 32
+	)
+	level := binary.BigEndian.Uint32(raw[levelOff:])
+
+	fitnessLen := int(binary.BigEndian.Uint32(raw[fitnessOff:]))
+	fitness := raw[fitnessOff+4 : fitnessOff+4+fitnessLen]
+	round, err := checkFitness(fitness, level)
+	if err != nil {
+		return err
+	}
+
+	off := fitnessOff + 4 + fitnessLen + 32 + 32 // context, payload_hash
+	if off+4+8+1 > len(raw) {
+		return errOutOfBounds
+	}
+	payloadRound := int32(binary.BigEndian.Uint32(raw[off:]))
+	if payloadRound < 0 {
+		return errNegativeRound
+	}
+	if payloadRound > round {
+		return errBadPayloadRound
+	}
+	off += 4 + 8 // payload_round, proof_of_work_nonce
+
+	switch raw[off] {
+	case 0x00:
+		off++
+	case 0xff:
+		off += 1 + 32
+	default:
+		return errBadSeedNonceFlag
+	}
+	off++ // per_block_votes
+	if off > len(raw) {
+		return errOutOfBounds
+	}
+	if off != len(raw) {
+		return errTrailingBytes
+	}
+	return nil
+}
+
+// checkFitness parses a Tenderbake fitness (version, level, locked round,
+// predecessor round, round; each length-prefixed) and returns its round.
+func checkFitness(fitness []byte, level uint32) (int32, error) {
+	var parts [][]byte
+	for len(fitness) > 0 {
+		if len(fitness) < 4 {
+			return 0, errBadFitness
+		}
+		n := int(binary.BigEndian.Uint32(fitness))
+		if n > len(fitness)-4 {
+			return 0, errBadFitness
+		}
+		parts = append(parts, fitness[4:4+n])
+		fitness = fitness[4+n:]
+	}
+	if len(parts) != 5 {
+		return 0, errBadFitness
+	}
+	version, lvl, locked, pred, round := parts[0], parts[1], parts[2], parts[3], parts[4]
+	if len(version) != 1 || version[0] != tenderbakeFitnessVersion {
+		return 0, errBadFitness
+	}
+	if len(lvl) != 4 || len(pred) != 4 || len(round) != 4 || (len(locked) != 0 && len(locked) != 4) {
+		return 0, errBadFitness
+	}
+	if binary.BigEndian.Uint32(lvl) != level {
+		return 0, errFitnessMismatch
+	}
+	return int32(binary.BigEndian.Uint32(round)), nil
+}
+
+// validateConsensusStrict checks the operation tag against the watermark and
+// that nothing follows the consensus content except, for attestations with
+// DAL, one zarith-encoded bitset.
+func validateConsensusStrict(kind SIGN_KIND, raw []byte) error {
+	const tagOff = 1 + 4 + 32
+	if len(raw) < consensusContentEnd {
+		return errOutOfBounds
+	}
+	tag := raw[tagOff]
+	switch {
+	case kind == PREATTESTATION && tag == opPreattestation,
+		kind == ATTESTATION && tag == opAttestation:
+		if len(raw) != consensusContentEnd {
+			return errTrailingBytes
+		}
+		return nil
+	case kind == ATTESTATION && tag == opAttestationWithDAL:
+		n, ok := zarithLen(raw[consensusContentEnd:])
+		if !ok {
+			return errBadDALContent
+		}
+		if consensusContentEnd+n != len(raw) {
+			return errTrailingBytes
+		}
+		return nil
+	}
+	return errOperationTag
+}
+
+// zarithLen is the length of the zarith natural at the start of b.
+func zarithLen(b []byte) (int, bool) {
+	for i, c := range b {
+		if c&0x80 == 0 {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
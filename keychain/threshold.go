@@ -0,0 +1,135 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tez-capital/tezsign/secure"
+	"github.com/tez-capital/tezsign/signer"
+	"github.com/tez-capital/tezsign/signerpb"
+)
+
+var ErrInvalidShare = errors.New("invalid threshold share")
+
+// ShareInfo says which group key an imported share belongs to. It is
+// informational: the share signs like any other key, and only the host that
+// combines partial signatures cares about the group.
+type ShareInfo struct {
+	GroupPubkey string `json:"group_pubkey"` // BLpk…
+	GroupTZ4    string `json:"group_tz4"`
+	Index       uint32 `json:"index"`
+	Threshold   uint32 `json:"threshold"`
+	Total       uint32 `json:"total"`
+}
+
+func ShareInfoFromProto(sp *signerpb.ThresholdShare) ShareInfo {
+	return ShareInfo{
+		GroupPubkey: sp.GetGroupPubkey(),
+		GroupTZ4:    sp.GetGroupTz4(),
+		Index:       sp.GetIndex(),
+		Threshold:   sp.GetThreshold(),
+		Total:       sp.GetTotal(),
+	}
+}
+
+func (s ShareInfo) toProto() *signerpb.ThresholdShare {
+	return &signerpb.ThresholdShare{
+		GroupPubkey: s.GroupPubkey,
+		GroupTz4:    s.GroupTZ4,
+		Index:       s.Index,
+		Threshold:   s.Threshold,
+		Total:       s.Total,
+	}
+}
+
+// validate checks the share's own consistency and fills GroupTZ4 from the
+// group pubkey. Whether the secret really is a share of the group can only be
+// seen by combining threshold share pubkeys (signer.CombinePublicKeys).
+func (s *ShareInfo) validate() error {
+	if s.Threshold < 1 || s.Threshold > s.Total || s.Total > signer.MaxShares {
+		return fmt.Errorf("%w: threshold %d of %d", ErrInvalidShare, s.Threshold, s.Total)
+	}
+	if s.Index < 1 || s.Index > s.Total {
+		return fmt.Errorf("%w: index %d of %d", ErrInvalidShare, s.Index, s.Total)
+	}
+	pk, err := signer.DecodeBLPubkey(s.GroupPubkey)
+	if err != nil {
+		return fmt.Errorf("%w: group pubkey: %v", ErrInvalidShare, err)
+	}
+	tz4, err := signer.Tz4FromBLPubkeyBytes(pk)
+	if err != nil {
+		return fmt.Errorf("%w: group pubkey: %v", ErrInvalidShare, err)
+	}
+	if s.GroupTZ4 != "" && s.GroupTZ4 != tz4 {
+		return fmt.Errorf("%w: group tz4 does not match group pubkey", ErrInvalidShare)
+	}
+	s.GroupTZ4 = tz4
+	return nil
+}
+
+// ImportShare stores a threshold share (BLsk…) as a new key under the master
+// passphrase, like CreateKey. A share is never derived from the store seed,
+// so deterministic backups do not cover it.
+func (kr *KeyRing) ImportShare(wanted, blSecretKey string, share ShareInfo, masterPassword, keyPassphrase []byte) (id, blPubkey, tz4 string, err error) {
+	if err := share.validate(); err != nil {
+		return "", "", "", err
+	}
+	secretKey, err := signer.ImportBLSecretKey(strings.TrimSpace(blSecretKey))
+	if err != nil {
+		return "", "", "", fmt.Errorf("%w: %v", ErrInvalidShare, err)
+	}
+	defer secretKey.Zeroize()
+
+	pubkeyBytes, blPubkey := signer.PublicKeyFromSecret(secretKey)
+	tz4, _ = signer.Tz4FromBLPubkeyBytes(pubkeyBytes)
+	if tz4 == share.GroupTZ4 {
+		return "", "", "", fmt.Errorf("%w: secret is the group key itself", ErrInvalidShare)
+	}
+	_, pop, err := signer.SignPoPCompressed(secretKey, pubkeyBytes)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	if existing, found, err := kr.store.lookupTZ4(tz4); err != nil {
+		return "", "", "", err
+	} else if found {
+		return "", "", "", fmt.Errorf("%w: share already imported as %s", ErrKeyExists, existing)
+	}
+
+	id = normalizeID(wanted)
+	if id != "" && !isValidID(id) {
+		return "", "", "", fmt.Errorf("invalid key_id")
+	}
+	for id == "" {
+		candidate := fmt.Sprintf("key%d", kr.nextID.Add(1))
+		if !kr.store.hasKey(candidate) {
+			id = candidate
+		}
+	}
+	if kr.store.hasKey(id) {
+		return "", "", "", ErrKeyExists
+	}
+
+	skLE := secretKey.ToLEndian()
+	defer secure.MemoryWipe(skLE)
+	if err := kr.store.createKey(id, masterPassword, keyPassphrase, skLE, blPubkey, tz4, pop, ""); err != nil {
+		return "", "", "", err
+	}
+	if err := kr.store.updateKeyMeta(id, func(meta *keyMeta) error {
+		meta.Share = &share
+		return nil
+	}); err != nil {
+		_ = kr.store.removeKey(id)
+		return "", "", "", err
+	}
+
+	if !kr.keys.Insert(id, newGKey(blPubkey, tz4)) {
+		return "", "", "", ErrKeyExists
+	}
+	kr.log.Info(fmt.Sprintf("IMPORT_SHARE id=%s tz4=%s group=%s index=%d threshold=%d/%d key_passphrase=%v", id, tz4, share.GroupTZ4, share.Index, share.Threshold, share.Total, len(keyPassphrase) > 0))
+	return id, blPubkey, tz4, nil
+}
@@ -0,0 +1,115 @@
+package keychain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tez-capital/tezsign/signerpb"
+)
+
+var (
+	ErrOutsideValidity  = errors.New("outside key validity window")
+	ErrValidityTampered = errors.New("key validity window failed authentication")
+)
+
+// Validity restricts when a key may sign. Zero fields leave that side open.
+// Levels are inclusive; times are checked against the gadget clock.
+type Validity struct {
+	NotBefore time.Time `json:"not_before,omitzero"`
+	NotAfter  time.Time `json:"not_after,omitzero"`
+	MinLevel  uint64    `json:"min_level,omitempty"`
+	MaxLevel  uint64    `json:"max_level,omitempty"`
+}
+
+func (v Validity) IsZero() bool {
+	return v.NotBefore.IsZero() && v.NotAfter.IsZero() && v.MinLevel == 0 && v.MaxLevel == 0
+}
+
+func ValidityFromProto(p *signerpb.Validity) Validity {
+	var v Validity
+	if p == nil {
+		return v
+	}
+	if p.GetNotBefore() != 0 {
+		v.NotBefore = time.Unix(p.GetNotBefore(), 0).UTC()
+	}
+	if p.GetNotAfter() != 0 {
+		v.NotAfter = time.Unix(p.GetNotAfter(), 0).UTC()
+	}
+	v.MinLevel = p.GetMinLevel()
+	v.MaxLevel = p.GetMaxLevel()
+	return v
+}
+
+func (v Validity) toProto() *signerpb.Validity {
+	p := &signerpb.Validity{MinLevel: v.MinLevel, MaxLevel: v.MaxLevel}
+	if !v.NotBefore.IsZero() {
+		p.NotBefore = v.NotBefore.Unix()
+	}
+	if !v.NotAfter.IsZero() {
+		p.NotAfter = v.NotAfter.Unix()
+	}
+	return p
+}
+
+func (v Validity) validate() error {
+	if !v.NotBefore.IsZero() && !v.NotAfter.IsZero() && !v.NotAfter.After(v.NotBefore) {
+		return fmt.Errorf("invalid validity: not_after must be after not_before")
+	}
+	if v.MaxLevel != 0 && v.MaxLevel < v.MinLevel {
+		return fmt.Errorf("invalid validity: max_level must be >= min_level")
+	}
+	return nil
+}
+
+func (v Validity) allows(now time.Time, level uint64) bool {
+	switch {
+	case !v.NotBefore.IsZero() && now.Before(v.NotBefore):
+		return false
+	case !v.NotAfter.IsZero() && !now.Before(v.NotAfter):
+		return false
+	case level < v.MinLevel:
+		return false
+	case v.MaxLevel != 0 && level > v.MaxLevel:
+		return false
+	default:
+		return true
+	}
+}
+
+// validityMAC authenticates the window under the key's DEK so it cannot be
+// edited in meta.json without the key. Whether there is a window at all is
+// sealed in the key's state file, so dropping both fields is caught too.
+func validityMAC(dek []byte, id, tz4 string, v Validity) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, dek)
+	mac.Write([]byte("validity|id=" + id + "|tz4=" + tz4 + "|"))
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// loadValidity returns the window stored in meta, verifying its MAC.
+// required is the key state's record that a window was set.
+func loadValidity(meta keyMeta, dek []byte, id string, required bool) (Validity, error) {
+	if meta.Validity == nil {
+		if required || len(meta.ValidityMAC) != 0 {
+			return Validity{}, ErrValidityTampered
+		}
+		return Validity{}, nil
+	}
+	want, err := validityMAC(dek, id, meta.TZ4, *meta.Validity)
+	if err != nil {
+		return Validity{}, err
+	}
+	if !hmac.Equal(want, meta.ValidityMAC) {
+		return Validity{}, ErrValidityTampered
+	}
+	return *meta.Validity, nil
+}
@@ -0,0 +1,244 @@
+package keychain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/secure"
+)
+
+const watermarkSnapshotVersion = 1
+
+var ErrSnapshotInvalid = errors.New("watermark snapshot invalid or not authentic")
+
+// WatermarkSnapshot is the export/import format for high watermarks. The MAC
+// is keyed from the master KEK, so only a store with the same master (e.g. a
+// restored backup on a replacement device) and passphrase can verify it.
+type WatermarkSnapshot struct {
+	Version  int                    `json:"version"`
+	DeviceID string                 `json:"device_id,omitempty"`
+	Created  time.Time              `json:"created"`
+	Keys     []WatermarkSnapshotKey `json:"keys"`
+	MAC      []byte                 `json:"mac,omitempty"`
+}
+
+type WatermarkSnapshotKey struct {
+	KeyID string                  `json:"key_id"`
+	TZ4   string                  `json:"tz4"`
+	Kinds []WatermarkSnapshotKind `json:"kinds"`
+}
+
+type WatermarkSnapshotKind struct {
+	Kind  uint8  `json:"kind"`
+	Name  string `json:"name,omitempty"`
+	Level uint64 `json:"level"`
+	Round uint32 `json:"round"`
+}
+
+// WatermarkImportResult reports what happened to one snapshot key.
+type WatermarkImportResult struct {
+	KeyID  string
+	TZ4    string
+	Raised bool // at least one kind moved up
+	Err    error
+}
+
+func (fs *FileStore) snapshotMACKey(masterPassword []byte) ([]byte, error) {
+	kek, _, err := fs.deriveKEK(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer kek.Close()
+
+	mac := hmac.New(sha256.New, kek.Bytes())
+	mac.Write([]byte("tezsign/watermark-snapshot/v1"))
+	return mac.Sum(nil), nil
+}
+
+func (s *WatermarkSnapshot) computeMAC(macKey []byte) ([]byte, error) {
+	unsigned := *s
+	unsigned.MAC = nil
+	body, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// ExportWatermarks snapshots the watermarks of all unlocked keys. Locked keys
+// cannot be read and are returned in skipped.
+func (kr *KeyRing) ExportWatermarks(masterPassword []byte, deviceID string) (data []byte, skipped []string, err error) {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	ids, err := kr.store.list()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snap := WatermarkSnapshot{
+		Version:  watermarkSnapshotVersion,
+		DeviceID: strings.TrimSpace(deviceID),
+		Created:  time.Now().UTC().Truncate(time.Second),
+		Keys:     make([]WatermarkSnapshotKey, 0, len(ids)),
+	}
+	for _, id := range ids {
+		key := kr.get(id)
+		if key == nil {
+			skipped = append(skipped, id)
+			continue
+		}
+		entry, ok := key.snapshotWatermarks(id)
+		if !ok {
+			skipped = append(skipped, id)
+			continue
+		}
+		snap.Keys = append(snap.Keys, entry)
+	}
+
+	macKey, err := kr.store.snapshotMACKey(masterPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer secure.MemoryWipe(macKey)
+
+	if snap.MAC, err = snap.computeMAC(macKey); err != nil {
+		return nil, nil, err
+	}
+	data, err = json.MarshalIndent(&snap, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kr.log.Info("watermarks exported", "keys", len(snap.Keys), "skipped", len(skipped))
+	return data, skipped, nil
+}
+
+// ImportWatermarks verifies a snapshot and raises watermarks of matching
+// (by tz4) unlocked keys. Nothing is ever lowered.
+func (kr *KeyRing) ImportWatermarks(masterPassword []byte, data []byte) ([]WatermarkImportResult, error) {
+	kr.lifecycleMu.Lock()
+	defer kr.lifecycleMu.Unlock()
+
+	var snap WatermarkSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotInvalid, err)
+	}
+	if snap.Version != watermarkSnapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrSnapshotInvalid, snap.Version)
+	}
+
+	macKey, err := kr.store.snapshotMACKey(masterPassword)
+	if err != nil {
+		return nil, err
+	}
+	defer secure.MemoryWipe(macKey)
+
+	want, err := snap.computeMAC(macKey)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(want, snap.MAC) {
+		return nil, ErrSnapshotInvalid
+	}
+
+	results := make([]WatermarkImportResult, 0, len(snap.Keys))
+	for _, entry := range snap.Keys {
+		res := WatermarkImportResult{TZ4: entry.TZ4}
+		id, err := kr.resolveKeyIDByTZ4(entry.TZ4)
+		if err != nil {
+			res.Err = ErrKeyNotFound
+			results = append(results, res)
+			continue
+		}
+		res.KeyID = id
+
+		key := kr.get(id)
+		if key == nil {
+			res.Err = ErrKeyLocked
+			results = append(results, res)
+			continue
+		}
+		res.Raised, res.Err = key.raiseWatermarks(id, entry.Kinds)
+		results = append(results, res)
+	}
+
+	kr.log.Info("watermarks imported", "device_id", snap.DeviceID, "created", snap.Created, "keys", len(results))
+	return results, nil
+}
+
+func (k *gKey) snapshotWatermarks(id string) (WatermarkSnapshotKey, bool) {
+	unlock := k.lock()
+	defer unlock()
+
+	if !k.isUnlocked() || k.hwmCorrupted {
+		return WatermarkSnapshotKey{}, false
+	}
+
+	entry := WatermarkSnapshotKey{KeyID: id, TZ4: k.tz4}
+	for kind, hw := range k.watermark {
+		name := kind.String()
+		if name == "unknown" {
+			name = ""
+		}
+		entry.Kinds = append(entry.Kinds, WatermarkSnapshotKind{
+			Kind:  uint8(kind),
+			Name:  name,
+			Level: hw.level,
+			Round: hw.round,
+		})
+	}
+	slices.SortFunc(entry.Kinds, func(a, b WatermarkSnapshotKind) int { return int(a.Kind) - int(b.Kind) })
+	return entry, true
+}
+
+func (k *gKey) raiseWatermarks(id string, kinds []WatermarkSnapshotKind) (bool, error) {
+	unlock := k.lock()
+	defer unlock()
+
+	if !k.isUnlocked() {
+		return false, ErrKeyLocked
+	}
+	if k.hwmCorrupted {
+		return false, ErrKeyStateCorrupted
+	}
+
+	raised := map[SIGN_KIND]HighWatermark{}
+	for _, entry := range kinds {
+		kind := SIGN_KIND(entry.Kind)
+		if kind == UNSPECIFIED {
+			return false, fmt.Errorf("%w: kind 0x00", ErrSnapshotInvalid)
+		}
+		next := HighWatermark{level: entry.Level, round: entry.Round}
+		if LevelRoundIncreasing(k.watermark[kind], next) {
+			raised[kind] = next
+		}
+	}
+	if len(raised) == 0 {
+		return false, nil
+	}
+
+	nextState := k.keyStateSnapshot()
+	for kind, hw := range raised {
+		nextState.ByKind[int32(kind)] = hw.ToKeyState()
+	}
+	nextSeq := k.hwmSeq + 1
+
+	if err := k.hwmFile.persist(k.dek.Bytes(), id, k.tz4, nextState, nextSeq); err != nil {
+		return false, err
+	}
+
+	for kind, hw := range raised {
+		k.watermark[kind] = hw
+	}
+	k.hwmSeq = nextSeq
+	return true, nil
+}
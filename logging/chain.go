@@ -0,0 +1,328 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hash-chained logs: every line written through a ChainWriter carries
+// chain=H(previous chain || line), so editing, dropping or reordering lines
+// breaks the chain from that point on. Every CheckpointEvery records a
+// LOG_CHECKPOINT line commits to the current head; with a key it is signed
+// (ed25519), which pins the chain against someone who rewrites it wholesale.
+//
+// A process start writes LOG_CHAIN_START and begins a new chain, so appending
+// after a restart stays verifiable. A rotated file usually starts mid-chain;
+// its first line is taken as the anchor.
+
+const (
+	chainStartMsg      = "LOG_CHAIN_START"
+	chainCheckpointMsg = "LOG_CHECKPOINT"
+	checkpointDomain   = "tezsign-log-checkpoint\x00"
+)
+
+var (
+	ErrChainBroken = errors.New("log chain broken")
+
+	textChainRe = regexp.MustCompile(` chain=([0-9a-f]{64})$`)
+	jsonChainRe = regexp.MustCompile(`,"chain":"([0-9a-f]{64})"}$`)
+
+	textStartRe = regexp.MustCompile(`^time=\S+ level=INFO msg=` + chainStartMsg + `(?: key=[0-9a-f]{64})?$`)
+	jsonStartRe = regexp.MustCompile(`^{"time":"[^"]+","level":"INFO","msg":"` + chainStartMsg + `"(?:,"key":"[0-9a-f]{64}")?}$`)
+
+	textCheckpointRe = regexp.MustCompile(`^time=\S+ level=INFO msg=` + chainCheckpointMsg + ` seq=(\d+) head=([0-9a-f]{64})(?: sig=([0-9a-f]{128}))?$`)
+	jsonCheckpointRe = regexp.MustCompile(`^{"time":"[^"]+","level":"INFO","msg":"` + chainCheckpointMsg + `","seq":(\d+),"head":"([0-9a-f]{64})"(?:,"sig":"([0-9a-f]{128})")?}$`)
+)
+
+// ChainWriter chains the newline-terminated records written to it (slog
+// handlers write one record per call) and passes them on to w.
+type ChainWriter struct {
+	mu              sync.Mutex
+	w               io.Writer
+	json            bool
+	key             ed25519.PrivateKey
+	checkpointEvery int
+
+	started bool
+	seq     uint64
+	head    [sha256.Size]byte
+	partial []byte
+}
+
+// NewChainWriter wraps w. jsonFormat must match the handler writing to it;
+// key may be nil for unsigned checkpoints and checkpointEvery 0 disables them.
+func NewChainWriter(w io.Writer, jsonFormat bool, key ed25519.PrivateKey, checkpointEvery int) *ChainWriter {
+	return &ChainWriter{w: w, json: jsonFormat, key: key, checkpointEvery: checkpointEvery}
+}
+
+func (c *ChainWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.started {
+		c.started = true
+		if err := c.writeStart(); err != nil {
+			return 0, err
+		}
+	}
+
+	buf := append(c.partial, p...)
+	c.partial = nil
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := c.writeLine(buf[:i]); err != nil {
+			return 0, err
+		}
+		buf = buf[i+1:]
+		c.seq++
+		if c.checkpointEvery > 0 && c.seq%uint64(c.checkpointEvery) == 0 {
+			if err := c.writeCheckpoint(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(buf) > 0 {
+		c.partial = append([]byte(nil), buf...)
+	}
+	return len(p), nil
+}
+
+// Checkpoint writes a checkpoint now, e.g. before shutdown.
+func (c *ChainWriter) Checkpoint() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started {
+		return nil
+	}
+	return c.writeCheckpoint()
+}
+
+// Close flushes a final checkpoint and closes w if it can be closed.
+func (c *ChainWriter) Close() error {
+	err := c.Checkpoint()
+	if cl, ok := c.w.(io.Closer); ok {
+		if cerr := cl.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (c *ChainWriter) writeLine(line []byte) error {
+	c.head = chainNext(c.head, line)
+	var out []byte
+	if c.json && len(line) > 0 && line[len(line)-1] == '}' {
+		out = append(out, line[:len(line)-1]...)
+		out = append(out, `,"chain":"`+hex.EncodeToString(c.head[:])+`"}`...)
+	} else {
+		out = append(out, line...)
+		out = append(out, " chain="+hex.EncodeToString(c.head[:])...)
+	}
+	out = append(out, '\n')
+	_, err := c.w.Write(out)
+	return err
+}
+
+func (c *ChainWriter) writeStart() error {
+	c.head = [sha256.Size]byte{}
+	now := time.Now().Format(time.RFC3339Nano)
+	var line string
+	if c.json {
+		line = `{"time":"` + now + `","level":"INFO","msg":"` + chainStartMsg + `"`
+		if c.key != nil {
+			line += `,"key":"` + hex.EncodeToString(c.key.Public().(ed25519.PublicKey)) + `"`
+		}
+		line += "}"
+	} else {
+		line = "time=" + now + " level=INFO msg=" + chainStartMsg
+		if c.key != nil {
+			line += " key=" + hex.EncodeToString(c.key.Public().(ed25519.PublicKey))
+		}
+	}
+	return c.writeLine([]byte(line))
+}
+
+func (c *ChainWriter) writeCheckpoint() error {
+	now := time.Now().Format(time.RFC3339Nano)
+	head := hex.EncodeToString(c.head[:])
+	var sig string
+	if c.key != nil {
+		sig = hex.EncodeToString(ed25519.Sign(c.key, checkpointMessage(c.seq, c.head)))
+	}
+	var line string
+	if c.json {
+		line = fmt.Sprintf(`{"time":"%s","level":"INFO","msg":"%s","seq":%d,"head":"%s"`, now, chainCheckpointMsg, c.seq, head)
+		if sig != "" {
+			line += `,"sig":"` + sig + `"`
+		}
+		line += "}"
+	} else {
+		line = fmt.Sprintf("time=%s level=INFO msg=%s seq=%d head=%s", now, chainCheckpointMsg, c.seq, head)
+		if sig != "" {
+			line += " sig=" + sig
+		}
+	}
+	return c.writeLine([]byte(line))
+}
+
+func chainNext(prev [sha256.Size]byte, line []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(prev[:])
+	h.Write(line)
+	var out [sha256.Size]byte
+	h.Sum(out[:0])
+	return out
+}
+
+func checkpointMessage(seq uint64, head [sha256.Size]byte) []byte {
+	msg := append([]byte(checkpointDomain), binary.BigEndian.AppendUint64(nil, seq)...)
+	return append(msg, head[:]...)
+}
+
+// ChainReport summarises a verified log.
+type ChainReport struct {
+	Records     int  // chained lines, including starts and checkpoints
+	Starts      int  // LOG_CHAIN_START lines (process starts)
+	Checkpoints int  // checkpoints whose head matched
+	Signed      int  // checkpoints whose signature verified
+	Anchored    bool // the first line was not a start and was trusted as is
+}
+
+// VerifyChain checks every line of r. With pub set, every checkpoint must be
+// signed by it; without, signatures are ignored. Lines written without
+// chaining (e.g. from before it was turned on) are an error.
+func VerifyChain(r io.Reader, pub ed25519.PublicKey) (ChainReport, error) {
+	var (
+		rep     ChainReport
+		head    [sha256.Size]byte
+		seq     uint64
+		lineNo  int
+		started bool
+		// seq is unknown until a start line after anchoring mid-chain
+		seqKnown bool
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		lineNo++
+		raw := sc.Text()
+		body, want, isJSON, ok := splitChain(raw)
+		if !ok {
+			return rep, fmt.Errorf("%w: line %d has no chain value", ErrChainBroken, lineNo)
+		}
+
+		isStart := textStartRe.MatchString(body) || jsonStartRe.MatchString(body)
+		switch {
+		case isStart:
+			head, seq, seqKnown = [sha256.Size]byte{}, 0, true
+			rep.Starts++
+		case !started:
+			// mid-chain (rotated) file: anchor on the first line
+			rep.Anchored = true
+			started = true
+			head = want
+			rep.Records++
+			continue
+		}
+		started = true
+
+		if m := checkpointMatch(body, isJSON); m != nil {
+			gotSeq, _ := strconv.ParseUint(m[1], 10, 64)
+			if m[2] != hex.EncodeToString(head[:]) {
+				return rep, fmt.Errorf("%w: line %d: checkpoint head does not match the chain", ErrChainBroken, lineNo)
+			}
+			if seqKnown && gotSeq != seq {
+				return rep, fmt.Errorf("%w: line %d: checkpoint counts %d records, chain has %d", ErrChainBroken, lineNo, gotSeq, seq)
+			}
+			if pub != nil {
+				sig, _ := hex.DecodeString(m[3])
+				if len(sig) == 0 || !ed25519.Verify(pub, checkpointMessage(gotSeq, head), sig) {
+					return rep, fmt.Errorf("%w: line %d: checkpoint signature invalid", ErrChainBroken, lineNo)
+				}
+				rep.Signed++
+			}
+			rep.Checkpoints++
+			seq, seqKnown = gotSeq, true
+		} else if !isStart {
+			seq++
+		}
+
+		head = chainNext(head, []byte(body))
+		if head != want {
+			return rep, fmt.Errorf("%w: line %d", ErrChainBroken, lineNo)
+		}
+		rep.Records++
+	}
+	return rep, sc.Err()
+}
+
+// VerifyChainFile is VerifyChain over a file; a .gz backup is decompressed.
+func VerifyChainFile(path string, pub ed25519.PublicKey) (ChainReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ChainReport{}, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return ChainReport{}, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return VerifyChain(r, pub)
+}
+
+func splitChain(line string) (body string, chain [sha256.Size]byte, isJSON, ok bool) {
+	if m := jsonChainRe.FindStringSubmatchIndex(line); m != nil {
+		body = line[:m[0]] + "}"
+		isJSON = true
+		hex.Decode(chain[:], []byte(line[m[2]:m[3]]))
+		return body, chain, isJSON, true
+	}
+	if m := textChainRe.FindStringSubmatchIndex(line); m != nil {
+		body = line[:m[0]]
+		hex.Decode(chain[:], []byte(line[m[2]:m[3]]))
+		return body, chain, false, true
+	}
+	return "", chain, false, false
+}
+
+func checkpointMatch(body string, isJSON bool) []string {
+	if isJSON {
+		return jsonCheckpointRe.FindStringSubmatch(body)
+	}
+	return textCheckpointRe.FindStringSubmatch(body)
+}
+
+// LoadChainKey reads an ed25519 seed stored as 64 hex characters.
+func LoadChainKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: want %d hex-encoded bytes", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func writeChained(t *testing.T, jsonFormat bool, key ed25519.PrivateKey, records int) string {
+	t.Helper()
+	var out bytes.Buffer
+	cw := NewChainWriter(&out, jsonFormat, key, 3)
+	var h slog.Handler = slog.NewTextHandler(cw, nil)
+	if jsonFormat {
+		h = slog.NewJSONHandler(cw, nil)
+	}
+	l := slog.New(h)
+	for i := 0; i < records; i++ {
+		l.Info("SIGNED", "key_id", "key1", "level", i)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return out.String()
+}
+
+func TestChainWriterVerifies(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	for _, jsonFormat := range []bool{false, true} {
+		log := writeChained(t, jsonFormat, key, 7)
+		rep, err := VerifyChain(strings.NewReader(log), pub)
+		if err != nil {
+			t.Fatalf("json=%v: VerifyChain: %v\n%s", jsonFormat, err, log)
+		}
+		// 1 start + 7 records + checkpoints at 3, 6 and on close
+		if rep.Records != 11 || rep.Starts != 1 || rep.Checkpoints != 3 || rep.Signed != 3 || rep.Anchored {
+			t.Fatalf("json=%v: report %+v", jsonFormat, rep)
+		}
+	}
+}
+
+func TestChainDetectsTampering(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	log := writeChained(t, false, key, 7)
+	lines := strings.SplitAfter(log, "\n")
+
+	edited := strings.Replace(log, "level=4", "level=40", 1)
+	if _, err := VerifyChain(strings.NewReader(edited), pub); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("edited line: err = %v", err)
+	}
+
+	dropped := strings.Join(append(append([]string{}, lines[:2]...), lines[3:]...), "")
+	if _, err := VerifyChain(strings.NewReader(dropped), pub); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("dropped line: err = %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := VerifyChain(strings.NewReader(log), other); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("wrong checkpoint key: err = %v", err)
+	}
+}
+
+func TestChainAnchorsRotatedFile(t *testing.T) {
+	log := writeChained(t, true, nil, 5)
+	lines := strings.SplitAfter(log, "\n")
+	tail := strings.Join(lines[3:], "")
+
+	rep, err := VerifyChain(strings.NewReader(tail), nil)
+	if err != nil {
+		t.Fatalf("VerifyChain: %v", err)
+	}
+	if !rep.Anchored || rep.Starts != 0 {
+		t.Fatalf("report %+v", rep)
+	}
+}
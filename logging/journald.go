@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+var errJournalUnavailable = errors.New("journald is not available on this platform")
+
+// JournalHandler sends records to systemd-journald over its native protocol.
+// The message goes to MESSAGE, the level to PRIORITY and every attribute to
+// an upper-cased field (groups joined with "_"), so `journalctl -o verbose`
+// and field matches like `journalctl TZ4=tz4…` work.
+type JournalHandler struct {
+	level  slog.Leveler
+	ident  string
+	attrs  []byte // pre-encoded WithAttrs fields
+	prefix string // open groups, "GROUP_"
+
+	conn *journalConn
+}
+
+// NewJournalHandler connects to the local journal. It fails when journald is
+// not running, so callers can fall back to another handler.
+func NewJournalHandler(level slog.Leveler) (*JournalHandler, error) {
+	conn, err := dialJournal(journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalHandler{
+		level: level,
+		ident: filepath.Base(os.Args[0]),
+		conn:  conn,
+	}, nil
+}
+
+func (h *JournalHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h *JournalHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", r.Message)
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(journalPriority(r.Level)))
+	appendJournalField(&b, "SYSLOG_IDENTIFIER", h.ident)
+	if r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		appendJournalField(&b, "CODE_FILE", f.File)
+		appendJournalField(&b, "CODE_LINE", strconv.Itoa(f.Line))
+		appendJournalField(&b, "CODE_FUNC", f.Function)
+	}
+	b.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendJournalAttr(&b, h.prefix, a)
+		return true
+	})
+	return h.conn.send(b.Bytes())
+}
+
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b bytes.Buffer
+	b.Write(h.attrs)
+	for _, a := range attrs {
+		appendJournalAttr(&b, h.prefix, a)
+	}
+	out := *h
+	out.attrs = b.Bytes()
+	return &out
+}
+
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.prefix = h.prefix + journalFieldName(name) + "_"
+	return &out
+}
+
+// journalPriority maps slog levels onto syslog priorities.
+func journalPriority(lvl slog.Level) int {
+	switch {
+	case lvl >= slog.LevelError:
+		return 3 // err
+	case lvl >= slog.LevelWarn:
+		return 4 // warning
+	case lvl >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+func appendJournalAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += journalFieldName(a.Key) + "_"
+		}
+		for _, ga := range v.Group() {
+			appendJournalAttr(b, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	appendJournalField(b, prefix+journalFieldName(a.Key), v.String())
+}
+
+// journalFieldName turns an attribute key into a valid field name: upper
+// case letters, digits and "_", not starting with "_" (reserved for trusted
+// fields) or a digit.
+func journalFieldName(key string) string {
+	var sb strings.Builder
+	for _, c := range strings.ToUpper(key) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	name := strings.TrimLeft(sb.String(), "_0123456789")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+// appendJournalField writes NAME=value, or the length-prefixed form when the
+// value spans lines.
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	b.Write(n[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+type journalConn struct {
+	mu sync.Mutex
+	fd int
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package logging
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func dialJournal(path string) (*journalConn, error) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Connect(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &journalConn{fd: fd}, nil
+}
+
+// send writes one entry as a datagram. Entries larger than the socket allows
+// are passed as a sealed memfd, which journald reads instead.
+func (c *journalConn) send(entry []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := unix.Send(c.fd, entry, 0)
+	if !errors.Is(err, unix.EMSGSIZE) && !errors.Is(err, unix.ENOBUFS) {
+		return err
+	}
+
+	mfd, err := unix.MemfdCreate("journal-entry", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(mfd)
+	for off := 0; off < len(entry); {
+		n, err := unix.Write(mfd, entry[off:])
+		if err != nil {
+			return err
+		}
+		off += n
+	}
+	if _, err := unix.FcntlInt(uintptr(mfd), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil {
+		return err
+	}
+	return unix.Sendmsg(c.fd, nil, unix.UnixRights(mfd), nil, 0)
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalHandlerEncodesFields(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := dialJournal(sock)
+	if err != nil {
+		t.Fatalf("dialJournal: %v", err)
+	}
+	h := &JournalHandler{level: slog.LevelInfo, ident: "gadget", conn: conn}
+	l := slog.New(h).With("key_id", "key1").WithGroup("req")
+
+	l.Debug("dropped")
+	l.Warn("sign refused", slog.String("tz4", "tz4abc"), slog.String("err", "line one\nline two"))
+
+	buf := make([]byte, 4096)
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	entry := buf[:n]
+
+	for _, want := range []string{
+		"MESSAGE=sign refused\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=gadget\n",
+		"KEY_ID=key1\n",
+		"REQ_TZ4=tz4abc\n",
+	} {
+		if !bytes.Contains(entry, []byte(want)) {
+			t.Fatalf("entry lacks %q:\n%q", want, entry)
+		}
+	}
+	var lenPrefixed bytes.Buffer
+	lenPrefixed.WriteString("REQ_ERR\n")
+	binary.Write(&lenPrefixed, binary.LittleEndian, uint64(len("line one\nline two")))
+	lenPrefixed.WriteString("line one\nline two\n")
+	if !bytes.Contains(entry, lenPrefixed.Bytes()) {
+		t.Fatalf("multi-line value not length-prefixed:\n%q", entry)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for in, want := range map[string]string{
+		"tz4":       "TZ4",
+		"key-id":    "KEY_ID",
+		"_internal": "INTERNAL",
+		"9lives":    "LIVES",
+		"...":       "FIELD",
+	} {
+		if got := journalFieldName(in); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package logging
+
+func dialJournal(string) (*journalConn, error) {
+	return nil, errJournalUnavailable
+}
+
+func (c *journalConn) send([]byte) error {
+	return errJournalUnavailable
+}
@@ -0,0 +1,225 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Modules with their own named logger (see Named).
+const (
+	ModuleBroker   = "broker"
+	ModuleKeychain = "keychain"
+	ModuleGadget   = "gadget"
+	ModuleHTTP     = "http"
+)
+
+// levelAll lets everything through the inner handlers; moduleHandler gates.
+const levelAll = slog.Level(math.MinInt)
+
+// ParseLevel accepts all, debug, info, warn(ing) and error.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "all":
+		return slog.Level(-100), nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// ParseLevelSpec reads "info,broker=debug,keychain=warn": a bare level sets
+// the default, module=level overrides one named logger. Entries that do not
+// parse are returned in bad and otherwise ignored.
+func ParseLevelSpec(spec string, def slog.Level) (global slog.Level, modules map[string]slog.Level, bad []string) {
+	global = def
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvlStr, isModule := strings.Cut(part, "=")
+		lvl, err := ParseLevel(lvlStr)
+		if !isModule {
+			lvl, err = ParseLevel(name)
+		}
+		if err != nil || (isModule && strings.TrimSpace(name) == "") {
+			bad = append(bad, part)
+			continue
+		}
+		if !isModule {
+			global = lvl
+			continue
+		}
+		if modules == nil {
+			modules = make(map[string]slog.Level)
+		}
+		modules[strings.ToLower(strings.TrimSpace(name))] = lvl
+	}
+	return global, modules, bad
+}
+
+// LevelTable holds the default level and per-module overrides; loggers made
+// by New consult it on every record, so changes apply immediately.
+type LevelTable struct {
+	mu      sync.RWMutex
+	global  slog.Level
+	modules map[string]slog.Level
+}
+
+func NewLevelTable(global slog.Level, modules map[string]slog.Level) *LevelTable {
+	t := &LevelTable{global: global, modules: make(map[string]slog.Level, len(modules))}
+	for m, l := range modules {
+		t.modules[m] = l
+	}
+	return t
+}
+
+// Apply changes the table with a LOG_LEVEL style spec: a bare level sets the
+// default, module=level overrides a module and module=default drops the
+// override. Nothing changes if any entry is invalid.
+func (t *LevelTable) Apply(spec string) error {
+	type change struct {
+		module string
+		level  slog.Level
+		clear  bool
+	}
+	var changes []change
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvlStr, isModule := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isModule {
+			lvl, err := ParseLevel(name)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, change{level: lvl})
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("log level %q: empty module", part)
+		}
+		if strings.EqualFold(strings.TrimSpace(lvlStr), "default") {
+			changes = append(changes, change{module: name, clear: true})
+			continue
+		}
+		lvl, err := ParseLevel(lvlStr)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change{module: name, level: lvl})
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("empty log level spec")
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range changes {
+		switch {
+		case c.module == "":
+			t.global = c.level
+		case c.clear:
+			delete(t.modules, c.module)
+		default:
+			t.modules[c.module] = c.level
+		}
+	}
+	return nil
+}
+
+// Level is the effective level of module ("" = default).
+func (t *LevelTable) Level(module string) slog.Level {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if l, ok := t.modules[module]; ok {
+		return l
+	}
+	return t.global
+}
+
+// String renders the table in LOG_LEVEL syntax.
+func (t *LevelTable) String() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	parts := []string{levelName(t.global)}
+	names := make([]string, 0, len(t.modules))
+	for m := range t.modules {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	for _, m := range names {
+		parts = append(parts, m+"="+levelName(t.modules[m]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func levelName(l slog.Level) string {
+	if l <= slog.Level(-100) {
+		return "all"
+	}
+	return strings.ToLower(l.String())
+}
+
+var (
+	curLevels   *LevelTable
+	curLevelsMu sync.RWMutex
+)
+
+// CurrentLevels is the table of the last logger New built, or nil.
+func CurrentLevels() *LevelTable {
+	curLevelsMu.RLock()
+	defer curLevelsMu.RUnlock()
+	return curLevels
+}
+
+func setCurrentLevels(t *LevelTable) {
+	curLevelsMu.Lock()
+	curLevels = t
+	curLevelsMu.Unlock()
+}
+
+type moduleHandler struct {
+	inner  slog.Handler
+	table  *LevelTable
+	module string
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.table.Level(h.module) && h.inner.Enabled(ctx, lvl)
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &moduleHandler{inner: h.inner.WithAttrs(attrs), table: h.table, module: h.module}
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return &moduleHandler{inner: h.inner.WithGroup(name), table: h.table, module: h.module}
+}
+
+// Named returns the child logger of module, tagged component=<module>. Its
+// level follows the module's LOG_LEVEL entry when l came from New.
+func Named(l *slog.Logger, module string) *slog.Logger {
+	if mh, ok := l.Handler().(*moduleHandler); ok {
+		l = slog.New(&moduleHandler{inner: mh.inner, table: mh.table, module: module})
+	}
+	return l.With("component", module)
+}
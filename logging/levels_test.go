@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevelSpec(t *testing.T) {
+	global, modules, bad := ParseLevelSpec("warn, broker=debug,KEYCHAIN=error,http=loud,=info", slog.LevelInfo)
+	if global != slog.LevelWarn {
+		t.Fatalf("global = %v", global)
+	}
+	if modules["broker"] != slog.LevelDebug || modules["keychain"] != slog.LevelError || len(modules) != 2 {
+		t.Fatalf("modules = %v", modules)
+	}
+	if len(bad) != 2 {
+		t.Fatalf("bad = %v", bad)
+	}
+	if got := NewLevelTable(global, modules).String(); got != "warn,broker=debug,keychain=error" {
+		t.Fatalf("String() = %q", got)
+	}
+
+	if global, modules, _ := ParseLevelSpec("", slog.LevelInfo); global != slog.LevelInfo || modules != nil {
+		t.Fatalf("empty spec: %v %v", global, modules)
+	}
+}
+
+func TestNamedLoggersFollowModuleLevels(t *testing.T) {
+	var out bytes.Buffer
+	table := NewLevelTable(slog.LevelInfo, map[string]slog.Level{ModuleBroker: slog.LevelDebug})
+	root := slog.New(&moduleHandler{inner: slog.NewTextHandler(&out, &slog.HandlerOptions{Level: levelAll}), table: table})
+
+	Named(root, ModuleKeychain).Debug("keychain noise")
+	Named(root, ModuleBroker).Debug("usb frame")
+	root.Debug("root noise")
+
+	got := out.String()
+	if strings.Contains(got, "noise") {
+		t.Fatalf("debug leaked through info modules:\n%s", got)
+	}
+	if !strings.Contains(got, "msg=\"usb frame\"") || !strings.Contains(got, "component=broker") {
+		t.Fatalf("broker debug missing:\n%s", got)
+	}
+}
+
+func TestLevelTableApply(t *testing.T) {
+	table := NewLevelTable(slog.LevelInfo, map[string]slog.Level{ModuleKeychain: slog.LevelWarn})
+
+	if err := table.Apply("broker=debug, keychain=default"); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got := table.String(); got != "info,broker=debug" {
+		t.Fatalf("after apply: %q", got)
+	}
+	if err := table.Apply("error,http=loud"); err == nil {
+		t.Fatal("invalid entry accepted")
+	}
+	if got := table.String(); got != "info,broker=debug" {
+		t.Fatalf("failed apply changed the table: %q", got)
+	}
+	if err := table.Apply("warn"); err != nil || table.Level(ModuleGadget) != slog.LevelWarn || table.Level(ModuleBroker) != slog.LevelDebug {
+		t.Fatalf("default change: %v %q", err, table.String())
+	}
+}
@@ -3,6 +3,8 @@ package logging
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
@@ -10,48 +12,59 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // ----------------- Config -----------------
 
 type Config struct {
-	Level        slog.Level // default: Info
-	Format       string     // "text" or "json" (default "text")
-	File         string     // path to log file; empty = no file
-	AlsoStderr   bool       // default true
-	MaxSizeMB    int        // default 50
-	SetAsDefault bool       // set slog.SetDefault
+	Level          slog.Level            // default: Info
+	ModuleLevels   map[string]slog.Level // per Named logger, overrides Level
+	Format         string                // "text", "json" or "journald" (default "text")
+	File           string                // path to log file; empty = no file
+	AlsoStderr     bool                  // default true
+	MaxSizeMB      int                   // default 50
+	MaxBackups     int                   // rotated files to keep; 0 = unlimited (default 3)
+	MaxAgeDays     int                   // drop rotated files older than this; 0 = never
+	Compress       bool                  // gzip rotated files
+	Chain          bool                  // hash-chain file records, see ChainWriter
+	ChainEvery     int                   // records between checkpoints; 0 = none (default 1000)
+	ChainKeyFile   string                // hex ed25519 seed signing checkpoints; empty = unsigned
+	RingLines      int                   // keep this many records in memory (CurrentRing); 0 = off
+	Syslog         string                // udp://host[:port], tcp://host[:port] or unix:///dev/log; empty = off
+	SyslogFacility int                   // syslog facility (default 1, user)
+	SetAsDefault   bool                  // set slog.SetDefault
 }
 
 func DefaultConfig() Config {
 	return Config{
-		Level:      slog.LevelInfo,
-		Format:     "text",
-		AlsoStderr: true,
-		MaxSizeMB:  50,
+		Level:          slog.LevelInfo,
+		Format:         "text",
+		AlsoStderr:     true,
+		MaxSizeMB:      50,
+		MaxBackups:     3,
+		ChainEvery:     1000,
+		SyslogFacility: 1,
 	}
 }
 
+// UsesJournal reports whether logs go to journald, in which case services
+// need not default to a log file.
+func (c Config) UsesJournal() bool { return c.Format == "journald" }
+
 // NewConfigFromEnv reads {PREFIX}_LOG* variables; falls back to BROKER_LOG* if prefix empty.
 func NewConfigFromEnv() Config {
 	cfg := DefaultConfig()
 
-	// Level
-	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
-	case "all":
-		cfg.Level = slog.Level(-100)
-	case "debug":
-		cfg.Level = slog.LevelDebug
-	case "warn", "warning":
-		cfg.Level = slog.LevelWarn
-	case "error":
-		cfg.Level = slog.LevelError
-	}
+	// Level, e.g. LOG_LEVEL=info,broker=debug
+	cfg.Level, cfg.ModuleLevels, _ = ParseLevelSpec(os.Getenv("LOG_LEVEL"), cfg.Level)
 
 	// Format
 	switch strings.ToLower(os.Getenv("LOG_FORMAT")) {
 	case "json":
 		cfg.Format = "json"
+	case "journald":
+		cfg.Format = "journald"
 	case "text", "":
 		cfg.Format = "text"
 	}
@@ -59,6 +72,17 @@ func NewConfigFromEnv() Config {
 	cfg.File = strings.TrimSpace(os.Getenv("LOG_FILE"))
 	cfg.AlsoStderr = envBool(os.Getenv("LOG_STDERR"), true)
 	cfg.MaxSizeMB = envInt(os.Getenv("LOG_MAX_SIZE_MB"), 5)
+	cfg.MaxBackups = envInt(os.Getenv("LOG_MAX_BACKUPS"), cfg.MaxBackups)
+	cfg.MaxAgeDays = envInt(os.Getenv("LOG_MAX_AGE_DAYS"), 0)
+	cfg.Compress = envBool(os.Getenv("LOG_COMPRESS"), false)
+	cfg.Chain = envBool(os.Getenv("LOG_CHAIN"), false)
+	cfg.ChainEvery = envInt(os.Getenv("LOG_CHAIN_CHECKPOINT_EVERY"), cfg.ChainEvery)
+	cfg.ChainKeyFile = strings.TrimSpace(os.Getenv("LOG_CHAIN_KEY_FILE"))
+	cfg.RingLines = envInt(os.Getenv("LOG_RING_LINES"), 0)
+	cfg.Syslog = strings.TrimSpace(os.Getenv("LOG_SYSLOG"))
+	if f, err := ParseSyslogFacility(os.Getenv("LOG_SYSLOG_FACILITY")); err == nil {
+		cfg.SyslogFacility = f
+	}
 
 	cfg.SetAsDefault = true
 	return cfg
@@ -163,19 +187,60 @@ func EnsureDir(path string) error {
 	return os.MkdirAll(dir, 0o755)
 }
 
-// New builds a slog.Logger using cfg; returns the logger and the truncating simple log writer.
+// New builds a slog.Logger using cfg; returns the logger and the rotating file writer (nil without File).
 func New(cfg Config) (*slog.Logger, io.Writer) {
 	handlers := make([]slog.Handler, 0, 2)
 
+	// journald replaces stderr (systemd would capture it twice); a file, if
+	// set, is still written as text.
+	if cfg.Format == "journald" {
+		if jh, err := NewJournalHandler(levelAll); err == nil {
+			handlers = append(handlers, jh)
+			cfg.AlsoStderr = false
+		} else {
+			fmt.Fprintf(os.Stderr, "journald: %v; logging to stderr\n", err)
+			cfg.AlsoStderr = true
+		}
+	}
+
 	var logWriter io.Writer
 	if cfg.File != "" {
-		logWriter, _ = NewSimpleLogResetWriter(cfg.File, cfg.MaxSizeMB*1024*1024)
+		maxAge := time.Duration(cfg.MaxAgeDays) * 24 * time.Hour
+		if rw, err := NewRotatingWriter(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups, maxAge, cfg.Compress); err == nil {
+			logWriter = rw
+		} else {
+			fmt.Fprintf(os.Stderr, "log file %s: %v\n", cfg.File, err)
+			logWriter = io.Discard
+		}
+		if cfg.Chain {
+			logWriter = newChainFromConfig(logWriter, cfg)
+		}
 		setCurrentFile(cfg.File)
 		switch cfg.Format {
 		case "json":
-			handlers = append(handlers, slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewJSONHandler(logWriter, &slog.HandlerOptions{Level: levelAll}))
 		default: // text
-			handlers = append(handlers, slog.NewTextHandler(logWriter, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewTextHandler(logWriter, &slog.HandlerOptions{Level: levelAll}))
+		}
+	}
+
+	// in-memory ring, independent of the file
+	if cfg.RingLines > 0 {
+		ring := NewRingBuffer(cfg.RingLines)
+		setCurrentRing(ring)
+		switch cfg.Format {
+		case "json":
+			handlers = append(handlers, slog.NewJSONHandler(ring, &slog.HandlerOptions{Level: levelAll}))
+		default:
+			handlers = append(handlers, slog.NewTextHandler(ring, &slog.HandlerOptions{Level: levelAll}))
+		}
+	}
+
+	if cfg.Syslog != "" {
+		if sh, err := NewSyslogHandler(cfg.Syslog, cfg.SyslogFacility, levelAll); err == nil {
+			handlers = append(handlers, sh)
+		} else {
+			fmt.Fprintf(os.Stderr, "syslog: %v\n", err)
 		}
 	}
 
@@ -183,29 +248,44 @@ func New(cfg Config) (*slog.Logger, io.Writer) {
 	if cfg.AlsoStderr {
 		switch cfg.Format {
 		case "json":
-			handlers = append(handlers, slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: levelAll}))
 		default:
-			handlers = append(handlers, slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}))
+			handlers = append(handlers, slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: levelAll}))
 		}
 	}
 
 	var h slog.Handler
 	if len(handlers) == 0 {
 		// fallback to stderr text
-		h = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level})
+		h = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: levelAll})
 	} else if len(handlers) == 1 {
 		h = handlers[0]
 	} else {
 		h = MultiHandler{hs: handlers}
 	}
 
-	l := slog.New(h)
+	levels := NewLevelTable(cfg.Level, cfg.ModuleLevels)
+	setCurrentLevels(levels)
+	l := slog.New(&moduleHandler{inner: NewRedactHandler(h), table: levels})
 	if cfg.SetAsDefault {
 		slog.SetDefault(l)
 	}
 	return l, logWriter
 }
 
+func newChainFromConfig(w io.Writer, cfg Config) *ChainWriter {
+	var key ed25519.PrivateKey
+	if cfg.ChainKeyFile != "" {
+		k, err := LoadChainKey(cfg.ChainKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log chain key: %v; checkpoints will be unsigned\n", err)
+		} else {
+			key = k
+		}
+	}
+	return NewChainWriter(w, cfg.Format == "json", key, cfg.ChainEvery)
+}
+
 func NewFromEnv() (*slog.Logger, io.Writer) {
 	return New(NewConfigFromEnv())
 }
@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Redaction runs on every record New builds, so a careless attr cannot put
+// secrets on disk: values under sensitive keys are replaced, []byte becomes
+// its length, protobuf messages (requests carry passphrases) their type, and
+// encoded secret keys are masked anywhere in messages and strings.
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeyParts match one "_"/"-"/"." separated part of an attr key,
+// case-insensitively: "key_passphrase", "sk_prefix" and "decrypted_len" are
+// all redacted, "key" and "tz4" are not.
+var sensitiveKeyParts = map[string]bool{
+	"pass":       true,
+	"passphrase": true,
+	"password":   true,
+	"passwd":     true,
+	"pin":        true,
+	"secret":     true,
+	"seed":       true,
+	"mnemonic":   true,
+	"sk":         true,
+	"privkey":    true,
+	"private":    true,
+	"dek":        true,
+	"kek":        true,
+	"plaintext":  true,
+	"decrypted":  true,
+}
+
+// Tezos-encoded secret keys: BLsk (tz4), edsk, spsk, p2sk, plus encrypted
+// forms (…esk) which are as good as the passphrase protecting them.
+var secretKeyRe = regexp.MustCompile(`\b(?:BLsk|edsk|spsk|p2sk|BLesk|edesk|spesk|p2esk)[1-9A-HJ-NP-Za-km-z]{20,}`)
+
+func isSensitiveKey(key string) bool {
+	for _, part := range strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		if sensitiveKeyParts[part] {
+			return true
+		}
+	}
+	return false
+}
+
+func redactString(s string) string {
+	if strings.Contains(s, "sk") {
+		return secretKeyRe.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		out := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			out[i] = redactAttr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(out...)}
+	case slog.KindAny:
+		var s string
+		switch x := v.Any().(type) {
+		case []byte:
+			return slog.String(a.Key, fmt.Sprintf("[%d bytes]", len(x)))
+		case interface{ ProtoMessage() }:
+			return slog.String(a.Key, fmt.Sprintf("[%T]", x))
+		case error:
+			s = x.Error()
+		case fmt.Stringer:
+			s = x.String()
+		default:
+			if hasSensitiveField(reflect.ValueOf(x), 3) {
+				return slog.String(a.Key, fmt.Sprintf("[%T]", x))
+			}
+			s = fmt.Sprintf("%+v", x)
+		}
+		// keep the value as is (e.g. a slice for JSON) unless it has to change
+		if r := redactString(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// sensitiveFieldWords are matched as substrings of Go field names, which
+// are camel case and cannot be split like attr keys.
+var sensitiveFieldWords = []string{"passphrase", "password", "secret", "seed", "mnemonic", "privkey", "private", "plaintext", "decrypted"}
+
+// hasSensitiveField reports whether a struct (behind pointers, slices and
+// maps, up to depth levels) has a field named like a secret.
+func hasSensitiveField(v reflect.Value, depth int) bool {
+	if depth < 0 || !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return !v.IsNil() && hasSensitiveField(v.Elem(), depth)
+	case reflect.Slice, reflect.Array:
+		return v.Len() > 0 && hasSensitiveField(v.Index(0), depth-1)
+	case reflect.Map:
+		it := v.MapRange()
+		return it.Next() && hasSensitiveField(it.Value(), depth-1)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.ToLower(t.Field(i).Name)
+			for _, w := range sensitiveFieldWords {
+				if strings.Contains(name, w) {
+					return true
+				}
+			}
+			if hasSensitiveField(v.Field(i), depth-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RedactHandler applies the redaction rules before passing records on.
+type RedactHandler struct{ inner slog.Handler }
+
+func NewRedactHandler(inner slog.Handler) *RedactHandler { return &RedactHandler{inner: inner} }
+
+func (h *RedactHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return h.inner.Enabled(ctx, lvl)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, out)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(a)
+	}
+	return &RedactHandler{inner: h.inner.WithAttrs(out)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{inner: h.inner.WithGroup(name)}
+}
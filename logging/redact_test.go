@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type unlockParams struct {
+	KeyID      string
+	Passphrase []byte
+}
+
+func TestRedactHandlerScrubsSecrets(t *testing.T) {
+	var out bytes.Buffer
+	l := slog.New(NewRedactHandler(slog.NewTextHandler(&out, nil)))
+
+	const blsk = "BLsk1hKAHyGqY9qRbgoSVnjiSmDWpKGjFF3WNQ7BaiaMUA6RMA6bfF"
+	l.With("master_password", "hunter2").Info("imported "+blsk,
+		slog.String("key", "key1"),
+		slog.String("key_passphrase", "correct horse"),
+		slog.Int("decrypted_len", 32),
+		slog.Any("payload", []byte{0x11, 0x12, 0x13}),
+		slog.Any("req", unlockParams{KeyID: "key1", Passphrase: []byte("hunter2")}),
+		slog.Any("err", errors.New("bad key "+blsk)),
+		slog.Group("import", slog.String("secret", blsk)),
+	)
+
+	got := out.String()
+	for _, leak := range []string{"hunter2", "correct horse", blsk, "decrypted_len=32"} {
+		if strings.Contains(got, leak) {
+			t.Fatalf("%q leaked:\n%s", leak, got)
+		}
+	}
+	for _, want := range []string{"key=key1", `payload="[3 bytes]"`, "req=[logging.unlockParams]", "import.secret=[REDACTED]"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRedactKeepsStructuredValues(t *testing.T) {
+	var out bytes.Buffer
+	l := slog.New(NewRedactHandler(slog.NewJSONHandler(&out, nil)))
+	l.Info("allow", slog.Any("keys", []string{"tz4a", "tz4b"}))
+	if !strings.Contains(out.String(), `"keys":["tz4a","tz4b"]`) {
+		t.Fatalf("slice attr was flattened: %s", out.String())
+	}
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ringMaxLine caps a single stored record; longer ones are cut.
+const ringMaxLine = 4096
+
+// RingBuffer keeps the last records written to it in memory, so logs stay
+// retrievable when the log file cannot be written or read.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBuffer{lines: make([]string, size)}
+}
+
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(line) > ringMaxLine {
+			line = append(line[:ringMaxLine:ringMaxLine], "…"...)
+		}
+		r.lines[r.next] = string(line)
+		r.next++
+		if r.next == len(r.lines) {
+			r.next, r.full = 0, true
+		}
+	}
+	return len(p), nil
+}
+
+// Last returns up to n most recent lines, newest last (n <= 0 means 100, as
+// for TailLastLines).
+func (r *RingBuffer) Last(n int) []string {
+	if n <= 0 {
+		n = 100
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	if n > count {
+		n = count
+	}
+	out := make([]string, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		out = append(out, r.lines[(i+len(r.lines))%len(r.lines)])
+	}
+	return out
+}
+
+var (
+	curRing   *RingBuffer
+	curRingMu sync.RWMutex
+)
+
+// CurrentRing is the buffer set up by the last New with RingLines, or nil.
+func CurrentRing() *RingBuffer {
+	curRingMu.RLock()
+	defer curRingMu.RUnlock()
+	return curRing
+}
+
+func setCurrentRing(r *RingBuffer) {
+	curRingMu.Lock()
+	curRing = r
+	curRingMu.Unlock()
+}
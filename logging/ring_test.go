@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRingBufferKeepsNewest(t *testing.T) {
+	r := NewRingBuffer(3)
+	if got := r.Last(10); len(got) != 0 {
+		t.Fatalf("empty ring returned %q", got)
+	}
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	if got, want := r.Last(10), []string{"line 3", "line 4", "line 5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Last(10) = %q, want %q", got, want)
+	}
+	if got, want := r.Last(2), []string{"line 4", "line 5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Last(2) = %q, want %q", got, want)
+	}
+}
+
+func TestRingWorksWithoutFile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlsoStderr = false
+	cfg.RingLines = 10
+	l, _ := New(cfg)
+	l.Info("no file here")
+
+	ring := CurrentRing()
+	if ring == nil {
+		t.Fatal("no ring")
+	}
+	lines := ring.Last(0)
+	if len(lines) != 1 {
+		t.Fatalf("ring = %q", lines)
+	}
+	if want := `msg="no file here"`; !strings.Contains(lines[0], want) {
+		t.Fatalf("line %q lacks %s", lines[0], want)
+	}
+}
@@ -0,0 +1,264 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingWriter appends to FilePath and, once it reaches MaxSize bytes,
+// renames it to <name>-<timestamp><ext> and starts a new file. Backups past
+// MaxBackups or older than MaxAge are removed; with Compress they are gzipped.
+// Zero MaxBackups or MaxAge means no limit.
+type RotatingWriter struct {
+	FilePath   string
+	MaxSize    int64
+	MaxBackups int
+	MaxAge     time.Duration
+	Compress   bool
+
+	mu      sync.Mutex
+	file    *os.File
+	written int64
+
+	millOnce sync.Once
+	millCh   chan struct{}
+	millWg   sync.WaitGroup
+}
+
+func NewRotatingWriter(filePath string, maxSize int64, maxBackups int, maxAge time.Duration, compress bool) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		FilePath:   filePath,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+		Compress:   compress,
+	}
+	if err := w.openFile(); err != nil {
+		return nil, err
+	}
+	// leftovers from a previous run may be past the limits already
+	w.mill()
+	return w, nil
+}
+
+func (w *RotatingWriter) openFile() error {
+	f, err := os.OpenFile(w.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.written = 0
+	if st, err := f.Stat(); err == nil {
+		w.written = st.Size()
+	}
+	return nil
+}
+
+func (w *RotatingWriter) Write(p []byte) (n int, err error) {
+	if w == nil {
+		return 0, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.openFile(); err != nil {
+			return 0, err
+		}
+	}
+	if w.MaxSize > 0 && w.written > 0 && w.written+int64(len(p)) > w.MaxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = w.file.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Rotate forces a rotation regardless of size.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *RotatingWriter) rotate() error {
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+	if _, err := os.Stat(w.FilePath); err == nil {
+		if err := os.Rename(w.FilePath, w.backupName(time.Now())); err != nil {
+			return err
+		}
+	}
+	if err := w.openFile(); err != nil {
+		return err
+	}
+	w.mill()
+	return nil
+}
+
+func (w *RotatingWriter) backupName(t time.Time) string {
+	dir, prefix, ext := w.nameParts()
+	return filepath.Join(dir, prefix+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+func (w *RotatingWriter) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(w.FilePath)
+	base := filepath.Base(w.FilePath)
+	ext = filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// mill wakes the background goroutine that compresses and prunes backups, so
+// a rotation never waits on gzip.
+func (w *RotatingWriter) mill() {
+	w.millOnce.Do(func() {
+		ch := make(chan struct{}, 1)
+		w.millCh = ch
+		w.millWg.Add(1)
+		go func() {
+			defer w.millWg.Done()
+			for range ch {
+				_ = w.millRun()
+			}
+		}()
+	})
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+type logBackup struct {
+	path string
+	at   time.Time
+}
+
+// backups lists rotated files of FilePath, newest first.
+func (w *RotatingWriter) backups() ([]logBackup, error) {
+	dir, prefix, ext := w.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []logBackup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue
+		}
+		out = append(out, logBackup{path: filepath.Join(dir, name), at: at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].at.After(out[j].at) })
+	return out, nil
+}
+
+func (w *RotatingWriter) millRun() error {
+	list, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	var keep []logBackup
+	cutoff := time.Now().Add(-w.MaxAge)
+	for i, b := range list {
+		if (w.MaxBackups > 0 && i >= w.MaxBackups) || (w.MaxAge > 0 && b.at.Before(cutoff)) {
+			_ = os.Remove(b.path)
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if !w.Compress {
+		return nil
+	}
+	var firstErr error
+	for _, b := range keep {
+		if strings.HasSuffix(b.path, ".gz") {
+			continue
+		}
+		if err := gzipFile(b.path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// gzipFile replaces src with src.gz.
+func gzipFile(src string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := src + ".gz"
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		out.Close()
+		return fmt.Errorf("compress %s: %w", src, err)
+	}
+	if err = zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Close closes the current file and waits for pending compression.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	ch := w.millCh
+	w.millCh = nil
+	w.mu.Unlock()
+
+	if ch != nil {
+		close(ch)
+		w.millWg.Wait()
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingWriterKeepsBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gadget.log")
+
+	w, err := NewRotatingWriter(path, 64, 2, 0, true)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter: %v", err)
+	}
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write(line); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+		// backup names have millisecond resolution
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	cur, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(cur, line) {
+		t.Fatalf("current file = %q, %v; want one line", cur, err)
+	}
+	list, err := w.backups()
+	if err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d backups, want 2", len(list))
+	}
+	for _, b := range list {
+		if !strings.HasSuffix(b.path, ".log.gz") {
+			t.Fatalf("backup %s not compressed", b.path)
+		}
+		f, err := os.Open(b.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("gzip %s: %v", b.path, err)
+		}
+		got, _ := io.ReadAll(zr)
+		f.Close()
+		if !bytes.Equal(got, line) {
+			t.Fatalf("backup %s = %q", b.path, got)
+		}
+	}
+}
+
+func TestRotatingWriterAppendsAndDropsOldBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "host.log")
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(dir, "host-"+time.Now().Add(-72*time.Hour).UTC().Format(backupTimeFormat)+".log")
+	if err := os.WriteFile(stale, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewRotatingWriter(path, 1<<20, 0, 24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("NewRotatingWriter: %v", err)
+	}
+	if _, err := w.Write([]byte("this run\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := os.ReadFile(path); string(got) != "earlier run\nthis run\n" {
+		t.Fatalf("restart truncated the log: %q", got)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("backup past MaxAge survived: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogSDID names the structured-data element carrying record attributes.
+// 32473 is the enterprise number RFC 5612 sets aside for examples and
+// private use.
+const syslogSDID = "tezsign@32473"
+
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "local0": 16, "local1": 17, "local2": 18,
+	"local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseSyslogFacility accepts user, daemon, auth and local0…local7.
+func ParseSyslogFacility(s string) (int, error) {
+	if f, ok := syslogFacilities[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %q", s)
+}
+
+// SyslogHandler writes RFC 5424 messages to a syslog endpoint; attributes go
+// into one structured-data element. Over TCP messages are octet-counted
+// (RFC 6587), over UDP and unix datagram sockets one per datagram.
+type SyslogHandler struct {
+	level    slog.Leveler
+	facility int
+	hostname string
+	app      string
+	params   string // pre-rendered WithAttrs params
+	prefix   string // open groups, "group."
+
+	conn *syslogConn
+}
+
+// NewSyslogHandler connects to endpoint: udp://host[:514], tcp://host[:514]
+// or unix:///dev/log.
+func NewSyslogHandler(endpoint string, facility int, level slog.Leveler) (*SyslogHandler, error) {
+	network, addr, err := parseSyslogEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn := &syslogConn{network: network, addr: addr}
+	if err := conn.dial(); err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogHandler{
+		level:    level,
+		facility: facility,
+		hostname: syslogHeaderField(hostname, 255),
+		app:      syslogHeaderField(filepath.Base(os.Args[0]), 48),
+		conn:     conn,
+	}, nil
+}
+
+func parseSyslogEndpoint(endpoint string) (network, addr string, err error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("syslog endpoint %q: %w", endpoint, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		addr = u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "514")
+		}
+		if u.Hostname() == "" {
+			return "", "", fmt.Errorf("syslog endpoint %q: missing host", endpoint)
+		}
+		return u.Scheme, addr, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("syslog endpoint %q: missing socket path", endpoint)
+		}
+		return "unix", u.Path, nil
+	}
+	return "", "", fmt.Errorf("syslog endpoint %q: want udp://, tcp:// or unix://", endpoint)
+}
+
+func (h *SyslogHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h *SyslogHandler) Handle(_ context.Context, r slog.Record) error {
+	var params strings.Builder
+	params.WriteString(h.params)
+	r.Attrs(func(a slog.Attr) bool {
+		appendSyslogParam(&params, h.prefix, a)
+		return true
+	})
+	sd := "-"
+	if params.Len() > 0 {
+		sd = "[" + syslogSDID + params.String() + "]"
+	}
+
+	ts := r.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s",
+		h.facility*8+journalPriority(r.Level),
+		ts.Format("2006-01-02T15:04:05.000000Z07:00"),
+		h.hostname, h.app, os.Getpid(), sd, r.Message)
+	return h.conn.write([]byte(msg))
+}
+
+func (h *SyslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var params strings.Builder
+	params.WriteString(h.params)
+	for _, a := range attrs {
+		appendSyslogParam(&params, h.prefix, a)
+	}
+	out := *h
+	out.params = params.String()
+	return &out
+}
+
+func (h *SyslogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.prefix = h.prefix + name + "."
+	return &out
+}
+
+func appendSyslogParam(b *strings.Builder, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			appendSyslogParam(b, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	b.WriteByte(' ')
+	b.WriteString(syslogParamName(prefix + a.Key))
+	b.WriteString(`="`)
+	b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v.String()))
+	b.WriteByte('"')
+}
+
+// syslogParamName keeps printable ASCII other than '=', ' ', ']' and '"',
+// at most 32 characters (RFC 5424 SD-NAME).
+func syslogParamName(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s) && sb.Len() < 32; i++ {
+		c := s[i]
+		if c <= ' ' || c >= 127 || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// syslogHeaderField makes s a valid header field: printable ASCII, no
+// spaces, at most max characters, "-" if empty.
+func syslogHeaderField(s string, max int) string {
+	var sb strings.Builder
+	for i := 0; i < len(s) && sb.Len() < max; i++ {
+		if c := s[i]; c > ' ' && c < 127 {
+			sb.WriteByte(c)
+		}
+	}
+	if sb.Len() == 0 {
+		return "-"
+	}
+	return sb.String()
+}
+
+type syslogConn struct {
+	mu      sync.Mutex
+	network string // udp, tcp or unix
+	addr    string
+	conn    net.Conn
+	stream  bool
+}
+
+func (c *syslogConn) dial() error {
+	if c.network == "unix" {
+		// local daemons listen on datagram sockets; some on stream ones
+		if conn, err := net.Dial("unixgram", c.addr); err == nil {
+			c.conn, c.stream = conn, false
+			return nil
+		}
+		conn, err := net.Dial("unix", c.addr)
+		if err != nil {
+			return err
+		}
+		c.conn, c.stream = conn, true
+		return nil
+	}
+	conn, err := net.DialTimeout(c.network, c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	c.conn, c.stream = conn, c.network == "tcp"
+	return nil
+}
+
+// write sends msg, redialing once if the connection went away.
+func (c *syslogConn) write(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if err = c.dial(); err != nil {
+				continue
+			}
+		}
+		frame := msg
+		if c.stream {
+			frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		if _, err = c.conn.Write(frame); err == nil {
+			return nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
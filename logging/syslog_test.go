@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var rfc5424Re = regexp.MustCompile(`^<(\d+)>1 \S+ \S+ \S+ \d+ - (\[.*\]|-) (.*)$`)
+
+func TestSyslogHandlerUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp: %v", err)
+	}
+	defer pc.Close()
+
+	h, err := NewSyslogHandler("udp://"+pc.LocalAddr().String(), 3, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("NewSyslogHandler: %v", err)
+	}
+	slog.New(h).With("key", "key1").Warn("sign refused", slog.String("reason", `level "5" ]`))
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	m := rfc5424Re.FindStringSubmatch(string(buf[:n]))
+	if m == nil {
+		t.Fatalf("not RFC 5424: %q", buf[:n])
+	}
+	if m[1] != "28" { // daemon(3)*8 + warning(4)
+		t.Fatalf("PRI = %s", m[1])
+	}
+	if want := `[tezsign@32473 key="key1" reason="level \"5\" \]"]`; m[2] != want {
+		t.Fatalf("SD = %s, want %s", m[2], want)
+	}
+	if m[3] != "sign refused" {
+		t.Fatalf("MSG = %q", m[3])
+	}
+}
+
+func TestSyslogHandlerTCPFraming(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("tcp: %v", err)
+	}
+	defer ln.Close()
+
+	h, err := NewSyslogHandler("tcp://"+ln.Addr().String(), 1, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("NewSyslogHandler: %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	l := slog.New(h)
+	l.Info("one")
+	l.Info("two")
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"one", "two"} {
+		lenStr, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		if err != nil {
+			t.Fatalf("frame length %q", lenStr)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(msg), " - "+want) {
+			t.Fatalf("frame %q", msg)
+		}
+	}
+}
+
+func TestParseSyslogEndpoint(t *testing.T) {
+	for in, want := range map[string]string{
+		"udp://logs.example":      "udp logs.example:514",
+		"tcp://10.0.0.5:6514":     "tcp 10.0.0.5:6514",
+		"unix:///dev/log":         "unix /dev/log",
+		"http://logs.example:514": "",
+		"udp://:514":              "",
+	} {
+		network, addr, err := parseSyslogEndpoint(in)
+		got := network + " " + addr
+		if err != nil {
+			got = ""
+		}
+		if got != want {
+			t.Errorf("parseSyslogEndpoint(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package minisign signs and checks files with the release key, in the
+// format of the minisign tool. It stands apart from tools/release so the
+// gadget can check pushed binaries without the disk image code.
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signatures use the minisign format, so a download can be checked with the
+// stock tool as well as with the updater:
+//
+//	minisign -Vm rpi4.img.xz -P <public key>
+//
+// Files are prehashed with BLAKE2b-512 (algorithm "ED"), which is what
+// minisign itself writes and accepts without extra flags.
+
+const (
+	// SignatureExt is appended to the name of the signed file.
+	SignatureExt = ".minisig"
+
+	keyAlg    = "Ed"
+	hashedAlg = "ED"
+
+	untrustedPrefix = "untrusted comment: "
+	trustedPrefix   = "trusted comment: "
+)
+
+var (
+	ErrBadSignature   = errors.New("bad signature")
+	ErrKeyMismatch    = errors.New("signed with a different key")
+	ErrMalformedKey   = errors.New("malformed public key")
+	ErrMalformedSig   = errors.New("malformed signature file")
+	ErrUnsupportedSig = errors.New("unsupported signature algorithm")
+)
+
+// PublicKey is a minisign public key: an ed25519 key and its 8-byte ID.
+type PublicKey struct {
+	ID  [8]byte
+	Key ed25519.PublicKey
+}
+
+// KeyID derives the minisign key ID from an ed25519 public key. minisign
+// picks IDs at random; deriving it keeps a key loaded from a bare seed stable.
+func KeyID(pub ed25519.PublicKey) [8]byte {
+	sum := blake2b.Sum256(pub)
+	var id [8]byte
+	copy(id[:], sum[:8])
+	return id
+}
+
+func NewPublicKey(key ed25519.PrivateKey) PublicKey {
+	pub := key.Public().(ed25519.PublicKey)
+	return PublicKey{ID: KeyID(pub), Key: pub}
+}
+
+// IDString is the key ID as minisign prints it.
+func (k PublicKey) IDString() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.ID[:]))
+}
+
+// String is the base64 form passed to minisign -P.
+func (k PublicKey) String() string {
+	raw := make([]byte, 0, 2+8+ed25519.PublicKeySize)
+	raw = append(raw, keyAlg...)
+	raw = append(raw, k.ID[:]...)
+	raw = append(raw, k.Key...)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// File is the content of a minisign .pub file.
+func (k PublicKey) File() []byte {
+	return []byte(untrustedPrefix + "minisign public key " + k.IDString() + "\n" + k.String() + "\n")
+}
+
+// ParsePublicKey accepts the base64 key or a whole .pub file.
+func ParsePublicKey(s string) (PublicKey, error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, untrustedPrefix) {
+			line = l
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(line)
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != keyAlg {
+		return PublicKey{}, ErrMalformedKey
+	}
+	var k PublicKey
+	copy(k.ID[:], raw[2:10])
+	k.Key = ed25519.PublicKey(bytes.Clone(raw[10:]))
+	return k, nil
+}
+
+// LoadKey reads a hex-encoded ed25519 seed, the same format LOG_CHAIN_KEY_FILE uses.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: want %d hex-encoded bytes", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func prehash(r io.Reader) ([]byte, error) {
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Sign returns a minisign signature file over the contents of r. The trusted
+// comment is covered by the signature; it must be a single line.
+func Sign(key ed25519.PrivateKey, r io.Reader, trustedComment string) ([]byte, error) {
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		return nil, errors.New("trusted comment must be a single line")
+	}
+	digest, err := prehash(r)
+	if err != nil {
+		return nil, err
+	}
+	pub := NewPublicKey(key)
+	sig := ed25519.Sign(key, digest)
+
+	blob := make([]byte, 0, 2+8+ed25519.SignatureSize)
+	blob = append(blob, hashedAlg...)
+	blob = append(blob, pub.ID[:]...)
+	blob = append(blob, sig...)
+	global := ed25519.Sign(key, append(bytes.Clone(sig), trustedComment...))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%ssignature from tezsign key %s\n", untrustedPrefix, pub.IDString())
+	fmt.Fprintf(&out, "%s\n", base64.StdEncoding.EncodeToString(blob))
+	fmt.Fprintf(&out, "%s%s\n", trustedPrefix, trustedComment)
+	fmt.Fprintf(&out, "%s\n", base64.StdEncoding.EncodeToString(global))
+	return out.Bytes(), nil
+}
+
+// Verify checks a minisign signature file over the contents of r and
+// returns its trusted comment.
+func Verify(pub PublicKey, r io.Reader, sigFile []byte) (string, error) {
+	lines := strings.Split(strings.TrimRight(string(sigFile), "\r\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedPrefix) || !strings.HasPrefix(lines[2], trustedPrefix) {
+		return "", ErrMalformedSig
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(blob) != 2+8+ed25519.SignatureSize {
+		return "", ErrMalformedSig
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return "", ErrMalformedSig
+	}
+	if string(blob[:2]) != hashedAlg {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedSig, blob[:2])
+	}
+	if !bytes.Equal(blob[2:10], pub.ID[:]) {
+		return "", ErrKeyMismatch
+	}
+
+	sig := blob[10:]
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], trustedPrefix), "\r")
+	if !ed25519.Verify(pub.Key, append(bytes.Clone(sig), comment...), global) {
+		return "", fmt.Errorf("%w: trusted comment", ErrBadSignature)
+	}
+
+	digest, err := prehash(r)
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(pub.Key, digest, sig) {
+		return "", ErrBadSignature
+	}
+	return comment, nil
+}
+
+// CommentField returns the name: field of a trusted comment the builder
+// wrote, tab-separated fields such as timestamp:…\tfile:…\thashed, or ""
+// when it has none.
+func CommentField(comment, name string) string {
+	for _, field := range strings.Split(comment, "\t") {
+		if value, ok := strings.CutPrefix(field, name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// SignFile writes path+SignatureExt.
+func SignFile(key ed25519.PrivateKey, path, trustedComment string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sig, err := Sign(key, f, trustedComment)
+	if err != nil {
+		return fmt.Errorf("sign %s: %w", path, err)
+	}
+	return os.WriteFile(path+SignatureExt, sig, 0o644)
+}
+
+// VerifyFile checks path against path+SignatureExt.
+func VerifyFile(pub PublicKey, path string) (string, error) {
+	sig, err := os.ReadFile(path + SignatureExt)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Verify(pub, f, sig)
+}
@@ -0,0 +1,85 @@
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	key := testKey()
+	data := []byte("tezsign image bytes")
+
+	sig, err := Sign(key, bytes.NewReader(data), "timestamp:1\tfile:rpi4.img.xz\thashed")
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	comment, err := Verify(NewPublicKey(key), bytes.NewReader(data), sig)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if comment != "timestamp:1\tfile:rpi4.img.xz\thashed" {
+		t.Fatalf("trusted comment = %q", comment)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	key := testKey()
+	pub := NewPublicKey(key)
+	data := []byte("tezsign image bytes")
+	sig, err := Sign(key, bytes.NewReader(data), "file:rpi4.img.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(pub, bytes.NewReader([]byte("tezsign image byteS")), sig); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify with changed data err = %v, want ErrBadSignature", err)
+	}
+
+	edited := bytes.Replace(sig, []byte("file:rpi4.img.xz"), []byte("file:rpi5.img.xz"), 1)
+	if _, err := Verify(pub, bytes.NewReader(data), edited); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify with changed trusted comment err = %v, want ErrBadSignature", err)
+	}
+
+	other := NewPublicKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{8}, ed25519.SeedSize)))
+	if _, err := Verify(other, bytes.NewReader(data), sig); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("Verify with another key err = %v, want ErrKeyMismatch", err)
+	}
+
+	if _, err := Verify(pub, bytes.NewReader(data), []byte("garbage\n")); !errors.Is(err, ErrMalformedSig) {
+		t.Fatalf("Verify of garbage err = %v, want ErrMalformedSig", err)
+	}
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	pub := NewPublicKey(testKey())
+	if !strings.HasPrefix(pub.String(), "RW") {
+		t.Fatalf("public key %q does not look like a minisign key", pub.String())
+	}
+
+	for _, in := range []string{pub.String(), string(pub.File())} {
+		got, err := ParsePublicKey(in)
+		if err != nil {
+			t.Fatalf("ParsePublicKey(%q): %v", in, err)
+		}
+		if got.ID != pub.ID || !got.Key.Equal(pub.Key) {
+			t.Fatalf("ParsePublicKey round trip = %+v, want %+v", got, pub)
+		}
+	}
+
+	if _, err := ParsePublicKey("RWQ"); !errors.Is(err, ErrMalformedKey) {
+		t.Fatalf("ParsePublicKey short key err = %v, want ErrMalformedKey", err)
+	}
+}
+
+func TestSignRejectsMultilineComment(t *testing.T) {
+	if _, err := Sign(testKey(), bytes.NewReader(nil), "a\nb"); err == nil {
+		t.Fatal("Sign accepted a trusted comment with a newline")
+	}
+}
@@ -0,0 +1,182 @@
+// Package provision is the per-device settings file an operator drops on the
+// boot partition of a flashed card. On the next boot the provision helper
+// validates it, moves it to the data partition and deletes it from the boot
+// partition; every later boot applies the stored copy. The builder writes
+// the same file into images and cards.
+package provision
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tez-capital/tezsign/logging"
+)
+
+const (
+	// FileName sits in the root of the boot partition.
+	FileName = "provision.toml"
+	// RejectedName is what the helper renames a file that fails validation
+	// to, so it can be read back on a PC.
+	RejectedName = FileName + ".rejected"
+
+	// Version is the only schema version this code reads.
+	Version = 1
+
+	// DefaultProduct is the USB product string without a label.
+	DefaultProduct = "tezsign-gadget"
+)
+
+var ErrInvalid = errors.New("invalid provision.toml")
+
+var (
+	hostnameLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	deviceLabel   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,31}$`)
+	serialPattern = regexp.MustCompile(`^[A-Z0-9]{12,32}$`)
+)
+
+// Config is provision.toml. Every field is optional; an empty one keeps the
+// image's default.
+type Config struct {
+	Version int `toml:"version"`
+	// Hostname is set on every boot.
+	Hostname string `toml:"hostname,omitempty"`
+	// Label is appended to the USB product string, so `tezsign list` tells
+	// the devices on one host apart.
+	Label    string   `toml:"label,omitempty"`
+	Gadget   Gadget   `toml:"gadget,omitempty"`
+	Features Features `toml:"features,omitempty"`
+}
+
+type Gadget struct {
+	// Serial replaces the generated USB serial (/app/tezsign_id).
+	Serial string `toml:"serial,omitempty"`
+	// LogLevel is the gadget's LOG_LEVEL.
+	LogLevel string `toml:"log_level,omitempty"`
+	// AuthorizedHosts are the hex host public keys written to broker_hosts;
+	// only these hosts may connect.
+	AuthorizedHosts []string `toml:"authorized_hosts,omitempty"`
+}
+
+// Features are switches the gadget reads at start. An operator cannot change
+// them over USB; only a new provision.toml does.
+type Features struct {
+	// RequireEncryption refuses hosts without the encrypted channel
+	// (BROKER_REQUIRE_ENCRYPTION).
+	RequireEncryption *bool `toml:"require_encryption,omitempty"`
+	// LogChain chains the log file lines (LOG_CHAIN).
+	LogChain *bool `toml:"log_chain,omitempty"`
+}
+
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	md, err := toml.Decode(string(data), &c)
+	if err != nil {
+		return nil, errors.Join(ErrInvalid, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return nil, fmt.Errorf("%w: unknown keys %s", ErrInvalid, strings.Join(keys, ", "))
+	}
+	c.Gadget.Serial = strings.ToUpper(c.Gadget.Serial)
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Version != Version {
+		return fmt.Errorf("%w: version %d, want %d", ErrInvalid, c.Version, Version)
+	}
+	if c.Hostname != "" {
+		if len(c.Hostname) > 253 {
+			return fmt.Errorf("%w: hostname is longer than 253 characters", ErrInvalid)
+		}
+		for _, label := range strings.Split(c.Hostname, ".") {
+			if !hostnameLabel.MatchString(label) {
+				return fmt.Errorf("%w: hostname %q", ErrInvalid, c.Hostname)
+			}
+		}
+	}
+	if c.Label != "" && !deviceLabel.MatchString(c.Label) {
+		return fmt.Errorf("%w: label %q (letters, digits, space, '.', '_' and '-', up to 32)", ErrInvalid, c.Label)
+	}
+	if c.Gadget.Serial != "" && !serialPattern.MatchString(c.Gadget.Serial) {
+		return fmt.Errorf("%w: serial %q (12 to 32 letters and digits)", ErrInvalid, c.Gadget.Serial)
+	}
+	if c.Gadget.LogLevel != "" {
+		if _, err := logging.ParseLevel(c.Gadget.LogLevel); err != nil {
+			return fmt.Errorf("%w: log_level: %v", ErrInvalid, err)
+		}
+	}
+	for _, h := range c.Gadget.AuthorizedHosts {
+		if k, err := hex.DecodeString(h); err != nil || len(k) != 32 {
+			return fmt.Errorf("%w: authorized host %q is not a hex public key", ErrInvalid, h)
+		}
+	}
+	return nil
+}
+
+// Marshal writes c as TOML. Comments of the file it was parsed from are
+// not kept.
+func (c *Config) Marshal() ([]byte, error) {
+	var b strings.Builder
+	enc := toml.NewEncoder(&b)
+	enc.Indent = ""
+	if err := enc.Encode(c); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// Product is the USB product string for the device.
+func (c *Config) Product() string {
+	if c.Label == "" {
+		return DefaultProduct
+	}
+	return DefaultProduct + " " + c.Label
+}
+
+// Env is the environment file setup-gadget.service and tezsign.service
+// read. Only set fields are written, so the units keep their defaults.
+func (c *Config) Env() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GADGET_PRODUCT=%s\n", c.Product())
+	if c.Gadget.LogLevel != "" {
+		fmt.Fprintf(&b, "LOG_LEVEL=%s\n", strings.ToLower(c.Gadget.LogLevel))
+	}
+	flag := func(name string, v *bool) {
+		if v == nil {
+			return
+		}
+		value := "0"
+		if *v {
+			value = "1"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, value)
+	}
+	flag("BROKER_REQUIRE_ENCRYPTION", c.Features.RequireEncryption)
+	flag("LOG_CHAIN", c.Features.LogChain)
+	return []byte(b.String())
+}
+
+// HostsFile is the broker_hosts content for AuthorizedHosts, or nil if the
+// file should be left alone.
+func (c *Config) HostsFile() []byte {
+	if len(c.Gadget.AuthorizedHosts) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("# written by provision.toml\n")
+	for _, h := range c.Gadget.AuthorizedHosts {
+		b.WriteString(strings.ToLower(h) + "\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,86 @@
+package provision
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAndEnv(t *testing.T) {
+	host := strings.Repeat("ab", 32)
+	c, err := Parse([]byte(`version = 1
+hostname = "signer-07.fleet"
+label = "baker A"
+
+[gadget]
+serial = "abcdef123456"
+log_level = "Info"
+authorized_hosts = ["` + strings.ToUpper(host) + `"]
+
+[features]
+require_encryption = true
+log_chain = false
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if c.Gadget.Serial != "ABCDEF123456" {
+		t.Fatalf("serial = %q", c.Gadget.Serial)
+	}
+	want := "GADGET_PRODUCT=tezsign-gadget baker A\nLOG_LEVEL=info\nBROKER_REQUIRE_ENCRYPTION=1\nLOG_CHAIN=0\n"
+	if got := string(c.Env()); got != want {
+		t.Fatalf("Env:\n%s\nwant:\n%s", got, want)
+	}
+	if got := string(c.HostsFile()); !strings.HasSuffix(got, "\n"+host+"\n") {
+		t.Fatalf("HostsFile = %q", got)
+	}
+}
+
+func TestParseEmptyKeepsDefaults(t *testing.T) {
+	c, err := Parse([]byte("version = 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(c.Env()); got != "GADGET_PRODUCT="+DefaultProduct+"\n" {
+		t.Fatalf("Env = %q", got)
+	}
+	if c.HostsFile() != nil {
+		t.Fatal("no authorized hosts should leave broker_hosts alone")
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"version":     "version = 2\n",
+		"unknown key": "version = 1\nwifi = \"x\"\n",
+		"hostname":    "version = 1\nhostname = \"-bad\"\n",
+		"label":       "version = 1\nlabel = \"a\\nLOG_LEVEL=debug\"\n",
+		"serial":      "version = 1\n[gadget]\nserial = \"short\"\n",
+		"log level":   "version = 1\n[gadget]\nlog_level = \"loud\"\n",
+		"host key":    "version = 1\n[gadget]\nauthorized_hosts = [\"abcd\"]\n",
+		"syntax":      "version = \n",
+	}
+	for name, in := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(in)); !errors.Is(err, ErrInvalid) {
+				t.Fatalf("err = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	on := true
+	c := &Config{Version: Version, Hostname: "signer-01", Features: Features{RequireEncryption: &on}}
+	data, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", data, err)
+	}
+	if got.Hostname != c.Hostname || got.Features.RequireEncryption == nil || !*got.Features.RequireEncryption || got.Features.LogChain != nil {
+		t.Fatalf("round trip = %+v from %q", got, data)
+	}
+}
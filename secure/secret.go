@@ -0,0 +1,156 @@
+package secure
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const canarySize = 16
+
+var (
+	ErrSecretClosed   = errors.New("secret buffer closed")
+	ErrCanaryMismatch = errors.New("secret buffer canary corrupted")
+)
+
+// process-wide canary; a stray write over either end of a buffer changes it
+var canary = func() [canarySize]byte {
+	var c [canarySize]byte
+	_, _ = io.ReadFull(rand.Reader, c[:])
+	return c
+}()
+
+// SecretBuffer holds key material outside the Go heap where the platform
+// allows it: the memory is mlocked (never swapped), framed by canaries and
+// wiped on Close. A cleanup wipes it too if Close is missed, but do not rely
+// on that - the GC may run much later, or never.
+type SecretBuffer struct {
+	mu     sync.Mutex
+	region *secretRegion
+	data   []byte
+	close  runtime.Cleanup
+}
+
+// secretRegion is the backing memory shared with the GC cleanup; it must not
+// point back to the SecretBuffer or the cleanup would never run.
+type secretRegion struct {
+	mem    []byte // canary | data | canary
+	locked bool
+}
+
+func (r *secretRegion) release() {
+	if r.mem == nil {
+		return
+	}
+	MemoryWipe(r.mem)
+	freeSecretMemory(r.mem, r.locked)
+	r.mem = nil
+}
+
+// NewSecretBuffer allocates a zeroed buffer of n bytes.
+func NewSecretBuffer(n int) (*SecretBuffer, error) {
+	if n <= 0 {
+		return nil, errors.New("secret buffer size must be positive")
+	}
+	mem, locked, err := allocSecretMemory(n + 2*canarySize)
+	if err != nil {
+		return nil, err
+	}
+	copy(mem, canary[:])
+	copy(mem[canarySize+n:], canary[:])
+
+	region := &secretRegion{mem: mem, locked: locked}
+	s := &SecretBuffer{region: region, data: mem[canarySize : canarySize+n : canarySize+n]}
+	s.close = runtime.AddCleanup(s, (*secretRegion).release, region)
+	return s, nil
+}
+
+// SecretBufferFrom moves b into a new SecretBuffer and wipes b.
+func SecretBufferFrom(b []byte) (*SecretBuffer, error) {
+	defer MemoryWipe(b)
+	s, err := NewSecretBuffer(len(b))
+	if err != nil {
+		return nil, err
+	}
+	copy(s.data, b)
+	return s, nil
+}
+
+// Bytes returns the protected memory (nil once closed). The slice aliases
+// the buffer: do not retain it past Close or append to it.
+func (s *SecretBuffer) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data
+}
+
+func (s *SecretBuffer) Len() int {
+	return len(s.Bytes())
+}
+
+// Locked reports whether the memory is mlocked (false where unsupported or
+// when RLIMIT_MEMLOCK is exhausted).
+func (s *SecretBuffer) Locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.region != nil && s.region.locked
+}
+
+// Clone copies the buffer into a new SecretBuffer.
+func (s *SecretBuffer) Clone() (*SecretBuffer, error) {
+	data := s.Bytes()
+	if data == nil {
+		return nil, ErrSecretClosed
+	}
+	c, err := NewSecretBuffer(len(data))
+	if err != nil {
+		return nil, err
+	}
+	copy(c.data, data)
+	return c, nil
+}
+
+// Check verifies both canaries.
+func (s *SecretBuffer) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkLocked()
+}
+
+func (s *SecretBuffer) checkLocked() error {
+	if s.region == nil {
+		return ErrSecretClosed
+	}
+	mem, n := s.region.mem, len(s.data)
+	if subtle.ConstantTimeCompare(mem[:canarySize], canary[:]) != 1 ||
+		subtle.ConstantTimeCompare(mem[canarySize+n:], canary[:]) != 1 {
+		return ErrCanaryMismatch
+	}
+	return nil
+}
+
+// Close wipes and releases the buffer. It reports a canary mismatch found
+// while closing; the memory is released either way. Closing twice (or a nil
+// buffer) is a no-op.
+func (s *SecretBuffer) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.region == nil {
+		return nil
+	}
+	err := s.checkLocked()
+	s.close.Stop()
+	s.region.release()
+	s.region, s.data = nil, nil
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package secure
+
+// Without mmap/mlock the buffer lives on the heap; the canaries and wiping
+// still apply.
+func allocSecretMemory(n int) ([]byte, bool, error) {
+	return make([]byte, n), false, nil
+}
+
+func freeSecretMemory([]byte, bool) {}
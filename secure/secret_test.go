@@ -0,0 +1,48 @@
+package secure
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecretBufferWipesAndDetectsOverflow(t *testing.T) {
+	src := []byte("0123456789abcdef0123456789abcdef")
+	buf, err := SecretBufferFrom(src)
+	if err != nil {
+		t.Fatalf("SecretBufferFrom: %v", err)
+	}
+	for _, c := range src {
+		if c != 0 {
+			t.Fatalf("source not wiped")
+		}
+	}
+	if got := string(buf.Bytes()); got != "0123456789abcdef0123456789abcdef" {
+		t.Fatalf("unexpected contents %q", got)
+	}
+	if err := buf.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	clone, err := buf.Clone()
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	// simulate a stray write just past the data
+	clone.region.mem[canarySize+clone.Len()] ^= 0xff
+	if err := clone.Check(); !errors.Is(err, ErrCanaryMismatch) {
+		t.Fatalf("expected ErrCanaryMismatch, got %v", err)
+	}
+	if err := clone.Close(); !errors.Is(err, ErrCanaryMismatch) {
+		t.Fatalf("expected Close to report the canary, got %v", err)
+	}
+
+	if err := buf.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if buf.Bytes() != nil || buf.Close() != nil {
+		t.Fatalf("closed buffer still readable or double close failed")
+	}
+	if _, err := buf.Clone(); !errors.Is(err, ErrSecretClosed) {
+		t.Fatalf("expected ErrSecretClosed, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package secure
+
+import "golang.org/x/sys/unix"
+
+// allocSecretMemory maps anonymous pages and tries to mlock them. A failed
+// mlock (e.g. RLIMIT_MEMLOCK) still returns usable, unlocked memory.
+func allocSecretMemory(n int) ([]byte, bool, error) {
+	mem, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, false, err
+	}
+	return mem, unix.Mlock(mem) == nil, nil
+}
+
+func freeSecretMemory(mem []byte, locked bool) {
+	if locked {
+		_ = unix.Munlock(mem)
+	}
+	_ = unix.Munmap(mem)
+}
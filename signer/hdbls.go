@@ -7,6 +7,8 @@ import (
 	"errors"
 	"hash"
 	"math/big"
+	"strconv"
+	"strings"
 
 	blst "github.com/supranational/blst/bindings/go"
 )
@@ -28,8 +30,11 @@ var (
 	errNilParent               = errors.New("parent is nil")
 	errParentScalarSizeInvalid = errors.New("unexpected parent scalar size")
 	errNilMaster               = errors.New("master is nil")
+	errPathInvalid             = errors.New("invalid derivation path: want m/<n>/<n>/...")
 )
 
+const maxPathDepth = 16
+
 // ----- Parameters & helpers -----
 
 // hdParams defines the scalar field order r and the HKDF salt used by HKDF_mod_r.
@@ -170,14 +175,27 @@ func derivePathSK(master *blst.SecretKey, path []uint32, params hdParams) (*blst
 
 // ----- Public API -----
 
+// TezSignPath is the path used for the store's internal index counter.
+func TezSignPath(index uint32) []uint32 {
+	return []uint32{12381, 1729, 0, 0, index}
+}
+
 // GenerateRandomKey -> (secretKey, pubkeyBytes[48], BLpubkey = BLpk...)
 func GenerateHDKey(masterSalt []byte, seed []byte, index uint32) (*blst.SecretKey, []byte, string, error) {
+	return GenerateHDKeyAtPath(masterSalt, seed, TezSignPath(index))
+}
+
+// GenerateHDKeyAtPath derives the key at an explicit EIP-2333 path. Note the
+// HKDF salt is still the store-bound TezSignHDParams salt.
+func GenerateHDKeyAtPath(masterSalt []byte, seed []byte, path []uint32) (*blst.SecretKey, []byte, string, error) {
+	if len(path) == 0 || len(path) > maxPathDepth {
+		return nil, nil, "", errPathInvalid
+	}
 	params := TezSignHDParams(masterSalt)
 	masterSK, err := deriveMasterSK(seed, params)
 	if err != nil {
 		return nil, nil, "", err
 	}
-	path := []uint32{12381, 1729, 0, 0, index}
 	childSK, err := derivePathSK(masterSK, path, params)
 	if err != nil {
 		return nil, nil, "", err
@@ -186,3 +204,32 @@ func GenerateHDKey(masterSalt []byte, seed []byte, index uint32) (*blst.SecretKe
 
 	return childSK, pubkeyBytes, blPubkey, nil
 }
+
+// ParseDerivationPath parses "m/12381/1729/0/0/5". Every EIP-2333 step is
+// hardened, so a trailing ' (as written by some tools) is accepted and ignored.
+func ParseDerivationPath(s string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || parts[0] != "m" || len(parts)-1 > maxPathDepth {
+		return nil, errPathInvalid
+	}
+	path := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		n, err := strconv.ParseUint(strings.TrimSuffix(part, "'"), 10, 32)
+		if err != nil {
+			return nil, errPathInvalid
+		}
+		path = append(path, uint32(n))
+	}
+	return path, nil
+}
+
+// FormatDerivationPath is the inverse of ParseDerivationPath.
+func FormatDerivationPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, i := range path {
+		b.WriteString("/")
+		b.WriteString(strconv.FormatUint(uint64(i), 10))
+	}
+	return b.String()
+}
@@ -1,6 +1,7 @@
 package signer
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
@@ -17,6 +18,7 @@ var (
 	pfxBLSignature = []byte{40, 171, 64, 207} // "BLsig" BLS12-381 signature (96 bytes)
 	pfxTz4         = []byte{6, 161, 166}      // "tz4"  BLS12-381 public key hash (20 bytes)
 	pfxBLSecretKey = []byte{3, 150, 192, 40}  // "BLsk" BLS12-381 secret key (32 bytes, LE)
+	pfxChainID     = []byte{87, 82, 0}        // "Net"  chain id (4 bytes)
 )
 
 var (
@@ -27,6 +29,11 @@ var (
 	errBadBLskPrefix                = errors.New("bad BLsk prefix")
 	errBLSecretKeyPayloadNot32Bytes = errors.New("BLSecretKey payload must be 32 bytes")
 	errScalarInvalid                = errors.New("invalid scalar")
+	errBadChainID                   = errors.New("bad chain id: want Net… (Base58Check, 4 bytes)")
+	errBadBLPubkey                  = errors.New("bad BLpk public key encoding")
+	errBadBLSignature               = errors.New("bad BLsig signature encoding")
+	errEmptyAggregate               = errors.New("nothing to aggregate")
+	errBadPubkeyEncoding            = errors.New("bad public key encoding")
 )
 
 // ---- Domain Separation ----
@@ -56,6 +63,12 @@ func GenerateRandomKey() (*blst.SecretKey, []byte, string) {
 	return secretKey, pubkeyBytes, blPubkey
 }
 
+// KeyFromIKM derives a key from at least 32 bytes of input keying material,
+// as GenerateRandomKey does from random bytes.
+func KeyFromIKM(ikm []byte) *blst.SecretKey {
+	return blst.KeyGen(ikm)
+}
+
 // SignCompressed -> (sigBytes[96], BLsig...)
 //
 //go:inline
@@ -102,7 +115,22 @@ func VerifyPoPCompressed(pubkeyBytes, popSigBytes []byte) bool {
 	return sig.Verify(true, &pk, true, pubkeyBytes, dstTezPop)
 }
 
+// VerifyPoP checks a BLsig… proof of possession against a BLpk… key, as
+// shown by status.
+func VerifyPoP(blPubkey, pop string) bool {
+	pubkeyBytes, err := DecodeBLPubkey(blPubkey)
+	if err != nil {
+		return false
+	}
+	popSigBytes, err := DecodeBLSignature(pop)
+	if err != nil {
+		return false
+	}
+	return VerifyPoPCompressed(pubkeyBytes, popSigBytes)
+}
+
 // FastAggregateVerifyCompressed checks many pubkeys signing the same msg.
+// A key whose PoP was not checked can cancel out the others (rogue key).
 func FastAggregateVerifyCompressed(pubkeyList [][]byte, aggSigBytes, msg []byte) bool {
 	pubkeys := make([]*PublicKey, 0, len(pubkeyList))
 	for _, b := range pubkeyList {
@@ -120,8 +148,12 @@ func FastAggregateVerifyCompressed(pubkeyList [][]byte, aggSigBytes, msg []byte)
 	return sig.FastAggregateVerify(true, pubkeys, msg, dstMinPk)
 }
 
-// AggregateCompressed aggregates multiple signatures (same msg).
+// AggregateCompressed aggregates multiple signatures (same msg, or one msg
+// per signer for AggregateVerifyCompressed).
 func AggregateCompressed(sigList [][]byte) ([]byte, error) {
+	if len(sigList) == 0 {
+		return nil, errEmptyAggregate
+	}
 	agg := new(AggregateSignature)
 
 	tmp := make([]*Signature, 0, len(sigList))
@@ -133,10 +165,76 @@ func AggregateCompressed(sigList [][]byte) ([]byte, error) {
 		sigCopy := sig
 		tmp = append(tmp, &sigCopy)
 	}
-	agg.Aggregate(tmp, true)
+	if !agg.Aggregate(tmp, true) {
+		return nil, errBadSigEncoding
+	}
+	return agg.ToAffine().Compress(), nil
+}
+
+// AggregatePublicKeysCompressed sums 48-byte G1 pubkeys into the one key
+// that verifies their same-msg aggregate signature.
+func AggregatePublicKeysCompressed(pubkeyList [][]byte) ([]byte, error) {
+	if len(pubkeyList) == 0 {
+		return nil, errEmptyAggregate
+	}
+	pubkeys := make([]*PublicKey, 0, len(pubkeyList))
+	for _, b := range pubkeyList {
+		var pubkey PublicKey
+		if pubkey.Uncompress(b) == nil {
+			return nil, errBadPubkeyEncoding
+		}
+		pubkeyCopy := pubkey
+		pubkeys = append(pubkeys, &pubkeyCopy)
+	}
+	agg := new(AggregatePublicKey)
+	if !agg.Aggregate(pubkeys, true) {
+		return nil, errBadPubkeyEncoding
+	}
 	return agg.ToAffine().Compress(), nil
 }
 
+// VerifyPoPsCompressed checks popList[i] over pubkeyList[i] for every key.
+func VerifyPoPsCompressed(pubkeyList, popList [][]byte) bool {
+	if len(pubkeyList) != len(popList) {
+		return false
+	}
+	for i := range pubkeyList {
+		if !VerifyPoPCompressed(pubkeyList[i], popList[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AggregateVerifyCompressed checks an aggregate of pubkeyList[i] signing
+// msgList[i], each key with its proof of possession popList[i]. Messages
+// need not be distinct: the PoPs rule out rogue keys, which is why they are
+// not optional.
+func AggregateVerifyCompressed(pubkeyList, popList [][]byte, aggSigBytes []byte, msgList [][]byte) bool {
+	if len(pubkeyList) == 0 || len(pubkeyList) != len(msgList) {
+		return false
+	}
+	if !VerifyPoPsCompressed(pubkeyList, popList) {
+		return false
+	}
+	pubkeys := make([]*PublicKey, 0, len(pubkeyList))
+	for _, b := range pubkeyList {
+		var pubkey PublicKey
+		if pubkey.Uncompress(b) == nil {
+			return false
+		}
+		pubkeyCopy := pubkey
+		pubkeys = append(pubkeys, &pubkeyCopy)
+	}
+	var sig Signature
+	if sig.Uncompress(aggSigBytes) == nil {
+		return false
+	}
+	msgs := make([]blst.Message, len(msgList))
+	copy(msgs, msgList)
+	return sig.AggregateVerify(true, pubkeys, true, msgs, dstMinPk)
+}
+
 // Tz4FromBLPubkeyBytes computes the tz4 address from a 48-byte G1 compressed key.
 func Tz4FromBLPubkeyBytes(pubkeyBytes []byte) (string, error) {
 	if len(pubkeyBytes) != blst.BLST_P1_COMPRESS_BYTES { // 48
@@ -171,6 +269,64 @@ func b58CheckEncode(prefix, payload []byte) string {
 	return base58.Encode(buf)
 }
 
+// EncodeChainID turns the 4 chain id bytes of a signing payload into Net….
+func EncodeChainID(chainID []byte) (string, error) {
+	if len(chainID) != 4 {
+		return "", errBadChainID
+	}
+	return b58CheckEncode(pfxChainID, chainID), nil
+}
+
+// DecodeChainID parses Net… into the 4 bytes found in signing payloads.
+func DecodeChainID(s string) ([]byte, error) {
+	b, ok := b58CheckDecode(pfxChainID, s, 4)
+	if !ok {
+		return nil, errBadChainID
+	}
+	return b, nil
+}
+
+// DecodeBLPubkey parses BLpk… into the 48-byte compressed key.
+func DecodeBLPubkey(s string) ([]byte, error) {
+	b, ok := b58CheckDecode(pfxBLPubkey, s, blst.BLST_P1_COMPRESS_BYTES)
+	if !ok {
+		return nil, errBadBLPubkey
+	}
+	return b, nil
+}
+
+// EncodeBLSignature encodes a 96-byte G2 compressed signature as BLsig….
+func EncodeBLSignature(sigBytes []byte) (string, error) {
+	if len(sigBytes) != blst.BLST_P2_COMPRESS_BYTES {
+		return "", errSigNot96Bytes
+	}
+	return b58CheckEncode(pfxBLSignature, sigBytes), nil
+}
+
+// DecodeBLSignature parses BLsig… into the 96-byte compressed signature.
+func DecodeBLSignature(s string) ([]byte, error) {
+	b, ok := b58CheckDecode(pfxBLSignature, s, blst.BLST_P2_COMPRESS_BYTES)
+	if !ok {
+		return nil, errBadBLSignature
+	}
+	return b, nil
+}
+
+// b58CheckDecode undoes b58CheckEncode, checking prefix, length and checksum.
+func b58CheckDecode(prefix []byte, s string, payloadLen int) ([]byte, bool) {
+	raw, err := base58.Decode(s)
+	if err != nil || len(raw) != len(prefix)+payloadLen+4 {
+		return nil, false
+	}
+	n := len(raw) - 4
+	sum1 := sha256.Sum256(raw[:n])
+	sum2 := sha256.Sum256(sum1[:])
+	if !bytes.Equal(raw[n:], sum2[:4]) || !bytes.Equal(raw[:len(prefix)], prefix) {
+		return nil, false
+	}
+	return raw[len(prefix):n], true
+}
+
 // Export our SecretKey as BLsk (LE payload)
 func EncodeBLSecretKey(secretKey *blst.SecretKey) string {
 	le := secretKey.ToLEndian() // 32 bytes little-endian scalar
@@ -0,0 +1,140 @@
+package signer
+
+import (
+	"testing"
+
+	blst "github.com/supranational/blst/bindings/go"
+)
+
+type testKey struct {
+	sk  *SecretKey
+	pk  []byte
+	pop []byte
+}
+
+func newTestKey(t *testing.T) testKey {
+	t.Helper()
+	sk, pk, _ := GenerateRandomKey()
+	pop, _, err := SignPoPCompressed(sk, pk)
+	if err != nil {
+		t.Fatalf("SignPoPCompressed: %v", err)
+	}
+	return testKey{sk: sk, pk: pk, pop: pop}
+}
+
+func mustAggregate(t *testing.T, sigs ...[]byte) []byte {
+	t.Helper()
+	agg, err := AggregateCompressed(sigs)
+	if err != nil {
+		t.Fatalf("AggregateCompressed: %v", err)
+	}
+	return agg
+}
+
+func TestAggregateVerify(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	msgA, msgB := []byte("block 100"), []byte("attestation 100")
+	sigA, _ := SignCompressed(a.sk, msgA)
+	sigB, _ := SignCompressed(b.sk, msgB)
+	agg := mustAggregate(t, sigA, sigB)
+
+	pks, pops := [][]byte{a.pk, b.pk}, [][]byte{a.pop, b.pop}
+	if !AggregateVerifyCompressed(pks, pops, agg, [][]byte{msgA, msgB}) {
+		t.Fatal("aggregate does not verify")
+	}
+	if AggregateVerifyCompressed(pks, pops, agg, [][]byte{msgB, msgA}) {
+		t.Fatal("aggregate verifies with the messages swapped")
+	}
+	if AggregateVerifyCompressed(pks, [][]byte{b.pop, a.pop}, agg, [][]byte{msgA, msgB}) {
+		t.Fatal("aggregate verifies with the PoPs swapped")
+	}
+	if AggregateVerifyCompressed(pks, pops[:1], agg, [][]byte{msgA, msgB}) {
+		t.Fatal("aggregate verifies with a PoP missing")
+	}
+	if AggregateVerifyCompressed(nil, nil, agg, nil) {
+		t.Fatal("empty aggregate verifies")
+	}
+}
+
+func TestFastAggregateVerifyAndAggregateKeys(t *testing.T) {
+	a, b := newTestKey(t), newTestKey(t)
+	msg := []byte("preattestation 7")
+	sigA, _ := SignCompressed(a.sk, msg)
+	sigB, _ := SignCompressed(b.sk, msg)
+	agg := mustAggregate(t, sigA, sigB)
+
+	if !FastAggregateVerifyCompressed([][]byte{a.pk, b.pk}, agg, msg) {
+		t.Fatal("same-message aggregate does not verify")
+	}
+	if FastAggregateVerifyCompressed([][]byte{a.pk}, agg, msg) {
+		t.Fatal("same-message aggregate verifies for one of its signers")
+	}
+	aggPK, err := AggregatePublicKeysCompressed([][]byte{a.pk, b.pk})
+	if err != nil {
+		t.Fatalf("AggregatePublicKeysCompressed: %v", err)
+	}
+	if !VerifyCompressed(aggPK, agg, msg) {
+		t.Fatal("aggregate does not verify under the aggregate key")
+	}
+}
+
+// TestRogueKeyNeedsPoP forges an aggregate "signed" by a victim with a key
+// chosen to cancel the victim's out. Without PoPs it verifies; the rogue key
+// has no PoP, so the PoP-checked verification refuses it.
+func TestRogueKeyNeedsPoP(t *testing.T) {
+	victim, attacker := newTestKey(t), newTestKey(t)
+
+	var victimPK, attackerPK PublicKey
+	if victimPK.Uncompress(victim.pk) == nil || attackerPK.Uncompress(attacker.pk) == nil {
+		t.Fatal("uncompress")
+	}
+	// rogue = attacker - victim, so victim + rogue = attacker
+	var rogue blst.P1
+	rogue.FromAffine(&attackerPK)
+	rogue.SubAssign(&victimPK)
+	roguePK := rogue.ToAffine().Compress()
+
+	msg := []byte("double bake")
+	forged, _ := SignCompressed(attacker.sk, msg)
+	pks := [][]byte{victim.pk, roguePK}
+
+	if !FastAggregateVerifyCompressed(pks, forged, msg) {
+		t.Fatal("rogue key forgery does not work without PoPs; the test is broken")
+	}
+	if VerifyPoPCompressed(roguePK, attacker.pop) {
+		t.Fatal("the attacker's PoP verifies for the rogue key")
+	}
+	if AggregateVerifyCompressed(pks, [][]byte{victim.pop, attacker.pop}, forged, [][]byte{msg, msg}) {
+		t.Fatal("rogue key forgery verifies with PoPs checked")
+	}
+}
+
+func TestVerifyPoP(t *testing.T) {
+	k, other := newTestKey(t), newTestKey(t)
+	if !VerifyPoPCompressed(k.pk, k.pop) {
+		t.Fatal("PoP does not verify")
+	}
+	if VerifyPoPCompressed(other.pk, k.pop) {
+		t.Fatal("PoP verifies for another key")
+	}
+	// a PoP is not a signature over the key bytes
+	sig, _ := SignCompressed(k.sk, k.pk)
+	if VerifyPoPCompressed(k.pk, sig) {
+		t.Fatal("plain signature over the key accepted as PoP")
+	}
+
+	blPubkey, err := EncodeBLPubkey(k.pk)
+	if err != nil {
+		t.Fatalf("EncodeBLPubkey: %v", err)
+	}
+	pop, err := EncodeBLSignature(k.pop)
+	if err != nil {
+		t.Fatalf("EncodeBLSignature: %v", err)
+	}
+	if !VerifyPoP(blPubkey, pop) {
+		t.Fatal("VerifyPoP refuses the encoded PoP")
+	}
+	if VerifyPoP(blPubkey, "BLsig") {
+		t.Fatal("VerifyPoP accepts a malformed PoP")
+	}
+}
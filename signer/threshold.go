@@ -0,0 +1,192 @@
+package signer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	blst "github.com/supranational/blst/bindings/go"
+)
+
+// Threshold BLS with a trusted dealer: SplitSecretKey Shamir-shares a key's
+// scalar so that any threshold of the shares sign for the group key. Each
+// share is an ordinary BLS key; its signatures are combined by interpolating
+// at zero (CombineSignatures), which gives exactly the signature the group
+// key would have made.
+
+var (
+	errBadThreshold   = errors.New("threshold must be at least 1 and at most the number of shares")
+	errTooManyShares  = errors.New("too many shares")
+	errBadShareIndex  = errors.New("share indices must be distinct and non-zero")
+	errShareCountDiff = errors.New("need exactly one share index per element")
+	errScalarArith    = errors.New("scalar arithmetic produced zero; retry")
+)
+
+// MaxShares bounds the number of shares of one key.
+const MaxShares = 255
+
+// KeyShare is share Index (1-based, the x coordinate) of a split key.
+type KeyShare struct {
+	Index  uint32
+	Secret *SecretKey
+}
+
+// SplitSecretKey shares secretKey into total shares, any threshold of which
+// recover it. The coefficients are wiped before returning; the caller owns
+// secretKey and the share secrets.
+func SplitSecretKey(secretKey *SecretKey, threshold, total uint32) ([]KeyShare, error) {
+	if threshold < 1 || threshold > total {
+		return nil, errBadThreshold
+	}
+	if total > MaxShares {
+		return nil, errTooManyShares
+	}
+
+	// f(x) = secretKey + c1·x + … + c(t-1)·x^(t-1)
+	coeffs := make([]*SecretKey, threshold)
+	coeffs[0] = secretKey
+	for i := 1; i < len(coeffs); i++ {
+		var ikm [32]byte
+		if _, err := rand.Read(ikm[:]); err != nil {
+			return nil, err
+		}
+		coeffs[i] = blst.KeyGen(ikm[:])
+		clear(ikm[:])
+	}
+	defer func() {
+		for _, c := range coeffs[1:] {
+			c.Zeroize()
+		}
+	}()
+
+	shares := make([]KeyShare, 0, total)
+	for idx := uint32(1); idx <= total; idx++ {
+		y, err := evalPolynomial(coeffs, scalarFromIndex(idx))
+		if err != nil {
+			for _, s := range shares {
+				s.Secret.Zeroize()
+			}
+			return nil, err
+		}
+		shares = append(shares, KeyShare{Index: idx, Secret: y})
+	}
+	return shares, nil
+}
+
+// evalPolynomial is Horner's rule over the scalar field.
+func evalPolynomial(coeffs []*SecretKey, x *blst.Scalar) (*SecretKey, error) {
+	acc := new(blst.Scalar)
+	*acc = *coeffs[len(coeffs)-1]
+	for i := len(coeffs) - 2; i >= 0; i-- {
+		if _, ok := acc.MulAssign(x); !ok {
+			return nil, errScalarArith
+		}
+		if _, ok := acc.AddAssign(coeffs[i]); !ok {
+			return nil, errScalarArith
+		}
+	}
+	return acc, nil
+}
+
+func scalarFromIndex(idx uint32) *blst.Scalar {
+	var be [blst.BLST_SCALAR_BYTES]byte
+	binary.BigEndian.PutUint32(be[len(be)-4:], idx)
+	return new(blst.Scalar).FromBEndian(be[:])
+}
+
+// lagrangeAtZero returns the coefficients λi with f(0) = Σ λi·f(xi).
+func lagrangeAtZero(indices []uint32) ([]*blst.Scalar, error) {
+	seen := make(map[uint32]bool, len(indices))
+	xs := make([]*blst.Scalar, len(indices))
+	for i, idx := range indices {
+		if idx == 0 || seen[idx] {
+			return nil, errBadShareIndex
+		}
+		seen[idx] = true
+		xs[i] = scalarFromIndex(idx)
+	}
+
+	out := make([]*blst.Scalar, len(indices))
+	for i := range xs {
+		num := scalarFromIndex(1)
+		den := scalarFromIndex(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			diff, ok := xs[j].Sub(xs[i])
+			if !ok {
+				return nil, errBadShareIndex
+			}
+			if _, ok := num.MulAssign(xs[j]); !ok {
+				return nil, errScalarArith
+			}
+			if _, ok := den.MulAssign(diff); !ok {
+				return nil, errScalarArith
+			}
+		}
+		lambda, ok := num.Mul(den.Inverse())
+		if !ok {
+			return nil, errScalarArith
+		}
+		out[i] = lambda
+	}
+	return out, nil
+}
+
+// CombineSignatures interpolates the partial signatures sigList[i], made by
+// share indices[i] over the same message, into the group signature. It needs
+// at least threshold partials and cannot tell if it got fewer: verify the
+// result against the group key.
+func CombineSignatures(indices []uint32, sigList [][]byte) ([]byte, error) {
+	if len(indices) == 0 {
+		return nil, errEmptyAggregate
+	}
+	if len(indices) != len(sigList) {
+		return nil, errShareCountDiff
+	}
+	lambdas, err := lagrangeAtZero(indices)
+	if err != nil {
+		return nil, err
+	}
+
+	var acc blst.P2
+	for i, b := range sigList {
+		var sig Signature
+		if sig.Uncompress(b) == nil || !sig.SigValidate(false) {
+			return nil, fmt.Errorf("partial %d: %w", indices[i], errBadSigEncoding)
+		}
+		var term blst.P2
+		term.FromAffine(&sig)
+		acc.AddAssign(term.MultAssign(lambdas[i]))
+	}
+	return acc.ToAffine().Compress(), nil
+}
+
+// CombinePublicKeys interpolates share pubkeys into the group pubkey, e.g. to
+// check that threshold devices really hold shares of one key.
+func CombinePublicKeys(indices []uint32, pubkeyList [][]byte) ([]byte, error) {
+	if len(indices) == 0 {
+		return nil, errEmptyAggregate
+	}
+	if len(indices) != len(pubkeyList) {
+		return nil, errShareCountDiff
+	}
+	lambdas, err := lagrangeAtZero(indices)
+	if err != nil {
+		return nil, err
+	}
+
+	var acc blst.P1
+	for i, b := range pubkeyList {
+		var pubkey PublicKey
+		if pubkey.Uncompress(b) == nil || !pubkey.KeyValidate() {
+			return nil, fmt.Errorf("share %d: %w", indices[i], errBadPubkeyEncoding)
+		}
+		var term blst.P1
+		term.FromAffine(&pubkey)
+		acc.AddAssign(term.MultAssign(lambdas[i]))
+	}
+	return acc.ToAffine().Compress(), nil
+}
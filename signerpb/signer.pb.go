@@ -133,11 +133,13 @@ func (x *PerKeyResult) GetError() string {
 
 // ---- unlock ----
 type UnlockRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	KeyIds        []string               `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
-	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	KeyIds     []string               `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
+	Passphrase []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	// Per-key passphrases, by key id, for keys created with one.
+	KeyPassphrases map[string][]byte `protobuf:"bytes,3,rep,name=key_passphrases,json=keyPassphrases,proto3" json:"key_passphrases,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *UnlockRequest) Reset() {
@@ -184,6 +186,13 @@ func (x *UnlockRequest) GetPassphrase() []byte {
 	return nil
 }
 
+func (x *UnlockRequest) GetKeyPassphrases() map[string][]byte {
+	if x != nil {
+		return x.KeyPassphrases
+	}
+	return nil
+}
+
 type UnlockResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Results       []*PerKeyResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
@@ -317,13 +326,86 @@ func (x *LockResponse) GetResults() []*PerKeyResult {
 	return nil
 }
 
+// Signing window; zero fields are unbounded. Times are unix seconds (gadget clock).
+type Validity struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NotBefore     int64                  `protobuf:"varint,1,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter      int64                  `protobuf:"varint,2,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	MinLevel      uint64                 `protobuf:"varint,3,opt,name=min_level,json=minLevel,proto3" json:"min_level,omitempty"` // inclusive
+	MaxLevel      uint64                 `protobuf:"varint,4,opt,name=max_level,json=maxLevel,proto3" json:"max_level,omitempty"` // inclusive
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Validity) Reset() {
+	*x = Validity{}
+	mi := &file_signer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Validity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Validity) ProtoMessage() {}
+
+func (x *Validity) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Validity.ProtoReflect.Descriptor instead.
+func (*Validity) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Validity) GetNotBefore() int64 {
+	if x != nil {
+		return x.NotBefore
+	}
+	return 0
+}
+
+func (x *Validity) GetNotAfter() int64 {
+	if x != nil {
+		return x.NotAfter
+	}
+	return 0
+}
+
+func (x *Validity) GetMinLevel() uint64 {
+	if x != nil {
+		return x.MinLevel
+	}
+	return 0
+}
+
+func (x *Validity) GetMaxLevel() uint64 {
+	if x != nil {
+		return x.MaxLevel
+	}
+	return 0
+}
+
 type KeyStatus struct {
 	state                   protoimpl.MessageState `protogen:"open.v1"`
 	KeyId                   string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
-	LockState               LockState              `protobuf:"varint,2,opt,name=lock_state,json=lockState,proto3,enum=signer.LockState" json:"lock_state,omitempty"` // true if key is unlocked in memory
-	Tz4                     string                 `protobuf:"bytes,3,opt,name=tz4,proto3" json:"tz4,omitempty"`                                                     // tz4 address (always available)
-	BlPubkey                string                 `protobuf:"bytes,4,opt,name=bl_pubkey,json=blPubkey,proto3" json:"bl_pubkey,omitempty"`                           // BLpk… (always available)
-	Pop                     string                 `protobuf:"bytes,5,opt,name=pop,proto3" json:"pop,omitempty"`                                                     // BLsig… PoP over pubkey (always available)
+	LockState               LockState              `protobuf:"varint,2,opt,name=lock_state,json=lockState,proto3,enum=signer.LockState" json:"lock_state,omitempty"`                         // true if key is unlocked in memory
+	Tz4                     string                 `protobuf:"bytes,3,opt,name=tz4,proto3" json:"tz4,omitempty"`                                                                             // tz4 address (always available)
+	BlPubkey                string                 `protobuf:"bytes,4,opt,name=bl_pubkey,json=blPubkey,proto3" json:"bl_pubkey,omitempty"`                                                   // BLpk… (always available)
+	Pop                     string                 `protobuf:"bytes,5,opt,name=pop,proto3" json:"pop,omitempty"`                                                                             // BLsig… PoP over pubkey (always available)
+	KeyPassphrase           bool                   `protobuf:"varint,6,opt,name=key_passphrase,json=keyPassphrase,proto3" json:"key_passphrase,omitempty"`                                   // unlock also requires the key's own passphrase
+	Tags                    map[string]string      `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // operator labels, e.g. role=baker
+	Validity                *Validity              `protobuf:"bytes,8,opt,name=validity,proto3" json:"validity,omitempty"`                                                                   // unset if the key has no signing window
+	DerivationPath          string                 `protobuf:"bytes,9,opt,name=derivation_path,json=derivationPath,proto3" json:"derivation_path,omitempty"`                                 // EIP-2333 path of deterministic keys
 	LastBlockLevel          uint64                 `protobuf:"varint,10,opt,name=last_block_level,json=lastBlockLevel,proto3" json:"last_block_level,omitempty"`
 	LastPreattestationLevel uint64                 `protobuf:"varint,11,opt,name=last_preattestation_level,json=lastPreattestationLevel,proto3" json:"last_preattestation_level,omitempty"`
 	LastAttestationLevel    uint64                 `protobuf:"varint,12,opt,name=last_attestation_level,json=lastAttestationLevel,proto3" json:"last_attestation_level,omitempty"`
@@ -331,13 +413,16 @@ type KeyStatus struct {
 	LastPreattestationRound uint32                 `protobuf:"varint,21,opt,name=last_preattestation_round,json=lastPreattestationRound,proto3" json:"last_preattestation_round,omitempty"`
 	LastAttestationRound    uint32                 `protobuf:"varint,22,opt,name=last_attestation_round,json=lastAttestationRound,proto3" json:"last_attestation_round,omitempty"`
 	StateCorrupted          bool                   `protobuf:"varint,30,opt,name=state_corrupted,json=stateCorrupted,proto3" json:"state_corrupted,omitempty"` // true if level.bin failed to decrypt/load
-	unknownFields           protoimpl.UnknownFields
-	sizeCache               protoimpl.SizeCache
+	// usage, from the encrypted key state (unlocked keys only)
+	SignCount     uint64 `protobuf:"varint,40,opt,name=sign_count,json=signCount,proto3" json:"sign_count,omitempty"`
+	LastUsedUnix  int64  `protobuf:"varint,41,opt,name=last_used_unix,json=lastUsedUnix,proto3" json:"last_used_unix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *KeyStatus) Reset() {
 	*x = KeyStatus{}
-	mi := &file_signer_proto_msgTypes[5]
+	mi := &file_signer_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -349,7 +434,7 @@ func (x *KeyStatus) String() string {
 func (*KeyStatus) ProtoMessage() {}
 
 func (x *KeyStatus) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[5]
+	mi := &file_signer_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -362,7 +447,7 @@ func (x *KeyStatus) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use KeyStatus.ProtoReflect.Descriptor instead.
 func (*KeyStatus) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{5}
+	return file_signer_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *KeyStatus) GetKeyId() string {
@@ -400,6 +485,34 @@ func (x *KeyStatus) GetPop() string {
 	return ""
 }
 
+func (x *KeyStatus) GetKeyPassphrase() bool {
+	if x != nil {
+		return x.KeyPassphrase
+	}
+	return false
+}
+
+func (x *KeyStatus) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *KeyStatus) GetValidity() *Validity {
+	if x != nil {
+		return x.Validity
+	}
+	return nil
+}
+
+func (x *KeyStatus) GetDerivationPath() string {
+	if x != nil {
+		return x.DerivationPath
+	}
+	return ""
+}
+
 func (x *KeyStatus) GetLastBlockLevel() uint64 {
 	if x != nil {
 		return x.LastBlockLevel
@@ -449,6 +562,20 @@ func (x *KeyStatus) GetStateCorrupted() bool {
 	return false
 }
 
+func (x *KeyStatus) GetSignCount() uint64 {
+	if x != nil {
+		return x.SignCount
+	}
+	return 0
+}
+
+func (x *KeyStatus) GetLastUsedUnix() int64 {
+	if x != nil {
+		return x.LastUsedUnix
+	}
+	return 0
+}
+
 type StatusRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -457,7 +584,7 @@ type StatusRequest struct {
 
 func (x *StatusRequest) Reset() {
 	*x = StatusRequest{}
-	mi := &file_signer_proto_msgTypes[6]
+	mi := &file_signer_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -469,7 +596,7 @@ func (x *StatusRequest) String() string {
 func (*StatusRequest) ProtoMessage() {}
 
 func (x *StatusRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[6]
+	mi := &file_signer_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -482,19 +609,21 @@ func (x *StatusRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
 func (*StatusRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{6}
+	return file_signer_proto_rawDescGZIP(), []int{7}
 }
 
 type StatusResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Keys          []*KeyStatus           `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Keys  []*KeyStatus           `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	// Set when the power-on self-test failed; the gadget then refuses to sign.
+	SelfTestFailure string `protobuf:"bytes,2,opt,name=self_test_failure,json=selfTestFailure,proto3" json:"self_test_failure,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *StatusResponse) Reset() {
 	*x = StatusResponse{}
-	mi := &file_signer_proto_msgTypes[7]
+	mi := &file_signer_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -506,7 +635,7 @@ func (x *StatusResponse) String() string {
 func (*StatusResponse) ProtoMessage() {}
 
 func (x *StatusResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[7]
+	mi := &file_signer_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -519,7 +648,7 @@ func (x *StatusResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
 func (*StatusResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{7}
+	return file_signer_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *StatusResponse) GetKeys() []*KeyStatus {
@@ -529,31 +658,36 @@ func (x *StatusResponse) GetKeys() []*KeyStatus {
 	return nil
 }
 
-// ---- sign ----
-// Gadget decodes raw bytes to determine both.
-type SignRequest struct {
+func (x *StatusResponse) GetSelfTestFailure() string {
+	if x != nil {
+		return x.SelfTestFailure
+	}
+	return ""
+}
+
+// Identity of one key, without the cost of a full status.
+type GetPublicKeyRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Tz4           string                 `protobuf:"bytes,1,opt,name=tz4,proto3" json:"tz4,omitempty"`
-	Message       []byte                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"` // raw, encoded Tezos content to sign
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"` // tz4 address or key id
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SignRequest) Reset() {
-	*x = SignRequest{}
-	mi := &file_signer_proto_msgTypes[8]
+func (x *GetPublicKeyRequest) Reset() {
+	*x = GetPublicKeyRequest{}
+	mi := &file_signer_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SignRequest) String() string {
+func (x *GetPublicKeyRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SignRequest) ProtoMessage() {}
+func (*GetPublicKeyRequest) ProtoMessage() {}
 
-func (x *SignRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[8]
+func (x *GetPublicKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -564,47 +698,42 @@ func (x *SignRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SignRequest.ProtoReflect.Descriptor instead.
-func (*SignRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{8}
+// Deprecated: Use GetPublicKeyRequest.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{9}
 }
 
-func (x *SignRequest) GetTz4() string {
+func (x *GetPublicKeyRequest) GetKey() string {
 	if x != nil {
-		return x.Tz4
+		return x.Key
 	}
 	return ""
 }
 
-func (x *SignRequest) GetMessage() []byte {
-	if x != nil {
-		return x.Message
-	}
-	return nil
-}
-
-type SignResponse struct {
+// Re-signs and stores the PoP of an unlocked key; answered with
+// get_public_key.
+type RegeneratePoPRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Signature     []byte                 `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"` // compressed BLsig (96B)
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SignResponse) Reset() {
-	*x = SignResponse{}
-	mi := &file_signer_proto_msgTypes[9]
+func (x *RegeneratePoPRequest) Reset() {
+	*x = RegeneratePoPRequest{}
+	mi := &file_signer_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SignResponse) String() string {
+func (x *RegeneratePoPRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SignResponse) ProtoMessage() {}
+func (*RegeneratePoPRequest) ProtoMessage() {}
 
-func (x *SignResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[9]
+func (x *RegeneratePoPRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -615,45 +744,44 @@ func (x *SignResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SignResponse.ProtoReflect.Descriptor instead.
-func (*SignResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{9}
+// Deprecated: Use RegeneratePoPRequest.ProtoReflect.Descriptor instead.
+func (*RegeneratePoPRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{10}
 }
 
-func (x *SignResponse) GetSignature() []byte {
+func (x *RegeneratePoPRequest) GetKeyId() string {
 	if x != nil {
-		return x.Signature
+		return x.KeyId
 	}
-	return nil
+	return ""
 }
 
-// ---- new keys ----
-type NewKeyPerKeyResult struct {
+type GetPublicKeyResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`          // final id (auto or requested)
-	BlPubkey      string                 `protobuf:"bytes,2,opt,name=bl_pubkey,json=blPubkey,proto3" json:"bl_pubkey,omitempty"` // Base58 BLPubkey
-	Tz4           string                 `protobuf:"bytes,3,opt,name=tz4,proto3" json:"tz4,omitempty"`                           // tz4 hash of pubkey
-	Ok            bool                   `protobuf:"varint,4,opt,name=ok,proto3" json:"ok,omitempty"`
-	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"` // empty if ok=true
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Tz4           string                 `protobuf:"bytes,2,opt,name=tz4,proto3" json:"tz4,omitempty"`
+	BlPubkey      string                 `protobuf:"bytes,3,opt,name=bl_pubkey,json=blPubkey,proto3" json:"bl_pubkey,omitempty"` // BLpk…
+	Pop           string                 `protobuf:"bytes,4,opt,name=pop,proto3" json:"pop,omitempty"`                           // BLsig… PoP over pubkey
+	Share         *ThresholdShare        `protobuf:"bytes,5,opt,name=share,proto3" json:"share,omitempty"`                       // set for imported threshold shares
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *NewKeyPerKeyResult) Reset() {
-	*x = NewKeyPerKeyResult{}
-	mi := &file_signer_proto_msgTypes[10]
+func (x *GetPublicKeyResponse) Reset() {
+	*x = GetPublicKeyResponse{}
+	mi := &file_signer_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *NewKeyPerKeyResult) String() string {
+func (x *GetPublicKeyResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NewKeyPerKeyResult) ProtoMessage() {}
+func (*GetPublicKeyResponse) ProtoMessage() {}
 
-func (x *NewKeyPerKeyResult) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[10]
+func (x *GetPublicKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -664,71 +792,71 @@ func (x *NewKeyPerKeyResult) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use NewKeyPerKeyResult.ProtoReflect.Descriptor instead.
-func (*NewKeyPerKeyResult) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{10}
+// Deprecated: Use GetPublicKeyResponse.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{11}
 }
 
-func (x *NewKeyPerKeyResult) GetKeyId() string {
+func (x *GetPublicKeyResponse) GetKeyId() string {
 	if x != nil {
 		return x.KeyId
 	}
 	return ""
 }
 
-func (x *NewKeyPerKeyResult) GetBlPubkey() string {
+func (x *GetPublicKeyResponse) GetTz4() string {
 	if x != nil {
-		return x.BlPubkey
+		return x.Tz4
 	}
 	return ""
 }
 
-func (x *NewKeyPerKeyResult) GetTz4() string {
+func (x *GetPublicKeyResponse) GetBlPubkey() string {
 	if x != nil {
-		return x.Tz4
+		return x.BlPubkey
 	}
 	return ""
 }
 
-func (x *NewKeyPerKeyResult) GetOk() bool {
+func (x *GetPublicKeyResponse) GetPop() string {
 	if x != nil {
-		return x.Ok
+		return x.Pop
 	}
-	return false
+	return ""
 }
 
-func (x *NewKeyPerKeyResult) GetError() string {
+func (x *GetPublicKeyResponse) GetShare() *ThresholdShare {
 	if x != nil {
-		return x.Error
+		return x.Share
 	}
-	return ""
+	return nil
 }
 
-type NewKeysRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Optional: provide one or more aliases
-	// If empty, gadget auto-assigns (e.g., "key3").
-	KeyIds        []string `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
-	Passphrase    []byte   `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+// ---- sign ----
+// Gadget decodes raw bytes to determine both.
+type SignRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tz4           string                 `protobuf:"bytes,1,opt,name=tz4,proto3" json:"tz4,omitempty"`
+	Message       []byte                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"` // raw, encoded Tezos content to sign
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *NewKeysRequest) Reset() {
-	*x = NewKeysRequest{}
-	mi := &file_signer_proto_msgTypes[11]
+func (x *SignRequest) Reset() {
+	*x = SignRequest{}
+	mi := &file_signer_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *NewKeysRequest) String() string {
+func (x *SignRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NewKeysRequest) ProtoMessage() {}
+func (*SignRequest) ProtoMessage() {}
 
-func (x *NewKeysRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[11]
+func (x *SignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -739,95 +867,2160 @@ func (x *NewKeysRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use NewKeysRequest.ProtoReflect.Descriptor instead.
-func (*NewKeysRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{11}
+// Deprecated: Use SignRequest.ProtoReflect.Descriptor instead.
+func (*SignRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{12}
 }
 
-func (x *NewKeysRequest) GetKeyIds() []string {
+func (x *SignRequest) GetTz4() string {
 	if x != nil {
-		return x.KeyIds
+		return x.Tz4
 	}
-	return nil
+	return ""
 }
 
-func (x *NewKeysRequest) GetPassphrase() []byte {
+func (x *SignRequest) GetMessage() []byte {
 	if x != nil {
-		return x.Passphrase
+		return x.Message
 	}
 	return nil
 }
 
-type NewKeysResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// One result per attempted key (includes ok/error + key material)
-	Results       []*NewKeyPerKeyResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+type SignResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Signature     []byte                 `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"` // compressed BLsig (96B)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *NewKeysResponse) Reset() {
-	*x = NewKeysResponse{}
-	mi := &file_signer_proto_msgTypes[12]
+func (x *SignResponse) Reset() {
+	*x = SignResponse{}
+	mi := &file_signer_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *NewKeysResponse) String() string {
+func (x *SignResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SignResponse) ProtoMessage() {}
+
+func (x *SignResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SignResponse.ProtoReflect.Descriptor instead.
+func (*SignResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SignResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// ---- new keys ----
+type NewKeyPerKeyResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`          // final id (auto or requested)
+	BlPubkey      string                 `protobuf:"bytes,2,opt,name=bl_pubkey,json=blPubkey,proto3" json:"bl_pubkey,omitempty"` // Base58 BLPubkey
+	Tz4           string                 `protobuf:"bytes,3,opt,name=tz4,proto3" json:"tz4,omitempty"`                           // tz4 hash of pubkey
+	Ok            bool                   `protobuf:"varint,4,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"` // empty if ok=true
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NewKeyPerKeyResult) Reset() {
+	*x = NewKeyPerKeyResult{}
+	mi := &file_signer_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewKeyPerKeyResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewKeyPerKeyResult) ProtoMessage() {}
+
+func (x *NewKeyPerKeyResult) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewKeyPerKeyResult.ProtoReflect.Descriptor instead.
+func (*NewKeyPerKeyResult) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *NewKeyPerKeyResult) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *NewKeyPerKeyResult) GetBlPubkey() string {
+	if x != nil {
+		return x.BlPubkey
+	}
+	return ""
+}
+
+func (x *NewKeyPerKeyResult) GetTz4() string {
+	if x != nil {
+		return x.Tz4
+	}
+	return ""
+}
+
+func (x *NewKeyPerKeyResult) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *NewKeyPerKeyResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type NewKeysRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Optional: provide one or more aliases
+	// If empty, gadget auto-assigns (e.g., "key3").
+	KeyIds     []string `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
+	Passphrase []byte   `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	// Optional: additional per-key passphrase applied to every key created.
+	KeyPassphrase []byte `protobuf:"bytes,3,opt,name=key_passphrase,json=keyPassphrase,proto3" json:"key_passphrase,omitempty"`
+	// Optional: explicit EIP-2333 path (deterministic stores, single key only).
+	DerivationPath string `protobuf:"bytes,4,opt,name=derivation_path,json=derivationPath,proto3" json:"derivation_path,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *NewKeysRequest) Reset() {
+	*x = NewKeysRequest{}
+	mi := &file_signer_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewKeysRequest) ProtoMessage() {}
+
+func (x *NewKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewKeysRequest.ProtoReflect.Descriptor instead.
+func (*NewKeysRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *NewKeysRequest) GetKeyIds() []string {
+	if x != nil {
+		return x.KeyIds
+	}
+	return nil
+}
+
+func (x *NewKeysRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+func (x *NewKeysRequest) GetKeyPassphrase() []byte {
+	if x != nil {
+		return x.KeyPassphrase
+	}
+	return nil
+}
+
+func (x *NewKeysRequest) GetDerivationPath() string {
+	if x != nil {
+		return x.DerivationPath
+	}
+	return ""
+}
+
+type NewKeysResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One result per attempted key (includes ok/error + key material)
+	Results       []*NewKeyPerKeyResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NewKeysResponse) Reset() {
+	*x = NewKeysResponse{}
+	mi := &file_signer_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewKeysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewKeysResponse) ProtoMessage() {}
+
+func (x *NewKeysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewKeysResponse.ProtoReflect.Descriptor instead.
+func (*NewKeysResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *NewKeysResponse) GetResults() []*NewKeyPerKeyResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// ---- logs ----
+type LogsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Max number of most-recent log lines to return.
+	// If zero, gadget picks a sensible default (e.g., 100).
+	Limit uint32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Tail the log file instead of the in-memory buffer. The buffer only holds
+	// records since boot but survives a full or read-only data partition.
+	FromFile      bool `protobuf:"varint,2,opt,name=from_file,json=fromFile,proto3" json:"from_file,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogsRequest) Reset() {
+	*x = LogsRequest{}
+	mi := &file_signer_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogsRequest) ProtoMessage() {}
+
+func (x *LogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogsRequest.ProtoReflect.Descriptor instead.
+func (*LogsRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *LogsRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *LogsRequest) GetFromFile() bool {
+	if x != nil {
+		return x.FromFile
+	}
+	return false
+}
+
+type LogsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lines         []string               `protobuf:"bytes,1,rep,name=lines,proto3" json:"lines,omitempty"`   // newest last
+	Source        string                 `protobuf:"bytes,2,opt,name=source,proto3" json:"source,omitempty"` // "memory" or "file"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogsResponse) Reset() {
+	*x = LogsResponse{}
+	mi := &file_signer_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogsResponse) ProtoMessage() {}
+
+func (x *LogsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogsResponse.ProtoReflect.Descriptor instead.
+func (*LogsResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *LogsResponse) GetLines() []string {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+func (x *LogsResponse) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+// Changes the gadget's log levels until the next restart. spec uses the
+// LOG_LEVEL syntax ("debug", "broker=debug,keychain=info"); module=default
+// drops an override. An empty spec only reports the current levels.
+type SetLogLevelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Spec          string                 `protobuf:"bytes,1,opt,name=spec,proto3" json:"spec,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLogLevelRequest) Reset() {
+	*x = SetLogLevelRequest{}
+	mi := &file_signer_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLogLevelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLogLevelRequest) ProtoMessage() {}
+
+func (x *SetLogLevelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLogLevelRequest.ProtoReflect.Descriptor instead.
+func (*SetLogLevelRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *SetLogLevelRequest) GetSpec() string {
+	if x != nil {
+		return x.Spec
+	}
+	return ""
+}
+
+type SetLogLevelResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Levels        string                 `protobuf:"bytes,1,opt,name=levels,proto3" json:"levels,omitempty"` // effective levels after the change, LOG_LEVEL syntax
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLogLevelResponse) Reset() {
+	*x = SetLogLevelResponse{}
+	mi := &file_signer_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLogLevelResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLogLevelResponse) ProtoMessage() {}
+
+func (x *SetLogLevelResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLogLevelResponse.ProtoReflect.Descriptor instead.
+func (*SetLogLevelResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *SetLogLevelResponse) GetLevels() string {
+	if x != nil {
+		return x.Levels
+	}
+	return ""
+}
+
+// ---- app update ----
+// The host pushes a new signer binary to the staging area on the data
+// partition: begin with the master passphrase, then chunks in order, then
+// commit. The gadget checks the size, hash, release signature and ELF
+// header on commit; the app_update helper checks them again and installs
+// the binary on the next boot.
+type BeginUpdateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passphrase    []byte                 `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"` // master passphrase; authorizes the update
+	Size          uint64                 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256        []byte                 `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"` // the binary's .minisig, by the release key
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BeginUpdateRequest) Reset() {
+	*x = BeginUpdateRequest{}
+	mi := &file_signer_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BeginUpdateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BeginUpdateRequest) ProtoMessage() {}
+
+func (x *BeginUpdateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BeginUpdateRequest.ProtoReflect.Descriptor instead.
+func (*BeginUpdateRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *BeginUpdateRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+func (x *BeginUpdateRequest) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *BeginUpdateRequest) GetSha256() []byte {
+	if x != nil {
+		return x.Sha256
+	}
+	return nil
+}
+
+func (x *BeginUpdateRequest) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type UpdateChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Offset        uint64                 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"` // must equal the bytes received so far
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateChunkRequest) Reset() {
+	*x = UpdateChunkRequest{}
+	mi := &file_signer_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateChunkRequest) ProtoMessage() {}
+
+func (x *UpdateChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateChunkRequest.ProtoReflect.Descriptor instead.
+func (*UpdateChunkRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *UpdateChunkRequest) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *UpdateChunkRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type CommitUpdateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommitUpdateRequest) Reset() {
+	*x = CommitUpdateRequest{}
+	mi := &file_signer_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommitUpdateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommitUpdateRequest) ProtoMessage() {}
+
+func (x *CommitUpdateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommitUpdateRequest.ProtoReflect.Descriptor instead.
+func (*CommitUpdateRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{23}
+}
+
+type UpdateUploadResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Received      uint64                 `protobuf:"varint,1,opt,name=received,proto3" json:"received,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateUploadResponse) Reset() {
+	*x = UpdateUploadResponse{}
+	mi := &file_signer_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateUploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateUploadResponse) ProtoMessage() {}
+
+func (x *UpdateUploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateUploadResponse.ProtoReflect.Descriptor instead.
+func (*UpdateUploadResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *UpdateUploadResponse) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+// ---- version ----
+type VersionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VersionRequest) Reset() {
+	*x = VersionRequest{}
+	mi := &file_signer_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VersionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VersionRequest) ProtoMessage() {}
+
+func (x *VersionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VersionRequest.ProtoReflect.Descriptor instead.
+func (*VersionRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{25}
+}
+
+type VersionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	BuildDate     string                 `protobuf:"bytes,2,opt,name=build_date,json=buildDate,proto3" json:"build_date,omitempty"` // RFC3339 (UTC) if available
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VersionResponse) Reset() {
+	*x = VersionResponse{}
+	mi := &file_signer_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VersionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VersionResponse) ProtoMessage() {}
+
+func (x *VersionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VersionResponse.ProtoReflect.Descriptor instead.
+func (*VersionResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *VersionResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *VersionResponse) GetBuildDate() string {
+	if x != nil {
+		return x.BuildDate
+	}
+	return ""
+}
+
+// ---- init master ----
+type InitMasterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deterministic bool                   `protobuf:"varint,1,opt,name=deterministic,proto3" json:"deterministic,omitempty"` // true => HD mode; false => random-only mode
+	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`        // used to derive KEK and encrypt seed.bin
+	Cipher        string                 `protobuf:"bytes,3,opt,name=cipher,proto3" json:"cipher,omitempty"`                // store cipher suite; empty => aes-256-gcm
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InitMasterRequest) Reset() {
+	*x = InitMasterRequest{}
+	mi := &file_signer_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InitMasterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitMasterRequest) ProtoMessage() {}
+
+func (x *InitMasterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitMasterRequest.ProtoReflect.Descriptor instead.
+func (*InitMasterRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *InitMasterRequest) GetDeterministic() bool {
+	if x != nil {
+		return x.Deterministic
+	}
+	return false
+}
+
+func (x *InitMasterRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+func (x *InitMasterRequest) GetCipher() string {
+	if x != nil {
+		return x.Cipher
+	}
+	return ""
+}
+
+// ---- init master info ----
+type InitInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InitInfoRequest) Reset() {
+	*x = InitInfoRequest{}
+	mi := &file_signer_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InitInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitInfoRequest) ProtoMessage() {}
+
+func (x *InitInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitInfoRequest.ProtoReflect.Descriptor instead.
+func (*InitInfoRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{28}
+}
+
+type InitInfoResponse struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	MasterPresent        bool                   `protobuf:"varint,1,opt,name=master_present,json=masterPresent,proto3" json:"master_present,omitempty"`                      // master.json exists
+	DeterministicEnabled bool                   `protobuf:"varint,2,opt,name=deterministic_enabled,json=deterministicEnabled,proto3" json:"deterministic_enabled,omitempty"` // first byte of seed.bin == 0x01 (only meaningful if seed_present==true)
+	DataLocked           bool                   `protobuf:"varint,3,opt,name=data_locked,json=dataLocked,proto3" json:"data_locked,omitempty"`                               // encrypted data vault not opened yet; deterministic_enabled unknown
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *InitInfoResponse) Reset() {
+	*x = InitInfoResponse{}
+	mi := &file_signer_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InitInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitInfoResponse) ProtoMessage() {}
+
+func (x *InitInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitInfoResponse.ProtoReflect.Descriptor instead.
+func (*InitInfoResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *InitInfoResponse) GetMasterPresent() bool {
+	if x != nil {
+		return x.MasterPresent
+	}
+	return false
+}
+
+func (x *InitInfoResponse) GetDeterministicEnabled() bool {
+	if x != nil {
+		return x.DeterministicEnabled
+	}
+	return false
+}
+
+func (x *InitInfoResponse) GetDataLocked() bool {
+	if x != nil {
+		return x.DataLocked
+	}
+	return false
+}
+
+// ---- set level ----
+// When executed, round is reset to zero on disk.
+type SetLevelRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Level         uint64                 `protobuf:"varint,3,opt,name=level,proto3" json:"level,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLevelRequest) Reset() {
+	*x = SetLevelRequest{}
+	mi := &file_signer_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLevelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLevelRequest) ProtoMessage() {}
+
+func (x *SetLevelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLevelRequest.ProtoReflect.Descriptor instead.
+func (*SetLevelRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *SetLevelRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SetLevelRequest) GetLevel() uint64 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+// ---- delete keys ----
+type DeleteKeysRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyIds        []string               `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
+	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteKeysRequest) Reset() {
+	*x = DeleteKeysRequest{}
+	mi := &file_signer_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteKeysRequest) ProtoMessage() {}
+
+func (x *DeleteKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteKeysRequest.ProtoReflect.Descriptor instead.
+func (*DeleteKeysRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *DeleteKeysRequest) GetKeyIds() []string {
+	if x != nil {
+		return x.KeyIds
+	}
+	return nil
+}
+
+func (x *DeleteKeysRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+type DeleteKeysResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*PerKeyResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteKeysResponse) Reset() {
+	*x = DeleteKeysResponse{}
+	mi := &file_signer_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteKeysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteKeysResponse) ProtoMessage() {}
+
+func (x *DeleteKeysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteKeysResponse.ProtoReflect.Descriptor instead.
+func (*DeleteKeysResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *DeleteKeysResponse) GetResults() []*PerKeyResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// ---- set tags ----
+// `set` is applied first, then `remove`. Response carries the resulting tags.
+type SetTagsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Set           map[string]string      `protobuf:"bytes,2,rep,name=set,proto3" json:"set,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Remove        []string               `protobuf:"bytes,3,rep,name=remove,proto3" json:"remove,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTagsRequest) Reset() {
+	*x = SetTagsRequest{}
+	mi := &file_signer_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTagsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTagsRequest) ProtoMessage() {}
+
+func (x *SetTagsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTagsRequest.ProtoReflect.Descriptor instead.
+func (*SetTagsRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *SetTagsRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SetTagsRequest) GetSet() map[string]string {
+	if x != nil {
+		return x.Set
+	}
+	return nil
+}
+
+func (x *SetTagsRequest) GetRemove() []string {
+	if x != nil {
+		return x.Remove
+	}
+	return nil
+}
+
+type SetTagsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tags          map[string]string      `protobuf:"bytes,1,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTagsResponse) Reset() {
+	*x = SetTagsResponse{}
+	mi := &file_signer_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTagsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTagsResponse) ProtoMessage() {}
+
+func (x *SetTagsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTagsResponse.ProtoReflect.Descriptor instead.
+func (*SetTagsResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *SetTagsResponse) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+// ---- set validity ----
+// Requires the key to be unlocked and the master passphrase: an open window
+// lets the key sign levels and times it was kept from.
+// An unset/zero validity clears the window.
+type SetValidityRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Validity      *Validity              `protobuf:"bytes,2,opt,name=validity,proto3" json:"validity,omitempty"`
+	Passphrase    []byte                 `protobuf:"bytes,3,opt,name=passphrase,proto3" json:"passphrase,omitempty"` // master passphrase; authorizes the change
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetValidityRequest) Reset() {
+	*x = SetValidityRequest{}
+	mi := &file_signer_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetValidityRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetValidityRequest) ProtoMessage() {}
+
+func (x *SetValidityRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetValidityRequest.ProtoReflect.Descriptor instead.
+func (*SetValidityRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *SetValidityRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SetValidityRequest) GetValidity() *Validity {
+	if x != nil {
+		return x.Validity
+	}
+	return nil
+}
+
+func (x *SetValidityRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+// ---- policy ----
+// What a key may sign beyond its watermarks. Unset fields leave that part
+// open; an empty policy allows everything the watermarks do.
+type Policy struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	AllowedKinds      []string               `protobuf:"bytes,1,rep,name=allowed_kinds,json=allowedKinds,proto3" json:"allowed_kinds,omitempty"` // sign kind names, e.g. "attestation"
+	ChainId           string                 `protobuf:"bytes,2,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`                // Net…
+	MaxSignsPerMinute uint32                 `protobuf:"varint,3,opt,name=max_signs_per_minute,json=maxSignsPerMinute,proto3" json:"max_signs_per_minute,omitempty"`
+	Validity          *Validity              `protobuf:"bytes,4,opt,name=validity,proto3" json:"validity,omitempty"`     // same window as set_validity
+	Validation        string                 `protobuf:"bytes,5,opt,name=validation,proto3" json:"validation,omitempty"` // payload checks: "" (standard) or "strict"
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Policy) Reset() {
+	*x = Policy{}
+	mi := &file_signer_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Policy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Policy) ProtoMessage() {}
+
+func (x *Policy) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Policy.ProtoReflect.Descriptor instead.
+func (*Policy) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *Policy) GetAllowedKinds() []string {
+	if x != nil {
+		return x.AllowedKinds
+	}
+	return nil
+}
+
+func (x *Policy) GetChainId() string {
+	if x != nil {
+		return x.ChainId
+	}
+	return ""
+}
+
+func (x *Policy) GetMaxSignsPerMinute() uint32 {
+	if x != nil {
+		return x.MaxSignsPerMinute
+	}
+	return 0
+}
+
+func (x *Policy) GetValidity() *Validity {
+	if x != nil {
+		return x.Validity
+	}
+	return nil
+}
+
+func (x *Policy) GetValidation() string {
+	if x != nil {
+		return x.Validation
+	}
+	return ""
+}
+
+// Requires the key to be unlocked; replaces the whole policy.
+// Requires the key to be unlocked and the master passphrase, as for
+// SetValidityRequest: a new policy can allow what the old one refused.
+type SetPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Policy        *Policy                `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+	Passphrase    []byte                 `protobuf:"bytes,3,opt,name=passphrase,proto3" json:"passphrase,omitempty"` // master passphrase; authorizes the change
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPolicyRequest) Reset() {
+	*x = SetPolicyRequest{}
+	mi := &file_signer_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPolicyRequest) ProtoMessage() {}
+
+func (x *SetPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPolicyRequest.ProtoReflect.Descriptor instead.
+func (*SetPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *SetPolicyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *SetPolicyRequest) GetPolicy() *Policy {
+	if x != nil {
+		return x.Policy
+	}
+	return nil
+}
+
+func (x *SetPolicyRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+type GetPolicyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPolicyRequest) Reset() {
+	*x = GetPolicyRequest{}
+	mi := &file_signer_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPolicyRequest) ProtoMessage() {}
+
+func (x *GetPolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPolicyRequest.ProtoReflect.Descriptor instead.
+func (*GetPolicyRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *GetPolicyRequest) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+type PolicyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Policy        *Policy                `protobuf:"bytes,1,opt,name=policy,proto3" json:"policy,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PolicyResponse) Reset() {
+	*x = PolicyResponse{}
+	mi := &file_signer_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PolicyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PolicyResponse) ProtoMessage() {}
+
+func (x *PolicyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PolicyResponse.ProtoReflect.Descriptor instead.
+func (*PolicyResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *PolicyResponse) GetPolicy() *Policy {
+	if x != nil {
+		return x.Policy
+	}
+	return nil
+}
+
+// ---- watermarks ----
+// Every kind a key has a watermark for, including kinds this build does not
+// know (kind is then "unknown"; kind_id still tells them apart).
+type WatermarkEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	KindId        uint32                 `protobuf:"varint,2,opt,name=kind_id,json=kindId,proto3" json:"kind_id,omitempty"` // watermark byte, e.g. 0x13
+	Level         uint64                 `protobuf:"varint,3,opt,name=level,proto3" json:"level,omitempty"`
+	Round         uint32                 `protobuf:"varint,4,opt,name=round,proto3" json:"round,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatermarkEntry) Reset() {
+	*x = WatermarkEntry{}
+	mi := &file_signer_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatermarkEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatermarkEntry) ProtoMessage() {}
+
+func (x *WatermarkEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatermarkEntry.ProtoReflect.Descriptor instead.
+func (*WatermarkEntry) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *WatermarkEntry) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *WatermarkEntry) GetKindId() uint32 {
+	if x != nil {
+		return x.KindId
+	}
+	return 0
+}
+
+func (x *WatermarkEntry) GetLevel() uint64 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *WatermarkEntry) GetRound() uint32 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+// Watermarks are only readable while the key is unlocked.
+type KeyWatermarks struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	KeyId          string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Tz4            string                 `protobuf:"bytes,2,opt,name=tz4,proto3" json:"tz4,omitempty"`
+	LockState      LockState              `protobuf:"varint,3,opt,name=lock_state,json=lockState,proto3,enum=signer.LockState" json:"lock_state,omitempty"`
+	Watermarks     []*WatermarkEntry      `protobuf:"bytes,4,rep,name=watermarks,proto3" json:"watermarks,omitempty"`
+	Sequence       uint64                 `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"` // state file writes so far
+	SignCount      uint64                 `protobuf:"varint,6,opt,name=sign_count,json=signCount,proto3" json:"sign_count,omitempty"`
+	LastUsedUnix   int64                  `protobuf:"varint,7,opt,name=last_used_unix,json=lastUsedUnix,proto3" json:"last_used_unix,omitempty"` // last signature, i.e. last watermark update
+	StateCorrupted bool                   `protobuf:"varint,8,opt,name=state_corrupted,json=stateCorrupted,proto3" json:"state_corrupted,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *KeyWatermarks) Reset() {
+	*x = KeyWatermarks{}
+	mi := &file_signer_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KeyWatermarks) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KeyWatermarks) ProtoMessage() {}
+
+func (x *KeyWatermarks) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KeyWatermarks.ProtoReflect.Descriptor instead.
+func (*KeyWatermarks) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *KeyWatermarks) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *KeyWatermarks) GetTz4() string {
+	if x != nil {
+		return x.Tz4
+	}
+	return ""
+}
+
+func (x *KeyWatermarks) GetLockState() LockState {
+	if x != nil {
+		return x.LockState
+	}
+	return LockState_LOCK_STATE_UNSPECIFIED
+}
+
+func (x *KeyWatermarks) GetWatermarks() []*WatermarkEntry {
+	if x != nil {
+		return x.Watermarks
+	}
+	return nil
+}
+
+func (x *KeyWatermarks) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *KeyWatermarks) GetSignCount() uint64 {
+	if x != nil {
+		return x.SignCount
+	}
+	return 0
+}
+
+func (x *KeyWatermarks) GetLastUsedUnix() int64 {
+	if x != nil {
+		return x.LastUsedUnix
+	}
+	return 0
+}
+
+func (x *KeyWatermarks) GetStateCorrupted() bool {
+	if x != nil {
+		return x.StateCorrupted
+	}
+	return false
+}
+
+type GetWatermarksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyIds        []string               `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"` // empty => all keys
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWatermarksRequest) Reset() {
+	*x = GetWatermarksRequest{}
+	mi := &file_signer_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWatermarksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWatermarksRequest) ProtoMessage() {}
+
+func (x *GetWatermarksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWatermarksRequest.ProtoReflect.Descriptor instead.
+func (*GetWatermarksRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *GetWatermarksRequest) GetKeyIds() []string {
+	if x != nil {
+		return x.KeyIds
+	}
+	return nil
+}
+
+type GetWatermarksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []*KeyWatermarks       `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWatermarksResponse) Reset() {
+	*x = GetWatermarksResponse{}
+	mi := &file_signer_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWatermarksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWatermarksResponse) ProtoMessage() {}
+
+func (x *GetWatermarksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWatermarksResponse.ProtoReflect.Descriptor instead.
+func (*GetWatermarksResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *GetWatermarksResponse) GetKeys() []*KeyWatermarks {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+// ---- watermark export / import ----
+// Snapshots are JSON (keychain.WatermarkSnapshot) authenticated with a MAC
+// keyed from the master passphrase. Import only ever raises watermarks.
+type ExportWatermarksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passphrase    []byte                 `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportWatermarksRequest) Reset() {
+	*x = ExportWatermarksRequest{}
+	mi := &file_signer_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportWatermarksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportWatermarksRequest) ProtoMessage() {}
+
+func (x *ExportWatermarksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportWatermarksRequest.ProtoReflect.Descriptor instead.
+func (*ExportWatermarksRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{44}
+}
+
+func (x *ExportWatermarksRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+type ExportWatermarksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Snapshot      []byte                 `protobuf:"bytes,1,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Skipped       []string               `protobuf:"bytes,2,rep,name=skipped,proto3" json:"skipped,omitempty"` // locked keys, not included
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportWatermarksResponse) Reset() {
+	*x = ExportWatermarksResponse{}
+	mi := &file_signer_proto_msgTypes[45]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportWatermarksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportWatermarksResponse) ProtoMessage() {}
+
+func (x *ExportWatermarksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[45]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportWatermarksResponse.ProtoReflect.Descriptor instead.
+func (*ExportWatermarksResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *ExportWatermarksResponse) GetSnapshot() []byte {
+	if x != nil {
+		return x.Snapshot
+	}
+	return nil
+}
+
+func (x *ExportWatermarksResponse) GetSkipped() []string {
+	if x != nil {
+		return x.Skipped
+	}
+	return nil
+}
+
+type ImportWatermarksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passphrase    []byte                 `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	Snapshot      []byte                 `protobuf:"bytes,2,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportWatermarksRequest) Reset() {
+	*x = ImportWatermarksRequest{}
+	mi := &file_signer_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportWatermarksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportWatermarksRequest) ProtoMessage() {}
+
+func (x *ImportWatermarksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportWatermarksRequest.ProtoReflect.Descriptor instead.
+func (*ImportWatermarksRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *ImportWatermarksRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+func (x *ImportWatermarksRequest) GetSnapshot() []byte {
+	if x != nil {
+		return x.Snapshot
+	}
+	return nil
+}
+
+type ImportWatermarksPerKeyResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Tz4           string                 `protobuf:"bytes,2,opt,name=tz4,proto3" json:"tz4,omitempty"`
+	Raised        bool                   `protobuf:"varint,3,opt,name=raised,proto3" json:"raised,omitempty"` // false if every kind was already at or above the snapshot
+	Ok            bool                   `protobuf:"varint,4,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"` // empty if ok=true
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportWatermarksPerKeyResult) Reset() {
+	*x = ImportWatermarksPerKeyResult{}
+	mi := &file_signer_proto_msgTypes[47]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportWatermarksPerKeyResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportWatermarksPerKeyResult) ProtoMessage() {}
+
+func (x *ImportWatermarksPerKeyResult) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[47]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportWatermarksPerKeyResult.ProtoReflect.Descriptor instead.
+func (*ImportWatermarksPerKeyResult) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{47}
+}
+
+func (x *ImportWatermarksPerKeyResult) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *ImportWatermarksPerKeyResult) GetTz4() string {
+	if x != nil {
+		return x.Tz4
+	}
+	return ""
+}
+
+func (x *ImportWatermarksPerKeyResult) GetRaised() bool {
+	if x != nil {
+		return x.Raised
+	}
+	return false
+}
+
+func (x *ImportWatermarksPerKeyResult) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *ImportWatermarksPerKeyResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ImportWatermarksResponse struct {
+	state         protoimpl.MessageState          `protogen:"open.v1"`
+	Results       []*ImportWatermarksPerKeyResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImportWatermarksResponse) Reset() {
+	*x = ImportWatermarksResponse{}
+	mi := &file_signer_proto_msgTypes[48]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImportWatermarksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImportWatermarksResponse) ProtoMessage() {}
+
+func (x *ImportWatermarksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[48]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImportWatermarksResponse.ProtoReflect.Descriptor instead.
+func (*ImportWatermarksResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{48}
+}
+
+func (x *ImportWatermarksResponse) GetResults() []*ImportWatermarksPerKeyResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+// ---- master KDF ----
+// Upgrade re-derives the master KEK with the recommended Argon2id params and
+// re-wraps every key and the seed. Watermark snapshots exported before it no
+// longer verify.
+type KDFStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *KDFStatusRequest) Reset() {
+	*x = KDFStatusRequest{}
+	mi := &file_signer_proto_msgTypes[49]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KDFStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*KDFStatusRequest) ProtoMessage() {}
+
+func (x *KDFStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[49]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KDFStatusRequest.ProtoReflect.Descriptor instead.
+func (*KDFStatusRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{49}
+}
+
+type KDFStatusResponse struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Time                 uint32                 `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+	MemoryKib            uint32                 `protobuf:"varint,2,opt,name=memory_kib,json=memoryKib,proto3" json:"memory_kib,omitempty"`
+	Threads              uint32                 `protobuf:"varint,3,opt,name=threads,proto3" json:"threads,omitempty"`
+	RecommendedTime      uint32                 `protobuf:"varint,4,opt,name=recommended_time,json=recommendedTime,proto3" json:"recommended_time,omitempty"`
+	RecommendedMemoryKib uint32                 `protobuf:"varint,5,opt,name=recommended_memory_kib,json=recommendedMemoryKib,proto3" json:"recommended_memory_kib,omitempty"`
+	RecommendedThreads   uint32                 `protobuf:"varint,6,opt,name=recommended_threads,json=recommendedThreads,proto3" json:"recommended_threads,omitempty"`
+	Weak                 bool                   `protobuf:"varint,7,opt,name=weak,proto3" json:"weak,omitempty"`       // current params are cheaper than recommended
+	Pending              bool                   `protobuf:"varint,8,opt,name=pending,proto3" json:"pending,omitempty"` // an interrupted upgrade still has files to fold in
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *KDFStatusResponse) Reset() {
+	*x = KDFStatusResponse{}
+	mi := &file_signer_proto_msgTypes[50]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *KDFStatusResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*NewKeysResponse) ProtoMessage() {}
+func (*KDFStatusResponse) ProtoMessage() {}
+
+func (x *KDFStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[50]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use KDFStatusResponse.ProtoReflect.Descriptor instead.
+func (*KDFStatusResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{50}
+}
+
+func (x *KDFStatusResponse) GetTime() uint32 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *KDFStatusResponse) GetMemoryKib() uint32 {
+	if x != nil {
+		return x.MemoryKib
+	}
+	return 0
+}
+
+func (x *KDFStatusResponse) GetThreads() uint32 {
+	if x != nil {
+		return x.Threads
+	}
+	return 0
+}
+
+func (x *KDFStatusResponse) GetRecommendedTime() uint32 {
+	if x != nil {
+		return x.RecommendedTime
+	}
+	return 0
+}
 
-func (x *NewKeysResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[12]
+func (x *KDFStatusResponse) GetRecommendedMemoryKib() uint32 {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+		return x.RecommendedMemoryKib
 	}
-	return mi.MessageOf(x)
+	return 0
 }
 
-// Deprecated: Use NewKeysResponse.ProtoReflect.Descriptor instead.
-func (*NewKeysResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{12}
+func (x *KDFStatusResponse) GetRecommendedThreads() uint32 {
+	if x != nil {
+		return x.RecommendedThreads
+	}
+	return 0
 }
 
-func (x *NewKeysResponse) GetResults() []*NewKeyPerKeyResult {
+func (x *KDFStatusResponse) GetWeak() bool {
 	if x != nil {
-		return x.Results
+		return x.Weak
 	}
-	return nil
+	return false
 }
 
-// ---- logs ----
-type LogsRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Max number of most-recent log lines to return.
-	// If zero, gadget picks a sensible default (e.g., 100).
-	Limit         uint32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+func (x *KDFStatusResponse) GetPending() bool {
+	if x != nil {
+		return x.Pending
+	}
+	return false
+}
+
+type UpgradeKDFRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passphrase    []byte                 `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LogsRequest) Reset() {
-	*x = LogsRequest{}
-	mi := &file_signer_proto_msgTypes[13]
+func (x *UpgradeKDFRequest) Reset() {
+	*x = UpgradeKDFRequest{}
+	mi := &file_signer_proto_msgTypes[51]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LogsRequest) String() string {
+func (x *UpgradeKDFRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LogsRequest) ProtoMessage() {}
+func (*UpgradeKDFRequest) ProtoMessage() {}
 
-func (x *LogsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[13]
+func (x *UpgradeKDFRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[51]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -838,40 +3031,40 @@ func (x *LogsRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LogsRequest.ProtoReflect.Descriptor instead.
-func (*LogsRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{13}
+// Deprecated: Use UpgradeKDFRequest.ProtoReflect.Descriptor instead.
+func (*UpgradeKDFRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{51}
 }
 
-func (x *LogsRequest) GetLimit() uint32 {
+func (x *UpgradeKDFRequest) GetPassphrase() []byte {
 	if x != nil {
-		return x.Limit
+		return x.Passphrase
 	}
-	return 0
+	return nil
 }
 
-type LogsResponse struct {
+// ---- device info ----
+type DeviceInfoRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Lines         []string               `protobuf:"bytes,1,rep,name=lines,proto3" json:"lines,omitempty"` // newest last
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *LogsResponse) Reset() {
-	*x = LogsResponse{}
-	mi := &file_signer_proto_msgTypes[14]
+func (x *DeviceInfoRequest) Reset() {
+	*x = DeviceInfoRequest{}
+	mi := &file_signer_proto_msgTypes[52]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *LogsResponse) String() string {
+func (x *DeviceInfoRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*LogsResponse) ProtoMessage() {}
+func (*DeviceInfoRequest) ProtoMessage() {}
 
-func (x *LogsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[14]
+func (x *DeviceInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[52]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -882,40 +3075,41 @@ func (x *LogsResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use LogsResponse.ProtoReflect.Descriptor instead.
-func (*LogsResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{14}
-}
-
-func (x *LogsResponse) GetLines() []string {
-	if x != nil {
-		return x.Lines
-	}
-	return nil
+// Deprecated: Use DeviceInfoRequest.ProtoReflect.Descriptor instead.
+func (*DeviceInfoRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{52}
 }
 
-// ---- version ----
-type VersionRequest struct {
+// Key store statistics; when data_locked is set the vault is still closed
+// and the rest is unknown.
+type StoreStats struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	MasterPresent bool                   `protobuf:"varint,1,opt,name=master_present,json=masterPresent,proto3" json:"master_present,omitempty"`
+	Deterministic bool                   `protobuf:"varint,2,opt,name=deterministic,proto3" json:"deterministic,omitempty"`
+	DataLocked    bool                   `protobuf:"varint,3,opt,name=data_locked,json=dataLocked,proto3" json:"data_locked,omitempty"`
+	Keys          uint32                 `protobuf:"varint,4,opt,name=keys,proto3" json:"keys,omitempty"`
+	UnlockedKeys  uint32                 `protobuf:"varint,5,opt,name=unlocked_keys,json=unlockedKeys,proto3" json:"unlocked_keys,omitempty"`
+	Cipher        string                 `protobuf:"bytes,6,opt,name=cipher,proto3" json:"cipher,omitempty"`                   // store cipher suite
+	KdfWeak       bool                   `protobuf:"varint,7,opt,name=kdf_weak,json=kdfWeak,proto3" json:"kdf_weak,omitempty"` // master KDF params below recommended
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *VersionRequest) Reset() {
-	*x = VersionRequest{}
-	mi := &file_signer_proto_msgTypes[15]
+func (x *StoreStats) Reset() {
+	*x = StoreStats{}
+	mi := &file_signer_proto_msgTypes[53]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *VersionRequest) String() string {
+func (x *StoreStats) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*VersionRequest) ProtoMessage() {}
+func (*StoreStats) ProtoMessage() {}
 
-func (x *VersionRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[15]
+func (x *StoreStats) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[53]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -926,34 +3120,89 @@ func (x *VersionRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use VersionRequest.ProtoReflect.Descriptor instead.
-func (*VersionRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{15}
+// Deprecated: Use StoreStats.ProtoReflect.Descriptor instead.
+func (*StoreStats) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{53}
 }
 
-type VersionResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
-	BuildDate     string                 `protobuf:"bytes,2,opt,name=build_date,json=buildDate,proto3" json:"build_date,omitempty"` // RFC3339 (UTC) if available
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+func (x *StoreStats) GetMasterPresent() bool {
+	if x != nil {
+		return x.MasterPresent
+	}
+	return false
 }
 
-func (x *VersionResponse) Reset() {
-	*x = VersionResponse{}
-	mi := &file_signer_proto_msgTypes[16]
+func (x *StoreStats) GetDeterministic() bool {
+	if x != nil {
+		return x.Deterministic
+	}
+	return false
+}
+
+func (x *StoreStats) GetDataLocked() bool {
+	if x != nil {
+		return x.DataLocked
+	}
+	return false
+}
+
+func (x *StoreStats) GetKeys() uint32 {
+	if x != nil {
+		return x.Keys
+	}
+	return 0
+}
+
+func (x *StoreStats) GetUnlockedKeys() uint32 {
+	if x != nil {
+		return x.UnlockedKeys
+	}
+	return 0
+}
+
+func (x *StoreStats) GetCipher() string {
+	if x != nil {
+		return x.Cipher
+	}
+	return ""
+}
+
+func (x *StoreStats) GetKdfWeak() bool {
+	if x != nil {
+		return x.KdfWeak
+	}
+	return false
+}
+
+type DeviceInfoResponse struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Serial       string                 `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`                                 // tezsign_id, also the USB serial number
+	ImageFlavour string                 `protobuf:"bytes,2,opt,name=image_flavour,json=imageFlavour,proto3" json:"image_flavour,omitempty"` // e.g. rpi4, rpi0-2w, radxa-zero3
+	Version      string                 `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`                               // app image version
+	BuildDate    string                 `protobuf:"bytes,4,opt,name=build_date,json=buildDate,proto3" json:"build_date,omitempty"`          // RFC3339 (UTC) if available
+	Store        *StoreStats            `protobuf:"bytes,5,opt,name=store,proto3" json:"store,omitempty"`
+	// Optional capabilities of this build, e.g. "watermark_snapshots".
+	Features        []string `protobuf:"bytes,6,rep,name=features,proto3" json:"features,omitempty"`
+	SelfTestFailure string   `protobuf:"bytes,7,opt,name=self_test_failure,json=selfTestFailure,proto3" json:"self_test_failure,omitempty"` // as in StatusResponse
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DeviceInfoResponse) Reset() {
+	*x = DeviceInfoResponse{}
+	mi := &file_signer_proto_msgTypes[54]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *VersionResponse) String() string {
+func (x *DeviceInfoResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*VersionResponse) ProtoMessage() {}
+func (*DeviceInfoResponse) ProtoMessage() {}
 
-func (x *VersionResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[16]
+func (x *DeviceInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[54]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -964,49 +3213,83 @@ func (x *VersionResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use VersionResponse.ProtoReflect.Descriptor instead.
-func (*VersionResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{16}
+// Deprecated: Use DeviceInfoResponse.ProtoReflect.Descriptor instead.
+func (*DeviceInfoResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{54}
 }
 
-func (x *VersionResponse) GetVersion() string {
+func (x *DeviceInfoResponse) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *DeviceInfoResponse) GetImageFlavour() string {
+	if x != nil {
+		return x.ImageFlavour
+	}
+	return ""
+}
+
+func (x *DeviceInfoResponse) GetVersion() string {
 	if x != nil {
 		return x.Version
 	}
 	return ""
 }
 
-func (x *VersionResponse) GetBuildDate() string {
+func (x *DeviceInfoResponse) GetBuildDate() string {
 	if x != nil {
 		return x.BuildDate
 	}
 	return ""
 }
 
-// ---- init master ----
-type InitMasterRequest struct {
+func (x *DeviceInfoResponse) GetStore() *StoreStats {
+	if x != nil {
+		return x.Store
+	}
+	return nil
+}
+
+func (x *DeviceInfoResponse) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *DeviceInfoResponse) GetSelfTestFailure() string {
+	if x != nil {
+		return x.SelfTestFailure
+	}
+	return ""
+}
+
+// ---- entropy ----
+type GetEntropyRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Deterministic bool                   `protobuf:"varint,1,opt,name=deterministic,proto3" json:"deterministic,omitempty"` // true => HD mode; false => random-only mode
-	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`        // used to derive KEK and encrypt seed.bin
+	Length        uint32                 `protobuf:"varint,1,opt,name=length,proto3" json:"length,omitempty"` // bytes wanted, at most 4096
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *InitMasterRequest) Reset() {
-	*x = InitMasterRequest{}
-	mi := &file_signer_proto_msgTypes[17]
+func (x *GetEntropyRequest) Reset() {
+	*x = GetEntropyRequest{}
+	mi := &file_signer_proto_msgTypes[55]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *InitMasterRequest) String() string {
+func (x *GetEntropyRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*InitMasterRequest) ProtoMessage() {}
+func (*GetEntropyRequest) ProtoMessage() {}
 
-func (x *InitMasterRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[17]
+func (x *GetEntropyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[55]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1017,47 +3300,41 @@ func (x *InitMasterRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use InitMasterRequest.ProtoReflect.Descriptor instead.
-func (*InitMasterRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{17}
-}
-
-func (x *InitMasterRequest) GetDeterministic() bool {
-	if x != nil {
-		return x.Deterministic
-	}
-	return false
+// Deprecated: Use GetEntropyRequest.ProtoReflect.Descriptor instead.
+func (*GetEntropyRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{55}
 }
 
-func (x *InitMasterRequest) GetPassphrase() []byte {
+func (x *GetEntropyRequest) GetLength() uint32 {
 	if x != nil {
-		return x.Passphrase
+		return x.Length
 	}
-	return nil
+	return 0
 }
 
-// ---- init master info ----
-type InitInfoRequest struct {
+type GetEntropyResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	Hardware      bool                   `protobuf:"varint,2,opt,name=hardware,proto3" json:"hardware,omitempty"` // false if /dev/hwrng was unavailable and only the kernel CSPRNG was used
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *InitInfoRequest) Reset() {
-	*x = InitInfoRequest{}
-	mi := &file_signer_proto_msgTypes[18]
+func (x *GetEntropyResponse) Reset() {
+	*x = GetEntropyResponse{}
+	mi := &file_signer_proto_msgTypes[56]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *InitInfoRequest) String() string {
+func (x *GetEntropyResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*InitInfoRequest) ProtoMessage() {}
+func (*GetEntropyResponse) ProtoMessage() {}
 
-func (x *InitInfoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[18]
+func (x *GetEntropyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[56]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1068,34 +3345,50 @@ func (x *InitInfoRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use InitInfoRequest.ProtoReflect.Descriptor instead.
-func (*InitInfoRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{18}
+// Deprecated: Use GetEntropyResponse.ProtoReflect.Descriptor instead.
+func (*GetEntropyResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{56}
 }
 
-type InitInfoResponse struct {
-	state                protoimpl.MessageState `protogen:"open.v1"`
-	MasterPresent        bool                   `protobuf:"varint,1,opt,name=master_present,json=masterPresent,proto3" json:"master_present,omitempty"`                      // master.json exists
-	DeterministicEnabled bool                   `protobuf:"varint,2,opt,name=deterministic_enabled,json=deterministicEnabled,proto3" json:"deterministic_enabled,omitempty"` // first byte of seed.bin == 0x01 (only meaningful if seed_present==true)
-	unknownFields        protoimpl.UnknownFields
-	sizeCache            protoimpl.SizeCache
+func (x *GetEntropyResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
 }
 
-func (x *InitInfoResponse) Reset() {
-	*x = InitInfoResponse{}
-	mi := &file_signer_proto_msgTypes[19]
+func (x *GetEntropyResponse) GetHardware() bool {
+	if x != nil {
+		return x.Hardware
+	}
+	return false
+}
+
+// ---- time ----
+// The gadget has no RTC; hosts push their clock at session start. The gadget
+// only ever moves its clock forward.
+type SetTimeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UnixMs        int64                  `protobuf:"varint,1,opt,name=unix_ms,json=unixMs,proto3" json:"unix_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTimeRequest) Reset() {
+	*x = SetTimeRequest{}
+	mi := &file_signer_proto_msgTypes[57]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *InitInfoResponse) String() string {
+func (x *SetTimeRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*InitInfoResponse) ProtoMessage() {}
+func (*SetTimeRequest) ProtoMessage() {}
 
-func (x *InitInfoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[19]
+func (x *SetTimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[57]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1106,50 +3399,77 @@ func (x *InitInfoResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use InitInfoResponse.ProtoReflect.Descriptor instead.
-func (*InitInfoResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{19}
+// Deprecated: Use SetTimeRequest.ProtoReflect.Descriptor instead.
+func (*SetTimeRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{57}
 }
 
-func (x *InitInfoResponse) GetMasterPresent() bool {
+func (x *SetTimeRequest) GetUnixMs() int64 {
 	if x != nil {
-		return x.MasterPresent
+		return x.UnixMs
 	}
-	return false
+	return 0
 }
 
-func (x *InitInfoResponse) GetDeterministicEnabled() bool {
+type GetTimeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTimeRequest) Reset() {
+	*x = GetTimeRequest{}
+	mi := &file_signer_proto_msgTypes[58]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTimeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTimeRequest) ProtoMessage() {}
+
+func (x *GetTimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[58]
 	if x != nil {
-		return x.DeterministicEnabled
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return false
+	return mi.MessageOf(x)
 }
 
-// ---- set level ----
-// When executed, round is reset to zero on disk.
-type SetLevelRequest struct {
+// Deprecated: Use GetTimeRequest.ProtoReflect.Descriptor instead.
+func (*GetTimeRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{58}
+}
+
+type TimeResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
-	Level         uint64                 `protobuf:"varint,3,opt,name=level,proto3" json:"level,omitempty"`
+	UnixMs        int64                  `protobuf:"varint,1,opt,name=unix_ms,json=unixMs,proto3" json:"unix_ms,omitempty"` // gadget clock after the request
+	Synced        bool                   `protobuf:"varint,2,opt,name=synced,proto3" json:"synced,omitempty"`               // a host has set the clock since boot
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SetLevelRequest) Reset() {
-	*x = SetLevelRequest{}
-	mi := &file_signer_proto_msgTypes[20]
+func (x *TimeResponse) Reset() {
+	*x = TimeResponse{}
+	mi := &file_signer_proto_msgTypes[59]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SetLevelRequest) String() string {
+func (x *TimeResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SetLevelRequest) ProtoMessage() {}
+func (*TimeResponse) ProtoMessage() {}
 
-func (x *SetLevelRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[20]
+func (x *TimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[59]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1160,49 +3480,55 @@ func (x *SetLevelRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SetLevelRequest.ProtoReflect.Descriptor instead.
-func (*SetLevelRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{20}
+// Deprecated: Use TimeResponse.ProtoReflect.Descriptor instead.
+func (*TimeResponse) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{59}
 }
 
-func (x *SetLevelRequest) GetKeyId() string {
+func (x *TimeResponse) GetUnixMs() int64 {
 	if x != nil {
-		return x.KeyId
+		return x.UnixMs
 	}
-	return ""
+	return 0
 }
 
-func (x *SetLevelRequest) GetLevel() uint64 {
+func (x *TimeResponse) GetSynced() bool {
 	if x != nil {
-		return x.Level
+		return x.Synced
 	}
-	return 0
+	return false
 }
 
-// ---- delete keys ----
-type DeleteKeysRequest struct {
+// ---- threshold shares ----
+// A Shamir share of a group BLS key, split by a trusted dealer. The share is
+// an ordinary key on the gadget (own tz4, watermarks, policy); hosts combine
+// threshold partial signatures into the group signature.
+type ThresholdShare struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	KeyIds        []string               `protobuf:"bytes,1,rep,name=key_ids,json=keyIds,proto3" json:"key_ids,omitempty"`
-	Passphrase    []byte                 `protobuf:"bytes,2,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	GroupPubkey   string                 `protobuf:"bytes,1,opt,name=group_pubkey,json=groupPubkey,proto3" json:"group_pubkey,omitempty"` // BLpk… of the shared key
+	GroupTz4      string                 `protobuf:"bytes,2,opt,name=group_tz4,json=groupTz4,proto3" json:"group_tz4,omitempty"`
+	Index         uint32                 `protobuf:"varint,3,opt,name=index,proto3" json:"index,omitempty"` // 1-based x coordinate
+	Threshold     uint32                 `protobuf:"varint,4,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	Total         uint32                 `protobuf:"varint,5,opt,name=total,proto3" json:"total,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *DeleteKeysRequest) Reset() {
-	*x = DeleteKeysRequest{}
-	mi := &file_signer_proto_msgTypes[21]
+func (x *ThresholdShare) Reset() {
+	*x = ThresholdShare{}
+	mi := &file_signer_proto_msgTypes[60]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *DeleteKeysRequest) String() string {
+func (x *ThresholdShare) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DeleteKeysRequest) ProtoMessage() {}
+func (*ThresholdShare) ProtoMessage() {}
 
-func (x *DeleteKeysRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[21]
+func (x *ThresholdShare) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[60]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1213,47 +3539,73 @@ func (x *DeleteKeysRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DeleteKeysRequest.ProtoReflect.Descriptor instead.
-func (*DeleteKeysRequest) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{21}
+// Deprecated: Use ThresholdShare.ProtoReflect.Descriptor instead.
+func (*ThresholdShare) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{60}
 }
 
-func (x *DeleteKeysRequest) GetKeyIds() []string {
+func (x *ThresholdShare) GetGroupPubkey() string {
 	if x != nil {
-		return x.KeyIds
+		return x.GroupPubkey
 	}
-	return nil
+	return ""
 }
 
-func (x *DeleteKeysRequest) GetPassphrase() []byte {
+func (x *ThresholdShare) GetGroupTz4() string {
 	if x != nil {
-		return x.Passphrase
+		return x.GroupTz4
 	}
-	return nil
+	return ""
 }
 
-type DeleteKeysResponse struct {
+func (x *ThresholdShare) GetIndex() uint32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *ThresholdShare) GetThreshold() uint32 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+func (x *ThresholdShare) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+// Answered with new_key (one result).
+type ImportKeyShareRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Results       []*PerKeyResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	KeyId         string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`             // empty auto-assigns
+	SecretKey     string                 `protobuf:"bytes,2,opt,name=secret_key,json=secretKey,proto3" json:"secret_key,omitempty"` // BLsk… of the share
+	Share         *ThresholdShare        `protobuf:"bytes,3,opt,name=share,proto3" json:"share,omitempty"`
+	Passphrase    []byte                 `protobuf:"bytes,4,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	KeyPassphrase []byte                 `protobuf:"bytes,5,opt,name=key_passphrase,json=keyPassphrase,proto3" json:"key_passphrase,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *DeleteKeysResponse) Reset() {
-	*x = DeleteKeysResponse{}
-	mi := &file_signer_proto_msgTypes[22]
+func (x *ImportKeyShareRequest) Reset() {
+	*x = ImportKeyShareRequest{}
+	mi := &file_signer_proto_msgTypes[61]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *DeleteKeysResponse) String() string {
+func (x *ImportKeyShareRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DeleteKeysResponse) ProtoMessage() {}
+func (*ImportKeyShareRequest) ProtoMessage() {}
 
-func (x *DeleteKeysResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[22]
+func (x *ImportKeyShareRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[61]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1264,14 +3616,42 @@ func (x *DeleteKeysResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DeleteKeysResponse.ProtoReflect.Descriptor instead.
-func (*DeleteKeysResponse) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{22}
+// Deprecated: Use ImportKeyShareRequest.ProtoReflect.Descriptor instead.
+func (*ImportKeyShareRequest) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{61}
 }
 
-func (x *DeleteKeysResponse) GetResults() []*PerKeyResult {
+func (x *ImportKeyShareRequest) GetKeyId() string {
 	if x != nil {
-		return x.Results
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *ImportKeyShareRequest) GetSecretKey() string {
+	if x != nil {
+		return x.SecretKey
+	}
+	return ""
+}
+
+func (x *ImportKeyShareRequest) GetShare() *ThresholdShare {
+	if x != nil {
+		return x.Share
+	}
+	return nil
+}
+
+func (x *ImportKeyShareRequest) GetPassphrase() []byte {
+	if x != nil {
+		return x.Passphrase
+	}
+	return nil
+}
+
+func (x *ImportKeyShareRequest) GetKeyPassphrase() []byte {
+	if x != nil {
+		return x.KeyPassphrase
 	}
 	return nil
 }
@@ -1285,7 +3665,7 @@ type Ok struct {
 
 func (x *Ok) Reset() {
 	*x = Ok{}
-	mi := &file_signer_proto_msgTypes[23]
+	mi := &file_signer_proto_msgTypes[62]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1297,7 +3677,7 @@ func (x *Ok) String() string {
 func (*Ok) ProtoMessage() {}
 
 func (x *Ok) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[23]
+	mi := &file_signer_proto_msgTypes[62]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1310,7 +3690,7 @@ func (x *Ok) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Ok.ProtoReflect.Descriptor instead.
 func (*Ok) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{23}
+	return file_signer_proto_rawDescGZIP(), []int{62}
 }
 
 func (x *Ok) GetOk() bool {
@@ -1330,7 +3710,7 @@ type Error struct {
 
 func (x *Error) Reset() {
 	*x = Error{}
-	mi := &file_signer_proto_msgTypes[24]
+	mi := &file_signer_proto_msgTypes[63]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1342,7 +3722,7 @@ func (x *Error) String() string {
 func (*Error) ProtoMessage() {}
 
 func (x *Error) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[24]
+	mi := &file_signer_proto_msgTypes[63]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1355,7 +3735,7 @@ func (x *Error) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Error.ProtoReflect.Descriptor instead.
 func (*Error) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{24}
+	return file_signer_proto_rawDescGZIP(), []int{63}
 }
 
 func (x *Error) GetCode() uint32 {
@@ -1387,6 +3767,26 @@ type Request struct {
 	//	*Request_SetLevel
 	//	*Request_DeleteKeys
 	//	*Request_Version
+	//	*Request_SetTags
+	//	*Request_SetValidity
+	//	*Request_ExportWatermarks
+	//	*Request_ImportWatermarks
+	//	*Request_KdfStatus
+	//	*Request_UpgradeKdf
+	//	*Request_DeviceInfo
+	//	*Request_GetPublicKey
+	//	*Request_GetEntropy
+	//	*Request_SetTime
+	//	*Request_GetTime
+	//	*Request_SetPolicy
+	//	*Request_GetPolicy
+	//	*Request_GetWatermarks
+	//	*Request_ImportKeyShare
+	//	*Request_RegeneratePop
+	//	*Request_SetLogLevel
+	//	*Request_BeginUpdate
+	//	*Request_UpdateChunk
+	//	*Request_CommitUpdate
 	Payload       isRequest_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -1394,135 +3794,315 @@ type Request struct {
 
 func (x *Request) Reset() {
 	*x = Request{}
-	mi := &file_signer_proto_msgTypes[25]
+	mi := &file_signer_proto_msgTypes[64]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *Request) String() string {
-	return protoimpl.X.MessageStringOf(x)
+func (x *Request) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Request) ProtoMessage() {}
+
+func (x *Request) ProtoReflect() protoreflect.Message {
+	mi := &file_signer_proto_msgTypes[64]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Request.ProtoReflect.Descriptor instead.
+func (*Request) Descriptor() ([]byte, []int) {
+	return file_signer_proto_rawDescGZIP(), []int{64}
+}
+
+func (x *Request) GetPayload() isRequest_Payload {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Request) GetUnlock() *UnlockRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Unlock); ok {
+			return x.Unlock
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetLock() *LockRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Lock); ok {
+			return x.Lock
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetStatus() *StatusRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Status); ok {
+			return x.Status
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetSign() *SignRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Sign); ok {
+			return x.Sign
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetNewKeys() *NewKeysRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_NewKeys); ok {
+			return x.NewKeys
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetLogs() *LogsRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Logs); ok {
+			return x.Logs
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetInitMaster() *InitMasterRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_InitMaster); ok {
+			return x.InitMaster
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetInitInfo() *InitInfoRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_InitInfo); ok {
+			return x.InitInfo
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetSetLevel() *SetLevelRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_SetLevel); ok {
+			return x.SetLevel
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetDeleteKeys() *DeleteKeysRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_DeleteKeys); ok {
+			return x.DeleteKeys
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetVersion() *VersionRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_Version); ok {
+			return x.Version
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetSetTags() *SetTagsRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_SetTags); ok {
+			return x.SetTags
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetSetValidity() *SetValidityRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_SetValidity); ok {
+			return x.SetValidity
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetExportWatermarks() *ExportWatermarksRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_ExportWatermarks); ok {
+			return x.ExportWatermarks
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetImportWatermarks() *ImportWatermarksRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_ImportWatermarks); ok {
+			return x.ImportWatermarks
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetKdfStatus() *KDFStatusRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_KdfStatus); ok {
+			return x.KdfStatus
+		}
+	}
+	return nil
+}
+
+func (x *Request) GetUpgradeKdf() *UpgradeKDFRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_UpgradeKdf); ok {
+			return x.UpgradeKdf
+		}
+	}
+	return nil
 }
 
-func (*Request) ProtoMessage() {}
-
-func (x *Request) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[25]
+func (x *Request) GetDeviceInfo() *DeviceInfoRequest {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
+		if x, ok := x.Payload.(*Request_DeviceInfo); ok {
+			return x.DeviceInfo
 		}
-		return ms
 	}
-	return mi.MessageOf(x)
+	return nil
 }
 
-// Deprecated: Use Request.ProtoReflect.Descriptor instead.
-func (*Request) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{25}
+func (x *Request) GetGetPublicKey() *GetPublicKeyRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*Request_GetPublicKey); ok {
+			return x.GetPublicKey
+		}
+	}
+	return nil
 }
 
-func (x *Request) GetPayload() isRequest_Payload {
+func (x *Request) GetGetEntropy() *GetEntropyRequest {
 	if x != nil {
-		return x.Payload
+		if x, ok := x.Payload.(*Request_GetEntropy); ok {
+			return x.GetEntropy
+		}
 	}
 	return nil
 }
 
-func (x *Request) GetUnlock() *UnlockRequest {
+func (x *Request) GetSetTime() *SetTimeRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Unlock); ok {
-			return x.Unlock
+		if x, ok := x.Payload.(*Request_SetTime); ok {
+			return x.SetTime
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetLock() *LockRequest {
+func (x *Request) GetGetTime() *GetTimeRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Lock); ok {
-			return x.Lock
+		if x, ok := x.Payload.(*Request_GetTime); ok {
+			return x.GetTime
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetStatus() *StatusRequest {
+func (x *Request) GetSetPolicy() *SetPolicyRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Status); ok {
-			return x.Status
+		if x, ok := x.Payload.(*Request_SetPolicy); ok {
+			return x.SetPolicy
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetSign() *SignRequest {
+func (x *Request) GetGetPolicy() *GetPolicyRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Sign); ok {
-			return x.Sign
+		if x, ok := x.Payload.(*Request_GetPolicy); ok {
+			return x.GetPolicy
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetNewKeys() *NewKeysRequest {
+func (x *Request) GetGetWatermarks() *GetWatermarksRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_NewKeys); ok {
-			return x.NewKeys
+		if x, ok := x.Payload.(*Request_GetWatermarks); ok {
+			return x.GetWatermarks
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetLogs() *LogsRequest {
+func (x *Request) GetImportKeyShare() *ImportKeyShareRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Logs); ok {
-			return x.Logs
+		if x, ok := x.Payload.(*Request_ImportKeyShare); ok {
+			return x.ImportKeyShare
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetInitMaster() *InitMasterRequest {
+func (x *Request) GetRegeneratePop() *RegeneratePoPRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_InitMaster); ok {
-			return x.InitMaster
+		if x, ok := x.Payload.(*Request_RegeneratePop); ok {
+			return x.RegeneratePop
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetInitInfo() *InitInfoRequest {
+func (x *Request) GetSetLogLevel() *SetLogLevelRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_InitInfo); ok {
-			return x.InitInfo
+		if x, ok := x.Payload.(*Request_SetLogLevel); ok {
+			return x.SetLogLevel
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetSetLevel() *SetLevelRequest {
+func (x *Request) GetBeginUpdate() *BeginUpdateRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_SetLevel); ok {
-			return x.SetLevel
+		if x, ok := x.Payload.(*Request_BeginUpdate); ok {
+			return x.BeginUpdate
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetDeleteKeys() *DeleteKeysRequest {
+func (x *Request) GetUpdateChunk() *UpdateChunkRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_DeleteKeys); ok {
-			return x.DeleteKeys
+		if x, ok := x.Payload.(*Request_UpdateChunk); ok {
+			return x.UpdateChunk
 		}
 	}
 	return nil
 }
 
-func (x *Request) GetVersion() *VersionRequest {
+func (x *Request) GetCommitUpdate() *CommitUpdateRequest {
 	if x != nil {
-		if x, ok := x.Payload.(*Request_Version); ok {
-			return x.Version
+		if x, ok := x.Payload.(*Request_CommitUpdate); ok {
+			return x.CommitUpdate
 		}
 	}
 	return nil
@@ -1576,6 +4156,86 @@ type Request_Version struct {
 	Version *VersionRequest `protobuf:"bytes,11,opt,name=version,proto3,oneof"`
 }
 
+type Request_SetTags struct {
+	SetTags *SetTagsRequest `protobuf:"bytes,12,opt,name=set_tags,json=setTags,proto3,oneof"`
+}
+
+type Request_SetValidity struct {
+	SetValidity *SetValidityRequest `protobuf:"bytes,13,opt,name=set_validity,json=setValidity,proto3,oneof"`
+}
+
+type Request_ExportWatermarks struct {
+	ExportWatermarks *ExportWatermarksRequest `protobuf:"bytes,14,opt,name=export_watermarks,json=exportWatermarks,proto3,oneof"`
+}
+
+type Request_ImportWatermarks struct {
+	ImportWatermarks *ImportWatermarksRequest `protobuf:"bytes,15,opt,name=import_watermarks,json=importWatermarks,proto3,oneof"`
+}
+
+type Request_KdfStatus struct {
+	KdfStatus *KDFStatusRequest `protobuf:"bytes,16,opt,name=kdf_status,json=kdfStatus,proto3,oneof"`
+}
+
+type Request_UpgradeKdf struct {
+	UpgradeKdf *UpgradeKDFRequest `protobuf:"bytes,17,opt,name=upgrade_kdf,json=upgradeKdf,proto3,oneof"`
+}
+
+type Request_DeviceInfo struct {
+	DeviceInfo *DeviceInfoRequest `protobuf:"bytes,18,opt,name=device_info,json=deviceInfo,proto3,oneof"`
+}
+
+type Request_GetPublicKey struct {
+	GetPublicKey *GetPublicKeyRequest `protobuf:"bytes,19,opt,name=get_public_key,json=getPublicKey,proto3,oneof"`
+}
+
+type Request_GetEntropy struct {
+	GetEntropy *GetEntropyRequest `protobuf:"bytes,20,opt,name=get_entropy,json=getEntropy,proto3,oneof"`
+}
+
+type Request_SetTime struct {
+	SetTime *SetTimeRequest `protobuf:"bytes,21,opt,name=set_time,json=setTime,proto3,oneof"`
+}
+
+type Request_GetTime struct {
+	GetTime *GetTimeRequest `protobuf:"bytes,22,opt,name=get_time,json=getTime,proto3,oneof"`
+}
+
+type Request_SetPolicy struct {
+	SetPolicy *SetPolicyRequest `protobuf:"bytes,23,opt,name=set_policy,json=setPolicy,proto3,oneof"`
+}
+
+type Request_GetPolicy struct {
+	GetPolicy *GetPolicyRequest `protobuf:"bytes,24,opt,name=get_policy,json=getPolicy,proto3,oneof"`
+}
+
+type Request_GetWatermarks struct {
+	GetWatermarks *GetWatermarksRequest `protobuf:"bytes,25,opt,name=get_watermarks,json=getWatermarks,proto3,oneof"`
+}
+
+type Request_ImportKeyShare struct {
+	ImportKeyShare *ImportKeyShareRequest `protobuf:"bytes,26,opt,name=import_key_share,json=importKeyShare,proto3,oneof"`
+}
+
+type Request_RegeneratePop struct {
+	RegeneratePop *RegeneratePoPRequest `protobuf:"bytes,27,opt,name=regenerate_pop,json=regeneratePop,proto3,oneof"`
+}
+
+type Request_SetLogLevel struct {
+	SetLogLevel *SetLogLevelRequest `protobuf:"bytes,28,opt,name=set_log_level,json=setLogLevel,proto3,oneof"`
+}
+
+type Request_BeginUpdate struct {
+	BeginUpdate *BeginUpdateRequest `protobuf:"bytes,29,opt,name=begin_update,json=beginUpdate,proto3,oneof"`
+}
+
+type Request_UpdateChunk struct {
+	UpdateChunk *UpdateChunkRequest `protobuf:"bytes,30,opt,name=update_chunk,json=updateChunk,proto3,oneof"`
+}
+
+type Request_CommitUpdate struct {
+	CommitUpdate *CommitUpdateRequest `protobuf:"bytes,31,opt,name=commit_update,json=commitUpdate,proto3,oneof"`
+}
+
 func (*Request_Unlock) isRequest_Payload() {}
 
 func (*Request_Lock) isRequest_Payload() {}
@@ -1598,6 +4258,46 @@ func (*Request_DeleteKeys) isRequest_Payload() {}
 
 func (*Request_Version) isRequest_Payload() {}
 
+func (*Request_SetTags) isRequest_Payload() {}
+
+func (*Request_SetValidity) isRequest_Payload() {}
+
+func (*Request_ExportWatermarks) isRequest_Payload() {}
+
+func (*Request_ImportWatermarks) isRequest_Payload() {}
+
+func (*Request_KdfStatus) isRequest_Payload() {}
+
+func (*Request_UpgradeKdf) isRequest_Payload() {}
+
+func (*Request_DeviceInfo) isRequest_Payload() {}
+
+func (*Request_GetPublicKey) isRequest_Payload() {}
+
+func (*Request_GetEntropy) isRequest_Payload() {}
+
+func (*Request_SetTime) isRequest_Payload() {}
+
+func (*Request_GetTime) isRequest_Payload() {}
+
+func (*Request_SetPolicy) isRequest_Payload() {}
+
+func (*Request_GetPolicy) isRequest_Payload() {}
+
+func (*Request_GetWatermarks) isRequest_Payload() {}
+
+func (*Request_ImportKeyShare) isRequest_Payload() {}
+
+func (*Request_RegeneratePop) isRequest_Payload() {}
+
+func (*Request_SetLogLevel) isRequest_Payload() {}
+
+func (*Request_BeginUpdate) isRequest_Payload() {}
+
+func (*Request_UpdateChunk) isRequest_Payload() {}
+
+func (*Request_CommitUpdate) isRequest_Payload() {}
+
 type Response struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Types that are valid to be assigned to Payload:
@@ -1611,6 +4311,18 @@ type Response struct {
 	//	*Response_InitInfo
 	//	*Response_DeleteKeys
 	//	*Response_Version
+	//	*Response_SetTags
+	//	*Response_ExportWatermarks
+	//	*Response_ImportWatermarks
+	//	*Response_KdfStatus
+	//	*Response_DeviceInfo
+	//	*Response_GetPublicKey
+	//	*Response_GetEntropy
+	//	*Response_Time
+	//	*Response_Policy
+	//	*Response_GetWatermarks
+	//	*Response_SetLogLevel
+	//	*Response_UpdateUpload
 	//	*Response_Ok
 	//	*Response_Error
 	Payload       isResponse_Payload `protobuf_oneof:"payload"`
@@ -1620,7 +4332,7 @@ type Response struct {
 
 func (x *Response) Reset() {
 	*x = Response{}
-	mi := &file_signer_proto_msgTypes[26]
+	mi := &file_signer_proto_msgTypes[65]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1632,7 +4344,7 @@ func (x *Response) String() string {
 func (*Response) ProtoMessage() {}
 
 func (x *Response) ProtoReflect() protoreflect.Message {
-	mi := &file_signer_proto_msgTypes[26]
+	mi := &file_signer_proto_msgTypes[65]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1645,7 +4357,7 @@ func (x *Response) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Response.ProtoReflect.Descriptor instead.
 func (*Response) Descriptor() ([]byte, []int) {
-	return file_signer_proto_rawDescGZIP(), []int{26}
+	return file_signer_proto_rawDescGZIP(), []int{65}
 }
 
 func (x *Response) GetPayload() isResponse_Payload {
@@ -1736,6 +4448,114 @@ func (x *Response) GetVersion() *VersionResponse {
 	return nil
 }
 
+func (x *Response) GetSetTags() *SetTagsResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_SetTags); ok {
+			return x.SetTags
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetExportWatermarks() *ExportWatermarksResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_ExportWatermarks); ok {
+			return x.ExportWatermarks
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetImportWatermarks() *ImportWatermarksResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_ImportWatermarks); ok {
+			return x.ImportWatermarks
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetKdfStatus() *KDFStatusResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_KdfStatus); ok {
+			return x.KdfStatus
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetDeviceInfo() *DeviceInfoResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_DeviceInfo); ok {
+			return x.DeviceInfo
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetGetPublicKey() *GetPublicKeyResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_GetPublicKey); ok {
+			return x.GetPublicKey
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetGetEntropy() *GetEntropyResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_GetEntropy); ok {
+			return x.GetEntropy
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetTime() *TimeResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Time); ok {
+			return x.Time
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetPolicy() *PolicyResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_Policy); ok {
+			return x.Policy
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetGetWatermarks() *GetWatermarksResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_GetWatermarks); ok {
+			return x.GetWatermarks
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetSetLogLevel() *SetLogLevelResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_SetLogLevel); ok {
+			return x.SetLogLevel
+		}
+	}
+	return nil
+}
+
+func (x *Response) GetUpdateUpload() *UpdateUploadResponse {
+	if x != nil {
+		if x, ok := x.Payload.(*Response_UpdateUpload); ok {
+			return x.UpdateUpload
+		}
+	}
+	return nil
+}
+
 func (x *Response) GetOk() *Ok {
 	if x != nil {
 		if x, ok := x.Payload.(*Response_Ok); ok {
@@ -1775,7 +4595,7 @@ type Response_Sign struct {
 }
 
 type Response_NewKey struct {
-	NewKey *NewKeysResponse `protobuf:"bytes,5,opt,name=new_key,json=newKey,proto3,oneof"`
+	NewKey *NewKeysResponse `protobuf:"bytes,5,opt,name=new_key,json=newKey,proto3,oneof"` // for new_keys & import_key_share
 }
 
 type Response_Logs struct {
@@ -1794,8 +4614,56 @@ type Response_Version struct {
 	Version *VersionResponse `protobuf:"bytes,9,opt,name=version,proto3,oneof"`
 }
 
+type Response_SetTags struct {
+	SetTags *SetTagsResponse `protobuf:"bytes,10,opt,name=set_tags,json=setTags,proto3,oneof"`
+}
+
+type Response_ExportWatermarks struct {
+	ExportWatermarks *ExportWatermarksResponse `protobuf:"bytes,11,opt,name=export_watermarks,json=exportWatermarks,proto3,oneof"`
+}
+
+type Response_ImportWatermarks struct {
+	ImportWatermarks *ImportWatermarksResponse `protobuf:"bytes,12,opt,name=import_watermarks,json=importWatermarks,proto3,oneof"`
+}
+
+type Response_KdfStatus struct {
+	KdfStatus *KDFStatusResponse `protobuf:"bytes,13,opt,name=kdf_status,json=kdfStatus,proto3,oneof"`
+}
+
+type Response_DeviceInfo struct {
+	DeviceInfo *DeviceInfoResponse `protobuf:"bytes,17,opt,name=device_info,json=deviceInfo,proto3,oneof"`
+}
+
+type Response_GetPublicKey struct {
+	GetPublicKey *GetPublicKeyResponse `protobuf:"bytes,18,opt,name=get_public_key,json=getPublicKey,proto3,oneof"` // for get_public_key & regenerate_pop
+}
+
+type Response_GetEntropy struct {
+	GetEntropy *GetEntropyResponse `protobuf:"bytes,19,opt,name=get_entropy,json=getEntropy,proto3,oneof"`
+}
+
+type Response_Time struct {
+	Time *TimeResponse `protobuf:"bytes,20,opt,name=time,proto3,oneof"` // for set_time & get_time
+}
+
+type Response_Policy struct {
+	Policy *PolicyResponse `protobuf:"bytes,21,opt,name=policy,proto3,oneof"` // for set_policy & get_policy
+}
+
+type Response_GetWatermarks struct {
+	GetWatermarks *GetWatermarksResponse `protobuf:"bytes,22,opt,name=get_watermarks,json=getWatermarks,proto3,oneof"`
+}
+
+type Response_SetLogLevel struct {
+	SetLogLevel *SetLogLevelResponse `protobuf:"bytes,23,opt,name=set_log_level,json=setLogLevel,proto3,oneof"`
+}
+
+type Response_UpdateUpload struct {
+	UpdateUpload *UpdateUploadResponse `protobuf:"bytes,24,opt,name=update_upload,json=updateUpload,proto3,oneof"` // for begin_update & update_chunk
+}
+
 type Response_Ok struct {
-	Ok *Ok `protobuf:"bytes,15,opt,name=ok,proto3,oneof"` // for init_master & set_level
+	Ok *Ok `protobuf:"bytes,15,opt,name=ok,proto3,oneof"` // for init_master, set_level, upgrade_kdf & commit_update
 }
 
 type Response_Error struct {
@@ -1820,6 +4688,30 @@ func (*Response_DeleteKeys) isResponse_Payload() {}
 
 func (*Response_Version) isResponse_Payload() {}
 
+func (*Response_SetTags) isResponse_Payload() {}
+
+func (*Response_ExportWatermarks) isResponse_Payload() {}
+
+func (*Response_ImportWatermarks) isResponse_Payload() {}
+
+func (*Response_KdfStatus) isResponse_Payload() {}
+
+func (*Response_DeviceInfo) isResponse_Payload() {}
+
+func (*Response_GetPublicKey) isResponse_Payload() {}
+
+func (*Response_GetEntropy) isResponse_Payload() {}
+
+func (*Response_Time) isResponse_Payload() {}
+
+func (*Response_Policy) isResponse_Payload() {}
+
+func (*Response_GetWatermarks) isResponse_Payload() {}
+
+func (*Response_SetLogLevel) isResponse_Payload() {}
+
+func (*Response_UpdateUpload) isResponse_Payload() {}
+
 func (*Response_Ok) isResponse_Payload() {}
 
 func (*Response_Error) isResponse_Payload() {}
@@ -1832,25 +4724,39 @@ const file_signer_proto_rawDesc = "" +
 	"\fPerKeyResult\x12\x15\n" +
 	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x0e\n" +
 	"\x02ok\x18\x02 \x01(\bR\x02ok\x12\x14\n" +
-	"\x05error\x18\x03 \x01(\tR\x05error\"H\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\xdf\x01\n" +
 	"\rUnlockRequest\x12\x17\n" +
 	"\akey_ids\x18\x01 \x03(\tR\x06keyIds\x12\x1e\n" +
 	"\n" +
 	"passphrase\x18\x02 \x01(\fR\n" +
-	"passphrase\"@\n" +
+	"passphrase\x12R\n" +
+	"\x0fkey_passphrases\x18\x03 \x03(\v2).signer.UnlockRequest.KeyPassphrasesEntryR\x0ekeyPassphrases\x1aA\n" +
+	"\x13KeyPassphrasesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01\"@\n" +
 	"\x0eUnlockResponse\x12.\n" +
 	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"&\n" +
 	"\vLockRequest\x12\x17\n" +
 	"\akey_ids\x18\x01 \x03(\tR\x06keyIds\">\n" +
 	"\fLockResponse\x12.\n" +
-	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\xf6\x03\n" +
+	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\x80\x01\n" +
+	"\bValidity\x12\x1d\n" +
+	"\n" +
+	"not_before\x18\x01 \x01(\x03R\tnotBefore\x12\x1b\n" +
+	"\tnot_after\x18\x02 \x01(\x03R\bnotAfter\x12\x1b\n" +
+	"\tmin_level\x18\x03 \x01(\x04R\bminLevel\x12\x1b\n" +
+	"\tmax_level\x18\x04 \x01(\x04R\bmaxLevel\"\xa3\x06\n" +
 	"\tKeyStatus\x12\x15\n" +
 	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x120\n" +
 	"\n" +
 	"lock_state\x18\x02 \x01(\x0e2\x11.signer.LockStateR\tlockState\x12\x10\n" +
 	"\x03tz4\x18\x03 \x01(\tR\x03tz4\x12\x1b\n" +
 	"\tbl_pubkey\x18\x04 \x01(\tR\bblPubkey\x12\x10\n" +
-	"\x03pop\x18\x05 \x01(\tR\x03pop\x12(\n" +
+	"\x03pop\x18\x05 \x01(\tR\x03pop\x12%\n" +
+	"\x0ekey_passphrase\x18\x06 \x01(\bR\rkeyPassphrase\x12/\n" +
+	"\x04tags\x18\a \x03(\v2\x1b.signer.KeyStatus.TagsEntryR\x04tags\x12,\n" +
+	"\bvalidity\x18\b \x01(\v2\x10.signer.ValidityR\bvalidity\x12'\n" +
+	"\x0fderivation_path\x18\t \x01(\tR\x0ederivationPath\x12(\n" +
 	"\x10last_block_level\x18\n" +
 	" \x01(\x04R\x0elastBlockLevel\x12:\n" +
 	"\x19last_preattestation_level\x18\v \x01(\x04R\x17lastPreattestationLevel\x124\n" +
@@ -1858,10 +4764,27 @@ const file_signer_proto_rawDesc = "" +
 	"\x10last_block_round\x18\x14 \x01(\rR\x0elastBlockRound\x12:\n" +
 	"\x19last_preattestation_round\x18\x15 \x01(\rR\x17lastPreattestationRound\x124\n" +
 	"\x16last_attestation_round\x18\x16 \x01(\rR\x14lastAttestationRound\x12'\n" +
-	"\x0fstate_corrupted\x18\x1e \x01(\bR\x0estateCorrupted\"\x0f\n" +
-	"\rStatusRequest\"7\n" +
+	"\x0fstate_corrupted\x18\x1e \x01(\bR\x0estateCorrupted\x12\x1d\n" +
+	"\n" +
+	"sign_count\x18( \x01(\x04R\tsignCount\x12$\n" +
+	"\x0elast_used_unix\x18) \x01(\x03R\flastUsedUnix\x1a7\n" +
+	"\tTagsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x0f\n" +
+	"\rStatusRequest\"c\n" +
 	"\x0eStatusResponse\x12%\n" +
-	"\x04keys\x18\x01 \x03(\v2\x11.signer.KeyStatusR\x04keys\"9\n" +
+	"\x04keys\x18\x01 \x03(\v2\x11.signer.KeyStatusR\x04keys\x12*\n" +
+	"\x11self_test_failure\x18\x02 \x01(\tR\x0fselfTestFailure\"'\n" +
+	"\x13GetPublicKeyRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"-\n" +
+	"\x14RegeneratePoPRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"\x9c\x01\n" +
+	"\x14GetPublicKeyResponse\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x10\n" +
+	"\x03tz4\x18\x02 \x01(\tR\x03tz4\x12\x1b\n" +
+	"\tbl_pubkey\x18\x03 \x01(\tR\bblPubkey\x12\x10\n" +
+	"\x03pop\x18\x04 \x01(\tR\x03pop\x12,\n" +
+	"\x05share\x18\x05 \x01(\v2\x16.signer.ThresholdShareR\x05share\"9\n" +
 	"\vSignRequest\x12\x10\n" +
 	"\x03tz4\x18\x01 \x01(\tR\x03tz4\x12\x18\n" +
 	"\amessage\x18\x02 \x01(\fR\amessage\",\n" +
@@ -1872,32 +4795,56 @@ const file_signer_proto_rawDesc = "" +
 	"\tbl_pubkey\x18\x02 \x01(\tR\bblPubkey\x12\x10\n" +
 	"\x03tz4\x18\x03 \x01(\tR\x03tz4\x12\x0e\n" +
 	"\x02ok\x18\x04 \x01(\bR\x02ok\x12\x14\n" +
-	"\x05error\x18\x05 \x01(\tR\x05error\"I\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\x99\x01\n" +
 	"\x0eNewKeysRequest\x12\x17\n" +
 	"\akey_ids\x18\x01 \x03(\tR\x06keyIds\x12\x1e\n" +
 	"\n" +
 	"passphrase\x18\x02 \x01(\fR\n" +
-	"passphrase\"G\n" +
+	"passphrase\x12%\n" +
+	"\x0ekey_passphrase\x18\x03 \x01(\fR\rkeyPassphrase\x12'\n" +
+	"\x0fderivation_path\x18\x04 \x01(\tR\x0ederivationPath\"G\n" +
 	"\x0fNewKeysResponse\x124\n" +
-	"\aresults\x18\x01 \x03(\v2\x1a.signer.NewKeyPerKeyResultR\aresults\"#\n" +
+	"\aresults\x18\x01 \x03(\v2\x1a.signer.NewKeyPerKeyResultR\aresults\"@\n" +
 	"\vLogsRequest\x12\x14\n" +
-	"\x05limit\x18\x01 \x01(\rR\x05limit\"$\n" +
+	"\x05limit\x18\x01 \x01(\rR\x05limit\x12\x1b\n" +
+	"\tfrom_file\x18\x02 \x01(\bR\bfromFile\"<\n" +
 	"\fLogsResponse\x12\x14\n" +
-	"\x05lines\x18\x01 \x03(\tR\x05lines\"\x10\n" +
+	"\x05lines\x18\x01 \x03(\tR\x05lines\x12\x16\n" +
+	"\x06source\x18\x02 \x01(\tR\x06source\"(\n" +
+	"\x12SetLogLevelRequest\x12\x12\n" +
+	"\x04spec\x18\x01 \x01(\tR\x04spec\"-\n" +
+	"\x13SetLogLevelResponse\x12\x16\n" +
+	"\x06levels\x18\x01 \x01(\tR\x06levels\"~\n" +
+	"\x12BeginUpdateRequest\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x01 \x01(\fR\n" +
+	"passphrase\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x04R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\fR\x06sha256\x12\x1c\n" +
+	"\tsignature\x18\x04 \x01(\fR\tsignature\"@\n" +
+	"\x12UpdateChunkRequest\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\x15\n" +
+	"\x13CommitUpdateRequest\"2\n" +
+	"\x14UpdateUploadResponse\x12\x1a\n" +
+	"\breceived\x18\x01 \x01(\x04R\breceived\"\x10\n" +
 	"\x0eVersionRequest\"J\n" +
 	"\x0fVersionResponse\x12\x18\n" +
 	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1d\n" +
 	"\n" +
-	"build_date\x18\x02 \x01(\tR\tbuildDate\"Y\n" +
+	"build_date\x18\x02 \x01(\tR\tbuildDate\"q\n" +
 	"\x11InitMasterRequest\x12$\n" +
 	"\rdeterministic\x18\x01 \x01(\bR\rdeterministic\x12\x1e\n" +
 	"\n" +
 	"passphrase\x18\x02 \x01(\fR\n" +
-	"passphrase\"\x11\n" +
-	"\x0fInitInfoRequest\"n\n" +
+	"passphrase\x12\x16\n" +
+	"\x06cipher\x18\x03 \x01(\tR\x06cipher\"\x11\n" +
+	"\x0fInitInfoRequest\"\x8f\x01\n" +
 	"\x10InitInfoResponse\x12%\n" +
 	"\x0emaster_present\x18\x01 \x01(\bR\rmasterPresent\x123\n" +
-	"\x15deterministic_enabled\x18\x02 \x01(\bR\x14deterministicEnabled\">\n" +
+	"\x15deterministic_enabled\x18\x02 \x01(\bR\x14deterministicEnabled\x12\x1f\n" +
+	"\vdata_locked\x18\x03 \x01(\bR\n" +
+	"dataLocked\">\n" +
 	"\x0fSetLevelRequest\x12\x15\n" +
 	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x14\n" +
 	"\x05level\x18\x03 \x01(\x04R\x05level\"L\n" +
@@ -1907,12 +4854,151 @@ const file_signer_proto_rawDesc = "" +
 	"passphrase\x18\x02 \x01(\fR\n" +
 	"passphrase\"D\n" +
 	"\x12DeleteKeysResponse\x12.\n" +
-	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\x14\n" +
+	"\aresults\x18\x01 \x03(\v2\x14.signer.PerKeyResultR\aresults\"\xaa\x01\n" +
+	"\x0eSetTagsRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x121\n" +
+	"\x03set\x18\x02 \x03(\v2\x1f.signer.SetTagsRequest.SetEntryR\x03set\x12\x16\n" +
+	"\x06remove\x18\x03 \x03(\tR\x06remove\x1a6\n" +
+	"\bSetEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x81\x01\n" +
+	"\x0fSetTagsResponse\x125\n" +
+	"\x04tags\x18\x01 \x03(\v2!.signer.SetTagsResponse.TagsEntryR\x04tags\x1a7\n" +
+	"\tTagsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"y\n" +
+	"\x12SetValidityRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12,\n" +
+	"\bvalidity\x18\x02 \x01(\v2\x10.signer.ValidityR\bvalidity\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x03 \x01(\fR\n" +
+	"passphrase\"\xc7\x01\n" +
+	"\x06Policy\x12#\n" +
+	"\rallowed_kinds\x18\x01 \x03(\tR\fallowedKinds\x12\x19\n" +
+	"\bchain_id\x18\x02 \x01(\tR\achainId\x12/\n" +
+	"\x14max_signs_per_minute\x18\x03 \x01(\rR\x11maxSignsPerMinute\x12,\n" +
+	"\bvalidity\x18\x04 \x01(\v2\x10.signer.ValidityR\bvalidity\x12\x1e\n" +
+	"\n" +
+	"validation\x18\x05 \x01(\tR\n" +
+	"validation\"q\n" +
+	"\x10SetPolicyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12&\n" +
+	"\x06policy\x18\x02 \x01(\v2\x0e.signer.PolicyR\x06policy\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x03 \x01(\fR\n" +
+	"passphrase\")\n" +
+	"\x10GetPolicyRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\"8\n" +
+	"\x0ePolicyResponse\x12&\n" +
+	"\x06policy\x18\x01 \x01(\v2\x0e.signer.PolicyR\x06policy\"i\n" +
+	"\x0eWatermarkEntry\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x17\n" +
+	"\akind_id\x18\x02 \x01(\rR\x06kindId\x12\x14\n" +
+	"\x05level\x18\x03 \x01(\x04R\x05level\x12\x14\n" +
+	"\x05round\x18\x04 \x01(\rR\x05round\"\xac\x02\n" +
+	"\rKeyWatermarks\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x10\n" +
+	"\x03tz4\x18\x02 \x01(\tR\x03tz4\x120\n" +
+	"\n" +
+	"lock_state\x18\x03 \x01(\x0e2\x11.signer.LockStateR\tlockState\x126\n" +
+	"\n" +
+	"watermarks\x18\x04 \x03(\v2\x16.signer.WatermarkEntryR\n" +
+	"watermarks\x12\x1a\n" +
+	"\bsequence\x18\x05 \x01(\x04R\bsequence\x12\x1d\n" +
+	"\n" +
+	"sign_count\x18\x06 \x01(\x04R\tsignCount\x12$\n" +
+	"\x0elast_used_unix\x18\a \x01(\x03R\flastUsedUnix\x12'\n" +
+	"\x0fstate_corrupted\x18\b \x01(\bR\x0estateCorrupted\"/\n" +
+	"\x14GetWatermarksRequest\x12\x17\n" +
+	"\akey_ids\x18\x01 \x03(\tR\x06keyIds\"B\n" +
+	"\x15GetWatermarksResponse\x12)\n" +
+	"\x04keys\x18\x01 \x03(\v2\x15.signer.KeyWatermarksR\x04keys\"9\n" +
+	"\x17ExportWatermarksRequest\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x01 \x01(\fR\n" +
+	"passphrase\"P\n" +
+	"\x18ExportWatermarksResponse\x12\x1a\n" +
+	"\bsnapshot\x18\x01 \x01(\fR\bsnapshot\x12\x18\n" +
+	"\askipped\x18\x02 \x03(\tR\askipped\"U\n" +
+	"\x17ImportWatermarksRequest\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x01 \x01(\fR\n" +
+	"passphrase\x12\x1a\n" +
+	"\bsnapshot\x18\x02 \x01(\fR\bsnapshot\"\x85\x01\n" +
+	"\x1cImportWatermarksPerKeyResult\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x10\n" +
+	"\x03tz4\x18\x02 \x01(\tR\x03tz4\x12\x16\n" +
+	"\x06raised\x18\x03 \x01(\bR\x06raised\x12\x0e\n" +
+	"\x02ok\x18\x04 \x01(\bR\x02ok\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"Z\n" +
+	"\x18ImportWatermarksResponse\x12>\n" +
+	"\aresults\x18\x01 \x03(\v2$.signer.ImportWatermarksPerKeyResultR\aresults\"\x12\n" +
+	"\x10KDFStatusRequest\"\xa0\x02\n" +
+	"\x11KDFStatusResponse\x12\x12\n" +
+	"\x04time\x18\x01 \x01(\rR\x04time\x12\x1d\n" +
+	"\n" +
+	"memory_kib\x18\x02 \x01(\rR\tmemoryKib\x12\x18\n" +
+	"\athreads\x18\x03 \x01(\rR\athreads\x12)\n" +
+	"\x10recommended_time\x18\x04 \x01(\rR\x0frecommendedTime\x124\n" +
+	"\x16recommended_memory_kib\x18\x05 \x01(\rR\x14recommendedMemoryKib\x12/\n" +
+	"\x13recommended_threads\x18\x06 \x01(\rR\x12recommendedThreads\x12\x12\n" +
+	"\x04weak\x18\a \x01(\bR\x04weak\x12\x18\n" +
+	"\apending\x18\b \x01(\bR\apending\"3\n" +
+	"\x11UpgradeKDFRequest\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x01 \x01(\fR\n" +
+	"passphrase\"\x13\n" +
+	"\x11DeviceInfoRequest\"\xe6\x01\n" +
+	"\n" +
+	"StoreStats\x12%\n" +
+	"\x0emaster_present\x18\x01 \x01(\bR\rmasterPresent\x12$\n" +
+	"\rdeterministic\x18\x02 \x01(\bR\rdeterministic\x12\x1f\n" +
+	"\vdata_locked\x18\x03 \x01(\bR\n" +
+	"dataLocked\x12\x12\n" +
+	"\x04keys\x18\x04 \x01(\rR\x04keys\x12#\n" +
+	"\runlocked_keys\x18\x05 \x01(\rR\funlockedKeys\x12\x16\n" +
+	"\x06cipher\x18\x06 \x01(\tR\x06cipher\x12\x19\n" +
+	"\bkdf_weak\x18\a \x01(\bR\akdfWeak\"\xfc\x01\n" +
+	"\x12DeviceInfoResponse\x12\x16\n" +
+	"\x06serial\x18\x01 \x01(\tR\x06serial\x12#\n" +
+	"\rimage_flavour\x18\x02 \x01(\tR\fimageFlavour\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x1d\n" +
+	"\n" +
+	"build_date\x18\x04 \x01(\tR\tbuildDate\x12(\n" +
+	"\x05store\x18\x05 \x01(\v2\x12.signer.StoreStatsR\x05store\x12\x1a\n" +
+	"\bfeatures\x18\x06 \x03(\tR\bfeatures\x12*\n" +
+	"\x11self_test_failure\x18\a \x01(\tR\x0fselfTestFailure\"+\n" +
+	"\x11GetEntropyRequest\x12\x16\n" +
+	"\x06length\x18\x01 \x01(\rR\x06length\"D\n" +
+	"\x12GetEntropyResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x1a\n" +
+	"\bhardware\x18\x02 \x01(\bR\bhardware\")\n" +
+	"\x0eSetTimeRequest\x12\x17\n" +
+	"\aunix_ms\x18\x01 \x01(\x03R\x06unixMs\"\x10\n" +
+	"\x0eGetTimeRequest\"?\n" +
+	"\fTimeResponse\x12\x17\n" +
+	"\aunix_ms\x18\x01 \x01(\x03R\x06unixMs\x12\x16\n" +
+	"\x06synced\x18\x02 \x01(\bR\x06synced\"\x9a\x01\n" +
+	"\x0eThresholdShare\x12!\n" +
+	"\fgroup_pubkey\x18\x01 \x01(\tR\vgroupPubkey\x12\x1b\n" +
+	"\tgroup_tz4\x18\x02 \x01(\tR\bgroupTz4\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\rR\x05index\x12\x1c\n" +
+	"\tthreshold\x18\x04 \x01(\rR\tthreshold\x12\x14\n" +
+	"\x05total\x18\x05 \x01(\rR\x05total\"\xc2\x01\n" +
+	"\x15ImportKeyShareRequest\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x1d\n" +
+	"\n" +
+	"secret_key\x18\x02 \x01(\tR\tsecretKey\x12,\n" +
+	"\x05share\x18\x03 \x01(\v2\x16.signer.ThresholdShareR\x05share\x12\x1e\n" +
+	"\n" +
+	"passphrase\x18\x04 \x01(\fR\n" +
+	"passphrase\x12%\n" +
+	"\x0ekey_passphrase\x18\x05 \x01(\fR\rkeyPassphrase\"\x14\n" +
 	"\x02Ok\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\"5\n" +
 	"\x05Error\x12\x12\n" +
 	"\x04code\x18\x01 \x01(\rR\x04code\x12\x18\n" +
-	"\amessage\x18\x02 \x01(\tR\amessage\"\xcc\x04\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\xdd\x0e\n" +
 	"\aRequest\x12/\n" +
 	"\x06unlock\x18\x01 \x01(\v2\x15.signer.UnlockRequestH\x00R\x06unlock\x12)\n" +
 	"\x04lock\x18\x02 \x01(\v2\x13.signer.LockRequestH\x00R\x04lock\x12/\n" +
@@ -1927,8 +5013,35 @@ const file_signer_proto_rawDesc = "" +
 	"\vdelete_keys\x18\n" +
 	" \x01(\v2\x19.signer.DeleteKeysRequestH\x00R\n" +
 	"deleteKeys\x122\n" +
-	"\aversion\x18\v \x01(\v2\x16.signer.VersionRequestH\x00R\aversionB\t\n" +
-	"\apayload\"\xa3\x04\n" +
+	"\aversion\x18\v \x01(\v2\x16.signer.VersionRequestH\x00R\aversion\x123\n" +
+	"\bset_tags\x18\f \x01(\v2\x16.signer.SetTagsRequestH\x00R\asetTags\x12?\n" +
+	"\fset_validity\x18\r \x01(\v2\x1a.signer.SetValidityRequestH\x00R\vsetValidity\x12N\n" +
+	"\x11export_watermarks\x18\x0e \x01(\v2\x1f.signer.ExportWatermarksRequestH\x00R\x10exportWatermarks\x12N\n" +
+	"\x11import_watermarks\x18\x0f \x01(\v2\x1f.signer.ImportWatermarksRequestH\x00R\x10importWatermarks\x129\n" +
+	"\n" +
+	"kdf_status\x18\x10 \x01(\v2\x18.signer.KDFStatusRequestH\x00R\tkdfStatus\x12<\n" +
+	"\vupgrade_kdf\x18\x11 \x01(\v2\x19.signer.UpgradeKDFRequestH\x00R\n" +
+	"upgradeKdf\x12<\n" +
+	"\vdevice_info\x18\x12 \x01(\v2\x19.signer.DeviceInfoRequestH\x00R\n" +
+	"deviceInfo\x12C\n" +
+	"\x0eget_public_key\x18\x13 \x01(\v2\x1b.signer.GetPublicKeyRequestH\x00R\fgetPublicKey\x12<\n" +
+	"\vget_entropy\x18\x14 \x01(\v2\x19.signer.GetEntropyRequestH\x00R\n" +
+	"getEntropy\x123\n" +
+	"\bset_time\x18\x15 \x01(\v2\x16.signer.SetTimeRequestH\x00R\asetTime\x123\n" +
+	"\bget_time\x18\x16 \x01(\v2\x16.signer.GetTimeRequestH\x00R\agetTime\x129\n" +
+	"\n" +
+	"set_policy\x18\x17 \x01(\v2\x18.signer.SetPolicyRequestH\x00R\tsetPolicy\x129\n" +
+	"\n" +
+	"get_policy\x18\x18 \x01(\v2\x18.signer.GetPolicyRequestH\x00R\tgetPolicy\x12E\n" +
+	"\x0eget_watermarks\x18\x19 \x01(\v2\x1c.signer.GetWatermarksRequestH\x00R\rgetWatermarks\x12I\n" +
+	"\x10import_key_share\x18\x1a \x01(\v2\x1d.signer.ImportKeyShareRequestH\x00R\x0eimportKeyShare\x12E\n" +
+	"\x0eregenerate_pop\x18\x1b \x01(\v2\x1c.signer.RegeneratePoPRequestH\x00R\rregeneratePop\x12@\n" +
+	"\rset_log_level\x18\x1c \x01(\v2\x1a.signer.SetLogLevelRequestH\x00R\vsetLogLevel\x12?\n" +
+	"\fbegin_update\x18\x1d \x01(\v2\x1a.signer.BeginUpdateRequestH\x00R\vbeginUpdate\x12?\n" +
+	"\fupdate_chunk\x18\x1e \x01(\v2\x1a.signer.UpdateChunkRequestH\x00R\vupdateChunk\x12B\n" +
+	"\rcommit_update\x18\x1f \x01(\v2\x1b.signer.CommitUpdateRequestH\x00R\fcommitUpdateB\t\n" +
+	"\apayload\"\xa9\n" +
+	"\n" +
 	"\bResponse\x120\n" +
 	"\x06unlock\x18\x01 \x01(\v2\x16.signer.UnlockResponseH\x00R\x06unlock\x12*\n" +
 	"\x04lock\x18\x02 \x01(\v2\x14.signer.LockResponseH\x00R\x04lock\x120\n" +
@@ -1939,7 +5052,23 @@ const file_signer_proto_rawDesc = "" +
 	"\tinit_info\x18\a \x01(\v2\x18.signer.InitInfoResponseH\x00R\binitInfo\x12=\n" +
 	"\vdelete_keys\x18\b \x01(\v2\x1a.signer.DeleteKeysResponseH\x00R\n" +
 	"deleteKeys\x123\n" +
-	"\aversion\x18\t \x01(\v2\x17.signer.VersionResponseH\x00R\aversion\x12\x1c\n" +
+	"\aversion\x18\t \x01(\v2\x17.signer.VersionResponseH\x00R\aversion\x124\n" +
+	"\bset_tags\x18\n" +
+	" \x01(\v2\x17.signer.SetTagsResponseH\x00R\asetTags\x12O\n" +
+	"\x11export_watermarks\x18\v \x01(\v2 .signer.ExportWatermarksResponseH\x00R\x10exportWatermarks\x12O\n" +
+	"\x11import_watermarks\x18\f \x01(\v2 .signer.ImportWatermarksResponseH\x00R\x10importWatermarks\x12:\n" +
+	"\n" +
+	"kdf_status\x18\r \x01(\v2\x19.signer.KDFStatusResponseH\x00R\tkdfStatus\x12=\n" +
+	"\vdevice_info\x18\x11 \x01(\v2\x1a.signer.DeviceInfoResponseH\x00R\n" +
+	"deviceInfo\x12D\n" +
+	"\x0eget_public_key\x18\x12 \x01(\v2\x1c.signer.GetPublicKeyResponseH\x00R\fgetPublicKey\x12=\n" +
+	"\vget_entropy\x18\x13 \x01(\v2\x1a.signer.GetEntropyResponseH\x00R\n" +
+	"getEntropy\x12*\n" +
+	"\x04time\x18\x14 \x01(\v2\x14.signer.TimeResponseH\x00R\x04time\x120\n" +
+	"\x06policy\x18\x15 \x01(\v2\x16.signer.PolicyResponseH\x00R\x06policy\x12F\n" +
+	"\x0eget_watermarks\x18\x16 \x01(\v2\x1d.signer.GetWatermarksResponseH\x00R\rgetWatermarks\x12A\n" +
+	"\rset_log_level\x18\x17 \x01(\v2\x1b.signer.SetLogLevelResponseH\x00R\vsetLogLevel\x12C\n" +
+	"\rupdate_upload\x18\x18 \x01(\v2\x1c.signer.UpdateUploadResponseH\x00R\fupdateUpload\x12\x1c\n" +
 	"\x02ok\x18\x0f \x01(\v2\n" +
 	".signer.OkH\x00R\x02ok\x12%\n" +
 	"\x05error\x18\x10 \x01(\v2\r.signer.ErrorH\x00R\x05errorB\t\n" +
@@ -1963,71 +5092,162 @@ func file_signer_proto_rawDescGZIP() []byte {
 }
 
 var file_signer_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 27)
+var file_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 70)
 var file_signer_proto_goTypes = []any{
-	(LockState)(0),             // 0: signer.LockState
-	(*PerKeyResult)(nil),       // 1: signer.PerKeyResult
-	(*UnlockRequest)(nil),      // 2: signer.UnlockRequest
-	(*UnlockResponse)(nil),     // 3: signer.UnlockResponse
-	(*LockRequest)(nil),        // 4: signer.LockRequest
-	(*LockResponse)(nil),       // 5: signer.LockResponse
-	(*KeyStatus)(nil),          // 6: signer.KeyStatus
-	(*StatusRequest)(nil),      // 7: signer.StatusRequest
-	(*StatusResponse)(nil),     // 8: signer.StatusResponse
-	(*SignRequest)(nil),        // 9: signer.SignRequest
-	(*SignResponse)(nil),       // 10: signer.SignResponse
-	(*NewKeyPerKeyResult)(nil), // 11: signer.NewKeyPerKeyResult
-	(*NewKeysRequest)(nil),     // 12: signer.NewKeysRequest
-	(*NewKeysResponse)(nil),    // 13: signer.NewKeysResponse
-	(*LogsRequest)(nil),        // 14: signer.LogsRequest
-	(*LogsResponse)(nil),       // 15: signer.LogsResponse
-	(*VersionRequest)(nil),     // 16: signer.VersionRequest
-	(*VersionResponse)(nil),    // 17: signer.VersionResponse
-	(*InitMasterRequest)(nil),  // 18: signer.InitMasterRequest
-	(*InitInfoRequest)(nil),    // 19: signer.InitInfoRequest
-	(*InitInfoResponse)(nil),   // 20: signer.InitInfoResponse
-	(*SetLevelRequest)(nil),    // 21: signer.SetLevelRequest
-	(*DeleteKeysRequest)(nil),  // 22: signer.DeleteKeysRequest
-	(*DeleteKeysResponse)(nil), // 23: signer.DeleteKeysResponse
-	(*Ok)(nil),                 // 24: signer.Ok
-	(*Error)(nil),              // 25: signer.Error
-	(*Request)(nil),            // 26: signer.Request
-	(*Response)(nil),           // 27: signer.Response
+	(LockState)(0),                       // 0: signer.LockState
+	(*PerKeyResult)(nil),                 // 1: signer.PerKeyResult
+	(*UnlockRequest)(nil),                // 2: signer.UnlockRequest
+	(*UnlockResponse)(nil),               // 3: signer.UnlockResponse
+	(*LockRequest)(nil),                  // 4: signer.LockRequest
+	(*LockResponse)(nil),                 // 5: signer.LockResponse
+	(*Validity)(nil),                     // 6: signer.Validity
+	(*KeyStatus)(nil),                    // 7: signer.KeyStatus
+	(*StatusRequest)(nil),                // 8: signer.StatusRequest
+	(*StatusResponse)(nil),               // 9: signer.StatusResponse
+	(*GetPublicKeyRequest)(nil),          // 10: signer.GetPublicKeyRequest
+	(*RegeneratePoPRequest)(nil),         // 11: signer.RegeneratePoPRequest
+	(*GetPublicKeyResponse)(nil),         // 12: signer.GetPublicKeyResponse
+	(*SignRequest)(nil),                  // 13: signer.SignRequest
+	(*SignResponse)(nil),                 // 14: signer.SignResponse
+	(*NewKeyPerKeyResult)(nil),           // 15: signer.NewKeyPerKeyResult
+	(*NewKeysRequest)(nil),               // 16: signer.NewKeysRequest
+	(*NewKeysResponse)(nil),              // 17: signer.NewKeysResponse
+	(*LogsRequest)(nil),                  // 18: signer.LogsRequest
+	(*LogsResponse)(nil),                 // 19: signer.LogsResponse
+	(*SetLogLevelRequest)(nil),           // 20: signer.SetLogLevelRequest
+	(*SetLogLevelResponse)(nil),          // 21: signer.SetLogLevelResponse
+	(*BeginUpdateRequest)(nil),           // 22: signer.BeginUpdateRequest
+	(*UpdateChunkRequest)(nil),           // 23: signer.UpdateChunkRequest
+	(*CommitUpdateRequest)(nil),          // 24: signer.CommitUpdateRequest
+	(*UpdateUploadResponse)(nil),         // 25: signer.UpdateUploadResponse
+	(*VersionRequest)(nil),               // 26: signer.VersionRequest
+	(*VersionResponse)(nil),              // 27: signer.VersionResponse
+	(*InitMasterRequest)(nil),            // 28: signer.InitMasterRequest
+	(*InitInfoRequest)(nil),              // 29: signer.InitInfoRequest
+	(*InitInfoResponse)(nil),             // 30: signer.InitInfoResponse
+	(*SetLevelRequest)(nil),              // 31: signer.SetLevelRequest
+	(*DeleteKeysRequest)(nil),            // 32: signer.DeleteKeysRequest
+	(*DeleteKeysResponse)(nil),           // 33: signer.DeleteKeysResponse
+	(*SetTagsRequest)(nil),               // 34: signer.SetTagsRequest
+	(*SetTagsResponse)(nil),              // 35: signer.SetTagsResponse
+	(*SetValidityRequest)(nil),           // 36: signer.SetValidityRequest
+	(*Policy)(nil),                       // 37: signer.Policy
+	(*SetPolicyRequest)(nil),             // 38: signer.SetPolicyRequest
+	(*GetPolicyRequest)(nil),             // 39: signer.GetPolicyRequest
+	(*PolicyResponse)(nil),               // 40: signer.PolicyResponse
+	(*WatermarkEntry)(nil),               // 41: signer.WatermarkEntry
+	(*KeyWatermarks)(nil),                // 42: signer.KeyWatermarks
+	(*GetWatermarksRequest)(nil),         // 43: signer.GetWatermarksRequest
+	(*GetWatermarksResponse)(nil),        // 44: signer.GetWatermarksResponse
+	(*ExportWatermarksRequest)(nil),      // 45: signer.ExportWatermarksRequest
+	(*ExportWatermarksResponse)(nil),     // 46: signer.ExportWatermarksResponse
+	(*ImportWatermarksRequest)(nil),      // 47: signer.ImportWatermarksRequest
+	(*ImportWatermarksPerKeyResult)(nil), // 48: signer.ImportWatermarksPerKeyResult
+	(*ImportWatermarksResponse)(nil),     // 49: signer.ImportWatermarksResponse
+	(*KDFStatusRequest)(nil),             // 50: signer.KDFStatusRequest
+	(*KDFStatusResponse)(nil),            // 51: signer.KDFStatusResponse
+	(*UpgradeKDFRequest)(nil),            // 52: signer.UpgradeKDFRequest
+	(*DeviceInfoRequest)(nil),            // 53: signer.DeviceInfoRequest
+	(*StoreStats)(nil),                   // 54: signer.StoreStats
+	(*DeviceInfoResponse)(nil),           // 55: signer.DeviceInfoResponse
+	(*GetEntropyRequest)(nil),            // 56: signer.GetEntropyRequest
+	(*GetEntropyResponse)(nil),           // 57: signer.GetEntropyResponse
+	(*SetTimeRequest)(nil),               // 58: signer.SetTimeRequest
+	(*GetTimeRequest)(nil),               // 59: signer.GetTimeRequest
+	(*TimeResponse)(nil),                 // 60: signer.TimeResponse
+	(*ThresholdShare)(nil),               // 61: signer.ThresholdShare
+	(*ImportKeyShareRequest)(nil),        // 62: signer.ImportKeyShareRequest
+	(*Ok)(nil),                           // 63: signer.Ok
+	(*Error)(nil),                        // 64: signer.Error
+	(*Request)(nil),                      // 65: signer.Request
+	(*Response)(nil),                     // 66: signer.Response
+	nil,                                  // 67: signer.UnlockRequest.KeyPassphrasesEntry
+	nil,                                  // 68: signer.KeyStatus.TagsEntry
+	nil,                                  // 69: signer.SetTagsRequest.SetEntry
+	nil,                                  // 70: signer.SetTagsResponse.TagsEntry
 }
 var file_signer_proto_depIdxs = []int32{
-	1,  // 0: signer.UnlockResponse.results:type_name -> signer.PerKeyResult
-	1,  // 1: signer.LockResponse.results:type_name -> signer.PerKeyResult
-	0,  // 2: signer.KeyStatus.lock_state:type_name -> signer.LockState
-	6,  // 3: signer.StatusResponse.keys:type_name -> signer.KeyStatus
-	11, // 4: signer.NewKeysResponse.results:type_name -> signer.NewKeyPerKeyResult
-	1,  // 5: signer.DeleteKeysResponse.results:type_name -> signer.PerKeyResult
-	2,  // 6: signer.Request.unlock:type_name -> signer.UnlockRequest
-	4,  // 7: signer.Request.lock:type_name -> signer.LockRequest
-	7,  // 8: signer.Request.status:type_name -> signer.StatusRequest
-	9,  // 9: signer.Request.sign:type_name -> signer.SignRequest
-	12, // 10: signer.Request.new_keys:type_name -> signer.NewKeysRequest
-	14, // 11: signer.Request.logs:type_name -> signer.LogsRequest
-	18, // 12: signer.Request.init_master:type_name -> signer.InitMasterRequest
-	19, // 13: signer.Request.init_info:type_name -> signer.InitInfoRequest
-	21, // 14: signer.Request.set_level:type_name -> signer.SetLevelRequest
-	22, // 15: signer.Request.delete_keys:type_name -> signer.DeleteKeysRequest
-	16, // 16: signer.Request.version:type_name -> signer.VersionRequest
-	3,  // 17: signer.Response.unlock:type_name -> signer.UnlockResponse
-	5,  // 18: signer.Response.lock:type_name -> signer.LockResponse
-	8,  // 19: signer.Response.status:type_name -> signer.StatusResponse
-	10, // 20: signer.Response.sign:type_name -> signer.SignResponse
-	13, // 21: signer.Response.new_key:type_name -> signer.NewKeysResponse
-	15, // 22: signer.Response.logs:type_name -> signer.LogsResponse
-	20, // 23: signer.Response.init_info:type_name -> signer.InitInfoResponse
-	23, // 24: signer.Response.delete_keys:type_name -> signer.DeleteKeysResponse
-	17, // 25: signer.Response.version:type_name -> signer.VersionResponse
-	24, // 26: signer.Response.ok:type_name -> signer.Ok
-	25, // 27: signer.Response.error:type_name -> signer.Error
-	28, // [28:28] is the sub-list for method output_type
-	28, // [28:28] is the sub-list for method input_type
-	28, // [28:28] is the sub-list for extension type_name
-	28, // [28:28] is the sub-list for extension extendee
-	0,  // [0:28] is the sub-list for field type_name
+	67, // 0: signer.UnlockRequest.key_passphrases:type_name -> signer.UnlockRequest.KeyPassphrasesEntry
+	1,  // 1: signer.UnlockResponse.results:type_name -> signer.PerKeyResult
+	1,  // 2: signer.LockResponse.results:type_name -> signer.PerKeyResult
+	0,  // 3: signer.KeyStatus.lock_state:type_name -> signer.LockState
+	68, // 4: signer.KeyStatus.tags:type_name -> signer.KeyStatus.TagsEntry
+	6,  // 5: signer.KeyStatus.validity:type_name -> signer.Validity
+	7,  // 6: signer.StatusResponse.keys:type_name -> signer.KeyStatus
+	61, // 7: signer.GetPublicKeyResponse.share:type_name -> signer.ThresholdShare
+	15, // 8: signer.NewKeysResponse.results:type_name -> signer.NewKeyPerKeyResult
+	1,  // 9: signer.DeleteKeysResponse.results:type_name -> signer.PerKeyResult
+	69, // 10: signer.SetTagsRequest.set:type_name -> signer.SetTagsRequest.SetEntry
+	70, // 11: signer.SetTagsResponse.tags:type_name -> signer.SetTagsResponse.TagsEntry
+	6,  // 12: signer.SetValidityRequest.validity:type_name -> signer.Validity
+	6,  // 13: signer.Policy.validity:type_name -> signer.Validity
+	37, // 14: signer.SetPolicyRequest.policy:type_name -> signer.Policy
+	37, // 15: signer.PolicyResponse.policy:type_name -> signer.Policy
+	0,  // 16: signer.KeyWatermarks.lock_state:type_name -> signer.LockState
+	41, // 17: signer.KeyWatermarks.watermarks:type_name -> signer.WatermarkEntry
+	42, // 18: signer.GetWatermarksResponse.keys:type_name -> signer.KeyWatermarks
+	48, // 19: signer.ImportWatermarksResponse.results:type_name -> signer.ImportWatermarksPerKeyResult
+	54, // 20: signer.DeviceInfoResponse.store:type_name -> signer.StoreStats
+	61, // 21: signer.ImportKeyShareRequest.share:type_name -> signer.ThresholdShare
+	2,  // 22: signer.Request.unlock:type_name -> signer.UnlockRequest
+	4,  // 23: signer.Request.lock:type_name -> signer.LockRequest
+	8,  // 24: signer.Request.status:type_name -> signer.StatusRequest
+	13, // 25: signer.Request.sign:type_name -> signer.SignRequest
+	16, // 26: signer.Request.new_keys:type_name -> signer.NewKeysRequest
+	18, // 27: signer.Request.logs:type_name -> signer.LogsRequest
+	28, // 28: signer.Request.init_master:type_name -> signer.InitMasterRequest
+	29, // 29: signer.Request.init_info:type_name -> signer.InitInfoRequest
+	31, // 30: signer.Request.set_level:type_name -> signer.SetLevelRequest
+	32, // 31: signer.Request.delete_keys:type_name -> signer.DeleteKeysRequest
+	26, // 32: signer.Request.version:type_name -> signer.VersionRequest
+	34, // 33: signer.Request.set_tags:type_name -> signer.SetTagsRequest
+	36, // 34: signer.Request.set_validity:type_name -> signer.SetValidityRequest
+	45, // 35: signer.Request.export_watermarks:type_name -> signer.ExportWatermarksRequest
+	47, // 36: signer.Request.import_watermarks:type_name -> signer.ImportWatermarksRequest
+	50, // 37: signer.Request.kdf_status:type_name -> signer.KDFStatusRequest
+	52, // 38: signer.Request.upgrade_kdf:type_name -> signer.UpgradeKDFRequest
+	53, // 39: signer.Request.device_info:type_name -> signer.DeviceInfoRequest
+	10, // 40: signer.Request.get_public_key:type_name -> signer.GetPublicKeyRequest
+	56, // 41: signer.Request.get_entropy:type_name -> signer.GetEntropyRequest
+	58, // 42: signer.Request.set_time:type_name -> signer.SetTimeRequest
+	59, // 43: signer.Request.get_time:type_name -> signer.GetTimeRequest
+	38, // 44: signer.Request.set_policy:type_name -> signer.SetPolicyRequest
+	39, // 45: signer.Request.get_policy:type_name -> signer.GetPolicyRequest
+	43, // 46: signer.Request.get_watermarks:type_name -> signer.GetWatermarksRequest
+	62, // 47: signer.Request.import_key_share:type_name -> signer.ImportKeyShareRequest
+	11, // 48: signer.Request.regenerate_pop:type_name -> signer.RegeneratePoPRequest
+	20, // 49: signer.Request.set_log_level:type_name -> signer.SetLogLevelRequest
+	22, // 50: signer.Request.begin_update:type_name -> signer.BeginUpdateRequest
+	23, // 51: signer.Request.update_chunk:type_name -> signer.UpdateChunkRequest
+	24, // 52: signer.Request.commit_update:type_name -> signer.CommitUpdateRequest
+	3,  // 53: signer.Response.unlock:type_name -> signer.UnlockResponse
+	5,  // 54: signer.Response.lock:type_name -> signer.LockResponse
+	9,  // 55: signer.Response.status:type_name -> signer.StatusResponse
+	14, // 56: signer.Response.sign:type_name -> signer.SignResponse
+	17, // 57: signer.Response.new_key:type_name -> signer.NewKeysResponse
+	19, // 58: signer.Response.logs:type_name -> signer.LogsResponse
+	30, // 59: signer.Response.init_info:type_name -> signer.InitInfoResponse
+	33, // 60: signer.Response.delete_keys:type_name -> signer.DeleteKeysResponse
+	27, // 61: signer.Response.version:type_name -> signer.VersionResponse
+	35, // 62: signer.Response.set_tags:type_name -> signer.SetTagsResponse
+	46, // 63: signer.Response.export_watermarks:type_name -> signer.ExportWatermarksResponse
+	49, // 64: signer.Response.import_watermarks:type_name -> signer.ImportWatermarksResponse
+	51, // 65: signer.Response.kdf_status:type_name -> signer.KDFStatusResponse
+	55, // 66: signer.Response.device_info:type_name -> signer.DeviceInfoResponse
+	12, // 67: signer.Response.get_public_key:type_name -> signer.GetPublicKeyResponse
+	57, // 68: signer.Response.get_entropy:type_name -> signer.GetEntropyResponse
+	60, // 69: signer.Response.time:type_name -> signer.TimeResponse
+	40, // 70: signer.Response.policy:type_name -> signer.PolicyResponse
+	44, // 71: signer.Response.get_watermarks:type_name -> signer.GetWatermarksResponse
+	21, // 72: signer.Response.set_log_level:type_name -> signer.SetLogLevelResponse
+	25, // 73: signer.Response.update_upload:type_name -> signer.UpdateUploadResponse
+	63, // 74: signer.Response.ok:type_name -> signer.Ok
+	64, // 75: signer.Response.error:type_name -> signer.Error
+	76, // [76:76] is the sub-list for method output_type
+	76, // [76:76] is the sub-list for method input_type
+	76, // [76:76] is the sub-list for extension type_name
+	76, // [76:76] is the sub-list for extension extendee
+	0,  // [0:76] is the sub-list for field type_name
 }
 
 func init() { file_signer_proto_init() }
@@ -2035,7 +5255,7 @@ func file_signer_proto_init() {
 	if File_signer_proto != nil {
 		return
 	}
-	file_signer_proto_msgTypes[25].OneofWrappers = []any{
+	file_signer_proto_msgTypes[64].OneofWrappers = []any{
 		(*Request_Unlock)(nil),
 		(*Request_Lock)(nil),
 		(*Request_Status)(nil),
@@ -2047,8 +5267,28 @@ func file_signer_proto_init() {
 		(*Request_SetLevel)(nil),
 		(*Request_DeleteKeys)(nil),
 		(*Request_Version)(nil),
+		(*Request_SetTags)(nil),
+		(*Request_SetValidity)(nil),
+		(*Request_ExportWatermarks)(nil),
+		(*Request_ImportWatermarks)(nil),
+		(*Request_KdfStatus)(nil),
+		(*Request_UpgradeKdf)(nil),
+		(*Request_DeviceInfo)(nil),
+		(*Request_GetPublicKey)(nil),
+		(*Request_GetEntropy)(nil),
+		(*Request_SetTime)(nil),
+		(*Request_GetTime)(nil),
+		(*Request_SetPolicy)(nil),
+		(*Request_GetPolicy)(nil),
+		(*Request_GetWatermarks)(nil),
+		(*Request_ImportKeyShare)(nil),
+		(*Request_RegeneratePop)(nil),
+		(*Request_SetLogLevel)(nil),
+		(*Request_BeginUpdate)(nil),
+		(*Request_UpdateChunk)(nil),
+		(*Request_CommitUpdate)(nil),
 	}
-	file_signer_proto_msgTypes[26].OneofWrappers = []any{
+	file_signer_proto_msgTypes[65].OneofWrappers = []any{
 		(*Response_Unlock)(nil),
 		(*Response_Lock)(nil),
 		(*Response_Status)(nil),
@@ -2058,6 +5298,18 @@ func file_signer_proto_init() {
 		(*Response_InitInfo)(nil),
 		(*Response_DeleteKeys)(nil),
 		(*Response_Version)(nil),
+		(*Response_SetTags)(nil),
+		(*Response_ExportWatermarks)(nil),
+		(*Response_ImportWatermarks)(nil),
+		(*Response_KdfStatus)(nil),
+		(*Response_DeviceInfo)(nil),
+		(*Response_GetPublicKey)(nil),
+		(*Response_GetEntropy)(nil),
+		(*Response_Time)(nil),
+		(*Response_Policy)(nil),
+		(*Response_GetWatermarks)(nil),
+		(*Response_SetLogLevel)(nil),
+		(*Response_UpdateUpload)(nil),
 		(*Response_Ok)(nil),
 		(*Response_Error)(nil),
 	}
@@ -2067,7 +5319,7 @@ func file_signer_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_signer_proto_rawDesc), len(file_signer_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   27,
+			NumMessages:   70,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/bootslot"
+)
+
+// BootEnv changes boot loader settings on the boot partition: keys in a
+// key=value file the boot loader reads, global extlinux.conf directives and
+// the kernel command line.
+type BootEnv struct {
+	// File is the key=value file Set changes, e.g. /armbianEnv.txt or
+	// /uEnv.txt. It defaults to config.txt on a Raspberry Pi.
+	File string `toml:"file"`
+	// Set changes keys in File. An empty value removes the key.
+	Set map[string]string `toml:"set"`
+	// Extlinux changes global extlinux.conf directives (timeout, prompt,
+	// menu). An empty value removes the directive.
+	Extlinux map[string]string `toml:"extlinux"`
+	// AddArgs and RemoveArgs change the kernel command line of every boot
+	// entry: cmdline.txt (per slot on A/B images) or the append line of
+	// each extlinux.conf label. Removal runs first. An entry without "="
+	// in RemoveArgs removes the argument whatever its value.
+	AddArgs    []string `toml:"add_args"`
+	RemoveArgs []string `toml:"remove_args"`
+}
+
+// extlinuxGlobals are the directives Extlinux may change. default is the
+// A/B slot selector and is left to tezsign-slot.
+var extlinuxGlobals = []string{"menu", "prompt", "timeout", "totaltimeout"}
+
+// protectedArgs are kernel arguments the image does not boot without, or
+// that tell it which slot it runs from.
+var protectedArgs = []string{"root", "rootfstype", "rdinit", "init", bootslot.CmdlineKey}
+
+// rpiReservedKeys are config.txt keys Set must not touch: the first two
+// appear once per overlay or parameter, and the others select the A/B slot.
+var rpiReservedKeys = []string{"dtoverlay", "dtparam", "include", "os_prefix"}
+
+func (b BootEnv) empty() bool {
+	return len(b.Set) == 0 && len(b.Extlinux) == 0 && len(b.AddArgs) == 0 && len(b.RemoveArgs) == 0
+}
+
+// merge returns b with o's settings on top: o's file if it names one, and
+// o's value where both set a key.
+func (b BootEnv) merge(o BootEnv) BootEnv {
+	out := BootEnv{
+		File:       b.File,
+		Set:        maps.Clone(b.Set),
+		Extlinux:   maps.Clone(b.Extlinux),
+		AddArgs:    slices.Concat(b.AddArgs, o.AddArgs),
+		RemoveArgs: slices.Concat(b.RemoveArgs, o.RemoveArgs),
+	}
+	if o.File != "" {
+		out.File = o.File
+	}
+	if len(o.Set) > 0 {
+		if out.Set == nil {
+			out.Set = map[string]string{}
+		}
+		maps.Copy(out.Set, o.Set)
+	}
+	if len(o.Extlinux) > 0 {
+		if out.Extlinux == nil {
+			out.Extlinux = map[string]string{}
+		}
+		maps.Copy(out.Extlinux, o.Extlinux)
+	}
+	return out
+}
+
+// file is the partition path Set changes, "" when the board has none.
+func (b BootEnv) file(boot string) string {
+	if b.File != "" {
+		return b.File
+	}
+	if boot == bootRPi {
+		return "/" + rpiConfigFile
+	}
+	return ""
+}
+
+// validate checks what does not depend on the board; validateFor checks
+// the merged settings of a flavour against its boot style.
+func (b BootEnv) validate(at string) error {
+	if b.File != "" && (!path.IsAbs(b.File) || path.Clean(b.File) != b.File || b.File == "/") {
+		return fmt.Errorf("%w: %s: file %q must be absolute and clean", errInvalidConfig, at, b.File)
+	}
+	for _, k := range slices.Sorted(maps.Keys(b.Set)) {
+		if k == "" || strings.ContainsAny(k, "=#[ \t\r\n") {
+			return fmt.Errorf("%w: %s.set: key %q is empty or has =, #, [ or spaces", errInvalidConfig, at, k)
+		}
+		if strings.ContainsAny(b.Set[k], "\r\n") {
+			return fmt.Errorf("%w: %s.set.%s spans lines", errInvalidConfig, at, k)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(b.Extlinux)) {
+		if !slices.Contains(extlinuxGlobals, k) {
+			return fmt.Errorf("%w: %s.extlinux: %q must be one of %s", errInvalidConfig, at, k, strings.Join(extlinuxGlobals, ", "))
+		}
+		if strings.ContainsAny(b.Extlinux[k], "\r\n") {
+			return fmt.Errorf("%w: %s.extlinux.%s spans lines", errInvalidConfig, at, k)
+		}
+	}
+	for name, args := range map[string][]string{"add_args": b.AddArgs, "remove_args": b.RemoveArgs} {
+		for i, arg := range args {
+			if arg == "" || strings.ContainsAny(arg, " \t\r\n") {
+				return fmt.Errorf("%w: %s.%s[%d] is empty or has spaces", errInvalidConfig, at, name, i)
+			}
+			if key, _, _ := strings.Cut(arg, "="); slices.Contains(protectedArgs, key) {
+				return fmt.Errorf("%w: %s.%s[%d]: the image needs %s as it is", errInvalidConfig, at, name, i, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (b BootEnv) validateFor(at, boot string) error {
+	if len(b.Extlinux) > 0 && boot != bootExtlinux {
+		return fmt.Errorf("%w: %s.extlinux: the flavour boots with %s", errInvalidConfig, at, boot)
+	}
+	if len(b.Set) == 0 {
+		return nil
+	}
+	file := b.file(boot)
+	if file == "" {
+		return fmt.Errorf("%w: %s.set: an extlinux flavour needs file, the env file its boot loader reads", errInvalidConfig, at)
+	}
+	if path.Base(file) == rpiConfigFile {
+		for k := range b.Set {
+			if slices.Contains(rpiReservedKeys, k) {
+				return fmt.Errorf("%w: %s.set: %s cannot be set in %s; use overlays, inject or a hook", errInvalidConfig, at, k, rpiConfigFile)
+			}
+		}
+	}
+	return nil
+}
+
+// bootEnvActions turns the settings into boot partition actions: the env
+// file, then extlinux.conf, then the kernel command line.
+func bootEnvActions(boot string, b BootEnv) []action {
+	var actions []action
+	if len(b.Set) > 0 {
+		file := b.file(boot)
+		actions = append(actions, action{
+			partition: partitionBoot,
+			what:      fmt.Sprintf("set %s in %s", describeSettings(b.Set, "="), file),
+			apply: func(root string) error {
+				return editFile(within(root, file), func(conf []byte) ([]byte, error) {
+					return setEnv(conf, b.Set), nil
+				})
+			},
+		})
+	}
+	if len(b.Extlinux) > 0 {
+		actions = append(actions, action{
+			partition: partitionBoot,
+			what:      fmt.Sprintf("set %s in %s", describeSettings(b.Extlinux, " "), bootslot.ExtlinuxFile),
+			apply: func(root string) error {
+				return editFile(filepath.Join(root, bootslot.ExtlinuxFile), func(conf []byte) ([]byte, error) {
+					return setExtlinuxGlobals(conf, b.Extlinux), nil
+				})
+			},
+		})
+	}
+	if len(b.AddArgs) > 0 || len(b.RemoveArgs) > 0 {
+		var change []string
+		for _, arg := range b.RemoveArgs {
+			change = append(change, "-"+arg)
+		}
+		for _, arg := range b.AddArgs {
+			change = append(change, "+"+arg)
+		}
+		where := "cmdline.txt"
+		if boot == bootExtlinux {
+			where = bootslot.ExtlinuxFile
+		}
+		actions = append(actions, action{
+			partition: partitionBoot,
+			what:      fmt.Sprintf("kernel args %s (%s)", strings.Join(change, " "), where),
+			apply: func(root string) error {
+				if boot == bootExtlinux {
+					return editFile(filepath.Join(root, bootslot.ExtlinuxFile), func(conf []byte) ([]byte, error) {
+						return editExtlinuxEntries(conf, "append", func(line string) string {
+							indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+							var args []string
+							if fields := strings.Fields(line); len(fields) > 0 {
+								args = fields[1:]
+							}
+							return indent + "append " + editArgs(strings.Join(args, " "), b.AddArgs, b.RemoveArgs)
+						})
+					})
+				}
+				return editCmdlines(root, b.AddArgs, b.RemoveArgs)
+			},
+		})
+	}
+	return actions
+}
+
+// describeSettings lists settings in key order; a removed one is -key.
+func describeSettings(set map[string]string, sep string) string {
+	var out []string
+	for _, k := range slices.Sorted(maps.Keys(set)) {
+		if set[k] == "" {
+			out = append(out, "-"+k)
+			continue
+		}
+		out = append(out, k+sep+set[k])
+	}
+	return strings.Join(out, ", ")
+}
+
+// editFile rewrites a file the image already has; a missing one means the
+// config does not match the image.
+func editFile(file string, edit func(conf []byte) ([]byte, error)) error {
+	conf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	updated, err := edit(conf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(file), err)
+	}
+	return os.WriteFile(file, updated, 0o644)
+}
+
+// setEnv changes keys in a key=value file. A key keeps the place of its
+// first line, whatever config.txt section that is in, and its other lines
+// are dropped. New keys go at the end, after an [all] section header when
+// the file ends in another section.
+func setEnv(conf []byte, set map[string]string) []byte {
+	lines := strings.Split(strings.TrimSuffix(string(conf), "\n"), "\n")
+	if len(conf) == 0 {
+		lines = nil
+	}
+	done := map[string]bool{}
+	section := ""
+	var out []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			section = strings.ToLower(trimmed)
+		}
+		key, _, ok := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		v, want := set[key]
+		if !ok || !want || strings.HasPrefix(trimmed, "#") {
+			out = append(out, line)
+			continue
+		}
+		if v != "" && !done[key] {
+			out = append(out, key+"="+v)
+		}
+		done[key] = true
+	}
+	var added []string
+	for _, k := range slices.Sorted(maps.Keys(set)) {
+		if !done[k] && set[k] != "" {
+			added = append(added, k+"="+set[k])
+		}
+	}
+	if len(added) > 0 && section != "" && section != "[all]" {
+		out = append(out, "[all]")
+	}
+	out = append(out, added...)
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// setExtlinuxGlobals changes the directives before the first label. New
+// ones go right before it.
+func setExtlinuxGlobals(conf []byte, set map[string]string) []byte {
+	lines := strings.Split(string(conf), "\n")
+	first := len(lines)
+	if entries := extlinuxEntries(lines); len(entries) > 0 {
+		first = entries[0].start
+	}
+	done := map[string]bool{}
+	var out []string
+	for _, line := range lines[:first] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			out = append(out, line)
+			continue
+		}
+		key := strings.ToLower(fields[0])
+		v, want := set[key]
+		if !want {
+			out = append(out, line)
+			continue
+		}
+		if v != "" && !done[key] {
+			out = append(out, key+" "+v)
+		}
+		done[key] = true
+	}
+	for _, k := range slices.Sorted(maps.Keys(set)) {
+		if !done[k] && set[k] != "" {
+			out = append(out, k+" "+set[k])
+		}
+	}
+	return []byte(strings.Join(append(out, lines[first:]...), "\n"))
+}
+
+// argMatches reports whether a RemoveArgs entry removes arg.
+func argMatches(arg, remove string) bool {
+	return arg == remove || (!strings.Contains(remove, "=") && strings.HasPrefix(arg, remove+"="))
+}
+
+// editArgs removes and then adds kernel arguments. An argument already on
+// the command line is not added again.
+func editArgs(cmdline string, add, remove []string) string {
+	var args []string
+	for _, arg := range strings.Fields(cmdline) {
+		if !slices.ContainsFunc(remove, func(r string) bool { return argMatches(arg, r) }) {
+			args = append(args, arg)
+		}
+	}
+	for _, arg := range add {
+		if !slices.Contains(args, arg) {
+			args = append(args, arg)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// editCmdlines changes every cmdline.txt on a Raspberry Pi boot partition:
+// the one in its root, or one per slot on A/B images.
+func editCmdlines(root string, add, remove []string) error {
+	files := []string{"cmdline.txt"}
+	for _, slot := range []string{bootslot.A, bootslot.B} {
+		files = append(files, path.Join(bootslot.Dir(slot), "cmdline.txt"))
+	}
+	found := false
+	for _, name := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		err := editFile(file, func(conf []byte) ([]byte, error) {
+			return []byte(editArgs(string(conf), add, remove) + "\n"), nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return errors.New("no cmdline.txt on the boot partition")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetEnvKeepsPlaceAndSection(t *testing.T) {
+	conf := "arm_64bit=1\nboot_delay=1\n[pi4]\nenable_uart=1\nboot_delay=3\n"
+	got := setEnv([]byte(conf), map[string]string{"boot_delay": "0", "enable_uart": "", "disable_splash": "1"})
+	if want := "arm_64bit=1\nboot_delay=0\n[pi4]\n[all]\ndisable_splash=1\n"; string(got) != want {
+		t.Fatalf("config.txt = %q, want %q", got, want)
+	}
+
+	got = setEnv([]byte("verbosity=1\nconsole=both"), map[string]string{"console": "serial", "bootlogo": "false"})
+	if want := "verbosity=1\nconsole=serial\nbootlogo=false\n"; string(got) != want {
+		t.Fatalf("armbianEnv.txt = %q, want %q", got, want)
+	}
+}
+
+func TestSetExtlinuxGlobalsBeforeFirstLabel(t *testing.T) {
+	conf := "default TezSign-a\nprompt 1\nlabel TezSign-a\n   kernel /slot_a/Image\n"
+	got := setExtlinuxGlobals([]byte(conf), map[string]string{"prompt": "", "timeout": "0"})
+	if want := "default TezSign-a\ntimeout 0\nlabel TezSign-a\n   kernel /slot_a/Image\n"; string(got) != want {
+		t.Fatalf("extlinux.conf:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEditArgs(t *testing.T) {
+	got := editArgs("root=/dev/ram0 rw earlycon console=tty1 console=ttyS2,1500000n8 loglevel=7", []string{"quiet", "loglevel=0", "rw"}, []string{"console", "earlycon", "loglevel=7"})
+	if want := "root=/dev/ram0 rw quiet loglevel=0"; got != want {
+		t.Fatalf("cmdline = %q, want %q", got, want)
+	}
+}
+
+func TestBootEnvActionsEveryEntry(t *testing.T) {
+	b := BootEnv{AddArgs: []string{"quiet"}, RemoveArgs: []string{"console"}}
+
+	pi := t.TempDir()
+	for _, slot := range []string{"slot_a", "slot_b"} {
+		if err := os.MkdirAll(filepath.Join(pi, slot), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pi, slot, "cmdline.txt"), []byte("console=tty1 tezsign.slot=a\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	actions := bootEnvActions(bootRPi, b)
+	if len(actions) != 1 || actions[0].what != "kernel args -console +quiet (cmdline.txt)" {
+		t.Fatalf("actions = %+v", actions)
+	}
+	if err := actions[0].apply(pi); err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range []string{"slot_a", "slot_b"} {
+		if got, _ := os.ReadFile(filepath.Join(pi, slot, "cmdline.txt")); string(got) != "tezsign.slot=a quiet\n" {
+			t.Fatalf("%s/cmdline.txt = %q", slot, got)
+		}
+	}
+	if err := actions[0].apply(t.TempDir()); err == nil {
+		t.Fatal("no error without a cmdline.txt")
+	}
+
+	ext := t.TempDir()
+	conf := "default TezSign-a\nlabel TezSign-a\n   kernel /slot_a/Image\n   append rw console=ttyS2 tezsign.slot=a\nlabel TezSign-b\n   kernel /slot_b/Image\n"
+	if err := os.MkdirAll(filepath.Join(ext, "extlinux"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ext, "extlinux", "extlinux.conf"), []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bootEnvActions(bootExtlinux, b)[0].apply(ext); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(ext, "extlinux", "extlinux.conf"))
+	want := "default TezSign-a\nlabel TezSign-a\n   kernel /slot_a/Image\n   append rw tezsign.slot=a quiet\nlabel TezSign-b\n   kernel /slot_b/Image\n   append quiet\n"
+	if string(got) != want {
+		t.Fatalf("extlinux.conf:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBootEnvMergesAllFirst(t *testing.T) {
+	conf := `version = 1
+
+[all.boot_env]
+set = { boot_delay = "0", disable_splash = "1" }
+remove_args = ["console"]
+
+[flavours.rpi4]
+boot = "rpi"
+
+[flavours.rpi4.boot_env]
+set = { disable_splash = "" }
+add_args = ["quiet"]
+
+[flavours.radxa-zero3]
+boot = "extlinux"
+
+[flavours.radxa-zero3.boot_env]
+file = "/uEnv.txt"
+extlinux = { timeout = "0" }
+`
+	cfg, err := parseConfig([]byte(conf), t.TempDir())
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	f, err := cfg.flavour("rpi4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range plan(cfg, f) {
+		got = append(got, a.partition+": "+a.what)
+	}
+	want := []string{
+		"boot: set boot_delay=0, -disable_splash in /config.txt",
+		"boot: kernel args -console +quiet (cmdline.txt)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if cfg.All.BootEnv.Set["disable_splash"] != "1" {
+		t.Fatal("merging changed the [all] settings")
+	}
+
+	f, err = cfg.flavour("radxa-zero3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.BootEnv.file(f.Boot); got != "/uEnv.txt" {
+		t.Fatalf("env file = %q", got)
+	}
+}
+
+func TestParseConfigRejectsInvalidBootEnv(t *testing.T) {
+	cases := map[string]string{
+		"extlinux on rpi":  "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[flavours.rpi4.boot_env]\nextlinux = { timeout = \"0\" }\n",
+		"extlinux default": "version = 1\n[flavours.rock-pi-s]\nboot = \"extlinux\"\n[flavours.rock-pi-s.boot_env]\nextlinux = { default = \"x\" }\n",
+		"set without file": "version = 1\n[all.boot_env]\nset = { bootdelay = \"0\" }\n[flavours.rock-pi-s]\nboot = \"extlinux\"\n",
+		"reserved key":     "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[flavours.rpi4.boot_env]\nset = { os_prefix = \"x/\" }\n",
+		"key with space":   "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[flavours.rpi4.boot_env]\nset = { \"a b\" = \"1\" }\n",
+		"protected arg":    "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[flavours.rpi4.boot_env]\nremove_args = [\"tezsign.slot\"]\n",
+		"arg with space":   "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[flavours.rpi4.boot_env]\nadd_args = [\"a b\"]\n",
+		"relative file":    "version = 1\n[flavours.rock-pi-s]\nboot = \"extlinux\"\n[flavours.rock-pi-s.boot_env]\nfile = \"uEnv.txt\"\n",
+	}
+	for name, conf := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseConfig([]byte(conf), t.TempDir()); !errors.Is(err, errInvalidConfig) {
+				t.Fatalf("err = %v, want errInvalidConfig", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+)
+
+// configVersion is the only schema version this builder reads. Bump it when
+// a change would make an older builder misread a newer file.
+const configVersion = 1
+
+const (
+	bootRPi      = flavours.BootRPi
+	bootExtlinux = flavours.BootExtlinux
+)
+
+const partitionBoot = "boot"
+
+// partitionNames are the partitions steps may target. "app" covers both
+// slots of an A/B image.
+var partitionNames = []string{partitionBoot, constants.AppPartitionLabel, constants.DataPartitionLabel}
+
+var errInvalidConfig = errors.New("invalid builder config")
+
+// Config is the builder configuration file (builder.toml). Steps under
+// [all] run on every flavour, before the flavour's own, and the steps of
+// the hardening profile picked with --profile run last.
+type Config struct {
+	Version  int                `toml:"version"`
+	All      Steps              `toml:"all"`
+	Flavours map[string]Flavour `toml:"flavours"`
+	Profiles map[string]Profile `toml:"profiles"`
+
+	// dir resolves inject sources; it is the directory of the file.
+	dir string
+	// profile is the selected profile, "" for none.
+	profile string
+}
+
+type Flavour struct {
+	// Boot is how the board's boot loader is configured: "rpi" or "extlinux".
+	// Boards in tools/flavours default to theirs.
+	Boot string `toml:"boot"`
+	Steps
+
+	// board is the flavour's entry in tools/flavours, zero for boards the
+	// registry does not know.
+	board flavours.Flavour
+}
+
+// Profile is a hardening profile: steps every flavour gets when it is
+// selected. Extends names a profile whose steps run first.
+type Profile struct {
+	Extends string `toml:"extends"`
+	Steps
+}
+
+type Steps struct {
+	// Overlays are device tree overlays to enable: dtoverlay= lines on a
+	// Raspberry Pi, .dtbo paths on the boot partition in extlinux.conf.
+	Overlays []string  `toml:"overlays"`
+	Inject   []Inject  `toml:"inject"`
+	Remove   []Remove  `toml:"remove"`
+	Symlink  []Symlink `toml:"symlink"`
+	Chmod    []Chmod   `toml:"chmod"`
+	Hooks    []Hook    `toml:"hooks"`
+	BootEnv  BootEnv   `toml:"boot_env"`
+}
+
+type Inject struct {
+	Partition string `toml:"partition"`
+	Source    string `toml:"source"`
+	Path      string `toml:"path"`
+	Mode      string `toml:"mode"`
+}
+
+type Remove struct {
+	Partition string `toml:"partition"`
+	Path      string `toml:"path"`
+	// Optional skips a path the image does not have, for steps shared by
+	// boards whose partitions differ.
+	Optional bool `toml:"optional"`
+}
+
+type Symlink struct {
+	Partition string `toml:"partition"`
+	Path      string `toml:"path"`
+	Target    string `toml:"target"`
+}
+
+type Chmod struct {
+	Partition string `toml:"partition"`
+	Path      string `toml:"path"`
+	Mode      string `toml:"mode"`
+}
+
+// Hook runs a command at one stage of configure. Run[0] is looked up in
+// PATH unless it contains a slash, in which case it is relative to the
+// config file.
+type Hook struct {
+	Stage string   `toml:"stage"`
+	Run   []string `toml:"run"`
+}
+
+func loadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(data, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// parseConfig decodes and validates a config; dir resolves inject sources.
+func parseConfig(data []byte, dir string) (*Config, error) {
+	var cfg Config
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
+		return nil, errors.Join(errInvalidConfig, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return nil, fmt.Errorf("%w: unknown keys %s", errInvalidConfig, strings.Join(keys, ", "))
+	}
+	cfg.dir = dir
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Version != configVersion {
+		return fmt.Errorf("%w: version %d, this builder reads version %d", errInvalidConfig, c.Version, configVersion)
+	}
+	if len(c.Flavours) == 0 {
+		return fmt.Errorf("%w: no flavours", errInvalidConfig)
+	}
+	if err := c.All.validate("all", c.dir); err != nil {
+		return err
+	}
+	for name, p := range c.Profiles {
+		if err := p.Steps.validate("profiles."+name, c.dir); err != nil {
+			return err
+		}
+		if _, err := c.profileSteps(name); err != nil {
+			return err
+		}
+	}
+	for name, f := range c.Flavours {
+		if board, ok := flavours.Lookup(name); ok {
+			if f.Boot == "" {
+				f.Boot = board.Boot
+			} else if f.Boot != board.Boot {
+				return fmt.Errorf("%w: flavours.%s: the %s boots with %q", errInvalidConfig, name, board.Board, board.Boot)
+			}
+			f.board = board
+			c.Flavours[name] = f
+		}
+		if f.Boot != bootRPi && f.Boot != bootExtlinux {
+			return fmt.Errorf("%w: flavours.%s: boot must be %q or %q", errInvalidConfig, name, bootRPi, bootExtlinux)
+		}
+		if err := f.Steps.validate("flavours."+name, c.dir); err != nil {
+			return err
+		}
+		// [all] and the profiles may set what only some boot styles support
+		env := c.All.BootEnv.merge(f.BootEnv)
+		if err := env.validateFor("flavours."+name+".boot_env", f.Boot); err != nil {
+			return err
+		}
+		for profile := range c.Profiles {
+			steps, _ := c.profileSteps(profile)
+			if err := env.merge(steps.BootEnv).validateFor("profiles."+profile+".boot_env for "+name, f.Boot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// profileSteps returns a profile's steps after those of the profiles it
+// extends.
+func (c *Config) profileSteps(name string) (Steps, error) {
+	var chain []Steps
+	for seen := map[string]bool{}; name != ""; {
+		p, ok := c.Profiles[name]
+		if !ok {
+			return Steps{}, fmt.Errorf("%w: profile %q is not defined", errInvalidConfig, name)
+		}
+		if seen[name] {
+			return Steps{}, fmt.Errorf("%w: profile %q extends itself", errInvalidConfig, name)
+		}
+		seen[name] = true
+		chain = append(chain, p.Steps)
+		name = p.Extends
+	}
+	var steps Steps
+	for i := len(chain) - 1; i >= 0; i-- {
+		steps = steps.then(chain[i])
+	}
+	return steps, nil
+}
+
+// useProfile selects the profile every flavour gets; "" selects none.
+func (c *Config) useProfile(name string) error {
+	if name != "" {
+		if _, ok := c.Profiles[name]; !ok {
+			return fmt.Errorf("profile %q is not defined in the builder config (have %s)", name, strings.Join(slices.Sorted(maps.Keys(c.Profiles)), ", "))
+		}
+	}
+	c.profile = name
+	return nil
+}
+
+// flavour returns the flavour's definition with the [all] steps first and
+// the selected profile's last.
+func (c *Config) flavour(name string) (Flavour, error) {
+	f, ok := c.Flavours[name]
+	if !ok {
+		return Flavour{}, fmt.Errorf("flavour %q is not defined in the builder config", name)
+	}
+	steps := c.All.then(f.Steps)
+	if c.profile != "" {
+		profile, err := c.profileSteps(c.profile)
+		if err != nil {
+			return Flavour{}, err
+		}
+		steps = steps.then(profile)
+	}
+	return Flavour{Boot: f.Boot, Steps: steps, board: f.board}, nil
+}
+
+// then returns s followed by next.
+func (s Steps) then(next Steps) Steps {
+	return Steps{
+		Overlays: slices.Concat(s.Overlays, next.Overlays),
+		Inject:   slices.Concat(s.Inject, next.Inject),
+		Remove:   slices.Concat(s.Remove, next.Remove),
+		Symlink:  slices.Concat(s.Symlink, next.Symlink),
+		Chmod:    slices.Concat(s.Chmod, next.Chmod),
+		Hooks:    slices.Concat(s.Hooks, next.Hooks),
+		BootEnv:  s.BootEnv.merge(next.BootEnv),
+	}
+}
+
+// source is the path an inject step copies from.
+func (c *Config) source(i Inject) string {
+	if filepath.IsAbs(i.Source) {
+		return i.Source
+	}
+	return filepath.Join(c.dir, i.Source)
+}
+
+func (s Steps) empty() bool {
+	return len(s.Overlays) == 0 && len(s.Inject) == 0 && len(s.Remove) == 0 && len(s.Symlink) == 0 && len(s.Chmod) == 0 && len(s.Hooks) == 0 && s.BootEnv.empty()
+}
+
+// partitions lists the partitions the steps touch or have hooks for, in
+// partitionNames order.
+func (s Steps) partitions() []string {
+	used := map[string]bool{}
+	if len(s.Overlays) > 0 || !s.BootEnv.empty() {
+		used[partitionBoot] = true
+	}
+	for _, i := range s.Inject {
+		used[i.Partition] = true
+	}
+	for _, r := range s.Remove {
+		used[r.Partition] = true
+	}
+	for _, l := range s.Symlink {
+		used[l.Partition] = true
+	}
+	for _, m := range s.Chmod {
+		used[m.Partition] = true
+	}
+	for _, h := range s.Hooks {
+		if name, ok := strings.CutPrefix(h.Stage, "post-"); ok && slices.Contains(partitionNames, name) {
+			used[name] = true
+		}
+	}
+	var out []string
+	for _, name := range partitionNames {
+		if used[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func (s Steps) validate(where, dir string) error {
+	for i, o := range s.Overlays {
+		if strings.TrimSpace(o) == "" || strings.ContainsAny(o, "\n\r") {
+			return fmt.Errorf("%w: %s.overlays[%d] is empty or spans lines", errInvalidConfig, where, i)
+		}
+	}
+	if err := s.BootEnv.validate(where + ".boot_env"); err != nil {
+		return err
+	}
+	for i, in := range s.Inject {
+		at := fmt.Sprintf("%s.inject[%d]", where, i)
+		if err := validateTarget(at, in.Partition, in.Path); err != nil {
+			return err
+		}
+		if in.Source == "" {
+			return fmt.Errorf("%w: %s: source is required", errInvalidConfig, at)
+		}
+		src := in.Source
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(dir, src)
+		}
+		if st, err := os.Stat(src); err != nil || !st.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s: source %s is not a readable file", errInvalidConfig, at, src)
+		}
+		if in.Mode != "" {
+			if _, err := parseMode(in.Mode); err != nil {
+				return fmt.Errorf("%w: %s: %v", errInvalidConfig, at, err)
+			}
+		}
+	}
+	for i, r := range s.Remove {
+		if err := validateTarget(fmt.Sprintf("%s.remove[%d]", where, i), r.Partition, r.Path); err != nil {
+			return err
+		}
+	}
+	for i, l := range s.Symlink {
+		at := fmt.Sprintf("%s.symlink[%d]", where, i)
+		if err := validateTarget(at, l.Partition, l.Path); err != nil {
+			return err
+		}
+		if l.Partition == partitionBoot {
+			return fmt.Errorf("%w: %s: the boot partition may be FAT and cannot hold symlinks", errInvalidConfig, at)
+		}
+		if l.Target == "" {
+			return fmt.Errorf("%w: %s: target is required", errInvalidConfig, at)
+		}
+	}
+	for i, m := range s.Chmod {
+		at := fmt.Sprintf("%s.chmod[%d]", where, i)
+		if err := validateTarget(at, m.Partition, m.Path); err != nil {
+			return err
+		}
+		if _, err := parseMode(m.Mode); err != nil {
+			return fmt.Errorf("%w: %s: %v", errInvalidConfig, at, err)
+		}
+	}
+	for i, h := range s.Hooks {
+		at := fmt.Sprintf("%s.hooks[%d]", where, i)
+		if !slices.Contains(hookStages, h.Stage) {
+			return fmt.Errorf("%w: %s: stage must be one of %s", errInvalidConfig, at, strings.Join(hookStages, ", "))
+		}
+		if len(h.Run) == 0 || h.Run[0] == "" {
+			return fmt.Errorf("%w: %s: run is required", errInvalidConfig, at)
+		}
+	}
+	return nil
+}
+
+func validateTarget(at, partition, p string) error {
+	if !slices.Contains(partitionNames, partition) {
+		return fmt.Errorf("%w: %s: partition must be one of %s", errInvalidConfig, at, strings.Join(partitionNames, ", "))
+	}
+	if !path.IsAbs(p) || path.Clean(p) != p || p == "/" {
+		return fmt.Errorf("%w: %s: path %q must be absolute and clean", errInvalidConfig, at, p)
+	}
+	return nil
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0o7777 {
+		return 0, fmt.Errorf("mode %q is not an octal permission", s)
+	}
+	return os.FileMode(v), nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tez-capital/tezsign/tools/flavours"
+)
+
+func TestDefaultConfigDefinesReleaseFlavours(t *testing.T) {
+	cfg, err := parseConfig(defaultConfig, ".")
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	for _, name := range flavours.Names() {
+		f, err := cfg.flavour(name)
+		if err != nil {
+			t.Fatalf("flavour %s: %v", name, err)
+		}
+		if board, _ := flavours.Lookup(name); f.Boot != board.Boot {
+			t.Fatalf("flavour %s boots with %q, want %q", name, f.Boot, board.Boot)
+		}
+		if !f.empty() {
+			t.Fatalf("flavour %s has steps; the default config should change nothing", name)
+		}
+	}
+}
+
+func TestDefaultConfigProfiles(t *testing.T) {
+	cfg, err := parseConfig(defaultConfig, ".")
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if err := cfg.useProfile("strict"); err == nil {
+		t.Fatal("unknown profile accepted")
+	}
+	if err := cfg.useProfile("paranoid"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rpi0-2w", "radxa-zero3"} {
+		f, err := cfg.flavour(name)
+		if err != nil {
+			t.Fatalf("flavour %s: %v", name, err)
+		}
+		args := f.BootEnv.AddArgs
+		if len(args) == 0 || args[0] != "sysctl.kernel.kptr_restrict=2" || !slices.Contains(args, "oops=panic") {
+			t.Fatalf("%s: paranoid args %v, want standard's first", name, args)
+		}
+		for _, r := range f.Remove {
+			if !r.Optional {
+				t.Fatalf("%s: profile removes %s unconditionally", name, r.Path)
+			}
+		}
+	}
+}
+
+func TestProfileStepsRunLast(t *testing.T) {
+	conf := `version = 1
+
+[all.boot_env]
+add_args = ["quiet"]
+
+[profiles.base.boot_env]
+add_args = ["sysctl.kernel.sysrq=0"]
+
+[profiles.strict]
+extends = "base"
+
+[[profiles.strict.remove]]
+partition = "boot"
+path = "/start_db.elf"
+optional = true
+
+[flavours.rpi4]
+boot = "rpi"
+
+[[flavours.rpi4.remove]]
+partition = "app"
+path = "/usr/bin/board"
+`
+	cfg, err := parseConfig([]byte(conf), t.TempDir())
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	if f, _ := cfg.flavour("rpi4"); len(f.BootEnv.AddArgs) != 1 || len(f.Remove) != 1 {
+		t.Fatalf("profile applied without --profile: %+v", f.Steps)
+	}
+	if err := cfg.useProfile("strict"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := cfg.flavour("rpi4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range plan(cfg, f) {
+		got = append(got, a.partition+": "+a.what)
+	}
+	want := []string{
+		"boot: kernel args +quiet +sysctl.kernel.sysrq=0 (cmdline.txt)",
+		"boot: remove /start_db.elf (if present)",
+		"app: remove /usr/bin/board",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	boot := t.TempDir()
+	for _, a := range plan(cfg, f) {
+		if strings.HasPrefix(a.what, "remove /start_db.elf") {
+			if err := a.apply(boot); err != nil {
+				t.Fatalf("optional remove of a missing file: %v", err)
+			}
+		}
+	}
+}
+
+func TestParseConfigRejectsInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "motd"), []byte("hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"version":      "version = 2\n[flavours.rpi4]\nboot = \"rpi\"\n",
+		"no flavours":  "version = 1\n",
+		"unknown key":  "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\nkernel = \"x\"\n",
+		"boot":         "version = 1\n[flavours.rpi4]\nboot = \"grub\"\n",
+		"board boot":   "version = 1\n[flavours.rpi4]\nboot = \"extlinux\"\n",
+		"custom boot":  "version = 1\n[flavours.my-board]\n",
+		"partition":    "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.remove]]\npartition = \"rootfs\"\npath = \"/x\"\n",
+		"relative":     "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.remove]]\npartition = \"app\"\npath = \"x\"\n",
+		"unclean":      "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.remove]]\npartition = \"app\"\npath = \"/a/../x\"\n",
+		"source":       "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.inject]]\npartition = \"app\"\nsource = \"missing\"\npath = \"/etc/motd\"\n",
+		"mode":         "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.inject]]\npartition = \"app\"\nsource = \"motd\"\npath = \"/etc/motd\"\nmode = \"0999\"\n",
+		"boot symlink": "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.symlink]]\npartition = \"boot\"\npath = \"/a\"\ntarget = \"b\"\n",
+		"all invalid":  "version = 1\n[[all.chmod]]\npartition = \"app\"\npath = \"/x\"\nmode = \"rw\"\n[flavours.rpi4]\nboot = \"rpi\"\n",
+		"hook stage":   "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.hooks]]\nstage = \"post-rootfs\"\nrun = [\"true\"]\n",
+		"hook run":     "version = 1\n[flavours.rpi4]\nboot = \"rpi\"\n[[flavours.rpi4.hooks]]\nstage = \"post-app\"\n",
+		"extends":      "version = 1\n[profiles.strict]\nextends = \"base\"\n[flavours.rpi4]\nboot = \"rpi\"\n",
+		"extends loop": "version = 1\n[profiles.a]\nextends = \"b\"\n[profiles.b]\nextends = \"a\"\n[flavours.rpi4]\nboot = \"rpi\"\n",
+		"profile boot": "version = 1\n[profiles.strict.boot_env]\nextlinux = { timeout = \"0\" }\n[flavours.rpi4]\nboot = \"rpi\"\n",
+	}
+	for name, conf := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseConfig([]byte(conf), dir); !errors.Is(err, errInvalidConfig) {
+				t.Fatalf("err = %v, want errInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestPlanRunsAllStepsFirstGroupedByPartition(t *testing.T) {
+	conf := `version = 1
+
+[[all.chmod]]
+partition = "data"
+path = "/tezsign"
+mode = "0700"
+
+[[all.remove]]
+partition = "app"
+path = "/usr/bin/common"
+
+[flavours.rpi4]
+boot = "rpi"
+overlays = ["disable-bt"]
+
+[[flavours.rpi4.remove]]
+partition = "app"
+path = "/usr/bin/board"
+`
+	cfg, err := parseConfig([]byte(conf), t.TempDir())
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	f, err := cfg.flavour("rpi4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, a := range plan(cfg, f) {
+		got = append(got, a.partition+": "+a.what)
+	}
+	want := []string{
+		"boot: enable overlays disable-bt (rpi)",
+		"app: remove /usr/bin/common",
+		"app: remove /usr/bin/board",
+		"data: chmod 0700 /tezsign",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if parts := f.partitions(); strings.Join(parts, ",") != "boot,app,data" {
+		t.Fatalf("partitions = %v", parts)
+	}
+}
+
+func TestAddRPiOverlaysSkipsLoaded(t *testing.T) {
+	got, err := addRPiOverlays([]byte("arm_64bit=1\ndtoverlay=dwc2"), []string{"dwc2", "disable-bt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "arm_64bit=1\ndtoverlay=dwc2\ndtoverlay=disable-bt\n"; string(got) != want {
+		t.Fatalf("config.txt = %q, want %q", got, want)
+	}
+}
+
+func TestAddExtlinuxOverlaysEveryLabel(t *testing.T) {
+	conf := `default a
+label a
+    kernel /a/Image
+    fdtoverlays /overlays/usb.dtbo
+label b
+    kernel /b/Image
+`
+	got, err := addExtlinuxOverlays([]byte(conf), []string{"/overlays/usb.dtbo", "/overlays/i2c.dtbo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `default a
+label a
+    kernel /a/Image
+    fdtoverlays /overlays/usb.dtbo /overlays/i2c.dtbo
+label b
+    kernel /b/Image
+    fdtoverlays /overlays/usb.dtbo /overlays/i2c.dtbo
+`
+	if string(got) != want {
+		t.Fatalf("extlinux.conf:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := addExtlinuxOverlays([]byte("default a\n"), []string{"/x.dtbo"}); err == nil {
+		t.Fatal("expected an error without labels")
+	}
+}
+
+// dirMounter hands out plain directories in place of mounted partitions.
+type dirMounter struct {
+	roots     map[string]string
+	unmounted []string
+}
+
+func (m *dirMounter) mount(image string, p imagePartition) (string, func() error, error) {
+	return m.roots[p.Label], func() error {
+		m.unmounted = append(m.unmounted, p.Label)
+		return nil
+	}, nil
+}
+
+func TestApplyToPartition(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "cmdline.txt"), []byte("console=tty1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	boot, data := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(data, "stale"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(data, "tezsign"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{dir: src}
+	f := Flavour{Boot: bootRPi, Steps: Steps{
+		Inject: []Inject{{Partition: partitionBoot, Source: "cmdline.txt", Path: "/firmware/cmdline.txt", Mode: "0644"}},
+		Remove: []Remove{{Partition: "data", Path: "/stale"}},
+		Chmod:  []Chmod{{Partition: "data", Path: "/tezsign", Mode: "0700"}},
+	}}
+	actions := plan(cfg, f)
+	m := &dirMounter{roots: map[string]string{"boot": boot, "data": data}}
+	logf := func(string, ...any) {}
+
+	for _, p := range []imagePartition{{Index: 1, Label: "boot"}, {Index: 3, Label: "data"}} {
+		if err := applyToPartition("img", p, partitionName(p.Label), actions, m, logf, nil); err != nil {
+			t.Fatalf("%s: %v", p.Label, err)
+		}
+	}
+
+	if st, err := os.Stat(filepath.Join(boot, "firmware", "cmdline.txt")); err != nil || st.Mode().Perm() != 0o644 {
+		t.Fatalf("injected file: %v, %v", st, err)
+	}
+	if _, err := os.Lstat(filepath.Join(data, "stale")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale file still present: %v", err)
+	}
+	if st, err := os.Stat(filepath.Join(data, "tezsign")); err != nil || st.Mode().Perm() != 0o700 {
+		t.Fatalf("chmod: %v, %v", st, err)
+	}
+
+	// a failed step still unmounts, so the scratch copy is not left behind
+	if err := applyToPartition("img", imagePartition{Index: 3, Label: "data"}, "data", actions, m, logf, nil); err == nil {
+		t.Fatal("removing a missing file should fail")
+	}
+	if strings.Join(m.unmounted, ",") != "boot,data,data" {
+		t.Fatalf("unmounted = %v", m.unmounted)
+	}
+}
+
+func TestRecordAppHashes(t *testing.T) {
+	boot := t.TempDir()
+	if err := os.Mkdir(filepath.Join(boot, "slot_b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	layout := &imageLayout{partitions: []imagePartition{{Index: 1, Label: "boot"}, {Index: 2, Label: "app_a"}, {Index: 3, Label: "app_b"}}}
+	sum := strings.Repeat("ab", 32)
+	m := &dirMounter{roots: map[string]string{"boot": boot}}
+
+	if err := recordAppHashes("img", layout, map[string]string{appSlot("app_b"): sum}, m, func(string, ...any) {}); err != nil {
+		t.Fatalf("recordAppHashes: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(boot, "slot_b", "tezsign.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sum + "  tezsign\n"; string(data) != want {
+		t.Fatalf("tezsign.sha256 = %q, want %q", data, want)
+	}
+	if appSlot("app") != "" {
+		t.Fatal("the plain app partition has no slot")
+	}
+}
+
+func TestPatchPartitions(t *testing.T) {
+	targets := []imagePartition{{Index: 1, Label: "boot"}, {Index: 2, Label: "app_a"}, {Index: 3, Label: "app_b"}, {Index: 4, Label: "data"}}
+
+	var mu sync.Mutex
+	running, peak, seen := 0, 0, 0
+	err := patchPartitions(targets, 2, func(p imagePartition) error {
+		mu.Lock()
+		running++
+		seen++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != len(targets) || peak != 2 {
+		t.Fatalf("patched %d partitions, %d at once; want %d, 2", seen, peak, len(targets))
+	}
+
+	bad := errors.New("disk full")
+	var started atomic.Int32
+	err = patchPartitions(targets, 1, func(p imagePartition) error {
+		started.Add(1)
+		if p.Label == "app_a" {
+			return bad
+		}
+		return nil
+	})
+	if !errors.Is(err, bad) {
+		t.Fatalf("err = %v, want %v", err, bad)
+	}
+	if n := started.Load(); n != 2 {
+		t.Fatalf("%d partitions started, want 2 (none after the failure)", n)
+	}
+}
+
+func TestWritePlanListsSlots(t *testing.T) {
+	conf := `version = 1
+
+[flavours.radxa-zero3]
+boot = "extlinux"
+
+[[flavours.radxa-zero3.remove]]
+partition = "app"
+path = "/usr/bin/board"
+
+[[flavours.radxa-zero3.hooks]]
+stage = "post-image"
+run = ["./sign.sh", "--quiet"]
+`
+	cfg, err := parseConfig([]byte(conf), t.TempDir())
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	f, err := cfg.flavour("radxa-zero3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := &imageLayout{partitions: []imagePartition{
+		{Index: 1, Label: "boot"}, {Index: 2, Label: "app_a"}, {Index: 3, Label: "app_b"}, {Index: 4, Label: "data"},
+	}}
+
+	var b strings.Builder
+	if err := writePlan(&b, cfg, "radxa-zero3", f, layout); err != nil {
+		t.Fatal(err)
+	}
+	want := `flavour radxa-zero3 (boot: extlinux)
+app_a:
+  remove /usr/bin/board
+app_b:
+  remove /usr/bin/board
+app hashes:
+  record tezsign hash in slot_a/tezsign.sha256
+  record tezsign hash in slot_b/tezsign.sha256
+post-image:
+  hook post-image: ./sign.sh --quiet
+`
+	if b.String() != want {
+		t.Fatalf("plan:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	layout.partitions = layout.partitions[:1]
+	if err := writePlan(io.Discard, cfg, "radxa-zero3", f, layout); err == nil {
+		t.Fatal("plan for an image without an app partition")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/release"
+)
+
+type imagePartition struct {
+	Index int // 1-based
+	Label string
+	Type  filesystem.Type
+	Start int64
+	Size  int64
+}
+
+// imageLayout is what configureImage needs to know about a built image.
+type imageLayout struct {
+	partitions []imagePartition
+	flavour    string
+}
+
+func readImageLayout(image string) (*imageLayout, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	d, err := diskfs.OpenBackend(file.New(f, true), diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", image, err)
+	}
+	defer d.Close()
+
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("read partition table: %w", err)
+	}
+	layout := &imageLayout{}
+	for idx, p := range table.GetPartitions() {
+		if p == nil || p.GetSize() == 0 {
+			continue
+		}
+		fs, err := d.GetFilesystem(idx + 1)
+		if err != nil {
+			// go-diskfs cannot open every ext4 feature set (the data
+			// partition uses inline_data); the label is still readable
+			if label, ok := ext4Label(d.Backend, p.GetStart()); ok {
+				layout.partitions = append(layout.partitions, imagePartition{
+					Index: idx + 1, Label: label, Type: filesystem.TypeExt4, Start: p.GetStart(), Size: p.GetSize(),
+				})
+			}
+			continue
+		}
+		ip := imagePartition{
+			Index: idx + 1,
+			Label: strings.TrimSpace(fs.Label()),
+			Type:  fs.Type(),
+			Start: p.GetStart(),
+			Size:  p.GetSize(),
+		}
+		if layout.flavour == "" && partitionName(ip.Label) == constants.AppPartitionLabel {
+			layout.flavour = release.ReadMarker(fs, flavours.Marker)
+		}
+		fs.Close()
+		layout.partitions = append(layout.partitions, ip)
+	}
+	return layout, nil
+}
+
+// ext4Label reads the volume label from the ext4 superblock of the
+// partition at start.
+func ext4Label(r io.ReaderAt, start int64) (string, bool) {
+	sb := make([]byte, 0x88)
+	if _, err := r.ReadAt(sb, start+1024); err != nil {
+		return "", false
+	}
+	if binary.LittleEndian.Uint16(sb[0x38:]) != 0xef53 {
+		return "", false
+	}
+	return strings.TrimRight(string(sb[0x78:0x88]), "\x00"), true
+}
+
+// partitionName maps a filesystem label to the config's partition names.
+func partitionName(label string) string {
+	switch label {
+	case "boot", "bootfs":
+		return partitionBoot
+	case constants.AppPartitionLabel, bootslot.AppLabel(bootslot.A), bootslot.AppLabel(bootslot.B):
+		return constants.AppPartitionLabel
+	case constants.DataPartitionLabel:
+		return constants.DataPartitionLabel
+	}
+	return ""
+}
+
+func (l *imageLayout) targets(name string) []imagePartition {
+	var out []imagePartition
+	for _, p := range l.partitions {
+		if partitionName(p.Label) == name {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// configureImage applies the flavour's steps to the image in place and runs
+// its hooks. Each partition is mounted once; the steps for it run in config
+// order, then its post-<partition> hooks. Partitions are separate byte ranges
+// of the image, so up to jobs of them (all when jobs is 0) are patched at
+// the same time; hooks still run one at a time.
+func configureImage(cfg *Config, flavourName, image string, m mounter, jobs int, logf func(format string, args ...any)) error {
+	logf = syncLogf(logf)
+	layout, err := readImageLayout(image)
+	if err != nil {
+		return err
+	}
+	if flavourName == "" {
+		if flavourName = layout.flavour; flavourName == "" {
+			return fmt.Errorf("%s has no .image-flavour; pass --flavour", image)
+		}
+	}
+	f, err := cfg.flavour(flavourName)
+	if err != nil {
+		return err
+	}
+	if cfg.profile != "" {
+		logf("%s: %s profile", image, cfg.profile)
+	}
+	if f.empty() {
+		logf("%s: nothing to configure for %s", image, flavourName)
+		return nil
+	}
+	actions := plan(cfg, f)
+	abs, err := filepath.Abs(image)
+	if err != nil {
+		return err
+	}
+	env := hookEnv{image: abs, flavour: flavourName}
+
+	var targets []imagePartition
+	appTargets := 0
+	for _, name := range f.partitions() {
+		t := layout.targets(name)
+		if len(t) == 0 {
+			return fmt.Errorf("%s has no %s partition", image, name)
+		}
+		if name == constants.AppPartitionLabel {
+			appTargets = len(t)
+		}
+		targets = append(targets, t...)
+	}
+
+	total := len(targets) + 2 // the pre-patch and post-image hooks
+	if appTargets > 0 {
+		total++
+	}
+	pr := newProgress(total, logf)
+
+	var hookMu sync.Mutex
+	runHooks := func(stage string, extra []string) error {
+		hookMu.Lock()
+		defer hookMu.Unlock()
+		return cfg.runHooks(f.Hooks, stage, env, extra, logf)
+	}
+
+	if err := pr.stage(stagePrePatch, func() error { return runHooks(stagePrePatch, nil) }); err != nil {
+		return err
+	}
+	var sumsMu sync.Mutex
+	sums := map[string]string{}
+	err = patchPartitions(targets, jobs, func(p imagePartition) error {
+		name := partitionName(p.Label)
+		after := func(root string) error {
+			extra := []string{"TEZSIGN_PARTITION=" + p.Label, "TEZSIGN_PARTITION_ROOT=" + root}
+			if err := runHooks(postStage(name), extra); err != nil {
+				return err
+			}
+			if name != constants.AppPartitionLabel {
+				return nil
+			}
+			sum, err := apphash.SumFile(filepath.Join(root, apphash.Binary))
+			if err != nil {
+				return err
+			}
+			sumsMu.Lock()
+			sums[appSlot(p.Label)] = sum
+			sumsMu.Unlock()
+			return nil
+		}
+		return pr.stage(p.Label, func() error {
+			return applyToPartition(image, p, name, actions, m, logf, after)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if appTargets > 0 {
+		// the boot partition is written again, so this waits for all of them
+		if err := pr.stage("app hashes", func() error { return recordAppHashes(image, layout, sums, m, logf) }); err != nil {
+			return err
+		}
+	}
+	return pr.stage(stagePostImage, func() error { return runHooks(stagePostImage, nil) })
+}
+
+// patchPartitions calls patch for every partition, at most jobs at a time
+// (all at once when jobs is 0). After a failure no further partition is
+// started, but those already running finish, since a half-written fuse copy
+// cannot be abandoned cleanly; the errors are returned together.
+func patchPartitions(targets []imagePartition, jobs int, patch func(p imagePartition) error) error {
+	if jobs <= 0 || jobs > len(targets) {
+		jobs = len(targets)
+	}
+	sem := make(chan struct{}, max(jobs, 1))
+	errs := make([]error, len(targets))
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, p := range targets {
+		sem <- struct{}{}
+		if failed.Load() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if errs[i] = patch(p); errs[i] != nil {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// planImage is configure --dry-run. The image, if given, is only read for its
+// flavour and partition labels.
+func planImage(w io.Writer, cfg *Config, flavourName, image string) error {
+	var layout *imageLayout
+	if image != "" {
+		var err error
+		if layout, err = readImageLayout(image); err != nil {
+			return err
+		}
+		if flavourName == "" {
+			if flavourName = layout.flavour; flavourName == "" {
+				return fmt.Errorf("%s has no .image-flavour; pass --flavour", image)
+			}
+		}
+	}
+	if flavourName == "" {
+		return errors.New("--dry-run without an image needs --flavour")
+	}
+	f, err := cfg.flavour(flavourName)
+	if err != nil {
+		return err
+	}
+	return writePlan(w, cfg, flavourName, f, layout)
+}
+
+// appSlot is the A/B slot of an app partition label, "" for the plain one.
+func appSlot(label string) string {
+	for _, slot := range []string{bootslot.A, bootslot.B} {
+		if label == bootslot.AppLabel(slot) {
+			return slot
+		}
+	}
+	return ""
+}
+
+// recordAppHashes rewrites the signer hashes on the boot partition after
+// the app partitions changed, so app-verify.service accepts the customized
+// image. sums maps a slot ("" on plain images) to its app's hash.
+func recordAppHashes(image string, layout *imageLayout, sums map[string]string, m mounter, logf func(format string, args ...any)) error {
+	boot := layout.targets(partitionBoot)
+	if len(boot) != 1 {
+		return fmt.Errorf("%s: want one boot partition to record the app hash, found %d", image, len(boot))
+	}
+	return applyToPartition(image, boot[0], partitionBoot, nil, m, logf, func(root string) error {
+		for slot, sum := range sums {
+			logf("%s: record %s hash in %s", boot[0].Label, apphash.Binary, apphash.Path(slot))
+			if err := os.WriteFile(filepath.Join(root, apphash.Path(slot)), apphash.Marshal(sum), 0o644); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// applyToPartition mounts p, applies the actions for it and calls after, if
+// set, before unmounting.
+func applyToPartition(image string, p imagePartition, name string, actions []action, m mounter, logf func(format string, args ...any), after func(root string) error) error {
+	root, unmount, err := m.mount(image, p)
+	if err != nil {
+		return err
+	}
+	for _, a := range actions {
+		if a.partition != name {
+			continue
+		}
+		logf("%s: %s", p.Label, a.what)
+		if err := a.apply(root); err != nil {
+			_ = unmount()
+			return fmt.Errorf("%s: %s: %w", p.Label, a.what, err)
+		}
+	}
+	if after != nil {
+		if err := after(root); err != nil {
+			_ = unmount()
+			return fmt.Errorf("%s: %w", p.Label, err)
+		}
+	}
+	return unmount()
+}
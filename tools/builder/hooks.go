@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+const (
+	stagePrePatch  = "pre-patch"  // before any partition is mounted
+	stagePostImage = "post-image" // after every partition is written back
+)
+
+// hookStages are the configure stages. pre-patch runs first and post-image
+// last; a post-<partition> hook runs once for every matching partition, after
+// its steps, while it is still mounted. Partitions are patched concurrently,
+// so the post-<partition> stages have no fixed order among themselves.
+var hookStages = []string{
+	stagePrePatch,
+	postStage(partitionBoot),
+	postStage(constants.AppPartitionLabel),
+	postStage(constants.DataPartitionLabel),
+	stagePostImage,
+}
+
+func postStage(partition string) string {
+	return "post-" + partition
+}
+
+// hookEnv is what every hook gets on top of the builder's environment.
+type hookEnv struct {
+	image   string
+	flavour string
+}
+
+func (e hookEnv) vars(stage string) []string {
+	return []string{
+		"TEZSIGN_HOOK_STAGE=" + stage,
+		"TEZSIGN_IMAGE=" + e.image,
+		"TEZSIGN_FLAVOUR=" + e.flavour,
+	}
+}
+
+// command resolves a hook's executable: relative to the config file when it
+// names a path, from PATH otherwise.
+func (c *Config) command(name string) string {
+	if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+		return filepath.Join(c.dir, name)
+	}
+	return name
+}
+
+// runHooks runs the hooks registered for stage in config order and stops at
+// the first that fails. extra is added to the hook environment.
+func (c *Config) runHooks(hooks []Hook, stage string, env hookEnv, extra []string, logf func(format string, args ...any)) error {
+	for _, h := range hooks {
+		if h.Stage != stage {
+			continue
+		}
+		logf("hook %s: %s", stage, strings.Join(h.Run, " "))
+		cmd := exec.Command(c.command(h.Run[0]), h.Run[1:]...)
+		cmd.Dir = c.dir
+		cmd.Env = append(append(os.Environ(), env.vars(stage)...), extra...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %s: %w", stage, h.Run[0], err)
+		}
+	}
+	return nil
+}
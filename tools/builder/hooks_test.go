@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartitionHookRunsMountedAfterSteps(t *testing.T) {
+	dir := t.TempDir()
+	hook := "#!/bin/sh\nset -e\nprintf '%s %s %s %s\\n' \"$TEZSIGN_HOOK_STAGE\" \"$TEZSIGN_FLAVOUR\" \"$TEZSIGN_PARTITION\" \"$1\" >> \"$TEZSIGN_PARTITION_ROOT/hook.log\"\ntest ! -e \"$TEZSIGN_PARTITION_ROOT/stale\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "record.sh"), []byte(hook), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	conf := `version = 1
+
+[[all.hooks]]
+stage = "post-data"
+run = ["./record.sh", "all"]
+
+[flavours.rpi4]
+boot = "rpi"
+
+[[flavours.rpi4.remove]]
+partition = "data"
+path = "/stale"
+
+[[flavours.rpi4.hooks]]
+stage = "post-data"
+run = ["./record.sh", "flavour"]
+`
+	cfg, err := parseConfig([]byte(conf), dir)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	f, err := cfg.flavour("rpi4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := f.partitions(); strings.Join(parts, ",") != "data" {
+		t.Fatalf("partitions = %v", parts)
+	}
+
+	data := t.TempDir()
+	if err := os.WriteFile(filepath.Join(data, "stale"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := &dirMounter{roots: map[string]string{"data": data}}
+	env := hookEnv{image: "/tmp/rpi4.img", flavour: "rpi4"}
+	logf := func(string, ...any) {}
+	p := imagePartition{Index: 3, Label: "data"}
+	after := func(root string) error {
+		return cfg.runHooks(f.Hooks, postStage("data"), env, []string{"TEZSIGN_PARTITION=" + p.Label, "TEZSIGN_PARTITION_ROOT=" + root}, logf)
+	}
+	if err := applyToPartition("img", p, "data", plan(cfg, f), m, logf, after); err != nil {
+		t.Fatalf("applyToPartition: %v", err)
+	}
+
+	log, err := os.ReadFile(filepath.Join(data, "hook.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "post-data rpi4 data all\npost-data rpi4 data flavour\n"; string(log) != want {
+		t.Fatalf("hook.log = %q, want %q", log, want)
+	}
+	if len(m.unmounted) != 1 {
+		t.Fatalf("unmounted = %v", m.unmounted)
+	}
+}
+
+func TestFailingHookStopsConfigure(t *testing.T) {
+	cfg := &Config{dir: t.TempDir()}
+	hooks := []Hook{{Stage: stagePrePatch, Run: []string{"false"}}, {Stage: stagePrePatch, Run: []string{"./never-run"}}}
+	err := cfg.runHooks(hooks, stagePrePatch, hookEnv{}, nil, func(string, ...any) {})
+	if err == nil || !strings.Contains(err.Error(), "pre-patch hook false") {
+		t.Fatalf("err = %v", err)
+	}
+}
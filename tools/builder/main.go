@@ -0,0 +1,610 @@
+// tezsign-builder turns the image a kas build leaves in kas/release into
+// release artifacts: it applies the builder config (builder.toml), checks
+// the result, then compresses it and writes the manifest, SHA256SUMS and the
+// minisign signatures published next to every image.
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/tools/release"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	envSigningKeyFile = "TEZSIGN_SIGNING_KEY_FILE"
+	envConfigFile     = "TEZSIGN_BUILDER_CONFIG"
+	envMount          = "TEZSIGN_BUILDER_MOUNT"
+	envProfile        = "TEZSIGN_BUILDER_PROFILE"
+	envChannel        = "TEZSIGN_RELEASE_CHANNEL"
+)
+
+// defaultConfig defines the release flavours with no extra steps.
+//
+//go:embed builder.toml
+var defaultConfig []byte
+
+func main() {
+	app := &cli.Command{
+		Name:  "tezsign-builder",
+		Usage: "Release tooling for built TezSign images",
+		Commands: []*cli.Command{
+			cmdConfigure(),
+			cmdVerifyImage(),
+			cmdProvision(),
+			cmdPackage(),
+			cmdManifest(),
+			cmdSums(),
+			cmdSign(),
+			cmdPubkey(),
+			cmdVerify(),
+		},
+	}
+	if err := app.Run(context.Background(), os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func keyFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:     "key",
+		Usage:    "file holding the hex ed25519 seed of the release key",
+		Sources:  cli.EnvVars(envSigningKeyFile),
+		Required: true,
+	}
+}
+
+// channelFlag is the update channel a manifest records. The updater applies
+// that channel's policy, so it is signed with the manifest.
+func channelFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:     "channel",
+		Usage:    "update channel the release is published on: " + strings.Join(release.Channels, ", "),
+		Sources:  cli.EnvVars(envChannel),
+		Required: true,
+	}
+}
+
+func checkChannel(channel string) error {
+	if !slices.Contains(release.Channels, channel) {
+		return fmt.Errorf("unknown channel %q (want %s)", channel, strings.Join(release.Channels, ", "))
+	}
+	return nil
+}
+
+func cmdConfigure() *cli.Command {
+	return &cli.Command{
+		Name:      "configure",
+		Usage:     "Apply the builder config's steps and hooks for the image's flavour to a raw image in place",
+		ArgsUsage: "<image.img>",
+		Flags: []cli.Flag{
+			configFlag(),
+			profileFlag(),
+			&cli.StringFlag{
+				Name:  "flavour",
+				Usage: "flavour to apply (default: the image's .image-flavour)",
+			},
+			&cli.StringFlag{
+				Name:  "scratch",
+				Usage: "directory for mount points and fuse's partition copies",
+				Value: os.TempDir(),
+			},
+			mountFlag(),
+			&cli.IntFlag{
+				Name:  "jobs",
+				Usage: "partitions patched at the same time (0: all); fuse needs scratch space for each",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "print the steps and hooks for each partition without changing the image; the image is optional with --flavour",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			dryRun := c.Bool("dry-run")
+			if c.Args().Len() > 1 || c.Args().Len() == 0 && !dryRun {
+				return errors.New("usage: configure [--config builder.toml] [--profile name] [--flavour name] [--dry-run] <image.img>")
+			}
+			cfg, err := builderConfig(c)
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				return planImage(os.Stdout, cfg, c.String("flavour"), c.Args().First())
+			}
+			logf := func(format string, args ...any) { fmt.Printf(format+"\n", args...) }
+			m, err := newMounter(c.String("mount"), c.String("scratch"), logf)
+			if err != nil {
+				return err
+			}
+			return configureImage(cfg, c.String("flavour"), c.Args().First(), m, c.Int("jobs"), logf)
+		},
+	}
+}
+
+func mountFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "mount",
+		Usage:   "how partitions are mounted: loop (root), fuse, or auto to use loop devices when they work",
+		Sources: cli.EnvVars(envMount),
+		Value:   mountAuto,
+	}
+}
+
+func configFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "config",
+		Usage:   "builder config file (default: the built-in builder.toml)",
+		Sources: cli.EnvVars(envConfigFile),
+	}
+}
+
+func profileFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "profile",
+		Usage:   "hardening profile from the builder config to apply after the flavour's steps, e.g. standard or paranoid",
+		Sources: cli.EnvVars(envProfile),
+	}
+}
+
+func builderConfig(c *cli.Command) (*Config, error) {
+	var cfg *Config
+	var err error
+	if file := c.String("config"); file != "" {
+		cfg, err = loadConfig(file)
+	} else {
+		cfg, err = parseConfig(defaultConfig, ".")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.useProfile(c.String("profile")); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func cmdVerifyImage() *cli.Command {
+	return &cli.Command{
+		Name:      "verify-image",
+		Usage:     "Check a raw image's partitions, signer binary, boot config, rootfs and configured steps",
+		ArgsUsage: "<image.img>",
+		Flags: []cli.Flag{
+			configFlag(),
+			profileFlag(),
+			&cli.StringFlag{
+				Name:  "flavour",
+				Usage: "flavour the image must be (default: the image's .image-flavour)",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return errors.New("usage: verify-image [--config builder.toml] [--profile name] [--flavour name] <image.img>")
+			}
+			cfg, err := builderConfig(c)
+			if err != nil {
+				return err
+			}
+			image := c.Args().First()
+			problems, err := verifyImage(cfg, c.String("flavour"), image)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				fmt.Printf("%s: FAIL: %s\n", image, p)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%s: %d problems", image, len(problems))
+			}
+			fmt.Printf("%s: OK\n", image)
+			return nil
+		},
+	}
+}
+
+func cmdProvision() *cli.Command {
+	return &cli.Command{
+		Name:      "provision",
+		Usage:     "Write a provision.toml into the boot partition of an image or flashed card",
+		ArgsUsage: "<image.img|device>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "file",
+				Usage:    "provision.toml to write",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "hostname",
+				Usage: "replace the file's hostname",
+			},
+			&cli.StringFlag{
+				Name:  "label",
+				Usage: "replace the file's device label",
+			},
+			&cli.StringFlag{
+				Name:  "serial",
+				Usage: "replace the file's USB serial",
+			},
+			&cli.StringFlag{
+				Name:  "scratch",
+				Usage: "directory for the mount point and fuse's partition copy",
+				Value: os.TempDir(),
+			},
+			mountFlag(),
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return errors.New("usage: provision --file provision.toml [--hostname name] [--label label] [--serial serial] <image.img|device>")
+			}
+			template, err := os.ReadFile(c.String("file"))
+			if err != nil {
+				return err
+			}
+			data, err := renderProvision(template, provisionOverrides{
+				hostname: c.String("hostname"),
+				label:    c.String("label"),
+				serial:   c.String("serial"),
+			})
+			if err != nil {
+				return err
+			}
+			logf := func(format string, args ...any) { fmt.Printf(format+"\n", args...) }
+			m, err := newMounter(c.String("mount"), c.String("scratch"), logf)
+			if err != nil {
+				return err
+			}
+			return provisionImage(c.Args().First(), data, m, logf)
+		},
+	}
+}
+
+func cmdPackage() *cli.Command {
+	return &cli.Command{
+		Name:      "package",
+		Usage:     "Write the release artifacts for a raw image: .img.xz, signer binary, manifest and SHA256SUMS, signed when a key is given",
+		ArgsUsage: "<image.img>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "release",
+				Usage: "release name (default: the image name without .img)",
+			},
+			&cli.StringFlag{
+				Name:  "out",
+				Usage: "directory for the artifacts (default: next to the image)",
+			},
+			channelFlag(),
+			&cli.StringFlag{
+				Name:    "key",
+				Usage:   "file holding the hex ed25519 seed; signs every artifact",
+				Sources: cli.EnvVars(envSigningKeyFile),
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return errors.New("usage: package --channel name [--release name] [--out dir] [--key file] <image.img>")
+			}
+			if err := checkChannel(c.String("channel")); err != nil {
+				return err
+			}
+			image := c.Args().First()
+			name := c.String("release")
+			if name == "" {
+				name = strings.TrimSuffix(filepath.Base(image), ".img")
+			}
+			dir := c.String("out")
+			if dir == "" {
+				dir = filepath.Dir(image)
+			}
+
+			m, err := release.NewManifest(name, image)
+			if err != nil {
+				return err
+			}
+			m.Channel = c.String("channel")
+			xzPath := filepath.Join(dir, name+".img.xz")
+			compressed, err := release.CompressImage(image, xzPath)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d bytes\n", xzPath, compressed.Size)
+			m.Artifacts = append(m.Artifacts, compressed)
+			// pushed over USB with tezsign advanced update --app; the
+			// gadget takes it only with its signature
+			binPath := filepath.Join(dir, name+release.AppBinaryExt)
+			binary, err := release.ExtractAppBinary(image, binPath)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d bytes\n", binPath, binary.Size)
+			m.Artifacts = append(m.Artifacts, binary)
+
+			data, err := m.Marshal()
+			if err != nil {
+				return err
+			}
+			manifestPath := filepath.Join(dir, release.ManifestName(name))
+			if err := os.WriteFile(manifestPath, data, 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s %s (%s), %d partitions\n", manifestPath, m.Flavour, m.Version, m.Channel, len(m.Partitions))
+
+			manifest, err := release.HashFile(manifestPath)
+			if err != nil {
+				return err
+			}
+			sumsPath := filepath.Join(dir, release.SumsName)
+			if err := release.UpdateSums(sumsPath, compressed, binary, manifest); err != nil {
+				return err
+			}
+			fmt.Println(sumsPath)
+
+			keyFile := c.String("key")
+			if keyFile == "" {
+				return nil
+			}
+			return signFiles(keyFile, []string{xzPath, binPath, manifestPath, sumsPath}, releaseFields(m)...)
+		},
+	}
+}
+
+func cmdSums() *cli.Command {
+	return &cli.Command{
+		Name:      "sums",
+		Usage:     "Add files to a SHA256SUMS list, signed when a key is given",
+		ArgsUsage: "<file...>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "out",
+				Usage: "checksum list to update",
+				Value: release.SumsName,
+			},
+			&cli.StringFlag{
+				Name:    "key",
+				Usage:   "file holding the hex ed25519 seed; signs the list",
+				Sources: cli.EnvVars(envSigningKeyFile),
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return errors.New("usage: sums [--out SHA256SUMS] [--key file] <file...>")
+			}
+			out := c.String("out")
+			var files []release.File
+			for _, path := range c.Args().Slice() {
+				// the list cannot hold its own checksum or signature
+				if base := filepath.Base(path); base == filepath.Base(out) || base == filepath.Base(out)+minisign.SignatureExt {
+					continue
+				}
+				f, err := release.HashFile(path)
+				if err != nil {
+					return err
+				}
+				files = append(files, f)
+			}
+			if err := release.UpdateSums(out, files...); err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d files\n", out, len(files))
+
+			keyFile := c.String("key")
+			if keyFile == "" {
+				return nil
+			}
+			return signFiles(keyFile, []string{out})
+		},
+	}
+}
+
+func cmdManifest() *cli.Command {
+	return &cli.Command{
+		Name:      "manifest",
+		Usage:     "Write <release>.manifest.json for a raw image, signed when a key is given",
+		ArgsUsage: "<image.img>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "release",
+				Usage: "release name (default: the image name without .img)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "artifact",
+				Usage: "published file built from the image, e.g. the .img.xz (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:  "out",
+				Usage: "directory for the manifest (default: next to the image)",
+			},
+			channelFlag(),
+			&cli.StringFlag{
+				Name:    "key",
+				Usage:   "file holding the hex ed25519 seed; signs the manifest and every artifact",
+				Sources: cli.EnvVars(envSigningKeyFile),
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 1 {
+				return errors.New("usage: manifest --channel name [--release name] [--artifact file...] [--key file] <image.img>")
+			}
+			if err := checkChannel(c.String("channel")); err != nil {
+				return err
+			}
+			image := c.Args().First()
+			name := c.String("release")
+			if name == "" {
+				name = strings.TrimSuffix(filepath.Base(image), ".img")
+			}
+
+			m, err := release.NewManifest(name, image)
+			if err != nil {
+				return err
+			}
+			m.Channel = c.String("channel")
+			for _, a := range c.StringSlice("artifact") {
+				if err := m.AddArtifact(a); err != nil {
+					return err
+				}
+			}
+			data, err := m.Marshal()
+			if err != nil {
+				return err
+			}
+
+			dir := c.String("out")
+			if dir == "" {
+				dir = filepath.Dir(image)
+			}
+			path := filepath.Join(dir, release.ManifestName(name))
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s %s (%s), %d partitions\n", path, m.Flavour, m.Version, m.Channel, len(m.Partitions))
+
+			keyFile := c.String("key")
+			if keyFile == "" {
+				return nil
+			}
+			return signFiles(keyFile, append([]string{path}, c.StringSlice("artifact")...), releaseFields(m)...)
+		},
+	}
+}
+
+func cmdSign() *cli.Command {
+	return &cli.Command{
+		Name:      "sign",
+		Usage:     "Write a detached minisign signature (<file>.minisig) for each file",
+		ArgsUsage: "<file...>",
+		Flags:     []cli.Flag{keyFlag()},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return errors.New("usage: sign --key file <file...>")
+			}
+			return signFiles(c.String("key"), c.Args().Slice())
+		},
+	}
+}
+
+// releaseFields are the trusted comment fields naming the release of m. The
+// gadget reads them off a pushed binary's signature (see appupdate), which
+// has no manifest next to it.
+func releaseFields(m *release.Manifest) []string {
+	return []string{"version:" + m.Version, "date:" + m.Date}
+}
+
+// signFiles signs each path with a trusted comment of its timestamp, its
+// name and fields.
+func signFiles(keyFile string, paths []string, fields ...string) error {
+	key, err := minisign.LoadKey(keyFile)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		comment := strings.Join(slices.Concat(
+			[]string{fmt.Sprintf("timestamp:%d", time.Now().Unix()), "file:" + filepath.Base(path)},
+			fields,
+			[]string{"hashed"},
+		), "\t")
+		if err := minisign.SignFile(key, path, comment); err != nil {
+			return err
+		}
+		fmt.Printf("%s%s\n", path, minisign.SignatureExt)
+	}
+	return nil
+}
+
+func cmdPubkey() *cli.Command {
+	return &cli.Command{
+		Name:  "pubkey",
+		Usage: "Print the minisign public key of the release key",
+		Flags: []cli.Flag{keyFlag()},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			key, err := minisign.LoadKey(c.String("key"))
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(minisign.NewPublicKey(key).File())
+			return err
+		},
+	}
+}
+
+func cmdVerify() *cli.Command {
+	return &cli.Command{
+		Name:      "verify",
+		Usage:     "Check files against their .minisig; a manifest's hashes are checked against the files next to it",
+		ArgsUsage: "<file...>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "pubkey",
+				Usage:    "minisign public key (base64) or .pub file",
+				Required: true,
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() == 0 {
+				return errors.New("usage: verify --pubkey key <file...>")
+			}
+			keyText := c.String("pubkey")
+			if data, err := os.ReadFile(keyText); err == nil {
+				keyText = string(data)
+			}
+			pub, err := minisign.ParsePublicKey(keyText)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, path := range c.Args().Slice() {
+				if err := verifyFile(pub, path); err != nil {
+					failed++
+					fmt.Printf("%s: FAIL: %v\n", path, err)
+					continue
+				}
+				fmt.Printf("%s: OK\n", path)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files failed verification", failed, c.Args().Len())
+			}
+			return nil
+		},
+	}
+}
+
+func verifyFile(pub minisign.PublicKey, path string) error {
+	if _, err := minisign.VerifyFile(pub, path); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(path, release.ManifestExt) {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m, err := release.ParseManifest(data)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	for _, want := range append([]release.File{m.Image}, m.Artifacts...) {
+		got, err := release.HashFile(filepath.Join(dir, want.Name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if got.SHA256 != want.SHA256 || got.Size != want.Size {
+			return fmt.Errorf("%s does not match the manifest", want.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// mounter makes one partition of an image file available as a directory.
+// unmount must be called and writes the changes back into the image.
+// configure mounts several partitions of one image at the same time.
+type mounter interface {
+	mount(image string, p imagePartition) (root string, unmount func() error, err error)
+}
+
+const (
+	mountAuto = "auto"
+	mountLoop = "loop"
+	mountFuse = "fuse"
+)
+
+// errNoLoop means loop devices cannot be used here, as opposed to a
+// partition that fails to mount.
+var errNoLoop = errors.New("loop devices unavailable")
+
+// newMounter picks how partitions are mounted. "auto" uses loop devices when
+// running as root and falls back to fuse when the kernel or container does
+// not allow them.
+func newMounter(kind, scratch string, logf func(format string, args ...any)) (mounter, error) {
+	fuse := &fuseMounter{scratch: scratch}
+	loop := &loopMounter{scratch: scratch}
+	switch kind {
+	case mountFuse:
+		return fuse, nil
+	case mountLoop:
+		if err := loopUsable(); err != nil {
+			return nil, err
+		}
+		return loop, nil
+	case mountAuto, "":
+		if err := loopUsable(); err != nil {
+			return fuse, nil
+		}
+		return &autoMounter{loop: loop, fuse: fuse, logf: logf}, nil
+	}
+	return nil, fmt.Errorf("unknown mount method %q (want %s, %s or %s)", kind, mountAuto, mountLoop, mountFuse)
+}
+
+// loopUsable checks what can be checked without attaching a device.
+func loopUsable() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("%w: not running as root", errNoLoop)
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		return fmt.Errorf("%w: %v", errNoLoop, err)
+	}
+	for _, tool := range []string{"losetup", "mount", "umount"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%w: %s not found", errNoLoop, tool)
+		}
+	}
+	return nil
+}
+
+// autoMounter tries loop devices and switches to fuse for good the first
+// time they turn out not to work, e.g. in an unprivileged container.
+type autoMounter struct {
+	loop, fuse mounter
+	logf       func(format string, args ...any)
+
+	mu      sync.Mutex
+	useFuse bool
+}
+
+func (m *autoMounter) mount(image string, p imagePartition) (string, func() error, error) {
+	m.mu.Lock()
+	useFuse := m.useFuse
+	m.mu.Unlock()
+	if !useFuse {
+		root, unmount, err := m.loop.mount(image, p)
+		if !errors.Is(err, errNoLoop) {
+			return root, unmount, err
+		}
+		m.mu.Lock()
+		if !m.useFuse {
+			m.logf("%v; mounting with fuse", err)
+			m.useFuse = true
+		}
+		m.mu.Unlock()
+	}
+	return m.fuse.mount(image, p)
+}
+
+// loopMounter attaches the partition to a loop device and mounts it with
+// the kernel's own driver. It needs root but no copy of the partition, so
+// it is much faster than fuse on large partitions.
+type loopMounter struct {
+	scratch string
+}
+
+func (m *loopMounter) mount(image string, p imagePartition) (string, func() error, error) {
+	var fstype string
+	switch p.Type {
+	case filesystem.TypeExt4:
+		fstype = "ext4"
+	case filesystem.TypeFat32, filesystem.TypeFat16, filesystem.TypeFat12:
+		fstype = "vfat"
+	default:
+		return "", nil, fmt.Errorf("partition %d (%s): unsupported filesystem", p.Index, p.Label)
+	}
+
+	root, err := os.MkdirTemp(m.scratch, fmt.Sprintf("part%d-", p.Index))
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := exec.Command("losetup", "--find", "--show",
+		"--offset", strconv.FormatInt(p.Start, 10),
+		"--sizelimit", strconv.FormatInt(p.Size, 10),
+		image).Output()
+	if err != nil {
+		os.Remove(root)
+		return "", nil, fmt.Errorf("%w: losetup: %v", errNoLoop, commandError(err))
+	}
+	device := strings.TrimSpace(string(out))
+	detach := func() error {
+		if out, err := exec.Command("losetup", "--detach", device).CombinedOutput(); err != nil {
+			return fmt.Errorf("detach %s: %v: %s", device, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if out, err := exec.Command("mount", "-t", fstype, device, root).CombinedOutput(); err != nil {
+		_ = detach()
+		os.Remove(root)
+		msg := strings.TrimSpace(string(out))
+		// a container may hand out loop devices but refuse the mount
+		if strings.Contains(msg, "permission denied") || strings.Contains(msg, "must be superuser") {
+			return "", nil, fmt.Errorf("%w: mount: %s", errNoLoop, msg)
+		}
+		return "", nil, fmt.Errorf("mount partition %d: %v: %s", p.Index, err, msg)
+	}
+
+	unmount := func() error {
+		if out, err := exec.Command("umount", root).CombinedOutput(); err != nil {
+			return fmt.Errorf("unmount partition %d: %v: %s", p.Index, err, strings.TrimSpace(string(out)))
+		}
+		os.Remove(root)
+		return detach()
+	}
+	return root, unmount, nil
+}
+
+func commandError(err error) string {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+		return strings.TrimSpace(string(exit.Stderr))
+	}
+	return err.Error()
+}
+
+// fuseMounter needs no root: it copies the partition into a scratch file,
+// mounts that with fuse2fs or fusefat, and copies it back on unmount. The
+// tools are looked up on first mount, so a config without steps needs none.
+type fuseMounter struct {
+	scratch string
+}
+
+func fusermount() (string, error) {
+	for _, tool := range []string{"fusermount3", "fusermount"} {
+		if p, err := exec.LookPath(tool); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("fusermount not found")
+}
+
+func (m *fuseMounter) mount(image string, p imagePartition) (string, func() error, error) {
+	dir, err := os.MkdirTemp(m.scratch, fmt.Sprintf("part%d-", p.Index))
+	if err != nil {
+		return "", nil, err
+	}
+	file := filepath.Join(dir, "partition.img")
+	root := filepath.Join(dir, "root")
+	cleanup := func() { os.RemoveAll(dir) }
+
+	if err := os.Mkdir(root, 0o755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := copyOut(image, p, file); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("extract partition %d: %w", p.Index, err)
+	}
+
+	var tool string
+	var args []string
+	switch p.Type {
+	case filesystem.TypeExt4:
+		// fakeroot lets the builder create root-owned files without being root
+		tool, args = "fuse2fs", []string{"-o", "fakeroot", file, root}
+	case filesystem.TypeFat32, filesystem.TypeFat16, filesystem.TypeFat12:
+		tool, args = "fusefat", []string{"-o", "rw+", file, root}
+	default:
+		cleanup()
+		return "", nil, fmt.Errorf("partition %d (%s): unsupported filesystem", p.Index, p.Label)
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("%s not found: %w", tool, err)
+	}
+	if _, err := fusermount(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if out, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("mount partition %d: %v: %s", p.Index, err, strings.TrimSpace(string(out)))
+	}
+
+	unmount := func() error {
+		defer cleanup()
+		tool, err := fusermount()
+		if err != nil {
+			return err
+		}
+		if out, err := exec.Command(tool, "-u", root).CombinedOutput(); err != nil {
+			return fmt.Errorf("unmount partition %d: %v: %s", p.Index, err, strings.TrimSpace(string(out)))
+		}
+		if err := copyIn(file, image, p); err != nil {
+			return fmt.Errorf("write back partition %d: %w", p.Index, err)
+		}
+		return nil
+	}
+	return root, unmount, nil
+}
+
+func copyOut(image string, p imagePartition, dst string) error {
+	in, err := os.Open(image)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(in, p.Start, p.Size)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyIn(src, image string, p imagePartition) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if st, err := in.Stat(); err != nil {
+		return err
+	} else if st.Size() != p.Size {
+		return fmt.Errorf("scratch file is %d bytes, partition is %d", st.Size(), p.Size)
+	}
+
+	out, err := os.OpenFile(image, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.NewOffsetWriter(out, p.Start), in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// failMounter fails every mount with err and counts the attempts.
+type failMounter struct {
+	err   error
+	calls int
+}
+
+func (m *failMounter) mount(image string, p imagePartition) (string, func() error, error) {
+	m.calls++
+	return "", nil, m.err
+}
+
+func TestAutoMounterFallsBackToFuse(t *testing.T) {
+	loop := &failMounter{err: fmt.Errorf("%w: losetup: permission denied", errNoLoop)}
+	fuse := &dirMounter{roots: map[string]string{"boot": "/boot-copy"}}
+	var logged []string
+	m := &autoMounter{loop: loop, fuse: fuse, logf: func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}}
+
+	for range 2 {
+		root, _, err := m.mount("img", imagePartition{Index: 1, Label: "boot"})
+		if err != nil || root != "/boot-copy" {
+			t.Fatalf("mount: %q, %v", root, err)
+		}
+	}
+	if loop.calls != 1 {
+		t.Fatalf("loop tried %d times, want once", loop.calls)
+	}
+	if len(logged) != 1 {
+		t.Fatalf("logged %q, want one fallback line", logged)
+	}
+}
+
+func TestAutoMounterKeepsLoopErrors(t *testing.T) {
+	bad := errors.New("mount partition 3: wrong fs type")
+	m := &autoMounter{loop: &failMounter{err: bad}, fuse: &dirMounter{}, logf: func(string, ...any) {}}
+
+	if _, _, err := m.mount("img", imagePartition{Index: 3, Label: "data"}); !errors.Is(err, bad) {
+		t.Fatalf("err = %v, want the loop error", err)
+	}
+	if m.useFuse {
+		t.Fatal("switched to fuse on a partition error")
+	}
+}
+
+func TestNewMounterRejectsUnknownKind(t *testing.T) {
+	if _, err := newMounter("nbd", t.TempDir(), func(string, ...any) {}); err == nil {
+		t.Fatal("unknown mount method accepted")
+	}
+	m, err := newMounter(mountFuse, t.TempDir(), func(string, ...any) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*fuseMounter); !ok {
+		t.Fatalf("fuse gave %T", m)
+	}
+}
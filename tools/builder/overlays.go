@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/bootslot"
+)
+
+const rpiConfigFile = "config.txt"
+
+func overlayAction(boot string, overlays []string) action {
+	return action{
+		partition: partitionBoot,
+		what:      fmt.Sprintf("enable overlays %s (%s)", strings.Join(overlays, ", "), boot),
+		apply: func(root string) error {
+			file := filepath.Join(root, rpiConfigFile)
+			edit := addRPiOverlays
+			if boot == bootExtlinux {
+				file = filepath.Join(root, bootslot.ExtlinuxFile)
+				edit = addExtlinuxOverlays
+			}
+			conf, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			updated, err := edit(conf, overlays)
+			if err != nil {
+				return fmt.Errorf("%s: %w", filepath.Base(file), err)
+			}
+			return os.WriteFile(file, updated, 0o644)
+		},
+	}
+}
+
+// addRPiOverlays appends a dtoverlay= line for every overlay config.txt does
+// not load yet.
+func addRPiOverlays(conf []byte, overlays []string) ([]byte, error) {
+	text := string(conf)
+	have := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "dtoverlay="); ok {
+			have[v] = true
+		}
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	for _, o := range overlays {
+		if !have[o] {
+			text += "dtoverlay=" + o + "\n"
+			have[o] = true
+		}
+	}
+	return []byte(text), nil
+}
+
+// extlinuxEntry is where one label's directives sit in extlinux.conf.
+type extlinuxEntry struct {
+	start      int            // the label line
+	last       int            // line of the label's last directive
+	directives map[string]int // first line of each directive
+	indent     string
+}
+
+// extlinuxEntries finds the labels in the lines of an extlinux.conf.
+func extlinuxEntries(lines []string) []extlinuxEntry {
+	var entries []extlinuxEntry
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key := strings.ToLower(fields[0])
+		if key == "label" {
+			entries = append(entries, extlinuxEntry{start: i, last: i, directives: map[string]int{}, indent: "   "})
+			continue
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		e := &entries[len(entries)-1]
+		e.last = i
+		e.indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if _, ok := e.directives[key]; !ok {
+			e.directives[key] = i
+		}
+	}
+	return entries
+}
+
+// editExtlinuxEntries calls edit with every label's line of the directive
+// key, or "" when the label has none, and writes back what it returns. A
+// label without the directive gets it as its last line.
+func editExtlinuxEntries(conf []byte, key string, edit func(line string) string) ([]byte, error) {
+	lines := strings.Split(string(conf), "\n")
+	entries := extlinuxEntries(lines)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no label to set %s in", key)
+	}
+	// back to front, so inserting a line keeps the earlier indexes valid
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if at, ok := e.directives[key]; ok {
+			lines[at] = edit(lines[at])
+			continue
+		}
+		lines = slices.Insert(lines, e.last+1, e.indent+edit(""))
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// addExtlinuxOverlays adds the overlays to the fdtoverlays line of every
+// label, so both slots of an A/B image get them.
+func addExtlinuxOverlays(conf []byte, overlays []string) ([]byte, error) {
+	return editExtlinuxEntries(conf, "fdtoverlays", func(line string) string {
+		if line == "" {
+			return "fdtoverlays " + strings.Join(overlays, " ")
+		}
+		have := strings.Fields(line)[1:]
+		line = strings.TrimRight(line, " \t")
+		for _, o := range overlays {
+			if !slices.Contains(have, o) {
+				line += " " + o
+			}
+		}
+		return line
+	})
+}
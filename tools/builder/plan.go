@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// action is one change to a mounted partition. Steps are turned into
+// actions up front so a whole flavour is checked before any partition is
+// mounted.
+type action struct {
+	partition string
+	what      string
+	apply     func(root string) error
+}
+
+// plan turns a flavour's steps into actions, grouped by partition in
+// partitionNames order and in config order within a partition.
+func plan(cfg *Config, f Flavour) []action {
+	var actions []action
+	if len(f.Overlays) > 0 {
+		actions = append(actions, overlayAction(f.Boot, f.Overlays))
+	}
+	actions = append(actions, bootEnvActions(f.Boot, f.BootEnv)...)
+	for _, in := range f.Inject {
+		actions = append(actions, injectAction(in, cfg.source(in)))
+	}
+	for _, r := range f.Remove {
+		actions = append(actions, removeAction(r))
+	}
+	for _, l := range f.Symlink {
+		actions = append(actions, symlinkAction(l))
+	}
+	for _, m := range f.Chmod {
+		actions = append(actions, chmodAction(m))
+	}
+
+	slices.SortStableFunc(actions, func(a, b action) int {
+		return slices.Index(partitionNames, a.partition) - slices.Index(partitionNames, b.partition)
+	})
+	return actions
+}
+
+// writePlan prints what configure would do, stage by stage, without
+// mounting anything. With a layout the partition stages are the image's
+// labels (app_a and app_b on A/B images); without one they are the config's
+// partition names.
+func writePlan(w io.Writer, cfg *Config, flavourName string, f Flavour, layout *imageLayout) error {
+	actions := plan(cfg, f)
+	hooks := func(stage string) {
+		for _, h := range f.Hooks {
+			if h.Stage == stage {
+				fmt.Fprintf(w, "  hook %s: %s\n", stage, strings.Join(h.Run, " "))
+			}
+		}
+	}
+	// the image-wide hook stages are only listed when they have hooks
+	imageHooks := func(stage string) {
+		if slices.ContainsFunc(f.Hooks, func(h Hook) bool { return h.Stage == stage }) {
+			fmt.Fprintf(w, "%s:\n", stage)
+			hooks(stage)
+		}
+	}
+
+	if cfg.profile != "" {
+		fmt.Fprintf(w, "flavour %s (boot: %s, profile: %s)\n", flavourName, f.Boot, cfg.profile)
+	} else {
+		fmt.Fprintf(w, "flavour %s (boot: %s)\n", flavourName, f.Boot)
+	}
+	if f.empty() {
+		fmt.Fprintln(w, "nothing to configure")
+		return nil
+	}
+	imageHooks(stagePrePatch)
+
+	var slots []string
+	for _, name := range f.partitions() {
+		labels := []string{name}
+		if layout != nil {
+			labels = labels[:0]
+			for _, p := range layout.targets(name) {
+				labels = append(labels, p.Label)
+			}
+			if len(labels) == 0 {
+				return fmt.Errorf("image has no %s partition", name)
+			}
+		}
+		for _, label := range labels {
+			fmt.Fprintf(w, "%s:\n", label)
+			for _, a := range actions {
+				if a.partition == name {
+					fmt.Fprintf(w, "  %s\n", a.what)
+				}
+			}
+			hooks(postStage(name))
+			if name == constants.AppPartitionLabel {
+				slots = append(slots, appSlot(label))
+			}
+		}
+	}
+	if len(slots) > 0 {
+		fmt.Fprintln(w, "app hashes:")
+		for _, slot := range slots {
+			if layout == nil {
+				fmt.Fprintf(w, "  record %s hash in %s (in each slot's directory on A/B images)\n", apphash.Binary, apphash.Path(""))
+				continue
+			}
+			fmt.Fprintf(w, "  record %s hash in %s\n", apphash.Binary, apphash.Path(slot))
+		}
+	}
+	imageHooks(stagePostImage)
+	return nil
+}
+
+// within resolves an absolute in-partition path below root.
+func within(root, p string) string {
+	return filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(path.Clean(p), "/")))
+}
+
+func injectAction(in Inject, source string) action {
+	mode := in.Mode
+	if mode == "" {
+		mode = "0644"
+	}
+	return action{
+		partition: in.Partition,
+		what:      fmt.Sprintf("inject %s -> %s (%s)", source, in.Path, mode),
+		apply: func(root string) error {
+			mode := os.FileMode(0o644)
+			if in.Mode != "" {
+				mode, _ = parseMode(in.Mode)
+			}
+			dst := within(root, in.Path)
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return err
+			}
+			if err := copyFile(source, dst, mode); err != nil {
+				return err
+			}
+			return chownRoot(in.Partition, dst)
+		},
+	}
+}
+
+func removeAction(r Remove) action {
+	what := "remove " + r.Path
+	if r.Optional {
+		what += " (if present)"
+	}
+	return action{
+		partition: r.Partition,
+		what:      what,
+		apply: func(root string) error {
+			dst := within(root, r.Path)
+			// a config that strips a file the image no longer has is out of date
+			if _, err := os.Lstat(dst); err != nil {
+				if r.Optional && errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			return os.RemoveAll(dst)
+		},
+	}
+}
+
+func symlinkAction(l Symlink) action {
+	return action{
+		partition: l.Partition,
+		what:      fmt.Sprintf("symlink %s -> %s", l.Path, l.Target),
+		apply: func(root string) error {
+			dst := within(root, l.Path)
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return err
+			}
+			if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := os.Symlink(l.Target, dst); err != nil {
+				return err
+			}
+			return chownRoot(l.Partition, dst)
+		},
+	}
+}
+
+func chmodAction(m Chmod) action {
+	return action{
+		partition: m.Partition,
+		what:      fmt.Sprintf("chmod %s %s", m.Mode, m.Path),
+		apply: func(root string) error {
+			mode, _ := parseMode(m.Mode)
+			return os.Chmod(within(root, m.Path), mode)
+		},
+	}
+}
+
+// chownRoot gives new files to root. Boot loaders ignore ownership, and a
+// FAT boot partition has none.
+func chownRoot(partition, p string) error {
+	if partition == partitionBoot {
+		return nil
+	}
+	return os.Lchown(p, 0, 0)
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile applies the umask and leaves an existing file's mode alone
+	return os.Chmod(dst, mode)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// progress numbers the configure stages as they finish. Partitions are
+// patched concurrently, so their lines arrive in any order.
+type progress struct {
+	mu    sync.Mutex
+	logf  func(format string, args ...any)
+	total int
+	done  int
+}
+
+func newProgress(total int, logf func(format string, args ...any)) *progress {
+	return &progress{total: total, logf: logf}
+}
+
+// stage runs fn and reports how long it took.
+func (p *progress) stage(name string, fn func() error) error {
+	start := time.Now()
+	p.logf("%s: started", name)
+	err := fn()
+	took := time.Since(start).Round(100 * time.Millisecond)
+
+	p.mu.Lock()
+	p.done++
+	n := p.done
+	p.mu.Unlock()
+	if err != nil {
+		p.logf("[%d/%d] %s: failed after %s", n, p.total, name, took)
+	} else {
+		p.logf("[%d/%d] %s: done in %s", n, p.total, name, took)
+	}
+	return err
+}
+
+// syncLogf serializes calls to logf, so lines from concurrent stages do not
+// interleave.
+func syncLogf(logf func(format string, args ...any)) func(format string, args ...any) {
+	var mu sync.Mutex
+	return func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		logf(format, args...)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tez-capital/tezsign/provision"
+)
+
+// provisionOverrides replace fields of a template provision.toml, so one
+// file serves a whole fleet with a hostname and label per card.
+type provisionOverrides struct {
+	hostname, label, serial string
+}
+
+func (o provisionOverrides) empty() bool {
+	return o == provisionOverrides{}
+}
+
+// renderProvision validates the template and applies the overrides. Without
+// overrides the file is written as given, comments included.
+func renderProvision(template []byte, o provisionOverrides) ([]byte, error) {
+	p, err := provision.Parse(template)
+	if err != nil {
+		return nil, err
+	}
+	if o.empty() {
+		return template, nil
+	}
+	if o.hostname != "" {
+		p.Hostname = o.hostname
+	}
+	if o.label != "" {
+		p.Label = o.label
+	}
+	if o.serial != "" {
+		p.Gadget.Serial = o.serial
+	}
+	data, err := p.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	// the overrides go through the same checks as the file
+	if _, err := provision.Parse(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// provisionImage writes provision.toml into the boot partition of an image
+// or a flashed card.
+func provisionImage(image string, data []byte, m mounter, logf func(format string, args ...any)) error {
+	layout, err := readImageLayout(image)
+	if err != nil {
+		return err
+	}
+	boot := layout.targets(partitionBoot)
+	if len(boot) != 1 {
+		return fmt.Errorf("%s: want one boot partition, found %d", image, len(boot))
+	}
+	return applyToPartition(image, boot[0], partitionBoot, nil, m, logf, func(root string) error {
+		logf("%s: write %s", boot[0].Label, provision.FileName)
+		return os.WriteFile(filepath.Join(root, provision.FileName), data, 0o644)
+	})
+}
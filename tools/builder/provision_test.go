@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tez-capital/tezsign/provision"
+)
+
+const provisionTemplate = `# fleet template
+version = 1
+hostname = "signer"
+
+[features]
+require_encryption = true
+`
+
+func TestRenderProvision(t *testing.T) {
+	got, err := renderProvision([]byte(provisionTemplate), provisionOverrides{})
+	if err != nil || string(got) != provisionTemplate {
+		t.Fatalf("without overrides = %q, %v; want the template unchanged", got, err)
+	}
+
+	got, err = renderProvision([]byte(provisionTemplate), provisionOverrides{hostname: "signer-07", label: "rack 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := provision.Parse(got)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", got, err)
+	}
+	if p.Hostname != "signer-07" || p.Label != "rack 2" || p.Features.RequireEncryption == nil || !*p.Features.RequireEncryption {
+		t.Fatalf("rendered %+v from %q", p, got)
+	}
+
+	if _, err := renderProvision([]byte(provisionTemplate), provisionOverrides{hostname: "bad_host"}); !errors.Is(err, provision.ErrInvalid) {
+		t.Fatalf("bad override err = %v, want ErrInvalid", err)
+	}
+	if _, err := renderProvision([]byte("version = 1\nwifi = true\n"), provisionOverrides{}); !errors.Is(err, provision.ErrInvalid) {
+		t.Fatalf("bad template err = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/appupdate"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/initramfs"
+	"github.com/tez-capital/tezsign/tools/release"
+)
+
+// rpiKernels are the names the Pi firmware loads when config.txt has no
+// kernel= line, newest board first.
+var rpiKernels = []string{"kernel_2712.img", "kernel8.img", "kernel7l.img", "kernel7.img", "kernel.img"}
+
+// baseUnits are enabled on every image (SYSTEMD_SERVICE in tezsign-core.bb).
+var baseUnits = []string{"setup-gadget.service", "attach-gadget.service", "ffs_registrar.service", "tezsign.service", "generate-serial.service", "app-verify.service", "provision.service"}
+
+// volume is one partition of the image, opened read-only. fs is nil if
+// go-diskfs cannot read the filesystem.
+type volume struct {
+	label string
+	fs    filesystem.FileSystem
+}
+
+// verifier collects every problem instead of stopping at the first, so one
+// run shows everything that is wrong with an image.
+type verifier struct {
+	problems []string
+}
+
+func (v *verifier) failf(format string, args ...any) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// verifyImage re-opens a finished image and checks it against what the
+// build and the builder config should have produced. It returns the
+// problems found; the error is only set when the image cannot be read.
+func verifyImage(cfg *Config, flavourName, image string) ([]string, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	d, err := diskfs.OpenBackend(file.New(f, true), diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", image, err)
+	}
+	defer d.Close()
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("read partition table: %w", err)
+	}
+
+	v := &verifier{}
+	byName := map[string][]volume{}
+	for idx, p := range table.GetPartitions() {
+		if p == nil || p.GetSize() == 0 {
+			continue
+		}
+		var vol volume
+		if fs, err := d.GetFilesystem(idx + 1); err == nil {
+			defer fs.Close()
+			vol = volume{label: strings.TrimSpace(fs.Label()), fs: fs}
+		} else if label, ok := ext4Label(d.Backend, p.GetStart()); ok {
+			// present, but the steps on it cannot be checked
+			vol = volume{label: label}
+		} else {
+			continue
+		}
+		name := partitionName(vol.label)
+		if name == "" {
+			continue
+		}
+		if slices.ContainsFunc(byName[name], func(o volume) bool { return o.label == vol.label }) {
+			v.failf("two partitions are labelled %s", vol.label)
+		}
+		byName[name] = append(byName[name], vol)
+	}
+
+	boot, data, apps := byName[partitionBoot], byName[constants.DataPartitionLabel], byName[constants.AppPartitionLabel]
+	if len(boot) != 1 {
+		v.failf("want one boot partition, found %d", len(boot))
+	}
+	if len(data) != 1 {
+		v.failf("want one data partition, found %d", len(data))
+	}
+	var appLabels []string
+	for _, app := range apps {
+		appLabels = append(appLabels, app.label)
+	}
+	slices.Sort(appLabels)
+	slotted := false
+	switch {
+	case slices.Equal(appLabels, []string{constants.AppPartitionLabel}):
+	case slices.Equal(appLabels, []string{bootslot.AppLabel(bootslot.A), bootslot.AppLabel(bootslot.B)}):
+		slotted = true
+	default:
+		v.failf("want an %s partition or %s and %s, found %v", constants.AppPartitionLabel, bootslot.AppLabel(bootslot.A), bootslot.AppLabel(bootslot.B), appLabels)
+	}
+
+	luks := false
+	for _, app := range apps {
+		if app.fs == nil {
+			v.failf("%s: cannot read the filesystem", app.label)
+			continue
+		}
+		marker := v.checkApp(app)
+		if flavourName == "" {
+			flavourName = marker
+		} else if marker != "" && marker != flavourName {
+			v.failf("%s: .image-flavour is %q, want %q", app.label, marker, flavourName)
+		}
+		if _, err := stat(app.fs, "/"+constants.DataLUKSMarker); err == nil {
+			luks = true
+		}
+	}
+
+	if flavourName == "" {
+		v.failf("cannot tell the image flavour; pass --flavour")
+		return v.problems, nil
+	}
+	fl, err := cfg.flavour(flavourName)
+	if err != nil {
+		v.failf("%v", err)
+		return v.problems, nil
+	}
+	if fl.board.Name != "" && table.Type() != fl.board.Table {
+		v.failf("partition table is %s, the %s uses %s", table.Type(), fl.board.Board, fl.board.Table)
+	}
+	if len(boot) == 1 && boot[0].fs == nil {
+		v.failf("boot: cannot read the filesystem")
+	} else if len(boot) == 1 {
+		v.checkBoard(boot[0], fl.board, slotted)
+		for _, kernel := range v.checkBoot(boot[0], fl, slotted) {
+			v.checkRootfs(boot[0], kernel, slotted, luks)
+		}
+		for _, app := range apps {
+			if app.fs != nil {
+				v.checkAppHash(boot[0], app)
+			}
+		}
+	}
+	v.checkSteps(cfg, fl, byName)
+	return v.problems, nil
+}
+
+// stat takes the in-partition path without its leading slash, which the
+// FAT driver rejects.
+func stat(fs filesystem.FileSystem, p string) (iofs.FileInfo, error) {
+	return fs.Stat(strings.TrimPrefix(p, "/"))
+}
+
+func readFile(fs filesystem.FileSystem, p string) ([]byte, error) {
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// checkApp checks the signer binary and the image markers on an app
+// partition and returns its flavour marker.
+func (v *verifier) checkApp(app volume) string {
+	st, err := stat(app.fs, "/tezsign")
+	switch {
+	case err != nil:
+		v.failf("%s: /tezsign is missing", app.label)
+	case !st.Mode().IsRegular() || st.Size() == 0:
+		v.failf("%s: /tezsign is not a regular, non-empty file", app.label)
+	case st.Mode().Perm()&0o111 == 0:
+		v.failf("%s: /tezsign is not executable (%v)", app.label, st.Mode().Perm())
+	default:
+		if head, err := readFile(app.fs, "/tezsign"); err != nil || !bytes.HasPrefix(head, []byte("\x7fELF")) {
+			v.failf("%s: /tezsign is not an ELF binary", app.label)
+		}
+	}
+
+	for _, marker := range []string{"/.image-version", "/.image-date"} {
+		if release.ReadMarker(app.fs, marker) == "" {
+			v.failf("%s: %s is missing or empty", app.label, marker)
+		}
+	}
+	// an image that takes pushed signers must name the release they are
+	// signed as
+	if key, err := readFile(app.fs, "/"+appupdate.KeyFile); err == nil && len(bytes.TrimSpace(key)) > 0 {
+		if name := release.ReadMarker(app.fs, "/"+appupdate.ReleaseFile); name == "" || name == "unknown" {
+			v.failf("%s: has %s but %s is missing or unknown", app.label, appupdate.KeyFile, appupdate.ReleaseFile)
+		}
+	}
+	flavour := release.ReadMarker(app.fs, flavours.Marker)
+	if flavour == "" || flavour == "unknown" {
+		v.failf("%s: .image-flavour is missing or unknown", app.label)
+		return ""
+	}
+	return flavour
+}
+
+// checkAppHash checks that the boot partition records the hash of the app
+// partition's signer, which app-verify.service compares before it starts.
+func (v *verifier) checkAppHash(boot, app volume) {
+	slot := ""
+	if s, ok := strings.CutPrefix(app.label, constants.AppPartitionLabel+"_"); ok {
+		slot = s
+	}
+	want, err := readFile(boot.fs, "/"+apphash.Path(slot))
+	if err != nil {
+		v.failf("boot: %s is missing", apphash.Path(slot))
+		return
+	}
+	f, err := app.fs.OpenFile("/"+apphash.Binary, os.O_RDONLY)
+	if err != nil {
+		return // checkApp reports it
+	}
+	defer f.Close()
+	if err := apphash.Check(want, f); err != nil {
+		v.failf("boot: %s: %v", apphash.Path(slot), err)
+	}
+}
+
+// checkBoard checks the boot partition against what tools/flavours expects
+// of the board: its filesystem and device tree.
+func (v *verifier) checkBoard(boot volume, board flavours.Flavour, slotted bool) {
+	if board.Name == "" {
+		return
+	}
+	fsType := map[filesystem.Type]string{filesystem.TypeFat32: flavours.FSVfat, filesystem.TypeExt4: flavours.FSExt4}[boot.fs.Type()]
+	if fsType != board.BootFS {
+		v.failf("boot: filesystem is not %s", board.BootFS)
+	}
+	for _, dir := range flavours.BootDirs(slotted) {
+		if _, err := stat(boot.fs, "/"+dir+board.DTB); err != nil {
+			v.failf("boot: %s device tree %s%s is missing", board.Board, dir, board.DTB)
+		}
+	}
+}
+
+// loadsOverlay reports whether one of the loaded overlays, as config.txt
+// (dwc2,dr_mode=otg) or extlinux.conf (/overlays/dwc2.dtbo) names them, is
+// the overlay called name.
+func loadsOverlay(loaded []string, name string) bool {
+	return slices.ContainsFunc(loaded, func(o string) bool {
+		o, _, _ = strings.Cut(o, ",")
+		return strings.TrimSuffix(path.Base(o), ".dtbo") == name
+	})
+}
+
+// checkBoot checks the boot loader configuration and returns the kernels it
+// boots.
+func (v *verifier) checkBoot(boot volume, f Flavour, slotted bool) []string {
+	if slotted {
+		if data, err := readFile(boot.fs, "/"+bootslot.StateFile); err != nil {
+			v.failf("boot: %s is missing", bootslot.StateFile)
+		} else if _, err := bootslot.ParseState(data); err != nil {
+			v.failf("boot: %s: %v", bootslot.StateFile, err)
+		}
+	}
+	if f.Boot == bootExtlinux {
+		return v.checkExtlinux(boot, f, slotted)
+	}
+	return v.checkRPi(boot, f, slotted)
+}
+
+func (v *verifier) checkRPi(boot volume, f Flavour, slotted bool) []string {
+	conf, err := readFile(boot.fs, "/"+rpiConfigFile)
+	if err != nil {
+		v.failf("boot: %s is missing", rpiConfigFile)
+		return nil
+	}
+	var loaded []string
+	kernel := ""
+	for _, line := range strings.Split(string(conf), "\n") {
+		line = strings.TrimSpace(line)
+		if o, ok := strings.CutPrefix(line, "dtoverlay="); ok {
+			loaded = append(loaded, o)
+		}
+		if k, ok := strings.CutPrefix(line, "kernel="); ok {
+			kernel = k
+		}
+	}
+	for _, o := range f.Overlays {
+		if !slices.Contains(loaded, o) {
+			v.failf("boot: %s does not load overlay %s", rpiConfigFile, o)
+		}
+	}
+	for _, o := range f.board.Overlays {
+		if !loadsOverlay(loaded, o) {
+			v.failf("boot: %s does not load the %s overlay %s", rpiConfigFile, f.board.Board, o)
+		}
+	}
+
+	prefixes := []string{""}
+	if slotted {
+		prefixes = []string{bootslot.Dir(bootslot.A) + "/", bootslot.Dir(bootslot.B) + "/"}
+		if !strings.Contains(string(conf), "include "+bootslot.PiSelectorFile) {
+			v.failf("boot: %s does not include %s", rpiConfigFile, bootslot.PiSelectorFile)
+		}
+		if _, err := stat(boot.fs, "/"+bootslot.PiSelectorFile); err != nil {
+			v.failf("boot: %s is missing", bootslot.PiSelectorFile)
+		}
+	}
+
+	var kernels []string
+	for i, prefix := range prefixes {
+		cmdline, err := readFile(boot.fs, "/"+prefix+"cmdline.txt")
+		if err != nil {
+			v.failf("boot: %scmdline.txt is missing", prefix)
+		} else {
+			if slotted {
+				if want := []string{bootslot.A, bootslot.B}[i]; bootslot.FromCmdline(string(cmdline)) != want {
+					v.failf("boot: %scmdline.txt does not set %s=%s", prefix, bootslot.CmdlineKey, want)
+				}
+			}
+			v.checkKernelArgs(prefix+"cmdline.txt", string(cmdline), f.BootEnv)
+		}
+
+		candidates := rpiKernels
+		if kernel != "" {
+			candidates = []string{kernel}
+		}
+		found := ""
+		for _, k := range candidates {
+			if _, err := stat(boot.fs, "/"+prefix+k); err == nil {
+				found = "/" + prefix + k
+				break
+			}
+		}
+		if found == "" {
+			v.failf("boot: no kernel in /%s (looked for %s)", prefix, strings.Join(candidates, ", "))
+			continue
+		}
+		kernels = append(kernels, found)
+	}
+	return kernels
+}
+
+type extlinuxLabel struct {
+	name     string
+	kernel   string
+	fdt      string
+	overlays []string
+	args     string
+}
+
+func parseExtlinux(conf []byte) []extlinuxLabel {
+	var labels []extlinuxLabel
+	for _, line := range strings.Split(string(conf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key := strings.ToLower(fields[0])
+		if key == "label" {
+			labels = append(labels, extlinuxLabel{name: fields[1]})
+			continue
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		l := &labels[len(labels)-1]
+		switch key {
+		case "kernel", "linux":
+			l.kernel = fields[1]
+		case "fdt", "devicetree":
+			l.fdt = fields[1]
+		case "fdtoverlays":
+			l.overlays = append(l.overlays, fields[1:]...)
+		case "append":
+			l.args = strings.Join(fields[1:], " ")
+		}
+	}
+	return labels
+}
+
+func (v *verifier) checkExtlinux(boot volume, f Flavour, slotted bool) []string {
+	conf, err := readFile(boot.fs, "/"+bootslot.ExtlinuxFile)
+	if err != nil {
+		v.failf("boot: %s is missing", bootslot.ExtlinuxFile)
+		return nil
+	}
+	labels := parseExtlinux(conf)
+	if len(labels) == 0 {
+		v.failf("boot: %s has no labels", bootslot.ExtlinuxFile)
+		return nil
+	}
+	if slotted {
+		for _, slot := range []string{bootslot.A, bootslot.B} {
+			want := bootslot.ExtlinuxLabel(slot)
+			if !slices.ContainsFunc(labels, func(l extlinuxLabel) bool { return l.name == want }) {
+				v.failf("boot: %s has no label %s", bootslot.ExtlinuxFile, want)
+			}
+		}
+	}
+
+	exists := func(p string) bool {
+		_, err := stat(boot.fs, "/"+strings.TrimPrefix(path.Clean(p), "/"))
+		return err == nil
+	}
+	var kernels []string
+	for _, l := range labels {
+		if l.kernel == "" || !exists(l.kernel) {
+			v.failf("boot: label %s: kernel %q is missing", l.name, l.kernel)
+		} else if !slices.Contains(kernels, l.kernel) {
+			kernels = append(kernels, l.kernel)
+		}
+		if l.fdt != "" && !exists(l.fdt) {
+			v.failf("boot: label %s: fdt %s is missing", l.name, l.fdt)
+		}
+		for _, o := range f.Overlays {
+			if !slices.Contains(l.overlays, o) {
+				v.failf("boot: label %s does not load overlay %s", l.name, o)
+			}
+		}
+		for _, o := range f.board.Overlays {
+			if !loadsOverlay(l.overlays, o) {
+				v.failf("boot: label %s does not load the %s overlay %s", l.name, f.board.Board, o)
+			}
+		}
+		for _, o := range l.overlays {
+			if !exists(o) {
+				v.failf("boot: label %s: overlay %s is missing", l.name, o)
+			}
+		}
+		v.checkKernelArgs("label "+l.name, l.args, f.BootEnv)
+	}
+	return kernels
+}
+
+// checkKernelArgs checks that a command line has the configured kernel
+// arguments and none of the removed ones.
+func (v *verifier) checkKernelArgs(where, cmdline string, b BootEnv) {
+	args := strings.Fields(cmdline)
+	for _, want := range b.AddArgs {
+		if !slices.Contains(args, want) {
+			v.failf("boot: %s lacks kernel argument %s", where, want)
+		}
+	}
+	for _, arg := range args {
+		if slices.ContainsFunc(b.RemoveArgs, func(r string) bool { return argMatches(arg, r) }) && !slices.Contains(b.AddArgs, arg) {
+			v.failf("boot: %s still has kernel argument %s", where, arg)
+		}
+	}
+}
+
+// checkRootfs checks the fstab and enabled units of the rootfs bundled
+// into a kernel.
+func (v *verifier) checkRootfs(boot volume, kernel string, slotted, luks bool) {
+	image, err := readFile(boot.fs, kernel)
+	if err != nil {
+		v.failf("%s: %v", kernel, err)
+		return
+	}
+	rootfs, err := initramfs.Bundled(image)
+	if err != nil {
+		v.failf("%s: %v", kernel, err)
+		return
+	}
+
+	mounts := parseFstab(rootfs["etc/fstab"].Data)
+	appDevice, dataDevice := "LABEL="+constants.AppPartitionLabel, "LABEL="+constants.DataPartitionLabel
+	if slotted {
+		appDevice = "/dev/tezsign/app"
+	}
+	if luks {
+		dataDevice = "/dev/tezsign/data"
+	}
+	for mount, want := range map[string]string{"/app": appDevice, "/data": dataDevice} {
+		if got, ok := mounts[mount]; !ok {
+			v.failf("%s: fstab does not mount %s", kernel, mount)
+		} else if got.device != want {
+			v.failf("%s: fstab mounts %s from %s, want %s", kernel, mount, got.device, want)
+		}
+	}
+
+	units := slices.Clone(baseUnits)
+	if slotted {
+		units = append(units, "boot-slot-arm.service", "boot-slot-commit.service")
+	}
+	if luks {
+		units = append(units, "data-luks.service")
+	}
+	if root, ok := mounts["/"]; ok && slices.Contains(root.options, "ro") {
+		units = append(units, "ro-root-overlay.service")
+	}
+	for _, unit := range units {
+		if !initramfs.HasUnit(rootfs, unit) {
+			v.failf("%s: unit %s is missing", kernel, unit)
+		} else if !initramfs.UnitEnabled(rootfs, unit) {
+			v.failf("%s: unit %s is not enabled", kernel, unit)
+		}
+	}
+}
+
+type fstabEntry struct {
+	device  string
+	options []string
+}
+
+func parseFstab(data []byte) map[string]fstabEntry {
+	mounts := map[string]fstabEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		mounts[fields[1]] = fstabEntry{device: fields[0], options: strings.Split(fields[3], ",")}
+	}
+	return mounts
+}
+
+// checkSteps checks that the builder config's steps are in the image.
+// Hooks cannot be checked; their own post-image hook can do that.
+func (v *verifier) checkSteps(cfg *Config, f Flavour, all map[string][]volume) {
+	byName := map[string][]volume{}
+	for _, name := range f.partitions() {
+		for _, vol := range all[name] {
+			if vol.fs == nil {
+				v.failf("%s: cannot read the filesystem to check its steps", vol.label)
+				continue
+			}
+			byName[name] = append(byName[name], vol)
+		}
+	}
+	for _, in := range f.Inject {
+		want, err := os.ReadFile(cfg.source(in))
+		if err != nil {
+			v.failf("inject %s: %v", in.Path, err)
+			continue
+		}
+		for _, vol := range byName[in.Partition] {
+			got, err := readFile(vol.fs, in.Path)
+			if err != nil {
+				v.failf("%s: injected %s is missing", vol.label, in.Path)
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				v.failf("%s: %s differs from %s", vol.label, in.Path, in.Source)
+			}
+			if in.Mode != "" && in.Partition != partitionBoot {
+				v.checkMode(vol, in.Path, in.Mode)
+			}
+		}
+	}
+	for _, r := range f.Remove {
+		for _, vol := range byName[r.Partition] {
+			if _, err := stat(vol.fs, r.Path); err == nil {
+				v.failf("%s: %s should have been removed", vol.label, r.Path)
+			}
+		}
+	}
+	for _, l := range f.Symlink {
+		for _, vol := range byName[l.Partition] {
+			if st, err := stat(vol.fs, l.Path); err != nil || st.Mode()&os.ModeSymlink == 0 {
+				v.failf("%s: %s is not a symlink", vol.label, l.Path)
+			}
+		}
+	}
+	for _, m := range f.Chmod {
+		for _, vol := range byName[m.Partition] {
+			v.checkMode(vol, m.Path, m.Mode)
+		}
+	}
+}
+
+func (v *verifier) checkMode(vol volume, p, mode string) {
+	want, _ := parseMode(mode)
+	st, err := stat(vol.fs, p)
+	if err != nil {
+		v.failf("%s: %s is missing", vol.label, p)
+		return
+	}
+	if st.Mode().Perm() != want.Perm() {
+		v.failf("%s: %s has mode %04o, want %s", vol.label, p, st.Mode().Perm(), mode)
+	}
+}
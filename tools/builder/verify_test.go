@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseExtlinux(t *testing.T) {
+	conf := `default TezSign-a
+label TezSign-a
+   kernel /slot_a/Image
+   fdt /slot_a/board.dtb
+   fdtoverlays /overlays/a.dtbo /overlays/b.dtbo
+   append root=/dev/ram0
+# label TezSign-old
+label TezSign-b
+   LINUX /slot_b/Image
+`
+	labels := parseExtlinux([]byte(conf))
+	if len(labels) != 2 {
+		t.Fatalf("labels = %+v", labels)
+	}
+	a, b := labels[0], labels[1]
+	if a.name != "TezSign-a" || a.kernel != "/slot_a/Image" || a.fdt != "/slot_a/board.dtb" || !slices.Equal(a.overlays, []string{"/overlays/a.dtbo", "/overlays/b.dtbo"}) {
+		t.Fatalf("label a = %+v", a)
+	}
+	if b.name != "TezSign-b" || b.kernel != "/slot_b/Image" || b.fdt != "" {
+		t.Fatalf("label b = %+v", b)
+	}
+}
+
+func TestParseFstab(t *testing.T) {
+	mounts := parseFstab([]byte("# <dev> <mount>\n/dev/tezsign/app /app ext4 ro,exec 0 1\nrootfs / rootfs ro 0 0\n"))
+	if mounts["/app"].device != "/dev/tezsign/app" {
+		t.Fatalf("/app = %+v", mounts["/app"])
+	}
+	if !slices.Contains(mounts["/"].options, "ro") {
+		t.Fatalf("/ = %+v", mounts["/"])
+	}
+	if _, ok := mounts["<mount>"]; ok {
+		t.Fatal("comment parsed as an entry")
+	}
+}
+
+func TestCheckKernelArgs(t *testing.T) {
+	b := BootEnv{AddArgs: []string{"quiet", "loglevel=0"}, RemoveArgs: []string{"console", "loglevel"}}
+	v := &verifier{}
+	v.checkKernelArgs("cmdline.txt", "root=/dev/ram0 quiet loglevel=0", b)
+	if len(v.problems) != 0 {
+		t.Fatalf("problems = %q", v.problems)
+	}
+	v.checkKernelArgs("label TezSign", "root=/dev/ram0 console=ttyS2 loglevel=0", b)
+	if len(v.problems) != 2 {
+		t.Fatalf("problems = %q, want quiet missing and console left", v.problems)
+	}
+}
+
+func TestLoadsOverlay(t *testing.T) {
+	if !loadsOverlay([]string{"vc4-kms-v3d", "dwc2,dr_mode=otg"}, "dwc2") || !loadsOverlay([]string{"/slot_a/overlays/dwc2.dtbo"}, "dwc2") {
+		t.Fatal("dwc2 not found")
+	}
+	if loadsOverlay([]string{"dwc2-host", "/overlays/dwc2x.dtbo"}, "dwc2") {
+		t.Fatal("overlay matched by prefix")
+	}
+}
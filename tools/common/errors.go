@@ -16,5 +16,7 @@ var (
 	ErrFailedToReadDirectory       = errors.New("failed to read directory")
 	ErrUnsupportedPartitionTable   = errors.New("unsupported partition table")
 	ErrFailedToConfigureImage      = errors.New("failed to configure image")
-	ErrUnexpectedPartitionCount     = errors.New("unexpected partition count")
+	ErrUnexpectedPartitionCount    = errors.New("unexpected partition count")
+	ErrSlottedImage                = errors.New("image has the A/B slot layout")
+	ErrNotSlottedImage             = errors.New("image does not have the A/B slot layout")
 )
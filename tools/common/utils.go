@@ -1,12 +1,15 @@
 package common
 
 import (
+	"bytes"
 	"errors"
+	"strings"
 
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/partition/gpt"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/bootslot"
 	"github.com/tez-capital/tezsign/tools/constants"
 )
 
@@ -15,6 +18,10 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 	if err != nil {
 		return nil, nil, nil, nil, errors.Join(ErrFailedToOpenPartitionTable, err)
 	}
+	// an A/B image has four partitions too; do not mistake app_a for rootfs
+	if IsSlottedImage(img) {
+		return nil, nil, nil, nil, errors.Join(ErrFailedToConfigureImage, ErrSlottedImage)
+	}
 
 	var bootPartition part.Partition
 	var rootfsPartition part.Partition
@@ -70,3 +77,66 @@ func GetTezsignPartitions(img *disk.Disk) (boot, rootfs, app, data part.Partitio
 	}
 	return bootPartition, rootfsPartition, appPartition, dataPartition, nil
 }
+
+// GetTezsignSlotPartitions returns the partitions of an A/B slotted image.
+// Slots are found by filesystem label, which works for GPT and MBR alike.
+func GetTezsignSlotPartitions(img *disk.Disk) (boot, appA, appB, data part.Partition, err error) {
+	table, err := img.GetPartitionTable()
+	if err != nil {
+		return nil, nil, nil, nil, errors.Join(ErrFailedToOpenPartitionTable, err)
+	}
+
+	for idx, p := range table.GetPartitions() {
+		if p == nil || p.GetSize() == 0 {
+			continue
+		}
+		fs, err := img.GetFilesystem(idx + 1)
+		if err != nil {
+			if IsLUKSPartition(img, p) {
+				data = p
+			}
+			continue
+		}
+		label := strings.TrimSpace(fs.Label())
+		fs.Close()
+		switch label {
+		case "boot", "bootfs":
+			boot = p
+		case bootslot.AppLabel(bootslot.A):
+			appA = p
+		case bootslot.AppLabel(bootslot.B):
+			appB = p
+		case constants.DataPartitionLabel:
+			data = p
+		}
+	}
+
+	if appA == nil || appB == nil {
+		return nil, nil, nil, nil, ErrNotSlottedImage
+	}
+	if boot == nil || data == nil {
+		return nil, nil, nil, nil, errors.Join(ErrFailedToConfigureImage, ErrUnexpectedPartitionCount)
+	}
+	return boot, appA, appB, data, nil
+}
+
+// IsSlottedImage reports whether img has the A/B layout.
+func IsSlottedImage(img *disk.Disk) bool {
+	_, _, _, _, err := GetTezsignSlotPartitions(img)
+	return err == nil
+}
+
+var luksMagic = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+
+// IsLUKSPartition reports whether p starts with a LUKS header, as the data
+// partition of TEZSIGN_DATA_LUKS images does once the device has booted.
+func IsLUKSPartition(img *disk.Disk, p part.Partition) bool {
+	if p == nil {
+		return false
+	}
+	buf := make([]byte, len(luksMagic))
+	if _, err := img.Backend.ReadAt(buf, p.GetStart()); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, luksMagic)
+}
@@ -3,5 +3,11 @@ package constants
 const (
 	AppPartitionLabel  = "app"
 	DataPartitionLabel = "data"
-	LatestReleaseURL   = "https://github.com/tez-capital/tezsign/releases/latest/download/"
+	// DataLUKSMarker is in the app partition of images built with
+	// TEZSIGN_DATA_LUKS = "1".
+	DataLUKSMarker   = ".data-luks"
+	LatestReleaseURL = "https://github.com/tez-capital/tezsign/releases/latest/download/"
+	// ReleaseDownloadURL + tag + "/" holds the assets of one release.
+	ReleaseDownloadURL = "https://github.com/tez-capital/tezsign/releases/download/"
+	ReleasesAPIURL     = "https://api.github.com/repos/tez-capital/tezsign/releases"
 )
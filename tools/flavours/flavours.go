@@ -0,0 +1,148 @@
+// Package flavours lists the boards TezSign images are built for: how each
+// boots, how its image is partitioned, what its boot partition carries and
+// what its release images are called. The builder and the updater both read
+// it. The image recipes (app.bb's flavour case) and the release workflow's
+// matrix name the same flavours and have to follow this list.
+package flavours
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/bootslot"
+)
+
+// Boot styles: how the board's boot loader is configured.
+const (
+	BootRPi      = "rpi"      // config.txt and cmdline.txt
+	BootExtlinux = "extlinux" // extlinux/extlinux.conf
+)
+
+// Partition tables, named as go-diskfs names them.
+const (
+	TableMBR = "mbr"
+	TableGPT = "gpt"
+)
+
+// Boot partition filesystems.
+const (
+	FSVfat = "vfat"
+	FSExt4 = "ext4"
+)
+
+// Marker is the file in the app partition that names the image's flavour.
+const Marker = "/.image-flavour"
+
+// SlottedSuffix ends the release names of A/B images, rpi4_ab.
+const SlottedSuffix = "_ab"
+
+var (
+	ErrUnknown   = errors.New("unknown flavour")
+	ErrAmbiguous = errors.New("boot partition matches more than one flavour")
+)
+
+type Flavour struct {
+	// Name is the .image-flavour marker and the base of the release names.
+	Name  string
+	Board string
+	Boot  string
+	// Table and BootFS are the partition table and the boot partition
+	// filesystem of the image.
+	Table  string
+	BootFS string
+	// DTB is the device tree on the boot partition, in slot_a/ and slot_b/
+	// on A/B images. No two boards share one, which is what Detect uses.
+	DTB string
+	// Overlays are the device tree overlays every release image loads,
+	// by name and without parameters.
+	Overlays []string
+}
+
+var all = []Flavour{
+	{Name: "rpi4", Board: "Raspberry Pi 4", Boot: BootRPi, Table: TableMBR, BootFS: FSVfat, DTB: "bcm2711-rpi-4-b.dtb", Overlays: []string{"dwc2"}},
+	{Name: "rpi5", Board: "Raspberry Pi 5", Boot: BootRPi, Table: TableMBR, BootFS: FSVfat, DTB: "bcm2712-rpi-5-b-tezsign.dtb"},
+	{Name: "rpi0-2w", Board: "Raspberry Pi Zero 2 W", Boot: BootRPi, Table: TableMBR, BootFS: FSVfat, DTB: "bcm2837-rpi-zero-2-w.dtb", Overlays: []string{"dwc2"}},
+	{Name: "radxa-zero3", Board: "Radxa Zero 3W", Boot: BootExtlinux, Table: TableGPT, BootFS: FSExt4, DTB: "rk3566-radxa-zero-3w.dtb"},
+	{Name: "radxa-zero3e", Board: "Radxa Zero 3E", Boot: BootExtlinux, Table: TableGPT, BootFS: FSExt4, DTB: "rk3566-radxa-zero-3e.dtb"},
+	{Name: "rock-pi-s", Board: "Radxa ROCK Pi S", Boot: BootExtlinux, Table: TableGPT, BootFS: FSExt4, DTB: "rk3308-rock-pi-s.dtb"},
+	{Name: "orangepi-zero2w", Board: "Orange Pi Zero 2W", Boot: BootExtlinux, Table: TableMBR, BootFS: FSExt4, DTB: "sun50i-h618-orangepi-zero2w.dtb"},
+}
+
+// All returns the supported flavours in release order.
+func All() []Flavour {
+	return slices.Clone(all)
+}
+
+// Names returns the names of the supported flavours in release order.
+func Names() []string {
+	names := make([]string, len(all))
+	for i, f := range all {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Lookup returns the flavour called name.
+func Lookup(name string) (Flavour, bool) {
+	i := slices.IndexFunc(all, func(f Flavour) bool { return f.Name == name })
+	if i < 0 {
+		return Flavour{}, false
+	}
+	return all[i], true
+}
+
+// Valid reports whether name is a supported flavour.
+func Valid(name string) bool {
+	_, ok := Lookup(name)
+	return ok
+}
+
+// Release is the release name of the flavour's image, rpi4 or rpi4_ab.
+func (f Flavour) Release(slotted bool) string {
+	if slotted {
+		return f.Name + SlottedSuffix
+	}
+	return f.Name
+}
+
+// Artifact is the file the release publishes for the image.
+func (f Flavour) Artifact(slotted bool) string {
+	return f.Release(slotted) + ".img.xz"
+}
+
+// BootDirs are the boot partition directories, with trailing slashes, that
+// hold a kernel and its device tree.
+func BootDirs(slotted bool) []string {
+	if slotted {
+		return []string{bootslot.Dir(bootslot.A) + "/", bootslot.Dir(bootslot.B) + "/"}
+	}
+	return []string{""}
+}
+
+// Detect tells the flavour of an image whose marker is missing from the
+// device trees on its boot partition. exists reports whether a path
+// relative to the root of the boot partition exists.
+func Detect(exists func(path string) bool) (Flavour, error) {
+	var found []Flavour
+	for _, f := range all {
+		for _, dir := range append(BootDirs(false), BootDirs(true)...) {
+			if exists(dir + f.DTB) {
+				found = append(found, f)
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Flavour{}, fmt.Errorf("%w: no known device tree on the boot partition", ErrUnknown)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, len(found))
+	for i, f := range found {
+		names[i] = f.Name
+	}
+	return Flavour{}, fmt.Errorf("%w: %s", ErrAmbiguous, strings.Join(names, ", "))
+}
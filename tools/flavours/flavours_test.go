@@ -0,0 +1,61 @@
+package flavours
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestFlavoursAreConsistent(t *testing.T) {
+	dtbs := map[string]string{}
+	for _, f := range All() {
+		if f.Boot != BootRPi && f.Boot != BootExtlinux {
+			t.Fatalf("%s: boot %q", f.Name, f.Boot)
+		}
+		if f.Table != TableMBR && f.Table != TableGPT {
+			t.Fatalf("%s: table %q", f.Name, f.Table)
+		}
+		if f.Boot == BootRPi && f.BootFS != FSVfat {
+			t.Fatalf("%s: the Raspberry Pi firmware reads only FAT", f.Name)
+		}
+		if other, ok := dtbs[f.DTB]; ok || f.DTB == "" {
+			t.Fatalf("%s: device tree %q is also %s's", f.Name, f.DTB, other)
+		}
+		dtbs[f.DTB] = f.Name
+		if got, ok := Lookup(f.Name); !ok || got.Name != f.Name {
+			t.Fatalf("Lookup(%s) = %v, %v", f.Name, got, ok)
+		}
+	}
+	if Valid("rpi3") || Valid("") {
+		t.Fatal("unknown flavour is valid")
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	f, _ := Lookup("rpi0-2w")
+	if got := f.Artifact(false); got != "rpi0-2w.img.xz" {
+		t.Fatalf("Artifact(false) = %s", got)
+	}
+	if got := f.Artifact(true); got != "rpi0-2w_ab.img.xz" {
+		t.Fatalf("Artifact(true) = %s", got)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	files := func(names ...string) func(string) bool {
+		return func(p string) bool { return slices.Contains(names, p) }
+	}
+
+	if f, err := Detect(files("Image", "rk3566-radxa-zero-3e.dtb")); err != nil || f.Name != "radxa-zero3e" {
+		t.Fatalf("Detect = %s, %v", f.Name, err)
+	}
+	if f, err := Detect(files("config.txt", "slot_b/bcm2711-rpi-4-b.dtb")); err != nil || f.Name != "rpi4" {
+		t.Fatalf("Detect(A/B) = %s, %v", f.Name, err)
+	}
+	if _, err := Detect(files("config.txt", "overlays/dwc2.dtbo")); !errors.Is(err, ErrUnknown) {
+		t.Fatalf("err = %v, want ErrUnknown", err)
+	}
+	if _, err := Detect(files("bcm2711-rpi-4-b.dtb", "bcm2837-rpi-zero-2-w.dtb")); !errors.Is(err, ErrAmbiguous) {
+		t.Fatalf("err = %v, want ErrAmbiguous", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/initramfs"
+)
+
+// change is one difference: '+' only in the new image, '-' only in the old
+// one, '~' in both but different.
+type change struct {
+	kind byte
+	name string
+	what string // for '~'
+}
+
+// section is the changes under one heading: a partition, the rootfs of a
+// kernel or its services.
+type section struct {
+	title   string
+	changes []change
+}
+
+// binary is an executable or kernel whose hash changed.
+type binary struct {
+	where, name string
+	old, new    string
+}
+
+type report struct {
+	notes    []string
+	sections []section
+	binaries []binary
+}
+
+func (r *report) empty() bool {
+	return len(r.notes) == 0 && len(r.sections) == 0 && len(r.binaries) == 0
+}
+
+// diffImages compares what was read of two images, old first.
+func diffImages(a, b *image) *report {
+	r := &report{}
+	for _, p := range a.unreadable {
+		r.notes = append(r.notes, "old: cannot read "+p)
+	}
+	for _, p := range b.unreadable {
+		r.notes = append(r.notes, "new: cannot read "+p)
+	}
+	r.diffTrees(a.partitions, b.partitions, "partition %s", "%s")
+	r.diffTrees(a.rootfs, b.rootfs, "rootfs of %s", "rootfs (%s)")
+	for _, kernel := range slices.Sorted(maps.Keys(a.rootfs)) {
+		if new, ok := b.rootfs[kernel]; ok {
+			if changes := diffUnits(units(a.rootfs[kernel]), units(new)); len(changes) > 0 {
+				r.sections = append(r.sections, section{"services (" + kernel + ")", changes})
+			}
+		}
+	}
+	return r
+}
+
+// diffTrees compares the trees both sides have under the same key and notes
+// the ones only one side has.
+func (r *report) diffTrees(a, b map[string]tree, what, title string) {
+	for _, key := range slices.Sorted(maps.Keys(a)) {
+		if _, ok := b[key]; !ok {
+			r.notes = append(r.notes, fmt.Sprintf(what+" is only in the old image", key))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(b)) {
+		old, ok := a[key]
+		if !ok {
+			r.notes = append(r.notes, fmt.Sprintf(what+" is only in the new image", key))
+			continue
+		}
+		where := fmt.Sprintf(title, key)
+		changes, binaries := diffTree(old, b[key], where)
+		if len(changes) > 0 {
+			r.sections = append(r.sections, section{where, changes})
+		}
+		r.binaries = append(r.binaries, binaries...)
+	}
+}
+
+func diffTree(a, b tree, where string) ([]change, []binary) {
+	var changes []change
+	var binaries []binary
+	names := slices.Sorted(maps.Keys(a))
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		old, inOld := a[name]
+		new, inNew := b[name]
+		switch {
+		case !inNew:
+			changes = append(changes, change{'-', name, ""})
+		case !inOld:
+			changes = append(changes, change{'+', name, ""})
+		default:
+			what := diffEntry(old, new)
+			if what == "" {
+				continue
+			}
+			changes = append(changes, change{'~', name, what})
+			if old.sha256 != new.sha256 && old.sha256 != "" && new.sha256 != "" && (old.elf || new.elf || isKernel(name)) {
+				binaries = append(binaries, binary{where, name, old.sha256, new.sha256})
+			}
+		}
+	}
+	return changes, binaries
+}
+
+// diffEntry describes how one file changed, "" if it did not.
+func diffEntry(a, b entry) string {
+	if a.mode.Type() != b.mode.Type() {
+		return fmt.Sprintf("%s -> %s", kind(a.mode), kind(b.mode))
+	}
+	var what []string
+	if a.sha256 != b.sha256 {
+		what = append(what, "content")
+	}
+	if a.target != b.target {
+		what = append(what, fmt.Sprintf("link %s -> %s", a.target, b.target))
+	}
+	if a.mode.Perm() != b.mode.Perm() {
+		what = append(what, fmt.Sprintf("mode %04o -> %04o", a.mode.Perm(), b.mode.Perm()))
+	}
+	return strings.Join(what, ", ")
+}
+
+func kind(m iofs.FileMode) string {
+	switch {
+	case m.IsDir():
+		return "directory"
+	case m&iofs.ModeSymlink != 0:
+		return "symlink"
+	case m.IsRegular():
+		return "file"
+	}
+	return "special file"
+}
+
+// unitSuffixes are the systemd unit types imgdiff reports.
+var unitSuffixes = []string{".service", ".socket", ".timer", ".path", ".mount", ".target"}
+
+// unit is one systemd unit of a rootfs.
+type unit struct {
+	sha256   string   // unit file, "" when only links name it
+	wantedBy []string // targets whose .wants/ or .requires/ link it
+	masked   bool
+}
+
+// units reads the installed units of a rootfs and how they are enabled.
+func units(rootfs tree) map[string]*unit {
+	out := map[string]*unit{}
+	get := func(name string) *unit {
+		if out[name] == nil {
+			out[name] = &unit{}
+		}
+		return out[name]
+	}
+	for name, e := range rootfs {
+		if !slices.ContainsFunc(unitSuffixes, func(s string) bool { return strings.HasSuffix(name, s) }) {
+			continue
+		}
+		dir, base := path.Split(name)
+		if target, ok := strings.CutPrefix(dir, "etc/systemd/system/"); ok && target != "" {
+			target = strings.TrimSuffix(target, "/")
+			if t, ok := strings.CutSuffix(target, ".wants"); ok {
+				get(base).wantedBy = append(get(base).wantedBy, t)
+			} else if t, ok := strings.CutSuffix(target, ".requires"); ok {
+				get(base).wantedBy = append(get(base).wantedBy, t)
+			}
+			continue
+		}
+		if !slices.Contains(initramfs.UnitDirs, dir) {
+			continue
+		}
+		switch {
+		case e.mode&iofs.ModeSymlink != 0 && e.target == "/dev/null":
+			get(base).masked = true
+		case e.mode.IsRegular():
+			get(base).sha256 = e.sha256
+		}
+	}
+	for _, u := range out {
+		slices.Sort(u.wantedBy)
+	}
+	return out
+}
+
+func diffUnits(a, b map[string]*unit) []change {
+	var changes []change
+	names := slices.Sorted(maps.Keys(a))
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		old, new := a[name], b[name]
+		switch {
+		case new == nil || (new.sha256 == "" && old != nil && old.sha256 != ""):
+			changes = append(changes, change{'-', name, ""})
+			continue
+		case old == nil || (old.sha256 == "" && new.sha256 != ""):
+			what := ""
+			if len(new.wantedBy) > 0 {
+				what = "enabled (" + strings.Join(new.wantedBy, ", ") + ")"
+			}
+			changes = append(changes, change{'+', name, what})
+			continue
+		}
+		var what []string
+		if old.sha256 != new.sha256 {
+			what = append(what, "unit file")
+		}
+		switch {
+		case slices.Equal(old.wantedBy, new.wantedBy):
+		case len(new.wantedBy) == 0:
+			what = append(what, "disabled")
+		case len(old.wantedBy) == 0:
+			what = append(what, "enabled ("+strings.Join(new.wantedBy, ", ")+")")
+		default:
+			what = append(what, fmt.Sprintf("wanted by %s (was %s)", strings.Join(new.wantedBy, ", "), strings.Join(old.wantedBy, ", ")))
+		}
+		if old.masked != new.masked {
+			what = append(what, map[bool]string{true: "masked", false: "unmasked"}[new.masked])
+		}
+		if len(what) > 0 {
+			changes = append(changes, change{'~', name, strings.Join(what, ", ")})
+		}
+	}
+	return changes
+}
+
+func (r *report) write(w io.Writer) {
+	for _, n := range r.notes {
+		fmt.Fprintln(w, n)
+	}
+	for _, s := range r.sections {
+		fmt.Fprintln(w, s.title)
+		for _, c := range s.changes {
+			if c.what == "" {
+				fmt.Fprintf(w, "  %c %s\n", c.kind, c.name)
+			} else {
+				fmt.Fprintf(w, "  %c %s: %s\n", c.kind, c.name, c.what)
+			}
+		}
+	}
+	if len(r.binaries) > 0 {
+		fmt.Fprintln(w, "binaries")
+		for _, b := range r.binaries {
+			fmt.Fprintf(w, "  %s: %s\n    - %s\n    + %s\n", b.where, b.name, b.old, b.new)
+		}
+	}
+}
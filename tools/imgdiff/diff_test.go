@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/tez-capital/tezsign/tools/initramfs"
+)
+
+func rootfs(extra map[string]initramfs.Entry) tree {
+	entries := map[string]initramfs.Entry{
+		"etc/fstab":                                                    {Mode: 0o644, Data: []byte("LABEL=app /app ext4 ro 0 1\n")},
+		"usr/bin/tezsign-helper":                                       {Mode: 0o755, Data: []byte("\x7fELF v1")},
+		"usr/lib/systemd/system/tezsign.service":                       {Mode: 0o644, Data: []byte("[Unit]\n")},
+		"usr/lib/systemd/system/getty@.service":                        {Mode: 0o644, Data: []byte("[Unit]\n")},
+		"usr/lib/systemd/system/provision.service":                     {Mode: 0o644, Data: []byte("[Unit]\n")},
+		"etc/systemd/system/multi-user.target.wants":                   {Mode: os.ModeDir | 0o755},
+		"etc/systemd/system/multi-user.target.wants/tezsign.service":   {Mode: os.ModeSymlink | 0o777, Data: []byte("/usr/lib/systemd/system/tezsign.service")},
+		"etc/systemd/system/multi-user.target.wants/provision.service": {Mode: os.ModeSymlink | 0o777, Data: []byte("/usr/lib/systemd/system/provision.service")},
+	}
+	for name, e := range extra {
+		if e.Mode == 0 {
+			delete(entries, name)
+		} else {
+			entries[name] = e
+		}
+	}
+	return rootfsTree(entries)
+}
+
+func TestDiffImages(t *testing.T) {
+	old := &image{
+		partitions: map[string]tree{
+			"boot": {"Image": {mode: 0o644, sha256: "k1"}, "config.txt": {mode: 0o644, sha256: "c1"}},
+			"app":  {"tezsign": {mode: 0o755, sha256: "a1", elf: true}, ".image-version": {mode: 0o644, sha256: "v1"}},
+		},
+		rootfs: map[string]tree{"Image": rootfs(nil)},
+	}
+	new := &image{
+		partitions: map[string]tree{
+			"boot":  {"Image": {mode: 0o644, sha256: "k2"}, "config.txt": {mode: 0o644, sha256: "c1"}, "overlays": {mode: os.ModeDir | 0o755}},
+			"app_a": {"tezsign": {mode: 0o755, sha256: "a2", elf: true}},
+		},
+		unreadable: []string{"partition 4 (LUKS)"},
+		rootfs: map[string]tree{"Image": rootfs(map[string]initramfs.Entry{
+			"usr/bin/tezsign-helper":                                     {Mode: 0o700, Data: []byte("\x7fELF v2")},
+			"usr/lib/systemd/system/provision.service":                   {Mode: 0o644, Data: []byte("[Unit]\nAfter=x\n")},
+			"usr/lib/systemd/system/tezsign-ab.service":                  {Mode: 0o644, Data: []byte("[Unit]\n")},
+			"etc/systemd/system/multi-user.target.wants/tezsign.service": {},
+			"etc/systemd/system/getty@.service":                          {Mode: os.ModeSymlink | 0o777, Data: []byte("/dev/null")},
+		})},
+	}
+
+	var out bytes.Buffer
+	r := diffImages(old, new)
+	r.write(&out)
+	want := `new: cannot read partition 4 (LUKS)
+partition app is only in the old image
+partition app_a is only in the new image
+boot
+  ~ Image: content
+  + overlays
+rootfs (Image)
+  + etc/systemd/system/getty@.service
+  - etc/systemd/system/multi-user.target.wants/tezsign.service
+  ~ usr/bin/tezsign-helper: content, mode 0755 -> 0700
+  ~ usr/lib/systemd/system/provision.service: content
+  + usr/lib/systemd/system/tezsign-ab.service
+services (Image)
+  ~ getty@.service: masked
+  ~ provision.service: unit file
+  + tezsign-ab.service
+  ~ tezsign.service: disabled
+binaries
+  boot: Image
+    - k1
+    + k2
+  rootfs (Image): usr/bin/tezsign-helper
+    - ` + old.rootfs["Image"]["usr/bin/tezsign-helper"].sha256 + `
+    + ` + new.rootfs["Image"]["usr/bin/tezsign-helper"].sha256 + `
+`
+	if out.String() != want {
+		t.Fatalf("report:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	if r := diffImages(old, old); !r.empty() {
+		t.Fatalf("an image differs from itself: %+v", r)
+	}
+}
+
+// TestReadImage reads a FAT boot partition and an ext4 app partition the way
+// release images have them.
+func TestReadImage(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "tezsign.img")
+	d, err := diskfs.Create(p, 48<<20, diskfs.SectorSizeDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Partition(&mbr.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		Partitions: []*mbr.Partition{
+			{Index: 1, Type: mbr.Fat32LBA, Start: 2048, Size: 40960},
+			{Index: 2, Type: mbr.Linux, Start: 43008, Size: 40960},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	write := func(n int, fsType filesystem.Type, label string, files map[string]string) {
+		t.Helper()
+		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: n, FSType: fsType, VolumeLabel: label})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range files {
+			if dir := filepath.Dir(name); dir != "/" {
+				if err := fs.Mkdir(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+	}
+	write(1, filesystem.TypeFat32, "boot", map[string]string{"/config.txt": "arm_64bit=1\n", "/overlays/dwc2.dtbo": "dtb"})
+	write(2, filesystem.TypeExt4, "app", map[string]string{"/tezsign": "\x7fELF signer"})
+	d.Close()
+
+	img, err := readImage(p, readOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for label, tr := range img.partitions {
+		for name, e := range tr {
+			if e.mode.IsRegular() {
+				got = append(got, label+":"+name)
+			}
+		}
+	}
+	slices.Sort(got)
+	if s := strings.Join(got, " "); !strings.Contains(s, "app:tezsign") || !strings.Contains(s, "boot:config.txt") || !strings.Contains(s, "boot:overlays/dwc2.dtbo") {
+		t.Fatalf("files = %s", s)
+	}
+	if !img.partitions["app"]["tezsign"].elf || img.partitions["boot"]["config.txt"].elf {
+		t.Fatal("ELF detection")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/initramfs"
+)
+
+// entry is what imgdiff compares of one file.
+type entry struct {
+	mode   iofs.FileMode
+	size   int64
+	sha256 string // regular files
+	target string // symlinks
+	elf    bool
+}
+
+// tree holds a filesystem's entries by slash path without a leading slash.
+type tree map[string]entry
+
+// image is everything imgdiff reads from an image or a device.
+type image struct {
+	// partitions are keyed by label; bootfs is read as boot.
+	partitions map[string]tree
+	// unreadable are partitions go-diskfs cannot read, such as a LUKS data
+	// partition.
+	unreadable []string
+	// rootfs holds the initramfs bundled into each kernel on the boot
+	// partition, keyed by the kernel's path.
+	rootfs map[string]tree
+}
+
+// readOptions say which partitions to read.
+type readOptions struct {
+	// data includes the data partition, which holds the device's keys and
+	// never matches a release image.
+	data bool
+}
+
+// readImage opens an image file or a block device read-only.
+func readImage(p string, opts readOptions) (*image, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	d, err := diskfs.OpenBackend(file.New(f, true), diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", p, err)
+	}
+	defer d.Close()
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("%s: read partition table: %w", p, err)
+	}
+
+	img := &image{partitions: map[string]tree{}, rootfs: map[string]tree{}}
+	for idx, part := range table.GetPartitions() {
+		if part == nil || part.GetSize() == 0 {
+			continue
+		}
+		fs, err := d.GetFilesystem(idx + 1)
+		if err != nil {
+			switch {
+			case !common.IsLUKSPartition(d, part):
+				img.unreadable = append(img.unreadable, fmt.Sprintf("partition %d", idx+1))
+			case opts.data:
+				img.unreadable = append(img.unreadable, fmt.Sprintf("partition %d (LUKS)", idx+1))
+			}
+			continue
+		}
+		label := strings.TrimSpace(fs.Label())
+		if label == "bootfs" {
+			label = "boot"
+		}
+		if label == "" || (label == constants.DataPartitionLabel && !opts.data) {
+			fs.Close()
+			continue
+		}
+		t, err := readTree(fs)
+		if err != nil {
+			fs.Close()
+			return nil, fmt.Errorf("%s: %s: %w", p, label, err)
+		}
+		img.partitions[label] = t
+		if label == "boot" {
+			if err := img.readRootfs(fs, t); err != nil {
+				fs.Close()
+				return nil, fmt.Errorf("%s: %w", p, err)
+			}
+		}
+		fs.Close()
+	}
+	return img, nil
+}
+
+// readTree walks a filesystem and hashes its regular files.
+func readTree(fs filesystem.FileSystem) (tree, error) {
+	t := tree{}
+	err := iofs.WalkDir(fs, ".", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		if name == "lost+found" && d.IsDir() {
+			return iofs.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := entry{mode: info.Mode(), size: info.Size()}
+		switch {
+		case info.Mode()&iofs.ModeSymlink != 0:
+			if l, ok := fs.(interface{ ReadLink(string) (string, error) }); ok {
+				e.target, _ = l.ReadLink(name)
+			}
+		case info.Mode().IsRegular():
+			f, err := fs.Open(name)
+			if err != nil {
+				return err
+			}
+			e.sha256, e.elf, err = hashReader(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		t[name] = e
+		return nil
+	})
+	return t, err
+}
+
+func hashReader(r io.Reader) (string, bool, error) {
+	h := sha256.New()
+	var head [4]byte
+	n, err := io.ReadFull(r, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", false, err
+	}
+	h.Write(head[:n])
+	if _, err := io.Copy(h, r); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n == 4 && bytes.Equal(head[:], []byte("\x7fELF")), nil
+}
+
+// isKernel reports whether a boot partition file is a kernel the boot
+// loaders start: Image on extlinux boards, kernel*.img on a Raspberry Pi.
+func isKernel(name string) bool {
+	base := path.Base(name)
+	return base == "Image" || (strings.HasPrefix(base, "kernel") && strings.HasSuffix(base, ".img"))
+}
+
+func (img *image) readRootfs(fs filesystem.FileSystem, boot tree) error {
+	for name, e := range boot {
+		if !e.mode.IsRegular() || !isKernel(name) {
+			continue
+		}
+		kernel, err := fs.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("boot: %s: %w", name, err)
+		}
+		rootfs, err := initramfs.Bundled(kernel)
+		if err != nil {
+			continue // a kernel without a bundled rootfs has only its hash to compare
+		}
+		img.rootfs[name] = rootfsTree(rootfs)
+	}
+	return nil
+}
+
+func rootfsTree(rootfs map[string]initramfs.Entry) tree {
+	t := tree{}
+	for name, e := range rootfs {
+		if name == "" || name == "." {
+			continue
+		}
+		te := entry{mode: e.Mode, size: int64(len(e.Data))}
+		switch {
+		case e.Mode&iofs.ModeSymlink != 0:
+			te.target = string(e.Data)
+		case e.Mode.IsRegular():
+			te.sha256, te.elf, _ = hashReader(bytes.NewReader(e.Data))
+		}
+		t[name] = te
+	}
+	return t
+}
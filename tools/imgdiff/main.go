@@ -0,0 +1,57 @@
+// tezsign-imgdiff shows what changed between two TezSign images, or between
+// an image and a device's card: the files that differ on each partition and
+// in the rootfs bundled into each kernel, the services added, removed,
+// enabled or disabled, and the old and new hashes of every changed binary.
+// Like diff, it exits 1 when the images differ and 2 on errors.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+)
+
+func main() {
+	app := &cli.Command{
+		Name:      "tezsign-imgdiff",
+		Usage:     "Compare two TezSign images, or an image and a device, read-only",
+		ArgsUsage: "<old.img|/dev/sdX> <new.img|/dev/sdX>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "data",
+				Usage: "compare the data partitions too; they hold the device's keys and watermarks",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if c.Args().Len() != 2 {
+				return errors.New("usage: tezsign-imgdiff [--data] <old> <new>")
+			}
+			opts := readOptions{data: c.Bool("data")}
+			old, err := readImage(c.Args().Get(0), opts)
+			if err != nil {
+				return err
+			}
+			new, err := readImage(c.Args().Get(1), opts)
+			if err != nil {
+				return err
+			}
+			r := diffImages(old, new)
+			if r.empty() {
+				return nil
+			}
+			r.write(os.Stdout)
+			return cli.Exit("", 1)
+		},
+	}
+	if err := app.Run(context.Background(), os.Args); err != nil {
+		var exit cli.ExitCoder
+		if errors.As(err, &exit) {
+			os.Exit(exit.ExitCode())
+		}
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+}
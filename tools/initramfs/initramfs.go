@@ -0,0 +1,247 @@
+// Package initramfs reads the rootfs that TezSign kernels carry: the
+// initramfs that INITRAMFS_IMAGE_BUNDLE links into the kernel image. The
+// builder verifies images with it and imgdiff compares them.
+package initramfs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Entry is one file of a newc cpio archive. Data holds a regular file's
+// contents or a symlink's target.
+type Entry struct {
+	Mode os.FileMode
+	Data []byte
+}
+
+var (
+	ErrNotFound = errors.New("no bundled initramfs found in the kernel (plain, gzip and zstd archives are read)")
+	cpioMagics  = [][]byte{[]byte("070701"), []byte("070702")}
+)
+
+// initramfsFormats are the CONFIG_INITRAMFS_COMPRESSION choices this package
+// can read, keyed by the magic that starts the embedded archive.
+var initramfsFormats = []struct {
+	magic []byte
+	open  func(r io.Reader) (io.Reader, func(), error)
+}{
+	{cpioMagics[0], plain},
+	{cpioMagics[1], plain},
+	{[]byte{0x1f, 0x8b, 0x08}, func(r io.Reader) (io.Reader, func(), error) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		zr.Multistream(false)
+		return zr, func() { zr.Close() }, nil
+	}},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, func(r io.Reader) (io.Reader, func(), error) {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}},
+}
+
+func plain(r io.Reader) (io.Reader, func(), error) {
+	return r, func() {}, nil
+}
+
+const (
+	cpioHeaderSize = 110
+	cpioTrailer    = "TRAILER!!!"
+	// kernelScanLimit bounds how many candidate offsets are tried.
+	kernelScanLimit = 256
+)
+
+// Bundled finds the rootfs that INITRAMFS_IMAGE_BUNDLE linked into a kernel
+// image. Names have no leading slash. The kernel embeds it as a newc archive, usually
+// compressed; the first archive holding etc/fstab is taken.
+func Bundled(kernel []byte) (map[string]Entry, error) {
+	tried := 0
+	for _, format := range initramfsFormats {
+		for off := 0; tried < kernelScanLimit; tried++ {
+			i := bytes.Index(kernel[off:], format.magic)
+			if i < 0 {
+				break
+			}
+			at := off + i
+			off = at + 1
+
+			r, done, err := format.open(bytes.NewReader(kernel[at:]))
+			if err != nil {
+				continue
+			}
+			entries, err := readCpio(r)
+			done()
+			if err != nil {
+				continue
+			}
+			if _, ok := entries["etc/fstab"]; ok {
+				return entries, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// readCpio reads newc archives until the data runs out. The kernel accepts
+// several archives back to back, separated by zero padding.
+func readCpio(r io.Reader) (map[string]Entry, error) {
+	cr := &cpioReader{r: bufio.NewReader(r)}
+	entries := map[string]Entry{}
+	for {
+		name, entry, err := cr.next()
+		if err != nil {
+			return nil, err
+		}
+		if name != cpioTrailer {
+			entries[name] = entry
+			continue
+		}
+		if !cr.skipPadding() {
+			return entries, nil
+		}
+	}
+}
+
+type cpioReader struct {
+	r   *bufio.Reader
+	off int64
+}
+
+// read grows its buffer as data arrives, so a bogus size in a false match
+// fails at the end of the data instead of allocating it up front.
+func (c *cpioReader) read(n int64) ([]byte, error) {
+	buf, err := io.ReadAll(io.LimitReader(c.r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	c.off += n
+	return buf, nil
+}
+
+func (c *cpioReader) align() error {
+	if pad := (4 - c.off%4) % 4; pad > 0 {
+		_, err := c.read(pad)
+		return err
+	}
+	return nil
+}
+
+// skipPadding moves past the zeros after a trailer and reports whether
+// another archive follows.
+func (c *cpioReader) skipPadding() bool {
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil || b[0] != 0 {
+			break
+		}
+		c.r.Discard(1)
+		c.off++
+	}
+	magic, err := c.r.Peek(6)
+	return err == nil && (bytes.Equal(magic, cpioMagics[0]) || bytes.Equal(magic, cpioMagics[1]))
+}
+
+func (c *cpioReader) next() (string, Entry, error) {
+	hdr, err := c.read(cpioHeaderSize)
+	if err != nil {
+		return "", Entry{}, err
+	}
+	if !bytes.Equal(hdr[:6], cpioMagics[0]) && !bytes.Equal(hdr[:6], cpioMagics[1]) {
+		return "", Entry{}, fmt.Errorf("bad cpio magic at %d", c.off-cpioHeaderSize)
+	}
+	field := func(i int) (int64, error) {
+		v, err := strconv.ParseUint(string(hdr[6+8*i:14+8*i]), 16, 32)
+		return int64(v), err
+	}
+	mode, err := field(1)
+	if err != nil {
+		return "", Entry{}, fmt.Errorf("bad cpio header: %w", err)
+	}
+	size, err := field(6)
+	if err != nil {
+		return "", Entry{}, fmt.Errorf("bad cpio header: %w", err)
+	}
+	nameSize, err := field(11)
+	if err != nil || nameSize == 0 || nameSize > 4096 {
+		return "", Entry{}, fmt.Errorf("bad cpio name size")
+	}
+
+	name, err := c.read(nameSize)
+	if err != nil {
+		return "", Entry{}, err
+	}
+	if err := c.align(); err != nil {
+		return "", Entry{}, err
+	}
+	data, err := c.read(size)
+	if err != nil {
+		return "", Entry{}, err
+	}
+	if err := c.align(); err != nil {
+		return "", Entry{}, err
+	}
+
+	clean := strings.Trim(strings.TrimPrefix(string(bytes.TrimRight(name, "\x00")), "./"), "/")
+	return clean, Entry{Mode: cpioMode(uint32(mode)), Data: data}, nil
+}
+
+// cpioMode converts the st_mode bits cpio stores.
+func cpioMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= os.ModeDir
+	case 0o120000:
+		mode |= os.ModeSymlink
+	case 0o020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0o060000:
+		mode |= os.ModeDevice
+	case 0o010000:
+		mode |= os.ModeNamedPipe
+	case 0o140000:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+// UnitDirs are where systemd unit files are installed.
+var UnitDirs = []string{"usr/lib/systemd/system/", "lib/systemd/system/", "etc/systemd/system/"}
+
+// HasUnit reports whether rootfs installs unit.
+func HasUnit(rootfs map[string]Entry, unit string) bool {
+	for _, dir := range UnitDirs {
+		if e, ok := rootfs[dir+unit]; ok && e.Mode.IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+// UnitEnabled reports whether systemctl enable left a .wants/ or .requires/
+// link for unit.
+func UnitEnabled(rootfs map[string]Entry, unit string) bool {
+	for name := range rootfs {
+		if strings.HasPrefix(name, "etc/systemd/system/") && (strings.HasSuffix(name, ".wants/"+unit) || strings.HasSuffix(name, ".requires/"+unit)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package initramfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name string
+	mode uint32
+	data string
+}
+
+// newc builds a newc archive the way the kernel's usr/gen_init_cpio does.
+func newc(entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	pad := func() {
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	for i, e := range append(entries, testEntry{name: cpioTrailer}) {
+		fmt.Fprintf(&buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			i+1, e.mode, 0, 0, 1, 0, len(e.data), 0, 0, 0, 0, len(e.name)+1, 0)
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		pad()
+		buf.WriteString(e.data)
+		pad()
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testRootfs() []byte {
+	return newc(
+		testEntry{"etc", 0o040755, ""},
+		testEntry{"etc/fstab", 0o100644, "LABEL=app /app ext4 ro 0 1\nLABEL=data /data ext4 rw 0 1\n"},
+		testEntry{"usr/lib/systemd/system/tezsign.service", 0o100644, "[Unit]\n"},
+		testEntry{"etc/systemd/system/multi-user.target.wants/tezsign.service", 0o120777, "/usr/lib/systemd/system/tezsign.service"},
+		testEntry{"usr/lib/systemd/system/ffs_registrar.service", 0o100644, "[Unit]\n"},
+	)
+}
+
+func TestBundledInitramfsSkipsDecoys(t *testing.T) {
+	// the kernel has the cpio magic as a string and other gzip streams
+	// (IKCONFIG) ahead of the initramfs
+	var kernel bytes.Buffer
+	kernel.WriteString("MZ\x00\x00 070701 initramfs.c ")
+	kernel.Write([]byte{0x1f, 0x8b, 0x08, 0, 0, 0})
+	kernel.Write(gzipped(t, []byte("CONFIG_BLK_DEV_INITRD=y\n")))
+	kernel.Write(gzipped(t, testRootfs()))
+	kernel.Write(make([]byte, 64))
+
+	rootfs, err := Bundled(kernel.Bytes())
+	if err != nil {
+		t.Fatalf("Bundled: %v", err)
+	}
+	if got := string(rootfs["etc/fstab"].Data); !strings.HasPrefix(got, "LABEL=app /app ") {
+		t.Fatalf("etc/fstab = %q", got)
+	}
+	if !HasUnit(rootfs, "tezsign.service") || !UnitEnabled(rootfs, "tezsign.service") {
+		t.Fatal("tezsign.service should be installed and enabled")
+	}
+	if !HasUnit(rootfs, "ffs_registrar.service") || UnitEnabled(rootfs, "ffs_registrar.service") {
+		t.Fatal("ffs_registrar.service should be installed but not enabled")
+	}
+}
+
+func TestBundledInitramfsPlainAndConcatenated(t *testing.T) {
+	archive := append(newc(testEntry{"init", 0o100755, "#!"}), make([]byte, 512)...)
+	archive = append(archive, testRootfs()...)
+	kernel := append([]byte("kernel text "), archive...)
+	kernel = append(kernel, "trailing kernel data"...)
+
+	rootfs, err := Bundled(kernel)
+	if err != nil {
+		t.Fatalf("Bundled: %v", err)
+	}
+	if _, ok := rootfs["init"]; !ok {
+		t.Fatal("entries of the first archive are missing")
+	}
+	if e := rootfs["etc/systemd/system/multi-user.target.wants/tezsign.service"]; e.Mode&os.ModeSymlink == 0 || string(e.Data) != "/usr/lib/systemd/system/tezsign.service" {
+		t.Fatalf("symlink mode = %v", e.Mode)
+	}
+}
+
+func TestBundledInitramfsMissing(t *testing.T) {
+	kernel := append([]byte("kernel "), gzipped(t, newc(testEntry{"init", 0o100755, "#!"}))...)
+	if _, err := Bundled(kernel); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
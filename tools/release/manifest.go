@@ -0,0 +1,288 @@
+// Package release describes release images. The builder writes a manifest
+// next to every image and signs the image and the manifest (see package
+// minisign); the updater and users check both before flashing.
+package release
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// ManifestExt follows the release name: rpi4.manifest.json.
+const ManifestExt = ".manifest.json"
+
+// AppBinaryExt follows the release name of the signer binary published for
+// pushing over USB (tezsign advanced update --app): rpi4.tezsign.
+const AppBinaryExt = ".tezsign"
+
+var ErrNoAppPartition = errors.New("image has no app partition")
+
+// Manifest is what <release>.manifest.json records about one release image.
+type Manifest struct {
+	Release string `json:"release"`
+	Flavour string `json:"flavour"`
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	// Channel is the update channel the release is published on: stable,
+	// beta or nightly.
+	Channel string `json:"channel,omitempty"`
+	// Image is the raw image that gets flashed.
+	Image File `json:"image"`
+	// Artifacts are the files published for it, e.g. the .img.xz.
+	Artifacts  []File      `json:"artifacts,omitempty"`
+	Partitions []Partition `json:"partitions"`
+}
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Partition offsets and sizes are in bytes; Index is 1-based like the
+// kernel's partition numbers.
+type Partition struct {
+	Index  int    `json:"index"`
+	Label  string `json:"label"`
+	Start  int64  `json:"start"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Channels are the update channels a release can be published on, from the
+// most to the least tested.
+var Channels = []string{"stable", "beta", "nightly"}
+
+func ManifestName(release string) string {
+	return release + ManifestExt
+}
+
+// HashFile returns the File entry for path.
+func HashFile(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return File{Name: filepath.Base(path), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// NewManifest describes the raw image at imagePath. Flavour, version and
+// date come from the app partition (the first slot's on A/B images).
+func NewManifest(release, imagePath string) (*Manifest, error) {
+	image, err := HashFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	d, err := diskfs.OpenBackend(file.New(f, true), diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %w", imagePath, err)
+	}
+	defer d.Close()
+
+	m := &Manifest{Release: release, Image: image}
+	if err := m.readPartitions(d); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ExtractAppBinary copies the signer binary out of the app partition of the
+// raw image at imagePath (the first slot's on A/B images) to dst.
+func ExtractAppBinary(imagePath, dst string) (File, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return File{}, err
+	}
+	d, err := diskfs.OpenBackend(file.New(f, true), diskfs.WithOpenMode(diskfs.ReadOnly), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+	if err != nil {
+		f.Close()
+		return File{}, fmt.Errorf("open %s: %w", imagePath, err)
+	}
+	defer d.Close()
+
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return File{}, fmt.Errorf("read partition table: %w", err)
+	}
+	for idx, p := range table.GetPartitions() {
+		if p == nil || p.GetSize() == 0 {
+			continue
+		}
+		fs, err := d.GetFilesystem(idx + 1)
+		if err != nil {
+			continue
+		}
+		if !isAppLabel(partitionLabel(p, fs)) {
+			fs.Close()
+			continue
+		}
+		err = copyOut(fs, "/"+apphash.Binary, dst)
+		fs.Close()
+		if err != nil {
+			return File{}, err
+		}
+		return HashFile(dst)
+	}
+	return File{}, ErrNoAppPartition
+}
+
+func copyOut(fs filesystem.FileSystem, path, dst string) error {
+	src, err := fs.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// partitionLabel is the filesystem's label, or the GPT name without one.
+func partitionLabel(p part.Partition, fs filesystem.FileSystem) string {
+	if label := strings.TrimSpace(fs.Label()); label != "" {
+		return label
+	}
+	if gp, ok := p.(*gpt.Partition); ok {
+		return gp.Name
+	}
+	return ""
+}
+
+// isAppLabel matches the app partition, or the first slot's on A/B images.
+func isAppLabel(label string) bool {
+	return label == constants.AppPartitionLabel || label == bootslot.AppLabel(bootslot.A)
+}
+
+func (m *Manifest) readPartitions(d *disk.Disk) error {
+	table, err := d.GetPartitionTable()
+	if err != nil {
+		return fmt.Errorf("read partition table: %w", err)
+	}
+
+	foundApp := false
+	for idx, p := range table.GetPartitions() {
+		if p == nil || p.GetSize() == 0 {
+			continue
+		}
+
+		entry := Partition{Index: idx + 1, Start: p.GetStart(), Size: p.GetSize()}
+		if gp, ok := p.(*gpt.Partition); ok {
+			entry.Label = gp.Name
+		}
+		if fs, err := d.GetFilesystem(idx + 1); err == nil {
+			entry.Label = partitionLabel(p, fs)
+			if !foundApp && isAppLabel(entry.Label) {
+				foundApp = true
+				m.Flavour = ReadMarker(fs, "/.image-flavour")
+				m.Version = ReadMarker(fs, "/.image-version")
+				m.Date = ReadMarker(fs, "/.image-date")
+			}
+			fs.Close()
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(d.Backend, p.GetStart(), p.GetSize())); err != nil {
+			return fmt.Errorf("hash partition %d: %w", idx+1, err)
+		}
+		entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+		m.Partitions = append(m.Partitions, entry)
+	}
+
+	if !foundApp {
+		return ErrNoAppPartition
+	}
+	return nil
+}
+
+// ReadMarker returns the trimmed contents of a marker file such as
+// /.image-flavour, or "" when it is missing.
+func ReadMarker(fs filesystem.FileSystem, path string) string {
+	f, err := fs.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// AddArtifact records a published file built from the image.
+func (m *Manifest) AddArtifact(path string) error {
+	a, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	return nil
+}
+
+// Artifact returns the entry named name (the image or an artifact).
+func (m *Manifest) Artifact(name string) (File, bool) {
+	if m.Image.Name == name {
+		return m.Image, true
+	}
+	for _, a := range m.Artifacts {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return File{}, false
+}
+
+func (m *Manifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.Release == "" || m.Image.SHA256 == "" {
+		return nil, errors.New("parse manifest: missing release or image hash")
+	}
+	return &m, nil
+}
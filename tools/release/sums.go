@@ -0,0 +1,129 @@
+package release
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// SumsName is the sha256sum-compatible checksum list published with a
+// release.
+const SumsName = "SHA256SUMS"
+
+// ParseSums reads "<hex>  <name>" lines as sha256sum writes them.
+func ParseSums(data []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if b, err := hex.DecodeString(sum); !ok || err != nil || len(b) != sha256.Size || name == "" {
+			return nil, fmt.Errorf("parse %s: bad line %q", SumsName, line)
+		}
+		sums[name] = sum
+	}
+	return sums, sc.Err()
+}
+
+// FormatSums writes sums sorted by name, one "<hex>  <name>" line each.
+func FormatSums(sums map[string]string) []byte {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	return buf.Bytes()
+}
+
+// UpdateSums adds or replaces the entries for files in the checksum list at
+// path and keeps the others, so several images can share one directory.
+func UpdateSums(path string, files ...File) error {
+	sums := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		if sums, err = ParseSums(data); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, f := range files {
+		sums[f.Name] = f.SHA256
+	}
+	return os.WriteFile(path, FormatSums(sums), 0o644)
+}
+
+// CompressImage writes the xz-compressed image to dst in a single pass and
+// returns the File entry for dst. It writes to a temporary name next to dst
+// and renames it, so an interrupted run leaves no truncated .img.xz.
+func CompressImage(image, dst string) (File, error) {
+	in, err := os.Open(image)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return File{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	counter := &countingWriter{}
+	out := io.MultiWriter(tmp, h, counter)
+	// xz -9 uses a 64 MiB dictionary; the images are mostly empty space
+	zw, err := xz.WriterConfig{DictCap: 64 << 20}.NewWriter(out)
+	if err != nil {
+		tmp.Close()
+		return File{}, err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		tmp.Close()
+		return File{}, fmt.Errorf("compress %s: %w", image, err)
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return File{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return File{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return File{}, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return File{}, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return File{}, err
+	}
+	return File{Name: filepath.Base(dst), Size: counter.n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+package release
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestUpdateSumsKeepsOtherEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SumsName)
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("b", 64)
+	c := strings.Repeat("c", 64)
+	if err := os.WriteFile(path, []byte(a+"  rpi5.img.xz\n"+b+" *rpi4.img.xz\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateSums(path, File{Name: "rpi4.img.xz", SHA256: c}, File{Name: "rpi4.manifest.json", SHA256: a}); err != nil {
+		t.Fatalf("UpdateSums: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := c + "  rpi4.img.xz\n" + a + "  rpi4.manifest.json\n" + a + "  rpi5.img.xz\n"
+	if string(got) != want {
+		t.Fatalf("%s:\n%s\nwant:\n%s", SumsName, got, want)
+	}
+}
+
+func TestParseSumsRejectsMalformed(t *testing.T) {
+	for _, line := range []string{"deadbeef  short.img\n", strings.Repeat("a", 64) + "\n", strings.Repeat("z", 64) + "  x\n"} {
+		if _, err := ParseSums([]byte(line)); err == nil {
+			t.Fatalf("ParseSums(%q) succeeded", line)
+		}
+	}
+}
+
+func TestCompressImageRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "rpi4.img")
+	raw := append(bytes.Repeat([]byte{0}, 1<<20), []byte("tezsign")...)
+	if err := os.WriteFile(image, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := image + ".xz"
+	f, err := CompressImage(image, dst)
+	if err != nil {
+		t.Fatalf("CompressImage: %v", err)
+	}
+	if want, err := HashFile(dst); err != nil || f != want {
+		t.Fatalf("returned %+v, file is %+v (%v)", f, want, err)
+	}
+
+	in, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	zr, err := xz.NewReader(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Fatal("decompressed image differs")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("temporary file left behind: %v", entries)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// alignedStorage is a raw disk for go-diskfs on systems that only accept
+// whole sectors: the macOS raw devices (/dev/rdiskN) and Windows physical
+// drives. Unaligned reads and writes go through a sector-aligned buffer.
+// It reports itself as a regular file of the disk's size, since go-diskfs
+// cannot size a block device there.
+type alignedStorage struct {
+	dev interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+	file     *os.File // nil in tests
+	name     string
+	sector   int64
+	size     int64
+	readOnly bool
+	off      int64
+}
+
+var _ backend.WritableFile = (*alignedStorage)(nil)
+
+func newAlignedStorage(f *os.File, size, sector int64, readOnly bool) *alignedStorage {
+	return &alignedStorage{dev: f, file: f, name: f.Name(), sector: sector, size: size, readOnly: readOnly}
+}
+
+// span is the aligned range that covers n bytes at off.
+func (a *alignedStorage) span(off int64, n int) (start, end int64) {
+	start = off - off%a.sector
+	end = off + int64(n)
+	if rem := end % a.sector; rem != 0 {
+		end += a.sector - rem
+	}
+	return start, min(end, a.size)
+}
+
+func (a *alignedStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off >= a.size {
+		return 0, io.EOF
+	}
+	if off%a.sector == 0 && int64(len(p))%a.sector == 0 && off+int64(len(p)) <= a.size {
+		return a.dev.ReadAt(p, off)
+	}
+	start, end := a.span(off, len(p))
+	buf := make([]byte, end-start)
+	if _, err := a.dev.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	n := copy(p, buf[off-start:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (a *alignedStorage) WriteAt(p []byte, off int64) (int, error) {
+	if a.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off+int64(len(p)) > a.size {
+		return 0, errors.New("write past the end of the disk")
+	}
+	if off%a.sector == 0 && int64(len(p))%a.sector == 0 {
+		return a.dev.WriteAt(p, off)
+	}
+	start, end := a.span(off, len(p))
+	buf := make([]byte, end-start)
+	if _, err := a.dev.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	copy(buf[off-start:], p)
+	if _, err := a.dev.WriteAt(buf, start); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (a *alignedStorage) Read(p []byte) (int, error) {
+	n, err := a.ReadAt(p, a.off)
+	a.off += int64(n)
+	return n, err
+}
+
+func (a *alignedStorage) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += a.off
+	case io.SeekEnd:
+		offset += a.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	a.off = offset
+	return offset, nil
+}
+
+func (a *alignedStorage) Stat() (fs.FileInfo, error) {
+	return diskInfo{name: a.name, size: a.size}, nil
+}
+
+func (a *alignedStorage) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+func (a *alignedStorage) Sys() (*os.File, error) {
+	if a.file == nil {
+		return nil, backend.ErrNotSuitable
+	}
+	return a.file, nil
+}
+
+func (a *alignedStorage) Writable() (backend.WritableFile, error) {
+	if a.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return a, nil
+}
+
+func (a *alignedStorage) Path() string { return a.name }
+
+// diskInfo describes a disk as a regular file of its size.
+type diskInfo struct {
+	name string
+	size int64
+}
+
+func (i diskInfo) Name() string       { return i.name }
+func (i diskInfo) Size() int64        { return i.size }
+func (i diskInfo) Mode() fs.FileMode  { return 0o600 }
+func (i diskInfo) ModTime() time.Time { return time.Time{} }
+func (i diskInfo) IsDir() bool        { return false }
+func (i diskInfo) Sys() any           { return nil }
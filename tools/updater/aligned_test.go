@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// sectorDevice is an in-memory disk that, like a raw device, fails accesses
+// that are not whole sectors.
+type sectorDevice struct {
+	data   []byte
+	sector int64
+}
+
+func (d *sectorDevice) check(p []byte, off int64) error {
+	if off%d.sector != 0 || int64(len(p))%d.sector != 0 {
+		return errors.New("unaligned access")
+	}
+	return nil
+}
+
+func (d *sectorDevice) ReadAt(p []byte, off int64) (int, error) {
+	if err := d.check(p, off); err != nil {
+		return 0, err
+	}
+	n := copy(p, d.data[min(off, int64(len(d.data))):])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *sectorDevice) WriteAt(p []byte, off int64) (int, error) {
+	if err := d.check(p, off); err != nil {
+		return 0, err
+	}
+	return copy(d.data[off:], p), nil
+}
+
+func TestAlignedStorage(t *testing.T) {
+	const size, sector = 64 << 10, 4096
+	dev := &sectorDevice{data: make([]byte, size), sector: sector}
+	a := &alignedStorage{dev: dev, sector: sector, size: size}
+	want := make([]byte, size)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		off := rng.Int64N(size)
+		n := rng.Int64N(min(3*sector, size-off)) + 1
+		p := make([]byte, n)
+		for i := range p {
+			p[i] = byte(rng.Uint32())
+		}
+		if written, err := a.WriteAt(p, off); err != nil || written != len(p) {
+			t.Fatalf("WriteAt(%d bytes, %d) = %d, %v", n, off, written, err)
+		}
+		copy(want[off:], p)
+
+		off = rng.Int64N(size)
+		got := make([]byte, rng.Int64N(size-off)+1)
+		if _, err := a.ReadAt(got, off); err != nil {
+			t.Fatalf("ReadAt(%d bytes, %d): %v", len(got), off, err)
+		}
+		if !bytes.Equal(got, want[off:off+int64(len(got))]) {
+			t.Fatalf("ReadAt(%d bytes, %d) returned other data", len(got), off)
+		}
+	}
+	if !bytes.Equal(dev.data, want) {
+		t.Fatal("device does not hold what was written")
+	}
+
+	if _, err := a.Seek(size-10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	tail, err := io.ReadAll(a)
+	if err != nil || !bytes.Equal(tail, want[size-10:]) {
+		t.Fatalf("read past the last sector: %d bytes, %v", len(tail), err)
+	}
+	if _, err := a.WriteAt([]byte("x"), size); err == nil {
+		t.Fatal("write past the end accepted")
+	}
+	if info, err := a.Stat(); err != nil || info.Size() != size || !info.Mode().IsRegular() {
+		t.Fatalf("Stat = %v, %v", info, err)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/release"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// backupExt names data partition backups: data-<tezsign_id>-<time>.img.xz.
+	backupExt = ".img.xz"
+	// backupMetaExt follows a backup's name for the JSON describing it.
+	backupMetaExt = ".json"
+)
+
+// updateOptions are the flags that change how an update runs.
+type updateOptions struct {
+	// noBackup skips the data partition backup taken before an update.
+	noBackup  bool
+	backupDir string
+	// progress is progressTUI or progressJSON.
+	progress string
+	// mirrors and proxy are where releases are downloaded from; see
+	// newReleaseSource.
+	mirrors []string
+	proxy   string
+	// version pins the release to download; empty means the latest of
+	// channel (empty: see updateChannel).
+	version      string
+	channel      string
+	listReleases bool
+	// allowDowngrade installs a release older than a device's image.
+	allowDowngrade bool
+	// yes lets flash write a destination checkFlashDestination refuses.
+	yes bool
+	// dryRun reports the update as an updatePlan instead of writing.
+	dryRun bool
+	// jobs is how many devices a batch updates at once; 0 is all.
+	jobs int
+	// verifyBoot waits for the updated device to boot and checks it over
+	// USB through the host CLI at hostCLI (found on PATH when empty).
+	verifyBoot    bool
+	verifyTimeout time.Duration
+	hostCLI       string
+	// noCache neither reads nor fills the artifact cache.
+	noCache bool
+	// expandData grows the data partition of a flashed card to its end.
+	expandData bool
+}
+
+// backupMeta is what a restore checks before it writes a backup back.
+type backupMeta struct {
+	TezsignID string `json:"tezsign_id,omitempty"`
+	// Size is the data partition's size; a backup is only restored onto a
+	// partition of the same size.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	LUKS   bool   `json:"luks"`
+	Date   string `json:"date"`
+}
+
+func defaultBackupDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "tezsign-backups"
+	}
+	return filepath.Join(home, "tezsign-backups")
+}
+
+// deviceDataPartition is the data partition of a device of either layout.
+func deviceDataPartition(d *disk.Disk) (part.Partition, error) {
+	if layout, err := loadSlotLayout(d); err == nil {
+		return layout.data, nil
+	} else if !errors.Is(err, common.ErrNotSlottedImage) {
+		return nil, err
+	}
+	_, _, _, data, err := common.GetTezsignPartitions(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions from the device: %w", err)
+	}
+	return data, nil
+}
+
+// backupBeforeUpdate saves the destination's data partition, which holds
+// the only copy of its keys and watermarks, before an update writes to the
+// device. Updates never write the data partition, but a botched one can
+// leave the card unusable.
+func backupBeforeUpdate(d *disk.Disk, destination string, tbl partition.Table, data part.Partition, tezsignID string, opts updateOptions, logger *slog.Logger) error {
+	if opts.noBackup {
+		logger.Warn("Skipping the data partition backup")
+		return nil
+	}
+	if err := unmountDestinationPartitions(destination, tbl, logger, data); err != nil {
+		return err
+	}
+	dir := opts.backupDir
+	if dir == "" {
+		dir = defaultBackupDir()
+	}
+	path, err := backupDataPartition(d, data, tezsignID, dir)
+	if err != nil {
+		return fmt.Errorf("failed to back up the data partition (--no-backup updates without one): %w", err)
+	}
+	logger.Info("Backed up data partition", "backup", path, "restore", fmt.Sprintf("%s restore %s", filepath.Base(os.Args[0]), path))
+	return nil
+}
+
+// backupDataPartition writes the raw data partition, xz-compressed, and its
+// backupMeta into dir. A LUKS partition is saved as it is, still encrypted.
+func backupDataPartition(d *disk.Disk, data part.Partition, tezsignID, dir string) (string, error) {
+	now := time.Now().UTC()
+	name := "data"
+	if id := strings.Map(fileNameRune, tezsignID); id != "" {
+		name += "-" + id
+	}
+	path := filepath.Join(dir, name+"-"+now.Format("20060102-150405")+backupExt)
+	meta := backupMeta{
+		TezsignID: tezsignID,
+		Size:      data.GetSize(),
+		LUKS:      common.IsLUKSPartition(d, data),
+		Date:      now.Format(time.RFC3339),
+	}
+
+	err := writeBackup(path, meta, func(w io.Writer) error {
+		counter := &countingWriter{w: w}
+		return runProgress("backup", "Backing up data partition", data.GetSize(), counter, nil, func(progressReporter) error {
+			_, err := data.ReadContents(d.Backend, counter)
+			return err
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeBackup writes what fill produces to path, xz-compressed, and meta
+// with its hash next to it. The backup only appears under its name once it
+// and its metadata are complete.
+func writeBackup(path string, meta backupMeta, fill func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	xw, err := xz.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to create xz writer: %w", err)
+	}
+
+	h := sha256.New()
+	err = fill(io.MultiWriter(xw, h))
+	if err == nil {
+		err = xw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	meta.SHA256 = hex.EncodeToString(h.Sum(nil))
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+backupMetaExt, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func fileNameRune(r rune) rune {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+		return r
+	}
+	return -1
+}
+
+func readBackupMeta(backup string) (backupMeta, error) {
+	data, err := os.ReadFile(backup + backupMetaExt)
+	if err != nil {
+		return backupMeta{}, fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	var meta backupMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return backupMeta{}, fmt.Errorf("failed to parse %s: %w", backup+backupMetaExt, err)
+	}
+	if meta.Size <= 0 || meta.SHA256 == "" {
+		return backupMeta{}, fmt.Errorf("%s is incomplete", backup+backupMetaExt)
+	}
+	return meta, nil
+}
+
+// performRestore writes a backup taken by backupDataPartition back onto the
+// destination's data partition.
+func performRestore(backup, destination string, logger *slog.Logger) error {
+	meta, err := readBackupMeta(backup)
+	if err != nil {
+		return err
+	}
+
+	dstImg, err := openDisk(destination, diskfs.ReadWriteExclusive)
+	if err != nil {
+		return fmt.Errorf("failed to load destination image: %w", err)
+	}
+	defer dstImg.Close()
+	data, err := deviceDataPartition(dstImg)
+	if err != nil {
+		return err
+	}
+	if data.GetSize() != meta.Size {
+		return fmt.Errorf("the backup is of a %s data partition, the destination's is %s", byteCountToHumanReadable(meta.Size), byteCountToHumanReadable(data.GetSize()))
+	}
+	tbl, err := dstImg.GetPartitionTable()
+	if err != nil {
+		return fmt.Errorf("failed to read destination partition table: %w", err)
+	}
+	if err := unmountDestinationPartitions(destination, tbl, logger, data); err != nil {
+		return err
+	}
+
+	raw, cleanup, err := maybeDecompressSource(backup, logger)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if got, err := release.HashFile(raw); err != nil {
+		return err
+	} else if got.Size != meta.Size || got.SHA256 != meta.SHA256 {
+		return errors.New("the backup does not match its metadata; it is damaged")
+	}
+
+	src, err := os.Open(raw)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	writableDst, err := dstImg.Backend.Writable()
+	if err != nil {
+		return errors.New("failed to get writable backend for destination disk")
+	}
+	logger.Info("Restoring data partition...", "backup", backup, "tezsign_id", meta.TezsignID, "date", meta.Date)
+	written, err := copyBlocks(src, 0, writableDst, data, meta.Size, "data partition", logger)
+	if err != nil {
+		return fmt.Errorf("failed to restore data partition: %w", err)
+	}
+	if err := flushDevice(destination, logger); err != nil {
+		return fmt.Errorf("failed to flush destination after restore: %w", err)
+	}
+	return verifyWrittenPartitions(dstImg, []writtenPartition{written}, logger)
+}
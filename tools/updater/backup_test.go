@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestWriteBackup(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	path := filepath.Join(dir, "data-abc-20260101-000000"+backupExt)
+	content := bytes.Repeat([]byte("watermark"), 10000)
+
+	meta := backupMeta{TezsignID: "abc", Size: int64(len(content)), Date: "2026-01-01T00:00:00Z"}
+	if err := writeBackup(path, meta, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	}); err != nil {
+		t.Fatalf("writeBackup: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("backup: %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("backup dir holds %d files, want the backup and its metadata", len(entries))
+	}
+
+	got, err := readBackupMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if want := (backupMeta{TezsignID: "abc", Size: meta.Size, SHA256: hex.EncodeToString(sum[:]), Date: meta.Date}); got != want {
+		t.Fatalf("meta = %+v, want %+v", got, want)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := xz.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(r); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("backup holds %d bytes, %v", len(data), err)
+	}
+}
+
+func TestWriteBackupFailureLeavesNoBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data"+backupExt)
+	err := writeBackup(path, backupMeta{Size: 1}, func(w io.Writer) error {
+		w.Write([]byte("half"))
+		return errors.New("read error")
+	})
+	if err == nil {
+		t.Fatal("writeBackup succeeded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("failed backup left %d files", len(entries))
+	}
+	if _, err := readBackupMeta(path); err == nil {
+		t.Fatal("metadata of a failed backup read")
+	}
+}
+
+func TestParseUpdateOptions(t *testing.T) {
+	opts, rest, err := parseUpdateOptions([]string{"--no-backup", "rpi4.img.xz", "--backup-dir", "/mnt/usb", "/dev/sdb"})
+	if err != nil || !opts.noBackup || opts.backupDir != "/mnt/usb" || !slices.Equal(rest, []string{"rpi4.img.xz", "/dev/sdb"}) {
+		t.Fatalf("opts = %+v, rest = %q, err = %v", opts, rest, err)
+	}
+	if _, _, err := parseUpdateOptions([]string{"--backup-dir"}); err == nil {
+		t.Fatal("--backup-dir without a directory parsed")
+	}
+	if _, _, err := parseUpdateOptions([]string{"--force"}); err == nil {
+		t.Fatal("unknown option parsed")
+	}
+}
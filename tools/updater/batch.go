@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// batch results
+const (
+	resultPending   = "pending"
+	resultRunning   = "running"
+	resultUpdated   = "updated"
+	resultUnchanged = "unchanged"
+	resultPlanned   = "planned"
+	resultFailed    = "failed"
+	resultSkipped   = "skipped"
+)
+
+// batchDevice is one device of a batch update and what its updater has
+// reported so far.
+type batchDevice struct {
+	Path   string
+	Source string
+	// Kind is passed on to the device's updater when set.
+	Kind UpdateKind
+
+	mu       sync.Mutex
+	result   string
+	title    string
+	bytes    int64
+	total    int64
+	status   string
+	err      string
+	copied   bool
+	plan     *updatePlan
+	started  time.Time
+	finished time.Time
+	log      bytes.Buffer
+}
+
+// batchResult is a device's line of the summary.
+type batchResult struct {
+	Device  string  `json:"device"`
+	Result  string  `json:"result"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// batchUpdate updates several devices, each in its own updater run with
+// --progress json, so that one failing card cannot take the others down and
+// every device keeps its own progress.
+type batchUpdate struct {
+	devices []*batchDevice
+	// jobs is how many devices are updated at once; 0 is all of them.
+	jobs int
+	args []string
+	// command starts the updater for a device.
+	command func(args []string) *exec.Cmd
+	// stopped is set when the user quits; no further device is started.
+	stopped atomic.Bool
+	// forward, when set, receives every event with its device.
+	forward func(ev progressEvent)
+}
+
+func newBatchUpdate(devices []*batchDevice, opts updateOptions) (*batchUpdate, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find the updater binary: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, d := range devices {
+		if seen[d.Path] {
+			return nil, fmt.Errorf("device %s is listed twice", d.Path)
+		}
+		seen[d.Path] = true
+		d.result = resultPending
+	}
+	args := []string{"--progress", progressJSON}
+	if opts.noBackup {
+		args = append(args, "--no-backup")
+	}
+	if opts.backupDir != "" {
+		args = append(args, "--backup-dir", opts.backupDir)
+	}
+	if opts.dryRun {
+		args = append(args, "--dry-run")
+	}
+	if opts.verifyBoot {
+		args = append(args, "--verify-boot")
+		if opts.verifyTimeout > 0 {
+			args = append(args, "--verify-timeout", opts.verifyTimeout.String())
+		}
+		if opts.hostCLI != "" {
+			args = append(args, "--host-cli", opts.hostCLI)
+		}
+	}
+	return &batchUpdate{
+		devices: devices,
+		jobs:    opts.jobs,
+		args:    args,
+		command: func(args []string) *exec.Cmd { return exec.Command(exe, args...) },
+	}, nil
+}
+
+// run updates the devices, at most jobs at a time. A failure does not stop
+// the other devices.
+func (b *batchUpdate) run() {
+	jobs := b.jobs
+	if jobs <= 0 || jobs > len(b.devices) {
+		jobs = len(b.devices)
+	}
+	sem := make(chan struct{}, max(jobs, 1))
+	var wg sync.WaitGroup
+	for _, d := range b.devices {
+		sem <- struct{}{}
+		if b.stopped.Load() {
+			<-sem
+			d.mu.Lock()
+			d.result = resultSkipped
+			d.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			b.update(d)
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *batchUpdate) update(d *batchDevice) {
+	d.mu.Lock()
+	d.result = resultRunning
+	d.started = time.Now()
+	d.mu.Unlock()
+
+	args := append(append([]string{}, b.args...), d.Source, d.Path)
+	if d.Kind != "" {
+		args = append(args, string(d.Kind))
+	}
+	cmd := b.command(args)
+	cmd.Stderr = &lockedWriter{mu: &d.mu, w: &d.log}
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		d.finish(resultFailed, err.Error())
+		return
+	}
+
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64*1024), maxReleaseFileSize)
+	for sc.Scan() {
+		var ev progressEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			continue
+		}
+		d.apply(ev)
+		if b.forward != nil {
+			ev.Device = d.Path
+			b.forward(ev)
+		}
+	}
+	io.Copy(io.Discard, stdout)
+	waitErr := cmd.Wait()
+
+	d.mu.Lock()
+	result := d.result
+	d.mu.Unlock()
+	if result == resultRunning {
+		msg := "updater stopped without a result"
+		if waitErr != nil {
+			msg = fmt.Sprintf("updater stopped without a result: %v", waitErr)
+		}
+		d.finish(resultFailed, msg)
+	}
+}
+
+// apply records an event from the device's updater.
+func (d *batchDevice) apply(ev progressEvent) {
+	d.mu.Lock()
+	switch ev.Event {
+	case "start":
+		d.title, d.bytes, d.total, d.status = ev.Title, 0, ev.Total, ""
+		if ev.Stage == "copy" {
+			d.copied = true
+		}
+	case "progress":
+		d.bytes, d.total = ev.Bytes, ev.Total
+	case "status":
+		d.status = ev.Message
+	case "plan":
+		d.plan = ev.Plan
+	}
+	copied, dryRun := d.copied, d.plan != nil
+	d.mu.Unlock()
+
+	switch ev.Event {
+	case "finished":
+		switch {
+		case dryRun:
+			d.finish(resultPlanned, "")
+		case copied:
+			d.finish(resultUpdated, "")
+		default:
+			d.finish(resultUnchanged, "")
+		}
+	case "failed":
+		d.finish(resultFailed, ev.Message)
+	}
+}
+
+func (d *batchDevice) finish(result, msg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.result, d.err, d.finished = result, msg, time.Now()
+}
+
+func (d *batchDevice) summary() batchResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := batchResult{Device: d.Path, Result: d.result, Error: d.err}
+	if !d.started.IsZero() && !d.finished.IsZero() {
+		r.Seconds = d.finished.Sub(d.started).Round(time.Second).Seconds()
+	}
+	return r
+}
+
+// failed reports how many devices did not end up updated, unchanged or
+// planned.
+func (b *batchUpdate) failed() int {
+	n := 0
+	for _, d := range b.devices {
+		switch d.summary().Result {
+		case resultUpdated, resultUnchanged, resultPlanned:
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// runBatch updates the devices with a row of progress each, or with their
+// events tagged with the device under --progress json, then prints a
+// summary. It fails when any device did not update.
+func runBatch(devices []*batchDevice, opts updateOptions) error {
+	b, err := newBatchUpdate(devices, opts)
+	if err != nil {
+		return err
+	}
+
+	if jsonProgress != nil {
+		b.forward = jsonProgress.emit
+		b.run()
+		results := make([]batchResult, len(devices))
+		for i, d := range devices {
+			results[i] = d.summary()
+		}
+		jsonProgress.emit(progressEvent{Event: "summary", Results: results})
+	} else {
+		p := tea.NewProgram(newBatchModel(b))
+		done := make(chan struct{})
+		go func() {
+			b.run()
+			p.Send(finishMsg{})
+			close(done)
+		}()
+		if _, err := p.Run(); err != nil {
+			b.stopped.Store(true)
+			fmt.Fprintf(os.Stderr, "Failed to render batch progress: %v; waiting for the running updates\n", err)
+		}
+		<-done
+		b.writeSummary(os.Stdout)
+	}
+
+	if n := b.failed(); n > 0 {
+		return fmt.Errorf("%d of %d devices were not updated", n, len(devices))
+	}
+	return nil
+}
+
+// writeSummary prints a line per device, then the plans of a dry run and
+// the logs of the devices that failed.
+func (b *batchUpdate) writeSummary(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nDEVICE\tRESULT\tTIME\tERROR")
+	for _, d := range b.devices {
+		r := d.summary()
+		elapsed := ""
+		if r.Seconds > 0 {
+			elapsed = (time.Duration(r.Seconds) * time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Device, r.Result, elapsed, r.Error)
+	}
+	tw.Flush()
+
+	for _, d := range b.devices {
+		d.mu.Lock()
+		plan, result, log := d.plan, d.result, d.log.String()
+		d.mu.Unlock()
+		if plan != nil {
+			fmt.Fprintln(w)
+			plan.writeTo(w)
+		}
+		if result == resultFailed && log != "" {
+			fmt.Fprintf(w, "\nLog of %s:\n%s", d.Path, log)
+		}
+	}
+}
+
+// parseJobs reads --jobs: devices updated at once, 0 for all.
+func parseJobs(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("--jobs needs a number of devices (0 for all)")
+	}
+	return n, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fakeUpdater stands in for the child updater: it answers for the device
+// named by its last argument.
+const fakeUpdater = `
+for dev; do :; done
+case "$dev" in
+*good) echo '{"event":"start","stage":"copy","title":"Copying app partition","bytes":0,"total":10}'
+       echo '{"event":"progress","stage":"copy","bytes":10,"total":10}'
+       echo '{"event":"finished","bytes":0}' ;;
+*same) echo '{"event":"finished","bytes":0}' ;;
+*bad)  echo 'failed to open device' >&2
+       echo '{"event":"failed","bytes":0,"message":"Update failed: failed to open device"}'
+       exit 1 ;;
+*)     echo 'not json'; exit 3 ;;
+esac
+`
+
+func TestBatchUpdate(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	var devices []*batchDevice
+	for _, name := range []string{"/dev/good", "/dev/same", "/dev/bad", "/dev/crash"} {
+		devices = append(devices, &batchDevice{Path: name, Source: "rpi4.img"})
+	}
+	if _, err := newBatchUpdate(append(devices, &batchDevice{Path: "/dev/good"}), updateOptions{}); err == nil {
+		t.Fatal("device listed twice accepted")
+	}
+	b, err := newBatchUpdate(devices, updateOptions{jobs: 2, noBackup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var calls [][]string
+	b.command = func(args []string) *exec.Cmd {
+		mu.Lock()
+		calls = append(calls, args)
+		mu.Unlock()
+		return exec.Command("sh", append([]string{"-c", fakeUpdater, "sh"}, args...)...)
+	}
+	var forwarded []progressEvent
+	b.forward = func(ev progressEvent) {
+		mu.Lock()
+		forwarded = append(forwarded, ev)
+		mu.Unlock()
+	}
+	b.run()
+
+	good := []string{"--progress", "json", "--no-backup", "rpi4.img", "/dev/good"}
+	if len(calls) != 4 || !slices.ContainsFunc(calls, func(args []string) bool { return slices.Equal(args, good) }) {
+		t.Fatalf("updater runs = %q", calls)
+	}
+	want := map[string]string{"/dev/good": resultUpdated, "/dev/same": resultUnchanged, "/dev/bad": resultFailed, "/dev/crash": resultFailed}
+	for _, d := range devices {
+		if r := d.summary(); r.Result != want[d.Path] {
+			t.Fatalf("%s: %+v", d.Path, r)
+		}
+	}
+	if n := b.failed(); n != 2 {
+		t.Fatalf("failed = %d", n)
+	}
+	for _, ev := range forwarded {
+		if ev.Device == "" {
+			t.Fatalf("event without a device: %+v", ev)
+		}
+	}
+
+	var out bytes.Buffer
+	b.writeSummary(&out)
+	for _, s := range []string{"/dev/good   updated", "Update failed: failed to open device", "updater stopped without a result: exit status 3", "Log of /dev/bad:\nfailed to open device"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("summary lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestBatchStopSkipsPendingDevices(t *testing.T) {
+	devices := []*batchDevice{{Path: "/dev/a"}, {Path: "/dev/b"}}
+	b, err := newBatchUpdate(devices, updateOptions{jobs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.command = func(args []string) *exec.Cmd {
+		b.stopped.Store(true)
+		return exec.Command("sh", "-c", `echo '{"event":"finished","bytes":0}'`)
+	}
+	b.run()
+	if a, bb := devices[0].summary(), devices[1].summary(); a.Result != resultUnchanged || bb.Result != resultSkipped {
+		t.Fatalf("results = %+v, %+v", a, bb)
+	}
+}
+
+func TestSelectionMarksSeveralDevices(t *testing.T) {
+	devices := []deviceCandidate{
+		{Name: "sdb", Path: "/dev/sdb", Valid: true},
+		{Name: "sdc", Path: "/dev/sdc"},
+		{Name: "sdd", Path: "/dev/sdd", Valid: true},
+	}
+	var m tea.Model = newSelectionModel(devices, true)
+	// sdc is not a TezSign card and cannot be marked
+	for _, key := range []tea.KeyType{tea.KeySpace, tea.KeyDown, tea.KeySpace, tea.KeyDown, tea.KeySpace, tea.KeyEnter} {
+		m, _ = m.Update(tea.KeyMsg{Type: key})
+	}
+	got := m.(selectionModel).selectedDevices
+	if len(got) != 2 || got[0].Path != "/dev/sdb" || got[1].Path != "/dev/sdd" {
+		t.Fatalf("selected = %+v", got)
+	}
+
+	m, _ = newSelectionModel(devices, false).Update(tea.KeyMsg{Type: tea.KeySpace})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if got := m.(selectionModel).selectedDevices; len(got) != 1 || got[0].Path != "/dev/sdb" {
+		t.Fatalf("single selection = %+v", got)
+	}
+}
+
+func TestParseJobsOption(t *testing.T) {
+	if opts, _, err := parseUpdateOptions(nil); err != nil || opts.jobs != 1 {
+		t.Fatalf("default jobs = %d, %v", opts.jobs, err)
+	}
+	if opts, _, err := parseUpdateOptions([]string{"--jobs=0"}); err != nil || opts.jobs != 0 {
+		t.Fatalf("--jobs=0 = %d, %v", opts.jobs, err)
+	}
+	for _, bad := range []string{"-1", "all"} {
+		if _, _, err := parseUpdateOptions([]string{"--jobs", bad}); err == nil {
+			t.Fatalf("--jobs %s parsed", bad)
+		}
+	}
+}
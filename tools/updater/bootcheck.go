@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+// key store states of a data partition, as found before an update
+const (
+	storeEmpty       = "empty"
+	storeInitialized = "initialized"
+	// storeVault is a store kept encrypted (LUKS partition or data vault);
+	// it boots locked until the master passphrase opens it.
+	storeVault   = "vault"
+	storeUnknown = "unknown"
+)
+
+const (
+	// hostCLIName is the host CLI, which talks to the device over USB.
+	// The updater does not link libusb, so boot checks go through it.
+	hostCLIName          = "tezsign"
+	defaultVerifyTimeout = 10 * time.Minute
+	verifyPollInterval   = 2 * time.Second
+)
+
+// bootCheck is what --verify-boot expects of a device once it boots the
+// update: its tezsign_id over USB, the new version and the key store it had.
+type bootCheck struct {
+	Device    string
+	TezsignID string
+	// Version is the source's; Previous is what the device ran before.
+	Version  string
+	Previous string
+	Store    string
+}
+
+// bootResult is the outcome of a boot check, sent as a "boot" event with
+// --progress json.
+type bootResult struct {
+	Device   string   `json:"device"`
+	Serial   string   `json:"serial,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Store    string   `json:"store,omitempty"`
+	Passed   bool     `json:"passed"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// gadgetInfo is the part of `tezsign info` the check reads.
+type gadgetInfo struct {
+	Serial  string `json:"serial"`
+	Version string `json:"version"`
+	Store   struct {
+		MasterPresent bool `json:"master_present"`
+		DataLocked    bool `json:"data_locked"`
+	} `json:"store"`
+	SelfTestFailure string `json:"self_test_failure"`
+}
+
+// dataStoreState tells from the data partition whether the device holds a
+// key store. The gadget keeps it under DATA_STORE=/data/tezsign.
+func dataStoreState(d *disk.Disk, data part.Partition) string {
+	if common.IsLUKSPartition(d, data) {
+		return storeVault
+	}
+	fs, err := filesystemForPartition(d, data)
+	if err != nil {
+		return storeUnknown
+	}
+	defer fs.Close()
+	exists := func(name string) bool {
+		f, err := fs.OpenFile(name, os.O_RDONLY)
+		if err != nil {
+			return false
+		}
+		f.Close()
+		return true
+	}
+	switch {
+	case exists("/tezsign/vault.img"):
+		return storeVault
+	case exists("/tezsign/keystore/master.json"):
+		return storeInitialized
+	default:
+		return storeEmpty
+	}
+}
+
+// judge compares what the device reported with what the update expects.
+func (c *bootCheck) judge(info gadgetInfo) bootResult {
+	r := bootResult{Device: c.Device, Serial: info.Serial, Version: info.Version}
+	switch {
+	case info.Store.DataLocked:
+		r.Store = "locked"
+	case info.Store.MasterPresent:
+		r.Store = "initialized"
+	default:
+		r.Store = "not initialized"
+	}
+
+	if c.TezsignID != "" && info.Serial != c.TezsignID {
+		r.Problems = append(r.Problems, fmt.Sprintf("device answered as %s, want %s", orUnknown(info.Serial), c.TezsignID))
+	}
+	if c.Version != "" && info.Version != c.Version {
+		if c.Previous != "" && info.Version == c.Previous {
+			r.Problems = append(r.Problems, fmt.Sprintf("device still runs %s: the update did not boot", c.Previous))
+		} else {
+			r.Problems = append(r.Problems, fmt.Sprintf("device runs %s, want %s", orUnknown(info.Version), c.Version))
+		}
+	}
+	switch c.Store {
+	case storeInitialized:
+		if !info.Store.MasterPresent {
+			r.Problems = append(r.Problems, "key store lost its master.json; restore the backup")
+		}
+	case storeVault:
+		if !info.Store.MasterPresent && !info.Store.DataLocked {
+			r.Problems = append(r.Problems, "encrypted key store is missing; restore the backup")
+		}
+	}
+	if info.SelfTestFailure != "" {
+		r.Problems = append(r.Problems, "self-test failed: "+info.SelfTestFailure)
+	}
+	r.Passed = len(r.Problems) == 0
+	return r
+}
+
+// bootVerifier waits for an updated device to come up over USB and asks it
+// for its version and key store through the host CLI.
+type bootVerifier struct {
+	hostCLI  string
+	timeout  time.Duration
+	interval time.Duration
+	// command runs the host CLI with args.
+	command func(args ...string) *exec.Cmd
+}
+
+func newBootVerifier(opts updateOptions) (*bootVerifier, error) {
+	path, err := findHostCLI(opts.hostCLI)
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.verifyTimeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	return &bootVerifier{
+		hostCLI:  path,
+		timeout:  timeout,
+		interval: verifyPollInterval,
+		command:  func(args ...string) *exec.Cmd { return exec.Command(path, args...) },
+	}, nil
+}
+
+// findHostCLI resolves --host-cli, else tezsign on PATH or next to the
+// updater.
+func findHostCLI(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("host CLI %s: %w", path, err)
+		}
+		return path, nil
+	}
+	if found, err := exec.LookPath(hostCLIName); err == nil {
+		return found, nil
+	}
+	if exe, err := os.Executable(); err == nil {
+		name := hostCLIName
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		candidate := filepath.Join(filepath.Dir(exe), name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("--verify-boot needs the %s host CLI; put it on PATH or pass --host-cli", hostCLIName)
+}
+
+// output runs the host CLI; its stdout is not a terminal, so it answers in
+// JSON.
+func (v *bootVerifier) output(args ...string) ([]byte, error) {
+	cmd := v.command(args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s %s: %w: %s", hostCLIName, args[len(args)-1], err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", hostCLIName, args[len(args)-1], err)
+	}
+	return out, nil
+}
+
+func (v *bootVerifier) serials() ([]string, error) {
+	out, err := v.output("list-devices")
+	if err != nil {
+		return nil, err
+	}
+	var devices []struct{ Serial string }
+	if err := json.Unmarshal(out, &devices); err != nil {
+		return nil, fmt.Errorf("unexpected list-devices output: %w", err)
+	}
+	serials := make([]string, len(devices))
+	for i, d := range devices {
+		serials[i] = d.Serial
+	}
+	return serials, nil
+}
+
+func (v *bootVerifier) info(serial string) (gadgetInfo, error) {
+	var info gadgetInfo
+	out, err := v.output("--device", serial, "info")
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return info, fmt.Errorf("unexpected info output: %w", err)
+	}
+	return info, nil
+}
+
+// wait polls until the device answers, the timeout passes or stop closes.
+// A device without a tezsign_id is only recognized as the one device
+// attached.
+func (v *bootVerifier) wait(c *bootCheck, r progressReporter, stop <-chan struct{}) (gadgetInfo, error) {
+	deadline := time.Now().Add(v.timeout)
+	var lastErr error
+	for {
+		serials, err := v.serials()
+		serial := ""
+		switch {
+		case err != nil:
+			lastErr = err
+		case c.TezsignID != "":
+			for _, s := range serials {
+				if s == c.TezsignID {
+					serial = s
+				}
+			}
+		case len(serials) == 1:
+			serial = serials[0]
+		case len(serials) > 1:
+			lastErr = errors.New("several devices are attached and the updated one has no tezsign_id; attach only it")
+		}
+		if serial != "" {
+			r.Status(fmt.Sprintf("Device %s is attached; asking for its version", serial))
+			info, err := v.info(serial)
+			if err == nil {
+				return info, nil
+			}
+			// the signer may still be starting
+			lastErr = err
+		}
+
+		if time.Now().After(deadline) {
+			err := fmt.Errorf("device did not answer over USB within %s", v.timeout)
+			if lastErr != nil {
+				err = fmt.Errorf("%w: %v", err, lastErr)
+			}
+			return gadgetInfo{}, err
+		}
+		select {
+		case <-stop:
+			return gadgetInfo{}, errors.New("boot verification cancelled")
+		case <-time.After(v.interval):
+		}
+	}
+}
+
+// verify waits for the device to boot the update, then reports whether it
+// runs the new version with its key store intact.
+func (v *bootVerifier) verify(c *bootCheck) error {
+	name := c.TezsignID
+	if name == "" {
+		name = c.Device
+	}
+	fmt.Fprintf(messageOutput(), "Move the card to the device and boot it; waiting up to %s for %s over USB...\n", v.timeout, name)
+
+	stop := make(chan struct{})
+	var info gadgetInfo
+	err := runProgress("boot", "Waiting for "+name+" to boot", -1, nil, func() { close(stop) }, func(r progressReporter) error {
+		var err error
+		info, err = v.wait(c, r, stop)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	result := c.judge(info)
+	if jsonProgress != nil {
+		jsonProgress.emit(progressEvent{Event: "boot", Device: c.Device, Boot: &result})
+	} else {
+		w := messageOutput()
+		if result.Passed {
+			fmt.Fprintf(w, "✅ Boot verified: %s runs %s, key store %s\n", result.Serial, result.Version, result.Store)
+		} else {
+			fmt.Fprintf(w, "❌ Boot check failed: %s runs %s, key store %s\n", orUnknown(result.Serial), orUnknown(result.Version), result.Store)
+			for _, p := range result.Problems {
+				fmt.Fprintf(w, "   - %s\n", p)
+			}
+		}
+	}
+	if !result.Passed {
+		return errors.New(strings.Join(result.Problems, "; "))
+	}
+	return nil
+}
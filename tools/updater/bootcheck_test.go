@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs"
+)
+
+// fakeHostCLI answers list-devices with no device until it has been asked
+// twice, then with tz-0001, whose info is $INFO.
+const fakeHostCLI = `
+case "$1" in
+list-devices)
+	n=$(cat "$COUNT" 2>/dev/null || echo 0)
+	echo $((n + 1)) > "$COUNT"
+	if [ "$n" -lt 2 ]; then echo '[]'; else echo '[{"Serial":"tz-0001","Manufacturer":"TzC","Product":"TezSign"}]'; fi ;;
+--device)
+	[ "$2" = tz-0001 ] || { echo "no device $2" >&2; exit 1; }
+	printf '%s\n' "$INFO" ;;
+esac
+`
+
+func fakeBootVerifier(t *testing.T, info string) *bootVerifier {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	count := filepath.Join(t.TempDir(), "count")
+	return &bootVerifier{
+		hostCLI:  "tezsign",
+		timeout:  5 * time.Second,
+		interval: time.Millisecond,
+		command: func(args ...string) *exec.Cmd {
+			cmd := exec.Command("sh", append([]string{"-c", fakeHostCLI, "sh"}, args...)...)
+			cmd.Env = append(cmd.Environ(), "COUNT="+count, "INFO="+info)
+			return cmd
+		},
+	}
+}
+
+func TestBootVerifierWaitsForDevice(t *testing.T) {
+	v := fakeBootVerifier(t, `{"serial":"tz-0001","version":"abc123","store":{"master_present":true,"keys":2}}`)
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+
+	check := &bootCheck{Device: "/dev/sdb", TezsignID: "tz-0001", Version: "abc123", Previous: "0ld", Store: storeInitialized}
+	if err := v.verify(check); err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(t, &out)
+	last := events[len(events)-1]
+	if events[0].Event != "start" || events[0].Stage != "boot" || last.Event != "boot" || last.Boot == nil {
+		t.Fatalf("events = %+v", events)
+	}
+	if r := *last.Boot; !r.Passed || r.Serial != "tz-0001" || r.Version != "abc123" || r.Store != "initialized" {
+		t.Fatalf("result = %+v", r)
+	}
+
+	v = fakeBootVerifier(t, `{"serial":"tz-0001","version":"0ld","store":{}}`)
+	err := v.verify(check)
+	if err == nil || !strings.Contains(err.Error(), "device still runs 0ld") || !strings.Contains(err.Error(), "lost its master.json") {
+		t.Fatalf("fallback boot verified: %v", err)
+	}
+}
+
+func TestBootVerifierTimesOut(t *testing.T) {
+	v := fakeBootVerifier(t, "{}")
+	v.timeout = 0
+	jsonProgress = newJSONProgressWriter(io.Discard)
+	defer func() { jsonProgress = nil }()
+	err := v.verify(&bootCheck{Device: "/dev/sdb", TezsignID: "tz-0001"})
+	if err == nil || !strings.Contains(err.Error(), "did not answer over USB") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestBootCheckJudge(t *testing.T) {
+	var locked, fresh, failing gadgetInfo
+	locked.Serial, locked.Version, locked.Store.DataLocked = "tz-0001", "abc123", true
+	fresh.Serial, fresh.Version = "tz-0001", "abc123"
+	failing.Serial, failing.Version, failing.Store.MasterPresent, failing.SelfTestFailure = "tz-0002", "xyz", true, "ed25519 KAT"
+
+	check := bootCheck{TezsignID: "tz-0001", Version: "abc123", Previous: "0ld", Store: storeVault}
+	if r := check.judge(locked); !r.Passed || r.Store != "locked" {
+		t.Fatalf("locked vault: %+v", r)
+	}
+	if r := check.judge(fresh); r.Passed {
+		t.Fatalf("missing vault passed: %+v", r)
+	}
+	check.Store = storeEmpty
+	if r := check.judge(fresh); !r.Passed || r.Store != "not initialized" {
+		t.Fatalf("empty store: %+v", r)
+	}
+	if r := check.judge(failing); r.Passed || len(r.Problems) != 3 {
+		t.Fatalf("failing device: %+v", r)
+	}
+}
+
+func TestDataStoreState(t *testing.T) {
+	device := tezsignImage(t, map[string]string{".image-version": "0ld\n"})
+	d, err := openDisk(device, diskfs.ReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	data, err := deviceDataPartition(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dataStoreState(d, data); got != storeEmpty {
+		t.Fatalf("store = %s", got)
+	}
+}
+
+func TestParseVerifyBootOptions(t *testing.T) {
+	opts, _, err := parseUpdateOptions([]string{"--verify-boot", "--verify-timeout=90s", "--host-cli", "/opt/tezsign"})
+	if err != nil || !opts.verifyBoot || opts.verifyTimeout != 90*time.Second || opts.hostCLI != "/opt/tezsign" {
+		t.Fatalf("opts = %+v, %v", opts, err)
+	}
+	for _, bad := range []string{"0s", "soon"} {
+		if _, _, err := parseUpdateOptions([]string{"--verify-timeout", bad}); err == nil {
+			t.Fatalf("--verify-timeout %s parsed", bad)
+		}
+	}
+	if _, err := findHostCLI(filepath.Join(t.TempDir(), "tezsign")); err == nil {
+		t.Fatal("missing --host-cli accepted")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tez-capital/tezsign/tools/release"
+)
+
+const (
+	// envCacheDir moves the artifact cache out of the user cache directory.
+	envCacheDir = "TEZSIGN_CACHE_DIR"
+	// cacheLayout names the cache's layout; a new layout starts a new
+	// directory instead of reading entries it does not understand.
+	cacheLayout = "v1"
+	// cacheKeep is how many images the cache holds, the most recently used.
+	cacheKeep = 3
+	// cacheMetaName is the metadata file of an entry.
+	cacheMetaName = "meta.json"
+)
+
+// artifactCache keeps release images, as downloaded and decompressed, so
+// that later updates reuse them. An entry is the directory
+// <cache>/v1/<sha256 of the compressed image>/ with a meta.json.
+type artifactCache struct {
+	dir string
+}
+
+// cacheEntry is an entry's meta.json. Every file is checked against it
+// before it is used again.
+type cacheEntry struct {
+	// Compressed is the image as released. A download keeps its file in
+	// the entry; a local image only its hash.
+	Compressed release.File `json:"compressed"`
+	// Stored is set when the entry holds the compressed file.
+	Stored bool `json:"stored,omitempty"`
+	// Image is the decompressed image, once there is one.
+	Image   *release.File `json:"image,omitempty"`
+	Release string        `json:"release,omitempty"`
+	Created time.Time     `json:"created"`
+	Used    time.Time     `json:"used"`
+}
+
+// openArtifactCache returns nil with --no-cache.
+func openArtifactCache(opts updateOptions) (*artifactCache, error) {
+	if opts.noCache {
+		return nil, nil
+	}
+	base := os.Getenv(envCacheDir)
+	if base == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			userCache = os.TempDir()
+		}
+		base = filepath.Join(userCache, "tezsign", "cache")
+	}
+	dir := filepath.Join(base, cacheLayout)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the artifact cache: %w", err)
+	}
+	return &artifactCache{dir: dir}, nil
+}
+
+func (c *artifactCache) entryDir(sum string) string {
+	return filepath.Join(c.dir, sum)
+}
+
+func (c *artifactCache) load(sum string) (*cacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(c.entryDir(sum), cacheMetaName))
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("cache entry %s: %w", sum, err)
+	}
+	if e.Compressed.SHA256 != sum {
+		return nil, fmt.Errorf("cache entry %s describes %s", sum, e.Compressed.SHA256)
+	}
+	return &e, nil
+}
+
+// save writes meta.json through a temporary file, so a reader never sees a
+// partial one.
+func (c *artifactCache) save(e *cacheEntry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := c.entryDir(e.Compressed.SHA256)
+	tmp := filepath.Join(dir, cacheMetaName+partSuffix)
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, cacheMetaName))
+}
+
+func (c *artifactCache) remove(sum string) {
+	os.RemoveAll(c.entryDir(sum))
+}
+
+// used records that e was used now and drops the least recently used
+// entries beyond cacheKeep.
+func (c *artifactCache) used(e *cacheEntry, logger *slog.Logger) {
+	e.Used = time.Now().UTC()
+	if err := c.save(e); err != nil {
+		logger.Debug("Failed to update cache entry", "entry", e.Compressed.SHA256, "error", err)
+	}
+	c.prune(logger)
+}
+
+func (c *artifactCache) prune(logger *slog.Logger) {
+	dirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var entries []*cacheEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		e, err := c.load(d.Name())
+		if err != nil {
+			// an entry whose first write was cut short
+			if info, statErr := d.Info(); statErr == nil && time.Since(info.ModTime()) > 24*time.Hour {
+				c.remove(d.Name())
+			}
+			continue
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *cacheEntry) int { return b.Used.Compare(a.Used) })
+	for _, e := range entries[min(cacheKeep, len(entries)):] {
+		logger.Info("Removing cached image", "artifact", e.Compressed.Name, "release", orUnknown(e.Release))
+		c.remove(e.Compressed.SHA256)
+	}
+}
+
+// artifact returns the cached download with the signed hash sum, if the
+// entry holds it at its recorded size. The caller verifies it again, as it
+// would a fresh download.
+func (c *artifactCache) artifact(sum string) (string, bool) {
+	e, err := c.load(sum)
+	if err != nil || !e.Stored {
+		return "", false
+	}
+	path := filepath.Join(c.entryDir(sum), e.Compressed.Name)
+	if info, err := os.Stat(path); err != nil || info.Size() != e.Compressed.Size {
+		return "", false
+	}
+	return path, true
+}
+
+// addArtifact moves a verified download into the cache and returns its new
+// path.
+func (c *artifactCache) addArtifact(path, tag string, logger *slog.Logger) (string, error) {
+	f, err := release.HashFile(path)
+	if err != nil {
+		return "", err
+	}
+	f.Name = filepath.Base(path)
+	dir := c.entryDir(f.SHA256)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	e, err := c.load(f.SHA256)
+	if err != nil {
+		e = &cacheEntry{Compressed: f, Created: time.Now().UTC()}
+	}
+	cached := filepath.Join(dir, f.Name)
+	if err := os.Rename(path, cached); err != nil {
+		return "", err
+	}
+	e.Stored, e.Release = true, tag
+	c.used(e, logger)
+	return cached, nil
+}
+
+// image returns the decompressed image of the xz file at path, from the
+// cache when it holds one whose hash still matches, or decompressed into
+// the cache.
+func (c *artifactCache) image(path string, logger *slog.Logger) (string, error) {
+	hash := func() (release.File, error) {
+		f, err := release.HashFile(path)
+		if err != nil {
+			return f, fmt.Errorf("failed to read source image: %w", err)
+		}
+		f.Name = filepath.Base(path)
+		return f, nil
+	}
+	// a cached download is named by its entry; anything else by its hash
+	var compressed release.File
+	sum := ""
+	if dir := filepath.Dir(path); filepath.Dir(dir) == c.dir {
+		sum = filepath.Base(dir)
+	} else {
+		var err error
+		if compressed, err = hash(); err != nil {
+			return "", err
+		}
+		sum = compressed.SHA256
+	}
+
+	e, err := c.load(sum)
+	if err != nil {
+		if compressed.SHA256 == "" {
+			if compressed, err = hash(); err != nil {
+				return "", err
+			}
+			sum = compressed.SHA256
+		}
+		e = &cacheEntry{Compressed: compressed, Created: time.Now().UTC()}
+	}
+	dir := c.entryDir(sum)
+	if e.Image != nil {
+		cached := filepath.Join(dir, e.Image.Name)
+		got, err := release.HashFile(cached)
+		if err == nil && got.Size == e.Image.Size && got.SHA256 == e.Image.SHA256 {
+			logger.Info("Using cached image", "image", cached)
+			c.used(e, logger)
+			return cached, nil
+		}
+		logger.Warn("Cached image does not match its metadata; decompressing again", "image", cached)
+		os.Remove(cached)
+		e.Image = nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create cache entry: %w", err)
+	}
+	name := strings.TrimSuffix(e.Compressed.Name, ".xz")
+	if name == e.Compressed.Name || name == "" {
+		name = "image.img"
+	}
+	cached := filepath.Join(dir, name)
+	out, err := os.OpenFile(cached+partSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cached image: %w", err)
+	}
+	f, err := decompressInto(path, out, logger)
+	if err != nil {
+		os.Remove(cached + partSuffix)
+		return "", err
+	}
+	if err := os.Rename(cached+partSuffix, cached); err != nil {
+		os.Remove(cached + partSuffix)
+		return "", fmt.Errorf("failed to store cached image: %w", err)
+	}
+	f.Name = name
+	e.Image = &f
+	c.used(e, logger)
+	return cached, nil
+}
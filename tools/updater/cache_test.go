@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func writeXZ(t *testing.T, path string, content []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := xz.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decompressStarted reports whether decompressing path ran the decompress
+// stage, and returns the image.
+func decompressStarted(t *testing.T, path string) (string, bool) {
+	t.Helper()
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	image, cleanup, err := decompressSource(path, updateOptions{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	for _, ev := range readEvents(t, &out) {
+		if ev.Event == "start" && ev.Stage == "decompress" {
+			return image, true
+		}
+	}
+	return image, false
+}
+
+func TestDecompressSourceReusesCachedImage(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	content := bytes.Repeat([]byte("tezsign"), 4096)
+	source := filepath.Join(t.TempDir(), "rpi4.img.xz")
+	writeXZ(t, source, content)
+
+	image, started := decompressStarted(t, source)
+	if !started {
+		t.Fatal("first update did not decompress")
+	}
+	if data, err := os.ReadFile(image); err != nil || !bytes.Equal(data, content) || filepath.Base(image) != "rpi4.img" {
+		t.Fatalf("image %s holds %d bytes, %v", image, len(data), err)
+	}
+	if again, started := decompressStarted(t, source); started || again != image {
+		t.Fatalf("second update decompressed again into %s", again)
+	}
+
+	if err := os.WriteFile(image, []byte("corrupt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, started := decompressStarted(t, source); !started {
+		t.Fatal("corrupted cached image was reused")
+	}
+	if data, err := os.ReadFile(image); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("image holds %d bytes after repair, %v", len(data), err)
+	}
+}
+
+func TestArtifactCacheKeepsRecentEntries(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	cache, err := openArtifactCache(updateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var sums []string
+	for i := range cacheKeep + 2 {
+		path := filepath.Join(t.TempDir(), "rpi4.img.xz")
+		writeXZ(t, path, bytes.Repeat([]byte{byte(i)}, 100))
+		cached, err := cache.addArtifact(path, "v1.0.0", logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Fatal("download was copied, not moved, into the cache")
+		}
+		sums = append(sums, filepath.Base(filepath.Dir(cached)))
+	}
+	for i, sum := range sums {
+		_, ok := cache.artifact(sum)
+		if want := i >= 2; ok != want {
+			t.Fatalf("entry %d cached = %v, want %v", i, ok, want)
+		}
+	}
+
+	if c, err := openArtifactCache(updateOptions{noCache: true}); c != nil || err != nil {
+		t.Fatalf("--no-cache opened %v, %v", c, err)
+	}
+	if opts, _, err := parseUpdateOptions([]string{"--no-cache"}); err != nil || !opts.noCache {
+		t.Fatalf("opts = %+v, %v", opts, err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// update channels for --channel
+const (
+	channelStable  = "stable"
+	channelBeta    = "beta"
+	channelNightly = "nightly"
+)
+
+// channels from the most to the least tested; a channel also tracks the
+// releases of those before it.
+var channelOrder = []string{channelStable, channelBeta, channelNightly}
+
+// channelTracks reports whether channel takes releases of releaseChannel.
+func channelTracks(channel, releaseChannel string) bool {
+	i := slices.Index(channelOrder, releaseChannel)
+	return i >= 0 && i <= slices.Index(channelOrder, channel)
+}
+
+// envUpdateChannel picks the channel when --channel is not given.
+const envUpdateChannel = "TEZSIGN_UPDATE_CHANNEL"
+
+// nightlyTag matches the release-<yyyymmddhhmm> pre-releases CI publishes
+// for every push to main.
+var nightlyTag = regexp.MustCompile(`^release-[0-9]{12}$`)
+
+// channelPolicy is how a channel's images may be applied. It is built into
+// the updater, so neither a mirror nor a release can loosen it.
+type channelPolicy struct {
+	// kinds are the update kinds allowed on single-layout devices, the
+	// preferred one first. A/B devices take any channel's slot update: a
+	// slot that does not come up falls back to the committed one.
+	kinds []UpdateKind
+}
+
+var channelPolicies = map[string]channelPolicy{
+	channelStable: {kinds: []UpdateKind{UpdateKindFull, UpdateKindApp}},
+	channelBeta:   {kinds: []UpdateKind{UpdateKindFull, UpdateKindApp}},
+	// Nightly kernels and boot files have had no testing beyond CI; a
+	// device tracking nightly keeps those of a stable or beta release and
+	// can go back with a full update.
+	channelNightly: {kinds: []UpdateKind{UpdateKindApp}},
+}
+
+// parseChannel checks a --channel value.
+func parseChannel(s string) (string, error) {
+	if _, ok := channelPolicies[s]; !ok {
+		return "", fmt.Errorf("unknown channel %q (want stable, beta or nightly)", s)
+	}
+	return s, nil
+}
+
+// updateChannel is the channel releases are downloaded from: a pinned
+// --version's, then --channel, then TEZSIGN_UPDATE_CHANNEL, then stable.
+func updateChannel(opts updateOptions) (string, error) {
+	if opts.version != "" {
+		return tagChannel(opts.version, false), nil
+	}
+	if opts.channel != "" {
+		return opts.channel, nil
+	}
+	if v := strings.TrimSpace(os.Getenv(envUpdateChannel)); v != "" {
+		channel, err := parseChannel(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", envUpdateChannel, err)
+		}
+		return channel, nil
+	}
+	return channelStable, nil
+}
+
+// tagChannel tells the channel of a release: CI's release-<date> builds are
+// nightly, other pre-releases and tags with a pre-release suffix
+// (v1.2.0-rc.1) beta, the rest stable.
+func tagChannel(tag string, prerelease bool) string {
+	switch {
+	case nightlyTag.MatchString(tag):
+		return channelNightly
+	case prerelease || strings.Contains(tag, "-"):
+		return channelBeta
+	default:
+		return channelStable
+	}
+}
+
+// updateKind is the kind of update a channel's image is applied with.
+// requested is the kind given on the command line, if any.
+func (p channelPolicy) updateKind(channel string, requested UpdateKind, slotted bool) (UpdateKind, error) {
+	if slotted {
+		return UpdateKindFull, nil
+	}
+	if requested == "" {
+		return p.kinds[0], nil
+	}
+	if !slices.Contains(p.kinds, requested) {
+		return "", fmt.Errorf("the %s channel does not allow %s updates of single-layout devices", channel, requested)
+	}
+	return requested, nil
+}
+
+// useChannel points s at the newest release a channel other than stable
+// tracks: each mirror's <channel>/ directory, or on GitHub the newest
+// release the API lists for the channel or a more tested one. It returns
+// the release's tag, when known, and channel as the directory or tag tells
+// it. Neither is signed: the policy that applies is that of the channel the
+// release's signed manifest names (see verifyRelease).
+func (s *releaseSource) useChannel(channel string) (tag, releaseChannel string, err error) {
+	if channel == channelStable {
+		return "", channelStable, nil
+	}
+	if s.mirrored {
+		for i := range s.bases {
+			s.bases[i] += channel + "/"
+		}
+		return "", channel, nil
+	}
+	releases, err := s.listReleases()
+	if err != nil {
+		return "", "", err
+	}
+	// newest first
+	for _, r := range releases {
+		if channelTracks(channel, r.Channel) {
+			s.bases = []string{constants.ReleaseDownloadURL + r.Tag + "/"}
+			return r.Tag, r.Channel, nil
+		}
+	}
+	return "", "", errors.New("no " + channel + " release is published")
+}
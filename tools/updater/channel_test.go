@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+func TestTagChannel(t *testing.T) {
+	for tag, want := range map[string]string{
+		"release-202610020000": channelNightly,
+		"v1.3.0-rc.1":          channelBeta,
+		"v1.2.3":               channelStable,
+		"release-2026":         channelBeta,
+	} {
+		if got := tagChannel(tag, false); got != want {
+			t.Fatalf("%s: channel %s, want %s", tag, got, want)
+		}
+	}
+	if got := tagChannel("v1.3.0", true); got != channelBeta {
+		t.Fatalf("pre-release v1.3.0: %s", got)
+	}
+}
+
+func TestChannelPolicy(t *testing.T) {
+	nightly := channelPolicies[channelNightly]
+	if kind, err := nightly.updateKind(channelNightly, "", false); err != nil || kind != UpdateKindApp {
+		t.Fatalf("nightly, single layout: %s, %v", kind, err)
+	}
+	if _, err := nightly.updateKind(channelNightly, UpdateKindFull, false); err == nil {
+		t.Fatal("nightly full update allowed")
+	}
+	if kind, err := nightly.updateKind(channelNightly, "", true); err != nil || kind != UpdateKindFull {
+		t.Fatalf("nightly, A/B: %s, %v", kind, err)
+	}
+	if kind, err := channelPolicies[channelStable].updateKind(channelStable, "", false); err != nil || kind != UpdateKindFull {
+		t.Fatalf("stable: %s, %v", kind, err)
+	}
+}
+
+func TestUseChannel(t *testing.T) {
+	t.Setenv(envReleaseMirror, "")
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[
+			{"tag_name": "release-202610020000", "prerelease": true},
+			{"tag_name": "v1.3.0-rc.1", "prerelease": true},
+			{"tag_name": "v1.2.3"}
+		]`)
+	}))
+	defer api.Close()
+
+	for channel, want := range map[string]string{channelBeta: "v1.3.0-rc.1", channelNightly: "release-202610020000"} {
+		src, err := newReleaseSource(nil, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.api = api.URL
+		tag, releaseChannel, err := src.useChannel(channel)
+		if err != nil || tag != want || releaseChannel != channel {
+			t.Fatalf("%s: %s (%s), %v", channel, tag, releaseChannel, err)
+		}
+		if !slices.Equal(src.bases, []string{constants.ReleaseDownloadURL + want + "/"}) {
+			t.Fatalf("%s: bases = %v", channel, src.bases)
+		}
+	}
+
+	src, err := newReleaseSource([]string{"https://a.example/tezsign"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, releaseChannel, err := src.useChannel(channelNightly); err != nil || releaseChannel != channelNightly || !slices.Equal(src.bases, []string{"https://a.example/tezsign/nightly/"}) {
+		t.Fatalf("mirrored nightly: %v, %s, %v", src.bases, releaseChannel, err)
+	}
+}
+
+func TestUpdateChannelOption(t *testing.T) {
+	t.Setenv(envUpdateChannel, "")
+	if channel, err := updateChannel(updateOptions{}); err != nil || channel != channelStable {
+		t.Fatalf("default channel = %s, %v", channel, err)
+	}
+	t.Setenv(envUpdateChannel, "beta")
+	if channel, err := updateChannel(updateOptions{}); err != nil || channel != channelBeta {
+		t.Fatalf("channel from the environment = %s, %v", channel, err)
+	}
+	opts, _, err := parseUpdateOptions([]string{"--channel=nightly"})
+	if err != nil || opts.channel != channelNightly {
+		t.Fatalf("--channel = %+v, %v", opts, err)
+	}
+	if channel, err := updateChannel(opts); err != nil || channel != channelNightly {
+		t.Fatalf("--channel over the environment = %s, %v", channel, err)
+	}
+	if channel, err := updateChannel(updateOptions{version: "release-202610020000"}); err != nil || channel != channelNightly {
+		t.Fatalf("pinned nightly = %s, %v", channel, err)
+	}
+	t.Setenv(envUpdateChannel, "edge")
+	if _, err := updateChannel(updateOptions{}); err == nil {
+		t.Fatal("unknown channel in the environment accepted")
+	}
+	for _, bad := range [][]string{{"--channel", "edge"}, {"--channel", "beta", "--version", "v1.2.3"}} {
+		if _, _, err := parseUpdateOptions(bad); err == nil {
+			t.Fatalf("%q parsed", bad)
+		}
+	}
+}
+
+func TestDryRunAppUpdate(t *testing.T) {
+	source := tezsignImage(t, map[string]string{".image-flavour": "rpi4\n", ".image-version": "abc123\n"})
+	device := tezsignImage(t, map[string]string{".image-flavour": "rpi4\n", ".image-version": "0ld\n"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	withBootFiles(t, source, map[string]string{"README": "no app hash here\n"})
+	if _, err := performUpdate(source, device, UpdateKindApp, updateOptions{dryRun: true}, logger); err == nil || !strings.Contains(err.Error(), "no tezsign.sha256") {
+		t.Fatalf("source without an app hash: %v", err)
+	}
+
+	withBootFiles(t, source, map[string]string{apphash.FileName: string(apphash.Marshal(strings.Repeat("ab", 32)))})
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	if _, err := performUpdate(source, device, UpdateKindApp, updateOptions{dryRun: true, noBackup: true}, logger); err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(t, &out)
+	if len(events) != 1 || events[0].Plan == nil {
+		t.Fatalf("events = %+v", events)
+	}
+	plan := events[0].Plan
+	if plan.Partitions[0].SourceSize != 0 || plan.Partitions[1].SourceSize != testPartSize {
+		t.Fatalf("partitions = %+v", plan.Partitions)
+	}
+	if steps := strings.Join(plan.Steps, "\n"); !strings.Contains(steps, "write the source's tezsign.sha256 onto the boot partition") || strings.Contains(steps, "boot partition (partition 1") {
+		t.Fatalf("steps:\n%s", steps)
+	}
+}
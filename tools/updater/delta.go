@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// deltaBlockSize is the unit copyChangedBlocks compares and rewrites. It is
+// a multiple of the erase blocks of common SD cards, so an unchanged block
+// is never erased.
+const deltaBlockSize = 1 << 20
+
+// readWriterAt is the destination of a block copy: a device opened for
+// writing, which is read first.
+type readWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// deltaStats counts the blocks a copy compared and the ones it rewrote.
+type deltaStats struct {
+	blocks, changed int
+}
+
+func (s deltaStats) String() string {
+	return fmt.Sprintf("%d of %d blocks", s.changed, s.blocks)
+}
+
+// copyChangedBlocks makes size bytes of dst at dstStart equal to src at
+// srcStart and writes only the blocks that differ. An update between
+// releases that share most of a partition then writes a fraction of it:
+// reading the card is cheap, writing is slow and wears it. Every source
+// byte also goes to out, for progress and hashing.
+func copyChangedBlocks(src io.ReaderAt, srcStart int64, dst readWriterAt, dstStart, size int64, out io.Writer) (deltaStats, error) {
+	var stats deltaStats
+	want := make([]byte, deltaBlockSize)
+	have := make([]byte, deltaBlockSize)
+	for off := int64(0); off < size; off += deltaBlockSize {
+		n := int(min(deltaBlockSize, size-off))
+		if _, err := src.ReadAt(want[:n], srcStart+off); err != nil {
+			return stats, fmt.Errorf("error occurred while reading from source partition: %w", err)
+		}
+		stats.blocks++
+		if _, err := dst.ReadAt(have[:n], dstStart+off); err != nil || !bytes.Equal(want[:n], have[:n]) {
+			if _, err := dst.WriteAt(want[:n], dstStart+off); err != nil {
+				return stats, fmt.Errorf("error occurred while writing to destination partition: %w", err)
+			}
+			stats.changed++
+		}
+		if _, err := out.Write(want[:n]); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
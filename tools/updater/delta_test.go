@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// device is an in-memory disk that records the offsets written to it.
+type device struct {
+	data   []byte
+	writes []int64
+}
+
+func (d *device) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, d.data[off:]), nil
+}
+
+func (d *device) WriteAt(p []byte, off int64) (int, error) {
+	d.writes = append(d.writes, off)
+	return copy(d.data[off:], p), nil
+}
+
+func TestCopyChangedBlocks(t *testing.T) {
+	const start = 4096
+	size := int64(3*deltaBlockSize + 512)
+	src := bytes.Repeat([]byte{1}, start+int(size))
+	dst := &device{data: bytes.Repeat([]byte{1}, 2*start+int(size))}
+	// the destination partition starts further in, and its second and
+	// last (short) blocks differ
+	copy(dst.data[2*start:], src[start:])
+	dst.data[2*start+deltaBlockSize+7] = 2
+	dst.data[len(dst.data)-1] = 2
+
+	h := sha256.New()
+	stats, err := copyChangedBlocks(bytes.NewReader(src), start, dst, 2*start, size, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.blocks != 4 || stats.changed != 2 {
+		t.Fatalf("stats = %s", stats)
+	}
+	if len(dst.writes) != 2 || dst.writes[0] != 2*start+deltaBlockSize || dst.writes[1] != 2*start+3*deltaBlockSize {
+		t.Fatalf("writes = %v", dst.writes)
+	}
+	if !bytes.Equal(dst.data[2*start:], src[start:]) {
+		t.Fatal("destination differs from the source after the copy")
+	}
+	if want := sha256.Sum256(src[start:]); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Fatal("hash of the copied data is not the source's")
+	}
+
+	if stats, _ := copyChangedBlocks(bytes.NewReader(src), start, dst, 2*start, size, h); stats.changed != 0 {
+		t.Fatalf("second copy rewrote %s", stats)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// Each platform file provides:
+//
+//	listRemovableDisks() ([]deviceCandidate, error)
+//	systemDisks() ([]string, error)
+//	openStorage(path string, readOnly bool) (backend.Storage, error)
+//	partitionMounts(destination string, index int) ([]string, error)
+//	unmountPartition(destination string, index int, logger *slog.Logger) error
+//	mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error)
+//	flushDevice(device string, logger *slog.Logger) error
+//	writeTezsignID(id, destination string, d *disk.Disk, appPartition part.Partition, index int, logger *slog.Logger) error
+//	relabelAppPartition(destination string, d *disk.Disk, p part.Partition, index int, label string) error
+//	canGrowFilesystem() error
+//	growFilesystem(destination string, index int, logger *slog.Logger) error
+
+// errGrowUnsupported is canGrowFilesystem's answer where resize2fs cannot
+// reach the card's partitions.
+var errGrowUnsupported = errors.New("growing the ext4 data filesystem needs resize2fs, which the updater only runs on Linux")
+
+func discoverTezsignDevices(logger *slog.Logger) ([]deviceCandidate, error) {
+	devices, err := listRemovableDisks()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no removable block devices detected")
+	}
+
+	for i := range devices {
+		isTezsign, status := probeTezsignDevice(devices[i].Path)
+		if !isTezsign {
+			logger.Debug("Device did not validate as TezSign", "device", devices[i].Path, "status", status)
+		}
+		devices[i].Status = status
+		devices[i].Valid = isTezsign
+	}
+	return devices, nil
+}
+
+// discoverBlankCards lists the removable disks for a fresh flash; only those
+// without a TezSign layout can be picked.
+func discoverBlankCards(logger *slog.Logger) ([]deviceCandidate, error) {
+	devices, err := listRemovableDisks()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.New("no removable block devices detected")
+	}
+
+	for i := range devices {
+		isTezsign, status := probeTezsignDevice(devices[i].Path)
+		logger.Debug("Probed card for flashing", "device", devices[i].Path, "status", status)
+		devices[i].Valid = !isTezsign
+		devices[i].Status = "no TezSign layout"
+		if isTezsign {
+			devices[i].Status = "holds TezSign; update it instead of flashing"
+		}
+	}
+	return devices, nil
+}
+
+// checkFlashDestination refuses a destination given to flash that the card
+// picker would not offer: flash overwrites the whole disk, partition table
+// included, so it must be a removable disk that holds none of the running
+// system. A regular file is an image and is written as it is.
+func checkFlashDestination(destination string) error {
+	if info, err := os.Stat(destination); err == nil && info.Mode().IsRegular() {
+		return nil
+	}
+	removable, err := listRemovableDisks()
+	if err != nil {
+		return err
+	}
+	system, err := systemDisks()
+	if err != nil {
+		return fmt.Errorf("failed to find the disk of the running system: %w", err)
+	}
+	return flashableDisk(destination, removable, system)
+}
+
+// flashableDisk checks destination against the removable disks and the
+// disks under the root filesystem.
+func flashableDisk(destination string, removable []deviceCandidate, system []string) error {
+	if resolved, err := filepath.EvalSymlinks(destination); err == nil {
+		destination = resolved
+	}
+	if slices.Contains(system, destination) {
+		return fmt.Errorf("%s holds the running system; pass --yes to flash it anyway", destination)
+	}
+	if !slices.ContainsFunc(removable, func(d deviceCandidate) bool { return d.Path == destination }) {
+		paths := make([]string, len(removable))
+		for i, d := range removable {
+			paths[i] = d.Path
+		}
+		found := "none"
+		if len(paths) > 0 {
+			found = strings.Join(paths, ", ")
+		}
+		return fmt.Errorf("%s is not a removable disk (removable: %s); pass --yes to flash it anyway", destination, found)
+	}
+	return nil
+}
+
+// writeTezsignIDInPlace writes tezsign_id through go-diskfs on systems that
+// cannot mount ext4. go-diskfs neither truncates nor cleanly removes ext4
+// files, so an existing tezsign_id is only overwritten by one of the same
+// length.
+func writeTezsignIDInPlace(d *disk.Disk, appPartition part.Partition, id string) error {
+	fs, err := filesystemForPartition(d, appPartition)
+	if err != nil {
+		return fmt.Errorf("failed to open app filesystem: %w", err)
+	}
+	defer fs.Close()
+
+	// go-diskfs does not report a missing file as os.ErrNotExist
+	entries, err := fs.ReadDir(".")
+	if err != nil {
+		return fmt.Errorf("failed to list app filesystem: %w", err)
+	}
+	want := []byte(id + "\n")
+	flags := os.O_CREATE | os.O_RDWR
+	if slices.ContainsFunc(entries, func(e iofs.DirEntry) bool { return e.Name() == "tezsign_id" }) {
+		current, err := fs.ReadFile("tezsign_id")
+		switch {
+		case err != nil:
+			return fmt.Errorf("failed to read tezsign_id: %w", err)
+		case bytes.Equal(current, want):
+			return nil
+		case len(current) != len(want):
+			return errors.New("the image carries a tezsign_id of another length; restore it from Linux")
+		}
+		flags = os.O_RDWR
+	}
+
+	f, err := fs.OpenFile("/tezsign_id", flags)
+	if err != nil {
+		return fmt.Errorf("failed to open tezsign_id: %w", err)
+	}
+	_, err = f.Write(want)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write tezsign_id: %w", err)
+	}
+	fs.Chmod("/tezsign_id", 0o400)
+
+	if got, err := fs.ReadFile("tezsign_id"); err != nil || !bytes.Equal(got, want) {
+		return fmt.Errorf("tezsign_id did not read back: %q, %v", got, err)
+	}
+	return nil
+}
+
+// setFilesystemLabel relabels an ext4 partition through go-diskfs.
+func setFilesystemLabel(d *disk.Disk, p part.Partition, label string) error {
+	fs, err := filesystemForPartition(d, p)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	return fs.SetLabel(label)
+}
+
+// parsePlist decodes an XML property list, as printed by diskutil -plist,
+// into maps, slices, strings, int64s and bools.
+func parsePlist(data []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse property list: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(dec, start)
+		}
+	}
+}
+
+func decodePlistValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]any{}
+		key := ""
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = v
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []any
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				v, err := decodePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", dec.Skip()
+	case "integer":
+		var s string
+		if err := dec.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	default:
+		var s string
+		err := dec.DecodeElement(&s, &start)
+		return s, err
+	}
+}
+
+// parseDiskutilList returns the whole disks of `diskutil list -plist`.
+func parseDiskutilList(data []byte) ([]string, error) {
+	v, err := parsePlist(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, _ := v.(map[string]any)
+	whole, _ := dict["WholeDisks"].([]any)
+	var disks []string
+	for _, d := range whole {
+		if name, ok := d.(string); ok && name != "" {
+			disks = append(disks, name)
+		}
+	}
+	return disks, nil
+}
+
+// parseDiskutilInfo reads a disk from `diskutil info -plist`.
+func parseDiskutilInfo(data []byte) (deviceCandidate, error) {
+	v, err := parsePlist(data)
+	if err != nil {
+		return deviceCandidate{}, err
+	}
+	dict, _ := v.(map[string]any)
+	name, _ := dict["DeviceIdentifier"].(string)
+	node, _ := dict["DeviceNode"].(string)
+	if name == "" || node == "" {
+		return deviceCandidate{}, errors.New("diskutil info lists no device node")
+	}
+	size, ok := dict["Size"].(int64)
+	if !ok {
+		size, _ = dict["TotalSize"].(int64)
+	}
+	model, _ := dict["MediaName"].(string)
+	return deviceCandidate{Name: name, Path: node, SizeBytes: uint64(max(size, 0)), Model: strings.TrimSpace(model)}, nil
+}
+
+// powerShellDisk is one Get-Disk entry converted to JSON.
+type powerShellDisk struct {
+	Number       int
+	FriendlyName string
+	Size         uint64
+}
+
+// parsePowerShellDisks reads Get-Disk output from ConvertTo-Json, which is a
+// single object when only one disk matches.
+func parsePowerShellDisks(data []byte) ([]deviceCandidate, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var disks []powerShellDisk
+	if data[0] != '[' {
+		var d powerShellDisk
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, fmt.Errorf("failed to parse disk list: %w", err)
+		}
+		disks = append(disks, d)
+	} else if err := json.Unmarshal(data, &disks); err != nil {
+		return nil, fmt.Errorf("failed to parse disk list: %w", err)
+	}
+
+	devices := make([]deviceCandidate, 0, len(disks))
+	for _, d := range disks {
+		devices = append(devices, deviceCandidate{
+			Name:      fmt.Sprintf("PhysicalDrive%d", d.Number),
+			Path:      fmt.Sprintf(`\\.\PhysicalDrive%d`, d.Number),
+			SizeBytes: d.Size,
+			Model:     strings.TrimSpace(d.FriendlyName),
+		})
+	}
+	return devices, nil
+}
@@ -0,0 +1,213 @@
+//go:build darwin
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	dkiocGetBlockSize  = 0x40046418 // DKIOCGETBLOCKSIZE
+	dkiocGetBlockCount = 0x40086419 // DKIOCGETBLOCKCOUNT
+)
+
+func diskutil(args ...string) ([]byte, error) {
+	out, err := exec.Command("diskutil", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("diskutil %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("diskutil %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// listRemovableDisks lists the external physical disks diskutil knows of;
+// SD card readers, built in or not, show up there.
+func listRemovableDisks() ([]deviceCandidate, error) {
+	out, err := diskutil("list", "-plist", "external", "physical")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks: %w", err)
+	}
+	names, err := parseDiskutilList(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks: %w", err)
+	}
+
+	var devices []deviceCandidate
+	for _, name := range names {
+		info, err := diskutil("info", "-plist", name)
+		if err != nil {
+			continue
+		}
+		device, err := parseDiskutilInfo(info)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// systemDisks returns the whole disk of the root volume. An APFS volume's
+// is a synthesized disk, which is not external either.
+func systemDisks() ([]string, error) {
+	info, err := diskutil("info", "-plist", "/")
+	if err != nil {
+		return nil, err
+	}
+	v, err := parsePlist(info)
+	if err != nil {
+		return nil, err
+	}
+	dict, _ := v.(map[string]any)
+	if whole, _ := dict["ParentWholeDisk"].(string); whole != "" {
+		return []string{"/dev/" + whole}, nil
+	}
+	return nil, nil
+}
+
+// rawDevicePath maps /dev/diskN to /dev/rdiskN, which skips the buffer cache
+// and is much faster to write.
+func rawDevicePath(path string) string {
+	if strings.HasPrefix(path, "/dev/disk") {
+		return "/dev/r" + strings.TrimPrefix(path, "/dev/")
+	}
+	return path
+}
+
+// partitionDevicePath names partition index of /dev/diskN or /dev/rdiskN.
+func partitionDevicePath(device string, index int) string {
+	return fmt.Sprintf("%ss%d", strings.Replace(device, "/dev/rdisk", "/dev/disk", 1), index)
+}
+
+// openStorage opens images as files and disks through their raw device,
+// which only takes whole sectors.
+func openStorage(path string, readOnly bool) (backend.Storage, error) {
+	flags := os.O_RDONLY
+	if !readOnly {
+		flags = os.O_RDWR
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		f, err := os.OpenFile(path, flags, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return file.New(f, false), nil
+	}
+
+	f, err := os.OpenFile(rawDevicePath(path), flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sector, err := unix.IoctlGetInt(int(f.Fd()), dkiocGetBlockSize)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the block size: %w", err)
+	}
+	count, err := unix.IoctlGetInt(int(f.Fd()), dkiocGetBlockCount)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the block count: %w", err)
+	}
+	return newAlignedStorage(f, int64(sector)*int64(count), int64(sector), readOnly), nil
+}
+
+// partitionMounts returns the mount point diskutil reports for a partition.
+func partitionMounts(destination string, index int) ([]string, error) {
+	info, err := diskutil("info", "-plist", partitionDevicePath(destination, index))
+	if err != nil {
+		// a partition diskutil does not know of is not mounted
+		return nil, nil
+	}
+	v, err := parsePlist(info)
+	if err != nil {
+		return nil, err
+	}
+	dict, _ := v.(map[string]any)
+	if mountPoint, _ := dict["MountPoint"].(string); mountPoint != "" {
+		return []string{mountPoint}, nil
+	}
+	return nil, nil
+}
+
+func unmountPartition(destination string, index int, logger *slog.Logger) error {
+	partDevice := partitionDevicePath(destination, index)
+	mounts, err := partitionMounts(destination, index)
+	if err != nil || len(mounts) == 0 {
+		return err
+	}
+	logger.Debug("Unmounting destination partition", "device", partDevice)
+	if _, err := diskutil("unmount", partDevice); err != nil {
+		return fmt.Errorf("failed to unmount %s: %w", partDevice, err)
+	}
+	return nil
+}
+
+// mountSpecificPartition mounts a FAT partition; macOS has no ext4 driver.
+func mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error) {
+	partDevice := partitionDevicePath(devicePath, partIndex)
+	tmpDir, err := os.MkdirTemp("", "tezsign_mount_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
+	}
+
+	args := []string{"mount"}
+	if !writable {
+		args = append(args, "readOnly")
+	}
+	args = append(args, "-mountPoint", tmpDir, partDevice)
+	if _, err := diskutil(args...); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", nil, fmt.Errorf("failed to mount partition (%s): %w", partDevice, err)
+	}
+
+	cleanup := func() {
+		diskutil("unmount", tmpDir)
+		os.RemoveAll(tmpDir)
+	}
+	return tmpDir, cleanup, nil
+}
+
+// flushDevice asks the disk to write out its cache (F_FULLFSYNC).
+func flushDevice(device string, logger *slog.Logger) error {
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed during device flush", "error", err, "output", string(out))
+	}
+	f, err := os.OpenFile(rawDevicePath(device), os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for flushing: %w", device, err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", device, err)
+	}
+	return nil
+}
+
+func writeTezsignID(id, _ string, d *disk.Disk, appPartition part.Partition, _ int, logger *slog.Logger) error {
+	logger.Debug("Restoring tezsign_id in place")
+	return writeTezsignIDInPlace(d, appPartition, id)
+}
+
+func relabelAppPartition(_ string, d *disk.Disk, p part.Partition, _ int, label string) error {
+	return setFilesystemLabel(d, p, label)
+}
+
+func canGrowFilesystem() error {
+	return errGrowUnsupported
+}
+
+func growFilesystem(string, int, *slog.Logger) error {
+	return errGrowUnsupported
+}
@@ -0,0 +1,416 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"golang.org/x/sys/unix"
+)
+
+// listRemovableDisks lists the removable block devices in sysfs.
+func listRemovableDisks() ([]deviceCandidate, error) {
+	paths, err := filepath.Glob("/sys/block/*/removable")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list block devices: %w", err)
+	}
+
+	var devices []deviceCandidate
+	for _, removablePath := range paths {
+		flag, err := os.ReadFile(removablePath)
+		if err != nil || strings.TrimSpace(string(flag)) != "1" {
+			continue
+		}
+
+		name := filepath.Base(filepath.Dir(removablePath))
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+
+		devices = append(devices, deviceCandidate{
+			Name:      name,
+			Path:      filepath.Join("/dev", name),
+			SizeBytes: readBlockSizeBytes(name),
+			Model:     strings.TrimSpace(readSysfsValue(filepath.Join("/sys/block", name, "device/model"))),
+		})
+	}
+	return devices, nil
+}
+
+// systemDisks lists the disks under the root filesystem: the disk of its
+// partition, or those under its device-mapper or RAID device. A root that is
+// on no block device (tmpfs, overlay, NFS) has none.
+func systemDisks() ([]string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat("/", &st); err != nil {
+		return nil, err
+	}
+	dev := uint64(st.Dev)
+	dir, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(dev), unix.Minor(dev)))
+	if err != nil {
+		return nil, nil
+	}
+	return blockDisks(dir), nil
+}
+
+// blockDisks maps the sysfs directory of a block device to the /dev paths
+// of the disks it sits on.
+func blockDisks(dir string) []string {
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		dir = filepath.Dir(dir)
+	}
+	slaves, _ := filepath.Glob(filepath.Join(dir, "slaves", "*"))
+	if len(slaves) == 0 {
+		return []string{filepath.Join("/dev", filepath.Base(dir))}
+	}
+	var disks []string
+	for _, slave := range slaves {
+		if resolved, err := filepath.EvalSymlinks(slave); err == nil {
+			disks = append(disks, blockDisks(resolved)...)
+		}
+	}
+	return disks
+}
+
+func openStorage(path string, readOnly bool) (backend.Storage, error) {
+	flags := os.O_RDONLY
+	if !readOnly {
+		flags = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return file.New(f, false), nil
+}
+
+func unmountPartition(destination string, index int, logger *slog.Logger) error {
+	return unmountIfMounted(partitionDevicePath(destination, index), logger)
+}
+
+func writeTezsignID(id, destination string, _ *disk.Disk, _ part.Partition, index int, logger *slog.Logger) error {
+	logger.Debug("Restoring tezsign_id via mount", "partition_index", index)
+	return writeTezsignIDViaMount(id, destination, index, logger)
+}
+
+func relabelAppPartition(destination string, _ *disk.Disk, _ part.Partition, index int, label string) error {
+	if out, err := exec.Command("e2label", partitionDevicePath(destination, index), label).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, string(out))
+	}
+	return nil
+}
+
+func readSysfsValue(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func readBlockSizeBytes(name string) uint64 {
+	sizeContent := readSysfsValue(filepath.Join("/sys/block", name, "size"))
+	sectors, err := strconv.ParseUint(strings.TrimSpace(sizeContent), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return sectors * 512 // sectors are 512-byte blocks
+}
+
+func ensureMountAvailable() error {
+	if _, err := exec.LookPath("mount"); err != nil {
+		return fmt.Errorf("mount binary not found: %w", err)
+	}
+	if _, err := exec.LookPath("umount"); err != nil {
+		return fmt.Errorf("umount binary not found: %w", err)
+	}
+	return nil
+}
+
+func mountAppPartition(writable bool) (string, func(), error) {
+	if err := ensureMountAvailable(); err != nil {
+		return "", nil, err
+	}
+	if _, err := os.Stat("/dev/disk/by-label/app"); err != nil {
+		return "", nil, fmt.Errorf("app partition label not found: %w", err)
+	}
+
+	appDev, err := filepath.EvalSymlinks("/dev/disk/by-label/app")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve /dev/disk/by-label/app: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "tezsign_app_mount_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
+	}
+
+	opts := "ro"
+	if writable {
+		opts = "rw,sync"
+	}
+	mountCmd := exec.Command("mount", "-o", opts, appDev, tmpDir)
+	if out, err := mountCmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", nil, fmt.Errorf("failed to mount app partition (%s): %v: %s", appDev, err, string(out))
+	}
+
+	cleanup := func() {
+		exec.Command("umount", tmpDir).Run()
+		os.RemoveAll(tmpDir)
+	}
+	return tmpDir, cleanup, nil
+}
+
+func mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error) {
+	if err := ensureMountAvailable(); err != nil {
+		return "", nil, err
+	}
+
+	partDevice := partitionDevicePath(devicePath, partIndex)
+	if _, err := os.Stat(partDevice); err != nil {
+		return "", nil, fmt.Errorf("resolved partition device does not exist: %s: %w", partDevice, err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "tezsign_mount_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
+	}
+
+	opts := "ro"
+	if writable {
+		opts = "rw,sync"
+	}
+	mountCmd := exec.Command("mount", "-o", opts, partDevice, tmpDir)
+	if out, err := mountCmd.CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", nil, fmt.Errorf("failed to mount partition (%s): %v: %s", partDevice, err, string(out))
+	}
+
+	cleanup := func() {
+		exec.Command("umount", tmpDir).Run()
+		os.RemoveAll(tmpDir)
+	}
+	return tmpDir, cleanup, nil
+}
+
+func partitionDevicePath(device string, index int) string {
+	// mmcblk devices need a 'p' before the partition number; sd/loop don't.
+	sep := ""
+	if len(device) > 0 && device[len(device)-1] >= '0' && device[len(device)-1] <= '9' {
+		sep = "p"
+	}
+	return fmt.Sprintf("%s%s%d", device, sep, index)
+}
+
+// partitionMounts lists where a partition of the destination is mounted.
+func partitionMounts(destination string, index int) ([]string, error) {
+	return mountPoints(partitionDevicePath(destination, index))
+}
+
+// mountPoints lists the mount points of devicePath in /proc/mounts.
+func mountPoints(devicePath string) ([]string, error) {
+	mounts, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc/mounts: %w", err)
+	}
+
+	resolvedTarget, targetErr := filepath.EvalSymlinks(devicePath)
+	var points []string
+
+	for _, line := range strings.Split(string(mounts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		dev := fields[0]
+		mountPoint := fields[1]
+
+		if dev == devicePath {
+			points = append(points, mountPoint)
+			continue
+		}
+		// "proc", "tmpfs" and the like resolve to nothing; so may devicePath
+		if resolvedDev, err := filepath.EvalSymlinks(dev); err == nil && targetErr == nil && resolvedDev == resolvedTarget {
+			points = append(points, mountPoint)
+		}
+	}
+	return points, nil
+}
+
+func unmountIfMounted(devicePath string, logger *slog.Logger) error {
+	points, err := mountPoints(devicePath)
+	if err != nil {
+		return err
+	}
+	for _, mp := range points {
+		logger.Debug("Unmounting destination partition", "device", devicePath, "mount_point", mp)
+		if out, err := exec.Command("umount", mp).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to unmount %s (%s): %v: %s", devicePath, mp, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+func writeTezsignIDViaMount(id, destination string, appPartitionIndex int, logger *slog.Logger) error {
+	partDevice := partitionDevicePath(destination, appPartitionIndex)
+	if err := unmountIfMounted(partDevice, logger); err != nil {
+		return err
+	}
+
+	mountDir, mountCleanup, err := mountSpecificPartition(destination, appPartitionIndex, true)
+	if err != nil {
+		logger.Error("Failed to mount app partition for tezsign_id restore", "error", err, "destination", destination, "partition_index", appPartitionIndex, "device", partDevice)
+		return err
+	}
+	tmpDir := mountDir
+	cleanup := mountCleanup
+	defer cleanup()
+
+	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
+		for _, line := range strings.Split(string(mounts), "\n") {
+			if strings.Contains(line, tmpDir) {
+				logger.Debug("Mount entry for app partition", "entry", line)
+				break
+			}
+		}
+	}
+
+	idPath := fmt.Sprintf("%s/tezsign_id", tmpDir)
+	if err := os.WriteFile(idPath, []byte(id+"\n"), 0644); err != nil {
+		logger.Error("Failed to write tezsign_id to mounted app partition", "error", err, "path", idPath)
+		return err
+	}
+	if err := os.Chmod(idPath, 0400); err != nil {
+		logger.Debug("Failed to chmod tezsign_id", "error", err, "path", idPath)
+	}
+	if err := fsyncPath(idPath); err != nil {
+		logger.Debug("Failed to fsync tezsign_id", "error", err, "path", idPath)
+	}
+	if err := fsyncPath(tmpDir); err != nil {
+		logger.Debug("Failed to fsync app mount directory", "error", err, "path", tmpDir)
+	}
+
+	if out, err := exec.Command("ls", "-l", tmpDir).CombinedOutput(); err != nil {
+		logger.Debug("Failed to list app mount after writing tezsign_id", "error", err, "output", string(out))
+	} else {
+		logger.Debug("App mount contents after tezsign_id restore", "output", string(out))
+	}
+
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed after tezsign_id restore", "error", err, "output", string(out))
+	}
+	if out, err := exec.Command("blockdev", "--flushbufs", partDevice).CombinedOutput(); err != nil {
+		logger.Debug("blockdev flush failed after tezsign_id restore", "error", err, "output", string(out))
+	}
+
+	if err := unmountIfMounted(partDevice, logger); err != nil {
+		return fmt.Errorf("failed to unmount app partition after tezsign_id write: %w", err)
+	}
+	// Avoid double umount/removal if cleanup runs later.
+	os.RemoveAll(tmpDir)
+	cleanup = func() {}
+
+	verifyDir, verifyCleanup, err := mountSpecificPartition(destination, appPartitionIndex, false)
+	if err != nil {
+		return fmt.Errorf("failed to remount app partition for tezsign_id verification: %w", err)
+	}
+	defer verifyCleanup()
+
+	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
+		for _, line := range strings.Split(string(mounts), "\n") {
+			if strings.Contains(line, verifyDir) {
+				logger.Debug("Mount entry for app partition (verify)", "entry", line)
+				break
+			}
+		}
+	}
+	if out, err := exec.Command("ls", "-l", verifyDir).CombinedOutput(); err == nil {
+		logger.Debug("App mount contents after remount verify", "output", string(out))
+	}
+
+	verifyPath := fmt.Sprintf("%s/tezsign_id", verifyDir)
+	data, err := os.ReadFile(verifyPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify tezsign_id after remount: %w", err)
+	}
+	logger.Debug("Verified tezsign_id after remount", "value", strings.TrimSpace(string(data)))
+
+	logger.Debug("tezsign_id restored", "path", verifyPath)
+	return nil
+}
+
+func fsyncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func flushDevice(device string, logger *slog.Logger) error {
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed during device flush", "error", err, "output", string(out))
+		return fmt.Errorf("sync failed during device flush: %w", err)
+	}
+
+	if out, err := exec.Command("blockdev", "--flushbufs", device).CombinedOutput(); err != nil {
+		logger.Debug("blockdev flush failed during device flush", "error", err, "output", string(out))
+		return fmt.Errorf("blockdev flush failed during device flush: %w", err)
+	}
+	return nil
+}
+
+// canGrowFilesystem checks for the tools growFilesystem runs.
+func canGrowFilesystem() error {
+	for _, tool := range []string{"blockdev", "e2fsck", "resize2fs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("growing the data partition needs %s (e2fsprogs, util-linux): %w", tool, err)
+		}
+	}
+	return nil
+}
+
+// growFilesystem rereads the partition table and grows the ext4 filesystem
+// of partition index to fill it. e2fsck exits 1 when it fixed something,
+// which still allows the resize.
+func growFilesystem(destination string, index int, logger *slog.Logger) error {
+	if out, err := exec.Command("blockdev", "--rereadpt", destination).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to reread the partition table of %s: %w: %s", destination, err, strings.TrimSpace(string(out)))
+	}
+	dev := partitionDevicePath(destination, index)
+	// udev creates the partition device after the reread
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(250 * time.Millisecond) {
+		if _, err := os.Stat(dev); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not appear after rereading the partition table", dev)
+		}
+	}
+	logger.Debug("Checking the data filesystem before growing it", "device", dev)
+	if err := exec.Command("e2fsck", "-f", "-y", dev).Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.ExitCode() > 1 {
+			return fmt.Errorf("e2fsck %s: %w", dev, err)
+		}
+	}
+	if out, err := exec.Command("resize2fs", dev).CombinedOutput(); err != nil {
+		return fmt.Errorf("resize2fs %s: %w: %s", dev, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
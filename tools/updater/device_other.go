@@ -0,0 +1,69 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+var errUnsupportedPlatform = errors.New("updating devices is supported on Linux, macOS and Windows")
+
+func listRemovableDisks() ([]deviceCandidate, error) {
+	return nil, errUnsupportedPlatform
+}
+
+func systemDisks() ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// openStorage still opens image files, so images can be checked anywhere.
+func openStorage(path string, readOnly bool) (backend.Storage, error) {
+	flags := os.O_RDONLY
+	if !readOnly {
+		flags = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return file.New(f, false), nil
+}
+
+func partitionMounts(string, int) ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
+
+func unmountPartition(string, int, *slog.Logger) error {
+	return errUnsupportedPlatform
+}
+
+func mountSpecificPartition(string, int, bool) (string, func(), error) {
+	return "", nil, errUnsupportedPlatform
+}
+
+func flushDevice(string, *slog.Logger) error {
+	return errUnsupportedPlatform
+}
+
+func writeTezsignID(string, string, *disk.Disk, part.Partition, int, *slog.Logger) error {
+	return errUnsupportedPlatform
+}
+
+func relabelAppPartition(string, *disk.Disk, part.Partition, int, string) error {
+	return errUnsupportedPlatform
+}
+
+func canGrowFilesystem() error {
+	return errUnsupportedPlatform
+}
+
+func growFilesystem(string, int, *slog.Logger) error {
+	return errUnsupportedPlatform
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+func TestParseDiskutil(t *testing.T) {
+	list := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>AllDisks</key>
+	<array><string>disk4</string><string>disk4s1</string><string>disk4s2</string></array>
+	<key>AllDisksAndPartitions</key>
+	<array>
+		<dict>
+			<key>Content</key><string>FDisk_partition_scheme</string>
+			<key>DeviceIdentifier</key><string>disk4</string>
+			<key>Partitions</key>
+			<array><dict><key>DeviceIdentifier</key><string>disk4s1</string><key>Size</key><integer>268435456</integer></dict></array>
+			<key>Size</key><integer>31914983424</integer>
+		</dict>
+	</array>
+	<key>VolumesFromDisks</key><array/>
+	<key>WholeDisks</key>
+	<array><string>disk4</string></array>
+</dict>
+</plist>`)
+	disks, err := parseDiskutilList(list)
+	if err != nil || !slices.Equal(disks, []string{"disk4"}) {
+		t.Fatalf("parseDiskutilList = %q, %v", disks, err)
+	}
+
+	info := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>BusProtocol</key><string>Secure Digital</string>
+	<key>DeviceIdentifier</key><string>disk4</string>
+	<key>DeviceNode</key><string>/dev/disk4</string>
+	<key>Internal</key><true/>
+	<key>MediaName</key><string>SD Card Reader </string>
+	<key>Removable</key><true/>
+	<key>Size</key><integer>31914983424</integer>
+	<key>WholeDisk</key><true/>
+</dict>
+</plist>`)
+	device, err := parseDiskutilInfo(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (deviceCandidate{Name: "disk4", Path: "/dev/disk4", SizeBytes: 31914983424, Model: "SD Card Reader"}); device != want {
+		t.Fatalf("parseDiskutilInfo = %+v, want %+v", device, want)
+	}
+	if _, err := parseDiskutilInfo([]byte(`<plist><dict/></plist>`)); err == nil {
+		t.Fatal("disk without a device node parsed")
+	}
+}
+
+func TestParsePowerShellDisks(t *testing.T) {
+	one := deviceCandidate{Name: "PhysicalDrive2", Path: `\\.\PhysicalDrive2`, SizeBytes: 31914983424, Model: "Generic MassStorageClass"}
+	for _, tt := range []struct {
+		in   string
+		want []deviceCandidate
+	}{
+		{"", nil},
+		{"[]", []deviceCandidate{}},
+		{`{"Number": 2, "FriendlyName": "Generic MassStorageClass ", "Size": 31914983424}`, []deviceCandidate{one}},
+		{`[
+    {"Number": 2, "FriendlyName": "Generic MassStorageClass", "Size": 31914983424},
+    {"Number": 3, "FriendlyName": "SD Card", "Size": 8000000000}
+]`, []deviceCandidate{one, {Name: "PhysicalDrive3", Path: `\\.\PhysicalDrive3`, SizeBytes: 8000000000, Model: "SD Card"}}},
+	} {
+		got, err := parsePowerShellDisks([]byte(tt.in))
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Fatalf("parsePowerShellDisks(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+	if _, err := parsePowerShellDisks([]byte("Get-Disk : Access denied")); err == nil {
+		t.Fatal("PowerShell error parsed as disks")
+	}
+}
+
+const (
+	testPartStart = 1 << 20
+	testPartSize  = 16 << 20
+)
+
+// ext4Image builds a disk image holding one ext4 partition made by mkfs.ext4.
+func ext4Image(t *testing.T) string {
+	t.Helper()
+	for _, tool := range []string{"mkfs.ext4", "e2fsck"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	dir := t.TempDir()
+	fsImage := filepath.Join(dir, "app.ext4")
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-L", "app", fsImage, "16M").CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.ext4: %v: %s", err, out)
+	}
+
+	path := filepath.Join(dir, "disk.img")
+	d, err := diskfs.Create(path, testPartStart+testPartSize, diskfs.SectorSizeDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Partition(&mbr.Table{Partitions: []*mbr.Partition{
+		{Type: mbr.Linux, Start: testPartStart / 512, Size: testPartSize / 512},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	src, err := os.Open(fsImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(io.NewOffsetWriter(dst, testPartStart), src); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openAppPartition(t *testing.T, path string) (*disk.Disk, part.Partition) {
+	t.Helper()
+	d, err := openDisk(path, diskfs.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := d.GetPartitionTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, tbl.GetPartitions()[0]
+}
+
+// fsck checks the partition of a disk image with e2fsck and returns it.
+func fsck(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsImage := filepath.Join(t.TempDir(), "app.ext4")
+	if err := os.WriteFile(fsImage, data[testPartStart:testPartStart+testPartSize], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("e2fsck", "-fn", fsImage).CombinedOutput(); err != nil {
+		t.Fatalf("e2fsck: %v: %s", err, out)
+	}
+	return fsImage
+}
+
+func TestWriteTezsignIDInPlace(t *testing.T) {
+	path := ext4Image(t)
+	readID := func() string {
+		t.Helper()
+		d, p := openAppPartition(t, path)
+		defer d.Close()
+		fs, err := filesystemForPartition(d, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fs.Close()
+		data, err := fs.ReadFile("tezsign_id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	d, p := openAppPartition(t, path)
+	if err := writeTezsignIDInPlace(d, p, "tz-0001"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := setFilesystemLabel(d, p, "app_b"); err != nil {
+		t.Fatalf("relabel: %v", err)
+	}
+	d.Close()
+	fsck(t, path)
+	if got := readID(); got != "tz-0001\n" {
+		t.Fatalf("tezsign_id = %q", got)
+	}
+
+	d, p = openAppPartition(t, path)
+	if err := writeTezsignIDInPlace(d, p, "tz-0001"); err != nil {
+		t.Fatalf("rewrite the same id: %v", err)
+	}
+	if err := writeTezsignIDInPlace(d, p, "tz-0002"); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	if err := writeTezsignIDInPlace(d, p, "tz-000003"); err == nil {
+		t.Fatal("id of another length written in place")
+	}
+	d.Close()
+	fsImage := fsck(t, path)
+	if got := readID(); got != "tz-0002\n" {
+		t.Fatalf("tezsign_id = %q", got)
+	}
+
+	if _, err := exec.LookPath("e2label"); err == nil {
+		out, err := exec.Command("e2label", fsImage).Output()
+		if err != nil || strings.TrimSpace(string(out)) != "app_b" {
+			t.Fatalf("e2label = %q, %v", out, err)
+		}
+	}
+}
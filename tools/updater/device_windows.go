@@ -0,0 +1,280 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	ioctlDiskGetLengthInfo = 0x0007405C // IOCTL_DISK_GET_LENGTH_INFO
+	fsctlLockVolume        = 0x00090018 // FSCTL_LOCK_VOLUME
+	fsctlDismountVolume    = 0x00090020 // FSCTL_DISMOUNT_VOLUME
+
+	// physical drives only take whole sectors; 4 KiB covers 512-byte and
+	// 4K-native cards alike
+	driveAlignment = 4096
+
+	physicalDrivePrefix = `\\.\PhysicalDrive`
+)
+
+var (
+	// lockedVolumes holds the volumes dismounted for the update, locked so
+	// that Windows does not mount them again while their sectors are
+	// written; the locks go with the process.
+	lockedVolumes   = map[string]windows.Handle{}
+	lockedVolumesMu sync.Mutex
+)
+
+func powershell(script string) ([]byte, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("powershell: %v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("powershell: %w", err)
+	}
+	return out, nil
+}
+
+func quotePowerShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// listRemovableDisks lists the USB, SD and MMC disks Get-Disk reports.
+func listRemovableDisks() ([]deviceCandidate, error) {
+	out, err := powershell(`ConvertTo-Json -InputObject @(Get-Disk | Where-Object { $_.BusType -in 'USB','SD','MMC' } | Select-Object Number,FriendlyName,Size)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disks: %w", err)
+	}
+	return parsePowerShellDisks(out)
+}
+
+// systemDisks returns the physical drive of the system drive.
+func systemDisks() ([]string, error) {
+	out, err := powershell(`(Get-Partition -DriveLetter $env:SystemDrive[0]).DiskNumber`)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected disk number %q", strings.TrimSpace(string(out)))
+	}
+	return []string{physicalDrivePrefix + strconv.Itoa(n)}, nil
+}
+
+func diskNumber(device string) (int, error) {
+	if !strings.HasPrefix(device, physicalDrivePrefix) {
+		return 0, fmt.Errorf("%s is not a physical drive (want %sN)", device, physicalDrivePrefix)
+	}
+	return strconv.Atoi(strings.TrimPrefix(device, physicalDrivePrefix))
+}
+
+// openStorage opens images as files and disks as physical drives, whose size
+// go-diskfs cannot read on Windows.
+func openStorage(path string, readOnly bool) (backend.Storage, error) {
+	flags := os.O_RDONLY
+	if !readOnly {
+		flags = os.O_RDWR
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(path, physicalDrivePrefix) {
+		return file.New(f, false), nil
+	}
+
+	var length int64
+	var returned uint32
+	if err := windows.DeviceIoControl(windows.Handle(f.Fd()), ioctlDiskGetLengthInfo, nil, 0,
+		(*byte)(unsafe.Pointer(&length)), uint32(unsafe.Sizeof(length)), &returned, nil); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the disk size: %w", err)
+	}
+	return newAlignedStorage(f, length, driveAlignment, readOnly), nil
+}
+
+func partitionAccessPaths(diskNum, index int) ([]string, error) {
+	out, err := powershell(fmt.Sprintf(`(Get-Partition -DiskNumber %d -PartitionNumber %d -ErrorAction SilentlyContinue).AccessPaths`, diskNum, index))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// partitionMounts lists the drive letters and folders a partition is
+// mounted on.
+func partitionMounts(destination string, index int) ([]string, error) {
+	diskNum, err := diskNumber(destination)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := partitionAccessPaths(diskNum, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes of partition %d: %w", index, err)
+	}
+	var mounts []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, `\\?\`) {
+			mounts = append(mounts, p)
+		}
+	}
+	return mounts, nil
+}
+
+// unmountPartition removes the drive letters of a partition, then locks and
+// dismounts its volume.
+func unmountPartition(destination string, index int, logger *slog.Logger) error {
+	diskNum, err := diskNumber(destination)
+	if err != nil {
+		return err
+	}
+	paths, err := partitionAccessPaths(diskNum, index)
+	if err != nil {
+		return fmt.Errorf("failed to list volumes of partition %d: %w", index, err)
+	}
+
+	for _, p := range paths {
+		if strings.HasPrefix(p, `\\?\`) {
+			continue
+		}
+		logger.Debug("Removing access path of destination partition", "partition_index", index, "path", p)
+		if _, err := powershell(fmt.Sprintf(`Remove-PartitionAccessPath -DiskNumber %d -PartitionNumber %d -AccessPath %s`, diskNum, index, quotePowerShell(p))); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, `\\?\Volume`) {
+			continue
+		}
+		if err := dismountVolume(strings.TrimSuffix(p, `\`)); err != nil {
+			return fmt.Errorf("failed to dismount partition %d: %w", index, err)
+		}
+		logger.Debug("Dismounted destination partition", "partition_index", index, "volume", p)
+	}
+	return nil
+}
+
+func dismountVolume(volume string) error {
+	lockedVolumesMu.Lock()
+	defer lockedVolumesMu.Unlock()
+	if _, ok := lockedVolumes[volume]; ok {
+		return nil
+	}
+
+	name, err := windows.UTF16PtrFromString(volume)
+	if err != nil {
+		return err
+	}
+	h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return err
+	}
+	var returned uint32
+	if err := windows.DeviceIoControl(h, fsctlLockVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		windows.CloseHandle(h)
+		return fmt.Errorf("volume is in use: %w", err)
+	}
+	if err := windows.DeviceIoControl(h, fsctlDismountVolume, nil, 0, nil, 0, &returned, nil); err != nil {
+		windows.CloseHandle(h)
+		return err
+	}
+	lockedVolumes[volume] = h
+	return nil
+}
+
+// releaseVolumes drops the locks unmountPartition took on a partition, so
+// that it can be mounted again.
+func releaseVolumes(diskNum, index int) {
+	paths, err := partitionAccessPaths(diskNum, index)
+	if err != nil {
+		return
+	}
+	lockedVolumesMu.Lock()
+	defer lockedVolumesMu.Unlock()
+	for _, p := range paths {
+		volume := strings.TrimSuffix(p, `\`)
+		if h, ok := lockedVolumes[volume]; ok {
+			windows.CloseHandle(h)
+			delete(lockedVolumes, volume)
+		}
+	}
+}
+
+// mountSpecificPartition mounts a FAT partition on an empty directory;
+// Windows has no ext4 driver.
+func mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error) {
+	diskNum, err := diskNumber(devicePath)
+	if err != nil {
+		return "", nil, err
+	}
+	releaseVolumes(diskNum, partIndex)
+
+	tmpDir, err := os.MkdirTemp("", "tezsign_mount_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
+	}
+	accessPath := quotePowerShell(tmpDir + `\`)
+	if _, err := powershell(fmt.Sprintf(`Add-PartitionAccessPath -DiskNumber %d -PartitionNumber %d -AccessPath %s`, diskNum, partIndex, accessPath)); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", nil, fmt.Errorf("failed to mount partition %d of %s: %w", partIndex, devicePath, err)
+	}
+
+	cleanup := func() {
+		powershell(fmt.Sprintf(`Remove-PartitionAccessPath -DiskNumber %d -PartitionNumber %d -AccessPath %s`, diskNum, partIndex, accessPath))
+		os.RemoveAll(tmpDir)
+	}
+	return tmpDir, cleanup, nil
+}
+
+// flushDevice flushes the drive's buffers (FlushFileBuffers).
+func flushDevice(device string, logger *slog.Logger) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for flushing: %w", device, err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		logger.Debug("FlushFileBuffers failed during device flush", "error", err)
+		return fmt.Errorf("failed to flush %s: %w", device, err)
+	}
+	return nil
+}
+
+func writeTezsignID(id, _ string, d *disk.Disk, appPartition part.Partition, _ int, logger *slog.Logger) error {
+	logger.Debug("Restoring tezsign_id in place")
+	return writeTezsignIDInPlace(d, appPartition, id)
+}
+
+func relabelAppPartition(_ string, d *disk.Disk, p part.Partition, _ int, label string) error {
+	return setFilesystemLabel(d, p, label)
+}
+
+func canGrowFilesystem() error {
+	return errGrowUnsupported
+}
+
+func growFilesystem(string, int, *slog.Logger) error {
+	return errGrowUnsupported
+}
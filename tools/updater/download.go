@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// downloadAttempts bounds consecutive attempts that make no progress.
+	downloadAttempts = 6
+	retryDelay       = 2 * time.Second
+	maxRetryDelay    = 30 * time.Second
+
+	// A download in progress is kept as <name>.part, with the ETag or
+	// Last-Modified of its response in <name>.part.validator.
+	partSuffix      = ".part"
+	validatorSuffix = ".validator"
+)
+
+// permanentError is a download failure a retry will not fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// downloader fetches url into path. Data arrives in path+".part", which
+// survives failures and later runs: the next attempt asks only for the rest
+// with a Range request, and If-Range makes the server send the whole file
+// again if it changed in the meantime.
+type downloader struct {
+	ctx        context.Context
+	client     *http.Client
+	url        string
+	path       string
+	retryDelay time.Duration
+
+	// status and size report retries and the total size to the progress
+	// display; either may be nil.
+	status func(string)
+	size   func(int64)
+
+	done atomic.Int64
+}
+
+func (d *downloader) Count() int64 { return d.done.Load() }
+
+func (d *downloader) Write(p []byte) (int, error) {
+	d.done.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func (d *downloader) partPath() string      { return d.path + partSuffix }
+func (d *downloader) validatorPath() string { return d.partPath() + validatorSuffix }
+
+func (d *downloader) discardPartial() {
+	os.Remove(d.partPath())
+	os.Remove(d.validatorPath())
+}
+
+// run downloads with retries and backoff and renames the finished file into
+// place. A failed download keeps its partial file for the next run.
+func (d *downloader) run() error {
+	delay := d.retryDelay
+	for attempt := 1; ; attempt++ {
+		before := d.Count()
+		err := d.attempt()
+		if err == nil {
+			os.Remove(d.validatorPath())
+			return os.Rename(d.partPath(), d.path)
+		}
+		if d.ctx.Err() != nil {
+			return d.ctx.Err()
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return err
+		}
+		if d.Count() > before {
+			attempt, delay = 1, d.retryDelay
+		}
+		if attempt >= downloadAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		if d.status != nil {
+			d.status(fmt.Sprintf("%v; retrying in %s (%d/%d)", err, delay, attempt+1, downloadAttempts))
+		}
+		select {
+		case <-d.ctx.Done():
+			return d.ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// attempt requests what the partial file is missing and appends it.
+func (d *downloader) attempt() error {
+	var offset int64
+	validator, _ := os.ReadFile(d.validatorPath())
+	if info, err := os.Stat(d.partPath()); err == nil && len(validator) > 0 {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", strings.TrimSpace(string(validator)))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			d.discardPartial()
+			return fmt.Errorf("unexpected Content-Range %q; starting over", resp.Header.Get("Content-Range"))
+		}
+		total = size
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		d.discardPartial()
+		return errors.New("partial download does not fit the file; starting over")
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("failed to download image: %s", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("failed to download image: %s", resp.Status)}
+	}
+	if d.size != nil {
+		d.size(total)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+		if err := d.saveValidator(resp); err != nil {
+			return permanentError{err}
+		}
+	}
+	f, err := os.OpenFile(d.partPath(), flags, 0o600)
+	if err != nil {
+		return permanentError{fmt.Errorf("failed to open partial download: %w", err)}
+	}
+	d.done.Store(offset)
+	n, err := io.Copy(f, io.TeeReader(resp.Body, d))
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		return permanentError{closeErr}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// saveValidator records what If-Range sends on resumption. Weak ETags cannot
+// be used for ranges, and without a validator the download is not resumed.
+func (d *downloader) saveValidator(resp *http.Response) error {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		os.Remove(d.validatorPath())
+		return nil
+	}
+	return os.WriteFile(d.validatorPath(), []byte(validator+"\n"), 0o600)
+}
+
+// parseContentRange reads "bytes <start>-<end>/<size>"; size is -1 for "*".
+func parseContentRange(s string) (start, size int64, ok bool) {
+	rest, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	span, total, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	first, _, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if total == "*" {
+		return start, -1, true
+	}
+	size, err = strconv.ParseInt(total, 10, 64)
+	return start, size, err == nil
+}
+
+// downloadDir holds partial and finished downloads between runs.
+func downloadDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	dir := filepath.Join(base, "tezsign", "downloads")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create download directory: %w", err)
+	}
+	return dir, nil
+}
+
+// downloadWithProgress downloads the release file name from the first of
+// src's mirrors that serves it.
+func downloadWithProgress(src *releaseSource, name string) (string, func(), error) {
+	dir, err := downloadDir()
+	if err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &downloader{
+		ctx:        ctx,
+		client:     src.client,
+		path:       filepath.Join(dir, path.Base(name)),
+		retryDelay: retryDelay,
+	}
+	// a finished file left by an earlier run may be an older release
+	os.Remove(d.path)
+
+	title := fmt.Sprintf("Download %s → %s", path.Base(name), dir)
+	err = runProgress("download", title, -1, d, cancel, func(r progressReporter) error {
+		d.status = r.Status
+		d.size = r.Total
+		var err error
+		for i, u := range src.urls(name) {
+			if i > 0 {
+				r.Status(fmt.Sprintf("%v; trying %s", err, u))
+			}
+			d.url = u
+			if err = d.run(); err == nil || ctx.Err() != nil {
+				return err
+			}
+		}
+		return err
+	})
+	if err != nil {
+		if _, statErr := os.Stat(d.partPath()); statErr == nil {
+			return "", nil, fmt.Errorf("failed to download image: %w (run the updater again to resume)", err)
+		}
+		return "", nil, fmt.Errorf("failed to download image: %w", err)
+	}
+
+	cleanup := func() {
+		os.Remove(d.path)
+	}
+
+	return d.path, cleanup, nil
+}
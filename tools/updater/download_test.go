@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// imageServer serves content with an ETag; the first failures requests are
+// cut off halfway.
+type imageServer struct {
+	content  []byte
+	etag     string
+	failures int
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	fail := s.failures > 0
+	s.failures--
+	s.mu.Unlock()
+
+	if fail {
+		w.Header().Set("ETag", s.etag)
+		w.Header().Set("Content-Length", "1000000")
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.content[:len(s.content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("ETag", s.etag)
+	http.ServeContent(w, r, "rpi4.img.xz", time.Time{}, bytes.NewReader(s.content))
+}
+
+func testDownloader(t *testing.T, url string) *downloader {
+	t.Helper()
+	return &downloader{
+		ctx:        context.Background(),
+		client:     http.DefaultClient,
+		url:        url,
+		path:       filepath.Join(t.TempDir(), "rpi4.img.xz"),
+		retryDelay: time.Millisecond,
+	}
+}
+
+func TestDownloadResumesAfterInterruption(t *testing.T) {
+	s := &imageServer{content: bytes.Repeat([]byte("tezsign"), 10000), etag: `"v1"`, failures: 1}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	d := testDownloader(t, srv.URL+"/rpi4.img.xz")
+	var statuses []string
+	d.status = func(s string) { statuses = append(statuses, s) }
+	if err := d.run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got, err := os.ReadFile(d.path)
+	if err != nil || !bytes.Equal(got, s.content) {
+		t.Fatalf("downloaded %d bytes, %v; want %d", len(got), err, len(s.content))
+	}
+	if len(s.ranges) != 2 || s.ranges[0] != "" || !strings.HasPrefix(s.ranges[1], "bytes=") || s.ranges[1] == "bytes=0-" {
+		t.Fatalf("ranges = %q, want a resumed second request", s.ranges)
+	}
+	if len(statuses) != 1 || !strings.Contains(statuses[0], "retrying") {
+		t.Fatalf("statuses = %q", statuses)
+	}
+	if _, err := os.Stat(d.partPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("partial file left after the download finished")
+	}
+}
+
+func TestDownloadRestartsWhenFileChanged(t *testing.T) {
+	s := &imageServer{content: bytes.Repeat([]byte("tezsign v2"), 1000), etag: `"v2"`}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// a partial download of the previous release
+	d := testDownloader(t, srv.URL+"/rpi4.img.xz")
+	if err := os.WriteFile(d.partPath(), []byte("tezsign v1tezsign v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(d.validatorPath(), []byte(`"v1"`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, _ := os.ReadFile(d.path); !bytes.Equal(got, s.content) {
+		t.Fatalf("downloaded %q..., want the new release", got[:min(len(got), 20)])
+	}
+	if len(s.ranges) != 1 || s.ranges[0] != "bytes=20-" {
+		t.Fatalf("ranges = %q", s.ranges)
+	}
+}
+
+func TestDownloadGivesUp(t *testing.T) {
+	notFound := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notFound++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	d := testDownloader(t, srv.URL+"/rpi4.img.xz")
+	if err := d.run(); err == nil || notFound != 1 {
+		t.Fatalf("404: err = %v after %d requests, want one failed request", err, notFound)
+	}
+
+	s := &imageServer{content: bytes.Repeat([]byte("x"), 1000), etag: `"v1"`, failures: 100}
+	flaky := httptest.NewServer(s)
+	defer flaky.Close()
+	d = testDownloader(t, flaky.URL+"/rpi4.img.xz")
+	if err := d.run(); err == nil {
+		t.Fatal("download that never finishes succeeded")
+	}
+	if _, err := os.Stat(d.partPath()); err != nil {
+		t.Fatalf("partial download not kept: %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for in, want := range map[string][2]int64{
+		"bytes 100-199/200": {100, 200},
+		"bytes 0-0/*":       {0, -1},
+	} {
+		start, size, ok := parseContentRange(in)
+		if !ok || start != want[0] || size != want[1] {
+			t.Fatalf("%s: %d, %d, %v", in, start, size, ok)
+		}
+	}
+	if _, _, ok := parseContentRange("items 1-2/3"); ok {
+		t.Fatal("parsed a non-byte range")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/installer"
+	"github.com/tez-capital/tezsign/tools/common"
+)
+
+// namedPartition is a partition of a TezSign image and how plans call it.
+type namedPartition struct {
+	name string
+	p    part.Partition
+}
+
+// imagePartitions returns the layout of a TezSign image, its partitions,
+// one of its app partitions and its data partition.
+func imagePartitions(d *disk.Disk) (layout string, parts []namedPartition, app, data part.Partition, err error) {
+	if slots, err := loadSlotLayout(d); err == nil {
+		parts = []namedPartition{{"boot partition", slots.boot}}
+		for _, slot := range []string{bootslot.A, bootslot.B} {
+			parts = append(parts, namedPartition{"app partition (slot " + slot + ")", slots.apps[slot]})
+		}
+		parts = append(parts, namedPartition{"data partition", slots.data})
+		return "a/b", parts, slots.apps[bootslot.A], slots.data, nil
+	}
+	boot, rootfs, app, data, err := common.GetTezsignPartitions(d)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	for _, np := range []namedPartition{{"boot partition", boot}, {"rootfs partition", rootfs}, {"app partition", app}, {"data partition", data}} {
+		if np.p != nil {
+			parts = append(parts, np)
+		}
+	}
+	return "single", parts, app, data, nil
+}
+
+// lastPartition is the index of the partition that ends last.
+func lastPartition(tbl partition.Table) int {
+	last, end := 0, int64(-1)
+	for idx, p := range tbl.GetPartitions() {
+		if p != nil && p.GetSize() > 0 && p.GetStart()+p.GetSize() > end {
+			last, end = idx+1, p.GetStart()+p.GetSize()
+		}
+	}
+	return last
+}
+
+// usedPartitions are the partitions of a card's table, if it has one.
+func usedPartitions(d *disk.Disk) (partition.Table, []part.Partition) {
+	tbl, err := d.GetPartitionTable()
+	if err != nil {
+		return nil, nil
+	}
+	var parts []part.Partition
+	for _, p := range tbl.GetPartitions() {
+		if p != nil && p.GetSize() > 0 {
+			parts = append(parts, p)
+		}
+	}
+	return tbl, parts
+}
+
+// performFlash writes source, partition table included, onto a card that
+// holds no TezSign layout. With --expand-data the data partition then grows
+// to the end of the card. Unless it was a dry run, it returns what
+// --verify-boot checks once the device boots.
+func performFlash(source, destination string, opts updateOptions, logger *slog.Logger) (*bootCheck, error) {
+	logger.Info("Starting TezSign flash", "source", source, "destination", destination)
+
+	sourcePath, cleanup, err := decompressSource(source, opts, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	srcImg, err := openDisk(sourcePath, diskfs.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source image: %w", err)
+	}
+	defer srcImg.Close()
+	if ok, err := checkTezsignMarker(srcImg); err != nil || !ok {
+		return nil, errors.New("source is not a TezSign image")
+	}
+	srcTbl, err := srcImg.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source partition table: %w", err)
+	}
+	layout, parts, sourceApp, sourceData, err := imagePartitions(srcImg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source partitions: %w", err)
+	}
+	sourceVersion := imageVersionForPartition(srcImg, sourceApp, logger, "source")
+	luks, err := imageEncryptsData(srcImg, sourceApp)
+	if err != nil {
+		return nil, err
+	}
+	dataIdx, err := partitionIndex(srcTbl, sourceData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the source data partition: %w", err)
+	}
+
+	dstImg, err := openDisk(destination, destinationMode(opts))
+	if err != nil {
+		return nil, err
+	}
+	defer dstImg.Close()
+	if ok, _ := checkTezsignMarker(dstImg); ok {
+		return nil, fmt.Errorf("%s already holds a TezSign layout; flashing would erase its keys, so update it instead", destination)
+	}
+	if srcImg.Size > dstImg.Size {
+		return nil, fmt.Errorf("%s has %s, the image needs %s", destination, byteCountToHumanReadable(dstImg.Size), byteCountToHumanReadable(srcImg.Size))
+	}
+	dstTbl, mounted := usedPartitions(dstImg)
+
+	grow := opts.expandData && dstImg.Size > srcImg.Size
+	if grow {
+		if lastPartition(srcTbl) != dataIdx {
+			return nil, errors.New("the image's data partition is not its last, so --expand-data cannot grow it")
+		}
+		// LUKS images format the whole partition on first boot
+		if !luks {
+			if err := canGrowFilesystem(); err != nil {
+				return nil, fmt.Errorf("cannot grow the data partition: %w; flash without --expand-data", err)
+			}
+		}
+	}
+
+	if opts.dryRun {
+		plan := newUpdatePlan(destination, layout)
+		if f, err := deviceFlavour(sourcePath); err == nil {
+			plan.Flavour = f.Name
+		}
+		plan.SourceVersion = sourceVersion
+		for _, np := range parts {
+			if _, err := plan.partition(srcTbl, np.name, np.p, np.p); err != nil {
+				return nil, err
+			}
+		}
+		if dstTbl != nil {
+			if err := plan.unmounts(destination, dstTbl, mounted...); err != nil {
+				return nil, err
+			}
+		}
+		plan.step("write the whole image (%s), partition table included, over the card (%s)", byteCountToHumanReadable(srcImg.Size), byteCountToHumanReadable(dstImg.Size))
+		plan.step("flush the card, then read the image back and compare it with the source")
+		switch {
+		case grow && luks:
+			plan.step("grow the data partition (partition %d) to the end of the card; the device encrypts and formats it on first boot", dataIdx)
+		case grow:
+			plan.step("grow the data partition (partition %d) and its filesystem to the end of the card", dataIdx)
+		case opts.expandData:
+			plan.step("leave the data partition as it is: the image fills the card")
+		}
+		plan.verifyBoot(opts)
+		return nil, plan.report()
+	}
+
+	if dstTbl != nil {
+		if err := unmountDestinationPartitions(destination, dstTbl, logger, mounted...); err != nil {
+			return nil, err
+		}
+	}
+	writable, err := dstImg.Backend.Writable()
+	if err != nil {
+		return nil, errors.New("failed to get writable backend for destination disk")
+	}
+
+	logger.Info("Writing image...")
+	h := sha256.New()
+	counter := &countingWriter{w: h}
+	var stats deltaStats
+	err = runProgress("copy", "Writing the image", srcImg.Size, counter, nil, func(progressReporter) error {
+		var copyErr error
+		stats, copyErr = copyChangedBlocks(srcImg.Backend, 0, writable, 0, srcImg.Size, counter)
+		return copyErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the image: %w", err)
+	}
+	logger.Info("Wrote image", "changed", stats.String())
+	if err := flushDevice(destination, logger); err != nil {
+		return nil, fmt.Errorf("failed to flush destination: %w", err)
+	}
+	if err := verifyFlashedImage(dstImg, srcImg.Size, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return nil, err
+	}
+
+	if grow {
+		logger.Info("Growing the data partition...")
+		if _, err := installer.GrowLastPartition(dstImg, dstImg.Size); err != nil {
+			return nil, fmt.Errorf("failed to grow the data partition: %w", err)
+		}
+		if err := flushDevice(destination, logger); err != nil {
+			return nil, fmt.Errorf("failed to flush destination: %w", err)
+		}
+		if !luks {
+			if err := growFilesystem(destination, dataIdx, logger); err != nil {
+				return nil, fmt.Errorf("failed to grow the data filesystem: %w", err)
+			}
+		}
+	}
+	return &bootCheck{Device: destination, Version: sourceVersion, Store: storeEmpty}, nil
+}
+
+// verifyFlashedImage reads the first size bytes back from the card and
+// compares their hash with the image's.
+func verifyFlashedImage(d *disk.Disk, size int64, want string) error {
+	h := sha256.New()
+	counter := &countingWriter{w: h}
+	if err := runProgress("verify", "Verifying the image", size, counter, nil, func(progressReporter) error {
+		_, err := io.Copy(counter, io.NewSectionReader(d.Backend, 0, size))
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to read back the image: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("the card does not match the image after writing (sha256 %s, want %s); the card may be failing", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// blankCard is a card of size bytes with no partition table.
+func blankCard(t *testing.T, size int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "card.img")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDryRunFlash(t *testing.T) {
+	source := tezsignImage(t, map[string]string{"tezsign": "\x7fELF", ".image-flavour": "rpi4\n", ".image-version": "abc123\n"})
+	card := blankCard(t, 64<<20)
+
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts := updateOptions{dryRun: true}
+	if canGrowFilesystem() == nil {
+		opts.expandData = true
+	}
+	if _, err := performFlash(source, card, opts, logger); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(card); err != nil || slices.ContainsFunc(data, func(b byte) bool { return b != 0 }) {
+		t.Fatalf("dry run wrote to the card (%v)", err)
+	}
+
+	events := readEvents(t, &out)
+	if len(events) != 1 || events[0].Plan == nil {
+		t.Fatalf("events = %+v", events)
+	}
+	plan := events[0].Plan
+	if plan.Flavour != "rpi4" || plan.Layout != "single" || plan.SourceVersion != "abc123" || len(plan.Partitions) != 3 {
+		t.Fatalf("plan = %+v", plan)
+	}
+	want := []string{"write the whole image (", "over the card (64.0 MiB)", "read the image back"}
+	if opts.expandData {
+		want = append(want, "grow the data partition (partition 3) and its filesystem")
+	}
+	steps := strings.Join(plan.Steps, "\n")
+	for _, s := range want {
+		if !strings.Contains(steps, s) {
+			t.Fatalf("steps lack %q:\n%s", s, steps)
+		}
+	}
+}
+
+func TestFlashRefusesUnsuitableCards(t *testing.T) {
+	files := map[string]string{"tezsign": "\x7fELF", ".image-version": "abc123\n"}
+	source := tezsignImage(t, files)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	jsonProgress = newJSONProgressWriter(io.Discard)
+	defer func() { jsonProgress = nil }()
+
+	for name, tc := range map[string]struct {
+		source, card string
+		want         string
+	}{
+		"tezsign card": {source, tezsignImage(t, files), "already holds a TezSign layout"},
+		"small card":   {source, blankCard(t, 1<<20), "the image needs"},
+		"blank source": {blankCard(t, 8<<20), blankCard(t, 64<<20), "not a TezSign image"},
+	} {
+		_, err := performFlash(tc.source, tc.card, updateOptions{}, logger)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+
+	if opts, rest, err := parseUpdateOptions([]string{"flash", "rpi4.img.xz", "--expand-data", "--yes"}); err != nil || !opts.expandData || !opts.yes || len(rest) != 2 {
+		t.Fatalf("opts = %+v, rest = %q, err = %v", opts, rest, err)
+	}
+}
+
+func TestFlashDestination(t *testing.T) {
+	removable := []deviceCandidate{{Path: "/dev/sdb"}, {Path: "/dev/mmcblk0"}}
+	for name, tc := range map[string]struct {
+		destination string
+		system      []string
+		want        string
+	}{
+		"removable card": {"/dev/sdb", []string{"/dev/nvme0n1"}, ""},
+		"fixed disk":     {"/dev/nvme0n1", []string{"/dev/nvme0n1"}, "holds the running system"},
+		"other disk":     {"/dev/sda", []string{"/dev/nvme0n1"}, "is not a removable disk"},
+		// a Raspberry Pi running off its own SD card
+		"system card": {"/dev/mmcblk0", []string{"/dev/mmcblk0"}, "holds the running system"},
+	} {
+		err := flashableDisk(tc.destination, removable, tc.system)
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Fatalf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+	if err := checkFlashDestination(blankCard(t, 1<<20)); err != nil {
+		t.Fatalf("image file refused: %v", err)
+	}
+}
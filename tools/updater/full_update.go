@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -9,31 +11,68 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"sync"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/partition"
 	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/apphash"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/release"
 	"github.com/ulikunitz/xz"
 )
 
-var validFlavours = map[string]bool{
-	"radxa-zero3": true,
-	"rpi4":        true,
-	"rpi0-2w":     true,
+func maybeDecompressSource(path string, logger *slog.Logger) (string, func(), error) {
+	if !strings.HasSuffix(path, ".xz") {
+		return path, func() {}, nil
+	}
+
+	tmpFile, err := os.CreateTemp("", "tezsign_img_*.img")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file for decompression: %w", err)
+	}
+	if _, err := decompressInto(path, tmpFile, logger); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		os.Remove(tmpFile.Name())
+	}
+
+	return tmpFile.Name(), cleanup, nil
 }
 
-func maybeDecompressSource(path string, logger *slog.Logger) (string, func(), error) {
+// decompressSource is maybeDecompressSource through the artifact cache,
+// unless --no-cache turns it off; cached images outlive the run.
+func decompressSource(path string, opts updateOptions, logger *slog.Logger) (string, func(), error) {
 	if !strings.HasSuffix(path, ".xz") {
 		return path, func() {}, nil
 	}
+	cache, err := openArtifactCache(opts)
+	if err != nil {
+		logger.Warn("Artifact cache unavailable; decompressing into a temporary file", "error", err)
+	}
+	if cache == nil {
+		return maybeDecompressSource(path, logger)
+	}
+	image, err := cache.image(path, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	return image, func() {}, nil
+}
 
+// decompressInto decompresses the xz file at path into out, which it
+// closes, and returns the size and hash of what it wrote.
+func decompressInto(path string, out *os.File, logger *slog.Logger) (release.File, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to open compressed source %s: %w", path, err)
+		out.Close()
+		return release.File{}, fmt.Errorf("failed to open compressed source %s: %w", path, err)
 	}
 	stat, _ := f.Stat()
 	totalBytes := stat.Size()
@@ -42,137 +81,135 @@ func maybeDecompressSource(path string, logger *slog.Logger) (string, func(), er
 	r, err := xz.NewReader(cr)
 	if err != nil {
 		f.Close()
-		return "", nil, fmt.Errorf("failed to create xz reader: %w", err)
-	}
-
-	tmpFile, err := os.CreateTemp("", "tezsign_img_*.img")
-	if err != nil {
-		f.Close()
-		return "", nil, fmt.Errorf("failed to create temp file for decompression: %w", err)
+		out.Close()
+		return release.File{}, fmt.Errorf("failed to create xz reader: %w", err)
 	}
 
-	logger.Info("Decompressing source image", "source", path, "destination", tmpFile.Name())
+	logger.Info("Decompressing source image", "source", path, "destination", out.Name())
 
 	cancel := func() {
 		f.Close()
-		tmpFile.Close()
+		out.Close()
 	}
 
-	title := fmt.Sprintf("Decompress %s → %s", filepath.Base(path), filepath.Base(tmpFile.Name()))
-	p := tea.NewProgram(newProgressModel(title, totalBytes, cr, cancel))
-
-	go func() {
-		_, copyErr := io.Copy(tmpFile, r)
-		tmpFile.Close()
+	h := sha256.New()
+	var n int64
+	title := fmt.Sprintf("Decompress %s → %s", filepath.Base(path), filepath.Base(out.Name()))
+	err = runProgress("decompress", title, totalBytes, cr, cancel, func(progressReporter) error {
+		var copyErr error
+		n, copyErr = io.Copy(io.MultiWriter(out, h), r)
+		if closeErr := out.Close(); copyErr == nil {
+			copyErr = closeErr
+		}
 		f.Close()
-		p.Send(finishMsg{err: copyErr})
-	}()
-
-	model, progErr := p.Run()
-	if progErr != nil {
-		os.Remove(tmpFile.Name())
-		return "", nil, fmt.Errorf("failed to render decompress progress: %w", progErr)
-	}
-
-	res, ok := model.(progressModel)
-	if !ok {
-		os.Remove(tmpFile.Name())
-		return "", nil, errors.New("unexpected model type after decompression")
-	}
-
-	if res.err != nil {
-		os.Remove(tmpFile.Name())
-		return "", nil, fmt.Errorf("failed to decompress source image: %w", res.err)
-	}
-
-	cleanup := func() {
-		os.Remove(tmpFile.Name())
+		return copyErr
+	})
+	if err != nil {
+		return release.File{}, fmt.Errorf("failed to decompress source image: %w", err)
 	}
+	return release.File{Name: filepath.Base(out.Name()), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
 
-	return tmpFile.Name(), cleanup, nil
+// writtenPartition is a partition the update copied and the SHA-256 of the
+// data read from the source for it.
+type writtenPartition struct {
+	partition   part.Partition
+	sha256      string
+	description string
 }
 
-func copyPartitionData(srcDisk *disk.Disk, srcPartition part.Partition, dstDisk *disk.Disk, dstPartition part.Partition, description string, logger *slog.Logger) error {
-	pr, pw := io.Pipe()
+// copyPartitionData copies a partition, writing only the blocks that
+// differ, and returns the SHA-256 of what it read for verifyWrittenPartitions.
+func copyPartitionData(srcDisk *disk.Disk, srcPartition part.Partition, dstDisk *disk.Disk, dstPartition part.Partition, description string, logger *slog.Logger) (writtenPartition, error) {
 	writableDst, err := dstDisk.Backend.Writable()
 	if err != nil {
-		return errors.New("failed to get writable backend for destination disk")
+		return writtenPartition{}, errors.New("failed to get writable backend for destination disk")
 	}
 
 	totalBytes := srcPartition.GetSize()
-	counter := &countingWriter{w: pw}
-	progress := tea.NewProgram(newProgressModel(fmt.Sprintf("Copying %s", description), totalBytes, counter, nil))
-
-	errCh := make(chan error, 1)
-
-	go func() {
-		var wg sync.WaitGroup
-		var readErr, writeErr error
-		var readBytes int64
+	if dstPartition.GetSize() < totalBytes {
+		return writtenPartition{}, fmt.Errorf("destination %s is smaller than the source's", description)
+	}
+	return copyBlocks(srcDisk.Backend, srcPartition.GetStart(), writableDst, dstPartition, totalBytes, description, logger)
+}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			defer pw.Close()
+// copyBlocks runs copyChangedBlocks into dstPartition with a progress bar.
+func copyBlocks(src io.ReaderAt, srcStart int64, dst readWriterAt, dstPartition part.Partition, totalBytes int64, description string, logger *slog.Logger) (writtenPartition, error) {
+	h := sha256.New()
+	counter := &countingWriter{w: h}
+	var stats deltaStats
+	err := runProgress("copy", fmt.Sprintf("Copying %s", description), totalBytes, counter, nil, func(progressReporter) error {
+		var copyErr error
+		stats, copyErr = copyChangedBlocks(src, srcStart, dst, dstPartition.GetStart(), totalBytes, counter)
+		return copyErr
+	})
+	if err != nil {
+		return writtenPartition{}, err
+	}
+	logger.Info("Rewrote changed blocks", "partition", description, "changed", stats.String())
 
-			readBytes, readErr = srcPartition.ReadContents(srcDisk.Backend, counter)
-			if readErr != nil {
-				logger.Error("Failed to read contents from source partition", "error", readErr)
-			}
-		}()
+	return writtenPartition{partition: dstPartition, sha256: hex.EncodeToString(h.Sum(nil)), description: description}, nil
+}
 
-		writtenBytes, writeErr := dstPartition.WriteContents(writableDst, pr)
-		if writeErr != nil {
-			logger.Error("Failed to write contents to destination partition", "error", writeErr)
+// verifyWrittenPartitions reads the copied partitions back from the device
+// and compares their hashes with what was copied. The device must have been
+// flushed so the reads come from the card rather than the page cache; a card
+// that silently drops or truncates writes then fails the update here.
+func verifyWrittenPartitions(d *disk.Disk, written []writtenPartition, logger *slog.Logger) error {
+	for _, w := range written {
+		logger.Info("Verifying " + w.description + "...")
+		h := sha256.New()
+		counter := &countingWriter{w: h}
+		if err := runProgress("verify", fmt.Sprintf("Verifying %s", w.description), w.partition.GetSize(), counter, nil, func(progressReporter) error {
+			_, err := w.partition.ReadContents(d.Backend, counter)
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to read back %s: %w", w.description, err)
 		}
-		pr.Close()
-		wg.Wait()
-
-		var copyErr error
-		if readErr != nil {
-			copyErr = errors.New("error occurred while reading from source partition: " + readErr.Error())
-		} else if writeErr != nil {
-			copyErr = errors.New("error occurred while writing to destination partition: " + writeErr.Error())
-		} else if uint64(readBytes) != writtenBytes {
-			copyErr = errors.New("mismatch in bytes read and written")
+		if got := hex.EncodeToString(h.Sum(nil)); got != w.sha256 {
+			return fmt.Errorf("%s does not match the source after writing (sha256 %s, want %s); the card may be failing", w.description, got, w.sha256)
 		}
-
-		progress.Send(finishMsg{err: copyErr})
-		errCh <- copyErr
-	}()
-
-	if _, progErr := progress.Run(); progErr != nil {
-		return fmt.Errorf("failed to render copy progress: %w", progErr)
 	}
-
-	if copyErr := <-errCh; copyErr != nil {
-		return copyErr
-	}
-
 	return nil
 }
 
-func performUpdate(source, destination string, kind UpdateKind, logger *slog.Logger) error {
+// performUpdate updates destination from source. Unless it was a dry run or
+// the device was already up to date, it returns what --verify-boot checks
+// once the device boots.
+func performUpdate(source, destination string, kind UpdateKind, opts updateOptions, logger *slog.Logger) (*bootCheck, error) {
 	logger.Info("Starting TezSign updater", "source", source, "destination", destination, "kind", string(kind))
 
-	sourcePath, cleanup, err := maybeDecompressSource(source, logger)
+	sourcePath, cleanup, err := decompressSource(source, opts, logger)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer cleanup()
 
-	dstImg, destinationBootPartition, destinationRootfsPartition, destinationAppPartition, err := loadImage(destination, diskfs.ReadWriteExclusive)
+	if slotted, err := isSlottedDevice(destination); err == nil && slotted {
+		if kind != UpdateKindFull {
+			return nil, fmt.Errorf("A/B devices update a whole slot; a %s update is not supported", kind)
+		}
+		return performSlotUpdate(sourcePath, destination, opts, logger)
+	}
+
+	dstImg, destinationBootPartition, destinationRootfsPartition, destinationAppPartition, err := loadImage(destination, destinationMode(opts))
 	if err != nil {
-		return fmt.Errorf("failed to load destination image: %w", err)
+		return nil, fmt.Errorf("failed to load destination image: %w", err)
 	}
 	defer dstImg.Close()
 
 	tbl, err := dstImg.GetPartitionTable()
 	if err != nil {
-		return fmt.Errorf("failed to read destination partition table: %w", err)
+		return nil, fmt.Errorf("failed to read destination partition table: %w", err)
 	}
-	if err := unmountDestinationPartitions(destination, tbl, logger, destinationBootPartition, destinationRootfsPartition, destinationAppPartition); err != nil {
-		return err
+	var plan *updatePlan
+	if opts.dryRun {
+		plan = newUpdatePlan(destination, "single")
+		if err := plan.unmounts(destination, tbl, destinationBootPartition, destinationRootfsPartition, destinationAppPartition); err != nil {
+			return nil, err
+		}
+	} else if err := unmountDestinationPartitions(destination, tbl, logger, destinationBootPartition, destinationRootfsPartition, destinationAppPartition); err != nil {
+		return nil, err
 	}
 
 	if ok, err := checkTezsignMarker(dstImg); err != nil {
@@ -182,101 +219,359 @@ func performUpdate(source, destination string, kind UpdateKind, logger *slog.Log
 	}
 
 	switch kind {
-	case UpdateKindFull:
+	case UpdateKindFull, UpdateKindApp:
+		appOnly := kind == UpdateKindApp
 		sourceImg, sourceBootPartition, sourceRootfsPartition, sourceAppPartition, err := loadImage(sourcePath, diskfs.ReadOnly)
 		if err != nil {
-			return fmt.Errorf("failed to load source image: %w", err)
+			return nil, fmt.Errorf("failed to load source image: %w", err)
 		}
 		defer sourceImg.Close()
 
 		sourceVersion := imageVersionForPartition(sourceImg, sourceAppPartition, logger, "source")
-		if sourceVersion != "" {
-			destVersion := imageVersionForPartition(dstImg, destinationAppPartition, logger, "destination")
-			if destVersion != "" && destVersion == sourceVersion {
-				logger.Info("Image version already matches source; skipping update", "version", sourceVersion)
-				return nil
+		destVersion := imageVersionForPartition(dstImg, destinationAppPartition, logger, "destination")
+		if plan != nil {
+			plan.Version, plan.SourceVersion = destVersion, sourceVersion
+		}
+		if sourceVersion != "" && destVersion == sourceVersion {
+			logger.Info("Image version already matches source; skipping update", "version", sourceVersion)
+			if plan != nil {
+				plan.upToDate()
+				return nil, plan.report()
 			}
+			return nil, nil
 		}
 
-		existingTezsignID := backupTezsignID(dstImg, destinationAppPartition, logger)
-		if (sourceBootPartition == nil || destinationBootPartition == nil) && (sourceBootPartition != destinationBootPartition) {
-			return errors.New("boot partition missing in source image or destination device, cannot proceed with full update")
+		_, _, _, destinationDataPartition, err := common.GetTezsignPartitions(dstImg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read destination partitions: %w", err)
 		}
-		if sourceBootPartition != nil && sourceBootPartition.GetSize() != destinationBootPartition.GetSize() {
-			return errors.New("boot partition size mismatch between source image and destination device, cannot proceed with update")
+		if err := checkDataEncryption(sourceImg, sourceAppPartition, dstImg, destinationDataPartition); err != nil {
+			return nil, err
 		}
 
-		if (sourceRootfsPartition == nil) != (destinationRootfsPartition == nil) {
-			return errors.New("rootfs partition presence mismatch between source image and destination device, cannot proceed with update")
+		if (sourceBootPartition == nil || destinationBootPartition == nil) && (sourceBootPartition != destinationBootPartition) {
+			return nil, errors.New("boot partition missing in source image or destination device, cannot proceed with full update")
 		}
+		// an app-only update leaves boot and rootfs as they are
+		if !appOnly {
+			if sourceBootPartition != nil && sourceBootPartition.GetSize() != destinationBootPartition.GetSize() {
+				return nil, errors.New("boot partition size mismatch between source image and destination device, cannot proceed with update")
+			}
+
+			if (sourceRootfsPartition == nil) != (destinationRootfsPartition == nil) {
+				return nil, errors.New("rootfs partition presence mismatch between source image and destination device, cannot proceed with update")
+			}
 
-		if sourceRootfsPartition != nil && sourceRootfsPartition.GetSize() != destinationRootfsPartition.GetSize() {
-			return errors.New("rootfs partition size mismatch between source image and destination device, cannot proceed with update")
+			if sourceRootfsPartition != nil && sourceRootfsPartition.GetSize() != destinationRootfsPartition.GetSize() {
+				return nil, errors.New("rootfs partition size mismatch between source image and destination device, cannot proceed with update")
+			}
 		}
 
 		if sourceAppPartition.GetSize() != destinationAppPartition.GetSize() {
-			return errors.New("app partition size mismatch between source image and destination device, cannot proceed with update")
+			return nil, errors.New("app partition size mismatch between source image and destination device, cannot proceed with update")
 		}
 
+		var appHash []byte
+		if appOnly {
+			if sourceBootPartition == nil {
+				return nil, errors.New("an app-only update needs the boot partition, which holds the app hash")
+			}
+			if appHash, err = readAppHash(sourceImg, sourceBootPartition); err != nil {
+				return nil, err
+			}
+			sourceBootPartition, sourceRootfsPartition = nil, nil
+		}
+
+		existingTezsignID := backupTezsignID(dstImg, destinationAppPartition, logger)
+		if plan != nil {
+			return nil, planFullUpdate(plan, destination, tbl, opts, existingTezsignID, appOnly,
+				[]part.Partition{sourceBootPartition, sourceRootfsPartition, sourceAppPartition},
+				[]part.Partition{destinationBootPartition, destinationRootfsPartition, destinationAppPartition, destinationDataPartition})
+		}
+		if err := backupBeforeUpdate(dstImg, destination, tbl, destinationDataPartition, existingTezsignID, opts, logger); err != nil {
+			return nil, err
+		}
+		check := &bootCheck{
+			Device:    destination,
+			TezsignID: existingTezsignID,
+			Version:   sourceVersion,
+			Previous:  destVersion,
+			Store:     dataStoreState(dstImg, destinationDataPartition),
+		}
+
+		var written []writtenPartition
 		if sourceBootPartition != nil {
 			logger.Info("Updating boot partition...")
-			if err = copyPartitionData(sourceImg, sourceBootPartition, dstImg, destinationBootPartition, "boot partition", logger); err != nil {
-				return fmt.Errorf("failed to update boot partition: %w", err)
+			w, err := copyPartitionData(sourceImg, sourceBootPartition, dstImg, destinationBootPartition, "boot partition", logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update boot partition: %w", err)
 			}
+			written = append(written, w)
 		}
 
 		if sourceRootfsPartition != nil {
 			logger.Info("Updating rootfs partition...")
-			if err = copyPartitionData(sourceImg, sourceRootfsPartition, dstImg, destinationRootfsPartition, "rootfs partition", logger); err != nil {
-				return fmt.Errorf("failed to update rootfs partition: %w", err)
+			w, err := copyPartitionData(sourceImg, sourceRootfsPartition, dstImg, destinationRootfsPartition, "rootfs partition", logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update rootfs partition: %w", err)
 			}
+			written = append(written, w)
 		}
 
 		logger.Info("Updating app partition...")
-		if err = copyPartitionData(sourceImg, sourceAppPartition, dstImg, destinationAppPartition, "app partition", logger); err != nil {
-			return fmt.Errorf("failed to update app partition: %w", err)
+		w, err := copyPartitionData(sourceImg, sourceAppPartition, dstImg, destinationAppPartition, "app partition", logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update app partition: %w", err)
 		}
+		written = append(written, w)
 		if err := flushDevice(destination, logger); err != nil {
-			return fmt.Errorf("failed to flush destination before tezsign_id restore: %w", err)
+			return nil, fmt.Errorf("failed to flush destination before tezsign_id restore: %w", err)
+		}
+		// before the tezsign_id restore changes the app partition
+		if err := verifyWrittenPartitions(dstImg, written, logger); err != nil {
+			return nil, err
 		}
 		if existingTezsignID != "" {
 			if err := restoreTezsignID(existingTezsignID, destination, dstImg, destinationAppPartition, logger); err != nil {
-				return fmt.Errorf("failed to restore tezsign_id: %w", err)
+				return nil, fmt.Errorf("failed to restore tezsign_id: %w", err)
+			}
+		}
+		if appOnly {
+			if err := writeAppHash(appHash, destination, tbl, destinationBootPartition, logger); err != nil {
+				return nil, err
 			}
 		}
+		return check, nil
 	default:
-		return fmt.Errorf("unsupported update kind: %s", kind)
+		return nil, fmt.Errorf("unsupported update kind: %s", kind)
+	}
+}
+
+// destinationMode opens the destination read-only for --dry-run.
+func destinationMode(opts updateOptions) diskfs.OpenModeOption {
+	if opts.dryRun {
+		return diskfs.ReadOnly
+	}
+	return diskfs.ReadWriteExclusive
+}
+
+// planFullUpdate finishes the plan of a full or app-only update from its
+// source boot, rootfs and app partitions and the destination's, followed by
+// its data partition; boot and rootfs may be nil.
+func planFullUpdate(plan *updatePlan, destination string, tbl partition.Table, opts updateOptions, tezsignID string, appOnly bool, src, dst []part.Partition) error {
+	names := []string{"boot partition", "rootfs partition", "app partition"}
+	for i := range names {
+		if dst[i] == nil {
+			continue
+		}
+		if _, err := plan.partition(tbl, names[i], dst[i], src[i]); err != nil {
+			return err
+		}
+	}
+	if _, err := plan.partition(tbl, "data partition", dst[3], nil); err != nil {
+		return err
+	}
+
+	if err := plan.backup(destination, tbl, dst[3], tezsignID, opts); err != nil {
+		return err
+	}
+	plan.writes()
+	plan.step("flush the device, then read the written partitions back and compare them with the source")
+	if tezsignID != "" {
+		plan.step("write tezsign_id %s back onto the app partition", tezsignID)
+	}
+	if appOnly {
+		plan.step("write the source's %s onto the boot partition", apphash.FileName)
+	}
+	plan.verifyBoot(opts)
+	return plan.report()
+}
+
+// readAppHash returns the source's record of its signer binary's hash,
+// which an app-only update puts on the destination boot partition so that
+// app-verify accepts the new binary.
+func readAppHash(sourceImg *disk.Disk, sourceBoot part.Partition) ([]byte, error) {
+	fs, err := filesystemForPartition(sourceImg, sourceBoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source boot filesystem: %w", err)
+	}
+	defer fs.Close()
+	f, err := fs.OpenFile("/"+apphash.Path(""), os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("source image has no %s on its boot partition; use a full update", apphash.FileName)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source %s: %w", apphash.FileName, err)
+	}
+	if _, err := apphash.Parse(data); err != nil {
+		return nil, fmt.Errorf("source %s: %w", apphash.FileName, err)
+	}
+	return data, nil
+}
+
+func writeAppHash(data []byte, destination string, tbl partition.Table, dstBoot part.Partition, logger *slog.Logger) error {
+	bootIdx, err := partitionIndex(tbl, dstBoot)
+	if err != nil {
+		return fmt.Errorf("failed to locate boot partition index: %w", err)
+	}
+	mountDir, cleanup, err := mountSpecificPartition(destination, bootIdx, true)
+	if err != nil {
+		return fmt.Errorf("failed to mount destination boot partition: %w", err)
 	}
+	defer cleanup()
 
+	logger.Info("Updating app hash on the boot partition...")
+	if err := os.WriteFile(filepath.Join(mountDir, apphash.Path("")), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", apphash.FileName, err)
+	}
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed after app hash write", "error", err, "output", string(out))
+	}
 	return nil
 }
 
-func deviceFlavour(devicePath string) (string, error) {
-	d, _, _, appPartition, err := loadImage(devicePath, diskfs.ReadOnly)
+// checkDataEncryption refuses images that disagree with the device on
+// TEZSIGN_DATA_LUKS: either way the updated device would not mount /data.
+func checkDataEncryption(sourceImg *disk.Disk, sourceApp part.Partition, dstImg *disk.Disk, dstData part.Partition) error {
+	sourceLUKS, err := imageEncryptsData(sourceImg, sourceApp)
 	if err != nil {
-		return "", err
+		return err
+	}
+	destinationLUKS := common.IsLUKSPartition(dstImg, dstData)
+
+	switch {
+	case destinationLUKS && !sourceLUKS:
+		return errors.New("destination data partition is encrypted (LUKS2) but the source image was built without TEZSIGN_DATA_LUKS")
+	case !destinationLUKS && sourceLUKS:
+		return errors.New("source image encrypts the data partition but the destination's is plain; flash the image instead of updating")
+	}
+	return nil
+}
+
+// imageEncryptsData reports whether the image was built with
+// TEZSIGN_DATA_LUKS, whose devices format the data partition as LUKS2 on
+// first boot.
+func imageEncryptsData(img *disk.Disk, app part.Partition) (bool, error) {
+	fs, err := filesystemForPartition(img, app)
+	if err != nil {
+		return false, fmt.Errorf("failed to open source app filesystem: %w", err)
+	}
+	defer fs.Close()
+	f, err := fs.OpenFile("/"+constants.DataLUKSMarker, os.O_RDONLY)
+	if err != nil {
+		return false, nil
+	}
+	f.Close()
+	return true, nil
+}
+
+// deviceFlavour reads the device's .image-flavour. Without one the flavour
+// is told from the device tree on the boot partition.
+func deviceFlavour(devicePath string) (flavours.Flavour, error) {
+	d, err := openDisk(devicePath, diskfs.ReadOnly)
+	if err != nil {
+		return flavours.Flavour{}, err
 	}
 	defer d.Close()
 
+	appPartition, err := deviceAppPartition(d)
+	if err != nil {
+		return flavours.Flavour{}, err
+	}
+
 	fs, err := filesystemForPartition(d, appPartition)
 	if err != nil {
-		return "", err
+		return flavours.Flavour{}, err
 	}
 	defer fs.Close()
 
 	flavour, err := readImageFlavour(fs)
 	if err != nil {
-		return "", err
+		return flavours.Flavour{}, err
+	}
+	if f, ok := flavours.Lookup(flavour); ok {
+		return f, nil
+	}
+
+	f, err := detectFlavour(d)
+	if err != nil {
+		return flavours.Flavour{}, fmt.Errorf("Image is corrupted, no .image-flavour and %w. Flash a new image manually", err)
+	}
+	return f, nil
+}
+
+// deviceImageDate reads the .image-date of the image the device runs, or ""
+// when it has none.
+func deviceImageDate(devicePath string) string {
+	d, err := openDisk(devicePath, diskfs.ReadOnly)
+	if err != nil {
+		return ""
 	}
-	if flavour != "" {
-		return flavour, nil
+	defer d.Close()
+
+	appPartition, err := deviceAppPartition(d)
+	if err != nil || appPartition == nil {
+		return ""
 	}
+	fs, err := filesystemForPartition(d, appPartition)
+	if err != nil {
+		return ""
+	}
+	defer fs.Close()
+	return release.ReadMarker(fs, "/.image-date")
+}
+
+// detectFlavour tells the flavour from the boot partition's device tree.
+func detectFlavour(d *disk.Disk) (flavours.Flavour, error) {
+	var boot part.Partition
+	if layout, err := loadSlotLayout(d); err == nil {
+		boot = layout.boot
+	} else if boot, _, _, _, err = common.GetTezsignPartitions(d); err != nil {
+		return flavours.Flavour{}, fmt.Errorf("failed to read partitions from the device: %w", err)
+	}
+	if boot == nil {
+		return flavours.Flavour{}, errors.New("no boot partition")
+	}
+
+	fs, err := filesystemForPartition(d, boot)
+	if err != nil {
+		return flavours.Flavour{}, err
+	}
+	defer fs.Close()
 
-	return "", errors.New("Image is corrupted, no .image-flavour. Flash a new image manually")
+	return flavours.Detect(func(p string) bool {
+		f, err := fs.OpenFile("/"+p, os.O_RDONLY)
+		if err != nil {
+			return false
+		}
+		f.Close()
+		return true
+	})
+}
+
+// deviceAppPartition is the app partition, or the active slot's on A/B devices.
+func deviceAppPartition(d *disk.Disk) (part.Partition, error) {
+	if layout, err := loadSlotLayout(d); err == nil {
+		state, err := readSlotState(d, layout.boot)
+		if err != nil {
+			return nil, err
+		}
+		return layout.apps[state.Active], nil
+	} else if !errors.Is(err, common.ErrNotSlottedImage) {
+		return nil, err
+	}
+
+	_, _, appPartition, _, err := common.GetTezsignPartitions(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partitions from the device: %w", err)
+	}
+	return appPartition, nil
 }
 
 func readImageFlavour(fs filesystem.FileSystem) (string, error) {
-	f, err := fs.OpenFile("/.image-flavour", os.O_RDONLY)
+	f, err := fs.OpenFile(flavours.Marker, os.O_RDONLY)
 	if err != nil {
 		// Some filesystems return a custom error string rather than os.ErrNotExist; treat any failure as "missing".
 		return "", nil
@@ -288,7 +583,7 @@ func readImageFlavour(fs filesystem.FileSystem) (string, error) {
 		return "", err
 	}
 	flavour := strings.TrimSpace(string(data))
-	if !validFlavours[flavour] {
+	if !flavours.Valid(flavour) {
 		return "", nil
 	}
 	return flavour, nil
@@ -378,8 +673,7 @@ func restoreTezsignID(id, destination string, d *disk.Disk, appPartition part.Pa
 		return fmt.Errorf("failed to locate app partition index: %w", err)
 	}
 
-	logger.Debug("Restoring tezsign_id via mount", "partition_index", idx)
-	return writeTezsignIDViaMount(id, destination, idx, logger)
+	return writeTezsignID(id, destination, d, appPartition, idx, logger)
 }
 
 func unmountDestinationPartitions(destination string, tbl partition.Table, logger *slog.Logger, partitions ...part.Partition) error {
@@ -391,154 +685,9 @@ func unmountDestinationPartitions(destination string, tbl partition.Table, logge
 		if err != nil {
 			return fmt.Errorf("failed to locate partition for unmounting: %w", err)
 		}
-		partDevice := partitionDevicePath(destination, idx)
-		if err := unmountIfMounted(partDevice, logger); err != nil {
+		if err := unmountPartition(destination, idx, logger); err != nil {
 			return err
 		}
 	}
 	return nil
 }
-
-func unmountIfMounted(devicePath string, logger *slog.Logger) error {
-	mounts, err := os.ReadFile("/proc/mounts")
-	if err != nil {
-		return fmt.Errorf("failed to read /proc/mounts: %w", err)
-	}
-
-	resolvedTarget, _ := filepath.EvalSymlinks(devicePath)
-	var mountPoints []string
-
-	for _, line := range strings.Split(string(mounts), "\n") {
-		fields := strings.Fields(line)
-		if len(fields) < 2 {
-			continue
-		}
-		dev := fields[0]
-		mountPoint := fields[1]
-
-		resolvedDev, _ := filepath.EvalSymlinks(dev)
-		if dev == devicePath || resolvedDev == resolvedTarget {
-			mountPoints = append(mountPoints, mountPoint)
-			logger.Debug("Found mounted destination partition", "device", dev, "mount_point", mountPoint)
-		}
-	}
-
-	for _, mp := range mountPoints {
-		logger.Debug("Unmounting destination partition", "device", devicePath, "mount_point", mp)
-		if out, err := exec.Command("umount", mp).CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to unmount %s (%s): %v: %s", devicePath, mp, err, string(out))
-		}
-	}
-
-	return nil
-}
-
-func writeTezsignIDViaMount(id, destination string, appPartitionIndex int, logger *slog.Logger) error {
-	partDevice := partitionDevicePath(destination, appPartitionIndex)
-	if err := unmountIfMounted(partDevice, logger); err != nil {
-		return err
-	}
-
-	mountDir, mountCleanup, err := mountSpecificPartition(destination, appPartitionIndex, true)
-	if err != nil {
-		logger.Error("Failed to mount app partition for tezsign_id restore", "error", err, "destination", destination, "partition_index", appPartitionIndex, "device", partDevice)
-		return err
-	}
-	tmpDir := mountDir
-	cleanup := mountCleanup
-	defer cleanup()
-
-	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
-		for _, line := range strings.Split(string(mounts), "\n") {
-			if strings.Contains(line, tmpDir) {
-				logger.Debug("Mount entry for app partition", "entry", line)
-				break
-			}
-		}
-	}
-
-	idPath := fmt.Sprintf("%s/tezsign_id", tmpDir)
-	if err := os.WriteFile(idPath, []byte(id+"\n"), 0644); err != nil {
-		logger.Error("Failed to write tezsign_id to mounted app partition", "error", err, "path", idPath)
-		return err
-	}
-	if err := os.Chmod(idPath, 0400); err != nil {
-		logger.Debug("Failed to chmod tezsign_id", "error", err, "path", idPath)
-	}
-	if err := fsyncPath(idPath); err != nil {
-		logger.Debug("Failed to fsync tezsign_id", "error", err, "path", idPath)
-	}
-	if err := fsyncPath(tmpDir); err != nil {
-		logger.Debug("Failed to fsync app mount directory", "error", err, "path", tmpDir)
-	}
-
-	if out, err := exec.Command("ls", "-l", tmpDir).CombinedOutput(); err != nil {
-		logger.Debug("Failed to list app mount after writing tezsign_id", "error", err, "output", string(out))
-	} else {
-		logger.Debug("App mount contents after tezsign_id restore", "output", string(out))
-	}
-
-	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
-		logger.Debug("sync failed after tezsign_id restore", "error", err, "output", string(out))
-	}
-	if out, err := exec.Command("blockdev", "--flushbufs", partDevice).CombinedOutput(); err != nil {
-		logger.Debug("blockdev flush failed after tezsign_id restore", "error", err, "output", string(out))
-	}
-
-	if err := unmountIfMounted(partDevice, logger); err != nil {
-		return fmt.Errorf("failed to unmount app partition after tezsign_id write: %w", err)
-	}
-	// Avoid double umount/removal if cleanup runs later.
-	os.RemoveAll(tmpDir)
-	cleanup = func() {}
-
-	verifyDir, verifyCleanup, err := mountSpecificPartition(destination, appPartitionIndex, false)
-	if err != nil {
-		return fmt.Errorf("failed to remount app partition for tezsign_id verification: %w", err)
-	}
-	defer verifyCleanup()
-
-	if mounts, err := os.ReadFile("/proc/mounts"); err == nil {
-		for _, line := range strings.Split(string(mounts), "\n") {
-			if strings.Contains(line, verifyDir) {
-				logger.Debug("Mount entry for app partition (verify)", "entry", line)
-				break
-			}
-		}
-	}
-	if out, err := exec.Command("ls", "-l", verifyDir).CombinedOutput(); err == nil {
-		logger.Debug("App mount contents after remount verify", "output", string(out))
-	}
-
-	verifyPath := fmt.Sprintf("%s/tezsign_id", verifyDir)
-	data, err := os.ReadFile(verifyPath)
-	if err != nil {
-		return fmt.Errorf("failed to verify tezsign_id after remount: %w", err)
-	}
-	logger.Debug("Verified tezsign_id after remount", "value", strings.TrimSpace(string(data)))
-
-	logger.Debug("tezsign_id restored", "path", verifyPath)
-	return nil
-}
-
-func fsyncPath(path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return f.Sync()
-}
-
-func flushDevice(device string, logger *slog.Logger) error {
-	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
-		logger.Debug("sync failed during device flush", "error", err, "output", string(out))
-		return fmt.Errorf("sync failed during device flush: %w", err)
-	}
-
-	if out, err := exec.Command("blockdev", "--flushbufs", device).CombinedOutput(); err != nil {
-		logger.Debug("blockdev flush failed during device flush", "error", err, "output", string(out))
-		return fmt.Errorf("blockdev flush failed during device flush: %w", err)
-	}
-	return nil
-}
@@ -1,27 +1,37 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"slices"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/tez-capital/tezsign/bootslot"
 	"github.com/tez-capital/tezsign/logging"
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/tools/common"
 	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/release"
 )
 
 type UpdateKind string
 
 const (
 	UpdateKindFull UpdateKind = "full"
+	// UpdateKindApp writes only the app partition of a single-layout device,
+	// with the signer hash on its boot partition, and keeps its kernel and
+	// rootfs.
+	UpdateKindApp UpdateKind = "app"
 )
 
 func main() {
@@ -32,92 +42,350 @@ func main() {
 		printUsage()
 		return
 	}
+	opts, args, err := parseUpdateOptions(args)
+	if err != nil {
+		fail(logger, "Invalid arguments", err)
+	}
+	if opts.progress == progressJSON {
+		jsonProgress = newJSONProgressWriter(os.Stdout)
+	}
+	if opts.listReleases {
+		runListReleases(opts, logger)
+		return
+	}
+	if len(args) >= 1 && args[0] == "restore" {
+		if opts.dryRun {
+			fail(logger, "--dry-run plans updates; restore has no dry run", nil)
+		}
+		runRestore(args[1:], logger)
+		return
+	}
+	// find the host CLI before anything is written
+	var verifier *bootVerifier
+	if opts.verifyBoot && !opts.dryRun {
+		if verifier, err = newBootVerifier(opts); err != nil {
+			fail(logger, "Cannot verify the boot", err)
+		}
+	}
+	if len(args) >= 1 && args[0] == "flash" {
+		runFlash(args[1:], opts, verifier, logger)
+		return
+	}
+	if opts.expandData {
+		fail(logger, "--expand-data grows the data partition of a card being flashed; use it with flash", nil)
+	}
 
 	var source string
 	var sourceProvided bool
 	if len(args) >= 1 {
 		source = args[0]
 		sourceProvided = true
+		if opts.version != "" || opts.channel != "" {
+			fail(logger, "--version and --channel pick a release to download; they cannot be combined with a local image", nil)
+		}
+		checkLocalImage(source, logger)
 	}
 
 	// Keep the previous non-interactive flow when destination is provided explicitly.
 	if sourceProvided && len(args) >= 2 {
-		destination := args[1]
-		if len(args) >= 3 {
-			kind := UpdateKind(args[2])
-			if kind != UpdateKindFull {
-				logger.Error("Invalid update kind. Valid option is: full")
-				os.Exit(1)
+		destinations := args[1:]
+		// the update kind may follow the destinations
+		kind := UpdateKindFull
+		switch last := UpdateKind(destinations[len(destinations)-1]); last {
+		case UpdateKindFull, UpdateKindApp:
+			kind = last
+			destinations = destinations[:len(destinations)-1]
+		}
+		if len(destinations) == 0 {
+			fail(logger, "Missing destination", nil)
+		}
+		if len(destinations) > 1 {
+			sourcePath, cleanup, err := decompressSource(source, opts, logger)
+			if err != nil {
+				fail(logger, "Invalid source image", err)
+			}
+			batch := make([]*batchDevice, len(destinations))
+			for i, destination := range destinations {
+				batch[i] = &batchDevice{Path: destination, Source: sourcePath, Kind: kind}
+			}
+			err = runBatch(batch, opts)
+			cleanup()
+			if err != nil {
+				fail(logger, "Batch update failed", err)
 			}
+			finishProgress(nil)
+			return
 		}
+		destination := destinations[0]
 
-		if err := performUpdate(source, destination, UpdateKindFull, logger); err != nil {
-			logger.Error("Update failed", "error", err)
-			os.Exit(1)
+		check, err := performUpdate(source, destination, kind, opts, logger)
+		if err != nil {
+			fail(logger, "Update failed", err)
 		}
+		verifyUpdatedBoot(verifier, check, logger)
 
-		logger.Info("Update completed successfully")
+		if opts.dryRun {
+			logger.Info("Dry run finished; nothing was written")
+		} else {
+			logger.Info("Update completed successfully")
+		}
+		finishProgress(nil)
 		return
 	}
 
 	devices, err := discoverTezsignDevices(logger)
 	if err != nil {
-		logger.Error("Failed to discover TezSign devices", "error", err)
-		os.Exit(1)
+		fail(logger, "Failed to discover TezSign devices", err)
 	}
 
-	selectedDevice, err := runSelection(devices)
+	selected, err := runSelection(devices, true)
 	if err != nil {
-		logger.Error("Selection failed", "error", err)
-		os.Exit(1)
+		fail(logger, "Selection failed", err)
 	}
 
-	if !sourceProvided {
-		flavour, err := deviceFlavour(selectedDevice.Path)
+	sources := make([]string, len(selected))
+	kinds := make([]UpdateKind, len(selected))
+	if sourceProvided {
+		for i := range sources {
+			sources[i], kinds[i] = source, UpdateKindFull
+		}
+	} else {
+		releases, err := newReleaseDownloads(opts, logger)
 		if err != nil {
-			logger.Error("Failed to detect device flavor", "error", err)
-			os.Exit(1)
+			fail(logger, "Cannot download releases", err)
+		}
+		defer releases.cleanup()
+		for i, device := range selected {
+			if sources[i], kinds[i], err = releases.forDevice(device.Path); err != nil {
+				releases.cleanup()
+				fail(logger, "Failed to download image for "+device.Path, err)
+			}
+		}
+	}
+
+	if len(selected) > 1 {
+		batch := make([]*batchDevice, len(selected))
+		decompressed := map[string]string{}
+		var cleanups []func()
+		cleanup := func() {
+			for _, c := range cleanups {
+				c()
+			}
+		}
+		for i, device := range selected {
+			path, ok := decompressed[sources[i]]
+			if !ok {
+				var c func()
+				if path, c, err = decompressSource(sources[i], opts, logger); err != nil {
+					cleanup()
+					fail(logger, "Invalid source image", err)
+				}
+				cleanups = append(cleanups, c)
+				decompressed[sources[i]] = path
+			}
+			batch[i] = &batchDevice{Path: device.Path, Source: path, Kind: kinds[i]}
 		}
-		url := fmt.Sprintf("%s%s.img.xz", constants.LatestReleaseURL, flavour)
-		downloaded, cleanupFn, err := downloadWithProgress(url)
+		err = runBatch(batch, opts)
+		cleanup()
 		if err != nil {
-			logger.Error("Failed to download image", "error", err)
-			os.Exit(1)
+			fail(logger, "Batch update failed", err)
 		}
-		defer cleanupFn()
-		source = downloaded
+		finishProgress(nil)
+		return
 	}
+	selectedDevice := selected[0]
+	source, kind := sources[0], kinds[0]
 
 	if _, err := os.Stat(source); err != nil {
-		logger.Error("Invalid source image", "error", err)
-		os.Exit(1)
+		fail(logger, "Invalid source image", err)
+	}
+
+	if opts.dryRun {
+		fmt.Fprintf(messageOutput(), "Planning a %s update of %s (dry run)...\n\n", string(kind), selectedDevice.Path)
+	} else {
+		fmt.Fprintf(messageOutput(), "Updating %s with a %s update...\n\n", selectedDevice.Path, string(kind))
 	}
 
-	fmt.Printf("Updating %s with a %s update...\n\n", selectedDevice.Path, string(UpdateKindFull))
+	check, err := performUpdate(source, selectedDevice.Path, kind, opts, logger)
+	if err != nil {
+		fail(logger, "Update failed", err)
+	}
+	verifyUpdatedBoot(verifier, check, logger)
 
-	if err := performUpdate(source, selectedDevice.Path, UpdateKindFull, logger); err != nil {
-		logger.Error("Update failed", "error", err)
-		os.Exit(1)
+	if opts.dryRun {
+		fmt.Fprintln(messageOutput(), "\nDry run: nothing was written")
+	} else {
+		fmt.Fprintln(messageOutput(), "✅ Update completed successfully")
 	}
+	finishProgress(nil)
+}
+
+// releaseDownloads fetches and verifies the release image of each device's
+// flavour and layout once, however many devices share it.
+type releaseDownloads struct {
+	pub minisign.PublicKey
+	src *releaseSource
+	// channel is the one followed: the signed manifest of a download must
+	// name a channel it tracks, whose policy picks the update kind. tag is
+	// the release's tag, when known.
+	channel string
+	tag     string
+	// allowDowngrade takes releases older than a device's image.
+	allowDowngrade bool
+	// cache keeps downloads for later runs; nil with --no-cache.
+	cache    *artifactCache
+	logger   *slog.Logger
+	images   map[string]releaseImage
+	cleanups []func()
+}
 
-	fmt.Println("✅ Update completed successfully")
+// releaseImage is a verified download and the manifest it was checked
+// against.
+type releaseImage struct {
+	path     string
+	manifest *release.Manifest
 }
 
-func readSysfsValue(path string) string {
-	data, err := os.ReadFile(path)
+func newReleaseDownloads(opts updateOptions, logger *slog.Logger) (*releaseDownloads, error) {
+	pub, err := loadReleaseKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify release downloads: %w", err)
+	}
+	channel, err := updateChannel(opts)
 	if err != nil {
-		return ""
+		return nil, err
 	}
-	return string(data)
+	src, err := newReleaseSource(opts.mirrors, opts.proxy, opts.version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download settings: %w", err)
+	}
+	tag := opts.version
+	if tag == "" {
+		var releaseChannel string
+		tag, releaseChannel, err = src.useChannel(channel)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the %s release: %w", channel, err)
+		}
+		logger.Info("Downloading from the "+channel+" channel", "release", orUnknown(tag), "release_channel", releaseChannel)
+	}
+	cache, err := openArtifactCache(opts)
+	if err != nil {
+		logger.Warn("Artifact cache unavailable; downloads are not kept", "error", err)
+	}
+	return &releaseDownloads{
+		pub: pub, src: src, channel: channel, tag: tag, allowDowngrade: opts.allowDowngrade,
+		cache: cache, logger: logger, images: map[string]releaseImage{},
+	}, nil
 }
 
-func readBlockSizeBytes(name string) uint64 {
-	sizeContent := readSysfsValue(filepath.Join("/sys/block", name, "size"))
-	sectors, err := strconv.ParseUint(strings.TrimSpace(sizeContent), 10, 64)
+// forDevice returns the verified image for device and the update kind the
+// policy of the release's signed channel applies it with. A release older
+// than the device's image is refused unless downgrades are allowed.
+func (r *releaseDownloads) forDevice(device string) (string, UpdateKind, error) {
+	flavour, err := deviceFlavour(device)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to detect device flavor: %w", err)
+	}
+	slotted, _ := isSlottedDevice(device)
+	img, err := r.image(flavour, slotted)
+	if err != nil {
+		return "", "", err
+	}
+	if !r.allowDowngrade {
+		if err := checkDowngrade(img.manifest, deviceImageDate(device)); err != nil {
+			return "", "", err
+		}
+	}
+	channel := img.manifest.Channel
+	kind, err := channelPolicies[channel].updateKind(channel, "", slotted)
 	if err != nil {
-		return 0
+		return "", "", err
 	}
-	return sectors * 512 // sectors are 512-byte blocks
+	return img.path, kind, nil
+}
+
+// image returns the verified release image of flavour and layout, from an
+// earlier device, the cache or a download.
+func (r *releaseDownloads) image(flavour flavours.Flavour, slotted bool) (releaseImage, error) {
+	artifact := flavour.Artifact(slotted)
+	if img, ok := r.images[artifact]; ok {
+		return img, nil
+	}
+	if img, ok := r.cached(artifact, flavour, slotted); ok {
+		r.images[artifact] = img
+		return img, nil
+	}
+
+	downloaded, cleanup, err := downloadWithProgress(r.src, artifact)
+	if err != nil {
+		return releaseImage{}, fmt.Errorf("failed to download image: %w", err)
+	}
+	m, err := verifyRelease(r.pub, downloaded, flavour, slotted, r.want(), r.src.fetch)
+	if err != nil {
+		cleanup()
+		return releaseImage{}, fmt.Errorf("downloaded image failed verification; nothing was written: %w", err)
+	}
+	if r.cache == nil {
+		r.cleanups = append(r.cleanups, cleanup)
+	} else if cached, err := r.cache.addArtifact(downloaded, r.tag, r.logger); err != nil {
+		r.logger.Warn("Failed to cache the download", "error", err)
+		r.cleanups = append(r.cleanups, cleanup)
+	} else {
+		downloaded = cached
+	}
+	img := releaseImage{path: downloaded, manifest: m}
+	r.images[artifact] = img
+	return img, nil
+}
+
+// want is what the manifests of the downloads must say.
+func (r *releaseDownloads) want() wantRelease {
+	return wantRelease{version: r.tag, channel: r.channel}
+}
+
+// cached returns the artifact from the cache when it holds the file the
+// release's SHA256SUMS lists and it passes the checks of a download.
+func (r *releaseDownloads) cached(artifact string, flavour flavours.Flavour, slotted bool) (releaseImage, bool) {
+	if r.cache == nil {
+		return releaseImage{}, false
+	}
+	sum, err := releaseSum(r.pub, artifact, r.src.fetch)
+	if err != nil {
+		return releaseImage{}, false
+	}
+	path, ok := r.cache.artifact(sum)
+	if !ok {
+		return releaseImage{}, false
+	}
+	m, err := verifyRelease(r.pub, path, flavour, slotted, r.want(), r.src.fetch)
+	if err != nil {
+		r.logger.Warn("Cached download failed verification; downloading again", "artifact", artifact, "error", err)
+		r.cache.remove(sum)
+		return releaseImage{}, false
+	}
+	r.logger.Info("Using cached download", "artifact", artifact, "path", path)
+	return releaseImage{path: path, manifest: m}, true
+}
+
+func (r *releaseDownloads) cleanup() {
+	for _, c := range r.cleanups {
+		c()
+	}
+	r.cleanups = nil
+}
+
+// fail logs msg and exits. With --progress json the output ends with a
+// "failed" event.
+func fail(logger *slog.Logger, msg string, err error) {
+	if err != nil {
+		logger.Error(msg, "error", err)
+		err = fmt.Errorf("%s: %w", msg, err)
+	} else {
+		logger.Error(msg)
+		err = errors.New(msg)
+	}
+	finishProgress(err)
+	os.Exit(1)
 }
 
 func checkTezsignMarker(disk *disk.Disk) (bool, error) {
@@ -125,22 +393,34 @@ func checkTezsignMarker(disk *disk.Disk) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	// A/B devices have a second app partition.
+	wantPartitions := 3
+	appLabels := map[string]bool{constants.AppPartitionLabel: true}
+	if common.IsSlottedImage(disk) {
+		wantPartitions = 4
+		appLabels = map[string]bool{bootslot.AppLabel(bootslot.A): true, bootslot.AppLabel(bootslot.B): true}
+	}
+
 	nonZeroPartitions := 0
 	for _, p := range table.GetPartitions() {
 		if p != nil && p.GetSize() > 0 {
 			nonZeroPartitions++
 		}
 	}
-	if nonZeroPartitions != 3 {
+	if nonZeroPartitions != wantPartitions {
 		return false, nil
 	}
 	hasApp := false
 	hasData := false
-	for idx := range table.GetPartitions() {
+	for idx, p := range table.GetPartitions() {
+		if common.IsLUKSPartition(disk, p) {
+			hasData = true
+			continue
+		}
 		fs, err := disk.GetFilesystem(idx + 1)
 		if err == nil {
 			label := strings.TrimSpace(fs.Label())
-			if label == constants.AppPartitionLabel {
+			if appLabels[label] {
 				if _, err := fs.OpenFile("/tezsign", os.O_RDONLY); err == nil {
 					hasApp = true
 				}
@@ -154,7 +434,7 @@ func checkTezsignMarker(disk *disk.Disk) (bool, error) {
 }
 
 func probeTezsignDevice(path string) (bool, string) {
-	disk, _, _, _, err := loadImage(path, diskfs.ReadOnly)
+	disk, err := openDisk(path, diskfs.ReadOnly)
 	if err != nil {
 		return false, err.Error()
 	}
@@ -171,129 +451,322 @@ func probeTezsignDevice(path string) (bool, string) {
 	}
 }
 
-func discoverTezsignDevices(logger *slog.Logger) ([]deviceCandidate, error) {
-	paths, err := filepath.Glob("/sys/block/*/removable")
-	if err != nil {
-		return nil, fmt.Errorf("failed to list block devices: %w", err)
-	}
-
-	var devices []deviceCandidate
-	for _, removablePath := range paths {
-		flag, err := os.ReadFile(removablePath)
-		if err != nil || strings.TrimSpace(string(flag)) != "1" {
-			continue
-		}
-
-		name := filepath.Base(filepath.Dir(removablePath))
-		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
-			continue
-		}
-
-		devicePath := filepath.Join("/dev", name)
-		sizeBytes := readBlockSizeBytes(name)
-		model := strings.TrimSpace(readSysfsValue(filepath.Join("/sys/block", name, "device/model")))
-
-		isTezsign, status := probeTezsignDevice(devicePath)
-		if !isTezsign {
-			logger.Debug("Device did not validate as TezSign", "device", devicePath, "status", status)
-		}
-
-		devices = append(devices, deviceCandidate{
-			Name:      name,
-			Path:      devicePath,
-			SizeBytes: sizeBytes,
-			Model:     model,
-			Status:    status,
-			Valid:     isTezsign,
-		})
+// runSelection lets the user pick a device, or several with multi.
+func runSelection(devices []deviceCandidate, multi bool) ([]deviceCandidate, error) {
+	if jsonProgress != nil {
+		return nil, errors.New("picking a device needs the TUI; give a destination with --progress json")
 	}
-
-	if len(devices) == 0 {
-		return nil, errors.New("no removable block devices detected")
-	}
-
-	return devices, nil
-}
-
-func runSelection(devices []deviceCandidate) (deviceCandidate, error) {
-	program := tea.NewProgram(newSelectionModel(devices))
+	program := tea.NewProgram(newSelectionModel(devices, multi))
 	model, err := program.Run()
 	if err != nil {
-		return deviceCandidate{}, err
+		return nil, err
 	}
 
 	selection, ok := model.(selectionModel)
 	if !ok {
-		return deviceCandidate{}, errors.New("failed to read selection state")
+		return nil, errors.New("failed to read selection state")
 	}
 
 	if selection.err != nil {
-		return deviceCandidate{}, selection.err
+		return nil, selection.err
 	}
 
-	if selection.selectedDevice == nil {
-		return deviceCandidate{}, errors.New("no device selected")
+	if len(selection.selectedDevices) == 0 {
+		return nil, errors.New("no device selected")
 	}
 
-	return *selection.selectedDevice, nil
+	return selection.selectedDevices, nil
 }
 
-func downloadWithProgress(url string) (string, func(), error) {
-	resp, err := http.Get(url)
+// checkLocalImage verifies a local image that has a .minisig next to it and
+// warns about one that does not.
+func checkLocalImage(source string, logger *slog.Logger) {
+	pub, err := loadReleaseKey()
+	if errors.Is(err, ErrNoReleaseKey) {
+		logger.Warn("Local image signature not checked", "error", err)
+		return
+	}
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to download image: %w", err)
+		fail(logger, "Cannot verify local image", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("failed to download image: %s", resp.Status)
+	signed, err := verifyLocalImage(pub, source)
+	switch {
+	case err != nil:
+		fail(logger, "Local image failed verification; nothing was written", err)
+	case !signed:
+		logger.Warn("Local image is not signed", "missing", source+minisign.SignatureExt)
 	}
+}
 
-	tmpFile, err := os.CreateTemp("", "tezsign_download_*.img.xz")
-	if err != nil {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("failed to create temp file for download: %w", err)
+// parseUpdateOptions takes the flags out of args and returns the rest.
+func parseUpdateOptions(args []string) (updateOptions, []string, error) {
+	opts := updateOptions{jobs: 1}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--no-backup":
+			opts.noBackup = true
+		case arg == "--backup-dir":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--backup-dir needs a directory")
+			}
+			i++
+			opts.backupDir = args[i]
+		case strings.HasPrefix(arg, "--backup-dir="):
+			opts.backupDir = strings.TrimPrefix(arg, "--backup-dir=")
+		case arg == "--mirror":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--mirror needs a base URL")
+			}
+			i++
+			opts.mirrors = append(opts.mirrors, args[i])
+		case strings.HasPrefix(arg, "--mirror="):
+			opts.mirrors = append(opts.mirrors, strings.TrimPrefix(arg, "--mirror="))
+		case arg == "--proxy":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--proxy needs a URL")
+			}
+			i++
+			opts.proxy = args[i]
+		case strings.HasPrefix(arg, "--proxy="):
+			opts.proxy = strings.TrimPrefix(arg, "--proxy=")
+		case arg == "--version":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--version needs a release tag")
+			}
+			i++
+			opts.version = args[i]
+		case strings.HasPrefix(arg, "--version="):
+			opts.version = strings.TrimPrefix(arg, "--version=")
+		case arg == "--channel":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--channel needs stable, beta or nightly")
+			}
+			i++
+			channel, err := parseChannel(args[i])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.channel = channel
+		case strings.HasPrefix(arg, "--channel="):
+			channel, err := parseChannel(strings.TrimPrefix(arg, "--channel="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.channel = channel
+		case arg == "--list-releases":
+			opts.listReleases = true
+		case arg == "--allow-downgrade":
+			opts.allowDowngrade = true
+		case arg == "--yes":
+			opts.yes = true
+		case arg == "--dry-run":
+			opts.dryRun = true
+		case arg == "--no-cache":
+			opts.noCache = true
+		case arg == "--expand-data":
+			opts.expandData = true
+		case arg == "--verify-boot":
+			opts.verifyBoot = true
+		case arg == "--verify-timeout":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--verify-timeout needs a duration, e.g. 10m")
+			}
+			i++
+			d, err := parseVerifyTimeout(args[i])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.verifyTimeout = d
+		case strings.HasPrefix(arg, "--verify-timeout="):
+			d, err := parseVerifyTimeout(strings.TrimPrefix(arg, "--verify-timeout="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.verifyTimeout = d
+		case arg == "--host-cli":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--host-cli needs the path of the tezsign binary")
+			}
+			i++
+			opts.hostCLI = args[i]
+		case strings.HasPrefix(arg, "--host-cli="):
+			opts.hostCLI = strings.TrimPrefix(arg, "--host-cli=")
+		case arg == "--jobs":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--jobs needs a number of devices (0 for all)")
+			}
+			i++
+			n, err := parseJobs(args[i])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.jobs = n
+		case strings.HasPrefix(arg, "--jobs="):
+			n, err := parseJobs(strings.TrimPrefix(arg, "--jobs="))
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.jobs = n
+		case arg == "--progress":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("--progress needs a format (tui or json)")
+			}
+			i++
+			opts.progress = args[i]
+		case strings.HasPrefix(arg, "--progress="):
+			opts.progress = strings.TrimPrefix(arg, "--progress=")
+		case strings.HasPrefix(arg, "--"):
+			return opts, nil, fmt.Errorf("unknown option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if opts.version != "" && opts.channel != "" {
+		return opts, nil, errors.New("--version pins a release; it cannot be combined with --channel")
+	}
+	switch opts.progress {
+	case "":
+		opts.progress = progressTUI
+	case progressTUI, progressJSON:
+	default:
+		return opts, nil, fmt.Errorf("unknown progress format %q (want tui or json)", opts.progress)
 	}
+	return opts, rest, nil
+}
 
-	total := resp.ContentLength
-	cr := &countingReader{r: resp.Body}
-	cancel := func() {
-		resp.Body.Close()
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
+// verifyUpdatedBoot runs the --verify-boot check of an update that wrote
+// to the device.
+func verifyUpdatedBoot(verifier *bootVerifier, check *bootCheck, logger *slog.Logger) {
+	if verifier == nil {
+		return
+	}
+	if check == nil {
+		logger.Info("Nothing was written; skipping boot verification")
+		return
+	}
+	if err := verifier.verify(check); err != nil {
+		fail(logger, "Boot verification failed", err)
 	}
+}
 
-	title := fmt.Sprintf("Download %s → %s", filepath.Base(url), filepath.Base(tmpFile.Name()))
-	p := tea.NewProgram(newProgressModel(title, total, cr, cancel))
+// parseVerifyTimeout reads --verify-timeout.
+func parseVerifyTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("--verify-timeout needs a positive duration, e.g. 10m")
+	}
+	return d, nil
+}
 
-	go func() {
-		_, copyErr := io.Copy(tmpFile, cr)
-		tmpFile.Close()
-		resp.Body.Close()
-		p.Send(finishMsg{err: copyErr})
-	}()
+// runRestore handles "restore <backup> [destination]"; without a
+// destination the device is picked interactively.
+func runRestore(args []string, logger *slog.Logger) {
+	if len(args) < 1 || len(args) > 2 {
+		fail(logger, "Usage: restore <backup"+backupExt+"> [destination]", nil)
+	}
+	destination := ""
+	if len(args) == 2 {
+		destination = args[1]
+	} else {
+		devices, err := discoverTezsignDevices(logger)
+		if err != nil {
+			fail(logger, "Failed to discover TezSign devices", err)
+		}
+		selected, err := runSelection(devices, false)
+		if err != nil {
+			fail(logger, "Selection failed", err)
+		}
+		destination = selected[0].Path
+	}
 
-	model, progErr := p.Run()
-	if progErr != nil {
-		cancel()
-		return "", nil, fmt.Errorf("failed to render download progress: %w", progErr)
+	if err := performRestore(args[0], destination, logger); err != nil {
+		fail(logger, "Restore failed", err)
 	}
+	fmt.Fprintln(messageOutput(), "✅ Data partition restored")
+	finishProgress(nil)
+}
 
-	res, ok := model.(progressModel)
-	if !ok {
-		cancel()
-		return "", nil, errors.New("unexpected model type after download")
+// runFlash handles "flash <image> [destination]"; without a destination the
+// card is picked interactively among those without a TezSign layout, and a
+// given one must pass checkFlashDestination unless --yes is passed.
+func runFlash(args []string, opts updateOptions, verifier *bootVerifier, logger *slog.Logger) {
+	if len(args) < 1 || len(args) > 2 {
+		fail(logger, "Usage: flash <image> [destination]", nil)
+	}
+	if opts.version != "" || opts.channel != "" {
+		fail(logger, "flash writes a local image; --version and --channel cannot be combined with it", nil)
+	}
+	source := args[0]
+	checkLocalImage(source, logger)
+
+	destination := ""
+	if len(args) == 2 {
+		destination = args[1]
+		if !opts.yes {
+			if err := checkFlashDestination(destination); err != nil {
+				fail(logger, "Refusing to flash "+destination, err)
+			}
+		}
+	} else {
+		devices, err := discoverBlankCards(logger)
+		if err != nil {
+			fail(logger, "Failed to discover cards", err)
+		}
+		selected, err := runSelection(devices, false)
+		if err != nil {
+			fail(logger, "Selection failed", err)
+		}
+		destination = selected[0].Path
 	}
 
-	if res.err != nil {
-		cancel()
-		return "", nil, fmt.Errorf("failed to download image: %w", res.err)
+	if opts.dryRun {
+		fmt.Fprintf(messageOutput(), "Planning to flash %s (dry run)...\n\n", destination)
+	} else {
+		fmt.Fprintf(messageOutput(), "Flashing %s...\n\n", destination)
+	}
+	check, err := performFlash(source, destination, opts, logger)
+	if err != nil {
+		fail(logger, "Flash failed", err)
 	}
+	verifyUpdatedBoot(verifier, check, logger)
 
-	cleanup := func() {
-		os.Remove(tmpFile.Name())
+	if opts.dryRun {
+		fmt.Fprintln(messageOutput(), "\nDry run: nothing was written")
+	} else {
+		fmt.Fprintln(messageOutput(), "✅ Card flashed")
 	}
+	finishProgress(nil)
+}
 
-	return tmpFile.Name(), cleanup, nil
+// runListReleases prints the published releases for --version, those
+// --channel tracks when it is given, as JSON with --progress json.
+func runListReleases(opts updateOptions, logger *slog.Logger) {
+	src, err := newReleaseSource(opts.mirrors, opts.proxy, "")
+	if err != nil {
+		fail(logger, "Invalid download settings", err)
+	}
+	releases, err := src.listReleases()
+	if err != nil {
+		fail(logger, "Cannot list releases", err)
+	}
+	if opts.channel != "" {
+		releases = slices.DeleteFunc(releases, func(r releaseInfo) bool { return !channelTracks(opts.channel, r.Channel) })
+	}
+	if jsonProgress != nil {
+		if releases == nil {
+			releases = []releaseInfo{}
+		}
+		json.NewEncoder(os.Stdout).Encode(releases)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tCHANNEL\tPUBLISHED\tNAME")
+	for _, r := range releases {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Tag, r.Channel, r.PublishedAt, r.Name)
+	}
+	w.Flush()
 }
 
 func hasHelpFlag(args []string) bool {
@@ -311,13 +784,66 @@ func printUsage() {
 
 Usage:
   %[1]s
-      Interactive mode: pick a device and download the latest release automatically.
+      Interactive mode: pick a device, or mark several with space, and
+      download the latest release automatically.
   %[1]s <source>
       Interactive mode using a local image; destination is still selected interactively.
-  %[1]s <source> <destination> [full]
-      Non-interactive full update using a local image.
+  %[1]s <source> <destination>... [full|app]
+      Non-interactive update using a local image; several destinations
+      are updated as a batch. An app update writes only the app partition
+      (and the signer hash) of single-layout devices.
+  %[1]s restore <backup.img.xz> [destination]
+      Write a data partition backup back onto a device.
+  %[1]s flash <source> [destination]
+      Write an image, partition table included, onto a card without a
+      TezSign layout, such as a new one.
+  %[1]s --list-releases
+      List the published releases, for --version.
+
+Downloads are checked against their minisign signature, the signed
+release manifest and SHA256SUMS before anything is written. A local image
+is checked when a <source>.minisig is next to it. Written partitions are
+read back and compared with the source.
+
+Before an update writes anything, the data partition (keys and watermarks)
+is backed up to ~/tezsign-backups/data-<tezsign_id>-<time>.img.xz.
 
 Options:
-  -h, --help    Show this help message.
-`, bin)
+  --dry-run            Print the device, its partitions and every step the
+                       update would take, without writing to the device.
+  --jobs <n>           Update up to <n> devices of a batch at once (0: all;
+                       default 1, one after the other).
+  --verify-boot        After writing, wait for the device to boot the update
+                       and check its version and key store over USB, with
+                       the tezsign host CLI.
+  --verify-timeout <d> How long --verify-boot waits (default 10m).
+  --host-cli <path>    The tezsign binary --verify-boot runs, if it is not
+                       on PATH.
+  --expand-data        With flash, grow the data partition to fill the card.
+  --yes                With flash, write a <destination> that is not a
+                       removable disk, or that the running system is on.
+  --no-backup          Update without backing up the data partition.
+  --no-cache           Neither reuse nor keep downloaded and decompressed
+                       images.
+  --backup-dir <dir>   Write the backup into <dir>.
+  --channel <name>     Download the newest release of stable (default),
+                       beta or nightly. Nightly images are applied as app
+                       updates, except on A/B devices.
+  --version <tag>      Download the release <tag> instead of the latest.
+  --allow-downgrade    Install a release older than the device's image.
+  --mirror <url>       Download releases from this base URL instead of
+                       GitHub; repeat to try several in order.
+  --proxy <url>        Download through this HTTP(S) proxy.
+  --progress json      Report progress as JSON lines on stdout instead of
+                       the TUI; needs a <destination>.
+  -h, --help           Show this help message.
+
+Environment:
+  %[2]s    Release public key (base64 or .pub file) to use
+                            instead of the one built in.
+  %[3]s    Mirror base URLs, separated by commas.
+  %[4]s    Channel to use without --channel.
+  %[5]s         Directory of the image cache.
+  HTTPS_PROXY, NO_PROXY     Proxy settings, used without --proxy.
+`, bin, envReleasePublicKey, envReleaseMirror, envUpdateChannel, envCacheDir)
 }
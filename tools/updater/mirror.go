@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/tez-capital/tezsign/tools/constants"
+)
+
+// envReleaseMirror lists base URLs, separated by commas or spaces, to fetch
+// releases from instead of GitHub. --mirror overrides it.
+const envReleaseMirror = "TEZSIGN_RELEASE_MIRROR"
+
+// releaseSource is where release files come from: the mirrors in order, or
+// GitHub when there are none. Mirrors need not be trusted; every download is
+// checked against the release signatures.
+type releaseSource struct {
+	bases  []string
+	client *http.Client
+	// api lists the releases for --list-releases.
+	api string
+	// mirrored is set when bases are mirrors rather than GitHub.
+	mirrored bool
+}
+
+// newReleaseSource takes the mirrors from --mirror or TEZSIGN_RELEASE_MIRROR
+// and the proxy from --proxy; without --proxy the usual HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY apply. Without a version the files are those of
+// the latest release; with one, they come from GitHub's release of that tag
+// or each mirror's <version>/ directory. The directory only picks the
+// files: that they are of that release is checked against the version the
+// signed manifest records (see verifyRelease).
+func newReleaseSource(mirrors []string, proxy, version string) (*releaseSource, error) {
+	if version != "" && (strings.ContainsAny(version, "/\\?#% ") || version == "." || version == "..") {
+		return nil, fmt.Errorf("invalid release version %q", version)
+	}
+	if len(mirrors) == 0 {
+		mirrors = strings.FieldsFunc(os.Getenv(envReleaseMirror), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n' || r == '\t'
+		})
+	}
+	s := &releaseSource{client: http.DefaultClient, api: constants.ReleasesAPIURL}
+	for _, m := range mirrors {
+		base, err := parseBaseURL(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror %q: %w", m, err)
+		}
+		if version != "" {
+			base += version + "/"
+		}
+		s.bases = append(s.bases, base)
+	}
+	switch {
+	case len(s.bases) > 0:
+		s.mirrored = true
+	case version != "":
+		s.bases = []string{constants.ReleaseDownloadURL + version + "/"}
+	default:
+		s.bases = []string{constants.LatestReleaseURL}
+	}
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", proxy)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		s.client = &http.Client{Transport: transport}
+	}
+	return s, nil
+}
+
+// parseBaseURL checks an http(s) mirror URL and ends it with a slash, so
+// file names can be appended.
+func parseBaseURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("want an http:// or https:// URL")
+	}
+	if !strings.HasSuffix(s, "/") {
+		s += "/"
+	}
+	return s, nil
+}
+
+func (s *releaseSource) urls(name string) []string {
+	urls := make([]string, len(s.bases))
+	for i, base := range s.bases {
+		urls[i] = base + name
+	}
+	return urls
+}
+
+// fetch returns a small release file from the first mirror that has it.
+func (s *releaseSource) fetch(name string) ([]byte, error) {
+	var errs []error
+	for _, u := range s.urls(name) {
+		data, err := s.fetchURL(u, name)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *releaseSource) fetchURL(u, name string) ([]byte, error) {
+	resp, err := s.client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s from %s: %s", name, u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReleaseFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if len(data) > maxReleaseFileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxReleaseFileSize)
+	}
+	return data, nil
+}
+
+// releaseInfo is a release as the GitHub API lists it.
+type releaseInfo struct {
+	Tag         string `json:"tag_name"`
+	Name        string `json:"name"`
+	PublishedAt string `json:"published_at"`
+	Prerelease  bool   `json:"prerelease"`
+	Draft       bool   `json:"draft"`
+	// Channel is told from the tag; see tagChannel.
+	Channel string `json:"channel"`
+}
+
+// listReleases returns the published releases, newest first.
+func (s *releaseSource) listReleases() ([]releaseInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, s.api+"?per_page=100", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list releases: %s", resp.Status)
+	}
+	var all []releaseInfo
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16*maxReleaseFileSize)).Decode(&all); err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	releases := all[:0]
+	for _, r := range all {
+		if !r.Draft && r.Tag != "" {
+			r.Channel = tagChannel(r.Tag, r.Prerelease)
+			releases = append(releases, r)
+		}
+	}
+	return releases, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/tools/constants"
+	"github.com/tez-capital/tezsign/tools/flavours"
+)
+
+func TestReleaseSourceMirrors(t *testing.T) {
+	t.Setenv(envReleaseMirror, "")
+	src, err := newReleaseSource(nil, "", "")
+	if err != nil || !slices.Equal(src.bases, []string{constants.LatestReleaseURL}) {
+		t.Fatalf("default source = %v, %v", src, err)
+	}
+
+	t.Setenv(envReleaseMirror, "https://a.example/tezsign, http://b.example/r/")
+	src, err = newReleaseSource(nil, "", "")
+	if err != nil || !slices.Equal(src.urls("SHA256SUMS"), []string{"https://a.example/tezsign/SHA256SUMS", "http://b.example/r/SHA256SUMS"}) {
+		t.Fatalf("mirrors from the environment = %v, %v", src, err)
+	}
+	src, err = newReleaseSource([]string{"https://c.example"}, "", "")
+	if err != nil || !slices.Equal(src.bases, []string{"https://c.example/"}) {
+		t.Fatalf("--mirror = %v, %v", src, err)
+	}
+
+	for _, bad := range []string{"ftp://a.example/", "a.example/releases", "https://"} {
+		if _, err := newReleaseSource([]string{bad}, "", ""); err == nil {
+			t.Fatalf("mirror %q accepted", bad)
+		}
+	}
+	if _, err := newReleaseSource(nil, "::", ""); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+}
+
+func TestReleaseSourceVersion(t *testing.T) {
+	t.Setenv(envReleaseMirror, "")
+	src, err := newReleaseSource(nil, "", "release-202610010000")
+	if err != nil || !slices.Equal(src.urls("SHA256SUMS"), []string{constants.ReleaseDownloadURL + "release-202610010000/SHA256SUMS"}) {
+		t.Fatalf("pinned source = %v, %v", src, err)
+	}
+	src, err = newReleaseSource([]string{"https://a.example/tezsign/"}, "", "v1.2.3")
+	if err != nil || !slices.Equal(src.urls("SHA256SUMS"), []string{"https://a.example/tezsign/v1.2.3/SHA256SUMS"}) {
+		t.Fatalf("pinned mirror = %v, %v", src, err)
+	}
+	for _, bad := range []string{"..", "v1/../../x", "v1?x", "v 1"} {
+		if _, err := newReleaseSource(nil, "", bad); err == nil {
+			t.Fatalf("version %q accepted", bad)
+		}
+	}
+}
+
+// TestPinnedMirrorServesAnotherRelease has a mirror serve the signed files
+// of release-202610010000 as v1.2.3.
+func TestPinnedMirrorServesAnotherRelease(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	rpi4, _ := flavours.Lookup("rpi4")
+	path, files := testRelease(t, key)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/v1.2.3/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer mirror.Close()
+
+	src, err := newReleaseSource([]string{mirror.URL}, "", "v1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verifyRelease(minisign.NewPublicKey(key), path, rpi4, false, wantRelease{version: "v1.2.3", channel: channelNightly}, src.fetch)
+	if err == nil || !strings.Contains(err.Error(), `not "v1.2.3"`) {
+		t.Fatalf("err = %v, want a version mismatch", err)
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[
+			{"tag_name": "release-202610020000", "name": "Nightly", "published_at": "2026-10-02T00:00:00Z", "prerelease": true},
+			{"tag_name": "wip", "draft": true},
+			{"tag_name": "v1.2.3", "name": "1.2.3", "published_at": "2026-09-01T00:00:00Z"}
+		]`)
+	}))
+	defer api.Close()
+
+	src, err := newReleaseSource(nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.api = api.URL
+	releases, err := src.listReleases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []releaseInfo{
+		{Tag: "release-202610020000", Name: "Nightly", PublishedAt: "2026-10-02T00:00:00Z", Prerelease: true, Channel: channelNightly},
+		{Tag: "v1.2.3", Name: "1.2.3", PublishedAt: "2026-09-01T00:00:00Z", Channel: channelStable},
+	}
+	if !slices.Equal(releases, want) {
+		t.Fatalf("releases = %+v", releases)
+	}
+}
+
+func TestReleaseSourceFallsBackToNextMirror(t *testing.T) {
+	content := bytes.Repeat([]byte("tezsign"), 1000)
+	var missing atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		missing.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(&imageServer{content: content, etag: `"v1"`})
+	defer up.Close()
+
+	src, err := newReleaseSource([]string{down.URL, up.URL + "/"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := src.fetch("rpi4.img.xz"); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("fetch = %d bytes, %v", len(data), err)
+	}
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	path, cleanup, err := downloadWithProgress(src, "rpi4.img.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("download = %d bytes, %v", len(data), err)
+	}
+	if missing.Load() != 2 || !strings.Contains(out.String(), "trying "+up.URL) {
+		t.Fatalf("first mirror asked %d times; progress:\n%s", missing.Load(), out.String())
+	}
+
+	src.bases = src.bases[:1]
+	if _, err := src.fetch("rpi4.img.xz"); err == nil {
+		t.Fatal("file missing on every mirror fetched")
+	}
+}
+
+func TestReleaseSourceProxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	src, err := newReleaseSource([]string{"http://mirror.invalid/releases"}, proxy.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := src.fetch("SHA256SUMS"); err != nil || string(data) != "via proxy" {
+		t.Fatalf("fetch = %q, %v", data, err)
+	}
+	if got := proxied.Load(); got != "http://mirror.invalid/releases/SHA256SUMS" {
+		t.Fatalf("proxy asked for %v", got)
+	}
+}
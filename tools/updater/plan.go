@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// updatePlan is what --dry-run reports instead of updating: the device as
+// the update found it and, in order, every step it would take. Building it
+// opens the device and the source read-only; the checks an update makes
+// before writing still run, so a plan that prints is one that would start.
+type updatePlan struct {
+	Device  string `json:"device"`
+	Flavour string `json:"flavour,omitempty"`
+	// Layout is "single" or "a/b".
+	Layout        string             `json:"layout"`
+	Version       string             `json:"version,omitempty"`
+	SourceVersion string             `json:"source_version,omitempty"`
+	Partitions    []plannedPartition `json:"partitions"`
+	Steps         []string           `json:"steps"`
+}
+
+// plannedPartition is a destination partition, with the size of the source
+// partition written onto it; SourceSize is 0 for one the update leaves alone.
+type plannedPartition struct {
+	Name       string `json:"name"`
+	Index      int    `json:"index"`
+	Size       int64  `json:"size"`
+	SourceSize int64  `json:"source_size,omitempty"`
+}
+
+func newUpdatePlan(destination, layout string) *updatePlan {
+	p := &updatePlan{Device: destination, Layout: layout, Partitions: []plannedPartition{}, Steps: []string{}}
+	if f, err := deviceFlavour(destination); err == nil {
+		p.Flavour = f.Name
+	}
+	return p
+}
+
+func (p *updatePlan) step(format string, args ...any) {
+	p.Steps = append(p.Steps, fmt.Sprintf(format, args...))
+}
+
+// partition lists dst, and src as what is written onto it when set, and
+// returns dst's index in the partition table.
+func (p *updatePlan) partition(tbl partition.Table, name string, dst, src part.Partition) (int, error) {
+	idx, err := partitionIndex(tbl, dst)
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate the %s: %w", name, err)
+	}
+	planned := plannedPartition{Name: name, Index: idx, Size: dst.GetSize()}
+	if src != nil {
+		planned.SourceSize = src.GetSize()
+	}
+	p.Partitions = append(p.Partitions, planned)
+	return idx, nil
+}
+
+// writes adds a step for each listed partition the source is written onto.
+func (p *updatePlan) writes() {
+	for _, pp := range p.Partitions {
+		if pp.SourceSize > 0 {
+			p.step("write the blocks of the %s (partition %d, %s) that differ from the source", pp.Name, pp.Index, byteCountToHumanReadable(pp.SourceSize))
+		}
+	}
+}
+
+// unmounts lists each mount of the partitions that unmountDestinationPartitions
+// would undo.
+func (p *updatePlan) unmounts(destination string, tbl partition.Table, partitions ...part.Partition) error {
+	for _, dst := range partitions {
+		if dst == nil {
+			continue
+		}
+		idx, err := partitionIndex(tbl, dst)
+		if err != nil {
+			return fmt.Errorf("failed to locate partition for unmounting: %w", err)
+		}
+		mounts, err := partitionMounts(destination, idx)
+		if err != nil {
+			return err
+		}
+		for _, m := range mounts {
+			p.step("unmount partition %d from %s", idx, m)
+		}
+	}
+	return nil
+}
+
+// backup lists what backupBeforeUpdate would do.
+func (p *updatePlan) backup(destination string, tbl partition.Table, data part.Partition, tezsignID string, opts updateOptions) error {
+	if opts.noBackup {
+		p.step("skip the data partition backup (--no-backup)")
+		return nil
+	}
+	if err := p.unmounts(destination, tbl, data); err != nil {
+		return err
+	}
+	dir := opts.backupDir
+	if dir == "" {
+		dir = defaultBackupDir()
+	}
+	p.step("back up the data partition (%s) into %s", byteCountToHumanReadable(data.GetSize()), dir)
+	return nil
+}
+
+// verifyBoot lists the check --verify-boot makes after writing.
+func (p *updatePlan) verifyBoot(opts updateOptions) {
+	if opts.verifyBoot {
+		p.step("wait for the device to boot, then check its version and key store over USB")
+	}
+}
+
+func (p *updatePlan) upToDate() {
+	p.step("stop: the device already runs %s", p.Version)
+}
+
+// report prints the plan, or sends it as a "plan" event with --progress json.
+func (p *updatePlan) report() error {
+	if jsonProgress != nil {
+		jsonProgress.emit(progressEvent{Event: "plan", Plan: p})
+		return nil
+	}
+	return p.writeTo(messageOutput())
+}
+
+func (p *updatePlan) writeTo(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "device %s (flavour: %s, layout: %s)\n", p.Device, orUnknown(p.Flavour), p.Layout)
+	if p.Version != "" || p.SourceVersion != "" {
+		fmt.Fprintf(&b, "version %s → %s\n", orUnknown(p.Version), orUnknown(p.SourceVersion))
+	}
+	fmt.Fprintln(&b, "partitions:")
+	for _, pp := range p.Partitions {
+		source := "not written"
+		if pp.SourceSize > 0 {
+			source = "source " + byteCountToHumanReadable(pp.SourceSize)
+		}
+		fmt.Fprintf(&b, "  %d %-24s %10s (%s)\n", pp.Index, pp.Name, byteCountToHumanReadable(pp.Size), source)
+	}
+	fmt.Fprintln(&b, "steps:")
+	for i, s := range p.Steps {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, s)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
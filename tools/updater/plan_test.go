@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// tezsignImage builds a boot, app and data image whose app partition is an
+// ext4 filesystem holding files; the data partition is an empty ext4.
+func tezsignImage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		t.Skip("mkfs.ext4 not available")
+	}
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appImage, dataImage := filepath.Join(dir, "app.ext4"), filepath.Join(dir, "data.ext4")
+	for _, args := range [][]string{{"-L", "app", "-d", root, appImage, "16M"}, {"-L", "data", dataImage, "1M"}} {
+		if out, err := exec.Command("mkfs.ext4", append([]string{"-q", "-F"}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("mkfs.ext4: %v: %s", err, out)
+		}
+	}
+
+	const (
+		boot = 1 << 20
+		app  = 2 << 20
+		data = app + testPartSize
+	)
+	path := filepath.Join(dir, "disk.img")
+	d, err := diskfs.Create(path, data+1<<20, diskfs.SectorSizeDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Partition(&mbr.Table{Partitions: []*mbr.Partition{
+		{Type: mbr.Fat32LBA, Start: boot / 512, Size: (app - boot) / 512},
+		{Type: mbr.Linux, Start: app / 512, Size: testPartSize / 512},
+		{Type: mbr.Linux, Start: data / 512, Size: (1 << 20) / 512},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for image, offset := range map[string]int64{appImage: app, dataImage: data} {
+		fsData, err := os.ReadFile(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(fsData, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// withBootFiles formats the boot partition of an image from tezsignImage
+// as FAT32 holding files.
+func withBootFiles(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	d, err := diskfs.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		f, err := fs.OpenFile("/"+name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	source := tezsignImage(t, map[string]string{".image-flavour": "rpi4\n", ".image-version": "abc123\n"})
+	device := tezsignImage(t, map[string]string{".image-flavour": "rpi4\n", ".image-version": "0ld\n", "tezsign_id": "tz-0001\n"})
+	before, err := os.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	backups := filepath.Join(t.TempDir(), "backups")
+	opts := updateOptions{dryRun: true, backupDir: backups}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := performUpdate(source, device, UpdateKindFull, opts, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, err := os.ReadFile(device); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("dry run changed the device (%v)", err)
+	}
+	if _, err := os.Stat(backups); !os.IsNotExist(err) {
+		t.Fatalf("dry run made a backup: %v", err)
+	}
+
+	events := readEvents(t, &out)
+	if len(events) != 1 || events[0].Event != "plan" || events[0].Plan == nil {
+		t.Fatalf("events = %+v", events)
+	}
+	plan := events[0].Plan
+	if plan.Flavour != "rpi4" || plan.Layout != "single" || plan.Version != "0ld" || plan.SourceVersion != "abc123" {
+		t.Fatalf("plan = %+v", plan)
+	}
+	want := []plannedPartition{
+		{Name: "boot partition", Index: 1, Size: 1 << 20, SourceSize: 1 << 20},
+		{Name: "app partition", Index: 2, Size: testPartSize, SourceSize: testPartSize},
+		{Name: "data partition", Index: 3, Size: 1 << 20},
+	}
+	if !slices.Equal(plan.Partitions, want) {
+		t.Fatalf("partitions = %+v", plan.Partitions)
+	}
+	steps := strings.Join(plan.Steps, "\n")
+	for _, s := range []string{"back up the data partition (1.0 MiB) into " + backups, "boot partition (partition 1", "app partition (partition 2", "read the written partitions back", "tezsign_id tz-0001"} {
+		if !strings.Contains(steps, s) {
+			t.Fatalf("steps lack %q:\n%s", s, steps)
+		}
+	}
+
+	var text bytes.Buffer
+	plan.Steps = nil
+	plan.step("skip the data partition backup (--no-backup)")
+	if err := plan.writeTo(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"device " + device + " (flavour: rpi4, layout: single)", "version 0ld → abc123", "3 data partition", "not written", "1. skip the data partition backup"} {
+		if !strings.Contains(text.String(), s) {
+			t.Fatalf("plan lacks %q:\n%s", s, text.String())
+		}
+	}
+}
+
+func TestDryRunUpToDate(t *testing.T) {
+	files := map[string]string{".image-flavour": "rpi4\n", ".image-version": "abc123\n"}
+	source, device := tezsignImage(t, files), tezsignImage(t, files)
+
+	var out bytes.Buffer
+	jsonProgress = newJSONProgressWriter(&out)
+	defer func() { jsonProgress = nil }()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := performUpdate(source, device, UpdateKindFull, updateOptions{dryRun: true}, logger); err != nil {
+		t.Fatal(err)
+	}
+	events := readEvents(t, &out)
+	if len(events) != 1 || events[0].Plan == nil || !slices.Equal(events[0].Plan.Steps, []string{"stop: the device already runs abc123"}) {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestParseDryRunOption(t *testing.T) {
+	if opts, rest, err := parseUpdateOptions([]string{"rpi4.img.xz", "--dry-run", "/dev/sdb"}); err != nil || !opts.dryRun || len(rest) != 2 {
+		t.Fatalf("opts = %+v, rest = %q, err = %v", opts, rest, err)
+	}
+}
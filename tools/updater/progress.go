@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// progress formats for --progress
+const (
+	progressTUI  = "tui"
+	progressJSON = "json"
+)
+
+// jsonProgress, when set by --progress json, receives progress as JSON
+// lines instead of the bubbletea TUI, so other tools can wrap the updater.
+var jsonProgress *jsonProgressWriter
+
+// progressEvent is one line of --progress json output. A stage sends
+// "start", then "progress" (and "status" for retries), then "done" or
+// "error". The run ends with "finished", or "failed" and its error, without a
+// stage. A --dry-run sends a "plan" instead of writing. A batch update tags
+// the events of each device's update with its device and ends with a
+// "summary" of the results. --verify-boot adds a "boot" stage while it
+// waits for the device, then a "boot" event with the outcome.
+type progressEvent struct {
+	Event   string        `json:"event"`
+	Device  string        `json:"device,omitempty"`
+	Stage   string        `json:"stage,omitempty"`
+	Title   string        `json:"title,omitempty"`
+	Bytes   int64         `json:"bytes"`
+	Total   int64         `json:"total,omitempty"` // 0 while unknown
+	Percent float64       `json:"percent,omitempty"`
+	ETA     float64       `json:"eta_seconds,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Plan    *updatePlan   `json:"plan,omitempty"`
+	Results []batchResult `json:"results,omitempty"`
+	Boot    *bootResult   `json:"boot,omitempty"`
+}
+
+type jsonProgressWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	interval time.Duration
+}
+
+func newJSONProgressWriter(w io.Writer) *jsonProgressWriter {
+	return &jsonProgressWriter{w: w, interval: 500 * time.Millisecond}
+}
+
+func (j *jsonProgressWriter) emit(ev progressEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(append(line, '\n'))
+}
+
+// progressReporter passes what a running operation learns to its display.
+type progressReporter interface {
+	Status(msg string)
+	Total(n int64)
+}
+
+type tuiReporter struct{ p *tea.Program }
+
+func (r tuiReporter) Status(msg string) { r.p.Send(statusMsg(msg)) }
+func (r tuiReporter) Total(n int64)     { r.p.Send(totalMsg(n)) }
+
+// runProgress runs work and shows how far counter got towards total (-1
+// while unknown). cancel, if set, stops work when the user quits the TUI.
+// It returns once work has returned, with its error.
+func runProgress(stage, title string, total int64, counter progressCounter, cancel func(), work func(progressReporter) error) error {
+	if jsonProgress != nil {
+		return jsonProgress.run(stage, title, total, counter, work)
+	}
+
+	p := tea.NewProgram(newProgressModel(title, total, counter, cancel))
+	errCh := make(chan error, 1)
+	go func() {
+		err := work(tuiReporter{p})
+		p.Send(finishMsg{err: err})
+		errCh <- err
+	}()
+	if _, err := p.Run(); err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		<-errCh
+		return fmt.Errorf("failed to render %s progress: %w", stage, err)
+	}
+	return <-errCh
+}
+
+type jsonReporter struct {
+	j     *jsonProgressWriter
+	stage string
+	mu    sync.Mutex
+	total int64
+}
+
+func (r *jsonReporter) Status(msg string) {
+	r.j.emit(progressEvent{Event: "status", Stage: r.stage, Message: msg})
+}
+
+func (r *jsonReporter) Total(n int64) {
+	r.mu.Lock()
+	r.total = n
+	r.mu.Unlock()
+}
+
+func (r *jsonReporter) progress(counter progressCounter, started time.Time) progressEvent {
+	r.mu.Lock()
+	total := r.total
+	r.mu.Unlock()
+	ev := progressEvent{Event: "progress", Stage: r.stage, Bytes: countOf(counter)}
+	if total > 0 {
+		ev.Total = total
+		ev.Percent = float64(ev.Bytes) / float64(total) * 100
+		if elapsed := time.Since(started).Seconds(); ev.Bytes > 0 && elapsed > 0 {
+			ev.ETA = float64(total-ev.Bytes) / (float64(ev.Bytes) / elapsed)
+		}
+	}
+	return ev
+}
+
+func (j *jsonProgressWriter) run(stage, title string, total int64, counter progressCounter, work func(progressReporter) error) error {
+	r := &jsonReporter{j: j, stage: stage, total: total}
+	j.emit(progressEvent{Event: "start", Stage: stage, Title: title, Total: max(total, 0)})
+
+	started := time.Now()
+	errCh := make(chan error, 1)
+	go func() { errCh <- work(r) }()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if counter != nil {
+				j.emit(r.progress(counter, started))
+			}
+		case err := <-errCh:
+			if counter != nil {
+				j.emit(r.progress(counter, started))
+			}
+			if err != nil {
+				j.emit(progressEvent{Event: "error", Stage: stage, Bytes: countOf(counter), Message: err.Error()})
+			} else {
+				j.emit(progressEvent{Event: "done", Stage: stage, Bytes: countOf(counter)})
+			}
+			return err
+		}
+	}
+}
+
+// countOf is counter's count; a stage that moves no data has no counter.
+func countOf(counter progressCounter) int64 {
+	if counter == nil {
+		return 0
+	}
+	return counter.Count()
+}
+
+// finishProgress ends --progress json output with the outcome of the run.
+func finishProgress(err error) {
+	if jsonProgress == nil {
+		return
+	}
+	ev := progressEvent{Event: "finished"}
+	if err != nil {
+		ev = progressEvent{Event: "failed", Message: err.Error()}
+	}
+	jsonProgress.emit(ev)
+}
+
+// messageOutput is where the updater's own messages go: stdout, unless it
+// carries JSON progress.
+func messageOutput() io.Writer {
+	if jsonProgress != nil {
+		return os.Stderr
+	}
+	return os.Stdout
+}
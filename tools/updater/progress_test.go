@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func readEvents(t *testing.T, r io.Reader) []progressEvent {
+	t.Helper()
+	var events []progressEvent
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var ev progressEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestJSONProgress(t *testing.T) {
+	var out bytes.Buffer
+	j := newJSONProgressWriter(&out)
+	j.interval = time.Millisecond
+
+	counter := &countingWriter{w: io.Discard}
+	err := j.run("download", "Download rpi4.img.xz", -1, counter, func(r progressReporter) error {
+		r.Total(1000)
+		counter.Write(make([]byte, 400))
+		r.Status("retrying in 1s")
+		time.Sleep(5 * time.Millisecond)
+		counter.Write(make([]byte, 600))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := readEvents(t, &out)
+	if first := events[0]; first.Event != "start" || first.Stage != "download" || first.Title != "Download rpi4.img.xz" || first.Total != 0 {
+		t.Fatalf("first event = %+v", first)
+	}
+	if last := events[len(events)-1]; last.Event != "done" || last.Bytes != 1000 {
+		t.Fatalf("last event = %+v", last)
+	}
+	var status, final bool
+	for _, ev := range events {
+		switch {
+		case ev.Event == "status":
+			status = ev.Message == "retrying in 1s"
+		case ev.Event == "progress" && ev.Bytes == 1000:
+			final = ev.Total == 1000 && ev.Percent == 100 && ev.ETA == 0
+		case ev.Event == "progress" && ev.Bytes > 0 && ev.ETA <= 0:
+			t.Fatalf("progress without an ETA: %+v", ev)
+		}
+	}
+	if !status || !final {
+		t.Fatalf("missing status or final progress in %+v", events)
+	}
+
+	out.Reset()
+	if err := j.run("copy", "Copying app", 10, counter, func(progressReporter) error {
+		return errors.New("short write")
+	}); err == nil {
+		t.Fatal("error not returned")
+	}
+	if events := readEvents(t, &out); events[len(events)-1].Event != "error" || events[len(events)-1].Message != "short write" {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestParseProgressOption(t *testing.T) {
+	if opts, _, err := parseUpdateOptions(nil); err != nil || opts.progress != progressTUI {
+		t.Fatalf("default progress = %q, %v", opts.progress, err)
+	}
+	for _, args := range [][]string{{"--progress", "json"}, {"--progress=json"}} {
+		if opts, _, err := parseUpdateOptions(args); err != nil || opts.progress != progressJSON {
+			t.Fatalf("%q: progress = %q, %v", args, opts.progress, err)
+		}
+	}
+	if _, _, err := parseUpdateOptions([]string{"--progress", "xml"}); err == nil {
+		t.Fatal("unknown progress format parsed")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/tez-capital/tezsign/bootslot"
+	"github.com/tez-capital/tezsign/tools/common"
+	"github.com/tez-capital/tezsign/tools/flavours"
+)
+
+type slotLayout struct {
+	boot part.Partition
+	apps map[string]part.Partition
+	data part.Partition
+}
+
+func loadSlotLayout(d *disk.Disk) (*slotLayout, error) {
+	boot, appA, appB, data, err := common.GetTezsignSlotPartitions(d)
+	if err != nil {
+		return nil, err
+	}
+	return &slotLayout{
+		boot: boot,
+		apps: map[string]part.Partition{bootslot.A: appA, bootslot.B: appB},
+		data: data,
+	}, nil
+}
+
+func isSlottedDevice(devicePath string) (bool, error) {
+	d, err := openDisk(devicePath, diskfs.ReadOnly)
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+	return common.IsSlottedImage(d), nil
+}
+
+func readSlotState(d *disk.Disk, boot part.Partition) (bootslot.State, error) {
+	fs, err := filesystemForPartition(d, boot)
+	if err != nil {
+		return bootslot.State{}, err
+	}
+	defer fs.Close()
+
+	f, err := fs.OpenFile("/"+bootslot.StateFile, os.O_RDONLY)
+	if err != nil {
+		return bootslot.State{}, fmt.Errorf("failed to open %s on boot partition: %w", bootslot.StateFile, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return bootslot.State{}, err
+	}
+	return bootslot.ParseState(data)
+}
+
+// performSlotUpdate installs the source image into the inactive slot of an
+// A/B device and selects it for one trial boot. The device commits it once
+// the signer stays up (boot_slot commit); until then every later boot
+// falls back to the slot that was active before the update.
+func performSlotUpdate(sourcePath, destination string, opts updateOptions, logger *slog.Logger) (*bootCheck, error) {
+	if _, err := exec.LookPath("e2label"); err != nil {
+		return nil, fmt.Errorf("e2label binary not found: %w", err)
+	}
+
+	dstImg, err := openDisk(destination, destinationMode(opts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destination image: %w", err)
+	}
+	defer dstImg.Close()
+	dst, err := loadSlotLayout(dstImg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination slots: %w", err)
+	}
+
+	tbl, err := dstImg.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination partition table: %w", err)
+	}
+	var plan *updatePlan
+	if opts.dryRun {
+		plan = newUpdatePlan(destination, "a/b")
+		if err := plan.unmounts(destination, tbl, dst.boot, dst.apps[bootslot.A], dst.apps[bootslot.B]); err != nil {
+			return nil, err
+		}
+	} else if err := unmountDestinationPartitions(destination, tbl, logger, dst.boot, dst.apps[bootslot.A], dst.apps[bootslot.B]); err != nil {
+		return nil, err
+	}
+
+	sourceImg, err := openDisk(sourcePath, diskfs.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load source image: %w", err)
+	}
+	defer sourceImg.Close()
+	src, err := loadSlotLayout(sourceImg)
+	if err != nil {
+		if errors.Is(err, common.ErrNotSlottedImage) {
+			return nil, fmt.Errorf("destination has the A/B layout; use the %s image of its flavour as the source", flavours.SlottedSuffix)
+		}
+		return nil, fmt.Errorf("failed to read source slots: %w", err)
+	}
+
+	dstState, err := readSlotState(dstImg, dst.boot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destination slot state: %w", err)
+	}
+	srcState, err := readSlotState(sourceImg, src.boot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source slot state: %w", err)
+	}
+	if dstState.Trial != "" {
+		logger.Warn("Previous update was never committed; replacing it", "slot", dstState.Trial)
+	}
+
+	target := bootslot.Other(dstState.Active)
+	sourceApp := src.apps[srcState.Active]
+	targetApp := dst.apps[target]
+
+	sourceVersion := imageVersionForPartition(sourceImg, sourceApp, logger, "source")
+	destVersion := imageVersionForPartition(dstImg, dst.apps[dstState.Active], logger, "destination")
+	if plan != nil {
+		plan.Version, plan.SourceVersion = destVersion, sourceVersion
+	}
+	if sourceVersion != "" && destVersion == sourceVersion {
+		logger.Info("Image version already matches source; skipping update", "version", sourceVersion)
+		if plan != nil {
+			plan.upToDate()
+			return nil, plan.report()
+		}
+		return nil, nil
+	}
+	if err := checkDataEncryption(sourceImg, sourceApp, dstImg, dst.data); err != nil {
+		return nil, err
+	}
+	if sourceApp.GetSize() != targetApp.GetSize() {
+		return nil, errors.New("app partition size mismatch between source image and destination device, cannot proceed with update")
+	}
+
+	existingTezsignID := backupTezsignID(dstImg, dst.apps[dstState.Active], logger)
+	if plan != nil {
+		return nil, planSlotUpdate(plan, destination, tbl, opts, existingTezsignID, dst, dstState, target, sourceApp, srcState.Active)
+	}
+	if err := backupBeforeUpdate(dstImg, destination, tbl, dst.data, existingTezsignID, opts, logger); err != nil {
+		return nil, err
+	}
+	check := &bootCheck{
+		Device:    destination,
+		TezsignID: existingTezsignID,
+		Version:   sourceVersion,
+		Previous:  destVersion,
+		Store:     dataStoreState(dstImg, dst.data),
+	}
+
+	logger.Info("Updating inactive slot...", "slot", target, "active", dstState.Active)
+	written, err := copyPartitionData(sourceImg, sourceApp, dstImg, targetApp, "app partition (slot "+target+")", logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update app partition: %w", err)
+	}
+	if err := flushDevice(destination, logger); err != nil {
+		return nil, fmt.Errorf("failed to flush destination after app copy: %w", err)
+	}
+	if err := verifyWrittenPartitions(dstImg, []writtenPartition{written}, logger); err != nil {
+		return nil, err
+	}
+
+	targetIdx, err := partitionIndex(tbl, targetApp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate app partition index: %w", err)
+	}
+	// the source image's app partition carries the label of its own slot
+	if err := relabelAppPartition(destination, dstImg, targetApp, targetIdx, bootslot.AppLabel(target)); err != nil {
+		return nil, fmt.Errorf("failed to relabel app partition: %w", err)
+	}
+	if existingTezsignID != "" {
+		if err := restoreTezsignID(existingTezsignID, destination, dstImg, targetApp, logger); err != nil {
+			return nil, fmt.Errorf("failed to restore tezsign_id: %w", err)
+		}
+	}
+
+	if err := installSlotKernel(sourceImg, src.boot, srcState.Active, destination, tbl, dst.boot, target, dstState.Active, logger); err != nil {
+		return nil, err
+	}
+	if err := flushDevice(destination, logger); err != nil {
+		return nil, fmt.Errorf("failed to flush destination after slot switch: %w", err)
+	}
+
+	logger.Info("Slot installed; it is kept if tezsign stays up after the next boot, otherwise the device falls back", "slot", target, "fallback", dstState.Active)
+	return check, nil
+}
+
+// planSlotUpdate finishes the plan of installing sourceApp, the app
+// partition of the source's active slot, into the target slot.
+func planSlotUpdate(plan *updatePlan, destination string, tbl partition.Table, opts updateOptions, tezsignID string, dst *slotLayout, dstState bootslot.State, target string, sourceApp part.Partition, sourceSlot string) error {
+	if _, err := plan.partition(tbl, "boot partition", dst.boot, nil); err != nil {
+		return err
+	}
+	for _, slot := range []string{bootslot.A, bootslot.B} {
+		var src part.Partition
+		if slot == target {
+			src = sourceApp
+		}
+		name := "app partition (slot " + slot + ")"
+		if slot == dstState.Active {
+			name = "app partition (slot " + slot + ", active)"
+		}
+		if _, err := plan.partition(tbl, name, dst.apps[slot], src); err != nil {
+			return err
+		}
+	}
+	if _, err := plan.partition(tbl, "data partition", dst.data, nil); err != nil {
+		return err
+	}
+
+	if err := plan.backup(destination, tbl, dst.data, tezsignID, opts); err != nil {
+		return err
+	}
+	plan.writes()
+	plan.step("flush the device, then read the app partition back and compare it with the source")
+	targetIdx, err := partitionIndex(tbl, dst.apps[target])
+	if err != nil {
+		return fmt.Errorf("failed to locate app partition index: %w", err)
+	}
+	plan.step("label partition %d %s", targetIdx, bootslot.AppLabel(target))
+	if tezsignID != "" {
+		plan.step("write tezsign_id %s onto the app partition of slot %s", tezsignID, target)
+	}
+	plan.step("replace %s/ on the boot partition with the source's %s/", bootslot.Dir(target), bootslot.Dir(sourceSlot))
+	plan.step("select slot %s for one trial boot; until the signer commits it, later boots fall back to slot %s", target, dstState.Active)
+	plan.verifyBoot(opts)
+	return plan.report()
+}
+
+// installSlotKernel replaces the target slot's directory on the destination
+// boot partition with the source's active slot, then records the trial and
+// points the selector at it. The state and selector are written last, so an
+// interrupted update leaves the active slot booting.
+func installSlotKernel(sourceImg *disk.Disk, sourceBoot part.Partition, sourceSlot, destination string, tbl partition.Table, dstBoot part.Partition, target, active string, logger *slog.Logger) error {
+	srcFS, err := filesystemForPartition(sourceImg, sourceBoot)
+	if err != nil {
+		return fmt.Errorf("failed to open source boot filesystem: %w", err)
+	}
+	defer srcFS.Close()
+
+	bootIdx, err := partitionIndex(tbl, dstBoot)
+	if err != nil {
+		return fmt.Errorf("failed to locate boot partition index: %w", err)
+	}
+
+	mountDir, cleanup, err := mountSpecificPartition(destination, bootIdx, true)
+	if err != nil {
+		return fmt.Errorf("failed to mount destination boot partition: %w", err)
+	}
+	defer cleanup()
+
+	slotDir := filepath.Join(mountDir, bootslot.Dir(target))
+	if err := os.RemoveAll(slotDir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", bootslot.Dir(target), err)
+	}
+	logger.Info("Updating boot slot...", "slot", target)
+	if err := copyTreeFromFS(srcFS, "/"+bootslot.Dir(sourceSlot), slotDir); err != nil {
+		return fmt.Errorf("failed to copy kernel into %s: %w", bootslot.Dir(target), err)
+	}
+
+	// Raspberry Pi slots carry their own cmdline.txt naming the slot.
+	cmdlinePath := filepath.Join(slotDir, "cmdline.txt")
+	if data, err := os.ReadFile(cmdlinePath); err == nil {
+		if err := os.WriteFile(cmdlinePath, []byte(bootslot.SetCmdline(string(data), target)+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to write slot cmdline.txt: %w", err)
+		}
+	}
+	if out, err := exec.Command("sync").CombinedOutput(); err != nil {
+		logger.Debug("sync failed after slot copy", "error", err, "output", string(out))
+	}
+
+	if err := bootslot.WriteState(mountDir, bootslot.State{Active: active, Trial: target}); err != nil {
+		return fmt.Errorf("failed to write slot state: %w", err)
+	}
+	if err := bootslot.SetSelector(mountDir, target); err != nil {
+		return fmt.Errorf("failed to select slot %s: %w", target, err)
+	}
+	return nil
+}
+
+func copyTreeFromFS(fs filesystem.FileSystem, src, dst string) error {
+	entries, err := fs.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "." || name == ".." {
+			continue
+		}
+		srcPath := path.Join(src, name)
+		dstPath := filepath.Join(dst, name)
+		if entry.IsDir() {
+			if err := copyTreeFromFS(fs, srcPath, dstPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		in, err := fs.OpenFile(srcPath, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dstPath, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -29,14 +29,17 @@ type deviceCandidate struct {
 }
 
 type selectionModel struct {
-	stage          selectionStage
-	devices        []deviceCandidate
-	table          table.Model
-	selectedDevice *deviceCandidate
-	err            error
+	stage   selectionStage
+	devices []deviceCandidate
+	table   table.Model
+	// multi lets space mark several devices for a batch update.
+	multi           bool
+	marked          map[int]bool
+	selectedDevices []deviceCandidate
+	err             error
 }
 
-func newSelectionModel(devices []deviceCandidate) selectionModel {
+func newSelectionModel(devices []deviceCandidate, multi bool) selectionModel {
 	columns := []table.Column{
 		{Title: "Name", Width: 10},
 		{Title: "Size", Width: 12},
@@ -44,31 +47,48 @@ func newSelectionModel(devices []deviceCandidate) selectionModel {
 		{Title: "Model", Width: 18},
 		{Title: "Path", Width: 20},
 	}
-
-	rows := make([]table.Row, 0, len(devices))
-	for _, dev := range devices {
-		rows = append(rows, table.Row{
-			dev.Name,
-			byteCountToHumanReadable(int64(dev.SizeBytes)),
-			dev.Status,
-			dev.Model,
-			dev.Path,
-		})
+	if multi {
+		columns = append([]table.Column{{Title: "", Width: 3}}, columns...)
 	}
 
 	t := table.New(
 		table.WithColumns(columns),
-		table.WithRows(rows),
 		table.WithFocused(true),
 		table.WithHeight(10),
 	)
 	t.SetStyles(newTableStyles())
 
-	return selectionModel{
+	m := selectionModel{
 		stage:   stageDevice,
 		devices: devices,
 		table:   t,
+		multi:   multi,
+		marked:  map[int]bool{},
+	}
+	m.table.SetRows(m.rows())
+	return m
+}
+
+func (m selectionModel) rows() []table.Row {
+	rows := make([]table.Row, 0, len(m.devices))
+	for i, dev := range m.devices {
+		row := table.Row{
+			dev.Name,
+			byteCountToHumanReadable(int64(dev.SizeBytes)),
+			dev.Status,
+			dev.Model,
+			dev.Path,
+		}
+		if m.multi {
+			mark := "[ ]"
+			if m.marked[i] {
+				mark = "[x]"
+			}
+			row = append(table.Row{mark}, row...)
+		}
+		rows = append(rows, row)
 	}
+	return rows
 }
 
 func newTableStyles() table.Styles {
@@ -99,6 +119,12 @@ func (m selectionModel) View() string {
 		if len(m.devices) == 0 {
 			return "No TezSign SD cards detected. Press q to exit."
 		}
+		if m.multi {
+			return fmt.Sprintf(
+				"Select devices (↑/↓ to navigate, space to mark several, enter to start full update)\n\n%s\n\nPress enter to continue or q to cancel.",
+				m.table.View(),
+			)
+		}
 		return fmt.Sprintf(
 			"Select a device (↑/↓ to navigate, enter to start full update)\n\n%s\n\nPress enter to continue or q to cancel.",
 			m.table.View(),
@@ -124,21 +150,42 @@ func (m selectionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.table.MoveUp(1)
 			case "down", "j":
 				m.table.MoveDown(1)
+			case " ":
+				cursor := m.table.Cursor()
+				if !m.multi || cursor < 0 || cursor >= len(m.devices) || !m.devices[cursor].Valid {
+					return m, nil
+				}
+				// copy, so that models returned earlier keep their marks
+				marked := make(map[int]bool, len(m.marked)+1)
+				for i, ok := range m.marked {
+					marked[i] = ok
+				}
+				marked[cursor] = !marked[cursor]
+				m.marked = marked
+				m.table.SetRows(m.rows())
 			case "enter":
 				if len(m.devices) == 0 {
 					m.err = errors.New("no TezSign SD cards detected")
 					return m, tea.Quit
 				}
+				for i, dev := range m.devices {
+					if m.marked[i] {
+						m.selectedDevices = append(m.selectedDevices, dev)
+					}
+				}
+				if len(m.selectedDevices) > 0 {
+					return m, tea.Quit
+				}
 				cursor := m.table.Cursor()
 				if cursor < 0 || cursor >= len(m.devices) {
 					m.err = errors.New("invalid selection")
 					return m, tea.Quit
 				}
 				if !m.devices[cursor].Valid {
-					m.err = errors.New("selected device is not a valid TezSign SD card")
+					m.err = errors.New("selected device cannot be used: " + m.devices[cursor].Status)
 					return m, tea.Quit
 				}
-				m.selectedDevice = &m.devices[cursor]
+				m.selectedDevices = []deviceCandidate{m.devices[cursor]}
 				return m, tea.Quit
 			}
 		}
@@ -162,6 +209,7 @@ type progressModel struct {
 	total   int64
 	counter progressCounter
 	cancel  func()
+	status  string
 	err     error
 	done    bool
 }
@@ -172,6 +220,12 @@ type finishMsg struct {
 	err error
 }
 
+// statusMsg is shown under the progress bar, e.g. a retry after an error.
+type statusMsg string
+
+// totalMsg sets the total once it is known.
+type totalMsg int64
+
 func newProgressModel(title string, total int64, counter progressCounter, cancel func()) progressModel {
 	return progressModel{
 		title:   title,
@@ -198,6 +252,12 @@ func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		return m, tickCmd()
+	case statusMsg:
+		m.status = string(msg)
+		return m, nil
+	case totalMsg:
+		m.total = int64(msg)
+		return m, nil
 	case finishMsg:
 		m.done = true
 		m.err = msg.err
@@ -231,7 +291,7 @@ func renderProgressBar(pct float64, width int) string {
 }
 
 func (m progressModel) View() string {
-	read := m.counter.Count()
+	read := countOf(m.counter)
 	var pct float64 = -1
 	if m.total > 0 {
 		pct = float64(read) / float64(m.total) * 100
@@ -239,13 +299,20 @@ func (m progressModel) View() string {
 
 	var builder strings.Builder
 	builder.WriteString(fmt.Sprintf("%s\n\n", m.title))
-	if pct >= 0 {
+	switch {
+	case m.counter == nil:
+		// a wait, with nothing to count
+	case pct >= 0:
 		builder.WriteString(renderProgressBar(pct, 40))
 		builder.WriteString(fmt.Sprintf("  %s / %s\n", byteCountToHumanReadable(read), byteCountToHumanReadable(m.total)))
-	} else {
+	default:
 		builder.WriteString(fmt.Sprintf("%s read\n", byteCountToHumanReadable(read)))
 	}
 
+	if m.status != "" && !m.done {
+		builder.WriteString(fmt.Sprintf("\n%s\n", m.status))
+	}
+
 	if m.done {
 		if m.err != nil {
 			builder.WriteString(fmt.Sprintf("\nError: %v\n", m.err))
@@ -309,3 +376,75 @@ func byteCountToHumanReadable(b int64) string {
 	}
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
+
+// batchModel shows a row per device of a batch update. q stops starting
+// devices but lets the running updates finish, since a card abandoned
+// mid-write may not boot.
+type batchModel struct {
+	batch    *batchUpdate
+	stopping bool
+	done     bool
+}
+
+func newBatchModel(b *batchUpdate) batchModel {
+	return batchModel{batch: b}
+}
+
+func (m batchModel) Init() tea.Cmd {
+	return tickCmd()
+}
+
+func (m batchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tickMsg:
+		if m.done {
+			return m, nil
+		}
+		return m, tickCmd()
+	case finishMsg:
+		m.done = true
+		return m, tea.Quit
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			m.stopping = true
+			m.batch.stopped.Store(true)
+		}
+	}
+	return m, nil
+}
+
+func (m batchModel) View() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("Updating %d devices\n\n", len(m.batch.devices)))
+	for _, d := range m.batch.devices {
+		d.mu.Lock()
+		result, title, read, total, status, errMsg := d.result, d.title, d.bytes, d.total, d.status, d.err
+		d.mu.Unlock()
+
+		line := result
+		switch result {
+		case resultRunning:
+			if total > 0 {
+				line = fmt.Sprintf("%-32s %s", title, renderProgressBar(float64(read)/float64(total)*100, 30))
+			} else {
+				line = fmt.Sprintf("%-32s %s read", title, byteCountToHumanReadable(read))
+			}
+			if status != "" {
+				line += "  " + status
+			}
+		case resultFailed:
+			line = "failed: " + errMsg
+		}
+		builder.WriteString(fmt.Sprintf("  %-16s %s\n", d.Path, line))
+	}
+
+	switch {
+	case m.done:
+	case m.stopping:
+		builder.WriteString("\nStopping: no further devices are started; the running updates finish.")
+	default:
+		builder.WriteString("\nPress q to stop after the running updates.")
+	}
+	return builder.String()
+}
@@ -3,12 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
 
 	"github.com/diskfs/go-diskfs"
-	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/partition"
@@ -16,21 +12,24 @@ import (
 	"github.com/tez-capital/tezsign/tools/common"
 )
 
-func loadImage(path string, mode diskfs.OpenModeOption) (*disk.Disk, part.Partition, part.Partition, part.Partition, error) {
-	flags := os.O_RDONLY
-	if mode != diskfs.ReadOnly {
-		flags = os.O_RDWR
+func openDisk(path string, mode diskfs.OpenModeOption) (*disk.Disk, error) {
+	storage, err := openStorage(path, mode == diskfs.ReadOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device %s: %w", path, err)
 	}
 
-	f, err := os.OpenFile(path, flags, 0600)
+	disk, err := diskfs.OpenBackend(storage, diskfs.WithOpenMode(mode), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to open device %s: %w", path, err)
+		storage.Close()
+		return nil, errors.New("failed to open disk backend")
 	}
+	return disk, nil
+}
 
-	disk, err := diskfs.OpenBackend(file.New(f, false), diskfs.WithOpenMode(mode), diskfs.WithSectorSize(diskfs.SectorSizeDefault))
+func loadImage(path string, mode diskfs.OpenModeOption) (*disk.Disk, part.Partition, part.Partition, part.Partition, error) {
+	disk, err := openDisk(path, mode)
 	if err != nil {
-		f.Close()
-		return nil, nil, nil, nil, errors.New("failed to open disk backend")
+		return nil, nil, nil, nil, err
 	}
 
 	bootPartition, rootfsPartition, appPartition, _, err := common.GetTezsignPartitions(disk)
@@ -56,83 +55,6 @@ func filesystemForPartition(d *disk.Disk, p part.Partition) (filesystem.FileSyst
 	return d.GetFilesystem(idx)
 }
 
-func ensureMountAvailable() error {
-	if _, err := exec.LookPath("mount"); err != nil {
-		return fmt.Errorf("mount binary not found: %w", err)
-	}
-	if _, err := exec.LookPath("umount"); err != nil {
-		return fmt.Errorf("umount binary not found: %w", err)
-	}
-	if _, err := os.Stat("/dev/disk/by-label/app"); err != nil {
-		return fmt.Errorf("app partition label not found: %w", err)
-	}
-	return nil
-}
-
-func mountAppPartition(writable bool) (string, func(), error) {
-	if err := ensureMountAvailable(); err != nil {
-		return "", nil, err
-	}
-
-	appDev, err := filepath.EvalSymlinks("/dev/disk/by-label/app")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to resolve /dev/disk/by-label/app: %w", err)
-	}
-
-	tmpDir, err := os.MkdirTemp("", "tezsign_app_mount_")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
-	}
-
-	opts := "ro"
-	if writable {
-		opts = "rw,sync"
-	}
-	mountCmd := exec.Command("mount", "-o", opts, appDev, tmpDir)
-	if out, err := mountCmd.CombinedOutput(); err != nil {
-		os.RemoveAll(tmpDir)
-		return "", nil, fmt.Errorf("failed to mount app partition (%s): %v: %s", appDev, err, string(out))
-	}
-
-	cleanup := func() {
-		exec.Command("umount", tmpDir).Run()
-		os.RemoveAll(tmpDir)
-	}
-	return tmpDir, cleanup, nil
-}
-
-func mountSpecificPartition(devicePath string, partIndex int, writable bool) (string, func(), error) {
-	if err := ensureMountAvailable(); err != nil {
-		return "", nil, err
-	}
-
-	partDevice := partitionDevicePath(devicePath, partIndex)
-	if _, err := os.Stat(partDevice); err != nil {
-		return "", nil, fmt.Errorf("resolved partition device does not exist: %s: %w", partDevice, err)
-	}
-
-	tmpDir, err := os.MkdirTemp("", "tezsign_mount_")
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to create temp mount dir: %w", err)
-	}
-
-	opts := "ro"
-	if writable {
-		opts = "rw,sync"
-	}
-	mountCmd := exec.Command("mount", "-o", opts, partDevice, tmpDir)
-	if out, err := mountCmd.CombinedOutput(); err != nil {
-		os.RemoveAll(tmpDir)
-		return "", nil, fmt.Errorf("failed to mount partition (%s): %v: %s", partDevice, err, string(out))
-	}
-
-	cleanup := func() {
-		exec.Command("umount", tmpDir).Run()
-		os.RemoveAll(tmpDir)
-	}
-	return tmpDir, cleanup, nil
-}
-
 func partitionIndex(tbl partition.Table, target part.Partition) (int, error) {
 	parts := tbl.GetPartitions()
 	for idx, part := range parts {
@@ -145,12 +67,3 @@ func partitionIndex(tbl partition.Table, target part.Partition) (int, error) {
 	}
 	return 0, errors.New("partition not found for filesystem lookup")
 }
-
-func partitionDevicePath(device string, index int) string {
-	// mmcblk devices need a 'p' before the partition number; sd/loop don't.
-	sep := ""
-	if len(device) > 0 && device[len(device)-1] >= '0' && device[len(device)-1] <= '9' {
-		sep = "p"
-	}
-	return fmt.Sprintf("%s%s%d", device, sep, index)
-}
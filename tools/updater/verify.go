@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/release"
+)
+
+// releasePublicKey is the minisign public key releases are signed with.
+// Release builds set it with -ldflags "-X main.releasePublicKey=<base64>".
+var releasePublicKey string
+
+// envReleasePublicKey overrides the embedded key with a base64 key or a .pub
+// file, for builds without one and for images signed with another key.
+const envReleasePublicKey = "TEZSIGN_RELEASE_PUBKEY"
+
+// maxReleaseFileSize bounds the signatures and manifests the updater fetches.
+const maxReleaseFileSize = 1 << 20
+
+var ErrNoReleaseKey = errors.New("this updater has no release public key; set " + envReleasePublicKey + " or pass a local image")
+
+func loadReleaseKey() (minisign.PublicKey, error) {
+	keyText := releasePublicKey
+	if v := os.Getenv(envReleasePublicKey); v != "" {
+		keyText = v
+		if data, err := os.ReadFile(v); err == nil {
+			keyText = string(data)
+		}
+	}
+	if keyText == "" {
+		return minisign.PublicKey{}, ErrNoReleaseKey
+	}
+	return minisign.ParsePublicKey(keyText)
+}
+
+// fetchFunc returns a small file published with the latest release.
+type fetchFunc func(name string) ([]byte, error)
+
+// verifySignature checks r against sig and that the signature was made for
+// name, so a validly signed file of another name cannot stand in for it.
+// The trusted comment names no release: that the file belongs to the one
+// asked for is up to the manifest (see verifyRelease).
+func verifySignature(pub minisign.PublicKey, r io.Reader, sig []byte, name string) error {
+	comment, err := minisign.Verify(pub, r, sig)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if got := minisign.CommentField(comment, "file"); got != name {
+		return fmt.Errorf("%s: signature is for %q", name, got)
+	}
+	return nil
+}
+
+// wantRelease is what the signed manifest must say about the release a
+// download was asked for.
+type wantRelease struct {
+	// version is the release's tag; empty when it is not known.
+	version string
+	// channel is the channel followed, which must track the release's.
+	channel string
+}
+
+// verifyRelease checks a downloaded artifact before anything is written:
+// its own signature, the signed release manifest, and that the manifest
+// lists the artifact with the same size and hash for the device's flavour
+// and names the release asked for. The hash must also match the signed
+// SHA256SUMS published with the release. It returns the manifest.
+func verifyRelease(pub minisign.PublicKey, path string, flavour flavours.Flavour, slotted bool, want wantRelease, fetch fetchFunc) (*release.Manifest, error) {
+	artifact := flavour.Artifact(slotted)
+	sig, err := fetch(artifact + minisign.SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	err = verifySignature(pub, f, sig, artifact)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	manifestName := release.ManifestName(flavour.Release(slotted))
+	data, err := fetch(manifestName)
+	if err != nil {
+		return nil, err
+	}
+	sig, err = fetch(manifestName + minisign.SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(pub, bytes.NewReader(data), sig, manifestName); err != nil {
+		return nil, err
+	}
+	m, err := release.ParseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+	if m.Flavour != flavour.Name {
+		return nil, fmt.Errorf("%s is for %s, not %s", manifestName, m.Flavour, flavour.Name)
+	}
+	// signed files of another release, e.g. an older one, are as valid
+	if want.version != "" && m.Version != want.version {
+		return nil, fmt.Errorf("%s is of release %q, not %q", manifestName, m.Version, want.version)
+	}
+	// the policy of the signed channel applies, whatever directory or tag
+	// the files came from
+	if !channelTracks(want.channel, m.Channel) {
+		return nil, fmt.Errorf("%s is of the %q channel, which %s does not take", manifestName, m.Channel, want.channel)
+	}
+
+	listed, ok := m.Artifact(artifact)
+	if !ok {
+		return nil, fmt.Errorf("%s does not list %s", manifestName, artifact)
+	}
+	got, err := release.HashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if got.Size != listed.Size || got.SHA256 != listed.SHA256 {
+		return nil, fmt.Errorf("%s does not match %s", artifact, manifestName)
+	}
+
+	sum, err := releaseSum(pub, artifact, fetch)
+	if err != nil {
+		return nil, err
+	}
+	if sum != got.SHA256 {
+		return nil, fmt.Errorf("%s does not match %s", artifact, release.SumsName)
+	}
+	return m, nil
+}
+
+var ErrDowngrade = errors.New("the release is older than the installed image; pass --allow-downgrade to install it anyway")
+
+// checkDowngrade refuses m when its signed date is before installed, the
+// .image-date of the image a device runs. A device without a readable date
+// takes any release; a release without one is taken for older.
+func checkDowngrade(m *release.Manifest, installed string) error {
+	have, err := time.Parse(time.RFC3339, installed)
+	if err != nil {
+		return nil
+	}
+	date, err := time.Parse(time.RFC3339, m.Date)
+	if err != nil {
+		return fmt.Errorf("%s has no release date: %w", m.Release, ErrDowngrade)
+	}
+	if date.Before(have) {
+		return fmt.Errorf("%s is of %s, the installed image of %s: %w", m.Release, m.Date, installed, ErrDowngrade)
+	}
+	return nil
+}
+
+// releaseSum returns the SHA-256 the signed SHA256SUMS lists for artifact.
+func releaseSum(pub minisign.PublicKey, artifact string, fetch fetchFunc) (string, error) {
+	data, err := fetch(release.SumsName)
+	if err != nil {
+		return "", err
+	}
+	sig, err := fetch(release.SumsName + minisign.SignatureExt)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(pub, bytes.NewReader(data), sig, release.SumsName); err != nil {
+		return "", err
+	}
+	sums, err := release.ParseSums(data)
+	if err != nil {
+		return "", err
+	}
+	sum, ok := sums[artifact]
+	if !ok {
+		return "", fmt.Errorf("%s does not list %s", release.SumsName, artifact)
+	}
+	return sum, nil
+}
+
+// verifyLocalImage checks a local image against the .minisig next to it.
+// Unsigned local images are the user's own and are only warned about.
+func verifyLocalImage(pub minisign.PublicKey, path string) (signed bool, err error) {
+	sig, err := os.ReadFile(path + minisign.SignatureExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := minisign.Verify(pub, f, sig); err != nil {
+		return true, fmt.Errorf("%s: %w", path, err)
+	}
+	return true, nil
+}
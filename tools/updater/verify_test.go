@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tez-capital/tezsign/minisign"
+	"github.com/tez-capital/tezsign/tools/flavours"
+	"github.com/tez-capital/tezsign/tools/release"
+)
+
+// testRelease publishes a signed rpi4 release the way the builder does and
+// returns the downloaded .img.xz and the release files by name.
+func testRelease(t *testing.T, key ed25519.PrivateKey) (string, map[string][]byte) {
+	t.Helper()
+	image := []byte("compressed tezsign image")
+	path := filepath.Join(t.TempDir(), "tezsign_download_1.img.xz")
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(image)
+	m := &release.Manifest{
+		Release:   "rpi4",
+		Flavour:   "rpi4",
+		Version:   "release-202610010000",
+		Date:      "2026-10-01T00:00:00Z",
+		Channel:   "nightly",
+		Image:     release.File{Name: "rpi4.img", Size: 1 << 20, SHA256: strings.Repeat("0", 64)},
+		Artifacts: []release.File{{Name: "rpi4.img.xz", Size: int64(len(image)), SHA256: hex.EncodeToString(sum[:])}},
+	}
+	manifest, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums := release.FormatSums(map[string]string{
+		"rpi4.img.xz":        hex.EncodeToString(sum[:]),
+		"rpi4.manifest.json": strings.Repeat("1", 64),
+	})
+	files := map[string][]byte{"rpi4.manifest.json": manifest, release.SumsName: sums}
+	sign := func(name string, data []byte) {
+		sig, err := minisign.Sign(key, bytes.NewReader(data), "timestamp:1\tfile:"+name+"\thashed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name+minisign.SignatureExt] = sig
+	}
+	sign("rpi4.img.xz", image)
+	sign("rpi4.manifest.json", manifest)
+	sign(release.SumsName, sums)
+	return path, files
+}
+
+func fetchFrom(files map[string][]byte) fetchFunc {
+	return func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, errors.New(name + ": 404 Not Found")
+		}
+		return data, nil
+	}
+}
+
+func TestVerifyRelease(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	pub := minisign.NewPublicKey(key)
+	rpi4, _ := flavours.Lookup("rpi4")
+
+	path, files := testRelease(t, key)
+	m, err := verifyRelease(pub, path, rpi4, false, wantRelease{version: "release-202610010000", channel: channelNightly}, fetchFrom(files))
+	if err != nil {
+		t.Fatalf("verifyRelease: %v", err)
+	}
+	if m.Version != "release-202610010000" || m.Date != "2026-10-01T00:00:00Z" {
+		t.Fatalf("manifest = %+v", m)
+	}
+
+	if _, err := verifyRelease(pub, path, rpi4, true, wantRelease{channel: channelNightly}, fetchFrom(files)); err == nil {
+		t.Fatal("the slotted release has no signature but verified")
+	}
+
+	other := minisign.NewPublicKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{4}, ed25519.SeedSize)))
+	if _, err := verifyRelease(other, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); !errors.Is(err, minisign.ErrKeyMismatch) {
+		t.Fatalf("another key: err = %v, want ErrKeyMismatch", err)
+	}
+
+	if err := os.WriteFile(path, []byte("compressed tezsign imagE"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); !errors.Is(err, minisign.ErrBadSignature) {
+		t.Fatalf("changed image: err = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestVerifyReleaseRejectsSwappedFiles(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	pub := minisign.NewPublicKey(key)
+	rpi4, _ := flavours.Lookup("rpi4")
+
+	// A validly signed rpi5 image served as the rpi4 one.
+	path, files := testRelease(t, key)
+	image, _ := os.ReadFile(path)
+	sig, err := minisign.Sign(key, bytes.NewReader(image), "timestamp:1\tfile:rpi5.img.xz\thashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["rpi4.img.xz.minisig"] = sig
+	if _, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); err == nil || !strings.Contains(err.Error(), `signature is for "rpi5.img.xz"`) {
+		t.Fatalf("err = %v, want a file mismatch", err)
+	}
+
+	// A signed manifest whose hash does not match the download.
+	path, files = testRelease(t, key)
+	m, err := release.ParseManifest(files["rpi4.manifest.json"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Artifacts[0].SHA256 = strings.Repeat("f", 64)
+	manifest, _ := m.Marshal()
+	sig, err = minisign.Sign(key, bytes.NewReader(manifest), "timestamp:1\tfile:rpi4.manifest.json\thashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["rpi4.manifest.json"], files["rpi4.manifest.json.minisig"] = manifest, sig
+	if _, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); err == nil || !strings.Contains(err.Error(), "does not match rpi4.manifest.json") {
+		t.Fatalf("err = %v, want a manifest mismatch", err)
+	}
+
+	// A signed SHA256SUMS that disagrees with the manifest.
+	path, files = testRelease(t, key)
+	sums := release.FormatSums(map[string]string{"rpi4.img.xz": strings.Repeat("e", 64)})
+	sig, err = minisign.Sign(key, bytes.NewReader(sums), "timestamp:1\tfile:SHA256SUMS\thashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[release.SumsName], files[release.SumsName+minisign.SignatureExt] = sums, sig
+	if _, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); err == nil || !strings.Contains(err.Error(), "does not match SHA256SUMS") {
+		t.Fatalf("err = %v, want a SHA256SUMS mismatch", err)
+	}
+}
+
+// TestVerifyReleaseChecksVersion serves the validly signed files of one
+// release for another.
+func TestVerifyReleaseChecksVersion(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	pub := minisign.NewPublicKey(key)
+	rpi4, _ := flavours.Lookup("rpi4")
+
+	path, files := testRelease(t, key)
+	_, err := verifyRelease(pub, path, rpi4, false, wantRelease{version: "release-202611010000", channel: channelNightly}, fetchFrom(files))
+	if err == nil || !strings.Contains(err.Error(), `release "release-202610010000", not "release-202611010000"`) {
+		t.Fatalf("err = %v, want a version mismatch", err)
+	}
+}
+
+// TestVerifyReleaseChecksChannel serves a signed nightly release where a
+// stable or beta one was asked for, as a mirror's stable directory can.
+func TestVerifyReleaseChecksChannel(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	pub := minisign.NewPublicKey(key)
+	rpi4, _ := flavours.Lookup("rpi4")
+
+	path, files := testRelease(t, key)
+	for _, channel := range []string{channelStable, channelBeta} {
+		_, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channel}, fetchFrom(files))
+		if err == nil || !strings.Contains(err.Error(), `of the "nightly" channel`) {
+			t.Fatalf("%s: err = %v, want a channel mismatch", channel, err)
+		}
+	}
+
+	// a manifest without a channel has no policy to apply
+	m, err := release.ParseManifest(files["rpi4.manifest.json"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Channel = ""
+	manifest, _ := m.Marshal()
+	sig, err := minisign.Sign(key, bytes.NewReader(manifest), "timestamp:1\tfile:rpi4.manifest.json\thashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["rpi4.manifest.json"], files["rpi4.manifest.json.minisig"] = manifest, sig
+	if _, err := verifyRelease(pub, path, rpi4, false, wantRelease{channel: channelNightly}, fetchFrom(files)); err == nil {
+		t.Fatal("a manifest without a channel verified")
+	}
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	m := &release.Manifest{Release: "rpi4", Date: "2026-10-01T00:00:00Z"}
+	for _, tc := range []struct {
+		installed string
+		ok        bool
+	}{
+		{"2026-09-01T00:00:00Z", true},
+		{"2026-10-01T00:00:00Z", true},
+		{"2026-10-01T00:00:01Z", false},
+		// nothing to compare with
+		{"", true},
+		{"unknown", true},
+	} {
+		err := checkDowngrade(m, tc.installed)
+		if tc.ok != (err == nil) || (err != nil && !errors.Is(err, ErrDowngrade)) {
+			t.Errorf("installed %q: err = %v", tc.installed, err)
+		}
+	}
+	// an undated release may be anything
+	if err := checkDowngrade(&release.Manifest{Release: "rpi4"}, "2026-09-01T00:00:00Z"); !errors.Is(err, ErrDowngrade) {
+		t.Errorf("undated release: err = %v, want ErrDowngrade", err)
+	}
+}
+
+func TestLoadReleaseKey(t *testing.T) {
+	pub := minisign.NewPublicKey(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize)))
+
+	t.Setenv(envReleasePublicKey, "")
+	if _, err := loadReleaseKey(); !errors.Is(err, ErrNoReleaseKey) {
+		t.Fatalf("err = %v, want ErrNoReleaseKey", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "release.pub")
+	if err := os.WriteFile(file, pub.File(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{pub.String(), file} {
+		t.Setenv(envReleasePublicKey, v)
+		got, err := loadReleaseKey()
+		if err != nil || got.String() != pub.String() {
+			t.Fatalf("%s: key = %s, %v", v, got, err)
+		}
+	}
+}